@@ -60,6 +60,9 @@ with st.sidebar:
     st.markdown("### Scan Options")
     use_deep = st.checkbox("Deep Discovery (Amass)", help="Enables passive Amass enumeration. Slower but finds more subdomains + ASN info.")
     use_fingerprint = st.checkbox("Aggressive Fingerprinting (WhatWeb)", help="Enables WhatWeb scan on live hosts. Accurately detects versions.")
+    proxy_url = st.text_input("Proxy URL", "", help="Route probing, fingerprinting and Nuclei through a proxy, e.g. http://127.0.0.1:8080 for Burp.")
+    custom_header = st.text_input("Identification Header", "", help="Sent on all probing traffic and Nuclei, e.g. 'X-Bug-Bounty: your-handle'.")
+    rate_limit = st.number_input("Rate Limit (req/s)", min_value=0, value=0, help="Requests-per-second ceiling for httpx, native HTTP calls and Nuclei. 0 = unlimited.")
     
     st.markdown("---")
     
@@ -131,6 +134,12 @@ with tab1:
                 cmd.append("-deep")
             if use_fingerprint:
                 cmd.append("-fingerprint")
+            if rate_limit:
+                cmd.extend(["-rate-limit", str(rate_limit)])
+            if proxy_url:
+                cmd.extend(["-proxy", proxy_url])
+            if custom_header:
+                cmd.extend(["-header", custom_header])
             cmd.append(target_domain)
 
             process = subprocess.Popen(
@@ -341,7 +350,7 @@ with tab2:
             else:
                 with st.spinner(f"Running Nuclei on {len(selected_rows)} targets..."):
                     targets = selected_rows['subdomain'].tolist()
-                    output = triage_logic.run_nuclei(targets)
+                    output = triage_logic.run_nuclei(targets, rate_limit=rate_limit, proxy=proxy_url, headers=[custom_header] if custom_header else [])
                 
                 # Check for error string
                 if isinstance(output, str) and output.startswith("❌"):
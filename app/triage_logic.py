@@ -19,11 +19,11 @@ def filter_by_tech(df, tech_name):
     )
     return df[mask]
 
-def run_nuclei(selected_subdomains):
+def run_nuclei(selected_subdomains, rate_limit=0, proxy="", headers=()):
     """
     Runs Nuclei on a list of selected subdomains.
     1. Writes targets to a temp file.
-    2. Runs nuclei -l targets.txt
+    2. Runs nuclei -l targets.txt (with -rl / -proxy / -H when set)
     3. Returns the output.
     """
     if not selected_subdomains:
@@ -39,6 +39,12 @@ def run_nuclei(selected_subdomains):
         # construct command
         # Include tags in JSON output
         cmd = ["nuclei", "-l", tmp_path, "-silent", "-json", "-include-tags"]
+        if rate_limit:
+            cmd.extend(["-rl", str(rate_limit)])
+        if proxy:
+            cmd.extend(["-proxy", proxy])
+        for h in headers:
+            cmd.extend(["-H", h])
         
         # Check if nuclei is installed
         if subprocess.call(["which", "nuclei"], stdout=subprocess.DEVNULL) != 0:
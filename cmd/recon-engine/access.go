@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Access control classes, as in access_control
+const (
+	accessNone         = "none"
+	accessBasicAuth    = "basic-auth"
+	accessNTLM         = "ntlm"
+	accessClientCert   = "client-cert"
+	accessIPRestricted = "ip-restricted"
+	accessWAFBlocked   = "waf-blocked"
+	accessSSORedirect  = "sso-redirect"
+	accessUnknown      = "unknown"
+)
+
+// accessClasses are the values access_control takes
+var accessClasses = []string{accessNone, accessBasicAuth, accessNTLM, accessClientCert, accessIPRestricted, accessWAFBlocked, accessSSORedirect, accessUnknown}
+
+// accessDeniedStatuses are the answers -access-control looks into; other
+// statuses, redirects aside, count as no access control
+var accessDeniedStatuses = map[int]bool{400: true, 401: true, 403: true, 407: true, 495: true, 496: true}
+
+// idpHosts are the identity providers SSO redirects go to, matched on the
+// host and its parent domains
+var idpHosts = []string{
+	"login.microsoftonline.com", "login.windows.net", "login.live.com", "b2clogin.com",
+	"accounts.google.com", "okta.com", "oktapreview.com", "okta-emea.com", "auth0.com",
+	"onelogin.com", "pingidentity.com", "pingone.com", "duosecurity.com",
+	"amazoncognito.com", "jumpcloud.com", "cloudflareaccess.com",
+}
+
+// idpPaths are the paths of self-hosted identity providers: ADFS, Keycloak,
+// generic OAuth and SAML endpoints
+var idpPaths = []string{"/adfs/ls", "/protocol/openid-connect/auth", "/oauth2/authorize", "/oauth2/v1/authorize", "/saml2/", "/idp/sso", "/cas/login"}
+
+// clientCertPage matches what servers answer a request without the client
+// certificate they require with, nginx's 400 page among them
+var clientCertPage = regexp.MustCompile(`(?i)no required ssl certificate was sent|ssl certificate error|client certificate (?:is )?required`)
+
+// ipRestrictedPage matches block pages naming the visitor's address or
+// network as the reason
+var ipRestrictedPage = regexp.MustCompile(`(?i)your ip(?: address)?|client ip|remote address|not (?:on|in) the (?:allow|white) ?list|ip (?:address )?(?:is )?(?:not allowed|blocked|denied|restricted)|access (?:is )?restricted to|from your (?:location|network|country)`)
+
+// classifyAccess sets res.AccessControl to what keeps an anonymous visitor
+// from the page, with the evidence, fetching the page of denied answers and
+// checking whether the TLS server asks for a client certificate
+func classifyAccess(ctx context.Context, res *Result) {
+	class, evidence := accessFor(ctx, res)
+	res.AccessControl, res.AccessEvidence = class, evidence
+	stats.Add("access."+class, 1)
+}
+
+func accessFor(ctx context.Context, res *Result) (string, string) {
+	if res.Blocked {
+		return accessWAFBlocked, "block page of " + res.BlockVendor
+	}
+	if idp, how := ssoRedirect(res); idp != "" {
+		res.IdPHost = idp
+		return accessSSORedirect, "redirects to " + idp + " (" + how + ")"
+	}
+	if !accessDeniedStatuses[res.StatusCode] {
+		return accessNone, ""
+	}
+	if res.URL != "" && (res.headers == nil || res.body == nil) {
+		fetchBlockPage(ctx, res)
+	}
+	defer func() { res.body = nil }()
+
+	if auth := res.headers["www_authenticate"]; auth != "" {
+		scheme := strings.ToLower(auth)
+		switch {
+		case strings.HasPrefix(scheme, "basic") || strings.HasPrefix(scheme, "digest"):
+			return accessBasicAuth, "www-authenticate: " + auth
+		case strings.Contains(scheme, "ntlm") || strings.Contains(scheme, "negotiate"):
+			return accessNTLM, "www-authenticate: " + auth
+		}
+	}
+	if m := clientCertPage.Find(res.body); m != nil {
+		return accessClientCert, fmt.Sprintf("body mentions %q", m)
+	}
+	if requested, err := clientCertRequested(ctx, res.URL); requested {
+		evidence := "TLS server requests a client certificate"
+		if err != nil {
+			evidence += "; refused without one: " + err.Error()
+		}
+		return accessClientCert, evidence
+	}
+	if res.StatusCode == 403 {
+		if m := ipRestrictedPage.FindString(res.Title); m != "" {
+			return accessIPRestricted, fmt.Sprintf("title mentions %q", m)
+		}
+		if m := ipRestrictedPage.Find(res.body); m != nil {
+			return accessIPRestricted, fmt.Sprintf("body mentions %q", m)
+		}
+	}
+	if auth := res.headers["www_authenticate"]; auth != "" {
+		return accessUnknown, "www-authenticate: " + auth
+	}
+	return accessUnknown, fmt.Sprintf("status %d", res.StatusCode)
+}
+
+// ssoRedirect returns the identity provider the host's redirects lead to,
+// and what gave it away: its host, or a SAML or OpenID Connect request
+func ssoRedirect(res *Result) (string, string) {
+	hops := res.RedirectChain
+	if len(hops) == 0 && res.headers["location"] != "" {
+		if u, err := url.Parse(res.URL); err == nil {
+			if next, err := u.Parse(res.headers["location"]); err == nil {
+				hops = []string{next.String()}
+			}
+		}
+	}
+	for _, hop := range hops {
+		u, err := url.Parse(hop)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		for _, idp := range idpHosts {
+			if host == idp || strings.HasSuffix(host, "."+idp) {
+				return host, idp
+			}
+		}
+		q := u.Query()
+		switch {
+		case q.Has("SAMLRequest"):
+			return host, "SAMLRequest"
+		case q.Has("client_id") && q.Has("response_type"):
+			return host, "OpenID Connect request"
+		}
+		path := strings.ToLower(u.Path)
+		for _, p := range idpPaths {
+			if strings.Contains(path, p) {
+				return host, "path " + p
+			}
+		}
+	}
+	return "", ""
+}
+
+// clientCertRequested reports whether the TLS server of an https rawURL
+// asks for a client certificate, with the error a request without one
+// ended in. Replayed responses carry no handshake, so -replay never
+// checks.
+func clientCertRequested(ctx context.Context, rawURL string) (bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || replayDir != "" {
+		return false, nil
+	}
+	if nativeLimiter.Wait(ctx) != nil {
+		return false, nil
+	}
+	var requested atomic.Bool
+	t := newInsecureTransport(nextProxy())
+	t.DisableKeepAlives = true
+	t.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		requested.Store(true)
+		return &tls.Certificate{}, nil
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: t,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.Scheme+"://"+u.Host+"/", nil)
+	if err != nil {
+		return false, nil
+	}
+	applyCustomHeaders(req)
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		err = uerr.Err
+	}
+	return requested.Load(), err
+}
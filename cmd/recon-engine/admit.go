@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// admitWorkers bounds the discovered names resolved at once to be judged
+// by the -scope address rules and -exclude-ips before they are probed
+const admitWorkers = 32
+
+// admitter judges the discovered names that must be resolved before they
+// may be probed, on a bounded pool of workers beside the goroutine feeding
+// the probe queue: a slow resolver holds up one worker, not every name
+// behind it. Names that need no lookup are passed on at once.
+type admitter struct {
+	ctx     context.Context
+	pass    func(name string)
+	exclude func(excludedName)
+	sem     chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newAdmitter returns an admitter handing the names it admits to pass and
+// those -exclude-ips keeps out, or whose addresses could not be checked, to
+// exclude. Both must be safe to call from several goroutines.
+func newAdmitter(ctx context.Context, pass func(string), exclude func(excludedName)) *admitter {
+	return &admitter{ctx: ctx, pass: pass, exclude: exclude, sem: make(chan struct{}, admitWorkers)}
+}
+
+// admitNeedsLookup reports whether names must be resolved to be judged
+func admitNeedsLookup() bool {
+	return len(ipExclusions) > 0 || scope != nil && scope.ipRules
+}
+
+// Submit judges name, passing it on when it may be probed. It blocks while
+// every worker is busy.
+func (a *admitter) Submit(name string) {
+	if !admitNeedsLookup() {
+		a.pass(name)
+		return
+	}
+	a.sem <- struct{}{}
+	a.wg.Add(1)
+	go func() {
+		defer func() {
+			<-a.sem
+			a.wg.Done()
+		}()
+		a.judge(name)
+	}()
+}
+
+// judge resolves name once for both the -scope address rules and
+// -exclude-ips. A lookup that fails, as opposed to a name that does not
+// exist, keeps the name out unless -probe-unresolved: the addresses the
+// prober resolves could be excluded ones.
+func (a *admitter) judge(name string) {
+	addrs, err := admitLookup(a.ctx, name)
+	if a.ctx.Err() != nil {
+		return
+	}
+	if lookupFailed(err) && !probeUnresolved {
+		stats.Add("admit.unresolved", 1)
+		a.exclude(excludedName{name: name, reason: fmt.Sprintf("lookup failed (%v), so its addresses could not be checked; see -probe-unresolved", err)})
+		return
+	}
+	if !scopeAllowsAddrs(addrs) {
+		return
+	}
+	if reason, ip := excludeAddrs(name, addrs); reason != "" {
+		a.exclude(excludedName{name, ip, reason})
+		return
+	}
+	a.pass(name)
+}
+
+// Wait returns once every name submitted so far has been judged
+func (a *admitter) Wait() {
+	a.wg.Wait()
+}
+
+// admitLookup resolves name, A and AAAA, reusing zone transfer records
+// when there are any
+func admitLookup(ctx context.Context, name string) ([]string, error) {
+	if rec, ok := lookupDNSRecord(name); ok && len(rec.addrs()) > 0 {
+		return rec.addrs(), nil
+	}
+	return dnsResolver.LookupHost(ctx, name)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// amassMajor returns the installed amass major version, 0 when it cannot be
+// determined. v3 writes JSON with -json; v4 dropped it and prints graph
+// relations as text.
+func amassMajor() int {
+	v := toolVersion("amass")
+	major, _ := strconv.Atoi(strings.SplitN(v, ".", 2)[0])
+	return major
+}
+
+// amassArgs builds the enum command line shared by both versions
+func amassArgs(domain string) []string {
+	args := []string{"enum", "-d", domain}
+	if amassActive {
+		args = append(args, "-active")
+	} else {
+		args = append(args, "-passive")
+	}
+	if amassConfig != "" {
+		args = append(args, "-config", amassConfig)
+	}
+	if amassTimeout > 0 {
+		args = append(args, "-timeout", strconv.Itoa(amassTimeout))
+	}
+	if r := dnsResolver.toolArg(); r != "" {
+		args = append(args, "-r", r)
+	}
+	return args
+}
+
+func runAmass(ctx context.Context, domain string, out chan<- string) error {
+	if amassMajor() >= 4 {
+		return runAmassV4(ctx, domain, out)
+	}
+	return runAmassV3(ctx, domain, out)
+}
+
+// runAmassV3 has amass write JSON to a temporary file and tails it while
+// amass runs
+func runAmassV3(ctx context.Context, domain string, out chan<- string) error {
+	f, err := os.CreateTemp("", "amass-*.json")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	defer os.Remove(path)
+	defer f.Close()
+
+	cmd := toolCommand(ctx, toolPath("amass"), append(amassArgs(domain), "-json", path)...)
+	if err := startTool(cmd); err != nil {
+		return fmt.Errorf("start error: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- waitTool(cmd) }()
+
+	r := bufio.NewReader(f)
+	var partial []byte
+	readLines := func() {
+		for {
+			line, err := r.ReadBytes('\n')
+			partial = append(partial, line...)
+			if err != nil {
+				return
+			}
+			keepAmassJSON(domain, partial, out)
+			partial = partial[:0]
+		}
+	}
+	for {
+		select {
+		case err := <-done:
+			readLines()
+			if len(partial) > 0 {
+				keepAmassJSON(domain, partial, out)
+			}
+			return err
+		case <-time.After(500 * time.Millisecond):
+			readLines()
+		}
+	}
+}
+
+// keepAmassJSON handles a v3 JSON line, keeping it for -keep-raw
+func keepAmassJSON(domain string, line []byte, out chan<- string) {
+	ref := keepRaw("amass", domain, "", line)
+	noteAmassRawRef(handleAmassJSON(line, out), ref)
+}
+
+// handleAmassJSON sends the name of a v3 JSON line to out and records its
+// infrastructure, returning the name, or "" for a rejected line
+func handleAmassJSON(line []byte, out chan<- string) string {
+	var ar AmassResult
+	line, fixed := validUTF8(line)
+	if err := json.Unmarshal(line, &ar); err != nil {
+		rejectedLine("amass", "discovery", eventParseFailed, err)
+		return ""
+	}
+	if repaired, err := sanitizeAmass(&ar); err != nil {
+		rejectedLine("amass", "discovery", eventInvalid, err)
+		return ""
+	} else if repaired || fixed {
+		repairedLine("amass")
+	}
+	out <- ar.Name
+	// Capture Infra info
+	if len(ar.Addresses) > 0 {
+		infraMutex.Lock()
+		infraMap[ar.Name] = Infrastructure{
+			Asn: ar.Addresses[0].Asn,
+			Org: ar.Addresses[0].Desc,
+		}
+		infraMutex.Unlock()
+	}
+	return ar.Name
+}
+
+// amassRelation matches a v4 output line such as
+// "www.example.com (FQDN) --> a_record --> 93.184.216.34 (IPAddress)"
+var amassRelation = regexp.MustCompile(`^(\S+) \((\w+)\) --> (\w+) --> (.+) \((\w+)\)$`)
+
+// amassGraph collects the v4 relations needed to tie a name to its ASN:
+// FQDN -a_record-> IP <-contains- Netblock <-announces- ASN -managed_by-> Org
+type amassGraph struct {
+	nameIP   map[string]string
+	ipBlock  map[string]string
+	blockASN map[string]int
+	asnOrg   map[int]string
+	pending  map[string]bool // names whose infrastructure is not known yet
+}
+
+func runAmassV4(ctx context.Context, domain string, out chan<- string) error {
+	cmd := toolCommand(ctx, toolPath("amass"), amassArgs(domain)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("pipe error: %w", err)
+	}
+	if err := startTool(cmd); err != nil {
+		return fmt.Errorf("start error: %w", err)
+	}
+	newAmassGraph().read(stdout, domain, out)
+	return waitTool(cmd)
+}
+
+func newAmassGraph() *amassGraph {
+	return &amassGraph{
+		nameIP:   make(map[string]string),
+		ipBlock:  make(map[string]string),
+		blockASN: make(map[string]int),
+		asnOrg:   make(map[int]string),
+		pending:  make(map[string]bool),
+	}
+}
+
+func (g *amassGraph) read(r io.Reader, domain string, out chan<- string) {
+	seen := make(map[string]bool)
+	var ref string
+	emit := func(name string) {
+		if name, ok := amassName(name, domain); ok && !seen[name] {
+			seen[name] = true
+			out <- name
+			noteAmassRawRef(name, ref)
+		}
+	}
+
+	scanner := newLineReader(r, "amass")
+	for scanner.Next() {
+		ref = keepRaw("amass", domain, "", scanner.Bytes())
+		g.handle(scanner.Text(), emit)
+	}
+}
+
+// amassName cleans a name of a v4 relation, reporting whether it is domain
+// or one of its subdomains
+func amassName(name, domain string) (string, bool) {
+	name, err := cleanName(name)
+	if err != nil {
+		rejectedLine("amass", "discovery", eventInvalid, err)
+		return "", false
+	}
+	return name, name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// handle reads a v4 output line, calling emit with the names it mentions
+func (g *amassGraph) handle(line string, emit func(string)) {
+	m := amassRelation.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return
+	}
+	from, fromType, rel, to, toType := m[1], m[2], m[3], m[4], m[5]
+	if fromType == "FQDN" {
+		emit(from)
+	}
+	if toType == "FQDN" {
+		emit(to)
+	}
+	switch {
+	case fromType == "FQDN" && (rel == "a_record" || rel == "aaaa_record"):
+		name := strings.ToLower(from)
+		if _, ok := g.nameIP[name]; !ok {
+			g.nameIP[name] = to
+			g.pending[name] = true
+		}
+	case fromType == "Netblock" && rel == "contains":
+		g.ipBlock[to] = from
+	case fromType == "ASN" && rel == "announces":
+		if asn, err := strconv.Atoi(from); err == nil {
+			g.blockASN[to] = asn
+		}
+	case fromType == "ASN" && rel == "managed_by":
+		if asn, err := strconv.Atoi(from); err == nil {
+			g.asnOrg[asn] = to
+		}
+	default:
+		return
+	}
+	g.resolvePending()
+}
+
+// resolvePending records infrastructure for names whose relation chain is
+// now complete
+func (g *amassGraph) resolvePending() {
+	for name := range g.pending {
+		block, ok := g.ipBlock[g.nameIP[name]]
+		if !ok {
+			continue
+		}
+		asn, ok := g.blockASN[block]
+		if !ok {
+			continue
+		}
+		org, ok := g.asnOrg[asn]
+		if !ok {
+			continue
+		}
+		infraMutex.Lock()
+		infraMap[name] = Infrastructure{Asn: asn, Org: org}
+		infraMutex.Unlock()
+		delete(g.pending, name)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiProviders are the external APIs whose requests are counted, by the
+// names the -config budgets, the circuit breakers and the summary use
+var apiProviders = []string{
+	"censys", "chaos", "circl", "crtsh", "dnsdb", "github", "nvd", "rdap",
+	"ripestat", "securitytrails", "virustotal", "whoisxml",
+}
+
+// -budget-reset policies: when -monitor starts the budgets afresh
+const (
+	budgetResetIteration = "iteration"
+	budgetResetDay       = "day"
+)
+
+// errBudgetExhausted is returned in place of a request past the provider's
+// budget
+var errBudgetExhausted = errors.New("request budget exhausted")
+
+// apiUsage is what a provider was sent, for the run summary
+type apiUsage struct {
+	Requests int `json:"requests"`
+	Budget   int `json:"budget,omitempty"`
+	// Skipped counts the requests not made once the budget was spent
+	Skipped   int  `json:"skipped,omitempty"`
+	Exhausted bool `json:"exhausted,omitempty"`
+}
+
+var (
+	// configBudgets are the -config file's budgets, requests per run or,
+	// with -monitor, per -budget-reset period
+	configBudgets map[string]int
+
+	apiUsagesMu sync.Mutex
+	apiUsages   = make(map[string]*apiUsage)
+	// apiBudgetDay is the day the counts are for, with -budget-reset day
+	apiBudgetDay string
+)
+
+// configureAPIBudgets checks the -config budgets and -budget-reset. Called
+// once after flag parsing.
+func configureAPIBudgets() error {
+	if budgetReset != budgetResetIteration && budgetReset != budgetResetDay {
+		return fmt.Errorf("-budget-reset must be %s or %s, got %q", budgetResetIteration, budgetResetDay, budgetReset)
+	}
+	for name, n := range configBudgets {
+		if !contains(apiProviders, name) {
+			return fmt.Errorf("budgets: unknown provider %q (want %s)", name, strings.Join(apiProviders, ", "))
+		}
+		if n < 1 {
+			return fmt.Errorf("budgets: %s must be at least 1, got %d", name, n)
+		}
+	}
+	return nil
+}
+
+// apiRequest counts a request about to be sent to provider. Once the
+// provider's budget is spent it warns once, opens the provider's circuit
+// breaker so its sources and enrichers are skipped, and refuses the
+// requests that follow with errBudgetExhausted. Replayed requests cost
+// nothing and are not counted.
+func apiRequest(provider string) error {
+	if replayDir != "" {
+		return nil
+	}
+	apiUsagesMu.Lock()
+	defer apiUsagesMu.Unlock()
+	if monitor && budgetReset == budgetResetDay {
+		if day := time.Now().Format(time.DateOnly); day != apiBudgetDay {
+			if apiBudgetDay != "" {
+				rollAPIBudgetsLocked()
+			}
+			apiBudgetDay = day
+		}
+	}
+	u := apiUsages[provider]
+	if u == nil {
+		u = &apiUsage{Budget: configBudgets[provider]}
+		apiUsages[provider] = u
+	}
+	if u.Exhausted {
+		u.Skipped++
+		stats.Add("api."+provider+".skipped", 1)
+		return fmt.Errorf("%s: %w", provider, errBudgetExhausted)
+	}
+	u.Requests++
+	stats.Add("api."+provider+".requests", 1)
+	if u.Budget > 0 && u.Requests >= u.Budget {
+		u.Exhausted = true
+		breakerExhaust(provider)
+		fmt.Fprintf(os.Stderr, "Warning: %s request budget (%d) spent, skipping %s %s\n", provider, u.Budget, provider, apiBudgetUntil())
+	}
+	return nil
+}
+
+// apiBudgetUntil says when a spent budget starts afresh
+func apiBudgetUntil() string {
+	switch {
+	case !monitor:
+		return "for the rest of the run"
+	case budgetReset == budgetResetDay:
+		return "until tomorrow"
+	default:
+		return "until the next iteration"
+	}
+}
+
+// rollAPIBudgets starts the budgets afresh for a -monitor iteration, with
+// -budget-reset iteration
+func rollAPIBudgets() {
+	if budgetReset != budgetResetIteration {
+		return
+	}
+	apiUsagesMu.Lock()
+	defer apiUsagesMu.Unlock()
+	rollAPIBudgetsLocked()
+}
+
+func rollAPIBudgetsLocked() {
+	for provider, u := range apiUsages {
+		if u.Exhausted {
+			breakerRestore(provider)
+		}
+	}
+	apiUsages = make(map[string]*apiUsage)
+}
+
+// apiUsageSnapshot copies the providers' counts, with the budgets no
+// request was made against, for the run summary
+func apiUsageSnapshot() map[string]apiUsage {
+	apiUsagesMu.Lock()
+	defer apiUsagesMu.Unlock()
+	if len(apiUsages) == 0 && len(configBudgets) == 0 {
+		return nil
+	}
+	snap := make(map[string]apiUsage, len(apiUsages))
+	for provider, n := range configBudgets {
+		snap[provider] = apiUsage{Budget: n}
+	}
+	for provider, u := range apiUsages {
+		snap[provider] = *u
+	}
+	return snap
+}
+
+// apiEstimate is a -dry-run estimate of a provider's requests: at least
+// Min and at most Max, or with no upper bound when Max is 0 and Per says
+// what the count grows with
+type apiEstimate struct {
+	Provider string `json:"provider"`
+	Min      int    `json:"min"`
+	Max      int    `json:"max,omitempty"`
+	Per      string `json:"per,omitempty"`
+	Budget   int    `json:"budget,omitempty"`
+
+	unbounded bool
+}
+
+// estimateAPIRequests estimates the requests a run against domains root
+// domains would send each provider, from the planned sources and
+// enrichers and the caps on them
+func estimateAPIRequests(domains int, sources []string) []apiEstimate {
+	est := make(map[string]*apiEstimate)
+	// add counts lo to hi requests, hi < 0 for as many as per says
+	add := func(provider string, lo, hi int, per string) {
+		e := est[provider]
+		if e == nil {
+			e = &apiEstimate{Provider: provider, Budget: configBudgets[provider]}
+			est[provider] = e
+		}
+		e.Min += lo
+		if hi < 0 {
+			e.unbounded = true
+		} else {
+			e.Max += hi
+		}
+		if per != "" {
+			e.Per = strings.TrimPrefix(e.Per+"; "+per, "; ")
+		}
+	}
+	// IP and URL targets come without sources
+	for _, name := range sources {
+		switch name {
+		case "crtsh", "chaos":
+			add(name, domains, domains, "")
+		case "censys":
+			add(name, domains, domains*censysMaxPages, "")
+		case "securitytrails":
+			add(name, domains, domains*securityTrailsMaxRequests, "")
+		case "virustotal":
+			add(name, domains, -1, "one per 40 names it lists")
+		case "dnsdb":
+			add(name, domains, domains*dnsdbMaxRequests, "")
+		case "circl":
+			add(name, domains, domains*circlMaxRequests, "")
+		default:
+			continue
+		}
+		if recursive {
+			add(name, 0, -1, "as many again per intermediate domain -recursive finds")
+		}
+	}
+	if censysEnrich {
+		add("censys", 0, -1, "one per live address")
+	}
+	if historicalIPsFlag {
+		for _, source := range pdnsSources {
+			if pdnsConfigured(source) {
+				add(source, 0, -1, "one per live host, within -"+source+"-max-requests per domain")
+			}
+		}
+	}
+	if cveLookup {
+		add("nvd", 0, -1, "one per product version found and not cached")
+	}
+	if whoisLookup {
+		add("rdap", 0, -1, "one per root domain")
+	}
+	if asnExpand != "" {
+		n := len(splitList(asnExpand))
+		add("ripestat", n, n, "")
+	}
+	if dorksPath != "" && secret("GITHUB_TOKEN") != "" {
+		add("github", 0, -1, "one per GitHub dork")
+	}
+	for provider, n := range configBudgets {
+		if est[provider] == nil {
+			est[provider] = &apiEstimate{Provider: provider, Budget: n}
+		}
+	}
+	out := make([]apiEstimate, 0, len(est))
+	for _, e := range est {
+		if e.unbounded {
+			e.Max = 0
+		}
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Provider < out[j].Provider })
+	return out
+}
+
+// String renders the estimate for the text plan
+func (e apiEstimate) String() string {
+	var s string
+	switch {
+	case e.Max > 0 && e.Max == e.Min:
+		s = fmt.Sprint(e.Min)
+	case e.Max > 0:
+		s = fmt.Sprintf("%d to %d", e.Min, e.Max)
+	case e.Min > 0:
+		s = fmt.Sprintf("at least %d", e.Min)
+	}
+	switch {
+	case e.Per != "" && s != "":
+		s += ", plus " + e.Per
+	case e.Per != "":
+		s = e.Per
+	case s == "":
+		s = "none planned"
+	}
+	if e.Budget > 0 {
+		s += fmt.Sprintf(" (budget %d)", e.Budget)
+	}
+	return s
+}
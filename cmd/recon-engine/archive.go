@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveDir is where -archive-bodies keeps the bodies, under the -workdir
+const archiveDir = "bodies"
+
+// archiveTypes are the -archive-types media types archived besides text,
+// "image/*" standing for every image type
+var archiveTypes []string
+
+// archiveHTTP refetches the response httpx probed, without following
+// redirects, as matchHTTP does
+var archiveHTTP = func() *http.Client {
+	c := newHTTPClient(15*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// configureArchive checks the -archive-* flags. -archive-all implies
+// -archive-bodies. Called once after flag parsing.
+func configureArchive() error {
+	archiveTypes = nil
+	if archiveAll {
+		archiveBodies = true
+	}
+	if !archiveBodies {
+		return nil
+	}
+	if archiveMax <= 0 {
+		return fmt.Errorf("-archive-max must be positive, got %d", archiveMax)
+	}
+	if !keepArtifacts {
+		return fmt.Errorf("-archive-bodies stores into the -workdir, which -keep-artifacts=false deletes")
+	}
+	for _, t := range splitList(archiveTypesFlag) {
+		t = strings.ToLower(t)
+		if major, ok := strings.CutSuffix(t, "/*"); ok && major != "" && !strings.Contains(major, "/") {
+			archiveTypes = append(archiveTypes, t)
+			continue
+		}
+		if mt, _, err := mime.ParseMediaType(t); err != nil || !strings.Contains(mt, "/") {
+			return fmt.Errorf("-archive-types: %q is not a media type such as application/pdf or image/*", t)
+		}
+		archiveTypes = append(archiveTypes, t)
+	}
+	return nil
+}
+
+// archiveWorthy reports whether -archive-bodies keeps res's body: it was
+// flagged, has findings or matched a -match-regex pattern. -archive-all
+// keeps every live host's.
+func archiveWorthy(res *Result) bool {
+	return archiveAll || len(res.Flags) > 0 || len(res.Vulnerabilities) > 0 || len(res.Matches) > 0
+}
+
+// archiveBody fetches res's page again and saves the first -archive-max
+// bytes of its body, gzipped, as bodies/<host>_<port>/<sha256>.gz in the
+// -workdir, setting res.BodyArchive to that path. Bodies that are not text
+// are skipped unless -archive-types names their type. The file is named by
+// the hash of what it holds, so a page that did not change is stored once.
+func archiveBody(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, res.URL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := archiveHTTP.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, archiveMax+1))
+	if err != nil || len(body) == 0 {
+		return
+	}
+	if int64(len(body)) > archiveMax {
+		body = body[:archiveMax]
+		stats.Add("archive.truncated", 1)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !textual(contentType, body) && !archiveTypeAllowed(contentType, body) {
+		stats.Add("archive.skipped_binary", 1)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	rel := path.Join(archiveDir, screenshotHostDir(u), hex.EncodeToString(sum[:])+".gz")
+	file := filepath.Join(workdir, filepath.FromSlash(rel))
+	if _, err := os.Stat(file); err == nil {
+		res.BodyArchive = rel
+		return
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Comment = res.URL
+	gz.ModTime = time.Now()
+	gz.Write(body)
+	gz.Close()
+	data := buf.Bytes()
+	if encryptKey != nil {
+		if data, err = encryptBytes(data); err != nil {
+			reportToolError("archive", "archive", "", fmt.Errorf("%s: %w", res.URL, err))
+			return
+		}
+	}
+	if err := writeFileAtomic(file, data); err != nil {
+		reportToolError("archive", "archive", "", fmt.Errorf("%s: %w", res.URL, err))
+		return
+	}
+	res.BodyArchive = rel
+	stats.Add("archive.bodies", 1)
+	stats.Add("archive.bytes", int64(len(data)))
+}
+
+// archiveTypeAllowed reports whether -archive-types names the body's type,
+// its Content-Type or, without one, its sniffed type
+func archiveTypeAllowed(contentType string, body []byte) bool {
+	if len(archiveTypes) == 0 {
+		return false
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range archiveTypes {
+		if t == mt || strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// asnInfo is the ASN and organization announcing an IP
+type asnInfo struct {
+	Asn int
+	Org string
+}
+
+// asnRange is one row of an ip2asn dataset; start/end are 16-byte IPs
+type asnRange struct {
+	start, end net.IP
+	info       asnInfo
+}
+
+var (
+	// asnRanges is the -asn-db dataset sorted by start address
+	asnRanges []asnRange
+	// asnmapPath is set when asnmap is on PATH and no -asn-db was given
+	asnmapPath string
+
+	asnCache sync.Map // ip -> asnInfo
+)
+
+// loadASNDB reads an ip2asn TSV dataset (iptoasn.com format, optionally
+// gzipped): range_start, range_end, AS number, country, AS description
+func loadASNDB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		asn, err := strconv.Atoi(fields[2])
+		if start == nil || end == nil || err != nil {
+			return fmt.Errorf("%s:%d: malformed line", path, lineNo)
+		}
+		if asn == 0 { // "Not routed"
+			continue
+		}
+		asnRanges = append(asnRanges, asnRange{start.To16(), end.To16(), asnInfo{asn, fields[4]}})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	sort.Slice(asnRanges, func(i, j int) bool {
+		return bytes.Compare(asnRanges[i].start, asnRanges[j].start) < 0
+	})
+	return nil
+}
+
+// configureASN loads -asn-db, or falls back to asnmap when it is installed
+func configureASN() error {
+	if asnDBPath != "" {
+		return loadASNDB(asnDBPath)
+	}
+	if p, err := exec.LookPath("asnmap"); err == nil {
+		asnmapPath = p
+	}
+	return nil
+}
+
+// lookupASN returns the ASN announcing ip, caching the answer per IP
+func lookupASN(ctx context.Context, ip string) (asnInfo, bool) {
+	if cached, ok := asnCache.Load(ip); ok {
+		info := cached.(asnInfo)
+		return info, info.Asn != 0
+	}
+	var info asnInfo
+	switch {
+	case asnRanges != nil:
+		info = lookupASNRange(ip)
+	case asnmapPath != "":
+		info = lookupASNMap(ctx, ip)
+	default:
+		return info, false
+	}
+	asnCache.Store(ip, info)
+	return info, info.Asn != 0
+}
+
+func lookupASNRange(ip string) asnInfo {
+	addr := net.ParseIP(ip).To16()
+	if addr == nil {
+		return asnInfo{}
+	}
+	// Last range starting at or before addr
+	i := sort.Search(len(asnRanges), func(i int) bool {
+		return bytes.Compare(asnRanges[i].start, addr) > 0
+	}) - 1
+	if i >= 0 && bytes.Compare(addr, asnRanges[i].end) <= 0 {
+		return asnRanges[i].info
+	}
+	return asnInfo{}
+}
+
+func lookupASNMap(ctx context.Context, ip string) asnInfo {
+	out, err := toolOutput(toolCommand(ctx, asnmapPath, "-i", ip, "-json", "-silent"))
+	if err != nil {
+		return asnInfo{}
+	}
+	var res struct {
+		ASNumber string `json:"as_number"`
+		ASName   string `json:"as_name"`
+	}
+	if json.Unmarshal(bytes.TrimSpace(out), &res) != nil {
+		return asnInfo{}
+	}
+	asn, _ := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(res.ASNumber), "AS"))
+	return asnInfo{Asn: asn, Org: res.ASName}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ripeStatAPIBase = "https://stat.ripe.net/data"
+
+// sharedTenancyASNs are cloud, hosting and CDN networks. Their address space
+// belongs to other customers too, so -asn-expand refuses them even when
+// listed.
+var sharedTenancyASNs = map[int]string{
+	16509:  "Amazon",
+	14618:  "Amazon",
+	8987:   "Amazon",
+	15169:  "Google",
+	396982: "Google Cloud",
+	8075:   "Microsoft",
+	13335:  "Cloudflare",
+	20940:  "Akamai",
+	16625:  "Akamai",
+	54113:  "Fastly",
+	14061:  "DigitalOcean",
+	16276:  "OVH",
+	24940:  "Hetzner",
+	63949:  "Linode",
+	20473:  "Vultr",
+	31898:  "Oracle Cloud",
+	45102:  "Alibaba Cloud",
+	132203: "Tencent Cloud",
+	36351:  "IBM Cloud",
+}
+
+// asnPrefix is a prefix announced by one of the -asn-expand ASNs
+type asnPrefix struct {
+	prefix netip.Prefix
+	asn    int
+}
+
+// asnExpandPrefixes is filled by configureASNExpand
+var asnExpandPrefixes []asnPrefix
+
+// parseASNList reads -asn-expand: AS numbers with or without the AS prefix
+func parseASNList(s string) ([]int, error) {
+	var asns []int
+	for _, v := range splitList(s) {
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(v), "AS"))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%q is not an AS number", v)
+		}
+		if org, ok := sharedTenancyASNs[n]; ok {
+			return nil, fmt.Errorf("AS%d (%s) is shared by other tenants and cannot be swept", n, org)
+		}
+		asns = append(asns, n)
+	}
+	if len(asns) == 0 {
+		return nil, fmt.Errorf("no AS numbers given")
+	}
+	return asns, nil
+}
+
+// validateASNExpand checks the -asn-expand flags without touching the network
+func validateASNExpand() error {
+	if asnExpand == "" {
+		return nil
+	}
+	if asnExpandMaxIPs < 1 {
+		return fmt.Errorf("-asn-expand-max-ips must be at least 1")
+	}
+	_, err := parseASNList(asnExpand)
+	return err
+}
+
+// configureASNExpand looks up the prefixes the -asn-expand ASNs announce,
+// of the -ip-version families. The run is refused, rather than trimmed,
+// when they hold more than -asn-expand-max-ips addresses: sweeping part of
+// a range the user did not size is worse than sweeping none of it. IPv6
+// prefixes are the exception, as nearly every one is far too large: one
+// holding more than -asn-expand-max-ips addresses on its own is skipped
+// with a warning. Called after -dry-run.
+func configureASNExpand() error {
+	if asnExpand == "" {
+		return nil
+	}
+	asns, err := parseASNList(asnExpand)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	total := 0
+	for _, asn := range asns {
+		prefixes, err := announcedPrefixes(ctx, asn)
+		if err != nil {
+			return fmt.Errorf("AS%d: %w", asn, err)
+		}
+		for _, p := range prefixes {
+			hostBits := p.Addr().BitLen() - p.Bits()
+			if p.Addr().Is6() && (hostBits >= 31 || 1<<hostBits > asnExpandMaxIPs) {
+				fmt.Fprintf(os.Stderr, "Warning: AS%d announces %s, too large to sweep, skipping it\n", asn, p)
+				continue
+			}
+			total += 1 << hostBits
+			asnExpandPrefixes = append(asnExpandPrefixes, asnPrefix{prefix: p, asn: asn})
+		}
+	}
+	if total > asnExpandMaxIPs {
+		return fmt.Errorf("the -asn-expand ASNs announce %d addresses, more than -asn-expand-max-ips %d", total, asnExpandMaxIPs)
+	}
+	fmt.Fprintf(os.Stderr, "ASN sweep: %d prefixes, %d addresses\n", len(asnExpandPrefixes), total)
+	return nil
+}
+
+// announcedPrefixes returns the prefixes of the -ip-version families
+// RIPEstat sees asn announce
+func announcedPrefixes(ctx context.Context, asn int) ([]netip.Prefix, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/announced-prefixes/data.json?resource=AS%d", ripeStatAPIBase, asn), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := apiRequest("ripestat"); err != nil {
+		return nil, err
+	}
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RIPEstat: %s", resp.Status)
+	}
+	var body struct {
+		Data struct {
+			Prefixes []struct {
+				Prefix string `json:"prefix"`
+			} `json:"prefixes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	var out []netip.Prefix
+	for _, p := range body.Data.Prefixes {
+		prefix, err := netip.ParsePrefix(p.Prefix)
+		if err != nil || !ipFamilyAllowed(prefix.Addr()) {
+			continue
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+// runASNSweep probes every address of the -asn-expand prefixes that the run
+// has not already seen behind a subdomain or in an IP target, and emits what
+// answers as Results without a subdomain
+func runASNSweep(ctx context.Context, target string, emit func(Result)) {
+	var ips []string
+	asnOf := make(map[string]int)
+	own := targetRange(target)
+	for _, ap := range asnExpandPrefixes {
+		for a := ap.prefix.Addr(); ap.prefix.Contains(a); a = a.Next() {
+			ip := a.String()
+			if _, dup := asnOf[ip]; dup || ipHosts.Has(ip) || own.Contains(a) || !scopeAllowsIP(ip) {
+				continue
+			}
+			asnOf[ip] = ap.asn
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ASN sweep: probing %d addresses\n", len(ips))
+	coverageAdd("asn_sweep", len(ips))
+
+	prober, in, out, err := startProber(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ASN sweep: %v\n", err)
+		return
+	}
+	go func() {
+		defer in.Close()
+		io.WriteString(in, strings.Join(ips, "\n")+"\n")
+	}()
+	lines := newLineReader(out, "httpx")
+	for lines.Next() {
+		var hRes HttpxResult
+		if err := json.Unmarshal(lines.Bytes(), &hRes); err != nil {
+			rejectedLine("httpx", "asn-sweep", eventParseFailed, err)
+			continue
+		}
+		if repaired, err := sanitizeHttpx(&hRes); err != nil {
+			rejectedLine("httpx", "asn-sweep", eventInvalid, err)
+			continue
+		} else if repaired {
+			repairedLine("httpx")
+		}
+		res := Result{
+			RunID:           runID,
+			RootDomain:      target,
+			EngineVersion:   version,
+			SchemaVersion:   schemaVersion,
+			Timestamp:       time.Now().Format(time.RFC3339),
+			URL:             hRes.Url,
+			Port:            urlPort(hRes.Url),
+			StatusCode:      hRes.StatusCode,
+			Title:           hRes.Title,
+			TechStack:       extractTech(hRes),
+			Vulnerabilities: []Finding{},
+			Source:          "asn-sweep",
+			IP:              hRes.Input,
+			ContentLength:   hRes.ContentLength,
+			ResponseTimeMs:  responseTimeMs(hRes.Time),
+			BodySHA256:      hRes.Hash.BodySHA256,
+			ProbeEngine:     probeEngine,
+		}
+		if asn, ok := asnOf[hRes.Input]; ok {
+			res.Asn = fmt.Sprintf("AS%d", asn)
+		}
+		applyFlagRules(&res)
+		emit(res)
+	}
+	if err := prober.Wait(); err != nil && ctx.Err() == nil {
+		reportToolError("httpx", "asn-sweep", "", err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// authBodyMax caps how much of a natively fetched page is hashed and
+// searched for a title
+const authBodyMax = 10 << 20
+
+// authEnvName is what a credential reference in -auth-file must look like:
+// the name of an environment variable, never the value itself
+var authEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// authContext is one entry of -auth-file: the credentials sent to the hosts
+// its patterns match, and nowhere else
+type authContext struct {
+	Hosts   []string          `yaml:"hosts"`   // host names and *.domain wildcards
+	Headers map[string]string `yaml:"headers"` // header name -> environment variable
+	Cookies map[string]string `yaml:"cookies"` // cookie name -> environment variable
+	Canary  string            `yaml:"canary"`  // URL the session is checked against
+
+	rules   []scopeRule
+	headers [][2]string // resolved, Cookie last
+}
+
+// authSummary is one -auth-file context in the run summary, without its
+// credentials
+type authSummary struct {
+	Hosts        []string `json:"hosts"`
+	Headers      []string `json:"headers,omitempty"` // names only
+	Cookies      []string `json:"cookies,omitempty"` // names only
+	Canary       string   `json:"canary,omitempty"`
+	SessionValid *bool    `json:"session_valid,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+}
+
+// authContexts are the -auth-file entries, in file order
+var authContexts []*authContext
+
+// anonymousKey marks a request context whose requests must go out without
+// -auth-file credentials
+type anonymousKey struct{}
+
+// configureAuth loads -auth-file and resolves its credentials from the
+// environment. Every value becomes a secret redactSecrets hides. Called once
+// after flag parsing.
+func configureAuth() error {
+	if authFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(authFile)
+	if err != nil {
+		return err
+	}
+	var entries []*authContext
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %w", authFile, err)
+	}
+	for i, c := range entries {
+		where := fmt.Sprintf("%s: entry %d", authFile, i+1)
+		if len(c.Hosts) == 0 {
+			return fmt.Errorf("%s has no hosts", where)
+		}
+		for _, h := range c.Hosts {
+			rule, err := parseScopeRule(h)
+			if err != nil || rule.deny || rule.host == "" {
+				return fmt.Errorf("%s: %q is not a host name or *.domain wildcard", where, h)
+			}
+			c.rules = append(c.rules, rule)
+		}
+		if len(c.Headers) == 0 && len(c.Cookies) == 0 {
+			return fmt.Errorf("%s has no headers or cookies", where)
+		}
+		resolve := func(name, env string) (string, error) {
+			if !authEnvName.MatchString(env) {
+				return "", fmt.Errorf("%s: %s must name the environment variable holding its value, not the value", where, name)
+			}
+			v := os.Getenv(env)
+			if v == "" {
+				return "", fmt.Errorf("%s: %s: environment variable %s is not set", where, name, env)
+			}
+			addRedaction(v)
+			return v, nil
+		}
+		for _, name := range sortedKeys(c.Headers) {
+			if strings.ContainsAny(name, " \t:") || strings.EqualFold(name, "Cookie") {
+				return fmt.Errorf("%s: invalid header name %q (cookies go under cookies)", where, name)
+			}
+			v, err := resolve(name, c.Headers[name])
+			if err != nil {
+				return err
+			}
+			c.headers = append(c.headers, [2]string{http.CanonicalHeaderKey(name), v})
+		}
+		var cookies []string
+		for _, name := range sortedKeys(c.Cookies) {
+			v, err := resolve(name, c.Cookies[name])
+			if err != nil {
+				return err
+			}
+			cookies = append(cookies, name+"="+v)
+		}
+		if len(cookies) > 0 {
+			c.headers = append(c.headers, [2]string{"Cookie", strings.Join(cookies, "; ")})
+		}
+		if c.Canary != "" {
+			u, err := url.Parse(c.Canary)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s: canary %q is not an http(s) URL", where, c.Canary)
+			}
+			if !c.matches(u.Hostname()) {
+				return fmt.Errorf("%s: canary %s is not one of the entry's hosts", where, c.Canary)
+			}
+		}
+	}
+	authContexts = entries
+	for _, c := range entries {
+		summary.Auth = append(summary.Auth, &authSummary{
+			Hosts:   c.Hosts,
+			Headers: sortedKeys(c.Headers),
+			Cookies: sortedKeys(c.Cookies),
+			Canary:  c.canary(),
+		})
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *authContext) matches(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, r := range c.rules {
+		if r.matchName(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// canary is the URL the session is checked against: the canary setting or
+// the root of the first host that is not a wildcard
+func (c *authContext) canary() string {
+	if c.Canary != "" {
+		return c.Canary
+	}
+	for _, r := range c.rules {
+		if !r.wildcard {
+			return "https://" + r.host + "/"
+		}
+	}
+	return ""
+}
+
+// authFor returns the context whose hosts match host, the first one in
+// -auth-file order, or nil
+func authFor(host string) *authContext {
+	for _, c := range authContexts {
+		if c.matches(host) {
+			return c
+		}
+	}
+	return nil
+}
+
+// authHeaders returns the credentials to send to host, nil for hosts no
+// -auth-file entry matches
+func authHeaders(host string) [][2]string {
+	if c := authFor(host); c != nil {
+		return c.headers
+	}
+	return nil
+}
+
+// applyAuthHeaders adds the credentials of req's host to req. Each request
+// is judged on its own host, so a redirect to a host outside the entry's
+// patterns goes out without them.
+func applyAuthHeaders(req *http.Request) {
+	if len(authContexts) == 0 || req.Context().Value(anonymousKey{}) != nil {
+		return
+	}
+	for _, p := range authHeaders(req.URL.Hostname()) {
+		if p[0] == "Cookie" && req.Header.Get("Cookie") != "" {
+			req.Header.Set("Cookie", req.Header.Get("Cookie")+"; "+p[1])
+			continue
+		}
+		req.Header.Set(p[0], p[1])
+	}
+}
+
+// rawURLHost returns the host name of rawURL, or ""
+func rawURLHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// authToolArgs returns flag, "Name: value" pairs carrying the credentials of
+// rawURL's host, for httpx-style tools such as ffuf
+func authToolArgs(flag, rawURL string) []string {
+	var args []string
+	for _, p := range authHeaders(rawURLHost(rawURL)) {
+		args = append(args, flag, p[0]+": "+p[1])
+	}
+	return args
+}
+
+// whatwebAuthArgs returns the WhatWeb options carrying the credentials of
+// rawURL's host. WhatWeb would send them along every redirect, so it is
+// told not to follow any.
+func whatwebAuthArgs(rawURL string) []string {
+	pairs := authHeaders(rawURLHost(rawURL))
+	if pairs == nil {
+		return nil
+	}
+	args := []string{"--follow-redirect=never"}
+	for _, p := range pairs {
+		args = append(args, "--header", p[0]+":"+p[1])
+	}
+	return args
+}
+
+// authProbeHTTP fetches authenticated hosts' pages without following
+// redirects, like httpx does
+var authProbeHTTP = func() *http.Client {
+	c := newHTTPClient(15*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// pageResponse is a page as the native fetches see it: what the status,
+// title, length and hash of a Result are made of
+type pageResponse struct {
+	status int
+	title  string
+	length int
+	sha256 string
+}
+
+// fetchAuthResponse GETs rawURL, anonymously when ctx says so
+func fetchAuthResponse(ctx context.Context, rawURL string) (pageResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return pageResponse{}, err
+	}
+	resp, err := authProbeHTTP.Do(req)
+	if err != nil {
+		return pageResponse{}, err
+	}
+	return readPageResponse(resp)
+}
+
+// readPageResponse reads and closes resp's body
+func readPageResponse(resp *http.Response) (pageResponse, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, authBodyMax))
+	if err != nil {
+		return pageResponse{}, err
+	}
+	sum := sha256.Sum256(body)
+	return pageResponse{status: resp.StatusCode, title: pageTitle(body), length: len(body), sha256: hex.EncodeToString(sum[:])}, nil
+}
+
+// samePage reports whether two responses are the same page, give or take
+// the jitter of a dynamic one
+func samePage(a, b pageResponse) bool {
+	baseline := &soft404Baseline{Status: a.status, Length: a.length, SHA256: a.sha256}
+	return baseline.Matches(b.status, b.length, b.sha256) && a.title == b.title
+}
+
+// pageTitle returns the text of an HTML page's first <title>
+func pageTitle(body []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "title" && z.Next() == html.TextToken {
+				return strings.Join(strings.Fields(string(z.Text())), " ")
+			}
+		}
+	}
+}
+
+// authenticateResult fetches res.URL again with its host's credentials,
+// which httpx never gets since it probes every host with the same
+// headers, and replaces the status, title, length and body hash with what
+// the authenticated page shows
+func authenticateResult(ctx context.Context, res *Result) {
+	if authFor(rawURLHost(res.URL)) == nil {
+		return
+	}
+	r, err := fetchAuthResponse(ctx, res.URL)
+	if err != nil {
+		return
+	}
+	res.StatusCode, res.Title, res.ContentLength, res.BodySHA256 = r.status, r.title, r.length, r.sha256
+	res.Authenticated = true
+}
+
+// checkAuthSessions requests each context's canary with and without its
+// credentials. When both answers are the same page, or the authenticated
+// one is a 401 or 403, the session has most likely expired and a warning
+// says so before the run produces logged-out results for every host.
+func checkAuthSessions() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for i, c := range authContexts {
+		s := summary.Auth[i]
+		if s.Canary == "" {
+			continue
+		}
+		valid, reason := authSessionValid(ctx, s.Canary)
+		s.SessionValid, s.Reason = &valid, reason
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Warning: the -auth-file credentials for %s look invalid (%s); results for those hosts may be logged out\n", strings.Join(c.Hosts, ", "), reason)
+		}
+	}
+}
+
+// authSessionValid compares the authenticated and anonymous answers to
+// canary
+func authSessionValid(ctx context.Context, canary string) (bool, string) {
+	authed, err := fetchAuthResponse(ctx, canary)
+	if err != nil {
+		return false, "canary request failed: " + err.Error()
+	}
+	if authed.status == http.StatusUnauthorized || authed.status == http.StatusForbidden {
+		return false, fmt.Sprintf("canary answered %d with credentials", authed.status)
+	}
+	anon, err := fetchAuthResponse(context.WithValue(ctx, anonymousKey{}, true), canary)
+	if err != nil {
+		// Only the credentials get an answer
+		return true, ""
+	}
+	if samePage(anon, authed) {
+		return false, "canary answered the same with and without credentials"
+	}
+	return true, ""
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// axfrTimeout bounds each transfer attempt; most nameservers refuse at once
+// and the rest are not worth waiting for
+const axfrTimeout = 5 * time.Second
+
+// dnsRecord is resolution data a discovery source already holds for a name,
+// so later stages need not look it up again
+type dnsRecord struct {
+	A     []string
+	AAAA  []string
+	CNAME string
+}
+
+// addrs returns the record's IPv4 and IPv6 addresses
+func (r dnsRecord) addrs() []string {
+	return append(append([]string(nil), r.A...), r.AAAA...)
+}
+
+// dnsRecords stores resolution data from zone transfers
+// key: subdomain
+var (
+	dnsRecords   = make(map[string]dnsRecord)
+	dnsRecordsMu sync.Mutex
+	// axfrOpen lists the nameservers that allowed a transfer this run
+	axfrOpen   []string
+	axfrOpenMu sync.Mutex
+)
+
+// runAXFR asks each of domain's nameservers for a zone transfer and streams
+// the names of any zone it gets. Refusals and timeouts are expected and
+// silent.
+func runAXFR(ctx context.Context, domain string, out chan<- string) error {
+	nss, err := dnsResolver.LookupNS(ctx, domain)
+	if err != nil {
+		return nil
+	}
+	zone := dns.Fqdn(domain)
+	seen := make(map[string]bool)
+	for _, ns := range nss {
+		addrs, err := dnsResolver.LookupHost(ctx, ns.Host)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			names, ok := transferZone(ctx, zone, net.JoinHostPort(addr, "53"))
+			if !ok {
+				continue
+			}
+			axfrOpenMu.Lock()
+			axfrOpen = append(axfrOpen, strings.TrimSuffix(ns.Host, "."))
+			axfrOpenMu.Unlock()
+			for _, name := range names {
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				select {
+				case out <- name:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			// One address per nameserver is enough to know it is open
+			break
+		}
+	}
+	return nil
+}
+
+// transferZone requests zone from server and returns the names in it,
+// recording their A and CNAME data in dnsRecords
+func transferZone(ctx context.Context, zone, server string) ([]string, bool) {
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	t := &dns.Transfer{DialTimeout: axfrTimeout, ReadTimeout: axfrTimeout, WriteTimeout: axfrTimeout}
+	conn, err := egressDialer("tcp", axfrTimeout).DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, false
+	}
+	t.Conn = &dns.Conn{Conn: conn}
+	envs, err := t.In(m, server)
+	if err != nil {
+		conn.Close()
+		return nil, false
+	}
+
+	records := make(map[string]dnsRecord)
+	var names []string
+	ok := false
+	for env := range envs {
+		if env.Error != nil {
+			// Drain the channel so the reader goroutine can exit
+			continue
+		}
+		for _, rr := range env.RR {
+			ok = true
+			name := strings.ToLower(strings.TrimSuffix(rr.Header().Name, "."))
+			if strings.HasPrefix(name, "*.") || !dns.IsSubDomain(zone, dns.Fqdn(name)) {
+				continue
+			}
+			rec, known := records[name]
+			switch v := rr.(type) {
+			case *dns.A:
+				rec.A = append(rec.A, v.A.String())
+			case *dns.AAAA:
+				rec.AAAA = append(rec.AAAA, v.AAAA.String())
+			case *dns.CNAME:
+				rec.CNAME = strings.TrimSuffix(v.Target, ".")
+			case *dns.SOA, *dns.NS:
+				// The apex is already the target
+				continue
+			}
+			if !known {
+				names = append(names, name)
+			}
+			records[name] = rec
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	dnsRecordsMu.Lock()
+	for name, rec := range records {
+		if rec.A != nil || rec.AAAA != nil || rec.CNAME != "" {
+			dnsRecords[name] = rec
+		}
+	}
+	dnsRecordsMu.Unlock()
+	return names, true
+}
+
+// lookupDNSRecord returns the resolution data a source recorded for name
+func lookupDNSRecord(name string) (dnsRecord, bool) {
+	dnsRecordsMu.Lock()
+	defer dnsRecordsMu.Unlock()
+	rec, ok := dnsRecords[name]
+	return rec, ok
+}
+
+// axfrResult reports the nameservers that allowed a zone transfer, if any
+func axfrResult(target string) (Result, bool) {
+	axfrOpenMu.Lock()
+	open := append([]string(nil), axfrOpen...)
+	axfrOpenMu.Unlock()
+	if len(open) == 0 {
+		return Result{}, false
+	}
+	sort.Strings(open)
+	findings := make([]Finding, 0, len(open))
+	for _, ns := range open {
+		findings = append(findings, newFinding("axfr", "dns-zone-transfer", "high", confidenceConfirmed,
+			ns+" allows anyone to transfer the "+target+" zone", map[string]interface{}{"nameserver": ns}))
+	}
+	return Result{
+		RunID:           runID,
+		RootDomain:      target,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Subdomain:       target,
+		TechStack:       []string{},
+		Vulnerabilities: findings,
+		Source:          "axfr",
+	}, true
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchFixtures holds the recorded tool output bench replays
+//
+//go:embed testdata/bench
+var benchFixtures embed.FS
+
+// benchReport is what bench measured for one run of the pipeline
+type benchReport struct {
+	Type          string                 `json:"type"`
+	Scale         int                    `json:"scale"`
+	ExitCode      int                    `json:"exit_code"`
+	Names         int64                  `json:"names"`
+	Live          int64                  `json:"live"`
+	Results       int64                  `json:"results"`
+	Seconds       float64                `json:"seconds"`
+	NamesPerSec   float64                `json:"names_per_sec"`
+	ResultsPerSec float64                `json:"results_per_sec"`
+	PeakRSSBytes  int64                  `json:"peak_rss_bytes,omitempty"`
+	Timings       map[string]timingStats `json:"timings,omitempty"`
+}
+
+// benchOwnedFlags are the scan flags bench sets itself, to find the scan's
+// output and summary
+var benchOwnedFlags = []string{"workdir", "summary-file", "o"}
+
+// benchOwnedFlag returns the first of benchOwnedFlags that the scan flags
+// after -- set, or ""
+func benchOwnedFlag(args []string) string {
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if strings.HasPrefix(a, "-") && contains(benchOwnedFlags, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// runBench implements the bench subcommand: a scan of the fixture domain
+// with subfinder, httpx, WhatWeb and nmap replaced by replays of recorded
+// output, timed from the outside
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	scale := fs.Int("scale", 1, "Replay the fixture names this many times over")
+	subfinderRate := fs.Int("subfinder-rate", 0, "Names per second the subfinder replay reports (0 = all at once)")
+	httpxLatency := fs.Duration("httpx-latency", 100*time.Millisecond, "How long each replayed httpx probe takes")
+	httpxThreads := fs.Int("httpx-threads", 50, "Probes the httpx replay runs at a time")
+	wwLatency := fs.Duration("whatweb-latency", 500*time.Millisecond, "How long each replayed WhatWeb run takes")
+	format := fs.String("format", "", "Print the report as JSON with -format json")
+	verbose := fs.Bool("v", false, "Pass the scan's stderr through")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] [-- scan flags]\n\nRuns the full pipeline against %s with subfinder, httpx, WhatWeb and\nnmap replaced by replays of the recorded output in testdata/bench, and\nreports throughput, the scan's peak RSS and per-stage latencies. No\nrequest leaves the machine unless the scan flags enable more stages.\nScan flags after -- are added to the scan, e.g. -- -pprof localhost:6060;\n-workdir, -summary-file and -o are bench's own.\n\nFlags:\n", os.Args[0], fixtureDomain)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *scale < 1 || *httpxThreads < 1 {
+		startupError("Invalid bench options", fmt.Errorf("-scale and -httpx-threads must be at least 1"))
+	}
+	if *format != "" && *format != "json" {
+		startupError("Invalid -format", fmt.Errorf("want json, got %q", *format))
+	}
+	if name := benchOwnedFlag(fs.Args()); name != "" {
+		startupError("Invalid scan flags", fmt.Errorf("-%s is set by bench, which reads the scan's output and summary from its own temporary directory", name))
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fatalError("Cannot find the engine's executable", err)
+	}
+	dir, err := os.MkdirTemp("", "recon-bench-*")
+	if err != nil {
+		fatalError("Failed to create the bench directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Redirects would be followed over the network
+	scanArgs := []string{"scan", "-timings", "-fingerprint", "-no-cache", "-follow-redirects=false", "-workdir", dir, "-o", filepath.Join(dir, "results.ndjson")}
+	for _, tool := range replayedTools {
+		// The replay finds out which tool it plays from its name
+		link := filepath.Join(dir, tool)
+		if err := os.Symlink(exe, link); err != nil {
+			os.RemoveAll(dir)
+			fatalError("Failed to link the replayed tools", err)
+		}
+		scanArgs = append(scanArgs, "-"+tool+"-bin", link)
+	}
+	scanArgs = append(scanArgs, fs.Args()...)
+	scanArgs = append(scanArgs, "--", fixtureDomain)
+
+	cmd := toolCommand(context.Background(), exe, scanArgs...)
+	cmd.Env = append(os.Environ(),
+		replayEnv+"=1",
+		replayScaleEnv+"="+strconv.Itoa(*scale),
+		replaySubfinderRateEnv+"="+strconv.Itoa(*subfinderRate),
+		replayHttpxLatencyEnv+"="+httpxLatency.String(),
+		replayHttpxThreadsEnv+"="+strconv.Itoa(*httpxThreads),
+		replayWhatWebLatencyEnv+"="+wwLatency.String(),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if *verbose {
+		cmd.Stderr = os.Stderr
+	}
+	start := time.Now()
+	err = runTool(cmd)
+	elapsed := time.Since(start)
+	if cmd.ProcessState == nil {
+		os.RemoveAll(dir)
+		fatalError("Failed to run the scan", err)
+	}
+
+	rep := benchReport{Type: "bench", Scale: *scale, ExitCode: cmd.ProcessState.ExitCode(), Seconds: elapsed.Seconds()}
+	rep.PeakRSSBytes = peakRSS(cmd.ProcessState)
+	var sum runSummary
+	b, err := os.ReadFile(filepath.Join(dir, "summary.json"))
+	if err == nil {
+		err = json.Unmarshal(b, &sum)
+	}
+	if err != nil {
+		io.Copy(os.Stderr, &stderr)
+		os.RemoveAll(dir)
+		fatalError("The scan wrote no summary", err)
+	}
+	rep.Names = sum.Counters["sources.subfinder"]
+	rep.Live = sum.Counters["names.live"]
+	rep.Results = sum.Counters["results.total"]
+	rep.NamesPerSec = float64(rep.Names) / elapsed.Seconds()
+	rep.ResultsPerSec = float64(rep.Results) / elapsed.Seconds()
+	rep.Timings = sum.Timings
+
+	if err := printBench(os.Stdout, *format, rep); err != nil {
+		os.RemoveAll(dir)
+		fatalError("Failed to print the report", err)
+	}
+	if rep.ExitCode != 0 {
+		io.Copy(os.Stderr, &stderr)
+		os.RemoveAll(dir)
+		exit(1)
+	}
+}
+
+// printBench writes the report as text, or JSON with -format json
+func printBench(w io.Writer, format string, rep benchReport) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(rep)
+	}
+	fmt.Fprintf(w, "%d names, %d live, %d results in %.1fs: %.1f names/s, %.1f results/s\n",
+		rep.Names, rep.Live, rep.Results, rep.Seconds, rep.NamesPerSec, rep.ResultsPerSec)
+	if rep.PeakRSSBytes > 0 {
+		fmt.Fprintf(w, "Peak RSS %.1f MiB\n", float64(rep.PeakRSSBytes)/(1<<20))
+	}
+	if rep.ExitCode != 0 {
+		fmt.Fprintf(w, "The scan exited with status %d\n", rep.ExitCode)
+	}
+	if len(rep.Timings) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(rep.Timings))
+	for k := range rep.Timings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "STAGE\tCOUNT\tP50\tP90\tP99\tMAX\t")
+	for _, k := range keys {
+		t := rep.Timings[k]
+		fmt.Fprintf(tw, "%s\t%d\t%.3fs\t%.3fs\t%.3fs\t%.3fs\t\n", k, t.Count, t.P50, t.P90, t.P99, t.Max)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// fixtureLines counts the lines of a testdata/bench fixture
+func fixtureLines(t *testing.T, name string) int64 {
+	t.Helper()
+	b, err := benchFixtures.ReadFile("testdata/bench/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(strings.Count(string(b), "\n"))
+}
+
+// TestBenchFixtures runs the whole pipeline against the recorded fixtures,
+// twice over, with the test binary standing in for every tool
+func TestBenchFixtures(t *testing.T) {
+	if testing.Short() {
+		t.Skip("a whole scan of the fixtures")
+	}
+	code, stdout, stderr := runMain(t, t.TempDir(), nil,
+		"bench", "-format", "json", "-scale", "2", "-httpx-latency", "0", "-whatweb-latency", "0")
+	if code != 0 {
+		t.Fatalf("exit %d\n%s%s", code, stdout, stderr)
+	}
+	var rep benchReport
+	if err := json.Unmarshal([]byte(stdout), &rep); err != nil {
+		t.Fatalf("%v: %s", err, stdout)
+	}
+	names, live := 2*fixtureLines(t, "subfinder.txt"), 2*fixtureLines(t, "httpx.jsonl")
+	if rep.ExitCode != 0 || rep.Names != names || rep.Live != live || rep.Results != live {
+		t.Errorf("%d names, %d live, %d results, exit %d; want %d names and %d live",
+			rep.Names, rep.Live, rep.Results, rep.ExitCode, names, live)
+	}
+	// A copy of a name answers as the recorded one does, so WhatWeb's
+	// output for the group is reused for it
+	for stage, want := range map[string]int64{"probe": live, "enrich": live, "enrich.whatweb": live / 2} {
+		if got := int64(rep.Timings[stage].Count); got != want {
+			t.Errorf("%s timed %d times, want %d", stage, got, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Breaker states, as in the summary's breakers and the -stats lines
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open" // one call is let through to see whether it recovered
+)
+
+// errBreakerOpen is returned in place of a call the breaker skipped
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// breaker tracks the health of one discovery source or enrichment API.
+// The censys source and enricher share one since they share the API and
+// its quota.
+type breaker struct {
+	State    string `json:"state"`
+	Failures int    `json:"consecutive_failures,omitempty"`
+	Skipped  int    `json:"skipped,omitempty"` // calls not made while open
+	Opened   int    `json:"opened,omitempty"`  // times it opened
+	LastErr  string `json:"last_error,omitempty"`
+	// Budget marks a breaker opened because the provider's request budget
+	// was spent, not by failures; only the budget starting afresh closes it
+	Budget bool `json:"budget_exhausted,omitempty"`
+
+	openedAt time.Time
+}
+
+var (
+	breakers   = make(map[string]*breaker)
+	breakersMu sync.Mutex
+)
+
+// breakerAllow reports whether name may be called. An open breaker skips
+// the call and counts it, for the rest of the run or, with -monitor, until
+// -breaker-cooldown has passed; then it lets one call through half-open.
+// One opened by a spent request budget stays open until breakerRestore.
+func breakerAllow(name string) bool {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b := breakers[name]
+	if b == nil || b.State == breakerClosed || (breakerFailures <= 0 && !b.Budget) {
+		return true
+	}
+	if b.State == breakerOpen && !b.Budget && monitor && time.Since(b.openedAt) >= breakerCooldown {
+		b.State = breakerHalfOpen
+		fmt.Fprintf(os.Stderr, "Circuit breaker: retrying %s after the %s cool-down\n", name, breakerCooldown)
+		return true
+	}
+	b.Skipped++
+	stats.Add("breaker."+name+".skipped", 1)
+	return false
+}
+
+// breakerResult records how a call to name went. err is nil on success; a
+// call cut short by its context is neither and is not recorded.
+func breakerResult(name string, err error) {
+	// A spent budget is not the provider failing
+	if breakerFailures <= 0 || errors.Is(err, errBudgetExhausted) {
+		return
+	}
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b := breakers[name]
+	if b == nil {
+		b = &breaker{State: breakerClosed}
+		breakers[name] = b
+	}
+	if b.Budget {
+		return
+	}
+	if err == nil {
+		if b.State == breakerHalfOpen {
+			fmt.Fprintf(os.Stderr, "Circuit breaker: %s recovered\n", name)
+		}
+		b.State, b.Failures = breakerClosed, 0
+		return
+	}
+	b.Failures++
+	b.LastErr = redactSecrets(err.Error())
+	switch {
+	case b.State == breakerHalfOpen:
+		b.State, b.openedAt = breakerOpen, time.Now()
+		b.Opened++
+		stats.Add("breaker."+name+".opened", 1)
+		fmt.Fprintf(os.Stderr, "Circuit breaker: %s still failing (%v), skipping it for another %s\n", name, err, breakerCooldown)
+	case b.State == breakerClosed && b.Failures >= breakerFailures:
+		b.State, b.openedAt = breakerOpen, time.Now()
+		b.Opened++
+		stats.Add("breaker."+name+".opened", 1)
+		until := "the rest of the run"
+		if monitor {
+			until = breakerCooldown.String()
+		}
+		fmt.Fprintf(os.Stderr, "Circuit breaker: %s failed %d times in a row (last: %v), skipping it for %s\n", name, b.Failures, err, until)
+	}
+}
+
+// breakerExhaust opens name's breaker because its request budget is spent
+func breakerExhaust(name string) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b := breakers[name]
+	if b == nil {
+		b = &breaker{}
+		breakers[name] = b
+	}
+	b.State, b.Budget, b.openedAt = breakerOpen, true, time.Now()
+	b.Opened++
+	stats.Add("breaker."+name+".opened", 1)
+}
+
+// breakerRestore closes name's breaker when a spent budget opened it
+func breakerRestore(name string) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	if b := breakers[name]; b != nil && b.Budget {
+		b.State, b.Budget, b.Failures = breakerClosed, false, 0
+	}
+}
+
+// breakerSnapshot copies the breakers that have seen a failure, for the run
+// summary
+func breakerSnapshot() map[string]breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	var snap map[string]breaker
+	for name, b := range breakers {
+		if b.Opened == 0 && b.Failures == 0 {
+			continue
+		}
+		if snap == nil {
+			snap = make(map[string]breaker)
+		}
+		snap[name] = *b
+	}
+	return snap
+}
+
+// breakerStates lists the breakers that are not closed, for the -stats
+// lines
+func breakerStates() map[string]string {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	var states map[string]string
+	for name, b := range breakers {
+		if b.State == breakerClosed {
+			continue
+		}
+		if states == nil {
+			states = make(map[string]string)
+		}
+		states[name] = b.State
+	}
+	return states
+}
+
+// validateBreaker checks the circuit breaker flags once after parsing
+func validateBreaker() error {
+	if breakerFailures < 0 {
+		return fmt.Errorf("-breaker-failures must not be negative, got %d", breakerFailures)
+	}
+	if monitor && breakerFailures > 0 && breakerCooldown <= 0 {
+		return fmt.Errorf("-breaker-cooldown must be positive, got %s", breakerCooldown)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed wordlists/subdomains.txt
+var defaultWordlist string
+
+// bruteWindow is how many lookups the -brute throttle judges at a time, and
+// bruteErrorRate the share of them failing (timeouts, SERVFAIL, REFUSED)
+// that halves the lookups in flight
+const (
+	bruteWindow    = 200
+	bruteErrorRate = 0.2
+)
+
+// bruteStarted is when the first -brute run of the iteration started, in
+// Unix nanoseconds, for the attempts per second of the -stats line
+var bruteStarted atomic.Int64
+
+// bruteChunk is a -brute-chunk-size run of wordlist lines. pending counts
+// its lookups in flight plus one while lines are still being read into it.
+// A chunk with a failed lookup is not checkpointed, so resume retries it.
+type bruteChunk struct {
+	index   int
+	pending atomic.Int64
+	failed  atomic.Bool
+	mu      sync.Mutex
+	found   []string
+}
+
+// runBrute resolves <word>.<domain> for every word in -wordlist (or the
+// embedded list) and emits the names that resolve to something other than
+// the zone's wildcard answer. The list is streamed in chunks of
+// -brute-chunk-size lines; with -state each finished chunk is checkpointed
+// and resume skips it, emitting the names it found again.
+func runBrute(ctx context.Context, domain string, out chan<- string) error {
+	open, id, err := bruteWordlist()
+	if err != nil {
+		return err
+	}
+	total, err := countWords(open)
+	if err != nil {
+		return err
+	}
+	stats.Add("brute.words", int64(total))
+
+	wildcard := wildcardIPs(ctx, dnsResolver, domain)
+	if len(wildcard) > 0 {
+		fmt.Fprintf(os.Stderr, "Info: %s has wildcard DNS (%d addresses), filtering brute-force hits\n", domain, len(wildcard))
+	}
+
+	done, found := checkpoints.start(domain, id)
+	if len(done) > 0 {
+		fmt.Fprintf(os.Stderr, "Info: resuming -brute on %s, %d chunks of %d lines already resolved\n", domain, len(done), bruteChunkSize)
+	}
+	for _, name := range found {
+		select {
+		case out <- name:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	defer checkpoints.flush()
+	bruteStarted.CompareAndSwap(0, time.Now().UnixNano())
+
+	type candidate struct {
+		name  string
+		chunk *bruteChunk
+	}
+	finish := func(c *bruteChunk) {
+		if c.pending.Add(-1) == 0 && !c.failed.Load() && ctx.Err() == nil {
+			stats.Add("brute.chunks_done", 1)
+			checkpoints.done(domain, c.index, c.found)
+		}
+	}
+	throttle := newBruteThrottle(ctx, max(bruteConcurrency, 1))
+	candidates := make(chan candidate)
+	var wg sync.WaitGroup
+	for i := 0; i < max(bruteConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range candidates {
+				if throttle.acquire() {
+					stats.Add("brute.attempted", 1)
+					addrs, err := dnsResolver.LookupHost(ctx, c.name)
+					failed := ctx.Err() == nil && lookupFailed(err)
+					throttle.release(failed)
+					if failed {
+						c.chunk.failed.Store(true)
+					}
+					switch {
+					case err != nil || len(addrs) == 0:
+					case isWildcardHit(addrs, wildcard):
+						stats.Add("brute.wildcard_filtered", 1)
+					default:
+						stats.Add("brute.resolved", 1)
+						c.chunk.mu.Lock()
+						c.chunk.found = append(c.chunk.found, c.name)
+						c.chunk.mu.Unlock()
+						select {
+						case out <- c.name:
+						case <-ctx.Done():
+						}
+					}
+				}
+				finish(c.chunk)
+			}
+		}()
+	}
+
+	words, err := open()
+	if err != nil {
+		close(candidates)
+		wg.Wait()
+		return err
+	}
+	defer words.Close()
+	scanner := bufio.NewScanner(words)
+	var chunk *bruteChunk
+	for line := 0; scanner.Scan() && ctx.Err() == nil; line++ {
+		if line%bruteChunkSize == 0 {
+			if chunk != nil && !done[chunk.index] {
+				finish(chunk)
+			}
+			chunk = &bruteChunk{index: line / bruteChunkSize}
+			chunk.pending.Store(1)
+		}
+		word, ok := bruteWord(scanner.Text())
+		if !ok {
+			continue
+		}
+		if done[chunk.index] {
+			stats.Add("brute.skipped", 1)
+			continue
+		}
+		chunk.pending.Add(1)
+		candidates <- candidate{word + "." + domain, chunk}
+	}
+	if chunk != nil && !done[chunk.index] {
+		finish(chunk)
+	}
+	close(candidates)
+	wg.Wait()
+	return scanner.Err()
+}
+
+// bruteWordlist returns a way to open -wordlist (or the embedded list) and
+// what identifies its contents in a checkpoint: the path, size and
+// modification time
+func bruteWordlist() (func() (io.ReadCloser, error), string, error) {
+	if wordlistPath == "" {
+		open := func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(defaultWordlist)), nil }
+		return open, fmt.Sprintf("embedded:%d", len(defaultWordlist)), nil
+	}
+	fi, err := os.Stat(wordlistPath)
+	if err != nil {
+		return nil, "", err
+	}
+	open := func() (io.ReadCloser, error) { return os.Open(wordlistPath) }
+	return open, fmt.Sprintf("%s:%d:%d", wordlistPath, fi.Size(), fi.ModTime().Unix()), nil
+}
+
+// bruteWord returns the word on a wordlist line, false for blank lines and
+// comments
+func bruteWord(line string) (string, bool) {
+	word := strings.ToLower(strings.TrimSpace(line))
+	return word, word != "" && !strings.HasPrefix(word, "#")
+}
+
+// countWords counts the words of a wordlist without holding it in memory
+func countWords(open func() (io.ReadCloser, error)) (int, error) {
+	r, err := open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if _, ok := bruteWord(scanner.Text()); ok {
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+// lookupFailed reports whether a lookup failed for another reason than the
+// name not existing: what resolvers answer when they rate limit
+func lookupFailed(err error) bool {
+	var dnsErr *net.DNSError
+	return err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
+
+// bruteThrottle bounds the -brute lookups in flight. A window of lookups
+// failing more than bruteErrorRate of the time halves the bound, a clean one
+// raises it by one again, up to -brute-concurrency.
+type bruteThrottle struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	inFlight int
+	lookups  int // in the current window
+	failures int
+}
+
+func newBruteThrottle(ctx context.Context, n int) *bruteThrottle {
+	t := &bruteThrottle{ctx: ctx, limit: n, max: n}
+	t.cond = sync.NewCond(&t.mu)
+	context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	})
+	return t
+}
+
+// acquire waits for a lookup slot. It returns false once ctx is done.
+func (t *bruteThrottle) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.inFlight >= t.limit && t.ctx.Err() == nil {
+		t.cond.Wait()
+	}
+	if t.ctx.Err() != nil {
+		return false
+	}
+	t.inFlight++
+	return true
+}
+
+// release frees a slot, counting whether its lookup failed
+func (t *bruteThrottle) release(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	t.lookups++
+	if failed {
+		t.failures++
+		stats.Add("brute.errors", 1)
+	}
+	if t.lookups >= bruteWindow {
+		switch rate := float64(t.failures) / float64(t.lookups); {
+		case rate > bruteErrorRate && t.limit > 1:
+			t.limit = max(t.limit/2, 1)
+			stats.Add("brute.slowdowns", 1)
+			fmt.Fprintf(os.Stderr, "Warning: %.0f%% of -brute lookups failing, slowing down to %d in flight\n", rate*100, t.limit)
+		case rate <= bruteErrorRate/2 && t.limit < t.max:
+			t.limit++
+		}
+		t.lookups, t.failures = 0, 0
+	}
+	t.cond.Broadcast()
+}
+
+// bruteRate is the -brute part of a -stats line
+type bruteRate struct {
+	AttemptsPerSec float64 `json:"attempts_per_sec"`
+	ETA            string  `json:"eta,omitempty"`
+}
+
+// currentBruteRate returns the attempts per second since -brute started
+// and when, at that pace, the wordlists will be done. It is nil when no
+// -brute run is in progress.
+func currentBruteRate(counters map[string]int64) *bruteRate {
+	started := bruteStarted.Load()
+	attempted, left := counters["brute.attempted"], counters["brute.words"]-counters["brute.skipped"]-counters["brute.attempted"]
+	if started == 0 || attempted == 0 || left <= 0 {
+		return nil
+	}
+	rate := float64(attempted) / time.Since(time.Unix(0, started)).Seconds()
+	return &bruteRate{
+		AttemptsPerSec: math.Round(rate*10) / 10,
+		ETA:            time.Duration(float64(left) / rate * float64(time.Second)).Round(time.Second).String(),
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	bucketMaxBytes = 256 << 10
+	// bucketSampleKeys caps the object keys kept as listing evidence
+	bucketSampleKeys = 5
+)
+
+// bucketHTTP sends the read-only bucket probes through the native limiter
+var bucketHTTP = newHTTPClient(10*time.Second, false)
+
+// bucketListing covers an S3/GCS ListBucketResult and an Azure
+// EnumerationResults
+type bucketListing struct {
+	XMLName xml.Name
+	Keys    []string `xml:"Contents>Key"`
+	Blobs   []string `xml:"Blobs>Blob>Name"`
+}
+
+// bucketResponse is what a probe read back
+type bucketResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// errorCode returns the <Code> of a storage API error body
+func (r bucketResponse) errorCode() string {
+	var e struct {
+		Code string `xml:"Code"`
+	}
+	if xml.Unmarshal(r.body, &e) != nil {
+		return ""
+	}
+	return e.Code
+}
+
+// listing parses r as a bucket listing
+func (r bucketResponse) listing() (keys []string, ok bool) {
+	var l bucketListing
+	if xml.Unmarshal(r.body, &l) != nil {
+		return nil, false
+	}
+	switch l.XMLName.Local {
+	case "ListBucketResult":
+		return l.Keys, true
+	case "EnumerationResults":
+		return l.Blobs, true
+	}
+	return nil, false
+}
+
+// bucketProvider names the storage service behind host, a CNAME target
+func bucketProvider(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case strings.HasSuffix(host, ".amazonaws.com") && (strings.Contains(host, ".s3.") || strings.Contains(host, ".s3-") || strings.HasPrefix(host, "s3.") || strings.HasPrefix(host, "s3-")):
+		return "s3"
+	case host == "storage.googleapis.com" || strings.HasSuffix(host, ".storage.googleapis.com"):
+		return "gcs"
+	case strings.HasSuffix(host, ".blob.core.windows.net"):
+		return "azure"
+	}
+	return ""
+}
+
+// bucketProviderFromHeaders recognises a storage service from the headers
+// it answers with, for hosts fronting a bucket without a telling CNAME
+func bucketProviderFromHeaders(h http.Header) string {
+	switch {
+	case h.Get("Server") == "AmazonS3" || h.Get("X-Amz-Bucket-Region") != "":
+		return "s3"
+	case h.Get("X-Guploader-Uploadid") != "" && strings.HasPrefix(h.Get("Server"), "UploadServer"):
+		return "gcs"
+	case strings.Contains(h.Get("Server"), "Windows-Azure-Blob"):
+		return "azure"
+	}
+	return ""
+}
+
+// bucketName derives the bucket a host is served from. Virtual-hosted S3
+// names carry it before the s3 label; otherwise website and CNAME hosting
+// require the bucket to be named after the host.
+func bucketName(provider, cname, host string) string {
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	switch provider {
+	case "s3":
+		for _, sep := range []string{".s3.", ".s3-"} {
+			if i := strings.Index(cname, sep); i > 0 {
+				return cname[:i]
+			}
+		}
+	case "azure":
+		// The account, not a bucket; containers cannot be listed anonymously
+		// from the account root
+		if i := strings.Index(cname, ".blob."); i > 0 {
+			return cname[:i]
+		}
+	}
+	return host
+}
+
+// bucketListURL is an anonymous ListBucket request for bucket
+func bucketListURL(provider, bucket string) string {
+	switch provider {
+	case "s3":
+		// Dotted names break the wildcard certificate of virtual hosting
+		if strings.Contains(bucket, ".") {
+			return "https://s3.amazonaws.com/" + url.PathEscape(bucket) + "?max-keys=" + strconv.Itoa(bucketSampleKeys)
+		}
+		return "https://" + bucket + ".s3.amazonaws.com/?max-keys=" + strconv.Itoa(bucketSampleKeys)
+	case "gcs":
+		return "https://storage.googleapis.com/" + url.PathEscape(bucket) + "?max-keys=" + strconv.Itoa(bucketSampleKeys)
+	}
+	return ""
+}
+
+// checkBucket looks for hosts backed by S3, GCS or Azure blob storage and
+// probes them with anonymous GETs. A NoSuchBucket answer behind a CNAME
+// means anyone can claim the bucket and serve content on the host; a
+// listing means the bucket is public.
+func checkBucket(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	root := u.Scheme + "://" + u.Host + "/"
+
+	// A zone transfer already supplied the CNAME
+	cname := res.CNAME
+	if cname == "" {
+		if c, err := dnsResolver.LookupCNAME(ctx, res.Subdomain); err == nil && !strings.EqualFold(strings.TrimSuffix(c, "."), res.Subdomain) {
+			cname = strings.TrimSuffix(c, ".")
+		}
+	}
+	var provider string
+	if cname != "" {
+		provider = bucketProvider(cname)
+	}
+
+	resp, err := bucketGet(ctx, root, false)
+	if err != nil {
+		return
+	}
+	if provider == "" {
+		if provider = bucketProviderFromHeaders(resp.header); provider == "" {
+			return
+		}
+	}
+	bucket := bucketName(provider, cname, res.Subdomain)
+	evidence := map[string]interface{}{
+		"provider": provider,
+		"bucket":   bucket,
+		"url":      root,
+		"status":   resp.status,
+	}
+	if cname != "" {
+		evidence["cname"] = cname
+	}
+
+	if code := resp.errorCode(); code == "NoSuchBucket" {
+		// Without a CNAME the host is the provider's own and nobody can
+		// claim it
+		if cname != "" {
+			evidence["error_code"] = code
+			res.Vulnerabilities = append(res.Vulnerabilities, bucketFinding("bucket-takeover-candidate", confidenceFirm, evidence))
+		}
+		return
+	}
+
+	// listBase is the URL object keys are relative to
+	listBase := root
+	keys, listed := resp.listing()
+	if !listed {
+		// The root may serve a website; ask for a listing explicitly
+		if listURL := bucketListURL(provider, bucket); listURL != "" {
+			if r, err := bucketGet(ctx, listURL, false); err == nil && r.status == http.StatusOK {
+				if keys, listed = r.listing(); listed {
+					evidence["url"] = listURL
+					listBase, _, _ = strings.Cut(listURL, "?")
+				}
+			}
+		}
+	}
+	if !listed {
+		return
+	}
+	if len(keys) > bucketSampleKeys {
+		keys = keys[:bucketSampleKeys]
+	}
+	evidence["sample_keys"] = keys
+	if len(keys) > 0 {
+		objURL := strings.TrimSuffix(listBase, "/") + "/" + (&url.URL{Path: keys[0]}).EscapedPath()
+		if r, err := bucketGet(ctx, objURL, true); err == nil {
+			evidence["object_readable"] = r.status == http.StatusOK || r.status == http.StatusPartialContent
+		}
+	}
+	res.Vulnerabilities = append(res.Vulnerabilities, bucketFinding("public-bucket-listing", confidenceConfirmed, evidence))
+}
+
+// bucketGet sends one GET. ranged asks for a single byte, enough to tell
+// whether an object is readable without downloading it.
+func bucketGet(ctx context.Context, rawURL string, ranged bool) (bucketResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return bucketResponse{}, err
+	}
+	if ranged {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := bucketHTTP.Do(req)
+	if err != nil {
+		return bucketResponse{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bucketMaxBytes))
+	if err != nil {
+		return bucketResponse{}, err
+	}
+	return bucketResponse{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+func bucketFinding(id, confidence string, evidence map[string]interface{}) Finding {
+	return newFinding("bucket-check", id, "high", confidence, "", evidence)
+}
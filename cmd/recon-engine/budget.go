@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Budget caps, as named in the run summary's caps_tripped
+const (
+	capMaxSubdomains = "max-subdomains"
+	capMaxLiveHosts  = "max-live-hosts"
+	capTimeBudget    = "time-budget"
+)
+
+var (
+	capsTripped   []string
+	capsTrippedMu sync.Mutex
+)
+
+// tripCap records that the -name cap of limit was reached and logs it once.
+// It reports whether this call was the first to trip it.
+func tripCap(name string, limit int, what string) bool {
+	if !recordCap(name) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Reached -%s (%d), %s\n", name, limit, what)
+	return true
+}
+
+// recordCap records that the -name cap was reached, without logging it. It
+// reports whether this call was the first to trip it.
+func recordCap(name string) bool {
+	capsTrippedMu.Lock()
+	defer capsTrippedMu.Unlock()
+	for _, c := range capsTripped {
+		if c == name {
+			return false
+		}
+	}
+	capsTripped = append(capsTripped, name)
+	return true
+}
+
+// trippedCaps lists the caps reached this run. A capped run saw only part
+// of the target, so hosts missing from it are not reported as removed.
+func trippedCaps() []string {
+	capsTrippedMu.Lock()
+	defer capsTrippedMu.Unlock()
+	return append([]string(nil), capsTripped...)
+}
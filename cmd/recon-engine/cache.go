@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is one cached result, stored as
+// <-cache>/<stage>/<sha256 of key>.json. The key and tool version are kept
+// so a hash collision or an upgraded tool reads as a miss.
+type cacheEntry struct {
+	Key      string          `json:"key"`
+	Version  string          `json:"version"`
+	StoredAt time.Time       `json:"stored_at"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// configureCache resolves the -cache directory. Called once after flag
+// parsing; the directory is created by the first write.
+func configureCache() error {
+	if cacheTTL <= 0 {
+		return fmt.Errorf("-cache-ttl must be positive, got %s", cacheTTL)
+	}
+	if noCache || cacheDir != "" {
+		return nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("no home directory for the default, set -cache or -no-cache: %w", err)
+	}
+	cacheDir = filepath.Join(home, ".recon-engine", "cache")
+	return nil
+}
+
+func cachePath(stage, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(cacheDir, stage, hex.EncodeToString(sum[:])+".json")
+}
+
+// cacheGet decodes the stage's entry for key into v when there is one
+// younger than -cache-ttl that was stored by the same tool version
+func cacheGet(stage, key, version string, v interface{}) bool {
+	if noCache {
+		return false
+	}
+	b, err := os.ReadFile(cachePath(stage, key))
+	if err != nil {
+		stats.Add("cache.misses", 1)
+		return false
+	}
+	var e cacheEntry
+	if json.Unmarshal(b, &e) != nil || e.Key != key || e.Version != version ||
+		time.Since(e.StoredAt) > cacheTTL || json.Unmarshal(e.Data, v) != nil {
+		stats.Add("cache.misses", 1)
+		return false
+	}
+	stats.Add("cache.hits", 1)
+	return true
+}
+
+// cachePut stores v for key. The entry is written to a temporary file and
+// renamed into place, so runs sharing the directory never read a partial
+// entry; when two store the same key the last rename wins.
+func cachePut(stage, key, version string, v interface{}) {
+	if noCache {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	b, err := json.Marshal(cacheEntry{Key: key, Version: version, StoredAt: time.Now(), Data: data})
+	if err != nil {
+		return
+	}
+	p := cachePath(stage, key)
+	if err := writeFileAtomic(p, b); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing cache entry: %v\n", err)
+	}
+}
+
+func writeFileAtomic(p string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
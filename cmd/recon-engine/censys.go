@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const censysAPIBase = "https://search.censys.io/api/v2"
+
+// CensysService is a single service Censys has observed on a host
+type CensysService struct {
+	Port      int    `json:"port"`
+	Service   string `json:"service_name"`
+	Transport string `json:"transport_protocol"`
+}
+
+type censysCertSearch struct {
+	Result struct {
+		Hits []struct {
+			Names []string `json:"names"`
+		} `json:"hits"`
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"result"`
+}
+
+type censysHost struct {
+	Result struct {
+		Services []CensysService `json:"services"`
+	} `json:"result"`
+}
+
+// errCensysQuota is returned once Censys reports the account quota is used up
+var errCensysQuota = errors.New("censys quota exhausted")
+
+var (
+	// censysDisabled is set on the first quota error so the rest of the run
+	// stops calling the API instead of failing on every host
+	censysDisabled atomic.Bool
+
+	censysHostCache sync.Map // ip -> []CensysService
+)
+
+func censysCredentials() (string, string, error) {
+	id, key := secret("CENSYS_API_ID"), secret("CENSYS_API_SECRET")
+	if id == "" || key == "" {
+		return "", "", fmt.Errorf("CENSYS_API_ID and CENSYS_API_SECRET must be set")
+	}
+	return id, key, nil
+}
+
+func censysGet(ctx context.Context, endpoint string, v interface{}) error {
+	if censysDisabled.Load() {
+		return errCensysQuota
+	}
+	id, secret, err := censysCredentials()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(id, secret)
+	req.Header.Set("Accept", "application/json")
+	if err := apiRequest("censys"); err != nil {
+		return err
+	}
+
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusPaymentRequired:
+		if !censysDisabled.Swap(true) {
+			fmt.Fprintf(os.Stderr, "Censys quota exhausted (HTTP %d), disabling Censys for the rest of the run\n", resp.StatusCode)
+		}
+		return errCensysQuota
+	case resp.StatusCode == http.StatusNotFound:
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("censys: unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// runCensysSource pages through the certificates index for names under domain
+func runCensysSource(ctx context.Context, domain string, out chan<- string) error {
+	if _, _, err := censysCredentials(); err != nil {
+		return err
+	}
+	suffix := "." + domain
+	cursor := ""
+	for page := 0; page < censysMaxPages; page++ {
+		q := url.Values{}
+		q.Set("q", "names: "+domain)
+		q.Set("per_page", "100")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		var res censysCertSearch
+		if err := censysGet(ctx, censysAPIBase+"/certificates/search?"+q.Encode(), &res); err != nil {
+			if errors.Is(err, errCensysQuota) {
+				return nil
+			}
+			return err
+		}
+		for _, hit := range res.Result.Hits {
+			for _, name := range hit.Names {
+				name = strings.ToLower(strings.TrimPrefix(name, "*."))
+				if name == domain || strings.HasSuffix(name, suffix) {
+					out <- name
+				}
+			}
+		}
+		cursor = res.Result.Links.Next
+		if cursor == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// censysServices returns the services Censys knows about for the first
+// address of the -ip-version family host resolves to, caching lookups per
+// IP
+func censysServices(ctx context.Context, host string) []CensysService {
+	if censysDisabled.Load() {
+		return nil
+	}
+	addrs, err := dnsResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil
+	}
+	ips := hostIPs(addrs)
+	if len(ips) == 0 {
+		return nil
+	}
+	ip := ips[0]
+	if cached, ok := censysHostCache.Load(ip); ok {
+		return cached.([]CensysService)
+	}
+
+	if !breakerAllow("censys") {
+		return nil
+	}
+	var res censysHost
+	err = censysGet(ctx, censysAPIBase+"/hosts/"+url.PathEscape(ip), &res)
+	if ctx.Err() == nil {
+		breakerResult("censys", err)
+	}
+	if err != nil {
+		if !errors.Is(err, errCensysQuota) && !errors.Is(err, errBudgetExhausted) {
+			fmt.Fprintf(os.Stderr, "Censys host lookup error for %s: %v\n", ip, err)
+		}
+		return nil
+	}
+	censysHostCache.Store(ip, res.Result.Services)
+	return res.Result.Services
+}
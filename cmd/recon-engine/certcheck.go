@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// certHTTP reads the certificate of a host without verifying it, since the
+// expired ones are those of interest, and without following redirects
+var certHTTP = func() *http.Client {
+	c := &http.Client{Timeout: 10 * time.Second, Transport: limitedTransport{insecure: true}}
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// abandonedCopyrightAge is how many years old a copyright notice in the
+// title must be to count as a sign of neglect
+const abandonedCopyrightAge = 3
+
+// copyrightYear matches "© 2016", "(c) 2014-2016" and "Copyright 2016",
+// capturing the last year
+var copyrightYear = regexp.MustCompile(`(?i)(?:©|\(c\)|copyright)\s*(?:(?:19|20)\d\d\s*[-–]\s*)?((?:19|20)\d\d)`)
+
+// certIssuerCDNs maps words of an issuer organization to the CDN or cloud
+// that issues and renews the certificate itself
+var certIssuerCDNs = map[string]string{
+	"cloudflare": "cloudflare",
+	"amazon":     "aws",
+}
+
+// CertInfo is the certificate a live HTTPS host presented, with -cert-check
+type CertInfo struct {
+	Subject       string   `json:"subject,omitempty"`
+	Issuer        string   `json:"issuer,omitempty"`
+	DNSNames      []string `json:"dns_names,omitempty"`
+	NotBefore     string   `json:"not_before"`
+	NotAfter      string   `json:"not_after"`
+	DaysRemaining int      `json:"days_remaining"` // negative once expired
+	Expired       bool     `json:"expired,omitempty"`
+	// ManagedBy is the CDN or cloud that serves and renews the certificate,
+	// so its owner rather than the host's acts on an expiry
+	ManagedBy string `json:"managed_by,omitempty"`
+}
+
+// configureCertCheck parses -cert-expiry-warn and checks the severities.
+// Called once after flag parsing.
+func configureCertCheck() error {
+	if !certCheck {
+		return nil
+	}
+	d, err := parseDays(certExpiryWarnFlag)
+	if err != nil {
+		return fmt.Errorf("-cert-expiry-warn: %w", err)
+	}
+	certExpiryWarn = d
+	for name, sev := range map[string]string{"cert-expired-severity": certExpiredSeverity, "cert-expiring-severity": certExpiringSeverity} {
+		if _, ok := parseSeverity(sev); !ok {
+			return fmt.Errorf("-%s: unknown severity %q", name, sev)
+		}
+	}
+	return nil
+}
+
+// parseDays reads a duration given in days ("30d"), or as time.ParseDuration
+// takes it
+func parseDays(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("want a number of days like 30d, got %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("want a number of days like 30d, got %q", s)
+	}
+	return d, nil
+}
+
+// checkCert records the certificate of a live HTTPS host in res.Cert and
+// reports one that has expired or expires within -cert-expiry-warn
+func checkCert(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.Scheme+"://"+u.Host+"/", nil)
+	if err != nil {
+		return
+	}
+	resp, err := certHTTP.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	// Replayed responses carry no TLS state
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return
+	}
+	res.Cert = newCertInfo(resp.TLS.PeerCertificates[0], res.CDN, time.Now())
+	c := res.Cert
+	evidence := map[string]interface{}{
+		"url":            u.Scheme + "://" + u.Host + "/",
+		"subject":        c.Subject,
+		"issuer":         c.Issuer,
+		"not_after":      c.NotAfter,
+		"days_remaining": c.DaysRemaining,
+	}
+	if c.ManagedBy != "" {
+		evidence["managed_by"] = c.ManagedBy
+	}
+	switch {
+	case c.Expired:
+		stats.Add("certs.expired", 1)
+		res.Vulnerabilities = append(res.Vulnerabilities, newFinding("cert-check", "tls-cert-expired", certExpiredSeverity, confidenceConfirmed,
+			fmt.Sprintf("TLS certificate expired %d days ago", -c.DaysRemaining), evidence))
+	case time.Until(resp.TLS.PeerCertificates[0].NotAfter) <= certExpiryWarn:
+		stats.Add("certs.expiring", 1)
+		res.Vulnerabilities = append(res.Vulnerabilities, newFinding("cert-check", "tls-cert-expiring", certExpiringSeverity, confidenceConfirmed,
+			fmt.Sprintf("TLS certificate expires in %d days", c.DaysRemaining), evidence))
+	}
+}
+
+// newCertInfo describes cert as of now. cdn is what the probe identified
+// the host as, if anything.
+func newCertInfo(cert *x509.Certificate, cdn string, now time.Time) *CertInfo {
+	left := cert.NotAfter.Sub(now)
+	c := &CertInfo{
+		Subject:       cert.Subject.CommonName,
+		Issuer:        certIssuer(cert),
+		DNSNames:      cert.DNSNames,
+		NotBefore:     cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:      cert.NotAfter.UTC().Format(time.RFC3339),
+		DaysRemaining: int(math.Floor(left.Hours() / 24)),
+		Expired:       left < 0,
+		ManagedBy:     strings.ToLower(cdn),
+	}
+	if c.ManagedBy == "" {
+		for _, org := range cert.Issuer.Organization {
+			for word, who := range certIssuerCDNs {
+				if strings.Contains(strings.ToLower(org), word) {
+					c.ManagedBy = who
+				}
+			}
+		}
+	}
+	return c
+}
+
+// certIssuer names the issuer by its organization and common name
+func certIssuer(cert *x509.Certificate) string {
+	var parts []string
+	if len(cert.Issuer.Organization) > 0 {
+		parts = append(parts, cert.Issuer.Organization[0])
+	}
+	if cn := cert.Issuer.CommonName; cn != "" {
+		parts = append(parts, cn)
+	}
+	return strings.Join(parts, " ")
+}
+
+// markAbandoned sets res.AbandonedCandidate when an expired certificate
+// comes with other signs nobody looks after the host: a copyright notice
+// in the title years old, or a server version with known CVEs. The signals
+// are added to the expiry finding's evidence.
+func markAbandoned(res *Result) {
+	if res.Cert == nil || !res.Cert.Expired {
+		return
+	}
+	var signals []string
+	for _, m := range copyrightYear.FindAllStringSubmatch(res.Title, -1) {
+		if year, _ := strconv.Atoi(m[1]); year <= time.Now().Year()-abandonedCopyrightAge {
+			signals = append(signals, "copyright "+m[1])
+			break
+		}
+	}
+	for _, f := range res.Vulnerabilities {
+		if f.Stage == "nvd" {
+			signals = append(signals, "outdated version")
+			break
+		}
+	}
+	if len(signals) == 0 {
+		return
+	}
+	res.AbandonedCandidate = true
+	stats.Add("certs.abandoned_candidates", 1)
+	for i := range res.Vulnerabilities {
+		if f := &res.Vulnerabilities[i]; f.ID == "tls-cert-expired" {
+			f.Evidence["abandoned_signals"] = signals
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const chaosAPIBase = "https://dns.projectdiscovery.io/dns"
+
+// runChaos streams the Chaos dataset for domain. Responses for large programs
+// run to hundreds of thousands of labels, so the subdomains array is decoded
+// one element at a time instead of reading the body into memory.
+func runChaos(ctx context.Context, domain string, out chan<- string) error {
+	key := secret("CHAOS_API_KEY")
+	if key == "" {
+		return fmt.Errorf("CHAOS_API_KEY is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/subdomains", chaosAPIBase, url.PathEscape(domain)), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", key)
+	if err := apiRequest("chaos"); err != nil {
+		return err
+	}
+
+	resp, err := streamHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		fmt.Fprintf(os.Stderr, "Info: %s is not in the Chaos dataset, skipping\n", domain)
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("Chaos rejected the API key (HTTP %d), check CHAOS_API_KEY", resp.StatusCode)
+	default:
+		return fmt.Errorf("chaos: unexpected status %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err := seekJSONArray(dec, "subdomains"); err != nil {
+		return fmt.Errorf("chaos: %w", err)
+	}
+	for dec.More() {
+		var label string
+		if err := dec.Decode(&label); err != nil {
+			return fmt.Errorf("chaos: %w", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" {
+			continue
+		}
+		out <- label + "." + domain
+	}
+	return nil
+}
+
+// seekJSONArray advances dec to just inside the array stored under key in the
+// top-level object, so its elements can be decoded one by one
+func seekJSONArray(dec *json.Decoder, key string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("expected object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if k, ok := tok.(string); ok && k == key {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if d, ok := tok.(json.Delim); !ok || d != '[' {
+				return fmt.Errorf("expected array for %q, got %v", key, tok)
+			}
+			return nil
+		}
+		// Skip the value of any other key
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("key %q not found", key)
+}
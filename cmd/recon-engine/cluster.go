@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// clusterKeyFields are the -cluster-keys a cluster key can be built from.
+// The status code is always part of it.
+var clusterKeyFields = []string{"title", "body", "tech", "length"}
+
+// clusterKeys is the parsed -cluster-keys
+var clusterKeys []string
+
+// clusterDigits matches the numbers normalizeTitle blanks out, so titles
+// differing only in a counter, date or node number cluster together
+var clusterDigits = regexp.MustCompile(`[0-9]+`)
+
+// configureClusters checks -cluster-keys. -collapse-clusters implies
+// -cluster. Called once after flag parsing.
+func configureClusters() error {
+	if collapseClusters {
+		clusterResults = true
+	}
+	clusterKeys = nil
+	for _, k := range splitList(strings.ToLower(clusterKeysFlag)) {
+		if !contains(clusterKeyFields, k) {
+			return fmt.Errorf("unknown cluster key %q (want %s)", k, strings.Join(clusterKeyFields, ", "))
+		}
+		if !contains(clusterKeys, k) {
+			clusterKeys = append(clusterKeys, k)
+		}
+	}
+	if clusterResults && len(clusterKeys) == 0 {
+		return fmt.Errorf("-cluster-keys needs at least one of %s", strings.Join(clusterKeyFields, ", "))
+	}
+	return nil
+}
+
+// clusterKey is what results of one cluster have in common: the status code
+// plus the -cluster-keys fields
+func clusterKey(res Result) string {
+	parts := []string{strconv.Itoa(res.StatusCode)}
+	for _, k := range clusterKeys {
+		switch k {
+		case "title":
+			parts = append(parts, normalizeTitle(res.Title))
+		case "body":
+			parts = append(parts, strings.ToLower(res.BodySHA256))
+		case "tech":
+			parts = append(parts, techKey(res.TechStack))
+		case "length":
+			parts = append(parts, strconv.Itoa(res.ContentLength))
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// normalizeTitle lower-cases a title, collapses its whitespace and blanks
+// out its numbers
+func normalizeTitle(title string) string {
+	return clusterDigits.ReplaceAllString(strings.Join(strings.Fields(strings.ToLower(title)), " "), "#")
+}
+
+// techKey returns tech as a sorted list of canonical names without
+// versions, so the same application on two patch levels still clusters
+func techKey(tech []string) string {
+	set := make([]string, 0, len(tech))
+	for _, t := range tech {
+		name, _, _ := strings.Cut(techEntry(t), ":")
+		if name != "" && !contains(set, name) {
+			set = append(set, name)
+		}
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
+
+// ResultCluster is a group of results serving the same application, as
+// reported in the run summary
+type ResultCluster struct {
+	ID             string `json:"id"`
+	Representative string `json:"representative"`
+	Count          int    `json:"count"`
+	Status         int    `json:"status"`
+	Title          string `json:"title,omitempty"`
+}
+
+// clusterIndex assigns cluster IDs to the probed results of a run
+type clusterIndex struct {
+	mu       sync.Mutex
+	clusters map[string]*ResultCluster
+	order    []string
+}
+
+var resultClusters = &clusterIndex{clusters: make(map[string]*ResultCluster)}
+
+// Add returns the ID of res's cluster, recording res as a member; the first
+// member is the cluster's representative. The ID is derived from the key,
+// so the same application gets the same ID in every run.
+func (x *clusterIndex) Add(res Result) string {
+	sum := sha256.Sum256([]byte(clusterKey(res)))
+	id := "c-" + hex.EncodeToString(sum[:5])
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	c, ok := x.clusters[id]
+	if !ok {
+		c = &ResultCluster{ID: id, Representative: resultHost(res), Status: res.StatusCode, Title: res.Title}
+		x.clusters[id] = c
+		x.order = append(x.order, id)
+	}
+	c.Count++
+	return id
+}
+
+// Shared returns the clusters with more than one member, largest first
+func (x *clusterIndex) Shared() []ResultCluster {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var out []ResultCluster
+	for _, id := range x.order {
+		if c := x.clusters[id]; c.Count > 1 {
+			out = append(out, *c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	return out
+}
+
+// resultHost names a result in cluster listings: its URL, or its host when
+// it has none
+func resultHost(res Result) string {
+	switch {
+	case res.URL != "":
+		return res.URL
+	case res.Subdomain != "":
+		return res.Subdomain
+	}
+	return res.IP
+}
+
+// clusterCollapser holds back clustered results for -collapse-clusters and
+// emits one representative per cluster, listing every member, once the
+// run is over
+type clusterCollapser struct {
+	reps  map[string]*Result
+	order []string
+}
+
+func newClusterCollapser() *clusterCollapser {
+	return &clusterCollapser{reps: make(map[string]*Result)}
+}
+
+// Hold reports whether res was held back as part of a cluster. Results
+// without a cluster, such as dead hosts and port scan records, are not, and
+// neither are hosts -state reports as removed.
+func (c *clusterCollapser) Hold(res Result) bool {
+	if !collapseClusters || res.ClusterID == "" || res.ChangeType == changeRemoved {
+		return false
+	}
+	rep, ok := c.reps[res.ClusterID]
+	if !ok {
+		rep = &res
+		c.reps[res.ClusterID] = rep
+		c.order = append(c.order, res.ClusterID)
+	}
+	rep.ClusterMembers = append(rep.ClusterMembers, resultHost(res))
+	return true
+}
+
+// Drain emits the representatives in the order their clusters appeared.
+// A cluster of one is emitted as it is, without a member list.
+func (c *clusterCollapser) Drain(emit func(Result)) {
+	for _, id := range c.order {
+		rep := c.reps[id]
+		if len(rep.ClusterMembers) == 1 {
+			rep.ClusterMembers = nil
+		}
+		emit(*rep)
+	}
+	c.reps, c.order = make(map[string]*Result), nil
+}
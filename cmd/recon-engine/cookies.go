@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cookieHTTP never follows redirects: only the root path's own cookies are
+// audited
+var cookieHTTP = func() *http.Client {
+	c := newHTTPClient(10*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// CookieInfo is the security-relevant part of one Set-Cookie header
+type CookieInfo struct {
+	Name     string `json:"name"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"http_only"`
+	SameSite string `json:"same_site,omitempty"` // as sent: Strict, Lax or None
+}
+
+// auditCookies requests the root path of a live host, records the cookies
+// it sets in res.Cookies and reports session cookies set without Secure
+// (on HTTPS), HttpOnly or SameSite
+func auditCookies(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	root := u.Scheme + "://" + u.Host + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, root, nil)
+	if err != nil {
+		return
+	}
+	resp, err := cookieHTTP.Do(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	for _, line := range resp.Header.Values("Set-Cookie") {
+		c, ok := parseSetCookie(line)
+		if !ok {
+			continue
+		}
+		res.Cookies = append(res.Cookies, c)
+		if !sessionCookie(c.Name) {
+			continue
+		}
+		var missing []string
+		if u.Scheme == "https" && !c.Secure {
+			missing = append(missing, "Secure")
+		}
+		if !c.HttpOnly {
+			missing = append(missing, "HttpOnly")
+		}
+		if c.SameSite == "" {
+			missing = append(missing, "SameSite")
+		}
+		if len(missing) > 0 {
+			res.Vulnerabilities = append(res.Vulnerabilities, newFinding("cookie-audit", "session-cookie-flags", "low", confidenceConfirmed,
+				"", map[string]interface{}{"url": root, "cookie": c.Name, "missing": missing}))
+		}
+	}
+}
+
+// parseSetCookie reads the name and attributes of a Set-Cookie value.
+// Attribute names are matched case-insensitively and unknown or malformed
+// attributes are ignored, as browsers do; it returns false when there is no
+// name.
+func parseSetCookie(line string) (CookieInfo, bool) {
+	parts := strings.Split(line, ";")
+	name, _, ok := strings.Cut(parts[0], "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return CookieInfo{}, false
+	}
+	c := CookieInfo{Name: name}
+	for _, attr := range parts[1:] {
+		k, v, _ := strings.Cut(attr, "=")
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(strings.Trim(strings.TrimSpace(v), `"`)) {
+			case "strict":
+				c.SameSite = "Strict"
+			case "lax":
+				c.SameSite = "Lax"
+			case "none":
+				c.SameSite = "None"
+			}
+		}
+	}
+	return c, true
+}
+
+// sessionCookie reports whether a cookie name looks like it carries a
+// session: PHPSESSID, connect.sid, auth_token, JSESSIONID and the like
+func sessionCookie(name string) bool {
+	n := strings.ToLower(name)
+	for _, s := range []string{"session", "auth", "token"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+	return strings.HasSuffix(n, "sid")
+}
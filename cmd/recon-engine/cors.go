@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// corsForeignOrigin is an origin no sane policy trusts
+const corsForeignOrigin = "https://evil.example"
+
+// corsHTTP stays on the root path: a redirect's target would be a different
+// policy than the one the host itself serves
+var corsHTTP = func() *http.Client {
+	c := newHTTPClient(10*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// corsResponse holds the CORS headers a host answered an Origin with
+type corsResponse struct {
+	origin, allowOrigin string
+	credentials         bool
+}
+
+// checkCORS requests the root of a live host with an untrusted subdomain of
+// target, a foreign origin and the null origin, and appends any policy that
+// trusts them with credentials to res.Vulnerabilities
+func checkCORS(ctx context.Context, res *Result, target string) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	root := u.Scheme + "://" + u.Host + "/"
+
+	// IP targets have no subdomains to trust
+	origins := []string{corsForeignOrigin, "https://evil." + targetHost(target), "null"}
+	if targetRange(target).IsValid() {
+		origins = []string{corsForeignOrigin, "null"}
+	}
+	var wildcard bool
+	for _, origin := range origins {
+		r, err := corsProbe(ctx, root, origin)
+		if err != nil {
+			continue
+		}
+		switch {
+		case r.allowOrigin == "*" && r.credentials:
+			// Browsers refuse this combination, so it is reported once
+			// as a sign of a broken policy rather than an exploitable one
+			if !wildcard {
+				wildcard = true
+				res.Vulnerabilities = append(res.Vulnerabilities, corsFinding("cors-wildcard-credentials", "low", root, r))
+			}
+		case !r.credentials || r.allowOrigin != origin:
+			// Not trusted with credentials
+		case origin == "null":
+			res.Vulnerabilities = append(res.Vulnerabilities, corsFinding("cors-null-origin", "high", root, r))
+		case origin == corsForeignOrigin:
+			res.Vulnerabilities = append(res.Vulnerabilities, corsFinding("cors-reflected-origin", "high", root, r))
+			// Any other origin is reflected too; the subdomain probe
+			// would only repeat the finding
+			return
+		default:
+			res.Vulnerabilities = append(res.Vulnerabilities, corsFinding("cors-subdomain-origin", "medium", root, r))
+		}
+	}
+}
+
+// corsProbe sends one GET with origin and reads the CORS response headers
+func corsProbe(ctx context.Context, rawURL, origin string) (corsResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return corsResponse{}, err
+	}
+	req.Header.Set("Origin", origin)
+	resp, err := corsHTTP.Do(req)
+	if err != nil {
+		return corsResponse{}, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return corsResponse{
+		origin:      origin,
+		allowOrigin: strings.TrimSpace(resp.Header.Get("Access-Control-Allow-Origin")),
+		credentials: strings.EqualFold(strings.TrimSpace(resp.Header.Get("Access-Control-Allow-Credentials")), "true"),
+	}, nil
+}
+
+// corsFinding builds a Finding carrying the request origin and the response
+// headers as evidence
+func corsFinding(id, severity, rawURL string, r corsResponse) Finding {
+	return newFinding("cors-check", id, severity, confidenceConfirmed, "", map[string]interface{}{
+		"url":                              rawURL,
+		"origin":                           r.origin,
+		"access-control-allow-origin":      r.allowOrigin,
+		"access-control-allow-credentials": "true",
+	})
+}
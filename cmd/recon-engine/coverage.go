@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Coverage statuses: what became of a capability in a run
+const (
+	coverageRan           = "ran"
+	coverageSkippedFlag   = "skipped-by-flag"
+	coverageSkippedBinary = "skipped-missing-binary"
+	coverageSkippedKey    = "skipped-missing-key"
+	coverageTruncated     = "truncated-by-budget"
+)
+
+// coverageEntry is one capability's line of the run summary's coverage
+type coverageEntry struct {
+	Capability string `json:"capability"`
+	Stage      string `json:"stage"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	// Items counts the names, hosts or addresses it processed
+	Items int64 `json:"items"`
+}
+
+// capability is something a run can do, as the coverage report lists it
+type capability struct {
+	name  string
+	stage string // discovery, probe, portscan, enrich or post
+	// enabled reports whether the flags ask for it; off says how to, or
+	// why it is off
+	enabled func() bool
+	off     string
+	// missing names the tool it needs and could not find, if any
+	missing func() string
+	// keys are the services of apiKeyEnv it needs a key of, any one
+	// doing; apis the providers whose budget or breaker can cut it short
+	keys []string
+	apis []string
+	// items counts what it processed from the run's counters; without it
+	// coverageAdd's count is used
+	items func(counters map[string]int64) int64
+}
+
+// counter returns an items func reading the named -stats counter
+func counter(name string) func(map[string]int64) int64 {
+	return func(c map[string]int64) int64 { return c[name] }
+}
+
+// sourceCapability is the capability of a -sources entry
+func sourceCapability(name, off string, keys ...string) capability {
+	return capability{
+		name:    name,
+		stage:   "discovery",
+		enabled: func() bool { return slices.Contains(summary.Sources, name) },
+		off:     off,
+		keys:    keys,
+		apis:    []string{name},
+		items:   counter("sources." + name),
+	}
+}
+
+// always is the enabled func of the capabilities no flag turns off
+func always() bool { return true }
+
+// capabilities lists every capability in the order a run goes through
+// them, which is the order of the coverage report. Each enrichment stage
+// of stageWeights, and waf, is listed; new stages and sources are added
+// here so that CI can tell a stage that ran from one that never started.
+var capabilities = []capability{
+	sourceCapability("subfinder", "-sources without subfinder"),
+	sourceCapability("amass", "needs -deep or -sources amass"),
+	sourceCapability("crtsh", "needs -sources crtsh"),
+	sourceCapability("censys", "needs -sources censys", "censys"),
+	sourceCapability("securitytrails", "needs -sources securitytrails", "securitytrails"),
+	sourceCapability("virustotal", "needs -sources virustotal", "virustotal"),
+	sourceCapability("chaos", "needs -sources chaos", "chaos"),
+	sourceCapability("dnsdb", "needs -sources dnsdb", "dnsdb"),
+	sourceCapability("circl", "needs -sources circl", "circl"),
+	sourceCapability("brute", "needs -brute"),
+	sourceCapability("axfr", "needs -axfr"),
+	{name: "recursive", stage: "discovery", enabled: func() bool { return recursive }, off: "needs -recursive"},
+	{name: "permute", stage: "discovery", enabled: func() bool { return permute }, off: "needs -permute", items: counter("sources.permutation")},
+	{name: "ptr_sweep", stage: "discovery", enabled: func() bool { return ptrSweep }, off: "needs -ptr", items: counter("sources.ptr")},
+
+	{name: "probe", stage: "probe", enabled: always, items: counter("names.live")},
+	{
+		name:    "httpx",
+		stage:   "probe",
+		enabled: func() bool { return probeEngine == probeEngineHttpx || probeEngineFallback },
+		off:     "-probe-engine native: no httpx technology or CDN detection",
+		missing: func() string {
+			if probeEngineFallback {
+				return "httpx not found, probed with the native engine"
+			}
+			return ""
+		},
+		items: counter("names.live"),
+	},
+	{name: "portscan", stage: "portscan", enabled: always},
+
+	{name: "auth", stage: "enrich", enabled: func() bool { return len(authContexts) > 0 }, off: "needs -auth-file"},
+	{name: "waf", stage: "enrich", enabled: always},
+	{name: "parked", stage: "enrich", enabled: always},
+	{name: "redirects", stage: "enrich", enabled: func() bool { return followRedirectsFlag }, off: "-follow-redirects=false"},
+	{name: "access", stage: "enrich", enabled: func() bool { return accessCheck }, off: "needs -access-control"},
+	{name: "soft404", stage: "enrich", enabled: func() bool { return soft404Flag }, off: "needs -soft404"},
+	{
+		name:    "asn",
+		stage:   "enrich",
+		enabled: always,
+		missing: func() string {
+			if asnRanges == nil && asnmapPath == "" {
+				return "asnmap not found and no -asn-db; only amass describes ASNs"
+			}
+			return ""
+		},
+	},
+	{name: "third_party", stage: "enrich", enabled: func() bool { return thirdPartyCheck }, off: "needs -third-party"},
+	{name: "ptr", stage: "enrich", enabled: func() bool { return ptrSweep || coverageRanges > 0 }, off: "needs -ptr or an IP target"},
+	{name: "geo", stage: "enrich", enabled: func() bool { return geoIPPath != "" }, off: "needs -geoip"},
+	{name: "censys", stage: "enrich", enabled: func() bool { return censysEnrich }, off: "needs -censys-enrich", keys: []string{"censys"}, apis: []string{"censys"}},
+	{name: "historical_ips", stage: "enrich", enabled: func() bool { return historicalIPsFlag }, off: "needs -historical-ips", keys: pdnsSources, apis: pdnsSources},
+	{name: "robots", stage: "enrich", enabled: func() bool { return collectRobotsFlag }, off: "needs -robots"},
+	{name: "security_txt", stage: "enrich", enabled: func() bool { return securityTxtFlag }, off: "needs -security-txt"},
+	{name: "cors", stage: "enrich", enabled: func() bool { return corsCheck }, off: "needs -cors-check"},
+	{name: "headers", stage: "enrich", enabled: func() bool { return headerAudit }, off: "needs -header-audit"},
+	{name: "cookies", stage: "enrich", enabled: func() bool { return cookieAudit }, off: "needs -cookie-audit"},
+	{name: "cert", stage: "enrich", enabled: func() bool { return certCheck }, off: "needs -cert-check"},
+	{name: "jarm", stage: "enrich", enabled: func() bool { return jarmFlag }, off: "needs -jarm"},
+	{name: "bucket", stage: "enrich", enabled: func() bool { return bucketCheck }, off: "needs -bucket-check"},
+	{name: "dirbrute", stage: "enrich", enabled: func() bool { return dirBrute }, off: "needs -dirbrute"},
+	{name: "whatweb", stage: "enrich", enabled: whatwebFingerprinting, off: "needs -fingerprint with -fingerprint-engine whatweb or both"},
+	{name: "fingerprint", stage: "enrich", enabled: nativeFingerprinting, off: "needs -fingerprint with -fingerprint-engine native or both"},
+	{name: "default_creds", stage: "enrich", enabled: func() bool { return defaultCreds }, off: "needs -default-creds"},
+	{name: "match", stage: "enrich", enabled: func() bool { return len(matchPatterns) > 0 }, off: "needs -match-regex or -match-file"},
+	{name: "params", stage: "enrich", enabled: func() bool { return paramsFlag }, off: "needs -params"},
+	{name: "redirect_check", stage: "enrich", enabled: func() bool { return redirectCheck }, off: "needs -redirect-check"},
+	{name: "cve", stage: "enrich", enabled: func() bool { return cveLookup }, off: "needs -cve-lookup", apis: []string{"nvd"}},
+	{name: "environment", stage: "enrich", enabled: func() bool { return envBody && envRulesUseBody() }, off: "needs -env-body and an environment rule on the body"},
+	{name: "screenshot", stage: "enrich", enabled: func() bool { return screenshotsFlag }, off: "needs -screenshots"},
+	{name: "archive", stage: "enrich", enabled: func() bool { return archiveBodies }, off: "needs -archive-bodies"},
+
+	{name: "asn_sweep", stage: "post", enabled: func() bool { return asnExpand != "" }, off: "needs -asn-expand", apis: []string{"ripestat"}},
+	{name: "vhost", stage: "post", enabled: func() bool { return vhostProbe }, off: "needs -vhost", items: counter("vhost.pairs")},
+	{name: "whois", stage: "post", enabled: func() bool { return whoisLookup }, off: "needs -whois", apis: []string{"rdap", "whoisxml"}},
+	{name: "dns_audit", stage: "post", enabled: func() bool { return dnsAudit }, off: "needs -dns-audit"},
+	{name: "dorks", stage: "post", enabled: func() bool { return dorksPath != "" }, off: "needs -dorks"},
+	{name: "github_dorks", stage: "post", enabled: func() bool { return dorksPath != "" }, off: "needs -dorks", keys: []string{"github"}, apis: []string{"github"}},
+}
+
+var (
+	coverageMu sync.Mutex
+	// coverageItems counts what each capability without a counter of its
+	// own processed
+	coverageItems = make(map[string]int64)
+	// coverageCapped lists the sources -max-subdomains-per-source stopped
+	coverageCapped []string
+	// coverageDomains counts the pipelines of domain targets, which run
+	// discovery, and coverageRanges and coverageURLs those of IP and URL
+	// targets, which do not
+	coverageDomains, coverageRanges, coverageURLs int
+)
+
+// coverageAdd records that capability name processed n more items
+func coverageAdd(name string, n int) {
+	coverageMu.Lock()
+	coverageItems[name] += int64(n)
+	coverageMu.Unlock()
+}
+
+// coverageTarget records the kind of target a pipeline scans
+func coverageTarget(target string) {
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+	switch {
+	case targetRange(target).IsValid():
+		coverageRanges++
+	case targetURL(target) != nil:
+		coverageURLs++
+	default:
+		coverageDomains++
+	}
+}
+
+// resetCoverage clears the counts of a monitor iteration
+func resetCoverage() {
+	coverageMu.Lock()
+	coverageItems = make(map[string]int64)
+	coverageCapped = nil
+	coverageDomains, coverageRanges, coverageURLs = 0, 0, 0
+	coverageMu.Unlock()
+}
+
+// coverageReport lists what became of every capability in the run s
+// describes: skipped because no flag asked for it, for a missing tool or API
+// key, cut short by a budget, cap or circuit breaker, or ran. Called by
+// finish with s locked and its counters, breakers and API usage filled in.
+func coverageReport(s *runSummary) []coverageEntry {
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+	out := make([]coverageEntry, 0, len(capabilities))
+	for _, c := range capabilities {
+		e := coverageEntry{Capability: c.name, Stage: c.stage, Status: coverageRan}
+		if c.items != nil {
+			e.Items = c.items(s.Counters)
+		} else {
+			e.Items = coverageItems[c.name]
+		}
+		switch {
+		case c.stage == "discovery" && coverageDomains == 0 && coverageRanges+coverageURLs > 0:
+			e.Status, e.Reason = coverageSkippedFlag, "IP and URL targets are scanned without discovery"
+		case !c.enabled():
+			e.Status, e.Reason = coverageSkippedFlag, c.off
+		case c.missing != nil && c.missing() != "":
+			e.Status, e.Reason = coverageSkippedBinary, c.missing()
+		case len(c.keys) > 0 && !anyKeyConfigured(c.keys):
+			e.Status, e.Reason = coverageSkippedKey, missingKeysReason(c.keys)
+		default:
+			if reason := coverageTruncation(s, c); reason != "" {
+				e.Status, e.Reason = coverageTruncated, reason
+			}
+		}
+		if e.Status != coverageRan && e.Status != coverageTruncated {
+			e.Items = 0
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// anyKeyConfigured reports whether any of services has its key set
+func anyKeyConfigured(services []string) bool {
+	return slices.ContainsFunc(services, func(service string) bool {
+		return len(missingKeys(service)) == 0
+	})
+}
+
+// missingKeysReason names the unset variables of services' keys
+func missingKeysReason(services []string) string {
+	var missing []string
+	for _, service := range services {
+		missing = append(missing, missingKeys(service)...)
+	}
+	if len(missing) == 1 {
+		return missing[0] + " is not set"
+	}
+	return strings.Join(missing, ", ") + " are not set"
+}
+
+// coverageTruncation says what cut capability c short, or "" when
+// nothing did: -time-budget, -max-subdomains or -max-live-hosts, a spent
+// API budget or an open circuit breaker
+func coverageTruncation(s *runSummary, c capability) string {
+	if tb := s.TimeBudget; tb != nil {
+		if n := tb.Skipped[c.name]; n > 0 {
+			return fmt.Sprintf("-time-budget spent, skipped on %d hosts", n)
+		}
+		budgetStage := c.stage
+		if budgetStage == "portscan" || budgetStage == "post" {
+			budgetStage = "enrich"
+		}
+		if n, ok := tb.Truncated[budgetStage]; ok {
+			return fmt.Sprintf("-time-budget spent during %s, %d left unprocessed", budgetStage, n)
+		}
+	}
+	switch {
+	case c.stage == "discovery" && slices.Contains(s.CapsTripped, capMaxSubdomains):
+		return "-" + capMaxSubdomains + " reached"
+	case c.stage != "discovery" && slices.Contains(s.CapsTripped, capMaxLiveHosts):
+		return "-" + capMaxLiveHosts + " reached"
+	}
+	for _, provider := range c.apis {
+		if u, ok := s.APIRequests[provider]; ok && u.Exhausted {
+			return fmt.Sprintf("%s request budget (%d) spent", provider, u.Budget)
+		}
+		if b, ok := s.Breakers[provider]; ok && b.Opened > 0 {
+			return fmt.Sprintf("%s circuit breaker opened: %s", provider, b.LastErr)
+		}
+	}
+	if slices.Contains(coverageCapped, c.name) {
+		return fmt.Sprintf("-max-subdomains-per-source (%d) reached", maxPerSource)
+	}
+	return ""
+}
+
+// coverageCap records that source reached -max-subdomains-per-source
+func coverageCap(source string) {
+	coverageMu.Lock()
+	if !slices.Contains(coverageCapped, source) {
+		coverageCapped = append(coverageCapped, source)
+	}
+	coverageMu.Unlock()
+}
+
+// warnCoverage names on stderr the capabilities the flags asked for that
+// a missing tool or key, a budget or a breaker kept from running in full,
+// which a user might otherwise take for the tool being broken
+func warnCoverage(entries []coverageEntry) {
+	var degraded []string
+	for _, e := range entries {
+		if e.Status != coverageRan && e.Status != coverageSkippedFlag {
+			degraded = append(degraded, fmt.Sprintf("%s (%s: %s)", e.Capability, e.Status, e.Reason))
+		}
+	}
+	if len(degraded) > 0 {
+		fmt.Fprintf(os.Stderr, "Coverage: %s\n", strings.Join(degraded, "; "))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type crtshEntry struct {
+	ID        int64  `json:"id"`
+	NameValue string `json:"name_value"`
+}
+
+// runCrtsh queries crt.sh certificate transparency logs for names under domain
+func runCrtsh(ctx context.Context, domain string, out chan<- string) error {
+	endpoint := "https://crt.sh/?output=json&q=" + url.QueryEscape("%."+domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if err := apiRequest("crtsh"); err != nil {
+		return err
+	}
+	// crt.sh is slow for large domains
+	resp, err := streamHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("crtsh: unexpected status %s", resp.Status)
+	}
+
+	suffix := "." + domain
+	dec := json.NewDecoder(resp.Body)
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("crtsh: %w", err)
+	}
+	for dec.More() {
+		var e crtshEntry
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("crtsh: %w", err)
+		}
+		// name_value holds one name per line
+		for _, name := range strings.Split(e.NameValue, "\n") {
+			name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "*."))
+			if name == domain || strings.HasSuffix(name, suffix) {
+				if graphPath != "" {
+					assetGraphs.ObserveCert(name, e.ID)
+				}
+				out <- name
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const nvdAPIBase = "https://services.nvd.nist.gov/rest/json/cves/2.0"
+
+// cpeProducts maps canonical technology names to the vendor:product pair NVD
+// uses where the two differ. Other names are queried as *:<name>.
+var cpeProducts = map[string]string{
+	"apache":        "apache:http_server",
+	"microsoft-iis": "microsoft:internet_information_services",
+	"nginx":         "f5:nginx",
+	"php":           "php:php",
+	"openssl":       "openssl:openssl",
+	"jquery":        "jquery:jquery",
+	"wordpress":     "wordpress:wordpress",
+	"drupal":        "drupal:drupal",
+	"joomla":        "joomla:joomla\\!",
+	"tomcat":        "apache:tomcat",
+	"apache-tomcat": "apache:tomcat",
+	"lighttpd":      "lighttpd:lighttpd",
+	"openssh":       "openbsd:openssh",
+}
+
+// exactVersion matches versions precise enough to look up: dotted numbers
+// with an optional alphanumeric suffix, e.g. 2.4.49 or 1.1.1k
+var exactVersion = regexp.MustCompile(`^\d+(\.\d+)+[a-z0-9]*$`)
+
+// CVEMatch is a vulnerability found for a product version
+type CVEMatch struct {
+	ID       string  `json:"id"`
+	CVSS     float64 `json:"cvss"`
+	Severity string  `json:"severity"`
+	Summary  string  `json:"summary"`
+}
+
+type nvdResponse struct {
+	Vulnerabilities []struct {
+		CVE struct {
+			ID           string `json:"id"`
+			Descriptions []struct {
+				Lang  string `json:"lang"`
+				Value string `json:"value"`
+			} `json:"descriptions"`
+			Metrics map[string][]struct {
+				CVSSData struct {
+					BaseScore    float64 `json:"baseScore"`
+					BaseSeverity string  `json:"baseSeverity"`
+				} `json:"cvssData"`
+				BaseSeverity string `json:"baseSeverity"`
+			} `json:"metrics"`
+		} `json:"cve"`
+	} `json:"vulnerabilities"`
+}
+
+var (
+	// cveMu serialises lookups: NVD's rate limits allow little parallelism
+	// and it keeps concurrent workers from querying the same key twice
+	cveMu       sync.Mutex
+	cveCache    map[string][]CVEMatch // product|version -> matches
+	cveRequests []time.Time           // NVD request times in the current window
+)
+
+// configureCVE loads the on-disk cache for -cve-lookup
+func configureCVE() error {
+	if !cveLookup {
+		return nil
+	}
+	if cveCachePath == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		cveCachePath = filepath.Join(dir, "recon-engine", "cve-cache.json")
+	}
+	cveCache = make(map[string][]CVEMatch)
+	b, err := os.ReadFile(cveCachePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &cveCache); err != nil {
+		return fmt.Errorf("%s: %w", cveCachePath, err)
+	}
+	return nil
+}
+
+// saveCVECache writes the cache back to disk. The caller holds cveMu.
+func saveCVECache() {
+	if err := os.MkdirAll(filepath.Dir(cveCachePath), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CVE cache: %v\n", err)
+		return
+	}
+	b, err := json.Marshal(cveCache)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(cveCachePath, b, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CVE cache: %v\n", err)
+	}
+}
+
+// cpeMatchString builds an NVD virtualMatchString for product at version,
+// or returns "" when the version is too vague to query
+func cpeMatchString(product, version string) string {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if !exactVersion.MatchString(version) {
+		return ""
+	}
+	p := strings.ToLower(strings.TrimSpace(product))
+	vp, ok := cpeProducts[p]
+	if !ok {
+		if strings.ContainsAny(p, " :*") || p == "" {
+			return ""
+		}
+		vp = "*:" + p
+	}
+	return "cpe:2.3:a:" + vp + ":" + version
+}
+
+// addCVEs looks up every precise version in res.Versions and appends the
+// matches to res.Vulnerabilities
+func addCVEs(ctx context.Context, res *Result) {
+	for product, versions := range res.Versions {
+		for _, v := range strings.Split(versions, ",") {
+			v = strings.TrimSpace(v)
+			matches, err := lookupCVEs(ctx, product, v)
+			if errors.Is(err, errBreakerOpen) || errors.Is(err, errBudgetExhausted) {
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "CVE lookup error for %s %s: %v\n", product, v, err)
+				continue
+			}
+			for _, m := range matches {
+				// NVD leaves some CVEs unscored
+				sev := m.Severity
+				if sev == "" {
+					sev = string(cvssSeverity(m.CVSS))
+				}
+				f := newFinding("nvd", m.ID, sev, confidenceTentative, m.Summary, map[string]interface{}{
+					"cvss":    m.CVSS,
+					"product": product,
+					"version": v,
+				})
+				f.Reference = "https://nvd.nist.gov/vuln/detail/" + m.ID
+				res.Vulnerabilities = append(res.Vulnerabilities, f)
+			}
+		}
+	}
+}
+
+// lookupCVEs returns the CVEs NVD lists for product at version, from the
+// cache when possible
+func lookupCVEs(ctx context.Context, product, version string) ([]CVEMatch, error) {
+	match := cpeMatchString(product, version)
+	if match == "" {
+		return nil, nil
+	}
+	key := strings.ToLower(product) + "|" + strings.ToLower(version)
+
+	cveMu.Lock()
+	defer cveMu.Unlock()
+	if cached, ok := cveCache[key]; ok {
+		return cached, nil
+	}
+	if !breakerAllow("nvd") {
+		return nil, errBreakerOpen
+	}
+	matches, err := queryNVD(ctx, match)
+	if ctx.Err() == nil {
+		breakerResult("nvd", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cveCache[key] = matches
+	saveCVECache()
+	return matches, nil
+}
+
+// waitNVD enforces NVD's documented limits: 5 requests per rolling 30
+// seconds without an API key, 50 with one. The caller holds cveMu.
+func waitNVD(ctx context.Context, limit int) error {
+	const window = 30 * time.Second
+	for {
+		now := time.Now()
+		kept := cveRequests[:0]
+		for _, t := range cveRequests {
+			if now.Sub(t) < window {
+				kept = append(kept, t)
+			}
+		}
+		cveRequests = kept
+		if len(cveRequests) < limit {
+			cveRequests = append(cveRequests, now)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(window - now.Sub(cveRequests[0])):
+		}
+	}
+}
+
+func queryNVD(ctx context.Context, match string) ([]CVEMatch, error) {
+	key := secret("NVD_API_KEY")
+	limit := 5
+	if key != "" {
+		limit = 50
+	}
+	if err := waitNVD(ctx, limit); err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("virtualMatchString", match)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nvdAPIBase+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		req.Header.Set("apiKey", key)
+	}
+	if err := apiRequest("nvd"); err != nil {
+		return nil, err
+	}
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("NVD rejected the request (HTTP 403), check NVD_API_KEY or the rate limit")
+	default:
+		return nil, fmt.Errorf("nvd: unexpected status %s", resp.Status)
+	}
+
+	var nr nvdResponse
+	if err := json.NewDecoder(resp.Body).Decode(&nr); err != nil {
+		return nil, err
+	}
+	matches := make([]CVEMatch, 0, len(nr.Vulnerabilities))
+	for _, v := range nr.Vulnerabilities {
+		m := CVEMatch{ID: v.CVE.ID}
+		for _, d := range v.CVE.Descriptions {
+			if d.Lang == "en" {
+				m.Summary = d.Value
+				break
+			}
+		}
+		// Prefer the newest CVSS version NVD has scored
+		for _, name := range []string{"cvssMetricV40", "cvssMetricV31", "cvssMetricV30", "cvssMetricV2"} {
+			if ms := v.CVE.Metrics[name]; len(ms) > 0 {
+				m.CVSS = ms[0].CVSSData.BaseScore
+				m.Severity = strings.ToLower(ms[0].CVSSData.BaseSeverity)
+				if m.Severity == "" {
+					// CVSS v2 keeps the severity outside cvssData
+					m.Severity = strings.ToLower(ms[0].BaseSeverity)
+				}
+				break
+			}
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
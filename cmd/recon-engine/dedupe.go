@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// nameSet records which names have already been sent to httpx. Add reports
+// whether name was new.
+type nameSet interface {
+	Add(name string) (bool, error)
+	Close() error
+}
+
+// newNameSet returns the set selected by -dedupe-backend
+func newNameSet() (nameSet, error) {
+	if dedupeBackend == "disk" {
+		return newDiskSet()
+	}
+	return memorySet{}, nil
+}
+
+type memorySet map[string]bool
+
+func (m memorySet) Add(name string) (bool, error) {
+	if m[name] {
+		return false, nil
+	}
+	m[name] = true
+	return true, nil
+}
+
+func (memorySet) Close() error { return nil }
+
+const (
+	// diskSetBuffer is how many keys are held in memory before they are
+	// written out as a sorted run
+	diskSetBuffer = 1 << 20
+	// diskSetMaxRuns bounds the runs a lookup has to search; beyond it all
+	// runs are merged into one
+	diskSetMaxRuns = 8
+)
+
+// setKey is a 128-bit SHA-256 prefix of a name. Collisions are not a
+// practical concern at any scope size, so keys stand in for names.
+type setKey [16]byte
+
+func keyOf(name string) setKey {
+	sum := sha256.Sum256([]byte(name))
+	var k setKey
+	copy(k[:], sum[:16])
+	return k
+}
+
+// diskRun is a file of sorted, fixed-size keys with a bloom filter so most
+// lookups for new names never touch the disk
+type diskRun struct {
+	f     *os.File
+	n     int64
+	bloom bloomFilter
+}
+
+// diskSet keeps recent keys in memory and spills them to sorted run files in
+// a temporary directory, keeping memory roughly constant: the buffer plus
+// about 1.25 bytes of bloom filter per name.
+type diskSet struct {
+	dir  string
+	mem  map[setKey]struct{}
+	runs []*diskRun
+	seq  int
+}
+
+func newDiskSet() (*diskSet, error) {
+	dir, err := os.MkdirTemp("", "recon-dedupe-*")
+	if err != nil {
+		return nil, err
+	}
+	return &diskSet{dir: dir, mem: make(map[setKey]struct{})}, nil
+}
+
+func (d *diskSet) Add(name string) (bool, error) {
+	k := keyOf(name)
+	if _, ok := d.mem[k]; ok {
+		return false, nil
+	}
+	for _, r := range d.runs {
+		found, err := r.contains(k)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return false, nil
+		}
+	}
+	d.mem[k] = struct{}{}
+	if len(d.mem) >= diskSetBuffer {
+		if err := d.flush(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// flush writes the in-memory keys out as a new run
+func (d *diskSet) flush() error {
+	keys := make([]setKey, 0, len(d.mem))
+	for k := range d.mem {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	r, err := d.writeRun(len(keys), func(emit func(setKey) error) error {
+		for _, k := range keys {
+			if err := emit(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.runs = append(d.runs, r)
+	d.mem = make(map[setKey]struct{})
+	if len(d.runs) > diskSetMaxRuns {
+		return d.merge()
+	}
+	return nil
+}
+
+// writeRun creates a run file from the keys produced by fill, which must
+// emit them in sorted order
+func (d *diskSet) writeRun(n int, fill func(emit func(setKey) error) error) (*diskRun, error) {
+	d.seq++
+	f, err := os.Create(filepath.Join(d.dir, fmt.Sprintf("run-%06d", d.seq)))
+	if err != nil {
+		return nil, err
+	}
+	r := &diskRun{f: f, bloom: newBloomFilter(n)}
+	w := bufio.NewWriterSize(f, 256*1024)
+	err = fill(func(k setKey) error {
+		r.bloom.add(k)
+		r.n++
+		_, err := w.Write(k[:])
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// merge combines every run into one, dropping nothing: the runs hold
+// disjoint keys since a key is only added after every run was searched
+func (d *diskSet) merge() error {
+	var total int64
+	readers := make([]*bufio.Reader, len(d.runs))
+	heads := make([]*setKey, len(d.runs))
+	for i, r := range d.runs {
+		total += r.n
+		readers[i] = bufio.NewReaderSize(io.NewSectionReader(r.f, 0, r.n*16), 64*1024)
+	}
+	next := func(i int) error {
+		var k setKey
+		if _, err := io.ReadFull(readers[i], k[:]); err != nil {
+			if err == io.EOF {
+				heads[i] = nil
+				return nil
+			}
+			return err
+		}
+		heads[i] = &k
+		return nil
+	}
+	for i := range readers {
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+
+	merged, err := d.writeRun(int(total), func(emit func(setKey) error) error {
+		for {
+			min := -1
+			for i, h := range heads {
+				if h != nil && (min < 0 || bytes.Compare(h[:], heads[min][:]) < 0) {
+					min = i
+				}
+			}
+			if min < 0 {
+				return nil
+			}
+			if err := emit(*heads[min]); err != nil {
+				return err
+			}
+			if err := next(min); err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range d.runs {
+		r.f.Close()
+		os.Remove(r.f.Name())
+	}
+	d.runs = []*diskRun{merged}
+	return nil
+}
+
+// contains binary searches the run for k
+func (r *diskRun) contains(k setKey) (bool, error) {
+	if !r.bloom.mayContain(k) {
+		return false, nil
+	}
+	var buf setKey
+	lo, hi := int64(0), r.n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, err := r.f.ReadAt(buf[:], mid*16); err != nil {
+			return false, err
+		}
+		switch c := bytes.Compare(buf[:], k[:]); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (d *diskSet) Close() error {
+	for _, r := range d.runs {
+		r.f.Close()
+	}
+	return os.RemoveAll(d.dir)
+}
+
+// bloomFilter uses 10 bits per key and 7 probes derived from the key bytes,
+// for a false positive rate around 1%
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+}
+
+func newBloomFilter(n int) bloomFilter {
+	m := uint64(max(n, 1)) * 10
+	return bloomFilter{bits: make([]uint64, (m+63)/64), m: m}
+}
+
+func (b bloomFilter) probes(k setKey, fn func(bit uint64) bool) bool {
+	h1 := binary.LittleEndian.Uint64(k[:8])
+	h2 := binary.LittleEndian.Uint64(k[8:]) | 1
+	for i := uint64(0); i < 7; i++ {
+		if !fn((h1 + i*h2) % b.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bloomFilter) add(k setKey) {
+	b.probes(k, func(bit uint64) bool {
+		b.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (b bloomFilter) mayContain(k setKey) bool {
+	return b.probes(k, func(bit uint64) bool {
+		return b.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// TestDiskSetNeverProbesTwice adds names across several spilled runs and a
+// merge: every name must be new exactly once
+func TestDiskSetNeverProbesTwice(t *testing.T) {
+	d, err := newDiskSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	add := func(name string, want bool) {
+		t.Helper()
+		fresh, err := d.Add(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh != want {
+			t.Fatalf("Add(%q) = %v, want %v", name, fresh, want)
+		}
+	}
+	const perRun = 500
+	for run := 0; run <= diskSetMaxRuns; run++ {
+		for i := 0; i < perRun; i++ {
+			add(fmt.Sprintf("h%d-%d.example.com", run, i), true)
+		}
+		if err := d.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The last flush went past diskSetMaxRuns and merged everything
+	if len(d.runs) != 1 || d.runs[0].n != int64(perRun*(diskSetMaxRuns+1)) {
+		t.Fatalf("%d runs after the merge", len(d.runs))
+	}
+	for run := 0; run <= diskSetMaxRuns; run++ {
+		for i := 0; i < perRun; i++ {
+			add(fmt.Sprintf("h%d-%d.example.com", run, i), false)
+		}
+	}
+	// In memory and on disk at once
+	add("fresh.example.com", true)
+	add("fresh.example.com", false)
+	add("h0-0.example.com", false)
+}
+
+func TestMemorySet(t *testing.T) {
+	s := memorySet{}
+	if fresh, _ := s.Add("a.example.com"); !fresh {
+		t.Error("first Add not fresh")
+	}
+	if fresh, _ := s.Add("a.example.com"); fresh {
+		t.Error("second Add fresh")
+	}
+}
+
+// benchmarkNameSet adds size new names to a fresh set per iteration, and
+// reports the peak heap one set took on top of what was in use before it
+func benchmarkNameSet(b *testing.B, newSet func() (nameSet, error)) {
+	for _, size := range []struct {
+		name string
+		n    int
+	}{{"1M", 1_000_000}, {"10M", 10_000_000}} {
+		b.Run(size.name, func(b *testing.B) {
+			if size.n > 1_000_000 && testing.Short() {
+				b.Skip("10M names take half a minute and about a gigabyte of heap with the memory set")
+			}
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				var m runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&m)
+				base := m.HeapAlloc
+				s, err := newSet()
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				for j := 0; j < size.n; j++ {
+					if _, err := s.Add(fmt.Sprintf("host-%d.example.com", j)); err != nil {
+						b.Fatal(err)
+					}
+					if j%(1<<16) == 0 || j == size.n-1 {
+						runtime.ReadMemStats(&m)
+						if m.HeapAlloc > base && m.HeapAlloc-base > peak {
+							peak = m.HeapAlloc - base
+						}
+					}
+				}
+				b.StopTimer()
+				s.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(size.n), "ns/name")
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+}
+
+func BenchmarkMemorySet(b *testing.B) {
+	benchmarkNameSet(b, func() (nameSet, error) { return memorySet{}, nil })
+}
+
+func BenchmarkDiskSet(b *testing.B) {
+	benchmarkNameSet(b, func() (nameSet, error) { return newDiskSet() })
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/default-creds.yaml
+var defaultCredsTable []byte
+
+// credCheck is one product's login endpoint and default credentials
+type credCheck struct {
+	Product     string            `yaml:"product"`
+	Tech        string            `yaml:"tech"` // canonical technology name
+	Method      string            `yaml:"method"`
+	Path        string            `yaml:"path"`
+	Auth        string            `yaml:"auth"` // basic, form or json
+	UserField   string            `yaml:"user_field"`
+	PassField   string            `yaml:"pass_field"`
+	Extra       map[string]string `yaml:"extra"`
+	Success     credSuccess       `yaml:"success"`
+	Credentials [][]string        `yaml:"credentials"`
+}
+
+// credSuccess is what a successful login answers with. Every condition set
+// must hold.
+type credSuccess struct {
+	Status           []int  `yaml:"status"`
+	BodyContains     string `yaml:"body_contains"`
+	LocationExcludes string `yaml:"location_excludes"` // a failed login redirects here
+}
+
+// credChecks are the loaded checks less the -default-creds-deny products
+var credChecks []credCheck
+
+// credHTTP never follows redirects: where a login redirects to is part of
+// its outcome
+var credHTTP = func() *http.Client {
+	c := newHTTPClient(10*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// configureDefaultCreds loads the embedded table for -default-creds. Called
+// once after flag parsing.
+func configureDefaultCreds() error {
+	if !defaultCreds {
+		return nil
+	}
+	if defaultCredsMax < 1 {
+		return fmt.Errorf("-default-creds-max must be at least 1")
+	}
+	var checks []credCheck
+	if err := yaml.Unmarshal(defaultCredsTable, &checks); err != nil {
+		return fmt.Errorf("embedded table: %w", err)
+	}
+	deny := splitList(strings.ToLower(defaultCredsDeny))
+	known := make(map[string]bool)
+	for _, c := range checks {
+		switch {
+		case c.Product == "" || c.Tech == "" || c.Path == "":
+			return fmt.Errorf("embedded table: entry %q needs product, tech and path", c.Product)
+		case c.Auth != "basic" && c.Auth != "form" && c.Auth != "json":
+			return fmt.Errorf("embedded table: %s: unknown auth %q", c.Product, c.Auth)
+		}
+		known[c.Product] = true
+		if contains(deny, c.Product) {
+			continue
+		}
+		credChecks = append(credChecks, c)
+	}
+	for _, p := range deny {
+		if !known[p] {
+			return fmt.Errorf("-default-creds-deny: unknown product %q", p)
+		}
+	}
+	return nil
+}
+
+// checkDefaultCreds tries the default credentials of every product in the
+// table whose technology res.TechStack names, at most -default-creds-max
+// login requests per host, and reports each that logs in. Hosts outside
+// -scope and products missing from the tech stack get no request at all, and
+// with -polite neither do login paths robots.txt disallows.
+func checkDefaultCreds(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" || !scopeAllowsHost(u.Hostname()) {
+		return
+	}
+	base := u.Scheme + "://" + u.Host
+	attempts := 0
+	for _, c := range credChecks {
+		if !hasTech(res.TechStack, c.Tech) {
+			continue
+		}
+		endpoint := base + c.Path
+		if robotsDisallowed(ctx, endpoint) {
+			continue
+		}
+
+		// A host that lets anyone in says nothing about its defaults
+		if c.Auth != "basic" {
+			if attempts == defaultCredsMax {
+				return
+			}
+			attempts++
+		}
+		ok, _, err := c.try(ctx, endpoint, randomCred(), randomCred(), c.Auth == "basic")
+		if err != nil || ok {
+			continue
+		}
+
+		for _, pair := range c.Credentials {
+			if len(pair) != 2 {
+				continue
+			}
+			if attempts == defaultCredsMax {
+				return
+			}
+			attempts++
+			ok, evidence, err := c.try(ctx, endpoint, pair[0], pair[1], false)
+			if err != nil {
+				break
+			}
+			if ok {
+				evidence["url"] = endpoint
+				evidence["product"] = c.Product
+				evidence["username"] = pair[0]
+				evidence["password"] = pair[1]
+				res.Vulnerabilities = append(res.Vulnerabilities, newFinding("default-creds", "default-credentials", "critical", confidenceConfirmed, "", evidence))
+				break
+			}
+		}
+	}
+}
+
+// try sends one login and reports whether it met c.Success, with the status
+// and Location as evidence. anonymous sends no credentials at all.
+func (c credCheck) try(ctx context.Context, endpoint, user, pass string, anonymous bool) (bool, map[string]interface{}, error) {
+	var body io.Reader
+	contentType := ""
+	switch {
+	case anonymous || c.Auth == "basic":
+	case c.Auth == "json":
+		fields := map[string]string{c.UserField: user, c.PassField: pass}
+		for k, v := range c.Extra {
+			fields[k] = v
+		}
+		b, _ := json.Marshal(fields)
+		body, contentType = bytes.NewReader(b), "application/json"
+	default:
+		form := url.Values{c.UserField: {user}, c.PassField: {pass}}
+		for k, v := range c.Extra {
+			form.Set(k, v)
+		}
+		body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	}
+	req, err := http.NewRequestWithContext(ctx, c.Method, endpoint, body)
+	if err != nil {
+		return false, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Auth == "basic" && !anonymous {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := credHTTP.Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 256<<10))
+
+	evidence := map[string]interface{}{"status": resp.StatusCode}
+	loc := resp.Header.Get("Location")
+	if loc != "" {
+		evidence["location"] = loc
+	}
+	s := c.Success
+	if len(s.Status) > 0 && !containsInt(s.Status, resp.StatusCode) {
+		return false, evidence, nil
+	}
+	if s.BodyContains != "" && !bytes.Contains(b, []byte(s.BodyContains)) {
+		return false, evidence, nil
+	}
+	if s.LocationExcludes != "" && strings.Contains(loc, s.LocationExcludes) {
+		return false, evidence, nil
+	}
+	return true, evidence, nil
+}
+
+// hasTech reports whether tech, a TechStack, names the canonical technology
+// name
+func hasTech(tech []string, name string) bool {
+	for _, t := range tech {
+		if n, _, _ := strings.Cut(techEntry(t), ":"); n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// randomCred returns a user name or password no product ships with
+func randomCred() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "x" + hex.EncodeToString(b)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ddFinding is one finding of DefectDojo's Generic Findings Import
+type ddFinding struct {
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	Severity         string   `json:"severity"`
+	Date             string   `json:"date"`
+	CVE              string   `json:"cve,omitempty"`
+	VulnIDFromTool   string   `json:"vuln_id_from_tool,omitempty"`
+	UniqueIDFromTool string   `json:"unique_id_from_tool"`
+	Endpoints        []string `json:"endpoints,omitempty"`
+	Active           bool     `json:"active"`
+	Verified         bool     `json:"verified"`
+	StaticFinding    bool     `json:"static_finding"`
+	DynamicFinding   bool     `json:"dynamic_finding"`
+}
+
+// ddSeverities maps finding severities to DefectDojo's levels
+var ddSeverities = map[Severity]string{
+	severityCritical: "Critical",
+	severityHigh:     "High",
+	severityMedium:   "Medium",
+	severityLow:      "Low",
+	severityInfo:     "Info",
+}
+
+// ddSeverity returns the DefectDojo severity of a finding
+func ddSeverity(f Finding) string {
+	if s, ok := ddSeverities[f.Severity]; ok {
+		return s
+	}
+	return "Info"
+}
+
+// ddEncoder collects results and writes a Generic Findings Import document
+// when the run ends: a finding per Vulnerabilities entry and, with
+// -defectdojo-flagged, an Info finding per flag on a host
+type ddEncoder struct {
+	w        io.Writer
+	findings []ddFinding
+}
+
+// Encode turns results into findings; anything else, such as event
+// records, is written as JSON straight away
+func (e *ddEncoder) Encode(v interface{}) error {
+	res, ok := v.(Result)
+	if !ok {
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	e.findings = append(e.findings, ddFindings(res)...)
+	return nil
+}
+
+// Flush writes the document
+func (e *ddEncoder) Flush() error {
+	findings := e.findings
+	if findings == nil {
+		findings = []ddFinding{}
+	}
+	enc := json.NewEncoder(e.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"findings": findings})
+}
+
+// ddFindings maps one result to its findings
+func ddFindings(res Result) []ddFinding {
+	endpoint := res.URL
+	if endpoint == "" {
+		endpoint = res.Subdomain
+	}
+	host := res.Subdomain
+	if host == "" {
+		host = endpoint
+	}
+	date := time.Now().Format("2006-01-02")
+	var out []ddFinding
+	for _, v := range res.Vulnerabilities {
+		id, title := v.ID, v.Name()
+		f := ddFinding{
+			Title:            title,
+			Description:      ddDescription(res, v),
+			Severity:         ddSeverity(v),
+			Date:             date,
+			VulnIDFromTool:   id,
+			UniqueIDFromTool: ddUniqueID(id, endpoint),
+			Endpoints:        []string{endpoint},
+			Active:           true,
+			DynamicFinding:   true,
+		}
+		if strings.HasPrefix(strings.ToUpper(id), "CVE-") {
+			f.CVE = strings.ToUpper(id)
+		}
+		out = append(out, f)
+	}
+	if defectDojoFlagged {
+		for _, flag := range res.Flags {
+			out = append(out, ddFinding{
+				Title:            flag + " on " + host,
+				Description:      fmt.Sprintf("recon-engine flagged %s as %s.\n\nTitle: %s\nStatus: %d\n\n%s", endpoint, flag, res.Title, res.StatusCode, ddFooter(res)),
+				Severity:         "Info",
+				Date:             date,
+				VulnIDFromTool:   "flag-" + flag,
+				UniqueIDFromTool: ddUniqueID("flag-"+flag, endpoint),
+				Endpoints:        []string{endpoint},
+				Active:           true,
+				DynamicFinding:   true,
+			})
+		}
+	}
+	return out
+}
+
+// ddDescription is the finding's summary, then its evidence, then the
+// footer that ties it to the run
+func ddDescription(res Result, f Finding) string {
+	var b strings.Builder
+	if f.Title != "" {
+		b.WriteString(f.Title + "\n\n")
+	}
+	fmt.Fprintf(&b, "stage: %s\n", f.Stage)
+	if f.Confidence != "" {
+		fmt.Fprintf(&b, "confidence: %s\n", f.Confidence)
+	}
+	if f.Reference != "" {
+		fmt.Fprintf(&b, "reference: %s\n", f.Reference)
+	}
+	keys := make([]string, 0, len(f.Evidence))
+	for k := range f.Evidence {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		val := f.Evidence[k]
+		if _, ok := val.(string); !ok {
+			if j, err := json.Marshal(val); err == nil {
+				val = string(j)
+			}
+		}
+		fmt.Fprintf(&b, "%s: %v\n", k, val)
+	}
+	b.WriteString("\n" + ddFooter(res))
+	return b.String()
+}
+
+func ddFooter(res Result) string {
+	return fmt.Sprintf("---\nReported by recon-engine %s, run %s, for %s", version, res.RunID, res.RootDomain)
+}
+
+// ddUniqueID keeps reimports of the same finding on the same endpoint
+// deduplicated in DefectDojo
+func ddUniqueID(id, endpoint string) string {
+	sum := sha256.Sum256([]byte(id + "|" + endpoint))
+	return hex.EncodeToString(sum[:16])
+}
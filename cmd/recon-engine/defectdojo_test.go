@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeDD reads a Generic Findings Import document back
+func decodeDD(t *testing.T, results ...Result) []ddFinding {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := &ddEncoder{w: &buf}
+	for _, res := range results {
+		if err := enc.Encode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Findings []ddFinding `json:"findings"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if doc.Findings == nil {
+		t.Fatalf("no findings array:\n%s", buf.String())
+	}
+	return doc.Findings
+}
+
+func TestDefectDojoImport(t *testing.T) {
+	oldFlagged := defectDojoFlagged
+	t.Cleanup(func() { defectDojoFlagged = oldFlagged })
+	defectDojoFlagged = false
+
+	res := Result{
+		RunID:      "run-1",
+		Subdomain:  "app.example.com",
+		RootDomain: "example.com",
+		URL:        "https://app.example.com",
+		StatusCode: 200,
+		Flags:      []string{"admin-panel"},
+		Vulnerabilities: []Finding{
+			newFinding("nuclei", "cve-2021-44228", "critical", confidenceConfirmed, "Log4Shell", map[string]interface{}{"matched": "https://app.example.com/api"}),
+			newFinding("headers", "missing-hsts", "info", confidenceConfirmed, "No HSTS", nil),
+			newFinding("custom", "odd", "unknown", "", "", nil),
+		},
+	}
+	got := decodeDD(t, res)
+	if len(got) != 3 {
+		t.Fatalf("%d findings, want 3: %+v", len(got), got)
+	}
+	log4j := got[0]
+	if log4j.Severity != "Critical" || log4j.CVE != "CVE-2021-44228" || !log4j.Active || !log4j.DynamicFinding {
+		t.Errorf("log4shell: %+v", log4j)
+	}
+	if len(log4j.Endpoints) != 1 || log4j.Endpoints[0] != res.URL {
+		t.Errorf("endpoints %v", log4j.Endpoints)
+	}
+	if !strings.Contains(log4j.Description, "matched: https://app.example.com/api") || !strings.Contains(log4j.Description, "run run-1") {
+		t.Errorf("description %q", log4j.Description)
+	}
+	if got[1].Severity != "Info" || got[1].CVE != "" {
+		t.Errorf("missing-hsts: %+v", got[1])
+	}
+	// DefectDojo rejects severities it does not know
+	if got[2].Severity != "Info" {
+		t.Errorf("unknown severity mapped to %q", got[2].Severity)
+	}
+
+	// Reimports of the same finding on the same endpoint deduplicate
+	again := decodeDD(t, res)
+	if again[0].UniqueIDFromTool != log4j.UniqueIDFromTool {
+		t.Error("unique_id_from_tool changed between runs")
+	}
+	other := res
+	other.URL = "https://other.example.com"
+	if decodeDD(t, other)[0].UniqueIDFromTool == log4j.UniqueIDFromTool {
+		t.Error("two endpoints share a unique_id_from_tool")
+	}
+
+	defectDojoFlagged = true
+	flagged := decodeDD(t, res)
+	if len(flagged) != 4 || flagged[3].Title != "admin-panel on app.example.com" || flagged[3].VulnIDFromTool != "flag-admin-panel" {
+		t.Errorf("-defectdojo-flagged: %+v", flagged)
+	}
+}
+
+func TestDefectDojoEmpty(t *testing.T) {
+	if got := decodeDD(t, Result{Subdomain: "www.example.com", StatusCode: 200}); len(got) != 0 {
+		t.Errorf("%d findings for a clean host", len(got))
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Change types set on Result.ChangeType in diff mode
+const (
+	changeNew     = "new"
+	changeChanged = "changed"
+	changeVisual  = "visual-change" // only the screenshot changed
+	changeRemoved = "removed"
+)
+
+// diffBaseline holds a previous run's Results keyed by subdomain+port and
+// classifies the current run's Results against it
+type diffBaseline struct {
+	mu   sync.Mutex
+	prev map[string]Result
+	seen map[string]bool
+}
+
+// diffKey identifies a Result across runs; volatile fields such as the
+// timestamp take no part in the comparison
+func diffKey(r Result) string {
+	return r.Subdomain + "|" + strconv.Itoa(r.Port)
+}
+
+// readResults parses a previous output file, either NDJSON or a JSON array.
+// Lines that are not Results (summary or event records) are skipped. A
+// record cut off at the end of the file, as a killed run leaves it, is
+// dropped with a warning, and an array missing its closing bracket is read
+// up to its last whole element. An -encrypt-output file is decrypted.
+func readResults(r io.Reader) ([]Result, error) {
+	br := bufio.NewReader(openDecrypted(r))
+	first, err := br.Peek(1)
+	for err == nil && len(bytes.TrimSpace(first)) == 0 {
+		br.ReadByte()
+		first, err = br.Peek(1)
+	}
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] == '[' {
+		return readResultArray(br)
+	}
+
+	var results []Result
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var probe struct {
+				Type      string `json:"type"`
+				Subdomain string `json:"subdomain"`
+			}
+			if jerr := json.Unmarshal(line, &probe); jerr != nil {
+				if err == io.EOF {
+					fmt.Fprintf(os.Stderr, "Warning: line %d is a truncated record, ignoring it\n", lineNo)
+					return results, nil
+				}
+				return nil, fmt.Errorf("line %d: %w", lineNo, jerr)
+			}
+			if probe.Type == "" && probe.Subdomain != "" {
+				var res Result
+				json.Unmarshal(line, &res)
+				results = append(results, res)
+			}
+		}
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readResultArray reads a JSON array of Results element by element, so a
+// file cut off mid-array still yields the elements before the cut
+func readResultArray(r io.Reader) ([]Result, error) {
+	dec := json.NewDecoder(r)
+	dec.Token() // [
+	var results []Result
+	for dec.More() {
+		var res Result
+		if err := dec.Decode(&res); err != nil {
+			if truncatedJSON(err) {
+				fmt.Fprintf(os.Stderr, "Warning: the JSON array is cut off, read the %d whole elements before the cut\n", len(results))
+				return results, nil
+			}
+			return nil, fmt.Errorf("element %d: %w", len(results)+1, err)
+		}
+		results = append(results, res)
+	}
+	if _, err := dec.Token(); err != nil {
+		if truncatedJSON(err) {
+			fmt.Fprintf(os.Stderr, "Warning: the JSON array is cut off, read the %d whole elements before the cut\n", len(results))
+			return results, nil
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// truncatedJSON reports whether a decoder error means the input ended early.
+// A cut right after a comma is a syntax error rather than io.ErrUnexpectedEOF.
+func truncatedJSON(err error) bool {
+	var se *json.SyntaxError
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &se) && se.Error() == "unexpected end of JSON input"
+}
+
+func loadBaseline(path string) (*diffBaseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	results, err := readResults(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return newBaseline(results), nil
+}
+
+// newBaseline builds a baseline from a previous run's Results
+func newBaseline(results []Result) *diffBaseline {
+	b := &diffBaseline{prev: make(map[string]Result, len(results)), seen: make(map[string]bool)}
+	for _, r := range results {
+		if r.ChangeType == changeRemoved {
+			continue
+		}
+		b.prev[diffKey(r)] = r
+	}
+	return b
+}
+
+// Classify compares res against the baseline, setting ChangeType and
+// Changes. It returns false when nothing relevant changed and the Result
+// should not be emitted.
+func (b *diffBaseline) Classify(res *Result) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := diffKey(*res)
+	prev, ok := b.prev[key]
+	if !ok {
+		// Output written before multi-port probing has no port
+		key = res.Subdomain + "|0"
+		prev, ok = b.prev[key]
+	}
+	if !ok {
+		res.ChangeType = changeNew
+		return true
+	}
+	b.seen[key] = true
+
+	res.Changes = compareResults(prev, *res)
+	if d, changed := visualDistance(prev, *res); changed {
+		res.VisualChange = &VisualChange{Previous: prev.Screenshot, Current: res.Screenshot, Distance: d}
+		res.Changes = append(res.Changes, fmt.Sprintf("visual-change %d/64", d))
+		if len(res.Changes) == 1 {
+			res.ChangeType = changeVisual
+			return true
+		}
+	}
+	if len(res.Changes) == 0 {
+		return false
+	}
+	res.ChangeType = changeChanged
+	return true
+}
+
+// compareResults lists the meaningful differences between two Results
+func compareResults(prev, cur Result) []string {
+	var changes []string
+	if prev.StatusCode != cur.StatusCode {
+		if prev.StatusCode == 0 && cur.StatusCode > 0 {
+			changes = append(changes, "newly_live")
+		}
+		changes = append(changes, fmt.Sprintf("status_code %d -> %d", prev.StatusCode, cur.StatusCode))
+	}
+	known := make(map[string]bool, len(prev.TechStack))
+	// Canonical names, so a -state written before a name was aliased does
+	// not report it as new
+	for _, t := range prev.TechStack {
+		known[techEntry(t)] = true
+	}
+	for _, t := range cur.TechStack {
+		if !known[techEntry(t)] {
+			changes = append(changes, "new_tech "+t)
+		}
+	}
+	found := make(map[string]bool, len(prev.Vulnerabilities))
+	for _, v := range prev.Vulnerabilities {
+		found[v.ID] = true
+	}
+	for _, v := range cur.Vulnerabilities {
+		if !found[v.ID] {
+			found[v.ID] = true
+			changes = append(changes, "new_finding "+v.ID)
+		}
+	}
+	return changes
+}
+
+// Removed returns synthetic records for baseline entries that were not seen
+// in this run, with StatusCode 0 and ChangeType "removed"
+func (b *diffBaseline) Removed() []Result {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.prev))
+	for k := range b.prev {
+		if !b.seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	now := time.Now().Format(time.RFC3339)
+	out := make([]Result, 0, len(keys))
+	for _, k := range keys {
+		r := b.prev[k]
+		r.Timestamp = now
+		r.RunID = runID
+		r.EngineVersion = version
+		r.SchemaVersion = schemaVersion
+		r.StatusCode = 0
+		r.ChangeType = changeRemoved
+		r.Changes = nil
+		if r.Vulnerabilities == nil {
+			r.Vulnerabilities = []Finding{}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// runDiffCommand implements the diff subcommand: it classifies every Result
+// in the new file against the old one and prints the changes as NDJSON
+func runDiffCommand(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff <old-results> <new-results>\n\nPrints new, changed and removed hosts as NDJSON, the same records -diff\nproduces during a scan. Both files may be NDJSON or a JSON array.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	baseline, err := loadBaseline(fs.Arg(0))
+	if err != nil {
+		fatalError("Failed to load old results", err)
+	}
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		fatalError("Failed to open new results", err)
+	}
+	current, err := readResults(f)
+	f.Close()
+	if err != nil {
+		fatalError("Failed to read new results", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, res := range current {
+		if res.ChangeType == changeRemoved {
+			continue
+		}
+		res.ChangeType, res.Changes = "", nil
+		if baseline.Classify(&res) {
+			enc.Encode(res)
+		}
+	}
+	for _, res := range baseline.Removed() {
+		enc.Encode(res)
+	}
+}
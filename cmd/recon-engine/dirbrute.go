@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//go:embed wordlists/directories.txt
+var defaultDirWordlist string
+
+// PathHit is a path -dirbrute found on a host
+type PathHit struct {
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	Length int    `json:"length"`
+}
+
+// dirbruteWords is the wordlist ffuf reads on stdin: -dirbrute-wordlist (or
+// the embedded list) cut to -dirbrute-max-requests entries
+var dirbruteWords string
+
+// configureDirbrute validates the -dirbrute flags and loads the capped
+// wordlist. Called once after flag parsing.
+func configureDirbrute() error {
+	if !dirBrute {
+		return nil
+	}
+	for _, s := range splitList(dirbruteStatus) {
+		if n, err := strconv.Atoi(s); err != nil || n < 100 || n > 599 {
+			return fmt.Errorf("-dirbrute-status: %q is not an HTTP status code", s)
+		}
+	}
+	if dirbruteMaxRequests < 1 {
+		return fmt.Errorf("-dirbrute-max-requests must be at least 1")
+	}
+
+	var words io.Reader = strings.NewReader(defaultDirWordlist)
+	if dirbruteWordlist != "" {
+		f, err := os.Open(dirbruteWordlist)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		words = f
+	}
+	var b strings.Builder
+	n := 0
+	scanner := bufio.NewScanner(words)
+	for scanner.Scan() && n < dirbruteMaxRequests {
+		word := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "/")
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		b.WriteString(word + "\n")
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	dirbruteWords = b.String()
+	return nil
+}
+
+// ffufRate splits -rate-limit between the enrichment workers, which may all
+// be running ffuf at once. With -polite only one does.
+func ffufRate() int {
+	if politeMode {
+		return activeRate()
+	}
+	if rateLimit <= 0 {
+		return 0
+	}
+	return max(rateLimit/max(workers, 1), 1)
+}
+
+// ffufThreads is ffuf's concurrency per host
+func ffufThreads() string {
+	if politeMode {
+		return "1"
+	}
+	return "10"
+}
+
+// ffufArgs builds the ffuf command line for base, a URL without trailing
+// slash. filterSizes, a size range, drops responses of the soft-404 page's
+// length.
+func ffufArgs(base string, filterSizes string) []string {
+	args := []string{"-u", base + "/FUZZ", "-w", "-", "-mc", strings.Join(splitList(dirbruteStatus), ","), "-json", "-s", "-noninteractive", "-t", ffufThreads()}
+	if r := ffufRate(); r > 0 {
+		args = append(args, "-rate", strconv.Itoa(r))
+	}
+	if filterSizes != "" {
+		args = append(args, "-fs", filterSizes)
+	}
+	if u := nextProxy(); u != nil {
+		args = append(args, "-x", u.String())
+	}
+	args = append(args, httpxHeaderArgs()...)
+	return append(args, authToolArgs("-H", base)...)
+}
+
+// allowedWords returns dirbruteWords without the paths base's robots.txt
+// disallows, which -polite leaves alone
+func allowedWords(ctx context.Context, base string) string {
+	if !politeMode {
+		return dirbruteWords
+	}
+	var b strings.Builder
+	for _, word := range strings.SplitAfter(dirbruteWords, "\n") {
+		if word != "" && !robotsDisallowed(ctx, base+"/"+strings.TrimSuffix(word, "\n")) {
+			b.WriteString(word)
+		}
+	}
+	return b.String()
+}
+
+// ffufResult is one line of ffuf -json output
+type ffufResult struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Length int    `json:"length"`
+}
+
+// soft404Filter returns the response sizes ffuf should drop as base's soft
+// 404 page: when its answer to a random path carries a status
+// -dirbrute-status would match, lengths within the jitter of that answer's.
+// Otherwise "".
+func soft404Filter(ctx context.Context, base string) (string, error) {
+	b, err := hostBaseline(ctx, base)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range splitList(dirbruteStatus) {
+		if s == strconv.Itoa(b.Status) {
+			j := soft404Jitter(b.Length)
+			return strconv.Itoa(max(b.Length-j, 0)) + "-" + strconv.Itoa(b.Length+j), nil
+		}
+	}
+	return "", nil
+}
+
+// bruteDirectories runs ffuf against a live host and records the hits in
+// res.Paths. Hosts behind a CDN are skipped; hosts that answer a random path
+// like a real one only report hits whose length differs from that answer by
+// more than dynamic content would.
+func bruteDirectories(ctx context.Context, res *Result) {
+	if res.CDN != "" {
+		return
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	base := u.Scheme + "://" + u.Host
+
+	filterSizes, err := soft404Filter(ctx, base)
+	if err != nil {
+		reportToolError("ffuf", "dirbrute", "", fmt.Errorf("baseline request for %s: %w", base, err))
+		return
+	}
+
+	if err := waitProxy(ctx); err != nil {
+		return
+	}
+	cmd := toolCommand(ctx, toolPath("ffuf"), ffufArgs(base, filterSizes)...)
+	cmd.Stdin = strings.NewReader(allowedWords(ctx, base))
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err := startTool(cmd); err != nil {
+		reportToolError("ffuf", "dirbrute", eventStartFailed, err)
+		return
+	}
+	lines := newLineReader(out, "ffuf")
+	for lines.Next() {
+		var r ffufResult
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			rejectedLine("ffuf", "dirbrute", eventParseFailed, err)
+			continue
+		}
+		if r.URL == "" || r.Status < 100 || r.Status > 599 {
+			rejectedLine("ffuf", "dirbrute", eventInvalid, fmt.Errorf("hit %q with status %d", r.URL, r.Status))
+			continue
+		}
+		if r.Length < 0 {
+			r.Length = 0
+			repairedLine("ffuf")
+		}
+		p := r.URL
+		if hit, err := url.Parse(r.URL); err == nil {
+			p = hit.EscapedPath()
+		}
+		res.Paths = append(res.Paths, PathHit{Path: p, Status: r.Status, Length: r.Length})
+	}
+	if err := waitTool(cmd); err != nil && ctx.Err() == nil {
+		reportToolError("ffuf", "dirbrute", "", err)
+	}
+}
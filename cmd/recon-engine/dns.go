@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultResolvers are the public resolvers native lookups use without
+// -resolvers: Cloudflare, Google, Quad9 and OpenDNS
+var defaultResolvers = []string{
+	"1.1.1.1:53", "1.0.0.1:53", "8.8.8.8:53", "8.8.4.4:53",
+	"9.9.9.9:53", "149.112.112.112:53", "208.67.222.222:53", "208.67.220.220:53",
+}
+
+// dnsResolver answers every native lookup: wildcard detection, -brute,
+// -permute, -ptr, -axfr, scope checks and the enrichers. main replaces it
+// with the -resolvers pool.
+var dnsResolver, _ = newDNSPool("", 2*time.Second, 2)
+
+// dnsPool spreads queries round-robin across a set of resolvers. A query
+// that times out or gets SERVFAIL or REFUSED is retried on the next server;
+// a server that keeps failing is quarantined like a -proxy. Each server's
+// answers and failures are counted in the stats as dns.ok.<server> and
+// dns.errors.<server>. Its methods mirror net.Resolver's.
+type dnsPool struct {
+	servers []string // host:port
+	timeout time.Duration
+	retries int
+	pool    *endpointPool
+}
+
+// newDNSPool builds the pool for -resolvers, a comma-separated list of
+// resolver IPs or IP:ports, files of them (one per line) and "system" for
+// the servers in /etc/resolv.conf; "" means defaultResolvers
+func newDNSPool(list string, timeout time.Duration, retries int) (*dnsPool, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("-dns-timeout must be positive")
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("-dns-retries must not be negative")
+	}
+	p := &dnsPool{timeout: timeout, retries: retries}
+	if list == "" {
+		p.servers = defaultResolvers
+	}
+	for _, item := range splitList(list) {
+		servers, err := resolverServers(item)
+		if err != nil {
+			return nil, err
+		}
+		p.servers = append(p.servers, servers...)
+	}
+	if len(p.servers) == 0 {
+		return nil, fmt.Errorf("%s: no resolvers listed", list)
+	}
+	p.pool = newEndpointPool("resolver", "dns", p.servers)
+	return p, nil
+}
+
+// resolverServers reads one -resolvers entry: a resolver address, a file
+// of them or "system"
+func resolverServers(item string) ([]string, error) {
+	if item == "system" {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, fmt.Errorf("system resolvers: %w", err)
+		}
+		var servers []string
+		for _, s := range conf.Servers {
+			servers = append(servers, net.JoinHostPort(s, conf.Port))
+		}
+		return servers, nil
+	}
+	if s, ok := resolverAddr(item); ok {
+		return []string{s}, nil
+	}
+	return readResolvers(item)
+}
+
+// resolverAddr returns s as host:port when it is an IP or IP:port
+func resolverAddr(s string) (string, bool) {
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(s, "53")
+	}
+	host, _, _ := net.SplitHostPort(s)
+	return s, net.ParseIP(host) != nil
+}
+
+// readResolvers reads a -resolvers file
+func readResolvers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		server, ok := resolverAddr(line)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: invalid resolver %q", path, lineNo, line)
+		}
+		servers = append(servers, server)
+	}
+	return servers, scanner.Err()
+}
+
+// toolArg returns the servers that are not quarantined as the
+// comma-separated list subfinder, httpx and amass take with -r, leaving out
+// the default port. The tools rotate through them themselves.
+func (p *dnsPool) toolArg() string {
+	var list []string
+	for _, i := range p.pool.healthy() {
+		s := p.servers[i]
+		if host, port, _ := net.SplitHostPort(s); port == "53" {
+			s = host
+		}
+		list = append(list, s)
+	}
+	return strings.Join(list, ",")
+}
+
+// exchange sends one question, moving to the next server on timeouts,
+// SERVFAIL and REFUSED, up to -dns-retries times. Truncated UDP answers are
+// asked again over TCP.
+func (p *dnsPool) exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	return p.exchangeMsg(ctx, m)
+}
+
+// exchangeMsg is exchange for a prepared question, e.g. one asking for
+// DNSSEC records
+func (p *dnsPool) exchangeMsg(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	name := strings.TrimSuffix(m.Question[0].Name, ".")
+	udp := &dns.Client{Net: "udp", Timeout: p.timeout, Dialer: egressDialer("udp", p.timeout)}
+	tcp := &dns.Client{Net: "tcp", Timeout: p.timeout, Dialer: egressDialer("tcp", p.timeout)}
+
+	var lastErr error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		i, err := p.pool.pick(ctx)
+		if err != nil {
+			return nil, err
+		}
+		server := p.servers[i]
+		stats.Add("dns.queries", 1)
+		resp, _, err := udp.ExchangeContext(ctx, m, server)
+		if err == nil && resp.Truncated {
+			resp, _, err = tcp.ExchangeContext(ctx, m, server)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil && resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused {
+			p.pool.report(i, nil)
+			return resp, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s from %s", dns.RcodeToString[resp.Rcode], server)
+		}
+		p.pool.report(i, err)
+		lastErr = err
+	}
+	return nil, &net.DNSError{Err: lastErr.Error(), Name: name, IsTemporary: true, IsTimeout: isTimeout(lastErr)}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// answers returns the records of type T in an answer. NXDOMAIN and answers
+// without any are not-found errors, as net.Resolver returns them.
+func answers[T dns.RR](resp *dns.Msg, name string) ([]T, error) {
+	var out []T
+	if resp.Rcode == dns.RcodeSuccess {
+		for _, rr := range resp.Answer {
+			if r, ok := rr.(T); ok {
+				out = append(out, r)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return out, nil
+}
+
+// LookupHost returns the IPv4 and IPv6 addresses of host
+func (p *dnsPool) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	var addrs []string
+	var firstErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		resp, err := p.exchange(ctx, host, qtype)
+		if err != nil {
+			firstErr = cmp.Or(firstErr, err)
+			continue
+		}
+		for _, rr := range resp.Answer {
+			switch r := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, r.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, r.AAAA.String())
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+// LookupAddr returns the PTR names of addr, with a trailing dot
+func (p *dnsPool) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	rev, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr}
+	}
+	resp, err := p.exchange(ctx, rev, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	ptrs, err := answers[*dns.PTR](resp, addr)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(ptrs))
+	for i, r := range ptrs {
+		names[i] = r.Ptr
+	}
+	return names, nil
+}
+
+// LookupCNAME returns the canonical name of host, with a trailing dot: the
+// end of its CNAME chain, or host itself when it has none
+func (p *dnsPool) LookupCNAME(ctx context.Context, host string) (string, error) {
+	resp, err := p.exchange(ctx, host, dns.TypeA)
+	if err != nil {
+		return "", err
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	name := dns.Fqdn(host)
+	for _, rr := range resp.Answer {
+		if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, name) {
+			name = c.Target
+		}
+	}
+	return name, nil
+}
+
+// CNAMEChain returns the names host's CNAME records lead through, in
+// order and without trailing dots; none when it has no CNAME
+func (p *dnsPool) CNAMEChain(ctx context.Context, host string) ([]string, error) {
+	resp, err := p.exchange(ctx, host, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	var chain []string
+	name := dns.Fqdn(host)
+	// Resolvers answer with the whole chain, in any order; a loop ends
+	// once every record was followed
+	for range resp.Answer {
+		next := ""
+		for _, rr := range resp.Answer {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, name) {
+				next = c.Target
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, strings.TrimSuffix(next, "."))
+		name = next
+	}
+	return chain, nil
+}
+
+// LookupTXT returns the TXT records of name, each one's strings joined
+func (p *dnsPool) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	resp, err := p.exchange(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := answers[*dns.TXT](resp, name)
+	if err != nil {
+		return nil, err
+	}
+	txts := make([]string, len(recs))
+	for i, r := range recs {
+		txts[i] = strings.Join(r.Txt, "")
+	}
+	return txts, nil
+}
+
+// LookupNS returns the nameservers of name
+func (p *dnsPool) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	resp, err := p.exchange(ctx, name, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := answers[*dns.NS](resp, name)
+	if err != nil {
+		return nil, err
+	}
+	nss := make([]*net.NS, len(recs))
+	for i, r := range recs {
+		nss[i] = &net.NS{Host: r.Ns}
+	}
+	return nss, nil
+}
+
+// wildcardIPs resolves a few random labels under domain. Any addresses they
+// return are answers the zone gives for every name, so hits resolving only to
+// these addresses are not real hosts.
+func wildcardIPs(ctx context.Context, r *dnsPool, domain string) map[string]bool {
+	ips := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		b := make([]byte, 8)
+		rand.Read(b)
+		addrs, err := r.LookupHost(ctx, hex.EncodeToString(b)+"."+domain)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ips[a] = true
+		}
+	}
+	return ips
+}
+
+// isWildcardHit reports whether every address in addrs is a wildcard answer
+func isWildcardHit(addrs []string, wildcard map[string]bool) bool {
+	if len(wildcard) == 0 {
+		return false
+	}
+	for _, a := range addrs {
+		if !wildcard[a] {
+			return false
+		}
+	}
+	return true
+}
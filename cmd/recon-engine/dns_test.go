@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testResolver is a resolver on a local port. It answers A queries with
+// 192.0.2.1, or with SERVFAIL when failing; a name starting with "big." is
+// truncated over UDP and answered over TCP.
+type testResolver struct {
+	addr    string
+	failing bool
+	queries atomic.Int64
+	tcp     atomic.Int64
+}
+
+func startResolver(t *testing.T, failing bool) *testResolver {
+	t.Helper()
+	r := &testResolver{failing: failing}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.addr = pc.LocalAddr().String()
+	ln, err := net.Listen("tcp", r.addr)
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		r.queries.Add(1)
+		overTCP := w.RemoteAddr().Network() == "tcp"
+		if overTCP {
+			r.tcp.Add(1)
+		}
+		m := new(dns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		switch {
+		case r.failing:
+			m.Rcode = dns.RcodeServerFailure
+		case q.Name == "big.example.com." && !overTCP:
+			m.Truncated = true
+		case q.Qtype == dns.TypeA:
+			rr, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})
+	udp := &dns.Server{PacketConn: pc, Handler: handler}
+	tcp := &dns.Server{Listener: ln, Handler: handler}
+	go udp.ActivateAndServe()
+	go tcp.ActivateAndServe()
+	t.Cleanup(func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	})
+	return r
+}
+
+// TestDNSPoolRetriesNextServer moves a SERVFAIL on to the next resolver
+func TestDNSPoolRetriesNextServer(t *testing.T) {
+	bad, good := startResolver(t, true), startResolver(t, false)
+	p, err := newDNSPool(bad.addr+","+good.addr, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := p.LookupHost(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(addrs, []string{"192.0.2.1"}) {
+		t.Errorf("addrs %v", addrs)
+	}
+	if bad.queries.Load() == 0 || good.queries.Load() == 0 {
+		t.Errorf("%d queries to the failing resolver, %d to the good one", bad.queries.Load(), good.queries.Load())
+	}
+}
+
+func TestDNSPoolGivesUp(t *testing.T) {
+	bad := startResolver(t, true)
+	p, err := newDNSPool(bad.addr, time.Second, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.LookupHost(context.Background(), "www.example.com")
+	dnsErr, ok := err.(*net.DNSError)
+	if !ok || !dnsErr.IsTemporary || dnsErr.IsNotFound {
+		t.Fatalf("err %#v", err)
+	}
+	// A and AAAA, each tried once and retried twice
+	if n := bad.queries.Load(); n != 6 {
+		t.Errorf("%d queries, want 6", n)
+	}
+}
+
+// TestDNSPoolTruncated asks a truncated UDP answer again over TCP
+func TestDNSPoolTruncated(t *testing.T) {
+	r := startResolver(t, false)
+	p, err := newDNSPool(r.addr, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.exchange(context.Background(), "big.example.com", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || r.tcp.Load() != 1 {
+		t.Errorf("%d answers, %d TCP queries", len(resp.Answer), r.tcp.Load())
+	}
+}
+
+func TestNewDNSPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolvers.txt")
+	if err := os.WriteFile(path, []byte("# ours\n10.0.0.53\n\n10.0.0.54:5353\n2001:db8::53\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newDNSPool("192.0.2.53,"+path, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.53:53", "10.0.0.53:53", "10.0.0.54:5353", "[2001:db8::53]:53"}
+	if !slices.Equal(p.servers, want) {
+		t.Errorf("servers %v, want %v", p.servers, want)
+	}
+	// The tools take the list without the default port
+	if got := p.toolArg(); got != "192.0.2.53,10.0.0.53,10.0.0.54:5353,2001:db8::53" {
+		t.Errorf("toolArg %q", got)
+	}
+
+	def, err := newDNSPool("", time.Second, 1)
+	if err != nil || !slices.Equal(def.servers, defaultResolvers) {
+		t.Errorf("default pool %v, %v", def, err)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(bad, []byte("10.0.0.53\nresolver.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		list    string
+		timeout time.Duration
+		retries int
+	}{
+		{bad, time.Second, 1},
+		{"/nonexistent/resolvers.txt", time.Second, 1},
+		{"192.0.2.53", 0, 1},
+		{"192.0.2.53", time.Second, -1},
+	} {
+		if _, err := newDNSPool(c.list, c.timeout, c.retries); err == nil {
+			t.Errorf("newDNSPool(%q, %s, %d) accepted", c.list, c.timeout, c.retries)
+		}
+	}
+}
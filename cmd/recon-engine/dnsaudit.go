@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsAuditTimeout bounds each -dns-audit check, however many queries it
+// takes
+const dnsAuditTimeout = 5 * time.Second
+
+// checkUnknown is the outcome of a check whose lookups failed, so it could
+// neither pass nor fail
+const checkUnknown = "unknown"
+
+// recursionProbeName is asked of the nameservers with recursion desired:
+// one that answers it for a zone it does not serve is an open resolver
+const recursionProbeName = "example.com"
+
+// DNSAudit is what -dns-audit found about the root domain's DNS
+type DNSAudit struct {
+	Domain      string          `json:"domain"`
+	Nameservers []string        `json:"nameservers,omitempty"`
+	SOA         *SOAInfo        `json:"soa,omitempty"`
+	Checks      []DNSAuditCheck `json:"checks"`
+}
+
+// DNSAuditCheck is one -dns-audit check. Status is pass, fail or unknown.
+type DNSAuditCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// SOAInfo holds the timers of the zone's SOA record, in seconds
+type SOAInfo struct {
+	PrimaryNS string `json:"primary_ns"`
+	Serial    uint32 `json:"serial"`
+	Refresh   uint32 `json:"refresh"`
+	Retry     uint32 `json:"retry"`
+	Expire    uint32 `json:"expire"`
+	Minimum   uint32 `json:"minimum"`
+}
+
+// soaBounds are the ranges RFC 1912 section 2.2 and RIPE-203 recommend for
+// the SOA timers
+var soaBounds = []struct {
+	name     string
+	value    func(*SOAInfo) uint32
+	min, max uint32
+}{
+	{"soa-refresh", func(s *SOAInfo) uint32 { return s.Refresh }, 1200, 86400},
+	{"soa-expire", func(s *SOAInfo) uint32 { return s.Expire }, 604800, 2419200},
+	{"soa-minimum", func(s *SOAInfo) uint32 { return s.Minimum }, 300, 86400},
+}
+
+// dnsAuditor collects the checks and the findings they raise
+type dnsAuditor struct {
+	audit    DNSAudit
+	findings []Finding
+}
+
+func (a *dnsAuditor) check(name, status, detail string) {
+	a.audit.Checks = append(a.audit.Checks, DNSAuditCheck{Name: name, Status: status, Detail: detail})
+}
+
+func (a *dnsAuditor) finding(id, severity, detail string) {
+	a.findings = append(a.findings, newFinding("dns-audit", id, severity, confidenceConfirmed, detail, nil))
+}
+
+// auditDNS checks domain's DNSSEC chain, its nameservers (registered,
+// answering authoritatively, not open resolvers, agreeing on the serial)
+// and its SOA timers. Lookups go through -resolvers; only the questions
+// for one nameserver are sent to it directly. A check whose lookups failed
+// is unknown rather than failed.
+func auditDNS(ctx context.Context, domain string) (*DNSAudit, []Finding) {
+	a := &dnsAuditor{audit: DNSAudit{Domain: domain}}
+	a.auditDNSSEC(ctx, domain)
+	serials := a.auditNameservers(ctx, domain)
+	a.auditSOA(ctx, domain, serials)
+	if a.findings == nil {
+		a.findings = []Finding{}
+	}
+	return &a.audit, a.findings
+}
+
+// auditDNSSEC checks that the parent's DS records match a DNSKEY of the
+// zone and that the DNSKEY set is signed by it with a current signature
+func (a *dnsAuditor) auditDNSSEC(ctx context.Context, domain string) {
+	ctx, cancel := context.WithTimeout(ctx, dnsAuditTimeout)
+	defer cancel()
+	zone := dns.Fqdn(domain)
+
+	ds, dsErr := dnssecQuery[*dns.DS](ctx, zone, dns.TypeDS)
+	keys, keysErr := dnssecQuery[*dns.DNSKEY](ctx, zone, dns.TypeDNSKEY)
+	sigs, _ := dnssecQuery[*dns.RRSIG](ctx, zone, dns.TypeDNSKEY)
+	switch {
+	case dsErr != nil || keysErr != nil:
+		a.check("dnssec", checkUnknown, errors.Join(dsErr, keysErr).Error())
+		return
+	case len(ds) == 0 && len(keys) == 0:
+		a.check("dnssec", checkFail, "not signed: no DS at the parent and no DNSKEY")
+		a.finding("dnssec-missing", "low", domain+" is not signed with DNSSEC")
+		return
+	case len(ds) == 0:
+		a.check("dnssec", checkFail, fmt.Sprintf("%d DNSKEY published but no DS at the parent, so nothing validates them", len(keys)))
+		a.finding("dnssec-missing", "low", domain+" publishes DNSKEYs without a DS record at the parent")
+		return
+	case len(keys) == 0:
+		a.check("dnssec", checkFail, "DS at the parent but no DNSKEY in the zone")
+		a.finding("dnssec-broken", "high", domain+" has a DS record but no DNSKEY; validating resolvers cannot resolve it")
+		return
+	}
+
+	rrset := make([]dns.RR, len(keys))
+	for i, k := range keys {
+		rrset[i] = k
+	}
+	for _, d := range ds {
+		for _, k := range keys {
+			if k.KeyTag() != d.KeyTag {
+				continue
+			}
+			if kd := k.ToDS(d.DigestType); kd == nil || !strings.EqualFold(kd.Digest, d.Digest) {
+				continue
+			}
+			for _, sig := range sigs {
+				if sig.TypeCovered != dns.TypeDNSKEY || sig.KeyTag != k.KeyTag() {
+					continue
+				}
+				if !sig.ValidityPeriod(time.Now()) {
+					a.check("dnssec", checkFail, fmt.Sprintf("the DNSKEY signature by key %d expired or is not yet valid", k.KeyTag()))
+					a.finding("dnssec-broken", "high", domain+"'s DNSKEY signature is outside its validity period; validating resolvers cannot resolve it")
+					return
+				}
+				if err := sig.Verify(k, rrset); err == nil {
+					a.check("dnssec", checkPass, fmt.Sprintf("DS %d matches a DNSKEY that signs the key set (%s)", d.KeyTag, dns.AlgorithmToString[k.Algorithm]))
+					return
+				}
+			}
+		}
+	}
+	a.check("dnssec", checkFail, "no DS record matches a DNSKEY with a valid signature over the key set")
+	a.finding("dnssec-broken", "high", domain+"'s DS records match no validly signed DNSKEY; validating resolvers cannot resolve it")
+}
+
+// dnssecQuery asks for qtype records of zone with the DO bit set and
+// returns the T records of the answer. A name without them is not an
+// error, a name that does not exist is.
+func dnssecQuery[T dns.RR](ctx context.Context, zone string, qtype uint16) ([]T, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, qtype)
+	m.SetEdns0(4096, true)
+	resp, err := dnsResolver.exchangeMsg(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	// An empty answer only means unsigned when the name exists
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s: %s", zone, dns.RcodeToString[resp.Rcode])
+	}
+	var out []T
+	for _, rr := range resp.Answer {
+		if r, ok := rr.(T); ok {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// auditNameservers checks each NS of domain: that its name resolves (and
+// if not, whether its domain is registered at all), that it answers for the
+// zone authoritatively and that it does not recurse for anyone. It returns
+// the SOA serial each answering nameserver reported.
+func (a *dnsAuditor) auditNameservers(ctx context.Context, domain string) map[string]uint32 {
+	nsCtx, cancel := context.WithTimeout(ctx, dnsAuditTimeout)
+	nss, err := dnsResolver.LookupNS(nsCtx, domain)
+	cancel()
+	if err != nil {
+		a.check("nameservers", checkUnknown, err.Error())
+		return nil
+	}
+	for _, ns := range nss {
+		a.audit.Nameservers = append(a.audit.Nameservers, strings.ToLower(strings.TrimSuffix(ns.Host, ".")))
+	}
+	sort.Strings(a.audit.Nameservers)
+
+	serials := make(map[string]uint32)
+	for _, ns := range a.audit.Nameservers {
+		if serial, ok := a.auditNameserver(ctx, domain, ns); ok {
+			serials[ns] = serial
+		}
+	}
+	return serials
+}
+
+func (a *dnsAuditor) auditNameserver(ctx context.Context, domain, ns string) (uint32, bool) {
+	ctx, cancel := context.WithTimeout(ctx, dnsAuditTimeout)
+	defer cancel()
+
+	addrs, err := dnsResolver.LookupHost(ctx, ns)
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		parent := registrableDomain(ns)
+		resp, err := dnsResolver.exchange(ctx, parent, dns.TypeNS)
+		switch {
+		case err != nil:
+			a.check("ns-resolves:"+ns, checkFail, "does not resolve; whether "+parent+" is registered is unknown: "+err.Error())
+			a.finding("ns-dangling", "medium", "nameserver "+ns+" of "+domain+" does not resolve")
+		case resp.Rcode == dns.RcodeNameError:
+			a.check("ns-resolves:"+ns, checkFail, parent+" does not exist and may be free to register")
+			a.finding("ns-unregistered-domain", "high", "nameserver "+ns+" of "+domain+" is under "+parent+", which does not exist; whoever registers it controls the delegation")
+		default:
+			a.check("ns-resolves:"+ns, checkFail, "does not resolve")
+			a.finding("ns-dangling", "medium", "nameserver "+ns+" of "+domain+" does not resolve")
+		}
+		return 0, false
+	case err != nil:
+		a.check("ns-resolves:"+ns, checkUnknown, err.Error())
+		return 0, false
+	}
+	a.check("ns-resolves:"+ns, checkPass, strings.Join(addrs, ", "))
+	server := net.JoinHostPort(addrs[0], "53")
+
+	// Authoritative answer for the zone, without recursion
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeSOA)
+	m.RecursionDesired = false
+	resp, err := directExchange(ctx, m, server)
+	var serial uint32
+	answered := false
+	switch {
+	case err != nil && isTimeout(err):
+		a.check("ns-authoritative:"+ns, checkFail, "no answer from "+server)
+		a.finding("ns-unresponsive", "medium", "nameserver "+ns+" of "+domain+" does not answer")
+	case err != nil:
+		a.check("ns-authoritative:"+ns, checkUnknown, err.Error())
+	case resp.Rcode != dns.RcodeSuccess || !resp.Authoritative:
+		a.check("ns-authoritative:"+ns, checkFail, fmt.Sprintf("lame delegation: answered %s without authority", dns.RcodeToString[resp.Rcode]))
+		a.finding("ns-lame", "medium", "nameserver "+ns+" is delegated "+domain+" but does not serve it")
+	default:
+		a.check("ns-authoritative:"+ns, checkPass, "answers for the zone with authority")
+		for _, rr := range resp.Answer {
+			if soa, ok := rr.(*dns.SOA); ok {
+				serial, answered = soa.Serial, true
+			}
+		}
+	}
+
+	// Recursion for a name outside the zone
+	m = new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(recursionProbeName), dns.TypeA)
+	resp, err = directExchange(ctx, m, server)
+	switch {
+	case err != nil && isTimeout(err):
+		a.check("open-recursion:"+ns, checkUnknown, "no answer from "+server)
+	case err != nil:
+		a.check("open-recursion:"+ns, checkUnknown, err.Error())
+	case resp.RecursionAvailable && resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		a.check("open-recursion:"+ns, checkFail, "resolved "+recursionProbeName+" for an outside client")
+		a.finding("dns-open-recursion", "medium", "nameserver "+ns+" of "+domain+" is an open resolver, usable for amplification and cache poisoning")
+	default:
+		a.check("open-recursion:"+ns, checkPass, "refused to recurse ("+dns.RcodeToString[resp.Rcode]+")")
+	}
+	return serial, answered
+}
+
+// directExchange sends m to one nameserver, once, within -dns-timeout
+func directExchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: dnsResolver.timeout, Dialer: egressDialer("udp", dnsResolver.timeout)}
+	stats.Add("dns.queries", 1)
+	resp, _, err := c.ExchangeContext(ctx, m, server)
+	if err == nil && resp.Truncated {
+		c.Net, c.Dialer = "tcp", egressDialer("tcp", dnsResolver.timeout)
+		resp, _, err = c.ExchangeContext(ctx, m, server)
+	}
+	return resp, err
+}
+
+// auditSOA checks the SOA timers against the recommended ranges and that
+// the nameservers agree on the serial
+func (a *dnsAuditor) auditSOA(ctx context.Context, domain string, serials map[string]uint32) {
+	ctx, cancel := context.WithTimeout(ctx, dnsAuditTimeout)
+	defer cancel()
+	resp, err := dnsResolver.exchange(ctx, domain, dns.TypeSOA)
+	var soas []*dns.SOA
+	if err == nil {
+		soas, err = answers[*dns.SOA](resp, domain)
+	}
+	if err != nil {
+		a.check("soa", checkUnknown, err.Error())
+		return
+	}
+	s := soas[0]
+	info := &SOAInfo{
+		PrimaryNS: strings.TrimSuffix(s.Ns, "."),
+		Serial:    s.Serial,
+		Refresh:   s.Refresh,
+		Retry:     s.Retry,
+		Expire:    s.Expire,
+		Minimum:   s.Minttl,
+	}
+	a.audit.SOA = info
+
+	for _, b := range soaBounds {
+		v := b.value(info)
+		if v < b.min || v > b.max {
+			a.check(b.name, checkFail, fmt.Sprintf("%d is outside the recommended %d to %d", v, b.min, b.max))
+		} else {
+			a.check(b.name, checkPass, fmt.Sprint(v))
+		}
+	}
+	if info.Retry >= info.Refresh {
+		a.check("soa-retry", checkFail, fmt.Sprintf("%d is not below refresh (%d)", info.Retry, info.Refresh))
+	} else {
+		a.check("soa-retry", checkPass, fmt.Sprint(info.Retry))
+	}
+
+	switch {
+	case len(serials) < 2:
+		a.check("soa-serial-sync", checkUnknown, fmt.Sprintf("%d nameserver(s) reported a serial", len(serials)))
+	default:
+		var parts []string
+		distinct := make(map[uint32]bool)
+		for _, ns := range a.audit.Nameservers {
+			if serial, ok := serials[ns]; ok {
+				distinct[serial] = true
+				parts = append(parts, fmt.Sprintf("%s=%d", ns, serial))
+			}
+		}
+		if len(distinct) > 1 {
+			a.check("soa-serial-sync", checkFail, "nameservers disagree: "+strings.Join(parts, ", "))
+		} else {
+			a.check("soa-serial-sync", checkPass, fmt.Sprintf("%d nameservers on serial %d", len(serials), s.Serial))
+		}
+	}
+}
+
+// dnsAuditResult is the -dns-audit-record record
+func dnsAuditResult(target string, audit *DNSAudit, findings []Finding) Result {
+	return Result{
+		RunID:           runID,
+		RootDomain:      target,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Subdomain:       audit.Domain,
+		TechStack:       []string{},
+		Vulnerabilities: findings,
+		Source:          "dns-audit",
+		DNSAudit:        audit,
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDockerImages are the images -use-docker runs missing tools from,
+// pinned to the versions the engine is tested with. Tools without one
+// (ffuf, masscan) need an image from -docker-images.
+var defaultDockerImages = map[string]string{
+	"subfinder": "projectdiscovery/subfinder:v2.6.6",
+	"httpx":     "projectdiscovery/httpx:v1.6.0",
+	"naabu":     "projectdiscovery/naabu:v2.3.0",
+	"tlsx":      "projectdiscovery/tlsx:v1.1.6",
+	"amass":     "caffix/amass:v4.2.0",
+	"nmap":      "instrumentisto/nmap:7.94",
+	"whatweb":   "urbanadventurer/whatweb:0.5.5",
+}
+
+// dockerPathArgs are the options whose value is a file on the host, by
+// tool. The file's directory is mounted at the same path, read-write for
+// outputs (true) and read-only for inputs. Nothing else is mounted.
+var dockerPathArgs = map[string]map[string]bool{
+	"nmap":      {"-oN": true},
+	"amass":     {"-json": true, "-config": false},
+	"subfinder": {"-provider-config": false},
+}
+
+var (
+	dockerImages = make(map[string]string) // tool -> image, defaults plus -docker-images
+
+	// containerized maps the tools that are run through docker to their
+	// image. Filled in by checkBinaries before any tool starts.
+	containerized = make(map[string]string)
+)
+
+// configureDocker parses -docker-images. Called once after flag parsing.
+func configureDocker() error {
+	for tool, image := range defaultDockerImages {
+		dockerImages[tool] = image
+	}
+	for _, kv := range splitList(dockerImagesFlag) {
+		tool, image, ok := strings.Cut(kv, "=")
+		if !ok || image == "" {
+			return fmt.Errorf("%q: want tool=image", kv)
+		}
+		if !slices.Contains(externalTools, tool) {
+			return fmt.Errorf("%q: unknown tool %s", kv, tool)
+		}
+		dockerImages[tool] = image
+	}
+	if useDocker && runtime.GOOS == "windows" {
+		return fmt.Errorf("-use-docker mounts host paths into Linux containers and is not supported on Windows")
+	}
+	return nil
+}
+
+// containerize runs tool from its image from now on. It returns false when
+// no image is configured for it.
+func containerize(tool string) bool {
+	image := dockerImages[tool]
+	if image == "" {
+		return false
+	}
+	containerized[tool] = image
+	return true
+}
+
+// checkDocker verifies the docker daemon answers and pulls the images of the
+// containerized tools that are not present yet, so the first tool run is not
+// spent downloading
+func checkDocker() error {
+	if err := dockerReachable(); err != nil {
+		return err
+	}
+	for tool, image := range containerized {
+		if exec.Command("docker", "image", "inspect", image).Run() == nil {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Pulling %s for %s\n", image, tool)
+		pull := exec.Command("docker", "pull", image)
+		pull.Stdout, pull.Stderr = os.Stderr, os.Stderr
+		if err := pull.Run(); err != nil {
+			return fmt.Errorf("pulling %s: %w", image, err)
+		}
+	}
+	return nil
+}
+
+func dockerReachable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker daemon not reachable: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// dockerCommand returns the docker command line running tool with args in
+// its container. stdin and stdout are passed through (-i), --init forwards
+// the SIGTERM stopChildren sends, and the host network keeps probing and
+// -proxy on localhost working as they do natively.
+func dockerCommand(tool, image string, args []string) []string {
+	run := []string{"run", "--rm", "-i", "--init", "--network", "host"}
+	args = slices.Clone(args)
+	writes := false
+	for i := 0; i+1 < len(args); i++ {
+		writable, ok := dockerPathArgs[tool][args[i]]
+		if !ok || args[i+1] == "-" {
+			continue
+		}
+		p, err := filepath.Abs(args[i+1])
+		if err != nil {
+			continue
+		}
+		args[i+1] = p
+		mount := filepath.Dir(p) + ":" + filepath.Dir(p)
+		if !writable {
+			mount += ":ro"
+		}
+		writes = writes || writable
+		run = append(run, "-v", mount)
+	}
+	if writes {
+		// Output files belong to the user, not root
+		run = append(run, "--user", strconv.Itoa(os.Getuid())+":"+strconv.Itoa(os.Getgid()))
+	}
+	run = append(run, image)
+	return append(run, args...)
+}
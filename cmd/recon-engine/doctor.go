@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// doctorTimeout bounds each network check
+const doctorTimeout = 15 * time.Second
+
+// Check outcomes
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// doctorCheck is one line of the doctor report. Required checks are the
+// ones the given flags depend on; only those make doctor exit non-zero.
+type doctorCheck struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Required bool   `json:"required"`
+	Detail   string `json:"detail"`
+}
+
+// apiKeyCheck describes how to test one API key with a request that costs
+// little or no quota
+type apiKeyCheck struct {
+	name  string // as in apiKeyEnv
+	probe func(ctx context.Context) (*http.Response, error)
+}
+
+var apiKeyChecks = []apiKeyCheck{
+	{"censys", func(ctx context.Context) (*http.Response, error) {
+		// The v1 account endpoint is free; v2 has no equivalent
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(censysAPIBase, "/v2")+"/v1/account", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(secret("CENSYS_API_ID"), secret("CENSYS_API_SECRET"))
+		return sourceHTTP.Do(req)
+	}},
+	{"securitytrails", func(ctx context.Context) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, securityTrailsAPIBase+"/ping", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("APIKEY", secret("SECURITYTRAILS_API_KEY"))
+		return sourceHTTP.Do(req)
+	}},
+	{"chaos", func(ctx context.Context) (*http.Response, error) {
+		// Without /subdomains Chaos only returns the count
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, chaosAPIBase+"/example.com", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", secret("CHAOS_API_KEY"))
+		return sourceHTTP.Do(req)
+	}},
+	{"virustotal", func(ctx context.Context) (*http.Response, error) {
+		// One request of the free tier's per-minute allowance
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, virusTotalAPIBase+"/domains/example.com", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-apikey", secret("VT_API_KEY"))
+		return sourceHTTP.Do(req)
+	}},
+	{"dnsdb", func(ctx context.Context) (*http.Response, error) {
+		// The rate limit endpoint spends no quota
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, dnsdbAPIBase+"/rate_limit", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-API-Key", secret("DNSDB_API_KEY"))
+		return sourceHTTP.Do(req)
+	}},
+	{"circl", func(ctx context.Context) (*http.Response, error) {
+		// One query of the account's allowance
+		req, err := circlRequest(ctx, "circl.lu")
+		if err != nil {
+			return nil, err
+		}
+		return sourceHTTP.Do(req)
+	}},
+	{"nvd", func(ctx context.Context) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, nvdAPIBase+"?cveId=CVE-2021-44228", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("apiKey", secret("NVD_API_KEY"))
+		return sourceHTTP.Do(req)
+	}},
+	{"whoisxml", func(ctx context.Context) (*http.Response, error) {
+		// The balance endpoint spends no credits
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://user.whoisxmlapi.com/user-service/account-balance?apiKey="+url.QueryEscape(secret("WHOISXML_API_KEY")), nil)
+		if err != nil {
+			return nil, err
+		}
+		return sourceHTTP.Do(req)
+	}},
+}
+
+// runDoctor checks the tools, API keys and connectivity a scan with the
+// given flags needs, prints the results and exits 1 when a required check
+// failed. target, when given, is resolved in the DNS check.
+func runDoctor(target string, sources []string) {
+	if outputFormat != "" && outputFormat != "json" {
+		startupError("Invalid -format", fmt.Errorf("doctor prints a table or, with -format json, JSON; got %q", outputFormat))
+	}
+
+	var checks []doctorCheck
+	checks = append(checks, toolChecks(requiredBinaries(sources))...)
+	for _, k := range apiKeyChecks {
+		// NVD works without a key, only slower
+		required := slices.Contains(sources, k.name) || (k.name == "censys" && censysEnrich)
+		checks = append(checks, checkAPIKey(k, required))
+	}
+	checks = append(checks, checkDNS(target), checkHTTPS())
+
+	for i := range checks {
+		checks[i].Detail = redactSecrets(checks[i].Detail)
+	}
+	if err := printChecks(os.Stdout, checks); err != nil {
+		fatalError("Failed to print checks", err)
+	}
+	for _, c := range checks {
+		if c.Required && c.Status == checkFail {
+			exit(1)
+		}
+	}
+	exit(0)
+}
+
+// toolChecks looks up every known tool and its version. Tools the flags do
+// not need only ever warn. With -use-docker a missing tool passes when the
+// docker daemon answers.
+func toolChecks(required []string) []doctorCheck {
+	var dockerErr error
+	if useDocker {
+		dockerErr = dockerReachable()
+	}
+	var checks []doctorCheck
+	for _, tool := range externalTools {
+		c := doctorCheck{Category: "tool", Name: tool, Required: slices.Contains(required, tool)}
+		path := toolPath(tool)
+		resolved, err := exec.LookPath(path)
+		switch {
+		case err != nil && path != tool:
+			c.Detail = fmt.Sprintf("%s is not executable, check -%s-bin / %s", path, tool, toolEnv(tool))
+		case err != nil:
+			c.Detail = "not found in PATH"
+		}
+		if err != nil && useDocker && path == tool && dockerImages[tool] != "" {
+			c.Status, c.Detail = checkPass, "runs in "+dockerImages[tool]+" (-use-docker)"
+			if dockerErr != nil {
+				c.Status, c.Detail = checkFail, c.Detail+", but "+dockerErr.Error()
+			}
+			checks = append(checks, c)
+			continue
+		}
+		if err != nil {
+			c.Status = checkFail
+			if !c.Required {
+				c.Status = checkWarn
+				c.Detail += " (not needed with these flags)"
+			}
+			checks = append(checks, c)
+			continue
+		}
+
+		spec := toolVersions[tool]
+		v := toolVersion(tool)
+		switch {
+		case v == "":
+			c.Status, c.Detail = checkWarn, fmt.Sprintf("%s, version not recognised (need %s)", resolved, spec.min)
+		case compareVersions(v, spec.min) < 0:
+			c.Status, c.Detail = checkWarn, fmt.Sprintf("%s %s is older than the required %s", resolved, v, spec.min)
+			if strictVersions && c.Required {
+				c.Status = checkFail
+			}
+		default:
+			c.Status, c.Detail = checkPass, resolved+" "+v
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+func checkAPIKey(k apiKeyCheck, required bool) doctorCheck {
+	c := doctorCheck{Category: "api-key", Name: k.name, Required: required}
+	if missing := missingKeys(k.name); len(missing) > 0 {
+		c.Status, c.Detail = checkWarn, strings.Join(missing, ", ")+" not set"
+		if required {
+			c.Status = checkFail
+		}
+		return c
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	resp, err := k.probe(ctx)
+	if err != nil {
+		c.Status, c.Detail = checkFail, err.Error()
+		return c
+	}
+	resp.Body.Close()
+	switch {
+	// 404 only means the probe's example domain is unknown to the source
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound:
+		c.Status, c.Detail = checkPass, "key accepted"
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		c.Status, c.Detail = checkFail, fmt.Sprintf("key rejected (HTTP %d)", resp.StatusCode)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusPaymentRequired:
+		c.Status, c.Detail = checkWarn, fmt.Sprintf("key accepted but the quota is exhausted (HTTP %d)", resp.StatusCode)
+	default:
+		c.Status, c.Detail = checkWarn, "unexpected status "+resp.Status
+	}
+	return c
+}
+
+// checkDNS resolves target, or example.com without one, through the
+// configured resolvers
+func checkDNS(target string) doctorCheck {
+	name := target
+	if name == "" {
+		name = "example.com"
+	}
+	c := doctorCheck{Category: "connectivity", Name: "dns", Required: true}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	addrs, err := dnsResolver.LookupHost(ctx, name)
+	if err != nil {
+		c.Status, c.Detail = checkFail, err.Error()
+		return c
+	}
+	c.Status, c.Detail = checkPass, fmt.Sprintf("%s resolves to %s", name, strings.Join(addrs, ", "))
+	return c
+}
+
+// checkHTTPS makes an outbound HTTPS request the way the enrichers do,
+// through -proxy when it is set
+func checkHTTPS() doctorCheck {
+	c := doctorCheck{Category: "connectivity", Name: "https", Required: true}
+	via := ""
+	if proxyURL != nil {
+		c.Name = "https-proxy"
+		via = " via " + proxyURL.Redacted()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://example.com/", nil)
+	if err != nil {
+		c.Status, c.Detail = checkFail, err.Error()
+		return c
+	}
+	resp, err := newHTTPClient(doctorTimeout, false).Do(req)
+	if err != nil {
+		c.Status, c.Detail = checkFail, err.Error()+via
+		return c
+	}
+	resp.Body.Close()
+	c.Status, c.Detail = checkPass, "https://example.com/ answered "+resp.Status+via
+	return c
+}
+
+// printChecks writes the checks as a table, or JSON with -format json
+func printChecks(w io.Writer, checks []doctorCheck) error {
+	if outputFormat == "json" {
+		return json.NewEncoder(w).Encode(struct {
+			Type   string        `json:"type"`
+			Checks []doctorCheck `json:"checks"`
+		}{"doctor", checks})
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCATEGORY\tCHECK\tDETAIL")
+	for _, c := range checks {
+		name := c.Name
+		if c.Required {
+			name += " *"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.ToUpper(c.Status), c.Category, name, c.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, "\n* needed by these flags; a failure here exits 1")
+	return err
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// registrableDomain returns the eTLD+1 of host, or host itself when it has
+// none (IPs, single labels)
+func registrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	d, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return d
+}
+
+// sameRegistrableDomain reports whether host belongs to the same registrable
+// domain as target
+func sameRegistrableDomain(host, target string) bool {
+	return registrableDomain(host) == registrableDomain(target)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// domainSummary is one root domain of a multi-domain run in the run
+// summary
+type domainSummary struct {
+	Results  int    `json:"results"`
+	Live     int    `json:"live"`
+	Findings int    `json:"findings"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// sharedNames, when set, is the dedupe store every pipeline of the run
+// shares instead of opening its own
+var sharedNames nameSet
+
+// lockedNameSet makes a nameSet safe for the pipelines running at once
+type lockedNameSet struct {
+	mu  sync.Mutex
+	set nameSet
+}
+
+func (s *lockedNameSet) Add(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.Add(name)
+}
+
+func (s *lockedNameSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.Close()
+}
+
+// observeDomain counts res against its root domain in the run summary.
+// Only multi-domain runs break the counts down.
+func observeDomain(res Result) {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	d := summary.Domains[res.RootDomain]
+	if d == nil {
+		return
+	}
+	d.Results++
+	if res.StatusCode > 0 {
+		d.Live++
+	}
+	d.Findings += len(res.Vulnerabilities)
+}
+
+// runTargets scans each target with scan, up to -parallel-domains at once.
+// The pipelines share the dedupe store, the -workers budget, the rate
+// limiters and the output; each runs its own discovery. A target whose
+// pipeline fails is recorded in the summary and the others go on. It
+// returns the first error only when every target failed.
+func runTargets(ctx context.Context, targets []string, scan func(string) error) error {
+	if len(targets) == 1 {
+		return scan(targets[0])
+	}
+	summary.mu.Lock()
+	summary.Domains = make(map[string]*domainSummary, len(targets))
+	for _, t := range targets {
+		summary.Domains[t] = &domainSummary{}
+	}
+	summary.mu.Unlock()
+
+	set, err := newNameSet()
+	if err != nil {
+		return fmt.Errorf("failed to create dedupe store: %w", err)
+	}
+	sharedNames = &lockedNameSet{set: set}
+	defer func() {
+		sharedNames.Close()
+		sharedNames = nil
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		failed   int
+	)
+	slots := make(chan struct{}, max(parallelDomains, 1))
+	for _, t := range targets {
+		// Caps and signals stop new domains from starting; running ones
+		// wind down through their own contexts
+		slots <- struct{}{}
+		if ctx.Err() != nil || len(trippedCaps()) > 0 {
+			<-slots
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Scanning %s\n", t)
+		wg.Add(1)
+		go func(t string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := time.Now()
+			err := scan(t)
+			summary.mu.Lock()
+			d := summary.Domains[t]
+			d.Duration = time.Since(start).Round(time.Second).String()
+			if err != nil {
+				d.Error = err.Error()
+			}
+			summary.mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Scan of %s failed: %v\n", t, err)
+				mu.Lock()
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	if failed == len(targets) {
+		return firstErr
+	}
+	return nil
+}
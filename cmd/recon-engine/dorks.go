@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const githubAPIBase = "https://api.github.com"
+
+// githubSearchPerMinute is GitHub's code search limit for an authenticated
+// user
+const githubSearchPerMinute = 10
+
+// Caps on what goes into the dork list, so a large estate does not bury the
+// domain-wide dorks under thousands of per-host ones
+const (
+	dorksMaxHosts = 25
+	dorksMaxTech  = 15
+)
+
+// dorkTechGoogle holds Google dorks that find a technology's exposed pages
+// better than its name does, keyed on canonical technology name
+var dorkTechGoogle = map[string]string{
+	"jenkins":    `intitle:"Dashboard [Jenkins]"`,
+	"grafana":    `intitle:"Grafana" inurl:login`,
+	"kibana":     `inurl:app/kibana`,
+	"gitlab":     `intitle:"Sign in · GitLab"`,
+	"jira":       `inurl:secure/Dashboard.jspa`,
+	"confluence": `inurl:dosearchsite.action`,
+	"phpmyadmin": `intitle:"phpMyAdmin"`,
+	"wordpress":  `inurl:wp-content OR inurl:wp-admin`,
+	"tomcat":     `intitle:"Apache Tomcat" inurl:manager`,
+	"sonarqube":  `intitle:"SonarQube"`,
+	"prometheus": `intitle:"Prometheus Time Series Collection"`,
+	"portainer":  `intitle:"Portainer"`,
+}
+
+// dorkIgnoredTech are technologies too common to say anything about the
+// organisation
+var dorkIgnoredTech = map[string]bool{
+	"nginx": true, "apache": true, "microsoft-iis": true, "openresty": true, "varnish": true,
+	"cloudflare": true, "amazon-cloudfront": true, "amazon-elb": true, "amazon-s3": true, "aws": true,
+	"akamai": true, "fastly": true, "imperva": true, "sucuri": true,
+	"jquery": true, "jquery-migrate": true, "jquery-ui": true, "bootstrap": true, "font-awesome": true,
+	"google-fonts": true, "google-analytics": true, "google-tag-manager": true, "recaptcha": true,
+	"modernizr": true, "lodash": true, "react": true, "vue.js": true, "angular": true, "angularjs": true,
+	"hsts": true, "http3": true, "php": true, "java": true, "python": true, "perl": true, "ruby": true,
+	"openssl": true, "ubuntu": true, "debian": true, "centos": true, "red-hat": true, "windows-server": true,
+}
+
+// Dork is one search query -dorks generated
+type Dork struct {
+	Engine string `json:"engine"` // github or google
+	Query  string `json:"query"`
+	Hits   *int   `json:"hits,omitempty"` // GitHub code search total_count, with GITHUB_TOKEN
+}
+
+// dorksSummary reports the -dorks file in the run summary
+type dorksSummary struct {
+	Path     string `json:"path"`
+	GitHub   int    `json:"github"`
+	Google   int    `json:"google"`
+	Searched int    `json:"searched,omitempty"` // GitHub dorks run against the API
+	Top      []Dork `json:"top,omitempty"`      // the GitHub dorks with the most hits
+}
+
+// dorkIndex collects the assets of a run dorks are generated from
+type dorkIndex struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+	tech  map[string]bool
+}
+
+var dorkAssets = &dorkIndex{hosts: make(map[string]bool), tech: make(map[string]bool)}
+
+// Observe records res's host when it is notable, flagged or named like a
+// non-production environment, and the technologies of live hosts
+func (x *dorkIndex) Observe(res Result) {
+	if res.Subdomain == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(res.Flags) > 0 || nonProductionName(res.Subdomain) {
+		x.hosts[strings.ToLower(res.Subdomain)] = true
+	}
+	if res.StatusCode > 0 {
+		for _, t := range res.TechStack {
+			if name, _, _ := strings.Cut(techEntry(t), ":"); name != "" {
+				x.tech[name] = true
+			}
+		}
+	}
+}
+
+// assets returns the recorded hosts and technologies, sorted
+func (x *dorkIndex) assets() (hosts, tech []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for h := range x.hosts {
+		hosts = append(hosts, h)
+	}
+	for t := range x.tech {
+		tech = append(tech, t)
+	}
+	sort.Strings(hosts)
+	sort.Strings(tech)
+	return hosts, tech
+}
+
+// nonProductionName reports whether a host name has a label or label part
+// like dev, uat, staging or internal, the hosts most likely to be mentioned
+// in leaked code
+func nonProductionName(host string) bool {
+	for _, label := range strings.Split(strings.ToLower(host), ".") {
+		for _, part := range strings.FieldsFunc(label, func(r rune) bool { return r == '-' || r == '_' }) {
+			switch part = strings.TrimRight(part, "0123456789"); part {
+			case "prod", "production":
+			case "internal", "intranet", "corp", "vpn", "jenkins", "ci", "git", "admin":
+				return true
+			default:
+				if contains(envWords, part) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// dorkOrg is the organisation name dorks pair technologies with: the
+// registrant org -whois found, or else the root domain's first label
+func dorkOrg(root string, whois *WhoisInfo) string {
+	if whois != nil && whois.RegistrantOrg != "" && !strings.Contains(strings.ToLower(whois.RegistrantOrg), "redacted") {
+		return whois.RegistrantOrg
+	}
+	label, _, _ := strings.Cut(root, ".")
+	return label
+}
+
+// generateDorks returns the GitHub code search and Google dorks for a root
+// domain, the notable hosts found under it and the technologies they run.
+// At most dorksMaxHosts hosts and dorksMaxTech technologies, less the
+// common ones, are used in the order given; duplicate queries are dropped.
+func generateDorks(root, org string, hosts, tech []string) []Dork {
+	var dorks []Dork
+	seen := make(map[string]bool)
+	add := func(engine, query string) {
+		if key := engine + "\x00" + query; !seen[key] {
+			seen[key] = true
+			dorks = append(dorks, Dork{Engine: engine, Query: query})
+		}
+	}
+	q := quoteDork(root)
+
+	// GitHub code search: where the domain shows up next to secrets
+	add("github", q)
+	for _, term := range []string{"password", "secret", "api_key", "token", "filename:.env", "filename:.npmrc _auth", "filename:id_rsa", "extension:pem private", "jdbc:", "smtp"} {
+		add("github", q+" "+term)
+	}
+	if len(hosts) > dorksMaxHosts {
+		hosts = hosts[:dorksMaxHosts]
+	}
+	for _, h := range hosts {
+		if h != root {
+			add("github", quoteDork(h))
+		}
+	}
+	var searched []string
+	for _, t := range tech {
+		if !dorkIgnoredTech[t] && len(searched) < dorksMaxTech {
+			searched = append(searched, t)
+		}
+	}
+	for _, t := range searched {
+		add("github", quoteDork(org)+" "+t)
+	}
+
+	// Google: exposed files and pages on the domain, and mentions elsewhere
+	site := "site:" + root
+	add("google", site+" -site:www."+root)
+	add("google", site+" ext:env OR ext:log OR ext:sql OR ext:bak OR ext:conf OR ext:ini")
+	add("google", site+" ext:pdf OR ext:doc OR ext:docx OR ext:xls OR ext:xlsx confidential OR internal")
+	add("google", site+` intitle:"index of"`)
+	add("google", site+" inurl:login OR inurl:admin OR inurl:signin OR inurl:dashboard")
+	add("google", site+` "sql syntax" OR "stack trace" OR "warning: mysqli"`)
+	add("google", site+" inurl:swagger OR inurl:api-docs OR inurl:graphql")
+	for _, other := range []string{"github.com", "gitlab.com", "pastebin.com", "trello.com", "stackoverflow.com"} {
+		add("google", "site:"+other+" "+q)
+	}
+	for _, h := range hosts {
+		if h != root {
+			add("google", "site:"+h)
+		}
+	}
+	for _, t := range searched {
+		if d, ok := dorkTechGoogle[t]; ok {
+			add("google", site+" "+d)
+		} else {
+			add("google", site+" "+quoteDork(t))
+		}
+	}
+	return dorks
+}
+
+// writeDorks generates the dorks for the run's target, runs the GitHub ones
+// when GITHUB_TOKEN is set, writes them to -dorks and records them in the
+// summary
+func writeDorks(ctx context.Context, target string) {
+	root := registrableDomain(targetHost(target))
+	hosts, tech := dorkAssets.assets()
+	dorks := generateDorks(root, dorkOrg(root, summary.Whois), hosts, tech)
+
+	s := &dorksSummary{Path: dorksPath}
+	if token := secret("GITHUB_TOKEN"); token != "" {
+		s.Searched = searchGitHubDorks(ctx, token, dorks)
+		coverageAdd("github_dorks", s.Searched)
+	}
+	coverageAdd("dorks", len(dorks))
+	for _, d := range dorks {
+		if d.Engine == "github" {
+			s.GitHub++
+			if d.Hits != nil && *d.Hits > 0 {
+				s.Top = append(s.Top, d)
+			}
+		} else {
+			s.Google++
+		}
+	}
+	sort.SliceStable(s.Top, func(i, j int) bool { return *s.Top[i].Hits > *s.Top[j].Hits })
+	if len(s.Top) > 10 {
+		s.Top = s.Top[:10]
+	}
+
+	if err := saveDorks(dorksPath, root, dorks); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -dorks: %v\n", err)
+		return
+	}
+	summary.Dorks = s
+}
+
+// saveDorks writes one dork per line, grouped by engine, with the hit count
+// after a tab when there is one
+func saveDorks(path, root string, dorks []Dork) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# Dorks for %s\n", root)
+	for _, engine := range []string{"github", "google"} {
+		if engine == "github" {
+			fmt.Fprintf(w, "\n# GitHub code search (https://github.com/search?type=code&q=...)\n")
+		} else {
+			fmt.Fprintf(w, "\n# Google\n")
+		}
+		for _, d := range dorks {
+			if d.Engine != engine {
+				continue
+			}
+			if d.Hits != nil {
+				fmt.Fprintf(w, "%s\t%d\n", d.Query, *d.Hits)
+			} else {
+				fmt.Fprintln(w, d.Query)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// searchGitHubDorks runs the GitHub dorks through the code search API,
+// setting their Hits, and returns how many it ran. Requests are spaced to
+// stay inside githubSearchPerMinute; when GitHub still answers that the
+// limit is used up, the search waits for its reset once, up to a minute,
+// and otherwise stops.
+func searchGitHubDorks(ctx context.Context, token string, dorks []Dork) int {
+	interval := time.Minute / githubSearchPerMinute
+	n := 0
+	for i := range dorks {
+		if dorks[i].Engine != "github" {
+			continue
+		}
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return n
+			case <-time.After(interval):
+			}
+		}
+		hits, wait, err := githubCodeSearch(ctx, token, dorks[i].Query)
+		if wait > 0 && wait <= time.Minute {
+			select {
+			case <-ctx.Done():
+				return n
+			case <-time.After(wait):
+			}
+			hits, wait, err = githubCodeSearch(ctx, token, dorks[i].Query)
+		}
+		if wait > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: GitHub code search rate limit reached, %d dorks left without hit counts\n", countEngine(dorks[i:], "github"))
+			return n
+		}
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, errBudgetExhausted) {
+				return n
+			}
+			reportToolError("dorks", "github", "", err)
+			return n
+		}
+		dorks[i].Hits = &hits
+		n++
+	}
+	return n
+}
+
+// githubCodeSearch returns the total_count of a code search. When GitHub
+// refuses for the rate limit it returns how long until the limit resets
+// instead.
+func githubCodeSearch(ctx context.Context, token, query string) (int, time.Duration, error) {
+	endpoint := githubAPIBase + "/search/code?per_page=1&q=" + url.QueryEscape(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if err := apiRequest("github"); err != nil {
+		return 0, 0, err
+	}
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var body struct {
+			TotalCount int `json:"total_count"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return 0, 0, err
+		}
+		return body.TotalCount, 0, nil
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		if resp.StatusCode == http.StatusTooManyRequests || resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "" {
+			return 0, githubRateLimitWait(resp.Header), nil
+		}
+		return 0, 0, fmt.Errorf("GitHub rejected the token (HTTP %d), check GITHUB_TOKEN", resp.StatusCode)
+	case http.StatusUnauthorized:
+		return 0, 0, fmt.Errorf("GitHub rejected the token (HTTP %d), check GITHUB_TOKEN", resp.StatusCode)
+	default:
+		return 0, 0, fmt.Errorf("github: unexpected status %s", resp.Status)
+	}
+}
+
+// githubRateLimitWait reads how long GitHub asks to wait from Retry-After or
+// X-RateLimit-Reset, at least a second
+func githubRateLimitWait(h http.Header) time.Duration {
+	if s, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		return max(time.Duration(s)*time.Second, time.Second)
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return max(time.Until(time.Unix(reset, 0)), time.Second)
+	}
+	return time.Minute
+}
+
+// quoteDork makes s an exact phrase in a search query
+func quoteDork(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+func countEngine(dorks []Dork, engine string) int {
+	n := 0
+	for _, d := range dorks {
+		if d.Engine == engine {
+			n++
+		}
+	}
+	return n
+}
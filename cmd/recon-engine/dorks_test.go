@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dorkQueries(dorks []Dork, engine string) []string {
+	var out []string
+	for _, d := range dorks {
+		if d.Engine == engine {
+			out = append(out, d.Query)
+		}
+	}
+	return out
+}
+
+func TestGenerateDorks(t *testing.T) {
+	hosts := []string{"example.com", "jenkins.example.com", "staging-api.example.com"}
+	tech := []string{"grafana", "jquery", "nginx", "wordpress"}
+	dorks := generateDorks("example.com", "Example Corp", hosts, tech)
+
+	seen := make(map[string]bool)
+	for _, d := range dorks {
+		key := d.Engine + " " + d.Query
+		if seen[key] {
+			t.Errorf("duplicate dork %s", key)
+		}
+		seen[key] = true
+	}
+	github, google := dorkQueries(dorks, "github"), dorkQueries(dorks, "google")
+	for _, want := range []string{
+		`"example.com"`,
+		`"example.com" filename:.env`,
+		`"jenkins.example.com"`,
+		`"staging-api.example.com"`,
+		`"Example Corp" grafana`,
+		`"Example Corp" wordpress`,
+	} {
+		if !slices.Contains(github, want) {
+			t.Errorf("no GitHub dork %s", want)
+		}
+	}
+	for _, want := range []string{
+		`site:example.com intitle:"index of"`,
+		`site:pastebin.com "example.com"`,
+		`site:staging-api.example.com`,
+		`site:example.com intitle:"Grafana" inurl:login`,
+		`site:example.com inurl:wp-content OR inurl:wp-admin`,
+	} {
+		if !slices.Contains(google, want) {
+			t.Errorf("no Google dork %s", want)
+		}
+	}
+	// The root is dorked once, and common technologies not at all
+	if slices.Contains(google, "site:example.com") {
+		t.Error("the root domain got a per-host dork")
+	}
+	for _, q := range append(github, google...) {
+		if strings.Contains(q, "nginx") || strings.Contains(q, "jquery") {
+			t.Errorf("dork on a common technology: %s", q)
+		}
+	}
+}
+
+func TestGenerateDorksCaps(t *testing.T) {
+	var hosts, tech []string
+	for i := 0; i < dorksMaxHosts*2; i++ {
+		hosts = append(hosts, fmt.Sprintf("dev%d.example.com", i))
+		tech = append(tech, fmt.Sprintf("app%d", i))
+	}
+	dorks := generateDorks("example.com", "example", hosts, tech)
+	perHost, perTech := 0, 0
+	for _, q := range dorkQueries(dorks, "github") {
+		switch {
+		case strings.HasPrefix(q, `"dev`):
+			perHost++
+		case strings.HasPrefix(q, `"example" app`):
+			perTech++
+		}
+	}
+	if perHost != dorksMaxHosts || perTech != dorksMaxTech {
+		t.Errorf("%d host and %d technology dorks, want %d and %d", perHost, perTech, dorksMaxHosts, dorksMaxTech)
+	}
+}
+
+func TestNonProductionName(t *testing.T) {
+	for host, want := range map[string]bool{
+		"dev.example.com":         true,
+		"api-staging.example.com": true,
+		"uat2.example.com":        true,
+		"vpn.example.com":         true,
+		"www.example.com":         false,
+		"prod.example.com":        false,
+		"developer.example.com":   false,
+	} {
+		if got := nonProductionName(host); got != want {
+			t.Errorf("nonProductionName(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestDorkOrg(t *testing.T) {
+	if got := dorkOrg("example.com", &WhoisInfo{RegistrantOrg: "Example Corp"}); got != "Example Corp" {
+		t.Errorf("org %q", got)
+	}
+	if got := dorkOrg("example.co.uk", &WhoisInfo{RegistrantOrg: "REDACTED FOR PRIVACY"}); got != "example" {
+		t.Errorf("redacted org gave %q", got)
+	}
+	if got := dorkOrg("example.com", nil); got != "example" {
+		t.Errorf("no whois gave %q", got)
+	}
+}
+
+func TestGitHubRateLimitWait(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "30")
+	if got := githubRateLimitWait(h); got != 30*time.Second {
+		t.Errorf("Retry-After 30: %s", got)
+	}
+	h = http.Header{}
+	h.Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(-time.Minute).Unix()))
+	if got := githubRateLimitWait(h); got != time.Second {
+		t.Errorf("a reset in the past: %s", got)
+	}
+	if got := githubRateLimitWait(http.Header{}); got != time.Minute {
+		t.Errorf("no headers: %s", got)
+	}
+}
+
+func TestSaveDorks(t *testing.T) {
+	hits := 3
+	path := filepath.Join(t.TempDir(), "dorks.txt")
+	err := saveDorks(path, "example.com", []Dork{
+		{Engine: "google", Query: "site:example.com"},
+		{Engine: "github", Query: `"example.com"`, Hits: &hits},
+		{Engine: "github", Query: `"example.com" smtp`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Dorks for example.com\n\n# GitHub code search (https://github.com/search?type=code&q=...)\n\"example.com\"\t3\n\"example.com\" smtp\n\n# Google\nsite:example.com\n"
+	if string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
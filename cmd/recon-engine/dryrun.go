@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dryRunPlaceholderURL stands in for each probed host in per-host commands
+const dryRunPlaceholderURL = "https://HOST"
+
+// dryRunIPv6 stands in for the IPv6 addresses port scanners are given
+const dryRunIPv6 = "IPv6..."
+
+// plannedStep is one entry of the -dry-run plan
+type plannedStep struct {
+	Stage   string   `json:"stage"`
+	Name    string   `json:"name"`
+	Command []string `json:"command,omitempty"` // external tool invocation
+	Native  string   `json:"native,omitempty"`  // work the engine does itself
+	Stdin   string   `json:"stdin,omitempty"`
+}
+
+// nativeSourceEndpoints describes the API sources for the plan
+var nativeSourceEndpoints = map[string]string{
+	"censys":         "GET " + censysAPIBase + "/certificates/search (CENSYS_API_ID, CENSYS_API_SECRET)",
+	"securitytrails": "GET " + securityTrailsAPIBase + "/domain/{domain}/subdomains (SECURITYTRAILS_API_KEY)",
+	"virustotal":     "GET " + virusTotalAPIBase + "/domains/{domain}/subdomains (VT_API_KEY)",
+	"chaos":          "GET " + chaosAPIBase + "/{domain}/subdomains (CHAOS_API_KEY)",
+	"crtsh":          "GET https://crt.sh/?output=json&q=%.{domain}",
+	"brute":          "DNS lookups of wordlist names under {domain}",
+	"axfr":           "AXFR of {domain} from each of its nameservers",
+	"dnsdb":          "GET " + dnsdbAPIBase + "/lookup/rrset/name/*.{domain}/ANY (DNSDB_API_KEY)",
+	"circl":          "GET " + circlAPIBase + "/query/{domain} and the names under it the records mention (CIRCL_PDNS_USER, CIRCL_PDNS_PASSWORD)",
+}
+
+// planSteps lists what a run against target would do, in execution order
+func planSteps(target string, sources []string) []plannedStep {
+	var steps []plannedStep
+	ipRange, u := targetRange(target), targetURL(target)
+	switch {
+	case ipRange.IsValid():
+		steps = append(steps, plannedStep{Stage: "discovery", Name: "ip-range", Native: "the addresses of " + target + " that -scope allows, at most " + strconv.Itoa(maxIPs)})
+	case u != nil:
+		steps = append(steps, plannedStep{Stage: "discovery", Name: "url", Native: u.String() + ", as given"})
+	}
+	for _, name := range sources {
+		step := plannedStep{Stage: "discovery", Name: name}
+		switch name {
+		case "subfinder":
+			step.Command = append([]string{toolPath("subfinder")}, subfinderArgs(target)...)
+		case "amass":
+			args := amassArgs(target)
+			if amassMajor() < 4 {
+				args = append(args, "-json", "TEMPFILE")
+			}
+			step.Command = append([]string{toolPath("amass")}, args...)
+		default:
+			step.Native = strings.ReplaceAll(nativeSourceEndpoints[name], "{domain}", target)
+		}
+		steps = append(steps, step)
+	}
+	if recursive {
+		steps = append(steps, plannedStep{Stage: "discovery", Name: "recursion", Native: "the sources above for each intermediate domain of discovered names, " + strconv.Itoa(recursionDepth) + " levels deep"})
+	}
+	if permute {
+		steps = append(steps, plannedStep{Stage: "discovery", Name: "permute", Native: "DNS lookups of permutations of discovered names"})
+	}
+	if ptrSweep {
+		steps = append(steps, plannedStep{Stage: "discovery", Name: "ptr", Native: "DNS lookups of discovered names, then PTR lookups of their addresses"})
+	}
+
+	probeStdin, portscanHosts, nmapHosts := "discovered names, one per line", "IPs of live hosts", []string{targetHost(target)}
+	// The port scanners' addresses, IPv6 ones with -ip-version 6 or an
+	// IPv6 range, as those are scanned in runs of their own
+	scanIPs := []string{"IP..."}
+	if ipVersion == ipVersion6 || ipRange.Addr().Is6() {
+		scanIPs = []string{dryRunIPv6}
+	}
+	switch {
+	case u != nil:
+		probeStdin = u.String()
+	case ipRange.IsValid():
+		probeStdin, portscanHosts, nmapHosts = "addresses of the range, one per line", "addresses of the range before probing", scanIPs
+		if portscanMode == "hosts" {
+			probeStdin = "ip:port for each open port, one per line"
+		}
+	}
+	steps = append(steps, probeStep("probe", probeStdin))
+	switch {
+	case portscanMode == "root":
+		steps = append(steps, plannedStep{Stage: "portscan", Name: "nmap", Command: append([]string{toolPath("nmap")}, nmapArgs(nmapHosts...)...)})
+	case portscanEngine == "masscan":
+		steps = append(steps, plannedStep{Stage: "portscan", Name: "masscan", Command: append([]string{toolPath("masscan")}, masscanArgs(scanIPs)...)})
+		if masscanServices {
+			steps = append(steps, plannedStep{Stage: "portscan", Name: "nmap", Command: append([]string{toolPath("nmap")}, nmapServiceArgs("PORTS", scanIPs)...)})
+		}
+	case portscanEngine == "naabu":
+		steps = append(steps, plannedStep{Stage: "portscan", Name: "naabu", Command: append([]string{toolPath("naabu")}, naabuArgs(scanIPs)...), Stdin: portscanHosts + ", up to 256 per run"})
+	default:
+		steps = append(steps, plannedStep{Stage: "portscan", Name: "nmap", Command: append([]string{toolPath("nmap")}, hostNmapArgs(scanIPs)...)})
+	}
+
+	if mailCheck {
+		steps = append(steps, plannedStep{Stage: "mail", Name: "mail-check", Native: "TXT lookups of " + target + ", _dmarc." + target + " and " + strconv.Itoa(len(dkimSelectors)) + " DKIM selectors under _domainkey." + target})
+	}
+
+	if whoisLookup {
+		steps = append(steps, plannedStep{Stage: "whois", Name: "rdap", Native: "GET " + rdapBootstrap + registrableDomain(targetHost(target)) + ", falling back to WHOIS on port 43 via " + whoisIANA})
+	}
+
+	if dnsAudit {
+		steps = append(steps, plannedStep{Stage: "dns-audit", Name: "dns-audit", Native: "DS, DNSKEY, NS and SOA lookups of " + registrableDomain(targetHost(target)) + ", then SOA and " + recursionProbeName + " A queries sent to each nameserver"})
+	}
+
+	for _, c := range authContexts {
+		var creds []string
+		for _, name := range sortedKeys(c.Headers) {
+			creds = append(creds, name+" from $"+c.Headers[name])
+		}
+		for _, name := range sortedKeys(c.Cookies) {
+			creds = append(creds, "cookie "+name+" from $"+c.Cookies[name])
+		}
+		native := "GET " + dryRunPlaceholderURL + " again for hosts matching " + strings.Join(c.Hosts, ", ") + " with " + strings.Join(creds, ", ")
+		if canary := c.canary(); canary != "" {
+			native += "; at startup, GET " + canary + " with and without them"
+		}
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "auth", Native: native})
+	}
+	if len(parkedRules) > 0 {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "parked", Native: "NS lookup of each root domain; GET " + dryRunPlaceholderURL + " for 2xx responses titled with nothing or the domain"})
+	}
+	if followRedirectsFlag {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "redirects", Native: "GET " + dryRunPlaceholderURL + " for 3xx responses, following up to 10 hops"})
+	}
+	if thirdPartyCheck {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "third_party", Native: "CNAME lookup of each live host"})
+	}
+	if accessCheck {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "access", Native: "GET " + dryRunPlaceholderURL + " for 400, 401, 403 and 407 responses; HEAD " + dryRunPlaceholderURL + "/ over HTTPS offering no client certificate"})
+	}
+	if soft404Flag || dirBrute {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "soft404", Native: "GET " + dryRunPlaceholderURL + "/{random}"})
+	}
+	switch {
+	case asnDBPath != "":
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "asn", Native: "lookup in " + asnDBPath})
+	case asnmapPath != "":
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "asn", Command: []string{asnmapPath, "-i", "IP", "-json", "-silent"}})
+	}
+	if geoIPPath != "" {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "geoip", Native: "lookup in " + geoIPPath})
+	}
+	if censysEnrich {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "censys", Native: "GET " + censysAPIBase + "/hosts/{ip} (CENSYS_API_ID, CENSYS_API_SECRET)"})
+	}
+	if historicalIPsFlag {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "historical_ips", Native: "passive DNS history of each host, from the dnsdb and circl sources or looked up"})
+	}
+	if collectRobotsFlag {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "robots", Native: "GET " + dryRunPlaceholderURL + "/robots.txt, /sitemap.xml and the sitemaps it lists"})
+	}
+	if securityTxtFlag {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "security.txt", Native: "GET " + dryRunPlaceholderURL + "/.well-known/security.txt, falling back to /security.txt"})
+	}
+	if corsCheck {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "cors", Native: "GET " + dryRunPlaceholderURL + "/ with Origin: " + corsForeignOrigin + ", https://evil.TARGET and null"})
+	}
+	if headerAudit {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "headers", Native: "grade httpx's response headers; for 3xx, GET the URL the redirects end on"})
+	}
+	if cookieAudit {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "cookies", Native: "GET " + dryRunPlaceholderURL + "/ without following redirects"})
+	}
+	if envBody && envRulesUseBody() {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "environment", Native: "GET " + dryRunPlaceholderURL + " for hosts the hostname and title leave unclassified"})
+	}
+	if certCheck {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "cert", Native: "HEAD " + dryRunPlaceholderURL + "/ over HTTPS, reading the certificate unverified"})
+	}
+	if jarmFlag {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "jarm", Command: append([]string{toolPath("tlsx")}, tlsxArgs("HOST:443")...)})
+	}
+	if bucketCheck {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "bucket", Native: "CNAME lookup, GET " + dryRunPlaceholderURL + "/ and, for storage-backed hosts, an anonymous bucket listing and a one-byte object read"})
+	}
+	if dirBrute {
+		steps = append(steps,
+			plannedStep{Stage: "enrich", Name: "ffuf", Command: append([]string{toolPath("ffuf")}, ffufArgs(strings.TrimSuffix(dryRunPlaceholderURL, "/"), "")...), Stdin: "wordlist"},
+		)
+	}
+	if whatwebFingerprinting() {
+		args := append([]string{"--aggression", fmt.Sprint(wwAggression), "--format=json"}, whatwebPluginArgs()...)
+		args = append(args, whatwebProxyArgs()...)
+		args = append(args, whatwebHeaderArgs()...)
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "whatweb", Command: append(append([]string{toolPath("whatweb")}, args...), dryRunPlaceholderURL)})
+	}
+	if nativeFingerprinting() {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "fingerprint", Native: "GET " + dryRunPlaceholderURL + ", following redirects, matched against " + strconv.Itoa(len(techFingerprints)) + " Wappalyzer fingerprints"})
+	}
+	if defaultCreds {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "default-creds", Native: "at most " + strconv.Itoa(defaultCredsMax) + " logins to " + dryRunPlaceholderURL + " for products in its tech stack (" + strconv.Itoa(len(credChecks)) + " in the table after -default-creds-deny)"})
+	}
+	if screenshotsFlag {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "screenshot", Command: []string{toolPath("chromium"), "--headless=new", fmt.Sprintf("--window-size=%d,%d", screenshotWidth, screenshotHeight), "--screenshot=" + filepath.Join(screenshotDir, "HOST_PORT", "TIME.png"), dryRunPlaceholderURL}})
+	}
+	if archiveBodies {
+		which := "flagged hosts"
+		if archiveAll {
+			which = "every live host"
+		}
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "archive", Native: "GET " + dryRunPlaceholderURL + " of " + which + ", the body gzipped to " + filepath.Join(workdir, archiveDir, "HOST_PORT", "SHA256.gz")})
+	}
+	if cveLookup {
+		steps = append(steps, plannedStep{Stage: "enrich", Name: "cve", Native: "GET " + nvdAPIBase + "?virtualMatchString=cpe:2.3:a:{vendor}:{product}:{version} (NVD_API_KEY, cached in " + cveCachePath + ")"})
+	}
+	if asnExpand != "" {
+		steps = append(steps,
+			plannedStep{Stage: "asn-sweep", Name: "ripestat", Native: "GET " + ripeStatAPIBase + "/announced-prefixes/data.json?resource=" + asnExpand + " (at most " + strconv.Itoa(asnExpandMaxIPs) + " addresses)"},
+			probeStep("asn-sweep", "announced IPv4 addresses not already seen, one per line"),
+		)
+	}
+	if vhostProbe {
+		steps = append(steps, plannedStep{Stage: "vhost", Name: "vhost", Native: "GET https://IP/ of each live host's address with each other in-scope name as the Host header, at most " + strconv.Itoa(vhostMaxPairs) + " pairs"})
+	}
+	if dorksPath != "" {
+		step := plannedStep{Stage: "output", Name: "dorks", Native: "write " + dorksPath}
+		if secret("GITHUB_TOKEN") != "" {
+			step.Native += ", GET " + githubAPIBase + "/search/code?q={dork} per GitHub dork (GITHUB_TOKEN, " + strconv.Itoa(githubSearchPerMinute) + "/min)"
+		}
+		steps = append(steps, step)
+	}
+	if graphPath != "" {
+		steps = append(steps, plannedStep{Stage: "output", Name: "graph", Native: "write " + graphPath})
+	}
+	if exportBurpPath != "" {
+		steps = append(steps, plannedStep{Stage: "output", Name: "export-burp", Native: "write " + exportBurpPath})
+	}
+	if exportURLsPath != "" {
+		steps = append(steps, plannedStep{Stage: "output", Name: "export-urls", Native: "write " + exportURLsPath})
+	}
+	if webhookURL != "" {
+		steps = append(steps, plannedStep{Stage: "output", Name: "webhook", Native: "POST " + redactURL(webhookURL)})
+	}
+	if ownerWebhooks {
+		steps = append(steps, plannedStep{Stage: "output", Name: "webhook", Native: "POST the -owners webhook of each result's owner"})
+	}
+	if emailTo != "" {
+		steps = append(steps, plannedStep{Stage: "output", Name: "email", Native: "SMTP " + net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort)) + " to " + emailTo + " when the run ends"})
+	}
+	if jiraURL != "" {
+		steps = append(steps, plannedStep{Stage: "output", Name: "jira", Native: "POST " + redactURL(jiraURL) + "/rest/api/2/issue per finding at or above " + jiraMinSeverity + " (deduplicated via " + jiraCachePath + " and JQL)"})
+	}
+
+	for i := range steps {
+		for j, a := range steps[i].Command {
+			steps[i].Command[j] = redactArg(a)
+		}
+	}
+	return steps
+}
+
+var secretHeader = regexp.MustCompile(`(?i)^(authorization|proxy-authorization|cookie|x-api-key|[\w-]*(token|secret|key))\s*:`)
+
+// redactArg hides credentials in a command line argument: configured
+// secrets, header values that look like secrets and userinfo in URLs
+func redactArg(a string) string {
+	a = redactSecrets(a)
+	if m := secretHeader.FindStringSubmatch(a); m != nil {
+		return strings.TrimSpace(a[:strings.IndexByte(a, ':')]) + ": REDACTED"
+	}
+	if strings.Contains(a, "://") {
+		return redactURL(a)
+	}
+	return a
+}
+
+// redactURL hides the userinfo and query string of a URL, which is where
+// proxy and webhook credentials usually live, and Slack webhook paths,
+// which are secrets themselves
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	if u.Host == "hooks.slack.com" {
+		u.Path = "/services/REDACTED"
+	}
+	return u.String()
+}
+
+// printPlan writes the -dry-run plan as text, or JSON with -format json
+func printPlan(w io.Writer, target string, steps []plannedStep, requests []apiEstimate) error {
+	if outputFormat == "json" {
+		return json.NewEncoder(w).Encode(struct {
+			Type        string        `json:"type"`
+			Target      string        `json:"target"`
+			Steps       []plannedStep `json:"steps"`
+			APIRequests []apiEstimate `json:"api_requests,omitempty"`
+		}{"plan", target, steps, requests})
+	}
+	fmt.Fprintf(w, "Planned run for %s:\n", target)
+	for i, s := range steps {
+		fmt.Fprintf(w, "%2d. [%s] %s\n", i+1, s.Stage, s.Name)
+		if len(s.Command) > 0 {
+			fmt.Fprintf(w, "      $ %s\n", shellJoin(s.Command))
+		}
+		if s.Native != "" {
+			fmt.Fprintf(w, "      %s\n", s.Native)
+		}
+		if s.Stdin != "" {
+			fmt.Fprintf(w, "      stdin: %s\n", s.Stdin)
+		}
+	}
+	if len(requests) > 0 {
+		fmt.Fprintln(w, "Estimated API requests:")
+		for _, e := range requests {
+			fmt.Fprintf(w, "  %-15s %s\n", e.Provider, e)
+		}
+	}
+	return nil
+}
+
+// shellJoin quotes arguments that need it so the line can be pasted into sh
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'$`\\*?;&|<>()") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// runDryRun prints the plan, with the API requests a run against domains
+// root domains would send, and exits
+func runDryRun(target string, sources []string, domains int) {
+	if err := printPlan(os.Stdout, target, planSteps(target, sources), estimateAPIRequests(domains, sources)); err != nil {
+		fatalError("Failed to print plan", err)
+	}
+	exit(0)
+}
+
+// probeStep is the -probe-engine prober of stage, sent stdin
+func probeStep(stage, stdin string) plannedStep {
+	if probeEngine == probeEngineNative {
+		return plannedStep{Stage: stage, Name: "native", Native: "GET https:// then http:// of each name (" + nativeTimeout.String() + " timeout, " + strconv.Itoa(nativeMaxRedirects) + " redirects followed)", Stdin: stdin}
+	}
+	return plannedStep{Stage: stage, Name: "httpx", Command: append([]string{toolPath("httpx")}, httpxArgs()...), Stdin: stdin}
+}
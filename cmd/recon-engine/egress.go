@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+)
+
+// egressTools are the tools that can be bound to -source-ip; every other
+// tool's traffic leaves through the default route
+var egressTools = map[string]bool{"nmap": true, "naabu": true, "masscan": true}
+
+// egressSummary states the address recon traffic left from, for reports
+// that document what the client allowlisted
+type egressSummary struct {
+	SourceIP  string `json:"source_ip"`
+	Interface string `json:"interface"`
+	// Unbound are the tools that could not be bound to the address
+	Unbound []string `json:"unbound,omitempty"`
+}
+
+var (
+	// egressIP is the -source-ip, or -interface's address; invalid when
+	// traffic takes the default route
+	egressIP    netip.Addr
+	egressIface string
+)
+
+// configureEgress checks -source-ip and -interface against the local
+// interfaces and binds the native HTTP clients to the address. Called once
+// after flag parsing, before configureHTTP clones the default transport.
+func configureEgress() error {
+	if sourceIPFlag == "" && interfaceFlag == "" {
+		return nil
+	}
+	var want netip.Addr
+	if sourceIPFlag != "" {
+		a, err := netip.ParseAddr(sourceIPFlag)
+		if err != nil {
+			return fmt.Errorf("-source-ip %q is not an IP address", sourceIPFlag)
+		}
+		want = a.Unmap()
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, iface := range ifaces {
+		if interfaceFlag != "" && iface.Name != interfaceFlag {
+			continue
+		}
+		found = true
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(ipnet.IP)
+			ip = ip.Unmap()
+			if want.IsValid() {
+				if ip == want {
+					egressIP, egressIface = ip, iface.Name
+				}
+				continue
+			}
+			// -interface alone binds to its IPv4 address, else its first
+			// routable one
+			if ip.IsLinkLocalUnicast() {
+				continue
+			}
+			if !egressIP.IsValid() || !egressIP.Is4() && ip.Is4() {
+				egressIP, egressIface = ip, iface.Name
+			}
+		}
+	}
+	switch {
+	case interfaceFlag != "" && !found:
+		return fmt.Errorf("-interface %s does not exist", interfaceFlag)
+	case want.IsValid() && !egressIP.IsValid() && interfaceFlag != "":
+		return fmt.Errorf("-source-ip %s is not assigned to -interface %s", want, interfaceFlag)
+	case want.IsValid() && !egressIP.IsValid():
+		return fmt.Errorf("-source-ip %s is not assigned to a local interface", want)
+	case !egressIP.IsValid():
+		return fmt.Errorf("-interface %s has no usable address", interfaceFlag)
+	}
+
+	t := http.DefaultTransport.(*http.Transport)
+	t.DialContext = egressDial(30 * time.Second)
+	if proxyFlag == "" {
+		insecureTransports = []http.RoundTripper{newInsecureTransport(nil)}
+	}
+	summary.Egress = &egressSummary{SourceIP: egressIP.String(), Interface: egressIface}
+	return nil
+}
+
+// warnUnboundTools names the tools among bins whose traffic -source-ip
+// cannot pin, and lists them in the run summary
+func warnUnboundTools(bins []string) {
+	if !egressIP.IsValid() {
+		return
+	}
+	for _, bin := range bins {
+		if egressTools[bin] || slices.Contains(summary.Egress.Unbound, bin) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s cannot be bound to -source-ip %s, its traffic takes the default route\n", bin, egressIP)
+		summary.Egress.Unbound = append(summary.Egress.Unbound, bin)
+	}
+}
+
+// egressDialer returns a dialer for network bound to -source-ip, or an
+// unbound one. The names it dials are looked up from the address too.
+func egressDialer(network string, timeout time.Duration) *net.Dialer {
+	d := boundDialer(network, timeout)
+	if egressIP.IsValid() {
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return boundDialer(network, timeout).DialContext(ctx, network, addr)
+			},
+		}
+	}
+	return d
+}
+
+func boundDialer(network string, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if !egressIP.IsValid() {
+		return d
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+		d.LocalAddr = &net.UDPAddr{IP: egressIP.AsSlice()}
+	default:
+		d.LocalAddr = &net.TCPAddr{IP: egressIP.AsSlice()}
+	}
+	return d
+}
+
+// egressDial is a DialContext bound to -source-ip
+func egressDial(timeout time.Duration) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := egressDialer(network, timeout)
+		d.KeepAlive = 30 * time.Second
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// egressArgs returns the options binding tool to -source-ip
+func egressArgs(tool string) []string {
+	if !egressIP.IsValid() {
+		return nil
+	}
+	switch tool {
+	case "nmap":
+		return []string{"-S", egressIP.String(), "-e", egressIface}
+	case "naabu":
+		return []string{"-source-ip", egressIP.String(), "-interface", egressIface}
+	case "masscan":
+		return []string{"--source-ip", egressIP.String(), "--adapter", egressIface}
+	}
+	return nil
+}
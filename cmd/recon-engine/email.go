@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exitEmailFailed is returned when -email-required is set, the report could
+// not be sent and no CI gate tripped
+const exitEmailFailed = 5
+
+// smtpTimeout bounds the whole SMTP conversation
+const smtpTimeout = 2 * time.Minute
+
+// emailMaxScreenshotPairs bounds the -screenshots visual changes whose
+// screenshots are attached
+const emailMaxScreenshotPairs = 10
+
+var (
+	emailRecipients []string
+	emailResults    []Result // what was emitted, for the report
+	emailResultsMu  sync.Mutex
+)
+
+// configureEmail validates the -email-* and -smtp-* flags. Called once
+// after flag parsing; the server is first contacted when the run ends.
+func configureEmail() error {
+	if emailTo == "" {
+		if emailRequired {
+			return fmt.Errorf("-email-required needs -email-to")
+		}
+		return nil
+	}
+	if monitor {
+		return fmt.Errorf("-email-to sends one report per run, not with -monitor")
+	}
+	if smtpHost == "" {
+		return fmt.Errorf("-email-to needs -smtp-host")
+	}
+	if smtpPort <= 0 || smtpPort > 65535 {
+		return fmt.Errorf("-smtp-port %d out of range", smtpPort)
+	}
+	for _, to := range splitList(emailTo) {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("-email-to %q: %w", to, err)
+		}
+		emailRecipients = append(emailRecipients, addr.Address)
+	}
+	if emailFrom == "" {
+		if u := os.Getenv("SMTP_USERNAME"); strings.Contains(u, "@") {
+			emailFrom = u
+		} else {
+			return fmt.Errorf("-email-from is required unless SMTP_USERNAME is an address")
+		}
+	}
+	if _, err := mail.ParseAddress(emailFrom); err != nil {
+		return fmt.Errorf("-email-from %q: %w", emailFrom, err)
+	}
+	return nil
+}
+
+// collectEmail keeps res for the emailed report
+func collectEmail(res Result) {
+	if emailRecipients == nil || knownQuiet(res) || parkedQuiet(res) {
+		return
+	}
+	emailResultsMu.Lock()
+	emailResults = append(emailResults, res)
+	emailResultsMu.Unlock()
+}
+
+// sendReport emails the run summary with the HTML report and, with
+// -email-results, the gzipped NDJSON results attached. Called once the
+// summary is written. It returns false when the message could not be sent.
+func sendReport(target string) bool {
+	if emailRecipients == nil {
+		return true
+	}
+	emailResultsMu.Lock()
+	results := emailResults
+	emailResultsMu.Unlock()
+
+	msg, err := reportMessage(target, results)
+	if err == nil {
+		err = sendMail(msg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Emailing the report failed: %v\n", err)
+		reportToolError("email", "output", "", err)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Emailed the report to %s\n", strings.Join(emailRecipients, ", "))
+	return true
+}
+
+// reportMessage builds the MIME message: the summary as text, then the
+// attachments
+func reportMessage(target string, results []Result) ([]byte, error) {
+	data := newReportData(target, results)
+	data.Coverage = summary.coverage()
+
+	var report bytes.Buffer
+	if err := htmlReport.Execute(&report, data); err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Recon run %s for %s finished.\n\n", runID, target)
+	fmt.Fprintf(&text, "%d hosts, %d live, %d with findings.\n", data.Total, data.Live, data.WithFindings)
+	shots := data.Visual
+	if len(shots) > emailMaxScreenshotPairs {
+		shots = shots[:emailMaxScreenshotPairs]
+	}
+	if len(data.Visual) > 0 {
+		fmt.Fprintf(&text, "%d hosts look different from the previous run; the screenshots of %d are attached.\n", len(data.Visual), len(shots))
+	}
+
+	var ndjson []byte
+	if emailResultsFlag {
+		var err error
+		if ndjson, err = gzipResults(results); err != nil {
+			return nil, err
+		}
+		if int64(len(ndjson)) > emailMaxResults {
+			fmt.Fprintf(&text, "\nThe results (%d bytes gzipped) exceed -email-max-results and are not attached.\n", len(ndjson))
+			ndjson = nil
+		}
+	}
+	if b := summary.encoded(); b != nil {
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, b, "", "  ") == nil {
+			b = pretty.Bytes()
+		}
+		fmt.Fprintf(&text, "\nRun summary:\n\n%s\n", b)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(text.String())); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	if err := attach(mw, "report.html", "text/html; charset=utf-8", report.Bytes()); err != nil {
+		return nil, err
+	}
+	if ndjson != nil {
+		if err := attach(mw, "results.ndjson.gz", "application/gzip", ndjson); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range shots {
+		for _, shot := range [][2]string{{"previous", v.Before}, {"current", v.After}} {
+			png, err := os.ReadFile(shot[1])
+			if err != nil {
+				// Pruned or taken on another machine
+				continue
+			}
+			if err := attach(mw, v.Anchor+"-"+shot[0]+".png", "image/png", png); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	subject := fmt.Sprintf("recon-engine: %s, %d live, %d with findings", target, data.Live, data.WithFindings)
+	fmt.Fprintf(&msg, "From: %s\r\n", emailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(emailRecipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@recon-engine>\r\n", runID)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func gzipResults(results []Result) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, res := range results {
+		if err := enc.Encode(res); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// attach adds b as a base64 attachment
+func attach(mw *multipart.Writer, name, contentType string, b []byte) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	enc := base64.StdEncoding.EncodeToString(b)
+	// RFC 2045 limits encoded lines to 76 characters
+	for len(enc) > 76 {
+		if _, err := part.Write([]byte(enc[:76] + "\r\n")); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = part.Write([]byte(enc + "\r\n"))
+	return err
+}
+
+// sendMail delivers msg to every recipient. Port 465 speaks TLS from the
+// start; on other ports STARTTLS is used whenever the server offers it.
+// SMTP_USERNAME and SMTP_PASSWORD, when set, authenticate with PLAIN, which
+// net/smtp only allows over TLS or to localhost.
+func sendMail(msg []byte) error {
+	addr := net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort))
+	tlsCfg := &tls.Config{ServerName: smtpHost}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if smtpPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, smtpHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && smtpPort != 465 {
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return err
+		}
+	}
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		if err := c.Auth(smtp.PlainAuth("", user, secret("SMTP_PASSWORD"), smtpHost)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(emailFrom); err != nil {
+		return err
+	}
+	for _, to := range emailRecipients {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("%s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// -encrypt-output files are AES-256-GCM streams: encryptMagic, the name of
+// the environment variable holding the key (one length byte, then the
+// name) and a random 7-byte nonce prefix, followed by chunks of at most
+// encryptChunkSize plaintext bytes, each a 4-byte big-endian length and the
+// sealed chunk. A chunk's nonce is the prefix, its 4-byte index and a byte
+// set to 1 on the last one, so chunks cannot be reordered or dropped and a
+// file cut short is told from a complete one. Writers flush short chunks at
+// checkpoints, and everything up to the last whole chunk of a killed run's
+// file decrypts. A file may hold several streams one after the other, as
+// resume appends them.
+const (
+	encryptMagic     = "RECON-ENC1\n"
+	encryptChunkSize = 64 << 10
+	encryptPrefixLen = 7
+)
+
+var (
+	// encryptKey is the -encrypt-output key, nil when local artifacts are
+	// written in the clear; encryptKeyName is the variable it came from
+	encryptKey     []byte
+	encryptKeyName string
+
+	// encryptTruncated warns once about an encrypted input without its
+	// last chunk
+	encryptTruncated sync.Once
+)
+
+// configureEncryption loads the -encrypt-output key, given as env:NAME.
+// Called once after flag parsing and -keys-file.
+func configureEncryption(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	name, ok := strings.CutPrefix(spec, "env:")
+	if !ok || name == "" {
+		return fmt.Errorf("want env:NAME, the environment variable holding the key, got %q", spec)
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("variable name %s is too long", name)
+	}
+	key, err := encryptionKey(name)
+	if err != nil {
+		return err
+	}
+	encryptKey, encryptKeyName = key, name
+	return nil
+}
+
+// encryptionKey reads the 32-byte key in the variable name, as 64 hex
+// digits or base64
+func encryptionKey(name string) ([]byte, error) {
+	v := strings.TrimSpace(secret(name))
+	if v == "" {
+		return nil, fmt.Errorf("$%s is not set", name)
+	}
+	addRedaction(v)
+	key, err := hex.DecodeString(v)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(v)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("$%s must hold a 32-byte key, as 64 hex digits or base64 (openssl rand -hex 32)", name)
+	}
+	return key, nil
+}
+
+// encryptWriter seals what is written to it into an encrypted stream
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce [12]byte
+	index uint32
+	buf   []byte
+	err   error
+}
+
+// newEncryptWriter writes the header of a stream under the -encrypt-output
+// key to w
+func newEncryptWriter(w io.Writer) (*encryptWriter, error) {
+	aead, err := newEncryptAEAD(encryptKey)
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptChunkSize)}
+	if _, err := rand.Read(e.nonce[:encryptPrefixLen]); err != nil {
+		return nil, err
+	}
+	header := append([]byte(encryptMagic), byte(len(encryptKeyName)))
+	header = append(header, encryptKeyName...)
+	header = append(header, e.nonce[:encryptPrefixLen]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func newEncryptAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && e.err == nil {
+		k := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p, n = p[k:], n+k
+		if len(e.buf) == cap(e.buf) {
+			e.seal(false)
+		}
+	}
+	return n, e.err
+}
+
+// Flush seals what is buffered as a chunk of its own, so it decrypts even
+// if the stream is never closed
+func (e *encryptWriter) Flush() error {
+	if len(e.buf) > 0 && e.err == nil {
+		e.seal(false)
+	}
+	return e.err
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.err == nil {
+		e.seal(true)
+	}
+	return e.err
+}
+
+func (e *encryptWriter) seal(last bool) {
+	nonce := e.nonce
+	binary.BigEndian.PutUint32(nonce[encryptPrefixLen:], e.index)
+	if last {
+		nonce[11] = 1
+	}
+	out := make([]byte, 4, 4+len(e.buf)+e.aead.Overhead())
+	out = e.aead.Seal(out, nonce[:], e.buf, nil)
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	if _, err := e.w.Write(out); err != nil {
+		e.err = err
+		return
+	}
+	e.buf, e.index = e.buf[:0], e.index+1
+}
+
+// decryptReader opens the encrypted streams of a file one after the other
+type decryptReader struct {
+	r *bufio.Reader
+	// key, when set, is used instead of the variable the header names
+	key []byte
+
+	aead  cipher.AEAD
+	nonce [12]byte
+	index uint32
+	last  bool // the current stream's last chunk was read
+	buf   []byte
+	// Truncated is set once the input ended before a stream's last chunk
+	Truncated bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next decrypts the following chunk, opening the next stream after the
+// last chunk of one
+func (d *decryptReader) next() error {
+	if d.aead == nil || d.last {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return io.EOF
+		}
+		return d.header()
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return d.cut(err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < uint32(d.aead.Overhead()) || n > encryptChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("encrypted chunk %d has an impossible length %d", d.index, n)
+	}
+	chunk := make([]byte, n)
+	if _, err := io.ReadFull(d.r, chunk); err != nil {
+		return d.cut(err)
+	}
+	nonce := d.nonce
+	binary.BigEndian.PutUint32(nonce[encryptPrefixLen:], d.index)
+	plain, err := d.aead.Open(nil, nonce[:], chunk, nil)
+	if err != nil {
+		nonce[11] = 1
+		if plain, err = d.aead.Open(nil, nonce[:], chunk, nil); err != nil {
+			return fmt.Errorf("encrypted chunk %d does not decrypt: wrong key or corrupted file", d.index)
+		}
+		d.last = true
+	}
+	d.buf, d.index = plain, d.index+1
+	return nil
+}
+
+// cut ends a stream that stops before its last chunk: a killed run's file
+// reads up to its last whole chunk
+func (d *decryptReader) cut(err error) error {
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	d.Truncated = true
+	encryptTruncated.Do(func() {
+		fmt.Fprintf(os.Stderr, "Warning: encrypted input ends before its last chunk, as a killed run leaves it; reading it up to there\n")
+	})
+	return io.EOF
+}
+
+func (d *decryptReader) header() error {
+	magic := make([]byte, len(encryptMagic)+1)
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic[:len(encryptMagic)]) != encryptMagic {
+		return fmt.Errorf("not an -encrypt-output stream")
+	}
+	name := make([]byte, int(magic[len(encryptMagic)]))
+	if _, err := io.ReadFull(d.r, name); err != nil {
+		return d.cut(err)
+	}
+	d.nonce = [12]byte{}
+	if _, err := io.ReadFull(d.r, d.nonce[:encryptPrefixLen]); err != nil {
+		return d.cut(err)
+	}
+	key := d.key
+	switch {
+	case key != nil:
+	case encryptKey != nil && encryptKeyName == string(name):
+		key = encryptKey
+	default:
+		var err error
+		if key, err = encryptionKey(string(name)); err != nil {
+			return fmt.Errorf("encrypted with the key in $%s: %w", name, err)
+		}
+	}
+	aead, err := newEncryptAEAD(key)
+	if err != nil {
+		return err
+	}
+	d.aead, d.index, d.last = aead, 0, false
+	return nil
+}
+
+// openDecrypted returns r decrypted when it is an -encrypt-output file, and
+// r as it is otherwise
+func openDecrypted(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(encryptMagic)); string(magic) == encryptMagic {
+		return &decryptReader{r: br}
+	}
+	return br
+}
+
+// isEncrypted reports whether data is an -encrypt-output file
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptMagic))
+}
+
+// encryptBytes returns data as a complete encrypted stream
+func encryptBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	e, err := newEncryptWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	e.Write(data)
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptBytes returns data decrypted when it is an -encrypt-output file,
+// and data itself otherwise
+func decryptBytes(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	return io.ReadAll(openDecrypted(bytes.NewReader(data)))
+}
+
+// runDecrypt implements the decrypt subcommand
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keySpec := fs.String("key", "", "env:NAME, the environment variable holding the key (default: the one the file names)")
+	outPath := fs.String("o", "", "Write the plaintext to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s decrypt [flags] <file>\n\nDecrypts an -encrypt-output file (results, -state, -keep-raw record or\nreport) to stdout, streaming. The key is read from the environment\nvariable the file was encrypted with, as 64 hex digits or base64, unless\n-key names another. A file a killed run left without its last chunk is\ndecrypted up to its last whole chunk, with a warning.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	d := &decryptReader{}
+	if *keySpec != "" {
+		name, ok := strings.CutPrefix(*keySpec, "env:")
+		if !ok || name == "" {
+			startupError("Invalid -key", fmt.Errorf("want env:NAME, got %q", *keySpec))
+		}
+		key, err := encryptionKey(name)
+		if err != nil {
+			startupError("Invalid -key", err)
+		}
+		d.key = key
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatalError("Failed to open "+fs.Arg(0), err)
+	}
+	defer f.Close()
+	d.r = bufio.NewReader(f)
+	if magic, _ := d.r.Peek(len(encryptMagic)); string(magic) != encryptMagic {
+		fatalError("Failed to decrypt "+fs.Arg(0), errors.New("not an -encrypt-output file"))
+	}
+
+	var w io.Writer = os.Stdout
+	var out *os.File
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			fatalError("Failed to create "+*outPath, err)
+		}
+		w = out
+	}
+	bw := bufio.NewWriter(w)
+	_, err = io.Copy(bw, d)
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if out != nil {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fatalError("Failed to decrypt "+fs.Arg(0), err)
+	}
+}
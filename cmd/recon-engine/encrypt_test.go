@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	testKeyEnv = "RECON_TEST_ENCRYPT_KEY"
+	testKey    = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+)
+
+// setEncryptKey sets -encrypt-output to a key of 32 bytes in hex
+func setEncryptKey(t *testing.T, hexKey string) {
+	t.Helper()
+	clearSecrets(t)
+	oldKey, oldName := encryptKey, encryptKeyName
+	t.Cleanup(func() { encryptKey, encryptKeyName = oldKey, oldName })
+	t.Setenv(testKeyEnv, hexKey)
+	if err := configureEncryption("env:" + testKeyEnv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// encryptChunks writes each part as a chunk of its own, the last one as
+// the stream's last chunk, and returns the stream
+func encryptChunks(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	e, err := newEncryptWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range parts {
+		e.Write([]byte(p))
+		if i < len(parts)-1 {
+			if err := e.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// splitStream returns a stream's header and its chunks, length included
+func splitStream(t *testing.T, data []byte) ([]byte, [][]byte) {
+	t.Helper()
+	n := len(encryptMagic) + 1 + int(data[len(encryptMagic)]) + encryptPrefixLen
+	header, rest := data[:n], data[n:]
+	var chunks [][]byte
+	for len(rest) > 0 {
+		size := 4 + int(binary.BigEndian.Uint32(rest))
+		chunks = append(chunks, rest[:size])
+		rest = rest[size:]
+	}
+	return header, chunks
+}
+
+func decrypt(data []byte) (string, bool, error) {
+	r := openDecrypted(bytes.NewReader(data))
+	b, err := io.ReadAll(r)
+	d, _ := r.(*decryptReader)
+	return string(b), d != nil && d.Truncated, err
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	setEncryptKey(t, testKey)
+	long := strings.Repeat("0123456789abcdef", encryptChunkSize/8) // two whole chunks
+	for _, plain := range []string{"", "one line\n", long, long + "tail\n"} {
+		data, err := encryptBytes([]byte(plain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isEncrypted(data) || bytes.Contains(data, []byte("line")) {
+			t.Fatalf("%d bytes not encrypted", len(plain))
+		}
+		got, truncated, err := decrypt(data)
+		if err != nil || got != plain || truncated {
+			t.Errorf("%d bytes: got %d, truncated %v, %v", len(plain), len(got), truncated, err)
+		}
+	}
+	if got, err := decryptBytes([]byte("plain\n")); err != nil || string(got) != "plain\n" {
+		t.Errorf("plaintext passed through as %q, %v", got, err)
+	}
+}
+
+// TestEncryptTruncated reads a killed run's file up to its last whole
+// chunk: what was flushed before it was cut
+func TestEncryptTruncated(t *testing.T) {
+	setEncryptKey(t, testKey)
+	var buf bytes.Buffer
+	e, err := newEncryptWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Write([]byte("first result\n"))
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	flushed := buf.Len()
+	e.Write([]byte("second result, flushed as the run was killed\n"))
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	e.Write([]byte("never flushed\n"))
+	data := buf.Bytes()
+
+	for _, cut := range []int{flushed, flushed + 2, flushed + 4, flushed + (len(data)-flushed)/2, len(data) - 1, len(data)} {
+		got, truncated, err := decrypt(data[:cut])
+		want := "first result\n"
+		if cut == len(data) {
+			want += "second result, flushed as the run was killed\n"
+		}
+		if err != nil || got != want || !truncated {
+			t.Errorf("cut at %d of %d: %q, truncated %v, %v", cut, len(data), got, truncated, err)
+		}
+	}
+}
+
+func TestEncryptWrongKey(t *testing.T) {
+	setEncryptKey(t, testKey)
+	data := encryptChunks(t, "secret results\n")
+	setEncryptKey(t, strings.Repeat("ff", 32))
+	if got, _, err := decrypt(data); err == nil || !strings.Contains(err.Error(), "wrong key") || got != "" {
+		t.Errorf("wrong key: %q, %v", got, err)
+	}
+	t.Setenv(testKeyEnv, "")
+	encryptKey, encryptKeyName = nil, ""
+	if _, _, err := decrypt(data); err == nil || !strings.Contains(err.Error(), testKeyEnv) {
+		t.Errorf("no key: %v", err)
+	}
+}
+
+// TestEncryptTampered fails on chunks that were reordered or dropped,
+// rather than reading them
+func TestEncryptTampered(t *testing.T) {
+	setEncryptKey(t, testKey)
+	header, chunks := splitStream(t, encryptChunks(t, "a\n", "b\n", "c\n"))
+	if len(chunks) != 3 {
+		t.Fatalf("%d chunks", len(chunks))
+	}
+	join := func(order ...int) []byte {
+		data := append([]byte{}, header...)
+		for _, i := range order {
+			data = append(data, chunks[i]...)
+		}
+		return data
+	}
+	for name, data := range map[string][]byte{
+		"swapped":         join(1, 0, 2),
+		"last moved":      join(0, 2, 1),
+		"middle dropped":  join(0, 2),
+		"first dropped":   join(1, 2),
+		"chunk repeated":  join(0, 0, 1, 2),
+		"last duplicated": join(0, 1, 2, 2),
+	} {
+		if got, _, err := decrypt(data); err == nil {
+			t.Errorf("%s: read %q", name, got)
+		}
+	}
+	// A stream without its last chunk is a killed run's file: it reads up
+	// to there, marked truncated
+	if got, truncated, err := decrypt(join(0, 1)); err != nil || got != "a\nb\n" || !truncated {
+		t.Errorf("last dropped: %q, truncated %v, %v", got, truncated, err)
+	}
+	flipped := join(0, 1, 2)
+	flipped[len(header)+5] ^= 1
+	if _, _, err := decrypt(flipped); err == nil {
+		t.Error("a flipped bit was read")
+	}
+}
+
+// TestEncryptConcatenated reads streams appended one after the other, as
+// resume appends them to an -o file
+func TestEncryptConcatenated(t *testing.T) {
+	setEncryptKey(t, testKey)
+	data := append(encryptChunks(t, "run 1\n", "more of run 1\n"), encryptChunks(t, "run 2\n")...)
+	data = append(data, encryptChunks(t, "")...)
+	data = append(data, encryptChunks(t, "run 3\n")...)
+	got, truncated, err := decrypt(data)
+	if err != nil || got != "run 1\nmore of run 1\nrun 2\nrun 3\n" || truncated {
+		t.Errorf("%q, truncated %v, %v", got, truncated, err)
+	}
+}
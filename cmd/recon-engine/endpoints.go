@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// endpointStats is one proxy or resolver in the run summary
+type endpointStats struct {
+	Name        string `json:"name"`
+	Successes   int    `json:"successes"`
+	Errors      int    `json:"errors"`
+	Quarantined int    `json:"quarantined,omitempty"` // times it was taken out of the rotation
+}
+
+// endpointPool rotates requests round-robin across a set of proxies or
+// resolvers. One that fails -endpoint-failures times in a row is
+// quarantined for -endpoint-cooldown; after that it gets one request back,
+// and a failure quarantines it again. With every endpoint quarantined,
+// pick waits for the first to come back instead of failing.
+type endpointPool struct {
+	kind string // "proxy" or "resolver", in messages and stats keys
+	stat string // the stats prefix: <stat>.ok.<name> and <stat>.errors.<name>
+
+	mu        sync.Mutex
+	endpoints []*endpointState
+	next      int
+	paused    bool // the all-quarantined message was printed
+}
+
+type endpointState struct {
+	endpointStats
+	failures int
+	until    time.Time // quarantined until then
+}
+
+func newEndpointPool(kind, stat string, names []string) *endpointPool {
+	p := &endpointPool{kind: kind, stat: stat}
+	for _, name := range names {
+		p.endpoints = append(p.endpoints, &endpointState{endpointStats: endpointStats{Name: name}})
+	}
+	return p
+}
+
+// pick returns the index of the next endpoint in the rotation that is not
+// quarantined, waiting while all of them are
+func (p *endpointPool) pick(ctx context.Context) (int, error) {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		var soonest time.Time
+		for range p.endpoints {
+			i := p.next
+			p.next = (p.next + 1) % len(p.endpoints)
+			e := p.endpoints[i]
+			if !e.until.After(now) {
+				if p.paused {
+					p.paused = false
+					fmt.Fprintf(os.Stderr, "Endpoints: retrying %s %s\n", p.kind, e.Name)
+				}
+				p.mu.Unlock()
+				return i, nil
+			}
+			if soonest.IsZero() || e.until.Before(soonest) {
+				soonest = e.until
+			}
+		}
+		if !p.paused {
+			p.paused = true
+			stats.Add(p.stat+".paused", 1)
+			fmt.Fprintf(os.Stderr, "Endpoints: every %s is quarantined, pausing until %s\n", p.kind, soonest.Format(time.TimeOnly))
+		}
+		p.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(time.Until(soonest)):
+		}
+	}
+}
+
+// peek returns the index of the next endpoint that is not quarantined,
+// or of the one that comes back first, without waiting
+func (p *endpointPool) peek() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	best := -1
+	for range p.endpoints {
+		i := p.next
+		p.next = (p.next + 1) % len(p.endpoints)
+		if !p.endpoints[i].until.After(now) {
+			return i
+		}
+		if best < 0 || p.endpoints[i].until.Before(p.endpoints[best].until) {
+			best = i
+		}
+	}
+	return best
+}
+
+// healthy returns the indexes of the endpoints that are not quarantined,
+// or all of them when every one is
+func (p *endpointPool) healthy() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out, all []int
+	now := time.Now()
+	for i, e := range p.endpoints {
+		all = append(all, i)
+		if !e.until.After(now) {
+			out = append(out, i)
+		}
+	}
+	if len(out) == 0 {
+		return all
+	}
+	return out
+}
+
+// report records how a request through endpoint i went. err is nil on
+// success.
+func (p *endpointPool) report(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.endpoints[i]
+	if err == nil {
+		e.Successes++
+		e.failures = 0
+		stats.Add(p.stat+".ok."+e.Name, 1)
+		return
+	}
+	e.Errors++
+	e.failures++
+	stats.Add(p.stat+".errors."+e.Name, 1)
+	if endpointFailures <= 0 || e.failures < endpointFailures {
+		return
+	}
+	e.until = time.Now().Add(endpointCooldown)
+	// Back from quarantine it gets a single request to prove itself
+	e.failures = endpointFailures - 1
+	e.Quarantined++
+	stats.Add(p.stat+".quarantined."+e.Name, 1)
+	fmt.Fprintf(os.Stderr, "Endpoints: %s %s failed %d times in a row (last: %v), quarantined for %s\n", p.kind, e.Name, endpointFailures, redactSecrets(err.Error()), endpointCooldown)
+}
+
+// snapshot copies the endpoints' counts for the run summary
+func (p *endpointPool) snapshot() []endpointStats {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]endpointStats, len(p.endpoints))
+	for i, e := range p.endpoints {
+		out[i] = e.endpointStats
+	}
+	return out
+}
+
+// proxyFailed reports whether a request error means the proxy itself could
+// not be reached, rather than the target through it
+func proxyFailed(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "proxyconnect"
+}
+
+// validateEndpoints checks the rotation flags once after parsing
+func validateEndpoints() error {
+	if endpointFailures < 0 {
+		return fmt.Errorf("-endpoint-failures must not be negative, got %d", endpointFailures)
+	}
+	if endpointFailures > 0 && endpointCooldown <= 0 {
+		return fmt.Errorf("-endpoint-cooldown must be positive, got %s", endpointCooldown)
+	}
+	return nil
+}
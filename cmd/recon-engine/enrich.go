@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// enrichResult runs the per-host enrichers on a probed Result. It is called
+// from the worker pool, so everything it touches must be safe for
+// concurrent use. Each step but flags is wrapped in enrichStep or
+// activeStep, which time it for -timings and take its -workers slots.
+func enrichResult(ctx context.Context, res *Result, target string) {
+	if excludedAfterProbe(res) {
+		return
+	}
+
+	// Everything after sees the page the credentials show
+	if len(authContexts) > 0 && res.StatusCode > 0 {
+		activeStep(ctx, res, "auth", func() {
+			authenticateResult(ctx, res)
+		})
+	}
+
+	// Before the stages that would take a block page for the site. The
+	// page is fetched again when the rules need it.
+	if res.StatusCode > 0 {
+		activeStep(ctx, res, "waf", func() {
+			detectBlock(ctx, res)
+		})
+	}
+
+	// A parked or for-sale domain has nothing for the rest to find. Pages
+	// titled like a parking template are fetched for the body signatures.
+	if res.StatusCode > 0 && !res.Blocked {
+		activeStep(ctx, res, "parked", func() {
+			detectParked(ctx, res)
+		})
+		if res.Parked && !includeParked {
+			return
+		}
+	}
+
+	// Record where redirects lead
+	if followRedirectsFlag && res.StatusCode >= 300 && res.StatusCode < 400 && res.URL != "" {
+		activeStep(ctx, res, "redirects", func() {
+			res.FinalURL, res.RedirectChain = followRedirects(ctx, res.URL)
+			if u, err := url.Parse(res.FinalURL); err == nil && u.Hostname() != "" {
+				res.RedirectsOffScope = !inTarget(u.Hostname(), target)
+			}
+		})
+	}
+
+	// After the redirects, which an SSO login starts with
+	if accessCheck && res.StatusCode > 0 {
+		activeStep(ctx, res, "access", func() {
+			classifyAccess(ctx, res)
+		})
+	}
+
+	// Before the stages that consult the host's soft-404 baseline
+	if soft404Flag && res.StatusCode > 0 {
+		activeStep(ctx, res, "soft404", func() {
+			checkSoft404(ctx, res)
+		})
+	}
+
+	// ASN/Org for hosts amass did not describe; amass data is usually more
+	// descriptive so it wins when present, including when it arrived after
+	// httpx probed the host
+	if res.Asn == "" && res.IP != "" {
+		enrichStep(ctx, res, "asn", func() {
+			if inf, ok := amassInfra(res.Subdomain); ok && res.Subdomain != "" {
+				res.Asn = fmt.Sprintf("AS%d", inf.Asn)
+				res.Org = inf.Org
+			} else if info, ok := lookupASN(ctx, res.IP); ok {
+				res.Asn = fmt.Sprintf("AS%d", info.Asn)
+				res.Org = info.Org
+			}
+		})
+	}
+
+	// After ASN, and before every active stage -skip-third-party-active
+	// holds back
+	if thirdPartyCheck && res.StatusCode > 0 {
+		enrichStep(ctx, res, "third_party", func() {
+			classifyThirdParty(ctx, res)
+		})
+	}
+
+	// Addresses of IP targets go by their PTR name when they have one
+	ipRange := targetRange(target).IsValid()
+	if (ptrSweep || ipRange) && res.IP != "" {
+		enrichStep(ctx, res, "ptr", func() {
+			res.Ptr = lookupPTR(ctx, res.IP)
+			if ipRange {
+				res.Subdomain = res.Ptr
+			}
+		})
+	}
+
+	if res.IP != "" {
+		enrichStep(ctx, res, "geo", func() {
+			loc := lookupGeo(res.IP)
+			res.Country, res.City = loc.Country, loc.City
+		})
+	}
+
+	// Enrich with Censys host data
+	if censysEnrich && res.StatusCode > 0 {
+		enrichStep(ctx, res, "censys", func() {
+			host := res.Subdomain
+			if host == "" {
+				host = res.IP
+			}
+			res.CensysServices = censysServices(ctx, host)
+		})
+	}
+
+	// Passive lookups; the addresses are never sent a request
+	if historicalIPsFlag && res.Subdomain != "" {
+		enrichStep(ctx, res, "historical_ips", func() {
+			enrichHistoricalIPs(ctx, res)
+		})
+	}
+
+	if collectRobotsFlag && res.StatusCode > 0 {
+		activeStep(ctx, res, "robots", func() {
+			collectRobots(ctx, res)
+		})
+	}
+
+	if securityTxtFlag && res.StatusCode > 0 {
+		activeStep(ctx, res, "security_txt", func() {
+			res.SecurityTxt = fetchSecurityTxt(ctx, res)
+		})
+	}
+
+	if corsCheck && res.StatusCode > 0 {
+		activeStep(ctx, res, "cors", func() {
+			checkCORS(ctx, res, target)
+		})
+	}
+
+	if headerAudit && res.StatusCode > 0 {
+		activeStep(ctx, res, "headers", func() {
+			auditHeaders(ctx, res)
+		})
+	}
+
+	if cookieAudit && res.StatusCode > 0 {
+		activeStep(ctx, res, "cookies", func() {
+			auditCookies(ctx, res)
+		})
+	}
+
+	if certCheck && res.StatusCode > 0 {
+		activeStep(ctx, res, "cert", func() {
+			checkCert(ctx, res)
+		})
+	}
+
+	if jarmFlag && res.StatusCode > 0 {
+		activeStep(ctx, res, "jarm", func() {
+			computeJarm(ctx, res)
+		})
+	}
+
+	if bucketCheck && res.StatusCode > 0 {
+		activeStep(ctx, res, "bucket", func() {
+			checkBucket(ctx, res)
+		})
+	}
+
+	if dirBrute && res.StatusCode > 0 {
+		activeStep(ctx, res, "dirbrute", func() {
+			bruteDirectories(ctx, res)
+		})
+	}
+
+	// --- WhatWeb Fingerprinting (Conditional) ---
+	// Hosts serving the same application wait for the first one's output
+	// outside the worker budget
+	if whatwebFingerprinting() && res.StatusCode > 0 { // Only fingerprint live hosts
+		fingerprintGrouped(ctx, res, func() (out []WhatWebResult) {
+			activeStep(ctx, res, "whatweb", func() {
+				out = fingerprintWhatWeb(ctx, res)
+			})
+			return out
+		})
+	}
+
+	// After WhatWeb, whose certainty settles conflicting versions
+	if nativeFingerprinting() && res.StatusCode > 0 && res.URL != "" {
+		activeStep(ctx, res, "fingerprint", func() {
+			fingerprintNativeResult(ctx, res)
+		})
+	}
+
+	if defaultCreds && res.StatusCode > 0 {
+		activeStep(ctx, res, "default_creds", func() {
+			checkDefaultCreds(ctx, res)
+		})
+	}
+
+	if len(matchPatterns) > 0 && res.StatusCode > 0 {
+		activeStep(ctx, res, "match", func() {
+			matchResponse(ctx, res)
+		})
+	}
+
+	// After the stages that collect URLs and technologies
+	if paramsFlag && res.StatusCode > 0 {
+		activeStep(ctx, res, "params", func() {
+			discoverParams(ctx, res)
+		})
+	}
+
+	if redirectCheck && res.StatusCode > 0 {
+		activeStep(ctx, res, "redirect_check", func() {
+			checkOpenRedirects(ctx, res)
+		})
+	}
+
+	if cveLookup && len(res.Versions) > 0 {
+		enrichStep(ctx, res, "cve", func() {
+			addCVEs(ctx, res)
+		})
+	}
+
+	if envBody && res.StatusCode > 0 && res.URL != "" && envRulesUseBody() {
+		activeStep(ctx, res, "environment", func() {
+			fetchEnvPage(ctx, res)
+		})
+	}
+
+	if screenshotsFlag && res.StatusCode > 0 && res.URL != "" {
+		activeStep(ctx, res, "screenshot", func() {
+			takeScreenshot(ctx, res)
+		})
+	}
+
+	// Flags last, so rules see everything the enrichers added
+	timeStep(res, "flags", func() {
+		markAbandoned(res)
+		classifyEnvironment(res)
+		res.body = nil
+		applyFlagRules(res)
+	})
+
+	// After the flags, which decide whose body is kept
+	if archiveBodies && res.StatusCode > 0 && res.URL != "" && archiveWorthy(res) {
+		activeStep(ctx, res, "archive", func() {
+			archiveBody(ctx, res)
+		})
+	}
+}
+
+// activeStep is enrichStep for an enricher that sends requests to the host,
+// which -polite runs one at a time and -block-action pause skips
+func activeStep(ctx context.Context, res *Result, name string, fn func()) {
+	if blockPaused(res.RootDomain) {
+		stats.Add("blocks.paused_steps", 1)
+		return
+	}
+	if thirdPartySkipped(res, name) {
+		return
+	}
+	stageStep(ctx, name, func() {
+		politeStep(ctx, func() {
+			otelStep(ctx, name, func() {
+				timeStep(res, name, fn)
+			})
+		})
+	})
+}
+
+// enrichStep is timeStep for an enricher that takes its slots of the
+// -workers budget and is traced in host batches
+func enrichStep(ctx context.Context, res *Result, name string, fn func()) {
+	stageStep(ctx, name, func() {
+		otelStep(ctx, name, func() {
+			timeStep(res, name, fn)
+		})
+	})
+}
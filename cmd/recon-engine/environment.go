@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// envUnknown is the environment of a result no environment rule matches
+const envUnknown = "unknown"
+
+// environments are the environments a rule can classify a result as
+var environments = []string{"prod", "staging", "dev", "test", "uat"}
+
+func validEnvironment(env string) bool {
+	return contains(environments, env)
+}
+
+// classifyEnvironment sets res.Environment from the first environment rule
+// it matches, and res.EnvironmentEvidence to the condition that fired.
+// Rules of -rules come before the embedded ones.
+func classifyEnvironment(res *Result) {
+	for i := range envRules {
+		r := &envRules[i]
+		if r.match(res) {
+			res.Environment, res.EnvironmentEvidence = r.Environment, r.explain(res)
+			stats.Add("environments."+r.Environment, 1)
+			return
+		}
+	}
+	res.Environment, res.EnvironmentEvidence = envUnknown, ""
+}
+
+// envRulesUseBody reports whether an environment rule looks at the page
+// body, which -env-body fetches
+func envRulesUseBody() bool {
+	for i := range envRules {
+		if envRules[i].usesBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchEnvPage fetches a live host's page for the environment rules that
+// match on the body, unless the cheaper signals already classify it
+func fetchEnvPage(ctx context.Context, res *Result) {
+	if classifyEnvironment(res); res.Environment != envUnknown {
+		return
+	}
+	fetchBlockPage(ctx, res)
+}
+
+// explain names the conditions of m that res matched: all of its own, the
+// explanations of its All entries and that of the first Any entry matching
+func (m *ruleMatcher) explain(res *Result) string {
+	var parts []string
+	if m.HostToken != "" {
+		parts = append(parts, fmt.Sprintf("hostname label %q", m.HostToken))
+	}
+	if m.TitleContains != "" {
+		parts = append(parts, fmt.Sprintf("title contains %q", m.TitleContains))
+	}
+	if m.TechContains != "" {
+		parts = append(parts, fmt.Sprintf("technology %q", m.TechContains))
+	}
+	if m.Status != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", m.Status))
+	}
+	if m.BodyHash != "" {
+		parts = append(parts, "body hash "+m.BodyHash)
+	}
+	if m.BodyContains != "" {
+		parts = append(parts, fmt.Sprintf("body contains %q", m.BodyContains))
+	}
+	if m.Header != "" {
+		parts = append(parts, fmt.Sprintf("header %s matches %q", m.Header, m.Regex))
+	}
+	if m.GradeBelow != "" {
+		parts = append(parts, "header grade below "+m.GradeBelow)
+	}
+	if m.Finding != "" {
+		parts = append(parts, "finding "+m.Finding)
+	}
+	if m.RobotsDenyAll {
+		parts = append(parts, "robots.txt disallows everything")
+	}
+	if len(m.EnvIn) > 0 {
+		parts = append(parts, "environment "+res.Environment)
+	}
+	if len(m.AccessIn) > 0 {
+		parts = append(parts, "access control "+res.AccessControl)
+	}
+	for i := range m.All {
+		parts = append(parts, m.All[i].explain(res))
+	}
+	for i := range m.Any {
+		if m.Any[i].match(res) {
+			parts = append(parts, m.Any[i].explain(res))
+			break
+		}
+	}
+	return strings.Join(parts, " and ")
+}
+
+// hostHasToken reports whether token is one of the labels of host left of
+// root, split and trimmed as hostKeywords does
+func hostHasToken(host, root, token string) bool {
+	host, root = strings.ToLower(host), strings.ToLower(root)
+	if root != "" {
+		if host == root {
+			return false
+		}
+		host = strings.TrimSuffix(host, "."+root)
+	}
+	for _, part := range strings.FieldsFunc(host, func(r rune) bool { return r == '.' || r == '-' || r == '_' }) {
+		if strings.TrimRight(part, "0123456789") == token {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// lockedWriter serialises writes so records written from different
+// goroutines never interleave. json.Encoder writes each record in a single
+// Write call.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// stdout is shared by the Result encoder and -events records
+var stdout = &lockedWriter{w: os.Stdout}
+
+// eventRecord describes a tool failure for automated consumers
+type eventRecord struct {
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+	Tool      string `json:"tool"`
+	Stage     string `json:"stage"`
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+}
+
+// Event kinds
+const (
+	eventStartFailed = "start_failed"
+	eventExitStatus  = "exit_status"
+	eventTimeout     = "timeout"
+	eventParseFailed = "parse_failed"
+	eventInvalid     = "invalid" // parsed, but missing or impossible fields
+	eventOutputLimit = "output_limit"
+	eventFailed      = "failed"
+)
+
+var (
+	eventOut io.Writer // nil unless -events or -events-file is set
+
+	eventCountsMu sync.Mutex
+	eventCounts   = make(map[string]int) // tool -> errors this run
+)
+
+// configureEvents opens the event destination chosen by -events/-events-file
+func configureEvents() error {
+	if eventsFile != "" {
+		f, err := os.OpenFile(eventsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		eventOut = &lockedWriter{w: f}
+		return nil
+	}
+	if eventsOnStdout {
+		eventOut = stdout
+	}
+	return nil
+}
+
+// reportToolError counts err against tool and writes an error record when
+// events are enabled, and an event on the -otel-endpoint trace. The kind is
+// derived from err unless given.
+func reportToolError(tool, stage, kind string, err error) {
+	eventCountsMu.Lock()
+	eventCounts[tool]++
+	eventCountsMu.Unlock()
+	if eventOut == nil && tracer == nil {
+		return
+	}
+
+	rec := eventRecord{
+		Type:      "error",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Tool:      tool,
+		Stage:     stage,
+		Kind:      kind,
+		Message:   redactSecrets(err.Error()),
+	}
+	var exitErr *exec.ExitError
+	var execErr *exec.Error
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &execErr) || errors.As(err, &pathErr):
+		if rec.Kind == "" {
+			rec.Kind = eventStartFailed
+		}
+	case errors.As(err, &exitErr):
+		code := exitErr.ExitCode()
+		rec.ExitCode = &code
+		if rec.Kind == "" {
+			rec.Kind = eventExitStatus
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		if rec.Kind == "" {
+			rec.Kind = eventTimeout
+		}
+	}
+	if rec.Kind == "" {
+		rec.Kind = eventFailed
+	}
+	otelToolError(rec)
+	if eventOut == nil {
+		return
+	}
+	b, jerr := json.Marshal(rec)
+	if jerr != nil {
+		return
+	}
+	fmt.Fprintln(eventOut, string(b))
+}
+
+// toolErrorCounts returns the per-tool error counts for the run summary
+func toolErrorCounts() map[string]int {
+	eventCountsMu.Lock()
+	defer eventCountsMu.Unlock()
+	if len(eventCounts) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(eventCounts))
+	for k, v := range eventCounts {
+		out[k] = v
+	}
+	return out
+}
+
+// resetToolErrors clears the counts between -monitor iterations
+func resetToolErrors() {
+	eventCountsMu.Lock()
+	eventCounts = make(map[string]int)
+	eventCountsMu.Unlock()
+}
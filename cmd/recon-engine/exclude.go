@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ipExclusions are the -exclude-ips ranges: hosts resolving into one are
+// reported as discovered but never probed, scanned or enriched
+var ipExclusions []netip.Prefix
+
+var (
+	// excludedItems holds the names and addresses each range kept out
+	excludedItemsMu sync.Mutex
+	excludedItems   = make(map[netip.Prefix]map[string]bool)
+)
+
+// excludedName is a discovered name -exclude-ips kept from probing, with
+// the address that put it there
+type excludedName struct {
+	name, ip, reason string
+}
+
+// configureExcludeIPs parses -exclude-ips: comma-separated addresses and
+// CIDR ranges, IPv4 or IPv6, or files of them one per line. Called once
+// after flag parsing.
+func configureExcludeIPs() error {
+	for _, item := range splitList(excludeIPsFlag) {
+		if _, err := os.Stat(item); err == nil {
+			prefixes, err := readExcludeIPs(item)
+			if err != nil {
+				return err
+			}
+			ipExclusions = append(ipExclusions, prefixes...)
+			continue
+		}
+		p, err := parseExcludeIP(item)
+		if err != nil {
+			return err
+		}
+		ipExclusions = append(ipExclusions, p)
+	}
+	if probeMixedIPs && len(ipExclusions) == 0 {
+		return fmt.Errorf("-probe-mixed-ips needs -exclude-ips")
+	}
+	return nil
+}
+
+func readExcludeIPs(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var prefixes []netip.Prefix
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		p, err := parseExcludeIP(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, sc.Err()
+}
+
+// parseExcludeIP reads an address or CIDR range, an address standing for
+// itself alone
+func parseExcludeIP(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not a CIDR range", s)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not an address, CIDR range or file", s)
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// excludedPrefix returns the -exclude-ips range ip falls in
+func excludedPrefix(ip string) (netip.Prefix, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	a = a.Unmap()
+	for _, p := range ipExclusions {
+		if p.Contains(a) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// countExclusion records that range p kept item, a name or address, out
+func countExclusion(p netip.Prefix, item string) {
+	excludedItemsMu.Lock()
+	defer excludedItemsMu.Unlock()
+	if excludedItems[p] == nil {
+		excludedItems[p] = make(map[string]bool)
+	}
+	excludedItems[p][item] = true
+}
+
+// excludeAddrs returns why name, resolving to addrs, must not be probed,
+// with the excluded address, or "" when it may be. A name with addresses
+// both inside and outside the ranges is excluded unless -probe-mixed-ips
+// is set.
+func excludeAddrs(name string, addrs []string) (string, string) {
+	if len(ipExclusions) == 0 {
+		return "", ""
+	}
+	var hit netip.Prefix
+	var hitIP string
+	mixed := false
+	for _, ip := range addrs {
+		if p, ok := excludedPrefix(ip); !ok {
+			mixed = true
+		} else if !hit.IsValid() {
+			hit, hitIP = p, ip
+		}
+	}
+	if !hit.IsValid() {
+		return "", ""
+	}
+	if mixed && probeMixedIPs {
+		stats.Add("exclude_ips.mixed_probed", 1)
+		return "", ""
+	}
+	countExclusion(hit, name)
+	reason := fmt.Sprintf("resolves to %s in excluded range %s", hitIP, hit)
+	if mixed {
+		reason += ", besides addresses outside it"
+	}
+	return reason, hitIP
+}
+
+// excludedAfterProbe re-checks the address a host was probed on, which
+// may differ from what it resolved to when it was fed, and marks res so
+// no enricher touches it
+func excludedAfterProbe(res *Result) bool {
+	if len(ipExclusions) == 0 || res.IP == "" {
+		return false
+	}
+	p, ok := excludedPrefix(res.IP)
+	if !ok {
+		return false
+	}
+	item := res.Subdomain
+	if item == "" {
+		item = res.IP
+	}
+	countExclusion(p, item)
+	stats.Add("exclude_ips.rechecked", 1)
+	res.ProbeSkipped = fmt.Sprintf("resolved to %s in excluded range %s once probed; not enriched", res.IP, p)
+	return true
+}
+
+// excludedResult is the discovery-only record of a name -exclude-ips kept
+// from probing
+func excludedResult(target string, e excludedName) Result {
+	res := unprobedResult(target, e.name)
+	res.IP, res.ProbeSkipped = e.ip, e.reason
+	return res
+}
+
+// ipExclusionCounts returns how many names and addresses each range kept
+// out this run
+func ipExclusionCounts() map[string]int {
+	excludedItemsMu.Lock()
+	defer excludedItemsMu.Unlock()
+	if len(excludedItems) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(excludedItems))
+	for p, items := range excludedItems {
+		out[p.String()] = len(items)
+	}
+	return out
+}
+
+// resetIPExclusions clears the counts for a new monitor iteration
+func resetIPExclusions() {
+	excludedItemsMu.Lock()
+	excludedItems = make(map[netip.Prefix]map[string]bool)
+	excludedItemsMu.Unlock()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// burpScope is the target scope file Burp Suite imports from Target >
+// Scope settings, in advanced mode so hosts and ports are regexes
+type burpScope struct {
+	Target burpTarget `json:"target"`
+}
+
+type burpTarget struct {
+	Scope burpScopeRules `json:"scope"`
+}
+
+type burpScopeRules struct {
+	AdvancedMode bool            `json:"advanced_mode"`
+	Exclude      []burpScopeRule `json:"exclude"`
+	Include      []burpScopeRule `json:"include"`
+}
+
+type burpScopeRule struct {
+	Enabled  bool   `json:"enabled"`
+	File     string `json:"file"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// exportSummary counts what -export-burp and -export-urls hold
+type exportSummary struct {
+	Burp  string `json:"burp,omitempty"`
+	URLs  string `json:"urls,omitempty"`
+	Hosts int    `json:"hosts"`
+}
+
+var (
+	// exportResults are the live Results of the run the exports are made
+	// from, whether or not -diff let them through to the output
+	exportMu      sync.Mutex
+	exportResults []Result
+)
+
+// configureExports checks -export-flags. Called once after flag parsing.
+func configureExports() error {
+	if len(splitList(exportFlags)) > 0 && exportBurpPath == "" && exportURLsPath == "" {
+		return fmt.Errorf("-export-flags needs -export-burp or -export-urls")
+	}
+	return nil
+}
+
+// observeExport keeps res for the exports if it is live and passes
+// -match-codes, -filter and -export-flags
+func observeExport(res Result) {
+	if exportBurpPath == "" && exportURLsPath == "" {
+		return
+	}
+	if res.StatusCode == 0 || res.URL == "" || res.ChangeType == changeRemoved {
+		return
+	}
+	if !keepStatus(res.StatusCode) || resultFilter != nil && !resultFilter.match(res) {
+		return
+	}
+	if want := splitList(exportFlags); len(want) > 0 {
+		found := false
+		for _, f := range want {
+			if contains(res.Flags, f) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+	exportMu.Lock()
+	exportResults = append(exportResults, res)
+	exportMu.Unlock()
+}
+
+// writeExports writes -export-burp and -export-urls from the Results kept
+// this run, the most interesting first, and forgets them
+func writeExports() {
+	exportMu.Lock()
+	results := exportResults
+	exportResults = nil
+	exportMu.Unlock()
+	sortReportResults(results)
+
+	var urls []string
+	var rules []burpScopeRule
+	seenURL := make(map[string]bool)
+	seenRule := make(map[burpScopeRule]bool)
+	for _, res := range results {
+		u, err := url.Parse(res.URL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if !seenURL[res.URL] {
+			seenURL[res.URL] = true
+			urls = append(urls, res.URL)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		rule := burpScopeRule{
+			Enabled:  true,
+			File:     "^/.*",
+			Host:     "^" + regexp.QuoteMeta(u.Hostname()) + "$",
+			Port:     "^" + port + "$",
+			Protocol: u.Scheme,
+		}
+		if !seenRule[rule] {
+			seenRule[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	sum := &exportSummary{Hosts: len(rules)}
+	if exportBurpPath != "" {
+		scope := burpScope{Target: burpTarget{Scope: burpScopeRules{
+			AdvancedMode: true,
+			Exclude:      []burpScopeRule{},
+			Include:      rules,
+		}}}
+		if scope.Target.Scope.Include == nil {
+			scope.Target.Scope.Include = []burpScopeRule{}
+		}
+		data, err := json.MarshalIndent(scope, "", "  ")
+		if err == nil {
+			err = writeFileAtomic(exportBurpPath, append(data, '\n'))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -export-burp: %v\n", err)
+		} else {
+			sum.Burp = exportBurpPath
+		}
+	}
+	if exportURLsPath != "" {
+		var data string
+		if len(urls) > 0 {
+			data = strings.Join(urls, "\n") + "\n"
+		}
+		if err := writeFileAtomic(exportURLsPath, []byte(data)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -export-urls: %v\n", err)
+		} else {
+			sum.URLs = exportURLsPath
+		}
+	}
+	summary.Export = sum
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// setExports sets -export-burp and -export-urls in a temporary directory
+// and -export-flags to flags, returning the two paths
+func setExports(t *testing.T, flags string) (string, string) {
+	t.Helper()
+	oldBurp, oldURLs, oldFlags, oldFilter, oldExport := exportBurpPath, exportURLsPath, exportFlags, resultFilter, summary.Export
+	t.Cleanup(func() {
+		exportBurpPath, exportURLsPath, exportFlags, resultFilter, summary.Export = oldBurp, oldURLs, oldFlags, oldFilter, oldExport
+		exportResults = nil
+	})
+	dir := t.TempDir()
+	exportBurpPath, exportURLsPath, exportFlags = filepath.Join(dir, "targets.json"), filepath.Join(dir, "urls.txt"), flags
+	resultFilter, exportResults = nil, nil
+	if err := setStatusFilter(t, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+	return exportBurpPath, exportURLsPath
+}
+
+func exportFixture() []Result {
+	return []Result{
+		{Subdomain: "www.example.com", URL: "https://www.example.com", StatusCode: 200, InterestScore: 10},
+		{Subdomain: "admin.example.com", URL: "http://admin.example.com:8080/login", StatusCode: 401, InterestScore: 60, Flags: []string{"login-page", "admin-panel"}},
+		// Regex metacharacters a hostname can carry: the dots, and the
+		// wildcard label and brackets of names and addresses taken as seen
+		{Subdomain: "*.cdn+test.example.com", URL: "https://*.cdn+test.example.com", StatusCode: 200, InterestScore: 5},
+		{IP: "2001:db8::1", URL: "https://[2001:db8::1]:8443/", StatusCode: 403, InterestScore: 20, Flags: []string{"login-page"}},
+		// Dead, removed, or no URL: not exported
+		{Subdomain: "dead.example.com", URL: "https://dead.example.com"},
+		{Subdomain: "gone.example.com", URL: "https://gone.example.com", StatusCode: 200, ChangeType: changeRemoved},
+		{Subdomain: "nourl.example.com", StatusCode: 200},
+		// The same URL again
+		{Subdomain: "www.example.com", URL: "https://www.example.com", StatusCode: 200, InterestScore: 10},
+	}
+}
+
+func readBurpScope(t *testing.T, path string) burpScope {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scope burpScope
+	if err := json.Unmarshal(b, &scope); err != nil {
+		t.Fatalf("invalid scope file: %v", err)
+	}
+	return scope
+}
+
+// TestExportBurp writes a scope file Burp imports, one rule per host, port
+// and scheme with the host's regex metacharacters escaped, and the live
+// URLs, the most interesting first
+func TestExportBurp(t *testing.T) {
+	burp, urls := setExports(t, "")
+	for _, res := range exportFixture() {
+		observeExport(res)
+	}
+	writeExports()
+
+	scope := readBurpScope(t, burp).Target.Scope
+	if !scope.AdvancedMode || scope.Exclude == nil || len(scope.Include) != 4 {
+		t.Fatalf("scope %+v", scope)
+	}
+	want := []burpScopeRule{
+		{true, "^/.*", `^admin\.example\.com$`, "^8080$", "http"},
+		{true, "^/.*", `^2001:db8::1$`, "^8443$", "https"},
+		{true, "^/.*", `^www\.example\.com$`, "^443$", "https"},
+		{true, "^/.*", `^\*\.cdn\+test\.example\.com$`, "^443$", "https"},
+	}
+	if !slices.Equal(scope.Include, want) {
+		t.Errorf("include %+v\nwant %+v", scope.Include, want)
+	}
+	// Each host regex matches its own host and nothing else
+	for _, c := range []struct{ rule, yes, no string }{
+		{scope.Include[0].Host, "admin.example.com", "adminxexample.com"},
+		{scope.Include[3].Host, "*.cdn+test.example.com", "a.cdnntest.example.com"},
+	} {
+		re := regexp.MustCompile(c.rule)
+		if !re.MatchString(c.yes) || re.MatchString(c.no) {
+			t.Errorf("%s: matches %q %v, %q %v", c.rule, c.yes, re.MatchString(c.yes), c.no, re.MatchString(c.no))
+		}
+	}
+
+	b, err := os.ReadFile(urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != "http://admin.example.com:8080/login\nhttps://[2001:db8::1]:8443/\nhttps://www.example.com\nhttps://*.cdn+test.example.com\n" {
+		t.Errorf("urls.txt:\n%s", got)
+	}
+	if s := summary.Export; s == nil || s.Hosts != 4 || s.Burp != burp || s.URLs != urls {
+		t.Errorf("summary %+v", s)
+	}
+}
+
+// TestExportFilters applies -export-flags, -match-codes and -filter
+func TestExportFilters(t *testing.T) {
+	burp, _ := setExports(t, "admin-panel,login-page")
+	if err := setStatusFilter(t, "4xx", "403", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range exportFixture() {
+		observeExport(res)
+	}
+	writeExports()
+	if include := readBurpScope(t, burp).Target.Scope.Include; len(include) != 1 || include[0].Host != `^admin\.example\.com$` {
+		t.Errorf("-export-flags and -match-codes: include %+v", include)
+	}
+
+	burp, urls := setExports(t, "")
+	e, err := parseFilter("interest_score >= 20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultFilter = e
+	for _, res := range exportFixture() {
+		observeExport(res)
+	}
+	writeExports()
+	if include := readBurpScope(t, burp).Target.Scope.Include; len(include) != 2 {
+		t.Errorf("-filter: include %+v", include)
+	}
+	if b, _ := os.ReadFile(urls); string(b) != "http://admin.example.com:8080/login\nhttps://[2001:db8::1]:8443/\n" {
+		t.Errorf("-filter: urls.txt %q", b)
+	}
+}
+
+// TestExportEmpty writes an empty scope and URL list rather than none
+func TestExportEmpty(t *testing.T) {
+	burp, urls := setExports(t, "")
+	writeExports()
+	if include := readBurpScope(t, burp).Target.Scope.Include; include == nil || len(include) != 0 {
+		t.Errorf("include %v", include)
+	}
+	if b, err := os.ReadFile(urls); err != nil || len(b) != 0 {
+		t.Errorf("urls.txt %q, %v", b, err)
+	}
+}
+
+func TestConfigureExports(t *testing.T) {
+	setExports(t, "login-page")
+	exportBurpPath, exportURLsPath = "", ""
+	if err := configureExports(); err == nil {
+		t.Error("accepted -export-flags without an export")
+	}
+}
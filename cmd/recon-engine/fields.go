@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// fieldTree is a -fields or -exclude-fields selection: each JSON field
+// named, with the nested fields selected under it, or nil for all of it.
+// Under an array the selection applies to every element.
+type fieldTree map[string]fieldTree
+
+// includeFields and excludeFields are the parsed -fields and
+// -exclude-fields, nil when unset
+var includeFields, excludeFields fieldTree
+
+// configureFields parses -fields and -exclude-fields, checking every path
+// against the result schema so a typo fails before the scan starts. Called
+// once after flag parsing.
+func configureFields() error {
+	schema := resultSchema()
+	var err error
+	if includeFields, err = parseFields(fieldsFlag, schema); err != nil {
+		return fmt.Errorf("-fields: %w", err)
+	}
+	if excludeFields, err = parseFields(excludeFieldsFlag, schema); err != nil {
+		return fmt.Errorf("-exclude-fields: %w", err)
+	}
+	return nil
+}
+
+// parseFields reads a comma-separated list of dot paths such as
+// subdomain,tls.not_after. A field named whole takes in any narrower path
+// under it.
+func parseFields(list string, schema map[string]interface{}) (fieldTree, error) {
+	paths := splitList(list)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	tree := fieldTree{}
+	for _, path := range paths {
+		if err := checkFieldPath(schema, path); err != nil {
+			return nil, err
+		}
+		node := tree
+		parts := strings.Split(path, ".")
+		for i, name := range parts {
+			child, ok := node[name]
+			if i == len(parts)-1 {
+				node[name] = nil
+				break
+			}
+			if ok && child == nil {
+				break
+			}
+			if !ok {
+				child = fieldTree{}
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return tree, nil
+}
+
+// checkFieldPath reports an error unless path names a field of schema
+func checkFieldPath(schema map[string]interface{}, path string) error {
+	_, _, err := fieldSchema(schema, path)
+	return err
+}
+
+// fieldSchema returns the schema of the field path names and whether the
+// path goes through an array, making the field's value one per element.
+// Arrays are looked through to their elements, map fields take any key
+// and fields of any type anything below them.
+func fieldSchema(schema map[string]interface{}, path string) (map[string]interface{}, bool, error) {
+	s, many := schema, false
+	for _, name := range strings.Split(path, ".") {
+		if name == "" {
+			return nil, false, fmt.Errorf("malformed field path %q", path)
+		}
+		for {
+			items, ok := s["items"].(map[string]interface{})
+			if !ok {
+				break
+			}
+			s, many = items, true
+		}
+		if props, ok := s["properties"].(map[string]interface{}); ok {
+			field, ok := props[name].(map[string]interface{})
+			if !ok && name == path {
+				return nil, false, fmt.Errorf("unknown field %q (see the schema subcommand)", name)
+			}
+			if !ok {
+				return nil, false, fmt.Errorf("unknown field %q in %q (see the schema subcommand)", name, path)
+			}
+			s = field
+			continue
+		}
+		if values, ok := s["additionalProperties"].(map[string]interface{}); ok {
+			s = values
+			continue
+		}
+		if len(s) == 0 {
+			return s, many, nil
+		}
+		return nil, false, fmt.Errorf("%q: %s has no fields", path, strings.TrimSuffix(path, "."+name))
+	}
+	return s, many, nil
+}
+
+// projectResult returns what JSON sinks write for res: res itself, or
+// with -fields or -exclude-fields the selected fields of its JSON form
+func projectResult(res Result) interface{} {
+	if includeFields == nil && excludeFields == nil {
+		return res
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return res
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return res
+	}
+	if includeFields != nil {
+		v = keepFields(v, includeFields)
+	}
+	if excludeFields != nil {
+		v = dropFields(v, excludeFields)
+	}
+	return v
+}
+
+func keepFields(v interface{}, t fieldTree) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for name, sub := range t {
+			fv, ok := v[name]
+			if !ok {
+				continue
+			}
+			if sub != nil {
+				fv = keepFields(fv, sub)
+			}
+			out[name] = fv
+		}
+		return out
+	case []interface{}:
+		for i := range v {
+			v[i] = keepFields(v[i], t)
+		}
+	}
+	return v
+}
+
+func dropFields(v interface{}, t fieldTree) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, sub := range t {
+			if sub == nil {
+				delete(v, name)
+			} else if fv, ok := v[name]; ok {
+				v[name] = dropFields(fv, sub)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = dropFields(v[i], t)
+		}
+	}
+	return v
+}
+
+// fieldsEncoder writes the -fields projection of each Result as JSON
+type fieldsEncoder struct {
+	enc *json.Encoder
+}
+
+func (e fieldsEncoder) Encode(v interface{}) error {
+	if res, ok := v.(Result); ok {
+		v = projectResult(res)
+	}
+	return e.enc.Encode(v)
+}
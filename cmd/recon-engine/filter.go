@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// statusSet is a parsed -match-codes or -filter-codes list. Entries are
+// exact codes (404) or classes (4xx).
+type statusSet struct {
+	codes   map[int]bool
+	classes map[int]bool // the hundreds digit
+}
+
+func (s statusSet) empty() bool {
+	return len(s.codes) == 0 && len(s.classes) == 0
+}
+
+func (s statusSet) has(code int) bool {
+	return s.codes[code] || s.classes[code/100]
+}
+
+var matchSet, filterSet statusSet
+
+func parseStatusSet(list string) (statusSet, error) {
+	s := statusSet{codes: make(map[int]bool), classes: make(map[int]bool)}
+	for _, v := range splitList(list) {
+		v = strings.ToLower(v)
+		if len(v) == 3 && strings.HasSuffix(v, "xx") && v[0] >= '1' && v[0] <= '5' {
+			s.classes[int(v[0]-'0')] = true
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 100 || n > 599 {
+			return s, fmt.Errorf("%q is not an HTTP status code or class like 4xx", v)
+		}
+		s.codes[n] = true
+	}
+	return s, nil
+}
+
+// configureStatusFilter parses -match-codes and -filter-codes. Called once
+// after flag parsing.
+func configureStatusFilter() error {
+	var err error
+	if matchSet, err = parseStatusSet(matchCodes); err != nil {
+		return fmt.Errorf("-match-codes: %w", err)
+	}
+	if filterSet, err = parseStatusSet(filterCodes); err != nil {
+		return fmt.Errorf("-filter-codes: %w", err)
+	}
+	for code := range matchSet.codes {
+		if filterSet.codes[code] {
+			return fmt.Errorf("%d is in both -match-codes and -filter-codes", code)
+		}
+	}
+	return nil
+}
+
+// keepStatus reports whether a result with code is emitted. -match-codes
+// picks the codes to keep and -filter-codes removes codes from what is
+// left, so -match-codes 4xx -filter-codes 404 keeps every 4xx but 404.
+// Results without a status are dead hosts or records of other stages; once
+// any code filter is set they are only kept with -include-dead.
+func keepStatus(code int) bool {
+	if matchSet.empty() && filterSet.empty() {
+		return true
+	}
+	if code == 0 {
+		return includeDead
+	}
+	if !matchSet.empty() && !matchSet.has(code) {
+		return false
+	}
+	return !filterSet.has(code)
+}
+
+// filterResult counts res and reports whether it passes the status filters
+// and -filter.
+// Suppressed results are counted per status so the run summary shows what
+// was left out.
+func filterResult(res Result) bool {
+	stats.Add("results.total", 1)
+	if hideKnown && knownQuiet(res) {
+		stats.Add("results.suppressed.known", 1)
+		return false
+	}
+	if !keepStatus(res.StatusCode) {
+		stats.Add("results.suppressed", 1)
+		stats.Add("results.suppressed."+strconv.Itoa(res.StatusCode), 1)
+		return false
+	}
+	if resultFilter != nil && !resultFilter.match(res) {
+		stats.Add("results.suppressed.filter", 1)
+		return false
+	}
+	return true
+}
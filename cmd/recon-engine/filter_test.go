@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func setStatusFilter(t *testing.T, match, filter string, dead bool) error {
+	t.Helper()
+	oldMatch, oldFilter, oldDead, oldMatchSet, oldFilterSet := matchCodes, filterCodes, includeDead, matchSet, filterSet
+	t.Cleanup(func() {
+		matchCodes, filterCodes, includeDead, matchSet, filterSet = oldMatch, oldFilter, oldDead, oldMatchSet, oldFilterSet
+	})
+	matchCodes, filterCodes, includeDead = match, filter, dead
+	return configureStatusFilter()
+}
+
+func TestKeepStatus(t *testing.T) {
+	for _, c := range []struct {
+		match, filter string
+		dead          bool
+		keep, drop    []int
+	}{
+		{"", "", false, []int{0, 200, 404, 503}, nil},
+		{"200,301", "", false, []int{200, 301}, []int{0, 302, 404}},
+		{"2xx,3xx", "", false, []int{200, 204, 302}, []int{401, 500}},
+		{"", "404,5xx", false, []int{200, 403}, []int{0, 404, 500, 503}},
+		// -filter-codes removes from what -match-codes keeps
+		{"4xx", "404", false, []int{401, 403}, []int{404, 200}},
+		{"", "404", true, []int{0, 200}, []int{404}},
+	} {
+		if err := setStatusFilter(t, c.match, c.filter, c.dead); err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range c.keep {
+			if !keepStatus(code) {
+				t.Errorf("-match-codes %q -filter-codes %q dropped %d", c.match, c.filter, code)
+			}
+		}
+		for _, code := range c.drop {
+			if keepStatus(code) {
+				t.Errorf("-match-codes %q -filter-codes %q kept %d", c.match, c.filter, code)
+			}
+		}
+	}
+}
+
+func TestConfigureStatusFilterRejects(t *testing.T) {
+	for _, c := range []struct{ match, filter string }{
+		{"20", ""},
+		{"", "600"},
+		{"6xx", ""},
+		{"", "ok"},
+		{"404", "404"},
+	} {
+		if setStatusFilter(t, c.match, c.filter, false) == nil {
+			t.Errorf("-match-codes %q -filter-codes %q accepted", c.match, c.filter)
+		}
+	}
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// resultFilter is the compiled -filter expression, nil without one
+var resultFilter *filterExpr
+
+// filterExpr is a parsed -filter expression. Values are the JSON forms of
+// the Result fields: float64, string, bool, []interface{},
+// map[string]interface{} or nil.
+type filterExpr struct {
+	src  string
+	root filterNode
+}
+
+// filterNode is one node of the expression tree. strict evaluates both
+// sides of && and ||, so a dry run reaches every node.
+type filterNode interface {
+	eval(doc map[string]interface{}, strict bool) (interface{}, error)
+}
+
+// configureFilter compiles -filter and evaluates it once against a zero
+// Result, so unknown fields and type mismatches fail at startup. Called
+// once after flag parsing.
+func configureFilter() error {
+	if filterFlag == "" {
+		return nil
+	}
+	e, err := parseFilter(filterFlag)
+	if err != nil {
+		return err
+	}
+	if _, err := e.eval(Result{}, true); err != nil {
+		return err
+	}
+	resultFilter = e
+	return nil
+}
+
+// match reports whether res passes the filter. An expression that fails on
+// a result, which the startup check makes unlikely, drops it.
+func (e *filterExpr) match(res Result) bool {
+	ok, err := e.eval(res, false)
+	if err != nil {
+		stats.Add("results.filter_errors", 1)
+		return false
+	}
+	return ok
+}
+
+func (e *filterExpr) eval(res Result, strict bool) (bool, error) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return false, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return false, err
+	}
+	v, err := e.root.eval(doc, strict)
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := v.(bool)
+	if !isBool {
+		return false, fmt.Errorf("the expression is a %s, not a condition", filterType(v))
+	}
+	return ok, nil
+}
+
+// filterType names the type of a value in error messages
+func filterType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+type filterLiteral struct{ v interface{} }
+
+func (n filterLiteral) eval(map[string]interface{}, bool) (interface{}, error) {
+	return n.v, nil
+}
+
+// filterField is a field of the Result, by its JSON dot path. A field the
+// record leaves out has the zero value of its schema type.
+type filterField struct {
+	path []string
+	zero interface{}
+}
+
+func (n filterField) eval(doc map[string]interface{}, _ bool) (interface{}, error) {
+	if v := filterFieldValue(doc, n.path); v != nil {
+		return v, nil
+	}
+	return n.zero, nil
+}
+
+// filterFieldValue looks path up in v. Under an array the rest of the path
+// is looked up in every element and the values are collected into a list.
+func filterFieldValue(v interface{}, path []string) interface{} {
+	for i, name := range path {
+		switch d := v.(type) {
+		case map[string]interface{}:
+			v = d[name]
+		case []interface{}:
+			var out []interface{}
+			for _, e := range d {
+				switch ev := filterFieldValue(e, path[i:]).(type) {
+				case nil:
+				case []interface{}:
+					out = append(out, ev...)
+				default:
+					out = append(out, ev)
+				}
+			}
+			return out
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// filterZero is the value of a field of schema s the record leaves out
+func filterZero(s map[string]interface{}, many bool) interface{} {
+	if many {
+		return []interface{}{}
+	}
+	t := s["type"]
+	if ts, ok := t.([]string); ok && len(ts) > 0 {
+		t = ts[0]
+	}
+	switch t {
+	case "string":
+		return ""
+	case "integer", "number":
+		return float64(0)
+	case "boolean":
+		return false
+	case "array":
+		return []interface{}{}
+	case "object":
+		return map[string]interface{}{}
+	}
+	return nil
+}
+
+type filterNot struct{ x filterNode }
+
+func (n filterNot) eval(doc map[string]interface{}, strict bool) (interface{}, error) {
+	v, err := n.x.eval(doc, strict)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! needs a condition, got a %s", filterType(v))
+	}
+	return !b, nil
+}
+
+// filterLogic is && or ||
+type filterLogic struct {
+	op   string
+	l, r filterNode
+}
+
+func (n filterLogic) eval(doc map[string]interface{}, strict bool) (interface{}, error) {
+	l, err := filterBool(n.op, n.l, doc, strict)
+	if err != nil {
+		return nil, err
+	}
+	if !strict && l == (n.op == "||") {
+		return l, nil
+	}
+	r, err := filterBool(n.op, n.r, doc, strict)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "||" {
+		return l || r, nil
+	}
+	return l && r, nil
+}
+
+func filterBool(op string, x filterNode, doc map[string]interface{}, strict bool) (bool, error) {
+	v, err := x.eval(doc, strict)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs conditions on both sides, got a %s", op, filterType(v))
+	}
+	return b, nil
+}
+
+// filterCompare is a comparison, a regexp match or in
+type filterCompare struct {
+	op   string
+	l, r filterNode
+	re   *regexp.Regexp // for =~ and !~
+}
+
+func (n filterCompare) eval(doc map[string]interface{}, strict bool) (interface{}, error) {
+	l, err := n.l.eval(doc, strict)
+	if err != nil {
+		return nil, err
+	}
+	if n.re != nil {
+		matched, err := filterRegexp(n.op, n.re, l)
+		return matched != (n.op == "!~"), err
+	}
+	r, err := n.r.eval(doc, strict)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==", "!=":
+		eq, err := filterEqual(l, r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.op, err)
+		}
+		return eq == (n.op == "=="), nil
+	case "in":
+		list, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("in needs a list on the right, got a %s", filterType(r))
+		}
+		for _, e := range list {
+			if eq, err := filterEqual(l, e); err == nil && eq {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	c, err := filterOrder(l, r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.op, err)
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+// filterRegexp matches a string, or any string of a list, against re
+func filterRegexp(op string, re *regexp.Regexp, v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case string:
+		return re.MatchString(v), nil
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok && re.MatchString(s) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("%s needs a string or a list on the left, got a %s", op, filterType(v))
+}
+
+func filterEqual(l, r interface{}) (bool, error) {
+	if l == nil || r == nil {
+		return l == r, nil
+	}
+	switch l := l.(type) {
+	case float64, string, bool:
+		if filterType(l) != filterType(r) {
+			return false, fmt.Errorf("cannot compare a %s with a %s", filterType(l), filterType(r))
+		}
+		return l == r, nil
+	}
+	return false, fmt.Errorf("cannot compare a %s, use contains or len", filterType(l))
+}
+
+func filterOrder(l, r interface{}) (int, error) {
+	switch l := l.(type) {
+	case float64:
+		if r, ok := r.(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if r, ok := r.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot order a %s and a %s", filterType(l), filterType(r))
+}
+
+type filterList struct{ items []filterNode }
+
+func (n filterList) eval(doc map[string]interface{}, strict bool) (interface{}, error) {
+	out := make([]interface{}, 0, len(n.items))
+	for _, x := range n.items {
+		v, err := x.eval(doc, strict)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// filterFuncs are the functions -filter expressions can call, by arity
+var filterFuncs = map[string]struct {
+	args int
+	fn   func(args []interface{}) (interface{}, error)
+}{
+	"contains":   {2, filterContains},
+	"len":        {1, filterLen},
+	"lower":      {1, filterLower},
+	"startswith": {2, filterAffix(strings.HasPrefix)},
+	"endswith":   {2, filterAffix(strings.HasSuffix)},
+}
+
+// filterContains reports whether a string holds a substring, or a list an
+// element: strings compare case-insensitively and as substrings, as the
+// rules' tech_contains does, so contains(tech_stack, "wordpress") matches
+// WordPress:6.4
+func filterContains(args []interface{}) (interface{}, error) {
+	switch hay := args[0].(type) {
+	case string:
+		needle, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("contains on a string needs a string, got a %s", filterType(args[1]))
+		}
+		return strings.Contains(strings.ToLower(hay), strings.ToLower(needle)), nil
+	case []interface{}:
+		needle, isString := args[1].(string)
+		for _, e := range hay {
+			if s, ok := e.(string); ok && isString {
+				if strings.Contains(strings.ToLower(s), strings.ToLower(needle)) {
+					return true, nil
+				}
+				continue
+			}
+			if eq, err := filterEqual(e, args[1]); err == nil && eq {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("contains needs a string or a list, got a %s", filterType(args[0]))
+}
+
+func filterLen(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case string:
+		return float64(len(v)), nil
+	case []interface{}:
+		return float64(len(v)), nil
+	case map[string]interface{}:
+		return float64(len(v)), nil
+	case nil:
+		return float64(0), nil
+	}
+	return nil, fmt.Errorf("len needs a string, list or object, got a %s", filterType(args[0]))
+}
+
+func filterLower(args []interface{}) (interface{}, error) {
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("lower needs a string, got a %s", filterType(args[0]))
+	}
+	return strings.ToLower(s), nil
+}
+
+func filterAffix(has func(s, affix string) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		affix, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("startswith and endswith need two strings, got a %s and a %s", filterType(args[0]), filterType(args[1]))
+		}
+		return has(s, affix), nil
+	}
+}
+
+type filterCall struct {
+	name string
+	args []filterNode
+}
+
+func (n filterCall) eval(doc map[string]interface{}, strict bool) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, x := range n.args {
+		v, err := x.eval(doc, strict)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return filterFuncs[n.name].fn(args)
+}
+
+// filterToken is a lexical token of a -filter expression
+type filterToken struct {
+	kind string // ident, number, string, or the operator or punctuation itself
+	text string
+	pos  int // 1-based column
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lexFilter(src string) ([]filterToken, error) {
+	var toks []filterToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != src[i] {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("column %d: unterminated string", i+1)
+			}
+			body := src[i+1 : j]
+			if c == '\'' {
+				body = strings.ReplaceAll(strings.ReplaceAll(body, `\'`, `'`), `"`, `\"`)
+			}
+			s, err := strconv.Unquote(`"` + body + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: bad string %s", i+1, src[i:j+1])
+			}
+			toks = append(toks, filterToken{"string", s, i + 1})
+			i = j + 1
+			continue
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, filterToken{"number", src[i:j], i + 1})
+			i = j
+			continue
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, filterToken{"ident", src[i:j], i + 1})
+			i = j
+			continue
+		}
+		matched := false
+		for _, op := range filterOperators {
+			if strings.HasPrefix(src[i:], op) {
+				toks = append(toks, filterToken{op, op, i + 1})
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("column %d: unexpected %q", i+1, src[i])
+		}
+	}
+	return append(toks, filterToken{"end", "", len(src) + 1}), nil
+}
+
+// filterParser is a recursive descent parser over the tokens:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "!~" | "in" ) operand ]
+//	operand = literal | field | call | "(" or ")" | "[" [ or { "," or } ] "]"
+type filterParser struct {
+	toks   []filterToken
+	i      int
+	schema map[string]interface{}
+}
+
+// parseFilter parses src, checking field names against the result schema
+// and function names and arities
+func parseFilter(src string) (*filterExpr, error) {
+	toks, err := lexFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks: toks, schema: resultSchema()}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return nil, fmt.Errorf("column %d: unexpected %q", t.pos, t.text)
+	}
+	return &filterExpr{src: src, root: root}, nil
+}
+
+func (p *filterParser) peek() filterToken { return p.toks[p.i] }
+
+func (p *filterParser) next() filterToken {
+	t := p.toks[p.i]
+	if t.kind != "end" {
+		p.i++
+	}
+	return t
+}
+
+func (p *filterParser) expect(kind string) error {
+	if t := p.next(); t.kind != kind {
+		if t.kind == "end" {
+			return fmt.Errorf("column %d: expected %q, got the end of the expression", t.pos, kind)
+		}
+		return fmt.Errorf("column %d: expected %q, got %q", t.pos, kind, t.text)
+	}
+	return nil
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	l, err := p.and()
+	for err == nil && p.peek().kind == "||" {
+		p.next()
+		var r filterNode
+		if r, err = p.and(); err == nil {
+			l = filterLogic{"||", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	l, err := p.unary()
+	for err == nil && p.peek().kind == "&&" {
+		p.next()
+		var r filterNode
+		if r, err = p.unary(); err == nil {
+			l = filterLogic{"&&", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) unary() (filterNode, error) {
+	if p.peek().kind == "!" {
+		p.next()
+		x, err := p.unary()
+		return filterNot{x}, err
+	}
+	return p.compare()
+}
+
+func (p *filterParser) compare() (filterNode, error) {
+	l, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	op := t.kind
+	if t.kind == "ident" && t.text == "in" {
+		op = "in"
+	}
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+		p.next()
+		r, err := p.operand()
+		return filterCompare{op: op, l: l, r: r}, err
+	case "=~", "!~":
+		p.next()
+		rt := p.next()
+		if rt.kind != "string" {
+			return nil, fmt.Errorf("column %d: %s needs a quoted regular expression", rt.pos, op)
+		}
+		re, err := regexp.Compile(rt.text)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %w", rt.pos, err)
+		}
+		return filterCompare{op: op, l: l, re: re}, nil
+	}
+	return l, nil
+}
+
+func (p *filterParser) operand() (filterNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return filterLiteral{t.text}, nil
+	case "number":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: bad number %q", t.pos, t.text)
+		}
+		return filterLiteral{n}, nil
+	case "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case "[":
+		var items []filterNode
+		for p.peek().kind != "]" {
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, x)
+			if p.peek().kind != "," {
+				break
+			}
+			p.next()
+		}
+		return filterList{items}, p.expect("]")
+	case "ident":
+		switch t.text {
+		case "true", "false":
+			return filterLiteral{t.text == "true"}, nil
+		case "null":
+			return filterLiteral{nil}, nil
+		}
+		if p.peek().kind == "(" {
+			return p.call(t)
+		}
+		s, many, err := fieldSchema(p.schema, t.text)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %w", t.pos, err)
+		}
+		return filterField{path: strings.Split(t.text, "."), zero: filterZero(s, many)}, nil
+	case "end":
+		return nil, fmt.Errorf("column %d: the expression ends too soon", t.pos)
+	}
+	return nil, fmt.Errorf("column %d: unexpected %q", t.pos, t.text)
+}
+
+func (p *filterParser) call(name filterToken) (filterNode, error) {
+	f, ok := filterFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("column %d: unknown function %q (want contains, len, lower, startswith or endswith)", name.pos, name.text)
+	}
+	p.next() // (
+	var args []filterNode
+	for p.peek().kind != ")" {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+		if p.peek().kind != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(args) != f.args {
+		return nil, fmt.Errorf("column %d: %s takes %d arguments, got %d", name.pos, name.text, f.args, len(args))
+	}
+	return filterCall{name.text, args}, nil
+}
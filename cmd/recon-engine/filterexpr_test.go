@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func mustFilter(t *testing.T, src string) *filterExpr {
+	t.Helper()
+	e, err := parseFilter(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return e
+}
+
+// filterCase is an expression with a Result it holds for and one it does not
+type filterCase struct {
+	src      string
+	yes, not Result
+}
+
+func checkFilterCases(t *testing.T, cases []filterCase) {
+	t.Helper()
+	for _, c := range cases {
+		e := mustFilter(t, c.src)
+		if _, err := e.eval(Result{}, true); err != nil {
+			t.Errorf("%s: fails the startup check: %v", c.src, err)
+		}
+		if ok, err := e.eval(c.yes, false); !ok || err != nil {
+			t.Errorf("%s: does not hold for %+v: %v", c.src, c.yes, err)
+		}
+		if ok, err := e.eval(c.not, false); ok || err != nil {
+			t.Errorf("%s: holds for %+v: %v", c.src, c.not, err)
+		}
+	}
+}
+
+func TestFilterPrecedence(t *testing.T) {
+	for src, want := range map[string]bool{
+		"true || false && false":            true,
+		"(true || false) && false":          false,
+		"false && false || true":            true,
+		"false && (false || true)":          false,
+		"!false && false":                   false,
+		"!(false && false)":                 true,
+		"!true || true":                     true,
+		"!!true":                            true,
+		"!status_code == 200 && true":       true,
+		"status_code == 0 || title == \"\"": true,
+	} {
+		if got, err := mustFilter(t, src).eval(Result{}, false); err != nil || got != want {
+			t.Errorf("%s = %v, %v, want %v", src, got, err, want)
+		}
+	}
+}
+
+func TestFilterOperators(t *testing.T) {
+	checkFilterCases(t, []filterCase{
+		// in lists
+		{`status_code in [301, 302, 307]`, Result{StatusCode: 302}, Result{StatusCode: 200}},
+		{`title in ["Login", 'Sign in']`, Result{Title: "Sign in"}, Result{Title: "login"}},
+		// =~ and !~ on strings and lists: any element matches
+		{`tech_stack =~ "^nginx"`, Result{TechStack: []string{"PHP", "nginx:1.25"}}, Result{TechStack: []string{"Apache nginx"}}},
+		{`tech_stack !~ "(?i)wordpress"`, Result{TechStack: []string{"Drupal"}}, Result{TechStack: []string{"Drupal", "WordPress"}}},
+		{`flags =~ "admin"`, Result{Flags: []string{"login-page", "admin-panel"}}, Result{}},
+		{`url !~ "^https:"`, Result{URL: "http://www.example.com"}, Result{URL: "https://www.example.com"}},
+		// contains is case-insensitive and matches substrings of strings
+		{`contains(tech_stack, "wordpress")`, Result{TechStack: []string{"WordPress:6.4"}}, Result{TechStack: []string{"Drupal"}}},
+		{`contains(title, "ADMIN")`, Result{Title: "Site Administration"}, Result{Title: "Home"}},
+		{`contains(open_ports.port, 8080)`, Result{OpenPorts: []OpenPort{{Port: 22}, {Port: 8080}}}, Result{OpenPorts: []OpenPort{{Port: 80}}}},
+		// Dot paths through lists collect every element's value
+		{`open_ports.service =~ "^ssh"`, Result{OpenPorts: []OpenPort{{Port: 443, Service: "https"}, {Port: 22, Service: "ssh"}}}, Result{OpenPorts: []OpenPort{{Port: 443, Service: "https"}}}},
+		{`len(paths.status) == 2`, Result{Paths: []PathHit{{Path: "/a", Status: 200}, {Path: "/b", Status: 403}}}, Result{Paths: []PathHit{{Path: "/a", Status: 200}}}},
+		{`contains(cookies.name, "session") && !contains(cookies.secure, true)`, Result{Cookies: []CookieInfo{{Name: "SESSIONID"}}}, Result{Cookies: []CookieInfo{{Name: "sessionid", Secure: true}}}},
+		// Fields a record leaves out are empty, 0 or false
+		{`len(tech_stack) == 0 && cdn == "" && !blocked`, Result{}, Result{CDN: "fastly"}},
+		{`len(security_txt.contact) == 0 && security_txt.url == ""`, Result{}, Result{SecurityTxt: &SecurityTxt{URL: "https://www.example.com/.well-known/security.txt"}}},
+		// Functions and ordering
+		{`startswith(lower(subdomain), "api.") && endswith(subdomain, ".com")`, Result{Subdomain: "API.example.com"}, Result{Subdomain: "www.api.example.com"}},
+		{`interest_score >= 50 && interest_score < 80`, Result{InterestScore: 50}, Result{InterestScore: 80}},
+		{`header_grade > "C"`, Result{HeaderGrade: "D"}, Result{HeaderGrade: "B"}},
+	})
+}
+
+// TestFilterStartupErrors fails unknown fields, bad syntax and type
+// mismatches before the scan starts, on both sides of && and ||
+func TestFilterStartupErrors(t *testing.T) {
+	oldFlag, oldFilter := filterFlag, resultFilter
+	t.Cleanup(func() { filterFlag, resultFilter = oldFlag, oldFilter })
+	for src, want := range map[string]string{
+		`status_cod == 200`:                   "status_cod",
+		`vulnerabilities.nosuch == "x"`:       "nosuch",
+		`status_code == "200"`:                "cannot compare a number with a string",
+		`title > 3`:                           "cannot order",
+		`status_code`:                         "not a condition",
+		`!title`:                              "! needs a condition",
+		`title && true`:                       "&& needs conditions",
+		`true || status_code == "200"`:        "cannot compare",
+		`tech_stack == "nginx"`:               "use contains or len",
+		`status_code in 200`:                  "in needs a list",
+		`status_code =~ "2.."`:                "=~ needs a string or a list",
+		`title =~ "("`:                        "missing closing )",
+		`title =~ title`:                      "quoted regular expression",
+		`contains(tech_stack)`:                "contains takes 2 arguments",
+		`exists(title)`:                       "unknown function",
+		`len(status_code) > 0`:                "len needs",
+		`title == "unterminated`:              "unterminated string",
+		`status_code == 200 &&`:               "ends too soon",
+		`(status_code == 200`:                 `expected ")"`,
+		`status_code == 200 status_code`:      "unexpected",
+		`1 < 2 == true`:                       "unexpected",
+		`status_code # 200`:                   "unexpected",
+		`contains(status_code, 200)`:          "contains needs a string or a list",
+		`startswith(status_code, "2")`:        "need two strings",
+		`contains(title, 3)`:                  "contains on a string needs a string",
+		`country != "" && !(country in US)`:   "US",
+		`change_type in ["new", "changed"] |`: "unexpected",
+	} {
+		filterFlag, resultFilter = src, nil
+		err := configureFilter()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, want an error with %q", src, err, want)
+		}
+		if resultFilter != nil {
+			t.Errorf("%s: set as the filter", src)
+		}
+	}
+	filterFlag = `status_code == 200`
+	if err := configureFilter(); err != nil || resultFilter == nil {
+		t.Errorf("valid filter: %v", err)
+	}
+}
+
+// filterCookbook are the Result each -filter example of the help holds
+// for and one it does not
+var filterCookbook = map[string][2]Result{
+	`status_code == 200 && contains(tech_stack, "WordPress")`: {
+		{StatusCode: 200, TechStack: []string{"wordpress:6.4"}},
+		{StatusCode: 200, TechStack: []string{"Drupal"}},
+	},
+	`status_code in [401, 403] && !contains(flags, "login-page")`: {
+		{StatusCode: 403, Flags: []string{"admin-panel"}},
+		{StatusCode: 401, Flags: []string{"login-page"}},
+	},
+	`contains(vulnerabilities.severity, "critical")`: {
+		{Vulnerabilities: []Finding{{ID: "a", Severity: severityHigh}, {ID: "b", Severity: severityCritical}}},
+		{Vulnerabilities: []Finding{{ID: "a", Severity: severityHigh}}},
+	},
+	`len(vulnerabilities) > 0 || interest_score >= 50`: {
+		{InterestScore: 50},
+		{InterestScore: 49},
+	},
+	`subdomain =~ "^(dev|staging|test)[.-]"`: {
+		{Subdomain: "staging-api.example.com"},
+		{Subdomain: "www.dev.example.com"},
+	},
+	`title =~ "(?i)index of /" || contains(flags, "dir-listing")`: {
+		{Title: "Index of /backup"},
+		{Title: "Home"},
+	},
+	`tech_stack =~ "^(Jenkins|GitLab|Grafana)"`: {
+		{TechStack: []string{"nginx", "Grafana:10.2"}},
+		{TechStack: []string{"nginx"}},
+	},
+	`country != "" && !(country in ["US", "GB"])`: {
+		{Country: "DE"},
+		{Country: "GB"},
+	},
+	`change_type in ["new", "changed"]`: {
+		{ChangeType: changeNew},
+		{ChangeType: changeRemoved},
+	},
+	`!known && owner == "unassigned"`: {
+		{Owner: "unassigned"},
+		{Owner: "unassigned", Known: true},
+	},
+	`cdn == "" && len(open_ports) > 2`: {
+		{OpenPorts: []OpenPort{{Port: 22}, {Port: 80}, {Port: 443}}},
+		{CDN: "cloudflare", OpenPorts: []OpenPort{{Port: 22}, {Port: 80}, {Port: 443}}},
+	},
+	`header_grade in ["D", "F"] && !blocked`: {
+		{HeaderGrade: "F"},
+		{HeaderGrade: "F", Blocked: true},
+	},
+}
+
+// helpFilterExamples returns the -filter examples the scan help lists
+func helpFilterExamples(t *testing.T) []string {
+	t.Helper()
+	var out bytes.Buffer
+	flag.CommandLine.SetOutput(&out)
+	defer flag.CommandLine.SetOutput(nil)
+	usage("scan")
+
+	var examples []string
+	section, listing := false, false
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "Filter expressions:":
+			section = true
+		case !section:
+		case !strings.HasPrefix(line, "  "):
+			return examples
+		case strings.HasSuffix(line, "Examples:"):
+			listing = true
+		case listing && strings.HasPrefix(line, "    "):
+			examples = append(examples, strings.TrimSpace(line))
+		}
+	}
+	return examples
+}
+
+// TestFilterCookbook evaluates every example of the help against a result
+// it holds for and one it does not
+func TestFilterCookbook(t *testing.T) {
+	examples := helpFilterExamples(t)
+	if len(examples) != len(filterCookbook) {
+		t.Errorf("the help has %d examples, the test %d", len(examples), len(filterCookbook))
+	}
+	var cases []filterCase
+	for _, src := range examples {
+		r, ok := filterCookbook[src]
+		if !ok {
+			t.Errorf("no results for the example %s", src)
+			continue
+		}
+		cases = append(cases, filterCase{src, r[0], r[1]})
+	}
+	checkFilterCases(t, cases)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity is how serious a Finding is
+type Severity string
+
+const (
+	severityInfo     Severity = "info"
+	severityLow      Severity = "low"
+	severityMedium   Severity = "medium"
+	severityHigh     Severity = "high"
+	severityCritical Severity = "critical"
+)
+
+// severityAliases maps the other names severities go by, NVD's and
+// nuclei's included, to the enum
+var severityAliases = map[string]Severity{
+	"info":          severityInfo,
+	"informational": severityInfo,
+	"none":          severityInfo,
+	"unknown":       severityInfo,
+	"low":           severityLow,
+	"medium":        severityMedium,
+	"moderate":      severityMedium,
+	"high":          severityHigh,
+	"critical":      severityCritical,
+}
+
+// parseSeverity returns the Severity s names, in any case
+func parseSeverity(s string) (Severity, bool) {
+	sev, ok := severityAliases[strings.ToLower(strings.TrimSpace(s))]
+	return sev, ok
+}
+
+// rank orders severities from info, 0, to critical, 4. Anything else ranks
+// below info.
+func (s Severity) rank() int {
+	switch s {
+	case severityInfo:
+		return 0
+	case severityLow:
+		return 1
+	case severityMedium:
+		return 2
+	case severityHigh:
+		return 3
+	case severityCritical:
+		return 4
+	}
+	return -1
+}
+
+// cvssSeverity bands a CVSS score as CVSS v3 does: 9.0 critical, 7.0 high,
+// 4.0 medium, 0.1 low, and info below
+func cvssSeverity(score float64) Severity {
+	switch {
+	case score >= 9:
+		return severityCritical
+	case score >= 7:
+		return severityHigh
+	case score >= 4:
+		return severityMedium
+	case score > 0:
+		return severityLow
+	}
+	return severityInfo
+}
+
+// Finding confidences: seen happen, inferred from strong evidence, or
+// inferred from a version or a response that may have other causes
+const (
+	confidenceConfirmed = "confirmed"
+	confidenceFirm      = "firm"
+	confidenceTentative = "tentative"
+)
+
+// Finding is one weakness a stage found on a host. Every stage that
+// reports findings builds them with newFinding.
+type Finding struct {
+	ID         string                 `json:"id"`
+	Title      string                 `json:"title,omitempty"`
+	Severity   Severity               `json:"severity"`
+	Confidence string                 `json:"confidence,omitempty"`
+	Evidence   map[string]interface{} `json:"evidence,omitempty"`
+	Reference  string                 `json:"reference,omitempty"`
+	Stage      string                 `json:"stage"`
+	DetectedAt string                 `json:"detected_at,omitempty"`
+}
+
+// invalidSeverities are the severities a stage passed that are not in the
+// enum, each reported once
+var (
+	invalidSeverities   = make(map[string]bool)
+	invalidSeveritiesMu sync.Mutex
+)
+
+// newFinding builds a Finding of stage. A severity outside the enum is a bug
+// in the stage or an answer no alias covers: it is reported once and the
+// finding is recorded as info.
+func newFinding(stage, id, severity, confidence, title string, evidence map[string]interface{}) Finding {
+	sev, ok := parseSeverity(severity)
+	if !ok {
+		sev = severityInfo
+		stats.Add("findings.invalid_severity", 1)
+		invalidSeveritiesMu.Lock()
+		if !invalidSeverities[stage+"|"+severity] {
+			invalidSeverities[stage+"|"+severity] = true
+			fmt.Fprintf(os.Stderr, "Warning: %s reported %s with severity %q, recorded as info\n", stage, id, severity)
+		}
+		invalidSeveritiesMu.Unlock()
+	}
+	return Finding{
+		ID:         id,
+		Title:      title,
+		Severity:   sev,
+		Confidence: confidence,
+		Evidence:   evidence,
+		Stage:      stage,
+		DetectedAt: time.Now().Format(time.RFC3339),
+	}
+}
+
+// findingKeys are the keys a Finding encodes itself under, legacy aliases
+// included
+var findingKeys = map[string]bool{
+	"id": true, "title": true, "severity": true, "confidence": true, "evidence": true,
+	"reference": true, "stage": true, "detected_at": true, "source": true, "summary": true,
+}
+
+// MarshalJSON writes the Finding as an object readers of schema 1.1 still
+// understand: "source" and "summary" repeat stage and title, and each
+// evidence entry is repeated at the top level, where the stages used to put
+// their details
+func (f Finding) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(f.Evidence)+10)
+	for k, v := range f.Evidence {
+		if !findingKeys[k] {
+			m[k] = v
+		}
+	}
+	m["id"] = f.ID
+	m["severity"] = f.Severity
+	m["stage"] = f.Stage
+	m["source"] = f.Stage
+	if f.Title != "" {
+		m["title"] = f.Title
+		m["summary"] = f.Title
+	}
+	if f.Confidence != "" {
+		m["confidence"] = f.Confidence
+	}
+	if len(f.Evidence) > 0 {
+		m["evidence"] = f.Evidence
+	}
+	if f.Reference != "" {
+		m["reference"] = f.Reference
+	}
+	if f.DetectedAt != "" {
+		m["detected_at"] = f.DetectedAt
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON reads a Finding as MarshalJSON writes it, and the entries of
+// earlier schemas and nuclei: source for stage, summary for title, nuclei's
+// template-id and info block, and top-level details, which become evidence
+func (f *Finding) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			if s, ok := m[k].(string); ok && s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	*f = Finding{
+		ID:         str("id", "template-id"),
+		Title:      str("title", "summary"),
+		Confidence: str("confidence"),
+		Reference:  str("reference"),
+		Stage:      str("stage", "source"),
+		DetectedAt: str("detected_at"),
+	}
+	sev := str("severity")
+	if info, ok := m["info"].(map[string]interface{}); ok {
+		if s, ok := info["severity"].(string); ok && sev == "" {
+			sev = s
+		}
+		if s, ok := info["name"].(string); ok && f.Title == "" {
+			f.Title = s
+		}
+	}
+	if ev, ok := m["evidence"].(map[string]interface{}); ok {
+		f.Evidence = ev
+	}
+	for k, v := range m {
+		if findingKeys[k] {
+			continue
+		}
+		if _, dup := f.Evidence[k]; dup {
+			continue
+		}
+		if f.Evidence == nil {
+			f.Evidence = make(map[string]interface{})
+		}
+		f.Evidence[k] = v
+	}
+	// Entries without a severity are banded by their CVSS score
+	var ok bool
+	if f.Severity, ok = parseSeverity(sev); !ok {
+		score, _ := f.CVSS()
+		f.Severity = cvssSeverity(score)
+	}
+	return nil
+}
+
+// Name is the finding's title, or its ID when it has none
+func (f Finding) Name() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.ID
+}
+
+// CVSS returns the finding's CVSS score: the NVD lookup's or nuclei's
+// info.classification.cvss-score
+func (f Finding) CVSS() (float64, bool) {
+	raw := f.Evidence["cvss"]
+	if info, ok := f.Evidence["info"].(map[string]interface{}); ok && raw == nil {
+		if c, ok := info["classification"].(map[string]interface{}); ok {
+			raw = c["cvss-score"]
+		}
+	}
+	switch s := raw.(type) {
+	case float64:
+		return s, true
+	case string:
+		score, err := strconv.ParseFloat(s, 64)
+		return score, err == nil
+	}
+	return 0, false
+}
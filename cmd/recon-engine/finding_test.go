@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestFindingRoundTrip reads back what newFinding writes, with the legacy
+// aliases written next to the fields
+func TestFindingRoundTrip(t *testing.T) {
+	f := newFinding("cors", "cors-wildcard", "High", confidenceFirm, "CORS allows any origin", map[string]interface{}{
+		"origin": "https://evil.example",
+		"status": float64(200),
+	})
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["source"] != "cors" || m["summary"] != "CORS allows any origin" || m["origin"] != "https://evil.example" {
+		t.Errorf("legacy aliases missing: %s", b)
+	}
+
+	var got Finding
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("round trip:\n got %+v\nwant %+v", got, f)
+	}
+}
+
+// TestFindingLegacy reads a finding as schema 1.1 wrote it: source, summary
+// and its details at the top level
+func TestFindingLegacy(t *testing.T) {
+	var f Finding
+	entry := `{"id": "exposed-git", "severity": "medium", "source": "endpoints", "summary": "/.git/HEAD is readable", "path": "/.git/HEAD", "status": 200}`
+	if err := json.Unmarshal([]byte(entry), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.ID != "exposed-git" || f.Severity != severityMedium || f.Title != "/.git/HEAD is readable" || f.Stage != "endpoints" {
+		t.Errorf("legacy entry: %+v", f)
+	}
+	if len(f.Evidence) != 2 || f.Evidence["path"] != "/.git/HEAD" || f.Evidence["status"] != float64(200) {
+		t.Errorf("legacy evidence %v", f.Evidence)
+	}
+}
+
+// TestFindingNuclei reads a raw nuclei entry: template-id, and the name and
+// severity in its info block
+func TestFindingNuclei(t *testing.T) {
+	var f Finding
+	entry := `{"template-id": "apache-status", "info": {"name": "Apache Server Status", "severity": "low", "classification": {"cvss-score": 5.3}}, "matched-at": "https://www.example.com/server-status", "type": "http"}`
+	if err := json.Unmarshal([]byte(entry), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.ID != "apache-status" || f.Severity != severityLow || f.Title != "Apache Server Status" {
+		t.Errorf("nuclei entry: %+v", f)
+	}
+	if f.Evidence["matched-at"] != "https://www.example.com/server-status" || f.Evidence["type"] != "http" {
+		t.Errorf("nuclei evidence %v", f.Evidence)
+	}
+	if score, ok := f.CVSS(); !ok || score != 5.3 {
+		t.Errorf("CVSS %v %v", score, ok)
+	}
+
+	// Without a severity, the CVSS score bands it
+	delete(f.Evidence["info"].(map[string]interface{}), "severity")
+	b, err := json.Marshal(f.Evidence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var banded Finding
+	if err := json.Unmarshal(b, &banded); err != nil {
+		t.Fatal(err)
+	}
+	if banded.Severity != severityMedium {
+		t.Errorf("banded as %s, want medium", banded.Severity)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for s, want := range map[string]Severity{"Critical": severityCritical, " moderate ": severityMedium, "informational": severityInfo} {
+		if got, ok := parseSeverity(s); !ok || got != want {
+			t.Errorf("parseSeverity(%q) = %s %v", s, got, ok)
+		}
+	}
+	if _, ok := parseSeverity("urgent"); ok {
+		t.Error("accepted urgent")
+	}
+	if f := newFinding("test", "x", "urgent", "", "", nil); f.Severity != severityInfo {
+		t.Errorf("invalid severity recorded as %s", f.Severity)
+	}
+}
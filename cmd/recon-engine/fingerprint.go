@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed rules/wappalyzer.json
+var defaultFingerprints []byte
+
+// The -fingerprint-engine choices: WhatWeb, the native matcher over the
+// Wappalyzer fingerprints, or both, WhatWeb first
+const (
+	fingerprintEngineWhatWeb = "whatweb"
+	fingerprintEngineNative  = "native"
+	fingerprintEngineBoth    = "both"
+)
+
+// detectionProbe is the detection_source of the technologies the probe
+// reported before any fingerprinting
+const detectionProbe = "probe"
+
+// techFingerprints are the embedded fingerprints followed by those of
+// -tech-fingerprints, which replace embedded ones of the same name
+var techFingerprints []*techFingerprint
+
+// fingerprintsSkipped counts the patterns left out because RE2 cannot
+// compile them: Wappalyzer's are JavaScript regexes, a few with lookarounds
+var fingerprintsSkipped int
+
+// fingerprintHTTP fetches the page the native matcher looks at. Redirects
+// are followed, as WhatWeb does, so a root redirecting to the application
+// is fingerprinted as the application.
+var fingerprintHTTP = &http.Client{Timeout: 15 * time.Second, Transport: limitedTransport{insecure: true}}
+
+// techFingerprint is one technology of the Wappalyzer dataset, reduced to
+// what one response can show: headers, cookies, meta tags, the HTML (html,
+// text and scripts patterns alike), script src URLs and the URL itself.
+// The js, dom, css and dns patterns need a browser or more requests and
+// are ignored.
+type techFingerprint struct {
+	name      string
+	headers   map[string][]fingerprintPattern // lower-case header name
+	cookies   map[string][]fingerprintPattern
+	meta      map[string][]fingerprintPattern // lower-case meta name
+	html      []fingerprintPattern
+	scriptSrc []fingerprintPattern
+	url       []fingerprintPattern
+	implies   []fingerprintImply
+	excludes  []string
+}
+
+// fingerprintPattern is a Wappalyzer pattern: a regex with optional
+// \;version: and \;confidence: tags
+type fingerprintPattern struct {
+	re         *regexp.Regexp
+	version    string
+	confidence int
+}
+
+type fingerprintImply struct {
+	name       string
+	confidence int
+}
+
+// techDetection is a technology the native matcher found on a page
+type techDetection struct {
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Confidence int    `json:"confidence"`
+}
+
+// configureFingerprints checks -fingerprint-engine and loads the
+// fingerprints when the native matcher runs. Called once after flag
+// parsing and -tech-aliases.
+func configureFingerprints() error {
+	switch fingerprintEngine {
+	case fingerprintEngineWhatWeb:
+		if techFingerprintsPath != "" {
+			return fmt.Errorf("-tech-fingerprints needs -fingerprint-engine native or both")
+		}
+		return nil
+	case fingerprintEngineNative, fingerprintEngineBoth:
+	default:
+		return fmt.Errorf("-fingerprint-engine: want whatweb, native or both, got %q", fingerprintEngine)
+	}
+	if !useFingerprint {
+		return nil
+	}
+	return loadFingerprints(techFingerprintsPath)
+}
+
+// nativeFingerprinting reports whether -fingerprint runs the native matcher
+func nativeFingerprinting() bool {
+	return useFingerprint && fingerprintEngine != fingerprintEngineWhatWeb
+}
+
+// whatwebFingerprinting reports whether -fingerprint runs WhatWeb
+func whatwebFingerprinting() bool {
+	return useFingerprint && fingerprintEngine != fingerprintEngineNative
+}
+
+// loadFingerprints loads the embedded fingerprints and those of path, a
+// Wappalyzer JSON file or a directory of them, on top
+func loadFingerprints(path string) error {
+	byName := make(map[string]*techFingerprint)
+	var order []string
+	add := func(data []byte, source string) error {
+		fps, err := parseFingerprints(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		for _, fp := range fps {
+			if _, ok := byName[fp.name]; !ok {
+				order = append(order, fp.name)
+			}
+			byName[fp.name] = fp
+		}
+		return nil
+	}
+	fingerprintsSkipped = 0
+	if err := add(defaultFingerprints, "embedded fingerprints"); err != nil {
+		return err
+	}
+	if path != "" {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return err
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+				return err
+			}
+			sort.Strings(files)
+		}
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			if err := add(data, f); err != nil {
+				return err
+			}
+		}
+	}
+	techFingerprints = techFingerprints[:0]
+	for _, name := range order {
+		techFingerprints = append(techFingerprints, byName[name])
+	}
+	return nil
+}
+
+// wappalyzerTech is a technology as Wappalyzer's JSON has it; most fields
+// hold a string or a list of them
+type wappalyzerTech struct {
+	Headers   map[string]json.RawMessage `json:"headers"`
+	Cookies   map[string]json.RawMessage `json:"cookies"`
+	Meta      map[string]json.RawMessage `json:"meta"`
+	HTML      json.RawMessage            `json:"html"`
+	Text      json.RawMessage            `json:"text"`
+	Scripts   json.RawMessage            `json:"scripts"`
+	ScriptSrc json.RawMessage            `json:"scriptSrc"`
+	URL       json.RawMessage            `json:"url"`
+	Implies   json.RawMessage            `json:"implies"`
+	Excludes  json.RawMessage            `json:"excludes"`
+}
+
+// parseFingerprints reads Wappalyzer's format: an object of technologies
+// by name, bare as in its technologies/*.json or under "technologies"
+func parseFingerprints(data []byte) ([]*techFingerprint, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	if techs, ok := top["technologies"]; ok {
+		if err := json.Unmarshal(techs, &top); err != nil {
+			return nil, fmt.Errorf("technologies: %w", err)
+		}
+	}
+	names := make([]string, 0, len(top))
+	for name := range top {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fps []*techFingerprint
+	for _, name := range names {
+		var t wappalyzerTech
+		if err := json.Unmarshal(top[name], &t); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fp, err := t.compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fps = append(fps, fp)
+	}
+	return fps, nil
+}
+
+func (t wappalyzerTech) compile(name string) (*techFingerprint, error) {
+	fp := &techFingerprint{name: name}
+	var err error
+	if fp.headers, err = patternMap(t.Headers); err != nil {
+		return nil, fmt.Errorf("headers: %w", err)
+	}
+	if fp.cookies, err = patternMap(t.Cookies); err != nil {
+		return nil, fmt.Errorf("cookies: %w", err)
+	}
+	if fp.meta, err = patternMap(t.Meta); err != nil {
+		return nil, fmt.Errorf("meta: %w", err)
+	}
+	for _, f := range []struct {
+		name string
+		raw  json.RawMessage
+		dst  *[]fingerprintPattern
+	}{
+		{"html", t.HTML, &fp.html},
+		{"text", t.Text, &fp.html},
+		{"scripts", t.Scripts, &fp.html},
+		{"scriptSrc", t.ScriptSrc, &fp.scriptSrc},
+		{"url", t.URL, &fp.url},
+	} {
+		list, err := stringList(f.raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.dst = append(*f.dst, compilePatterns(list)...)
+	}
+	implies, err := stringList(t.Implies)
+	if err != nil {
+		return nil, fmt.Errorf("implies: %w", err)
+	}
+	for _, s := range implies {
+		name, tags, _ := strings.Cut(s, `\;`)
+		_, confidence := patternTags(tags)
+		fp.implies = append(fp.implies, fingerprintImply{name: name, confidence: confidence})
+	}
+	if fp.excludes, err = stringList(t.Excludes); err != nil {
+		return nil, fmt.Errorf("excludes: %w", err)
+	}
+	return fp, nil
+}
+
+// stringList reads a string or a list of strings
+func stringList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("want a string or a list of strings")
+	}
+	return list, nil
+}
+
+func patternMap(m map[string]json.RawMessage) (map[string][]fingerprintPattern, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	out := make(map[string][]fingerprintPattern, len(m))
+	for k, raw := range m {
+		list, err := stringList(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		if ps := compilePatterns(list); len(ps) > 0 {
+			out[strings.ToLower(k)] = append(out[strings.ToLower(k)], ps...)
+		}
+	}
+	return out, nil
+}
+
+// compilePatterns compiles Wappalyzer patterns case-insensitively,
+// counting and leaving out those RE2 cannot compile
+func compilePatterns(list []string) []fingerprintPattern {
+	var out []fingerprintPattern
+	for _, s := range list {
+		expr, tags, _ := strings.Cut(s, `\;`)
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			fingerprintsSkipped++
+			continue
+		}
+		version, confidence := patternTags(tags)
+		out = append(out, fingerprintPattern{re: re, version: version, confidence: confidence})
+	}
+	return out
+}
+
+// patternTags reads the version: and confidence: tags following a
+// pattern, separated by \;
+func patternTags(tags string) (version string, confidence int) {
+	confidence = 100
+	for _, tag := range strings.Split(tags, `\;`) {
+		k, v, _ := strings.Cut(tag, ":")
+		switch k {
+		case "version":
+			version = v
+		case "confidence":
+			if n, err := strconv.Atoi(v); err == nil {
+				confidence = n
+			}
+		}
+	}
+	return version, confidence
+}
+
+// versionTernary is Wappalyzer's \1?a:b, a when group 1 matched and b
+// otherwise
+var versionTernary = regexp.MustCompile(`\\(\d)\?([^:]*):(.*)`)
+
+var versionGroup = regexp.MustCompile(`\\(\d)`)
+
+// versionOf fills the pattern's version template from a match
+func (p fingerprintPattern) versionOf(m []string) string {
+	group := func(ref string) string {
+		n, _ := strconv.Atoi(ref)
+		if n < len(m) {
+			return m[n]
+		}
+		return ""
+	}
+	v := versionTernary.ReplaceAllStringFunc(p.version, func(s string) string {
+		sub := versionTernary.FindStringSubmatch(s)
+		if group(sub[1]) != "" {
+			return sub[2]
+		}
+		return sub[3]
+	})
+	v = versionGroup.ReplaceAllStringFunc(v, func(s string) string { return group(s[1:]) })
+	return strings.TrimSpace(v)
+}
+
+// fingerprintPage is what the native matcher looks at of one response
+type fingerprintPage struct {
+	url     string
+	headers map[string][]string // lower-case names
+	cookies map[string]string
+	meta    map[string][]string // lower-case names
+	scripts []string
+	html    string
+}
+
+var (
+	metaTagRe   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttrRe  = regexp.MustCompile(`(?is)\b(name|property|http-equiv|content)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	scriptSrcRe = regexp.MustCompile(`(?is)<script\s[^>]*\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// newFingerprintPage gathers the headers, cookies, meta tags and script
+// sources of a response with its body
+func newFingerprintPage(url string, header http.Header, body []byte) *fingerprintPage {
+	p := &fingerprintPage{
+		url:     url,
+		headers: make(map[string][]string, len(header)),
+		cookies: make(map[string]string),
+		meta:    make(map[string][]string),
+		html:    string(body),
+	}
+	for k, v := range header {
+		p.headers[strings.ToLower(k)] = v
+	}
+	for _, line := range header.Values("Set-Cookie") {
+		pair, _, _ := strings.Cut(line, ";")
+		name, value, _ := strings.Cut(pair, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			p.cookies[name] = strings.TrimSpace(value)
+		}
+	}
+	for _, tag := range metaTagRe.FindAllString(p.html, -1) {
+		var name, content string
+		for _, a := range metaAttrRe.FindAllStringSubmatch(tag, -1) {
+			v := a[2] + a[3] + a[4]
+			if strings.EqualFold(a[1], "content") {
+				content = v
+			} else {
+				name = strings.ToLower(v)
+			}
+		}
+		if name != "" {
+			p.meta[name] = append(p.meta[name], content)
+		}
+	}
+	for _, m := range scriptSrcRe.FindAllStringSubmatch(p.html, -1) {
+		p.scripts = append(p.scripts, m[1]+m[2]+m[3])
+	}
+	return p
+}
+
+// detect matches the page against techFingerprints. A technology's
+// confidence is the sum of its matching patterns', at most 100; its
+// version is the first a matching pattern yields. Implied technologies
+// follow with the implying one's confidence scaled by the implication's,
+// and excluded ones are dropped. Detections are sorted by name.
+func (p *fingerprintPage) detect() []techDetection {
+	found := make(map[string]*techDetection)
+	byName := make(map[string]*techFingerprint, len(techFingerprints))
+	for _, fp := range techFingerprints {
+		byName[fp.name] = fp
+		d := techDetection{Name: fp.name}
+		match := func(ps []fingerprintPattern, values ...string) {
+			for _, pat := range ps {
+				for _, v := range values {
+					m := pat.re.FindStringSubmatch(v)
+					if m == nil {
+						continue
+					}
+					d.Confidence += pat.confidence
+					if d.Version == "" {
+						d.Version = pat.versionOf(m)
+					}
+					break
+				}
+			}
+		}
+		for name, ps := range fp.headers {
+			if values, ok := p.headers[name]; ok {
+				match(ps, values...)
+			}
+		}
+		for name, ps := range fp.cookies {
+			if value, ok := p.cookies[name]; ok {
+				match(ps, value)
+			}
+		}
+		for name, ps := range fp.meta {
+			if values, ok := p.meta[name]; ok {
+				match(ps, values...)
+			}
+		}
+		if p.html != "" {
+			match(fp.html, p.html)
+		}
+		match(fp.scriptSrc, p.scripts...)
+		match(fp.url, p.url)
+		if d.Confidence > 0 {
+			d.Confidence = min(d.Confidence, 100)
+			found[fp.name] = &d
+		}
+	}
+
+	queue := make([]string, 0, len(found))
+	for name := range found {
+		queue = append(queue, name)
+	}
+	sort.Strings(queue)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		fp := byName[name]
+		if fp == nil {
+			continue
+		}
+		for _, imp := range fp.implies {
+			c := found[name].Confidence * imp.confidence / 100
+			if d, ok := found[imp.name]; ok {
+				d.Confidence = max(d.Confidence, c)
+				continue
+			}
+			found[imp.name] = &techDetection{Name: imp.name, Confidence: c}
+			queue = append(queue, imp.name)
+		}
+	}
+	for name := range found {
+		if fp := byName[name]; fp != nil {
+			for _, ex := range fp.excludes {
+				delete(found, ex)
+			}
+		}
+	}
+
+	out := make([]techDetection, 0, len(found))
+	for _, d := range found {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// fingerprintNativeResult fetches res.URL and merges what the native
+// matcher finds on it into res
+func fingerprintNativeResult(ctx context.Context, res *Result) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, res.URL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := fingerprintHTTP.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, blockBodyMax))
+	page := newFingerprintPage(resp.Request.URL.String(), resp.Header, body)
+	dets := page.detect()
+	stats.Add("fingerprint.native_detections", int64(len(dets)))
+	mergeNative(res, dets)
+}
+
+// mergeNative merges native detections into res under canonical names. A
+// version that differs from one another source reported replaces it only
+// when the native matcher is more confident.
+func mergeNative(res *Result, dets []techDetection) {
+	tech := newTechSet(res.TechStack)
+	for _, d := range dets {
+		name := canonicalTech(d.Name)
+		if name == "" {
+			continue
+		}
+		tech.Add(name)
+		if d.Version == "" {
+			recordDetection(res, name, fingerprintEngineNative, d.Confidence, false)
+			continue
+		}
+		if prev := res.Versions[name]; prev != "" && prev != d.Version && d.Confidence <= res.techConfidence[name] {
+			recordDetection(res, name, fingerprintEngineNative, d.Confidence, false)
+			continue
+		}
+		if res.Versions == nil {
+			res.Versions = make(map[string]string)
+		}
+		res.Versions[name] = d.Version
+		recordDetection(res, name, fingerprintEngineNative, d.Confidence, true)
+	}
+	res.TechStack = tech.Stack()
+}
+
+// recordDetection notes which source found the technology name, and how
+// sure it is. The first source to find a technology keeps it unless a
+// later one supplies its version; technologies the probe reported before
+// fingerprinting are the probe's.
+func recordDetection(res *Result, name, source string, confidence int, versioned bool) {
+	if res.DetectionSource == nil {
+		res.DetectionSource = make(map[string]string)
+		res.techConfidence = make(map[string]int)
+		for _, t := range res.TechStack {
+			n, _, _ := strings.Cut(techEntry(t), ":")
+			if n == "" {
+				continue
+			}
+			res.DetectionSource[n] = detectionProbe
+			res.techConfidence[n] = 100
+		}
+	}
+	if _, ok := res.DetectionSource[name]; ok && !versioned {
+		return
+	}
+	res.DetectionSource[name] = source
+	res.techConfidence[name] = confidence
+}
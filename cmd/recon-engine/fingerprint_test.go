@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fingerprintCase is a recorded response and the technologies the native
+// matcher must find on it, as name or name:version under canonical names
+type fingerprintCase struct {
+	URL     string              `json:"url"`
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+	Expect  []string            `json:"expect"`
+}
+
+// fingerprintCaseResult runs the matcher on a recorded response and
+// returns the technologies it merges into an empty Result, sorted
+func fingerprintCaseResult(c fingerprintCase) []string {
+	header := make(http.Header, len(c.Headers))
+	for k, vs := range c.Headers {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	var res Result
+	mergeNative(&res, newFingerprintPage(c.URL, header, []byte(c.Body)).detect())
+	var got []string
+	for _, t := range res.TechStack {
+		if v := res.Versions[t]; v != "" {
+			t += ":" + v
+		}
+		got = append(got, t)
+	}
+	sort.Strings(got)
+	return got
+}
+
+// TestFingerprintCorpus runs the native matcher over the recorded responses
+// in testdata/fingerprints and wants exactly what each expects, so a
+// fingerprint that stops matching or starts matching too much is caught
+func TestFingerprintCorpus(t *testing.T) {
+	if err := configureTechAliases(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadFingerprints(""); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join("testdata", "fingerprints", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no cases in testdata/fingerprints: %v", err)
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c fingerprintCase
+			if err := json.Unmarshal(raw, &c); err != nil {
+				t.Fatal(err)
+			}
+			want := slices.Clone(c.Expect)
+			sort.Strings(want)
+			if got := fingerprintCaseResult(c); !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
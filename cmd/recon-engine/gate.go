@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Exit codes used by the CI gates. A run that fails once started exits 1
+// via fatalError, one that cannot start exits exitStartupFailed via
+// startupError and an interrupted run exits 130.
+const (
+	exitSeverityGate  = 2
+	exitNewAssetGate  = 3
+	exitStartupFailed = 6
+	exitInterrupted   = 130
+)
+
+// validateGates checks the -fail-on-* flags once after parsing
+func validateGates() error {
+	if failOnSeverity != "" {
+		if sev, ok := parseSeverity(failOnSeverity); !ok || sev.rank() < severityMedium.rank() {
+			return fmt.Errorf("-fail-on-severity must be critical, high or medium, got %q", failOnSeverity)
+		}
+	}
+	if failOnNewSubdomains < 0 {
+		return fmt.Errorf("-fail-on-new-subdomains must not be negative")
+	}
+	if failOnNewSubdomains > 0 && diffPath == "" {
+		return fmt.Errorf("-fail-on-new-subdomains requires -diff")
+	}
+	return nil
+}
+
+// ciGate tallies the emitted Results against the -fail-on-* thresholds.
+// It is only used from the goroutine that writes output.
+type ciGate struct {
+	severityHits  int
+	newSubdomains map[string]bool
+}
+
+func newCIGate() *ciGate {
+	return &ciGate{newSubdomains: make(map[string]bool)}
+}
+
+// Observe records res. Known hosts count only when they changed.
+func (g *ciGate) Observe(res Result) {
+	if knownQuiet(res) {
+		return
+	}
+	if failOnSeverity != "" {
+		min, _ := parseSeverity(failOnSeverity)
+		for _, v := range res.Vulnerabilities {
+			if v.Severity.rank() >= min.rank() {
+				g.severityHits++
+			}
+		}
+	}
+	if res.ChangeType == changeNew {
+		g.newSubdomains[res.Subdomain] = true
+	}
+}
+
+// Tripped returns a description of every gate that tripped and the exit
+// code to use, 0 when none did. The severity gate wins when both trip.
+func (g *ciGate) Tripped() ([]string, int) {
+	var gates []string
+	code := 0
+	if failOnNewSubdomains > 0 && len(g.newSubdomains) >= failOnNewSubdomains {
+		gates = append(gates, fmt.Sprintf("new_subdomains: %d new (threshold %d)", len(g.newSubdomains), failOnNewSubdomains))
+		code = exitNewAssetGate
+	}
+	if failOnSeverity != "" && g.severityHits > 0 {
+		min, _ := parseSeverity(failOnSeverity)
+		gates = append([]string{fmt.Sprintf("severity: %d findings at or above %s", g.severityHits, min)}, gates...)
+		code = exitSeverityGate
+	}
+	return gates, code
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoRecord is the subset of a GeoLite2/GeoIP2 City record we use
+type geoRecord struct {
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// geoLocation is the country/city an IP maps to
+type geoLocation struct {
+	Country string
+	City    string
+}
+
+var (
+	geoDB    *maxminddb.Reader
+	geoCache sync.Map // ip -> geoLocation
+)
+
+// openGeoIP opens the -geoip database; called only when the flag is set
+func openGeoIP(path string) error {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	geoDB = db
+	return nil
+}
+
+// lookupGeo returns the location of ip. Private and otherwise unroutable
+// addresses, and addresses missing from the database, return empty fields.
+func lookupGeo(ip string) geoLocation {
+	if geoDB == nil {
+		return geoLocation{}
+	}
+	if cached, ok := geoCache.Load(ip); ok {
+		return cached.(geoLocation)
+	}
+	var loc geoLocation
+	addr := net.ParseIP(ip)
+	if addr != nil && addr.IsGlobalUnicast() && !addr.IsPrivate() {
+		var rec geoRecord
+		if err := geoDB.Lookup(addr, &rec); err == nil {
+			loc = geoLocation{Country: rec.Country.Names["en"], City: rec.City.Names["en"]}
+		}
+	}
+	geoCache.Store(ip, loc)
+	return loc
+}
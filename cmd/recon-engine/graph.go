@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Node kinds and edge types of the -graph export
+const (
+	nodeDomain      = "domain"
+	nodeSubdomain   = "subdomain"
+	nodeIP          = "ip"
+	nodeASN         = "asn"
+	nodeCertificate = "certificate"
+	nodeTechnology  = "technology"
+
+	edgeSubdomainOf = "subdomain_of"
+	edgeResolvesTo  = "resolves_to"
+	edgeAnnouncedBy = "announced_by"
+	edgeCoveredBy   = "covered_by"
+	edgeRuns        = "runs"
+)
+
+// graphMaxCertsPerName caps the crt.sh certificates kept for one name;
+// long-lived names are in hundreds of them
+const graphMaxCertsPerName = 5
+
+// graphNodeKey identifies a node by kind and label
+type graphNodeKey struct {
+	kind, label string
+}
+
+// graphEdge is a typed edge between two nodes
+type graphEdge struct {
+	from, to graphNodeKey
+	kind     string
+}
+
+// assetGraph collects the relationships between the assets of a run for
+// -graph. Labels and attribute values repeat across many nodes (an IP
+// behind hundreds of names, the same organization on every ASN), so each
+// distinct string is stored once.
+type assetGraph struct {
+	mu      sync.Mutex
+	strings map[string]string
+	nodes   map[graphNodeKey]map[string]string // attributes
+	edges   map[graphEdge]bool
+
+	// certs holds the crt.sh certificate IDs seen for each name; only the
+	// names that turn out to be live get covered_by edges
+	certs map[string][]int64
+}
+
+var assetGraphs = newAssetGraph()
+
+func newAssetGraph() *assetGraph {
+	return &assetGraph{
+		strings: make(map[string]string),
+		nodes:   make(map[graphNodeKey]map[string]string),
+		edges:   make(map[graphEdge]bool),
+		certs:   make(map[string][]int64),
+	}
+}
+
+// intern returns the graph's copy of s. Called with g.mu held.
+func (g *assetGraph) intern(s string) string {
+	if v, ok := g.strings[s]; ok {
+		return v
+	}
+	g.strings[s] = s
+	return s
+}
+
+// node adds a node, or returns the existing one, and sets the non-empty
+// attributes it does not have yet. Called with g.mu held.
+func (g *assetGraph) node(kind, label string, attrs ...string) graphNodeKey {
+	k := graphNodeKey{kind, g.intern(label)}
+	a := g.nodes[k]
+	if a == nil {
+		a = make(map[string]string)
+		g.nodes[k] = a
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" && a[attrs[i]] == "" {
+			a[attrs[i]] = g.intern(attrs[i+1])
+		}
+	}
+	return k
+}
+
+// Observe adds res's subdomain, domain, IP, ASN and technologies and the
+// edges between them
+func (g *assetGraph) Observe(res Result) {
+	if res.Subdomain == "" && res.IP == "" {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var host graphNodeKey
+	if res.Subdomain != "" {
+		status := ""
+		if res.StatusCode > 0 {
+			status = strconv.Itoa(res.StatusCode)
+		}
+		host = g.node(nodeSubdomain, strings.ToLower(res.Subdomain),
+			"url", res.URL, "status_code", status, "title", res.Title, "cdn", res.CDN,
+			"cname", res.CNAME, "flags", strings.Join(res.Flags, ","))
+		if res.InterestScore > 0 {
+			// The highest score of the host's results
+			a := g.nodes[host]
+			if n, _ := strconv.Atoi(a["interest_score"]); res.InterestScore > n {
+				a["interest_score"] = g.intern(strconv.Itoa(res.InterestScore))
+			}
+		}
+		// IP targets have no domain
+		if root := registrableDomain(targetHost(res.RootDomain)); !targetRange(res.RootDomain).IsValid() && root != "" && root != host.label {
+			domain := g.node(nodeDomain, root)
+			g.edges[graphEdge{host, domain, edgeSubdomainOf}] = true
+		}
+	}
+	if res.IP != "" {
+		ip := g.node(nodeIP, res.IP, "ptr", res.Ptr, "country", res.Country, "city", res.City)
+		if res.Subdomain != "" {
+			g.edges[graphEdge{host, ip, edgeResolvesTo}] = true
+		}
+		if res.Asn != "" {
+			asn := g.node(nodeASN, res.Asn, "org", res.Org)
+			g.edges[graphEdge{ip, asn, edgeAnnouncedBy}] = true
+		}
+	}
+	if res.Subdomain != "" && res.StatusCode > 0 {
+		for _, t := range res.TechStack {
+			entry := techEntry(t)
+			name, ver, _ := strings.Cut(entry, ":")
+			if name == "" {
+				continue
+			}
+			tech := g.node(nodeTechnology, name)
+			g.edges[graphEdge{host, tech, edgeRuns}] = true
+			if ver != "" {
+				g.nodes[host]["version."+g.intern(name)] = g.intern(ver)
+			}
+		}
+	}
+}
+
+// ObserveCert records that crt.sh certificate id covers name
+func (g *assetGraph) ObserveCert(name string, id int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	name = g.intern(name)
+	ids := g.certs[name]
+	if len(ids) >= graphMaxCertsPerName {
+		return
+	}
+	for _, seen := range ids {
+		if seen == id {
+			return
+		}
+	}
+	g.certs[name] = append(ids, id)
+}
+
+// graphNode is a node as exported, with a stable ID
+type graphNode struct {
+	ID    string
+	Kind  string
+	Label string
+	Attrs map[string]string
+}
+
+// snapshot returns the nodes and edges sorted by kind and label, with the
+// certificates of the subdomains in the graph added
+func (g *assetGraph) snapshot() ([]graphNode, []graphEdge) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	edges := make([]graphEdge, 0, len(g.edges))
+	for e := range g.edges {
+		edges = append(edges, e)
+	}
+	for name, ids := range g.certs {
+		host := graphNodeKey{nodeSubdomain, name}
+		if g.nodes[host] == nil {
+			continue
+		}
+		for _, id := range ids {
+			cert := g.node(nodeCertificate, "crt.sh:"+strconv.FormatInt(id, 10), "crtsh_id", strconv.FormatInt(id, 10))
+			edges = append(edges, graphEdge{host, cert, edgeCoveredBy})
+		}
+	}
+
+	keys := make([]graphNodeKey, 0, len(g.nodes))
+	for k := range g.nodes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].label < keys[j].label
+	})
+	nodes := make([]graphNode, len(keys))
+	for i, k := range keys {
+		nodes[i] = graphNode{ID: "n" + strconv.Itoa(i), Kind: k.kind, Label: k.label, Attrs: g.nodes[k]}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.from != b.from {
+			return a.from.kind < b.from.kind || a.from.kind == b.from.kind && a.from.label < b.from.label
+		}
+		if a.to != b.to {
+			return a.to.kind < b.to.kind || a.to.kind == b.to.kind && a.to.label < b.to.label
+		}
+		return a.kind < b.kind
+	})
+	return nodes, edges
+}
+
+// graphSummary records the -graph export in the run summary
+type graphSummary struct {
+	Path  string `json:"path"`
+	Nodes int    `json:"nodes"`
+	Edges int    `json:"edges"`
+}
+
+// dotGraph reports whether -graph is written as Graphviz DOT rather than
+// GraphML, which its extension decides
+func dotGraph(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".dot" || ext == ".gv"
+}
+
+// writeGraph exports the asset graph to -graph
+func writeGraph() {
+	nodes, edges := assetGraphs.snapshot()
+	f, err := os.Create(graphPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -graph: %v\n", err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	if dotGraph(graphPath) {
+		err = encodeDOT(w, nodes, edges)
+	} else {
+		err = encodeGraphML(w, nodes, edges)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -graph: %v\n", err)
+		return
+	}
+	summary.Graph = &graphSummary{Path: graphPath, Nodes: len(nodes), Edges: len(edges)}
+}
+
+// graphAttrKeys returns every attribute name the nodes use, sorted
+func graphAttrKeys(nodes []graphNode) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, n := range nodes {
+		for k := range n.Attrs {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeGraphML writes the graph as GraphML, with kind and label as node
+// data and kind as edge data, for Gephi, yEd and Maltego
+func encodeGraphML(w io.Writer, nodes []graphNode, edges []graphEdge) error {
+	ids := make(map[graphNodeKey]string, len(nodes))
+	for _, n := range nodes {
+		ids[graphNodeKey{n.Kind, n.Label}] = n.ID
+	}
+	attrs := graphAttrKeys(nodes)
+
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(w, `  <key id="kind" for="node" attr.name="kind" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="label" for="node" attr.name="label" attr.type="string"/>`)
+	for i, a := range attrs {
+		fmt.Fprintf(w, "  <key id=\"a%d\" for=\"node\" attr.name=\"%s\" attr.type=\"string\"/>\n", i, esc(a))
+	}
+	fmt.Fprintln(w, `  <key id="type" for="edge" attr.name="type" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <graph id="recon" edgedefault="directed">`)
+	for _, n := range nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\"><data key=\"kind\">%s</data><data key=\"label\">%s</data>", n.ID, n.Kind, esc(n.Label))
+		for i, a := range attrs {
+			if v := n.Attrs[a]; v != "" {
+				fmt.Fprintf(w, "<data key=\"a%d\">%s</data>", i, esc(v))
+			}
+		}
+		fmt.Fprintln(w, "</node>")
+	}
+	for i, e := range edges {
+		fmt.Fprintf(w, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\"><data key=\"type\">%s</data></edge>\n", i, ids[e.from], ids[e.to], e.kind)
+	}
+	fmt.Fprintln(w, "  </graph>")
+	_, err := fmt.Fprintln(w, "</graphml>")
+	return err
+}
+
+// encodeDOT writes the graph in Graphviz DOT, with the edge types as edge
+// labels
+func encodeDOT(w io.Writer, nodes []graphNode, edges []graphEdge) error {
+	ids := make(map[graphNodeKey]string, len(nodes))
+	for _, n := range nodes {
+		ids[graphNodeKey{n.Kind, n.Label}] = n.ID
+	}
+	fmt.Fprintln(w, "digraph recon {")
+	for _, n := range nodes {
+		fmt.Fprintf(w, "  %s [label=%s kind=%s", n.ID, strconv.Quote(n.Label), n.Kind)
+		for _, a := range graphAttrKeys([]graphNode{n}) {
+			fmt.Fprintf(w, " %s=%s", strconv.Quote(a), strconv.Quote(n.Attrs[a]))
+		}
+		fmt.Fprintln(w, "];")
+	}
+	for _, e := range edges {
+		fmt.Fprintf(w, "  %s -> %s [label=%s];\n", ids[e.from], ids[e.to], e.kind)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
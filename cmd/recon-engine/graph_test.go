@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// graphFixture is two names behind one IP, a third on another IP in the
+// same ASN, and certificates for a live and an unknown name
+func graphFixture() *assetGraph {
+	g := newAssetGraph()
+	g.Observe(Result{Subdomain: "www.example.com", RootDomain: "example.com", URL: "https://www.example.com", StatusCode: 200,
+		IP: "192.0.2.1", Asn: "AS64500", Org: "Example Hosting", TechStack: []string{"nginx:1.25", "php"}, InterestScore: 10})
+	g.Observe(Result{Subdomain: "API.example.com", RootDomain: "example.com", StatusCode: 401,
+		IP: "192.0.2.1", Asn: "AS64500", TechStack: []string{"nginx"}, InterestScore: 30})
+	g.Observe(Result{Subdomain: "mail.example.com", RootDomain: "example.com", IP: "192.0.2.2", Asn: "AS64500", Org: "Example Hosting",
+		TechStack: []string{"postfix"}})
+	// A later, lower score does not replace a higher one
+	g.Observe(Result{Subdomain: "api.example.com", RootDomain: "example.com", StatusCode: 401, InterestScore: 5})
+	g.ObserveCert("www.example.com", 42)
+	g.ObserveCert("www.example.com", 42)
+	g.ObserveCert("gone.example.com", 43)
+	return g
+}
+
+func edgeStrings(edges []graphEdge) map[string]bool {
+	m := make(map[string]bool, len(edges))
+	for _, e := range edges {
+		m[e.from.label+" "+e.kind+" "+e.to.label] = true
+	}
+	return m
+}
+
+func TestAssetGraphEdges(t *testing.T) {
+	nodes, edges := graphFixture().snapshot()
+	got := edgeStrings(edges)
+	for _, want := range []string{
+		"www.example.com subdomain_of example.com",
+		"api.example.com subdomain_of example.com",
+		"mail.example.com subdomain_of example.com",
+		"www.example.com resolves_to 192.0.2.1",
+		"api.example.com resolves_to 192.0.2.1",
+		"mail.example.com resolves_to 192.0.2.2",
+		"192.0.2.1 announced_by AS64500",
+		"192.0.2.2 announced_by AS64500",
+		"www.example.com covered_by crt.sh:42",
+		"www.example.com runs nginx",
+		"www.example.com runs php",
+		"api.example.com runs nginx",
+	} {
+		if !got[want] {
+			t.Errorf("missing edge %q", want)
+		}
+	}
+	if len(edges) != 12 {
+		t.Errorf("%d edges, want 12: %v", len(edges), got)
+	}
+	// mail did not answer, so runs nothing; gone was never seen
+	for _, e := range edges {
+		if e.from.label == "mail.example.com" && e.kind == edgeRuns || e.to.label == "crt.sh:43" {
+			t.Errorf("unexpected edge %v", e)
+		}
+	}
+
+	attrs := make(map[graphNodeKey]map[string]string)
+	kinds := make(map[string]int)
+	for _, n := range nodes {
+		attrs[graphNodeKey{n.Kind, n.Label}] = n.Attrs
+		kinds[n.Kind]++
+	}
+	want := map[string]int{nodeDomain: 1, nodeSubdomain: 3, nodeIP: 2, nodeASN: 1, nodeCertificate: 1, nodeTechnology: 2}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("%d %s nodes, want %d", kinds[kind], kind, n)
+		}
+	}
+	www := attrs[graphNodeKey{nodeSubdomain, "www.example.com"}]
+	if www["status_code"] != "200" || www["url"] != "https://www.example.com" || www["version.nginx"] != "1.25" {
+		t.Errorf("www attributes %v", www)
+	}
+	if api := attrs[graphNodeKey{nodeSubdomain, "api.example.com"}]; api["interest_score"] != "30" {
+		t.Errorf("api attributes %v", api)
+	}
+	if asn := attrs[graphNodeKey{nodeASN, "AS64500"}]; asn["org"] != "Example Hosting" {
+		t.Errorf("asn attributes %v", asn)
+	}
+}
+
+// TestAssetGraphInterns stores each repeated label and value once
+func TestAssetGraphInterns(t *testing.T) {
+	g := newAssetGraph()
+	for i := 0; i < 100; i++ {
+		g.Observe(Result{Subdomain: "www.example.com", RootDomain: "example.com", IP: "192.0.2.1", Asn: "AS64500", Org: "Example Hosting"})
+	}
+	g.Observe(Result{Subdomain: "mail.example.com", RootDomain: "example.com", IP: "192.0.2.1", Asn: "AS64500", Org: "Example Hosting"})
+	if len(g.strings) != 6 {
+		t.Errorf("%d strings interned, want 6: %v", len(g.strings), g.strings)
+	}
+	for i := int64(0); i < 2*graphMaxCertsPerName; i++ {
+		g.ObserveCert("www.example.com", i)
+	}
+	if n := len(g.certs["www.example.com"]); n != graphMaxCertsPerName {
+		t.Errorf("%d certificates kept, want %d", n, graphMaxCertsPerName)
+	}
+}
+
+func TestEncodeGraphML(t *testing.T) {
+	nodes, edges := graphFixture().snapshot()
+	var buf bytes.Buffer
+	if err := encodeGraphML(&buf, nodes, edges); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Graph struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid GraphML: %v", err)
+	}
+	if len(doc.Graph.Nodes) != len(nodes) || len(doc.Graph.Edges) != len(edges) {
+		t.Fatalf("%d nodes and %d edges exported", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	ids := make(map[string]bool)
+	for _, n := range doc.Graph.Nodes {
+		ids[n.ID] = true
+	}
+	for _, e := range doc.Graph.Edges {
+		if !ids[e.Source] || !ids[e.Target] {
+			t.Errorf("edge %s -> %s to a missing node", e.Source, e.Target)
+		}
+	}
+}
+
+func TestEncodeDOT(t *testing.T) {
+	g := newAssetGraph()
+	g.Observe(Result{Subdomain: "www.example.com", RootDomain: "example.com", IP: "192.0.2.1", Title: `say "hi"`, StatusCode: 200})
+	nodes, edges := g.snapshot()
+	var buf bytes.Buffer
+	if err := encodeDOT(&buf, nodes, edges); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"digraph recon {",
+		`n0 [label="example.com" kind=domain];`,
+		`n2 [label="www.example.com" kind=subdomain "status_code"="200" "title"="say \"hi\""];`,
+		"n2 -> n0 [label=subdomain_of];",
+		"n2 -> n1 [label=resolves_to];",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT output lacks %q:\n%s", want, out)
+		}
+	}
+	if !dotGraph("out.GV") || dotGraph("out.graphml") {
+		t.Error("dotGraph picks the wrong format")
+	}
+}
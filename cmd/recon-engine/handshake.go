@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// handshakeRecord is the first record of a -handshake run, written before
+// any result so an orchestrator can check it understands the output
+type handshakeRecord struct {
+	Type          string           `json:"type"`
+	EngineVersion string           `json:"engine_version"`
+	SchemaVersion string           `json:"schema_version"`
+	RunID         string           `json:"run_id"`
+	Targets       []string         `json:"targets"`
+	Stages        []handshakeStage `json:"stages"`
+	// EgressIP is the -source-ip or -interface address traffic leaves from
+	EgressIP string `json:"egress_ip,omitempty"`
+}
+
+// handshakeStage is a step the run will take, as -dry-run names it
+type handshakeStage struct {
+	Stage string `json:"stage"`
+	Name  string `json:"name"`
+}
+
+// validateHandshake checks -handshake against the output options
+func validateHandshake() error {
+	if handshake && (outputFormat == "junit" || outputFormat == "defectdojo") {
+		return fmt.Errorf("-handshake cannot be combined with -format %s, which is one document", outputFormat)
+	}
+	return nil
+}
+
+// writeHandshake writes the -handshake record to the result stream
+func writeHandshake(targets []string, sources []string) error {
+	if !handshake {
+		return nil
+	}
+	rec := handshakeRecord{
+		Type:          "handshake",
+		EngineVersion: version,
+		SchemaVersion: schemaVersion,
+		RunID:         runID,
+		Targets:       targets,
+		Stages:        []handshakeStage{},
+	}
+	if egressIP.IsValid() {
+		rec.EgressIP = egressIP.String()
+	}
+	seen := make(map[handshakeStage]bool)
+	// The stages are the same for each of several -org domains
+	for _, step := range planSteps(targets[0], sources) {
+		s := handshakeStage{Stage: step.Stage, Name: step.Name}
+		if !seen[s] {
+			seen[s] = true
+			rec.Stages = append(rec.Stages, s)
+		}
+	}
+	return json.NewEncoder(stdout).Encode(rec)
+}
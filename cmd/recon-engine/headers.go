@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerFlags collects repeated -header "Name: value" flags
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("header must look like \"Name: value\", got %q", v)
+	}
+	// Values may themselves contain colons, only the first one separates
+	*h = append(*h, name+": "+strings.TrimSpace(value))
+	return nil
+}
+
+// customHeaders returns the -header flags as canonical name/value pairs
+func customHeaders() [][2]string {
+	pairs := make([][2]string, 0, len(extraHeaders))
+	for _, h := range extraHeaders {
+		name, value, _ := strings.Cut(h, ":")
+		pairs = append(pairs, [2]string{http.CanonicalHeaderKey(name), strings.TrimSpace(value)})
+	}
+	return pairs
+}
+
+// applyCustomHeaders sets -header and -user-agent values on req without
+// overriding headers the caller set explicitly (API credentials and such)
+func applyCustomHeaders(req *http.Request) {
+	for _, p := range customHeaders() {
+		if req.Header.Get(p[0]) == "" {
+			req.Header.Set(p[0], p[1])
+		}
+	}
+	if userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+}
+
+func httpxHeaderArgs() []string {
+	var args []string
+	for _, h := range extraHeaders {
+		args = append(args, "-H", h)
+	}
+	if userAgent != "" {
+		args = append(args, "-H", "User-Agent: "+userAgent)
+	}
+	return args
+}
+
+func whatwebHeaderArgs() []string {
+	var args []string
+	for _, p := range customHeaders() {
+		args = append(args, "--header", p[0]+":"+p[1])
+	}
+	if userAgent != "" {
+		args = append(args, "--user-agent", userAgent)
+	}
+	return args
+}
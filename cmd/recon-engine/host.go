@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hostEnrichers are the enrichers the host command turns on unless the
+// command line or -profile sets them: every one that needs neither an
+// external tool nor an API key. The tool-backed ones (-fingerprint, -jarm,
+// -dirbrute, -screenshots) and the API lookups run when asked for.
+var hostEnrichers = map[string]string{
+	"follow-redirects": "true",
+	"access-control":   "true",
+	"third-party":      "true",
+	"robots":           "true",
+	"security-txt":     "true",
+	"cors-check":       "true",
+	"header-audit":     "true",
+	"cookie-audit":     "true",
+	"cert-check":       "true",
+	"params":           "true",
+}
+
+// hostCommand is set for the host command, whose -plain output is the
+// detailed breakdown of hostDetail rather than one line per host
+var hostCommand bool
+
+// configureHostCommand checks the flags of the host command, turns on
+// hostEnrichers and returns the URL it probes: args' only argument, a URL
+// or a bare hostname or address, which is probed over HTTPS. Called right
+// after flag parsing and -profile.
+func configureHostCommand(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("takes one URL or hostname, got %d arguments", len(args))
+	}
+	if f := discoveryFlag(); f != "" {
+		return "", fmt.Errorf("%s does not apply, host runs no discovery", f)
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-monitor", monitor},
+		{"-org", orgName != ""},
+		{"-probe-ports", probePorts != ""},
+	} {
+		if f.set {
+			return "", fmt.Errorf("%s does not apply, host probes one URL", f.name)
+		}
+	}
+
+	target := args[0]
+	if _, ok := parseURLTarget(target); !ok {
+		if strings.ContainsAny(target, "/:?# ") && !isIPv6(target) {
+			return "", fmt.Errorf("%q is neither an http(s) URL nor a hostname", target)
+		}
+		host := strings.ToLower(target)
+		if isIPv6(host) {
+			host = "[" + host + "]"
+		}
+		target = "https://" + host + "/"
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, name := range sortedKeys(hostEnrichers) {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, hostEnrichers[name]); err != nil {
+			return "", fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+	hostCommand = true
+	return target, nil
+}
+
+func isIPv6(s string) bool {
+	a, err := netip.ParseAddr(s)
+	return err == nil && a.Is6()
+}
+
+// hostEncoder writes the host command's Results as hostDetail breakdowns
+// with -plain; anything else stays JSON
+type hostEncoder struct {
+	w     io.Writer
+	color bool
+}
+
+func (e *hostEncoder) Encode(v interface{}) error {
+	res, ok := v.(Result)
+	if !ok {
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	_, err := io.WriteString(e.w, hostDetail(res, e.color))
+	return err
+}
+
+// hostDetail renders everything the enrichers found about res as labelled
+// sections, leaving out the empty ones
+func hostDetail(res Result, color bool) string {
+	var b strings.Builder
+	heading := res.URL
+	if heading == "" {
+		heading = res.Subdomain
+	}
+	if color {
+		heading = "\x1b[1m" + heading + "\x1b[0m"
+	}
+	b.WriteString(heading + "\n")
+
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "  %-16s %s\n", label, value)
+		}
+	}
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n  %s\n", title)
+		for _, l := range lines {
+			fmt.Fprintf(&b, "    %s\n", l)
+		}
+	}
+
+	if res.StatusCode > 0 {
+		status := strconv.Itoa(res.StatusCode)
+		if color {
+			status = "\x1b[" + statusANSI(res.StatusCode) + "m" + status + "\x1b[0m"
+		}
+		field("Status", status)
+	} else {
+		field("Status", "no answer")
+	}
+	field("Title", res.Title)
+	if res.FinalURL != "" {
+		field("Redirects", strings.Join(append(append([]string{}, res.RedirectChain...), res.FinalURL), " -> "))
+	}
+	if res.ContentLength > 0 {
+		field("Length", strconv.Itoa(res.ContentLength))
+	}
+	if res.ResponseTimeMs > 0 {
+		field("Response time", strconv.FormatFloat(res.ResponseTimeMs, 'f', 0, 64)+" ms")
+	}
+	field("IP", res.IP)
+	field("PTR", res.Ptr)
+	field("CNAME", strings.Join(res.CNAMEChain, " -> "))
+	if res.Asn != "" {
+		field("ASN", strings.TrimSpace(res.Asn+" "+res.Org))
+	}
+	field("Location", strings.Trim(res.City+", "+res.Country, ", "))
+	field("CDN", res.CDN)
+	if res.ThirdParty {
+		field("Third party", res.ThirdPartyVendor+" ("+res.ThirdPartyEvidence+")")
+	}
+	field("Access", strings.TrimSpace(res.AccessControl+" "+res.AccessEvidence))
+	if res.Blocked {
+		field("Blocked", "by "+res.BlockVendor)
+	}
+	field("Environment", res.Environment)
+	field("Owner", res.Owner)
+	if res.InterestScore > 0 {
+		field("Interest score", strconv.Itoa(res.InterestScore))
+	}
+	field("Flags", strings.Join(res.Flags, ", "))
+	field("Probe skipped", res.ProbeSkipped)
+
+	var tech []string
+	for _, t := range res.TechStack {
+		name, _, _ := strings.Cut(t, ":")
+		if v := res.Versions[name]; v != "" && !strings.Contains(t, ":") {
+			t += " " + v
+		}
+		tech = append(tech, t)
+	}
+	for _, name := range sortedKeys(res.Versions) {
+		if !containsPrefix(res.TechStack, name) {
+			tech = append(tech, name+" "+res.Versions[name])
+		}
+	}
+	section("Technologies", tech)
+
+	if c := res.Cert; c != nil {
+		lines := []string{"Subject  " + c.Subject, "Issuer   " + c.Issuer}
+		if len(c.DNSNames) > 0 {
+			lines = append(lines, "Names    "+strings.Join(c.DNSNames, ", "))
+		}
+		valid := c.NotBefore + " to " + c.NotAfter + ", " + strconv.Itoa(c.DaysRemaining) + " days left"
+		if c.Expired {
+			valid = c.NotBefore + " to " + c.NotAfter + ", expired"
+		}
+		lines = append(lines, "Valid    "+valid)
+		if c.ManagedBy != "" {
+			lines = append(lines, "Managed  "+c.ManagedBy)
+		}
+		section("TLS certificate", lines)
+	}
+	if res.Jarm != "" {
+		section("JARM", []string{res.Jarm})
+	}
+
+	var headers []string
+	if res.HeaderGrade != "" {
+		headers = append(headers, "Grade "+res.HeaderGrade)
+	}
+	for _, name := range sortedKeys(res.SecurityHeaders) {
+		headers = append(headers, name+": "+res.SecurityHeaders[name])
+	}
+	section("Security headers", headers)
+
+	var cookies []string
+	for _, c := range res.Cookies {
+		var attrs []string
+		if c.Secure {
+			attrs = append(attrs, "Secure")
+		}
+		if c.HttpOnly {
+			attrs = append(attrs, "HttpOnly")
+		}
+		if c.SameSite != "" {
+			attrs = append(attrs, "SameSite="+c.SameSite)
+		}
+		if len(attrs) == 0 {
+			attrs = append(attrs, "no attributes")
+		}
+		cookies = append(cookies, c.Name+"  "+strings.Join(attrs, " "))
+	}
+	section("Cookies", cookies)
+
+	if st := res.SecurityTxt; st != nil {
+		lines := []string{st.URL, "Contact  " + strings.Join(st.Contact, ", ")}
+		if st.Expires != "" {
+			exp := st.Expires
+			if st.Expired {
+				exp += " (expired)"
+			}
+			lines = append(lines, "Expires  "+exp)
+		}
+		section("security.txt", lines)
+	}
+	var robots []string
+	for _, p := range res.RobotsDisallow {
+		robots = append(robots, "Disallow "+p)
+	}
+	for _, u := range res.SitemapURLs {
+		robots = append(robots, "Sitemap  "+u)
+	}
+	section("robots.txt", robots)
+	section("Parameters", res.Parameters)
+
+	var paths []string
+	for _, p := range res.Paths {
+		paths = append(paths, fmt.Sprintf("%d  %s (%d bytes)", p.Status, p.Path, p.Length))
+	}
+	section("Paths", paths)
+
+	var ports []string
+	for _, p := range res.OpenPorts {
+		ports = append(ports, strings.TrimSpace(fmt.Sprintf("%d/%s %s %s %s", p.Port, p.Protocol, p.Service, p.Product, p.Version)))
+	}
+	section("Open ports", ports)
+
+	var matches []string
+	for _, m := range res.Matches {
+		matches = append(matches, m.Pattern+" ("+m.Part+"): "+m.Evidence)
+	}
+	section("Matches", matches)
+
+	findings := append([]Finding{}, res.Vulnerabilities...)
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity.rank() > findings[j].Severity.rank()
+	})
+	var lines []string
+	for _, f := range findings {
+		line := strings.ToUpper(string(f.Severity)) + "  " + f.Name()
+		if f.Stage != "" {
+			line += " [" + f.Stage + "]"
+		}
+		lines = append(lines, line)
+	}
+	section("Findings", lines)
+
+	if res.Screenshot != "" {
+		section("Screenshot", []string{res.Screenshot})
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// containsPrefix reports whether an entry of list is name or name:version
+func containsPrefix(list []string, name string) bool {
+	for _, s := range list {
+		if s == name || strings.HasPrefix(s, name+":") {
+			return true
+		}
+	}
+	return false
+}
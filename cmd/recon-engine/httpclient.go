@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	// nativeLimiter throttles every HTTP request the engine makes itself
+	nativeLimiter *tokenBucket
+
+	// proxyURL is the first -proxy, nil when traffic goes direct.
+	// proxyURLs are all of them, rotated through by proxies.
+	proxyURL  *url.URL
+	proxyURLs []*url.URL
+	proxies   *endpointPool
+
+	directTransport http.RoundTripper = http.DefaultTransport
+
+	// proxiedTransports and insecureTransports hold one transport per
+	// proxy, or a direct one without -proxy. The insecure ones do not
+	// verify certificates, for requests sent to an address rather than the
+	// name its certificate is for. Direct, they only connect over the
+	// -ip-version family.
+	proxiedTransports  = []http.RoundTripper{http.DefaultTransport}
+	insecureTransports = []http.RoundTripper{newInsecureTransport(nil)}
+)
+
+func newInsecureTransport(proxy *url.URL) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	} else {
+		t.DialContext = ipVersionDial(t.DialContext)
+	}
+	return t
+}
+
+// configureHTTP applies -proxy to the native transports. Called once after
+// flag parsing, before any request is made.
+func configureHTTP() error {
+	if proxyFlag == "" {
+		return nil
+	}
+	var names []string
+	proxiedTransports, insecureTransports = nil, nil
+	for _, raw := range splitList(proxyFlag) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q (want http, https or socks5)", u.Scheme)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("proxy URL %q has no host", u.Redacted())
+		}
+		proxyURLs = append(proxyURLs, u)
+		names = append(names, u.Scheme+"://"+u.Host)
+
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(u)
+		proxiedTransports = append(proxiedTransports, t)
+		insecureTransports = append(insecureTransports, newInsecureTransport(u))
+	}
+	proxyURL = proxyURLs[0]
+	proxies = newEndpointPool("proxy", "proxy", names)
+	return nil
+}
+
+// nextProxy returns the proxy the next external tool invocation goes
+// through, nil without -proxy. Tools take turns like native requests, but
+// only native requests judge a proxy's health.
+func nextProxy() *url.URL {
+	if proxies == nil {
+		return nil
+	}
+	return proxyURLs[proxies.peek()]
+}
+
+// waitProxy holds up a tool invocation while every -proxy is quarantined
+func waitProxy(ctx context.Context) error {
+	if proxies == nil {
+		return nil
+	}
+	_, err := proxies.pick(ctx)
+	return err
+}
+
+// limitedTransport waits on nativeLimiter before each request, and on
+// politeLimiter and any -block-action throttle too unless it is passive
+// discovery traffic, adds the -header/-user-agent values and, unless it is
+// passive discovery traffic, the -auth-file credentials of the request's
+// host, and routes it through the proxy unless it is passive discovery
+// traffic and -proxy-skip-discovery is set. insecure requests skip
+// certificate verification.
+type limitedTransport struct {
+	passive  bool
+	insecure bool
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if recordDir != "" || replayDir != "" {
+		return tapeRoundTrip(req, t.send)
+	}
+	return t.send(req)
+}
+
+func (t limitedTransport) send(req *http.Request) (*http.Response, error) {
+	if err := nativeLimiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	if !t.passive {
+		if err := politeLimiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		if err := blockLimiter(req.URL.Hostname()).Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	req = req.Clone(req.Context())
+	applyCustomHeaders(req)
+	if !t.passive {
+		applyAuthHeaders(req)
+	}
+	if t.passive && proxySkipDiscovery && !t.insecure {
+		return directTransport.RoundTrip(req)
+	}
+	transports := proxiedTransports
+	if t.insecure {
+		transports = insecureTransports
+	}
+	if proxies == nil {
+		return transports[0].RoundTrip(req)
+	}
+	i, err := proxies.pick(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := transports[i].RoundTrip(req)
+	switch {
+	case err == nil:
+		proxies.report(i, nil)
+	case proxyFailed(err):
+		proxies.report(i, err)
+	}
+	return resp, err
+}
+
+// newHTTPClient returns a client for native requests that honours
+// -rate-limit and -proxy. passive marks third-party API traffic that is not
+// aimed at the target. A zero timeout leaves the request bounded only by
+// its context.
+func newHTTPClient(timeout time.Duration, passive bool) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: limitedTransport{passive: passive},
+	}
+}
+
+// whatwebProxyArgs translates -proxy into WhatWeb options. WhatWeb only
+// speaks HTTP proxies, so SOCKS proxies cannot be honoured there.
+func whatwebProxyArgs() []string {
+	u := nextProxy()
+	if u == nil || u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	args := []string{"--proxy", u.Host}
+	if u.User != nil {
+		args = append(args, "--proxy-user", u.User.String())
+	}
+	return args
+}
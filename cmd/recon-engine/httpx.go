@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// minHttpxVersion is the oldest httpx release the engine is tested with. It
+// needs -hash, -ip and the status_code/content_length JSON field names.
+const minHttpxVersion = "1.3.0"
+
+// httpxManagedFlags are httpx options the engine sets itself or whose
+// output it depends on; -httpx-args may not use them
+var httpxManagedFlags = map[string]string{
+	"json": "", "j": "", "jsonl": "", "csv": "", "silent": "",
+	"title": "", "tech-detect": "", "td": "", "status-code": "", "sc": "",
+	"content-length": "", "cl": "", "response-time": "", "rt": "",
+	"hash": "", "ip": "", "l": "", "list": "", "u": "", "target": "",
+	"rate-limit": "-rate-limit", "rl": "-rate-limit",
+	"http-proxy": "-proxy", "proxy": "-proxy",
+	"H": "-header", "header": "-header",
+	"ports": "-probe-ports", "p": "-probe-ports",
+	"threads": "-httpx-threads", "t": "-httpx-threads",
+	"timeout":   "-httpx-timeout",
+	"retries":   "-httpx-retries",
+	"resolvers": "-resolvers", "r": "-resolvers",
+}
+
+// splitArgs splits s on whitespace, keeping single- or double-quoted
+// sections together
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// parseHttpxArgs validates -httpx-args and returns the split arguments
+func parseHttpxArgs() ([]string, error) {
+	if httpxThreads < 0 || httpxTimeout < 0 || httpxRetries < 0 {
+		return nil, fmt.Errorf("-httpx-threads, -httpx-timeout and -httpx-retries must not be negative")
+	}
+	args, err := splitArgs(httpxExtraArgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if alt, ok := httpxManagedFlags[name]; ok {
+			if alt != "" {
+				return nil, fmt.Errorf("%s is managed by the engine, use %s instead", a, alt)
+			}
+			return nil, fmt.Errorf("%s is managed by the engine and cannot be passed to httpx", a)
+		}
+	}
+	return args, nil
+}
+
+// httpxArgs builds the httpx command line
+func httpxArgs() []string {
+	args := []string{"-silent", "-json", "-title", "-tech-detect", "-status-code", "-content-length", "-response-time", "-hash", "sha256", "-ip"}
+	if r := activeRate(); r > 0 {
+		args = append(args, "-rate-limit", strconv.Itoa(r))
+	}
+	if u := nextProxy(); u != nil {
+		args = append(args, "-http-proxy", u.String())
+	}
+	args = append(args, httpxHeaderArgs()...)
+	if probePorts != "" {
+		args = append(args, "-ports", probePorts)
+	}
+	if portscanMode == "hosts" || dirBrute || jarmFlag {
+		// CDN edges are left out of port scans, path brute-forcing and JARM
+		args = append(args, "-cdn")
+	}
+	if rulesNeedHeaders || headerAudit {
+		args = append(args, "-irh")
+	}
+	args = append(args, httpxIPVersionArgs()...)
+	switch {
+	case politeMode:
+		args = append(args, "-threads", "1")
+	case httpxThreads > 0:
+		args = append(args, "-threads", strconv.Itoa(httpxThreads))
+	}
+	if httpxTimeout > 0 {
+		args = append(args, "-timeout", strconv.Itoa(httpxTimeout))
+	}
+	if httpxRetries > 0 {
+		args = append(args, "-retries", strconv.Itoa(httpxRetries))
+	}
+	if r := dnsResolver.toolArg(); r != "" {
+		args = append(args, "-r", r)
+	}
+	return append(args, httpxPassthrough...)
+}
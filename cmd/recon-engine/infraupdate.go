@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// amass often reports a name's infrastructure after httpx has already
+// probed it, so the Result goes out without amass's ASN and Org, or with
+// what -asn-db made of its address. Results emitted while amass still owed
+// their name are tracked, and once discovery is over the reconciliation
+// pass counts those amass described after all and, with -emit-updates,
+// queues an update record for each.
+
+// infraUpdate is the -emit-updates record patching an emitted Result, keyed
+// by run ID and subdomain, and the URL for names live on several ports
+type infraUpdate struct {
+	Type       string            `json:"type"`
+	RunID      string            `json:"run_id"`
+	RootDomain string            `json:"root_domain"`
+	Subdomain  string            `json:"subdomain"`
+	URL        string            `json:"url,omitempty"`
+	Timestamp  string            `json:"timestamp"`
+	Set        map[string]string `json:"set"`
+	Reason     string            `json:"reason"`
+}
+
+var (
+	infraUpdatesMu sync.Mutex
+	infraUpdates   []infraUpdate
+)
+
+// infraPending tracks the Results of one pipeline emitted before amass
+// described their name. It is only used from the emit goroutine and after
+// it ends.
+type infraPending struct {
+	target  string
+	results []infraEmitted
+}
+
+// infraEmitted is what an update record needs of an emitted Result: its key
+// and the infrastructure it went out with
+type infraEmitted struct {
+	subdomain, url string
+	asn, org       string
+}
+
+// newInfraPending returns nil unless amass is among sources, as no
+// infrastructure can arrive late without it
+func newInfraPending(target string, sources []string) *infraPending {
+	if !contains(sources, "amass") {
+		return nil
+	}
+	return &infraPending{target: target}
+}
+
+// Observe tracks res if amass has not described its name yet
+func (p *infraPending) Observe(res Result) {
+	if p == nil || res.Subdomain == "" {
+		return
+	}
+	if _, ok := amassInfra(res.Subdomain); ok {
+		return
+	}
+	p.results = append(p.results, infraEmitted{subdomain: res.Subdomain, url: res.URL, asn: res.Asn, org: res.Org})
+}
+
+// Reconcile applies what amass reported after the tracked Results went out.
+// Called once discovery has ended, so no more infrastructure arrives.
+func (p *infraPending) Reconcile() {
+	if p == nil {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, res := range p.results {
+		inf, ok := amassInfra(res.subdomain)
+		if !ok {
+			continue
+		}
+		asn := fmt.Sprintf("AS%d", inf.Asn)
+		if asn == res.asn && inf.Org == res.org {
+			continue
+		}
+		stats.Add("infra.late", 1)
+		if !emitUpdates {
+			continue
+		}
+		infraUpdatesMu.Lock()
+		infraUpdates = append(infraUpdates, infraUpdate{
+			Type:       "update",
+			RunID:      summary.RunID,
+			RootDomain: p.target,
+			Subdomain:  res.subdomain,
+			URL:        res.url,
+			Timestamp:  now,
+			Set:        map[string]string{"asn": asn, "org": inf.Org},
+			Reason:     "late amass infrastructure",
+		})
+		infraUpdatesMu.Unlock()
+	}
+}
+
+// amassInfra returns what amass reported about name's infrastructure so far
+func amassInfra(name string) (Infrastructure, bool) {
+	infraMutex.Lock()
+	defer infraMutex.Unlock()
+	inf, ok := infraMap[name]
+	return inf, ok
+}
+
+// writeInfraUpdates writes the queued update records to w after the
+// Results they patch, and forgets them. Only stdout or -o gets them: they
+// are not Results, so the other sinks and -fields never see them.
+func writeInfraUpdates(w io.Writer) {
+	infraUpdatesMu.Lock()
+	updates := infraUpdates
+	infraUpdates = nil
+	infraUpdatesMu.Unlock()
+	enc := json.NewEncoder(w)
+	for _, u := range updates {
+		if err := enc.Encode(u); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing update records: %v\n", err)
+			return
+		}
+		stats.Add("infra.updates", 1)
+	}
+}
+
+// validateEmitUpdates checks -emit-updates against the output format: the
+// update records are NDJSON lines next to the Results
+func validateEmitUpdates() error {
+	if !emitUpdates {
+		return nil
+	}
+	if plainOutput || resultTemplate != nil || (outputFormat != "" && outputFormat != "json") {
+		return fmt.Errorf("-emit-updates needs JSON output")
+	}
+	return nil
+}
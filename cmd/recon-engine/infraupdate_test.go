@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// setInfraUpdates empties infraMap and the queued update records, and sets
+// -emit-updates and the run ID
+func setInfraUpdates(t *testing.T) {
+	t.Helper()
+	oldEmit, oldRunID := emitUpdates, summary.RunID
+	infraMutex.Lock()
+	oldInfra := infraMap
+	infraMap = make(map[string]Infrastructure)
+	infraMutex.Unlock()
+	t.Cleanup(func() {
+		emitUpdates, summary.RunID = oldEmit, oldRunID
+		infraMutex.Lock()
+		infraMap = oldInfra
+		infraMutex.Unlock()
+		infraUpdatesMu.Lock()
+		infraUpdates = nil
+		infraUpdatesMu.Unlock()
+	})
+	emitUpdates, summary.RunID = true, "run-1"
+	infraUpdatesMu.Lock()
+	infraUpdates = nil
+	infraUpdatesMu.Unlock()
+}
+
+func setAmassInfra(name string, inf Infrastructure) {
+	infraMutex.Lock()
+	infraMap[name] = inf
+	infraMutex.Unlock()
+}
+
+// readInfraUpdates returns the records writeInfraUpdates writes
+func readInfraUpdates(t *testing.T) []infraUpdate {
+	t.Helper()
+	var buf bytes.Buffer
+	writeInfraUpdates(&buf)
+	var updates []infraUpdate
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var u infraUpdate
+		if err := dec.Decode(&u); err != nil {
+			t.Fatal(err)
+		}
+		updates = append(updates, u)
+	}
+	return updates
+}
+
+// TestInfraUpdateAmassLate writes one update record for a Result httpx
+// probed before amass described its name
+func TestInfraUpdateAmassLate(t *testing.T) {
+	setInfraUpdates(t)
+	before := stats.Get("infra.late")
+	p := newInfraPending("example.com", []string{"subfinder", "amass"})
+	p.Observe(Result{
+		Subdomain: "www.example.com",
+		URL:       "https://www.example.com",
+		Asn:       "AS64496",
+		Org:       "from -asn-db",
+		TechStack: []string{"nginx"},
+	})
+	setAmassInfra("www.example.com", Infrastructure{Asn: 64500, Org: "Example Hosting"})
+	p.Reconcile()
+
+	updates := readInfraUpdates(t)
+	if len(updates) != 1 {
+		t.Fatalf("%d update records, want 1", len(updates))
+	}
+	u := updates[0]
+	if u.Type != "update" || u.RunID != "run-1" || u.RootDomain != "example.com" || u.Subdomain != "www.example.com" || u.URL != "https://www.example.com" {
+		t.Errorf("record key %+v", u)
+	}
+	if len(u.Set) != 2 || u.Set["asn"] != "AS64500" || u.Set["org"] != "Example Hosting" {
+		t.Errorf("record set %v", u.Set)
+	}
+	if stats.Get("infra.late") != before+1 {
+		t.Error("the late infrastructure was not counted")
+	}
+	if updates := readInfraUpdates(t); len(updates) != 0 {
+		t.Errorf("records written twice: %+v", updates)
+	}
+}
+
+// TestInfraUpdateNone writes no record when amass described the name before
+// the Result went out, when it reported what the Result already had, or
+// when it never described it
+func TestInfraUpdateNone(t *testing.T) {
+	setInfraUpdates(t)
+	p := newInfraPending("example.com", []string{"amass"})
+
+	setAmassInfra("early.example.com", Infrastructure{Asn: 64500, Org: "Example Hosting"})
+	p.Observe(Result{Subdomain: "early.example.com", URL: "https://early.example.com"})
+	p.Observe(Result{Subdomain: "same.example.com", URL: "https://same.example.com", Asn: "AS64501", Org: "Same Org"})
+	p.Observe(Result{Subdomain: "never.example.com", URL: "https://never.example.com"})
+	if len(p.results) != 2 {
+		t.Errorf("tracking %d Results, want 2", len(p.results))
+	}
+	setAmassInfra("early.example.com", Infrastructure{Asn: 64502, Org: "Changed"})
+	setAmassInfra("same.example.com", Infrastructure{Asn: 64501, Org: "Same Org"})
+	p.Reconcile()
+	if updates := readInfraUpdates(t); len(updates) != 0 {
+		t.Errorf("update records %+v", updates)
+	}
+}
+
+// TestInfraUpdateWithout writes no record without -emit-updates, and
+// tracks nothing without amass
+func TestInfraUpdateWithout(t *testing.T) {
+	setInfraUpdates(t)
+	if p := newInfraPending("example.com", []string{"subfinder"}); p != nil {
+		t.Error("tracking Results without amass")
+	}
+	emitUpdates = false
+	p := newInfraPending("example.com", []string{"amass"})
+	p.Observe(Result{Subdomain: "www.example.com"})
+	setAmassInfra("www.example.com", Infrastructure{Asn: 64500, Org: "Example Hosting"})
+	p.Reconcile()
+	if updates := readInfraUpdates(t); len(updates) != 0 {
+		t.Errorf("update records without -emit-updates: %+v", updates)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// minGoVersion is the oldest toolchain the pinned tools build with
+const minGoVersion = "1.22"
+
+// goTool is a tool install builds from source with go install
+type goTool struct {
+	name    string
+	pkg     string
+	version string // pinned release, bumped here when a newer one is vetted
+	hint    string // printed when go install fails
+}
+
+var goTools = []goTool{
+	{"subfinder", "github.com/projectdiscovery/subfinder/v2/cmd/subfinder", "v2.6.6", ""},
+	{"httpx", "github.com/projectdiscovery/httpx/cmd/httpx", "v1.6.8", ""},
+	{"dnsx", "github.com/projectdiscovery/dnsx/cmd/dnsx", "v1.2.1", ""},
+	{"naabu", "github.com/projectdiscovery/naabu/v2/cmd/naabu", "v2.3.1", "naabu links against libpcap; install its headers (libpcap-dev, libpcap-devel or brew install libpcap) and retry"},
+	{"nuclei", "github.com/projectdiscovery/nuclei/v3/cmd/nuclei", "v3.3.2", ""},
+	{"katana", "github.com/projectdiscovery/katana/cmd/katana", "v1.1.0", ""},
+	{"tlsx", "github.com/projectdiscovery/tlsx/cmd/tlsx", "v1.1.7", ""},
+}
+
+// systemTools are installed with the OS package manager. install only says
+// how, keyed by package manager; a missing entry means no package exists.
+var systemTools = []struct {
+	name     string
+	packages map[string]string
+}{
+	{"whatweb", map[string]string{"brew": "whatweb", "apt": "whatweb", "dnf": "whatweb", "pacman": "whatweb"}},
+	{"nmap", map[string]string{"brew": "nmap", "apt": "nmap", "dnf": "nmap", "pacman": "nmap", "winget": "Insecure.Nmap", "choco": "nmap", "scoop": "nmap"}},
+	{"masscan", map[string]string{"brew": "masscan", "apt": "masscan", "dnf": "masscan", "pacman": "masscan"}},
+}
+
+// packageManagers are tried in order; the first found on PATH is used
+var packageManagers = map[string][]struct{ name, command string }{
+	"darwin": {{"brew", "brew install %s"}},
+	"linux": {
+		{"apt", "sudo apt install %s"},
+		{"dnf", "sudo dnf install %s"},
+		{"pacman", "sudo pacman -S %s"},
+		{"brew", "brew install %s"},
+	},
+	"windows": {
+		{"winget", "winget install %s"},
+		{"choco", "choco install %s"},
+		{"scoop", "scoop install %s"},
+	},
+}
+
+// runInstall implements install: go install the pinned Go tools that are
+// missing (or, with -update, not at their pinned version), check them
+// afterwards and print how to get the rest
+func runInstall(args []string) {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	check := fs.Bool("check", false, "Only report which tools are missing or not at their pinned version")
+	update := fs.Bool("update", false, "Reinstall Go tools that are not at their pinned version")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s install [flags]\n\nInstalls the Go-based tools (%s)\nwith go install at the versions pinned in this release, into GOBIN or\nGOPATH/bin, and checks their versions afterwards. Tools already installed\nare left alone unless -update is given. For WhatWeb, nmap and masscan it\nprints the package manager command instead of running it. Exits 1 when a\nGo tool is missing, not at its pinned version or failed to install.\n\nFlags:\n", os.Args[0], goToolNames())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		exit(2)
+	}
+	if *check && *update {
+		startupError("Invalid install options", fmt.Errorf("-check and -update cannot be combined"))
+	}
+
+	bin, binErr := goBinDir()
+	if !*check && binErr != nil {
+		fatalError("Cannot install the Go tools", binErr)
+	}
+
+	var rows [][3]string
+	failed := false
+	for _, t := range goTools {
+		path, v := findGoTool(t.name, bin)
+		state := goToolState(path, v, t.version)
+		if *check || state == "ok" || (state != "missing" && !*update) {
+			if state != "ok" {
+				failed = true
+			}
+			rows = append(rows, [3]string{t.name, state, goToolDetail(path, v, t.version, *update)})
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "Installing %s@%s\n", t.pkg, t.version)
+		cmd := exec.Command("go", "install", t.pkg+"@"+t.version)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			failed = true
+			detail := "go install failed: " + err.Error()
+			if t.hint != "" {
+				detail += "; " + t.hint
+			}
+			rows = append(rows, [3]string{t.name, "failed", detail})
+			continue
+		}
+		path = filepath.Join(bin, t.name+exeSuffix())
+		v = readVersion(path, toolVersions[t.name])
+		state = goToolState(path, v, t.version)
+		if state != "ok" {
+			failed = true
+		}
+		rows = append(rows, [3]string{t.name, state, goToolDetail(path, v, t.version, true)})
+	}
+
+	pm, pmCommand := detectPackageManager()
+	for _, t := range systemTools {
+		if path, err := exec.LookPath(t.name); err == nil {
+			rows = append(rows, [3]string{t.name, "ok", path})
+			continue
+		}
+		pkg, ok := t.packages[pm]
+		switch {
+		case pm == "":
+			rows = append(rows, [3]string{t.name, "missing", "install it with your package manager or from its website"})
+		case !ok:
+			rows = append(rows, [3]string{t.name, "missing", "no " + pm + " package; build it from source"})
+		default:
+			rows = append(rows, [3]string{t.name, "missing", "run: " + fmt.Sprintf(pmCommand, pkg)})
+		}
+	}
+
+	if err := printInstallRows(os.Stdout, rows); err != nil {
+		fatalError("Failed to print the tool report", err)
+	}
+	if binErr != nil {
+		fmt.Fprintf(os.Stdout, "\nGo tools cannot be installed: %v\n", binErr)
+	} else if !inPath(bin) {
+		fmt.Fprintf(os.Stdout, "\n%s is not in PATH; add it so the engine finds the tools, e.g.\n  export PATH=\"$PATH:%s\"\n", bin, bin)
+	}
+	if failed {
+		exit(1)
+	}
+	exit(0)
+}
+
+// goBinDir returns where go install puts binaries, after checking that a
+// recent enough Go toolchain is available
+func goBinDir() (string, error) {
+	if _, err := exec.LookPath("go"); err != nil {
+		return "", fmt.Errorf("the Go toolchain was not found in PATH; install Go %s or newer from https://go.dev/dl/", minGoVersion)
+	}
+	out, err := exec.Command("go", "env", "GOVERSION", "GOBIN", "GOPATH").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env failed: %w", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\r\n"), "\n")
+	if len(lines) < 3 {
+		return "", fmt.Errorf("unexpected go env output %q", out)
+	}
+	goVersion := strings.TrimPrefix(strings.TrimSpace(lines[0]), "go")
+	if compareVersions(goVersion, minGoVersion) < 0 {
+		return "", fmt.Errorf("Go %s is too old to build the tools; upgrade to %s or newer from https://go.dev/dl/", goVersion, minGoVersion)
+	}
+	if bin := strings.TrimSpace(lines[1]); bin != "" {
+		return bin, nil
+	}
+	gopath := strings.TrimSpace(lines[2])
+	if gopath == "" {
+		return "", fmt.Errorf("neither GOBIN nor GOPATH is set; set GOBIN to the directory the tools should go to")
+	}
+	// go install uses the first GOPATH entry
+	return filepath.Join(filepath.SplitList(gopath)[0], "bin"), nil
+}
+
+// findGoTool looks for tool on PATH, then in bin, and returns its path and
+// version; path is "" when it was not found
+func findGoTool(tool, bin string) (path, version string) {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		if bin == "" {
+			return "", ""
+		}
+		path = filepath.Join(bin, tool+exeSuffix())
+		if _, err := exec.LookPath(path); err != nil {
+			return "", ""
+		}
+	}
+	return path, readVersion(path, toolVersions[tool])
+}
+
+// goToolState is ok, missing, outdated (any version other than the pinned
+// one) or unknown when the version banner was not recognised
+func goToolState(path, version, pinned string) string {
+	switch {
+	case path == "":
+		return "missing"
+	case version == "":
+		return "unknown"
+	case compareVersions(version, strings.TrimPrefix(pinned, "v")) != 0:
+		return "outdated"
+	}
+	return "ok"
+}
+
+func goToolDetail(path, version, pinned string, update bool) string {
+	switch {
+	case path == "":
+		return "want " + pinned
+	case version == "":
+		return path + ", version not recognised (want " + pinned + ")"
+	case compareVersions(version, strings.TrimPrefix(pinned, "v")) != 0 && !update:
+		return fmt.Sprintf("%s %s, pinned %s (install -update to switch)", path, version, pinned)
+	case compareVersions(version, strings.TrimPrefix(pinned, "v")) != 0:
+		return fmt.Sprintf("%s %s, pinned %s", path, version, pinned)
+	}
+	return path + " " + version
+}
+
+// detectPackageManager returns the first known package manager on PATH for
+// this OS and its install command, or "" when there is none
+func detectPackageManager() (name, command string) {
+	for _, pm := range packageManagers[runtime.GOOS] {
+		if _, err := exec.LookPath(pm.name); err == nil {
+			return pm.name, pm.command
+		}
+	}
+	return "", ""
+}
+
+func printInstallRows(w io.Writer, rows [][3]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOOL\tSTATUS\tDETAIL")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r[0], strings.ToUpper(r[1]), r[2])
+	}
+	return tw.Flush()
+}
+
+func inPath(dir string) bool {
+	for _, p := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(p) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func exeSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+func goToolNames() string {
+	names := make([]string, len(goTools))
+	for i, t := range goTools {
+		names[i] = t.name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// ipHostIndex counts the distinct subdomains seen on each IP during the run
+type ipHostIndex struct {
+	mu    sync.Mutex
+	hosts map[string]map[string]bool
+}
+
+var ipHosts = &ipHostIndex{hosts: make(map[string]map[string]bool)}
+
+// Add records that subdomain resolved to ip and returns how many distinct
+// subdomains now share that IP
+func (x *ipHostIndex) Add(ip, subdomain string) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	set, ok := x.hosts[ip]
+	if !ok {
+		set = make(map[string]bool)
+		x.hosts[ip] = set
+	}
+	set[subdomain] = true
+	return len(set)
+}
+
+// Has reports whether any subdomain was seen on ip
+func (x *ipHostIndex) Has(ip string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.hosts[ip]) > 0
+}
+
+// SharedIP describes an IP serving at least the shared-hosting threshold of
+// subdomains, as reported in the run summary
+type SharedIP struct {
+	IP         string   `json:"ip"`
+	Count      int      `json:"count"`
+	Subdomains []string `json:"subdomains"`
+}
+
+// Shared returns every IP hosting at least threshold subdomains, busiest first
+func (x *ipHostIndex) Shared(threshold int) []SharedIP {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var out []SharedIP
+	for ip, set := range x.hosts {
+		if len(set) < threshold {
+			continue
+		}
+		subs := make([]string, 0, len(set))
+		for s := range set {
+			subs = append(subs, s)
+		}
+		sort.Strings(subs)
+		out = append(out, SharedIP{IP: ip, Count: len(set), Subdomains: subs})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].IP < out[j].IP
+	})
+	return out
+}
+
+// probeIP picks the address httpx connected to: its host field when that is
+// an IP, otherwise the first A record it reported, or AAAA record without
+// one, of the -ip-version families
+func probeIP(h HttpxResult) string {
+	if net.ParseIP(h.Host) != nil {
+		return h.Host
+	}
+	for _, a := range append(append([]string(nil), h.A...), h.AAAA...) {
+		if ipAllowed(a) {
+			return a
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+)
+
+// parseIPTarget reports whether target is an address or a CIDR range rather
+// than a domain. An address is a range of one.
+func parseIPTarget(target string) (netip.Prefix, bool) {
+	if a, err := netip.ParseAddr(target); err == nil {
+		a = a.Unmap()
+		return netip.PrefixFrom(a, a.BitLen()), true
+	}
+	if p, err := netip.ParsePrefix(target); err == nil {
+		return p.Masked(), true
+	}
+	return netip.Prefix{}, false
+}
+
+// targetRange is the range an IP or CIDR target covers; it is the zero
+// Prefix for domain and URL targets. Each target of a run is classified on
+// its own.
+func targetRange(target string) netip.Prefix {
+	p, _ := parseIPTarget(target)
+	return p
+}
+
+// validateIPTarget checks an IP or CIDR target, which is scanned without
+// discovery. The flags that only make sense for a domain are refused, and
+// so is a range larger than -max-ips: as with -asn-expand, sweeping part of
+// a range is worse than sweeping none of it. Domain targets pass.
+func validateIPTarget(target string) error {
+	if maxIPs < 1 {
+		return fmt.Errorf("-max-ips must be at least 1")
+	}
+	p, ok := parseIPTarget(target)
+	if !ok {
+		return nil
+	}
+	if f := discoveryFlag(); f != "" {
+		return fmt.Errorf("%s needs a domain target, %s is scanned without discovery", f, target)
+	}
+	if whoisLookup {
+		return fmt.Errorf("-whois needs a domain target")
+	}
+	if dnsAudit {
+		return fmt.Errorf("-dns-audit needs a domain target")
+	}
+	hostBits := p.Addr().BitLen() - p.Bits()
+	if hostBits >= 31 {
+		return fmt.Errorf("%s holds more than -max-ips %d addresses", target, maxIPs)
+	}
+	if n := 1 << hostBits; n > maxIPs {
+		return fmt.Errorf("%s holds %d addresses, more than -max-ips %d", target, n, maxIPs)
+	}
+	return nil
+}
+
+// discoveryFlag returns the first flag set that only makes sense with
+// discovery, or "" when there is none
+func discoveryFlag() string {
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-sources", sourcesFlag != ""},
+		{"-deep", useDeep},
+		{"-brute", bruteForce},
+		{"-axfr", axfr},
+		{"-recursive", recursive},
+		{"-permute", permute},
+		{"-ptr", ptrSweep},
+		{"-mail-check", mailCheck},
+	} {
+		if f.set {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// rangeAddrs returns the addresses of p that -scope allows. The network and
+// broadcast addresses of IPv4 ranges are left out.
+func rangeAddrs(p netip.Prefix) []string {
+	var all []netip.Addr
+	for a := p.Addr(); p.Contains(a); a = a.Next() {
+		all = append(all, a)
+	}
+	if p.Addr().Is4() && p.Bits() <= 30 {
+		all = all[1 : len(all)-1]
+	}
+	addrs := make([]string, 0, len(all))
+	for _, a := range all {
+		if ip := a.String(); scopeAllowsIP(ip) {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
+// runIPRange sends addrs to out in place of discovery. With -portscan hosts
+// they are port scanned first and each open TCP port goes to httpx as
+// ip:port; the ports found are recorded in open for the port scan Results.
+func runIPRange(ctx context.Context, addrs []string, open map[string][]OpenPort, out chan<- string) {
+	if portscanMode != "hosts" {
+		for _, ip := range addrs {
+			if ctx.Err() != nil {
+				return
+			}
+			recordDiscovery(ip, "ip-range")
+			out <- ip
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Port scanning %d addresses with %s\n", len(addrs), portscanEngine)
+	coverageAdd("portscan", len(addrs))
+	size := portscanBatchSize(len(addrs))
+	for start := 0; start < len(addrs) && ctx.Err() == nil; start += size {
+		batch := addrs[start:min(start+size, len(addrs))]
+		found := scanPortBatch(ctx, batch)
+		for _, ip := range batch {
+			a, err := netip.ParseAddr(ip)
+			if err != nil || len(found[ip]) == 0 {
+				continue
+			}
+			open[ip] = found[ip]
+			for _, p := range found[ip] {
+				if p.Protocol != "tcp" {
+					continue
+				}
+				input := netip.AddrPortFrom(a, uint16(p.Port)).String()
+				recordDiscovery(input, "ip-range")
+				out <- input
+			}
+		}
+	}
+}
+
+// emitRangePorts emits a port scan Result for every address runIPRange
+// found open ports on, named by its PTR record when it has one
+func emitRangePorts(ctx context.Context, target string, open map[string][]OpenPort, emit func(Result)) {
+	ips := make([]string, 0, len(open))
+	for ip := range open {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		emit(portscanResult(target, lookupPTR(ctx, ip), ip, open[ip]))
+	}
+}
+
+// rangeHost returns the address of an httpx input runIPRange fed, ip or
+// ip:port
+func rangeHost(input string) string {
+	if ap, err := netip.ParseAddrPort(input); err == nil {
+		return ap.Addr().String()
+	}
+	return input
+}
+
+// inTarget reports whether host belongs to the target: the same registrable
+// domain, or an address inside an IP or CIDR target
+func inTarget(host, target string) bool {
+	p := targetRange(target)
+	if !p.IsValid() {
+		return sameRegistrableDomain(host, targetHost(target))
+	}
+	a, err := netip.ParseAddr(host)
+	return err == nil && p.Contains(a.Unmap())
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// -ip-version values: the address families hosts are resolved, probed and
+// port scanned on
+const (
+	ipVersion4    = "4"
+	ipVersion6    = "6"
+	ipVersionBoth = "both"
+)
+
+// configureIPVersion checks -ip-version against the target and -source-ip:
+// an IP or URL target of the other family, or a source address that cannot
+// reach it, would leave nothing to scan. Called once after the target is
+// configured.
+func configureIPVersion(target string) error {
+	switch ipVersion {
+	case ipVersion4, ipVersion6, ipVersionBoth:
+	default:
+		return fmt.Errorf("-ip-version must be 4, 6 or both, got %q", ipVersion)
+	}
+	var a netip.Addr
+	if p := targetRange(target); p.IsValid() {
+		a = p.Addr()
+	} else if u := targetURL(target); u != nil {
+		a, _ = netip.ParseAddr(u.Hostname())
+	}
+	if a.IsValid() && !ipFamilyAllowed(a) {
+		return fmt.Errorf("%s is an IPv%s target, -ip-version %s leaves nothing to scan", target, addrFamily(a), ipVersion)
+	}
+	if egressIP.IsValid() && !ipFamilyAllowed(egressIP) {
+		return fmt.Errorf("-source-ip %s is IPv%s, it cannot reach -ip-version %s hosts", egressIP, addrFamily(egressIP), ipVersion)
+	}
+	return nil
+}
+
+// addrFamily is "4" or "6"
+func addrFamily(a netip.Addr) string {
+	if a.Unmap().Is4() {
+		return ipVersion4
+	}
+	return ipVersion6
+}
+
+// ipFamilyAllowed reports whether -ip-version lets a through
+func ipFamilyAllowed(a netip.Addr) bool {
+	return ipVersion == ipVersionBoth || addrFamily(a) == ipVersion
+}
+
+// ipAllowed is ipFamilyAllowed for an address in text; anything that is not
+// an address is refused
+func ipAllowed(ip string) bool {
+	a, err := netip.ParseAddr(ip)
+	return err == nil && ipFamilyAllowed(a)
+}
+
+// hostIPs gathers the addresses of a probed host, IPv4 then IPv6, in the
+// order they were reported and without repeats, keeping those -ip-version
+// lets through
+func hostIPs(lists ...[]string) []string {
+	var v4, v6 []string
+	for _, list := range lists {
+		for _, ip := range list {
+			a, err := netip.ParseAddr(ip)
+			if err != nil || !ipFamilyAllowed(a) {
+				continue
+			}
+			ip = a.Unmap().String()
+			if slices.Contains(v4, ip) || slices.Contains(v6, ip) {
+				continue
+			}
+			if a.Unmap().Is4() {
+				v4 = append(v4, ip)
+			} else {
+				v6 = append(v6, ip)
+			}
+		}
+	}
+	return append(v4, v6...)
+}
+
+// splitFamilies splits ips into the IPv4 ones and the IPv6 ones, for tools
+// such as nmap that scan one family per run
+func splitFamilies(ips []string) [][]string {
+	var v4, v6 []string
+	for _, ip := range ips {
+		if a, err := netip.ParseAddr(ip); err == nil && a.Unmap().Is6() {
+			v6 = append(v6, ip)
+		} else {
+			v4 = append(v4, ip)
+		}
+	}
+	var out [][]string
+	for _, group := range [][]string{v4, v6} {
+		if len(group) > 0 {
+			out = append(out, group)
+		}
+	}
+	return out
+}
+
+// hasIPv6 reports whether any of hosts is an IPv6 address, or the -dry-run
+// placeholder for them
+func hasIPv6(hosts []string) bool {
+	return slices.ContainsFunc(hosts, func(h string) bool {
+		return isIPv6(h) || h == dryRunIPv6
+	})
+}
+
+// probeFamilyAllowed reports whether an httpx answer came over a family
+// -ip-version allows. With -ip-version 4 or 6 httpx probes every address of
+// a name, -pa, and the answers on the other family are dropped here.
+func probeFamilyAllowed(h HttpxResult) bool {
+	a, err := netip.ParseAddr(h.Host)
+	return err != nil || ipFamilyAllowed(a)
+}
+
+// ipVersionDial keeps the connections dial makes to the -ip-version
+// family. Names resolve to addresses of that family only, and addresses of
+// the other fail to connect.
+func ipVersionDial(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" && ipVersion != ipVersionBoth {
+			network += ipVersion
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// httpxIPVersionArgs makes httpx probe every address of a name when
+// -ip-version keeps to one family, so that a name with addresses of both is
+// reached on the one asked for
+func httpxIPVersionArgs() []string {
+	if ipVersion == ipVersion4 || ipVersion == ipVersion6 {
+		return []string{"-pa"}
+	}
+	return nil
+}
+
+// naabuIPVersionArgs asks naabu for the families of ips, or of
+// -ip-version. naabu scans IPv4 only unless told otherwise.
+func naabuIPVersionArgs(ips []string) []string {
+	switch {
+	case ipVersion == ipVersion6:
+		return []string{"-iv", ipVersion6}
+	case ipVersion == ipVersion4:
+		return nil
+	case hasIPv6(ips):
+		return []string{"-iv", "4,6"}
+	}
+	return nil
+}
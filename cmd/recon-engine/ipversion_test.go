@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func setIPVersion(t *testing.T, v string) {
+	old := ipVersion
+	t.Cleanup(func() { ipVersion = old })
+	ipVersion = v
+}
+
+func TestHostIPs(t *testing.T) {
+	a := []string{"2001:db8::10", "192.0.2.1", "::ffff:192.0.2.1", "not-an-ip"}
+	aaaa := []string{"2001:DB8::10", "2001:db8::11", "192.0.2.2"}
+	for _, c := range []struct {
+		version string
+		want    []string
+	}{
+		{ipVersionBoth, []string{"192.0.2.1", "192.0.2.2", "2001:db8::10", "2001:db8::11"}},
+		{ipVersion4, []string{"192.0.2.1", "192.0.2.2"}},
+		{ipVersion6, []string{"2001:db8::10", "2001:db8::11"}},
+	} {
+		setIPVersion(t, c.version)
+		if got := hostIPs(a, aaaa); !slices.Equal(got, c.want) {
+			t.Errorf("-ip-version %s: %v, want %v", c.version, got, c.want)
+		}
+	}
+}
+
+func TestSplitFamilies(t *testing.T) {
+	got := splitFamilies([]string{"192.0.2.1", "2001:db8::1", "192.0.2.2", "fe80::1%eth0"})
+	if len(got) != 2 || !slices.Equal(got[0], []string{"192.0.2.1", "192.0.2.2"}) || !slices.Equal(got[1], []string{"2001:db8::1", "fe80::1%eth0"}) {
+		t.Errorf("splitFamilies = %v", got)
+	}
+	if got := splitFamilies([]string{"2001:db8::1"}); len(got) != 1 || got[0][0] != "2001:db8::1" {
+		t.Errorf("IPv6 only: %v", got)
+	}
+}
+
+func TestConfigureIPVersion(t *testing.T) {
+	oldEgress := egressIP
+	t.Cleanup(func() { egressIP = oldEgress })
+	for _, c := range []struct {
+		version, target, source string
+		ok                      bool
+	}{
+		{ipVersionBoth, "2001:db8::/120", "", true},
+		{ipVersion6, "2001:db8::1", "", true},
+		{ipVersion4, "2001:db8::/120", "", false},
+		{ipVersion4, "https://[2001:db8::1]:8443/", "", false},
+		{ipVersion6, "192.0.2.0/28", "", false},
+		{ipVersion6, "::ffff:192.0.2.1", "", false},
+		{ipVersion6, "example.com", "", true},
+		{ipVersion4, "example.com", "2001:db8::53", false},
+		{ipVersion6, "example.com", "2001:db8::53", true},
+		{"v6", "example.com", "", false},
+	} {
+		setIPVersion(t, c.version)
+		egressIP = netip.Addr{}
+		if c.source != "" {
+			egressIP = netip.MustParseAddr(c.source)
+		}
+		if err := configureIPVersion(c.target); (err == nil) != c.ok {
+			t.Errorf("-ip-version %s, target %s, -source-ip %q: %v", c.version, c.target, c.source, err)
+		}
+	}
+}
+
+func TestProbeFamilyAllowed(t *testing.T) {
+	setIPVersion(t, ipVersion4)
+	for host, want := range map[string]bool{"192.0.2.1": true, "2001:db8::1": false, "": true} {
+		if got := probeFamilyAllowed(HttpxResult{Host: host}); got != want {
+			t.Errorf("-ip-version 4, answer over %q: %v", host, got)
+		}
+	}
+	setIPVersion(t, ipVersion6)
+	if probeFamilyAllowed(HttpxResult{Host: "192.0.2.1"}) || !probeFamilyAllowed(HttpxResult{Host: "2001:db8::1"}) {
+		t.Error("-ip-version 6 kept the wrong family")
+	}
+}
+
+// TestIPVersionToolArgs asks each tool for IPv6 when there are IPv6
+// addresses to reach or -ip-version 6
+func TestIPVersionToolArgs(t *testing.T) {
+	oldEgress, oldNmap := egressIP, nmapOutput
+	t.Cleanup(func() { egressIP, nmapOutput = oldEgress, oldNmap })
+	egressIP, nmapOutput = netip.Addr{}, "nmap.txt"
+	v4 := []string{"192.0.2.1"}
+	v6 := []string{"2001:db8::1", "2001:db8::2"}
+
+	setIPVersion(t, ipVersionBoth)
+	if args := httpxIPVersionArgs(); args != nil {
+		t.Errorf("httpx with both families: %v", args)
+	}
+	if args := naabuIPVersionArgs(v4); args != nil {
+		t.Errorf("naabu, IPv4 hosts: %v", args)
+	}
+	if args := naabuIPVersionArgs(append(v4, v6...)); !slices.Equal(args, []string{"-iv", "4,6"}) {
+		t.Errorf("naabu, both families: %v", args)
+	}
+	if args := hostNmapArgs(v4); slices.Contains(args, "-6") {
+		t.Errorf("nmap, IPv4 hosts: %v", args)
+	}
+	for _, args := range [][]string{hostNmapArgs(v6), nmapServiceArgs("80,443", v6), nmapArgs(v6...)} {
+		if !slices.Contains(args, "-6") || !slices.Contains(args, "2001:db8::2") {
+			t.Errorf("nmap, IPv6 hosts: %v", args)
+		}
+	}
+	if args := nmapArgs(dryRunIPv6); !slices.Contains(args, "-6") {
+		t.Errorf("nmap, -dry-run IPv6 placeholder: %v", args)
+	}
+
+	setIPVersion(t, ipVersion6)
+	if args := httpxIPVersionArgs(); !slices.Equal(args, []string{"-pa"}) {
+		t.Errorf("httpx -ip-version 6: %v", args)
+	}
+	if args := naabuIPVersionArgs(nil); !slices.Equal(args, []string{"-iv", "6"}) {
+		t.Errorf("naabu -ip-version 6: %v", args)
+	}
+	if args := nmapArgs("www.example.com"); !slices.Contains(args, "-6") {
+		t.Errorf("nmap -ip-version 6: %v", args)
+	}
+	setIPVersion(t, ipVersion4)
+	if args := naabuIPVersionArgs(v6); args != nil {
+		t.Errorf("naabu -ip-version 4: %v", args)
+	}
+}
+
+func TestIPVersionDial(t *testing.T) {
+	var network string
+	dial := ipVersionDial(func(_ context.Context, n, _ string) (net.Conn, error) {
+		network = n
+		return nil, nil
+	})
+	for v, want := range map[string]string{ipVersionBoth: "tcp", ipVersion4: "tcp4", ipVersion6: "tcp6"} {
+		setIPVersion(t, v)
+		dial(context.Background(), "tcp", "[2001:db8::1]:443")
+		if network != want {
+			t.Errorf("-ip-version %s dials %s, want %s", v, network, want)
+		}
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	for _, c := range []struct{ host, port, want string }{
+		{"www.example.com", "", "www.example.com"},
+		{"192.0.2.1", "8080", "192.0.2.1:8080"},
+		{"2001:db8::1", "", "[2001:db8::1]"},
+		{"2001:db8::1", "8443", "[2001:db8::1]:8443"},
+		{"fe80::1%eth0", "80", "[fe80::1%25eth0]:80"},
+	} {
+		if got := urlHost(c.host, c.port); got != c.want {
+			t.Errorf("urlHost(%q, %q) = %q, want %q", c.host, c.port, got, c.want)
+		}
+	}
+}
+
+// TestNativeProbeIPv6 probes an IPv6 literal with its port, as -portscan of
+// an IPv6 target feeds it
+func TestNativeProbeIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback")
+	}
+	s := httptest.NewUnstartedServer(appServer("Six"))
+	s.Listener.Close()
+	s.Listener = ln
+	s.Start()
+	defer s.Close()
+	setNativeProbe(t, 0)
+	setIPVersion(t, ipVersionBoth)
+
+	name := ln.Addr().String() // [::1]:port
+	got := nativeProbeName(context.Background(), name)
+	if len(got) != 1 {
+		t.Fatalf("%d answers", len(got))
+	}
+	h := got[0]
+	if h.Url != "http://"+name || h.Title != "Six" || h.Host != "::1" || h.Input != name {
+		t.Errorf("answer %+v", h)
+	}
+	if res := httpxResult(h, "::1"); res.IP != "::1" {
+		t.Errorf("result IP %q", res.IP)
+	}
+	if rangeHost(name) != "::1" {
+		t.Errorf("rangeHost(%q) = %q", name, rangeHost(name))
+	}
+}
+
+func TestExcludeIPv6(t *testing.T) {
+	oldExclusions, oldMixed := ipExclusions, probeMixedIPs
+	t.Cleanup(func() {
+		ipExclusions, probeMixedIPs = oldExclusions, oldMixed
+		resetIPExclusions()
+	})
+	resetIPExclusions()
+	ipExclusions, probeMixedIPs = nil, false
+	for _, s := range []string{"2001:db8:aa::/48", "2001:DB8::1", "::ffff:198.51.100.7", "192.0.2.0/24"} {
+		p, err := parseExcludeIP(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipExclusions = append(ipExclusions, p)
+	}
+	want := []string{"2001:db8:aa::/48", "2001:db8::1/128", "198.51.100.7/32", "192.0.2.0/24"}
+	for i, p := range ipExclusions {
+		if p.String() != want[i] {
+			t.Errorf("range %d is %s, want %s", i, p, want[i])
+		}
+	}
+	for _, c := range []struct {
+		addrs []string
+		hit   string
+	}{
+		{[]string{"2001:db8:aa:1::5"}, "2001:db8:aa:1::5"},
+		{[]string{"2001:db8::1"}, "2001:db8::1"},
+		{[]string{"::ffff:198.51.100.7"}, "::ffff:198.51.100.7"},
+		{[]string{"2001:db8::2", "2001:db8:ab::1"}, ""},
+		// Dual-stack with one family inside: excluded as mixed
+		{[]string{"203.0.113.1", "2001:db8:aa::1"}, "2001:db8:aa::1"},
+	} {
+		reason, hit := excludeAddrs("www.example.com", c.addrs)
+		if hit != c.hit || (hit == "") != (reason == "") {
+			t.Errorf("%v: hit %q, reason %q", c.addrs, hit, reason)
+		}
+	}
+	res := Result{Subdomain: "v6.example.com", IP: "2001:db8:aa::9"}
+	if !excludedAfterProbe(&res) || !strings.Contains(res.ProbeSkipped, "2001:db8:aa::/48") {
+		t.Errorf("probed over an excluded IPv6 address: %q", res.ProbeSkipped)
+	}
+	// www.example.com once, however many of its addresses are in it
+	if n := ipExclusionCounts()["2001:db8:aa::/48"]; n != 2 {
+		t.Errorf("%d items counted against 2001:db8:aa::/48, want 2", n)
+	}
+}
+
+func TestIPTargetIPv6(t *testing.T) {
+	oldMax := maxIPs
+	t.Cleanup(func() { maxIPs = oldMax })
+	maxIPs = 256
+	p, ok := parseIPTarget("2001:db8::5/126")
+	if !ok || p.String() != "2001:db8::4/126" {
+		t.Fatalf("parseIPTarget: %v %v", p, ok)
+	}
+	// IPv6 ranges have no network or broadcast address to leave out
+	if got := rangeAddrs(p); !slices.Equal(got, []string{"2001:db8::4", "2001:db8::5", "2001:db8::6", "2001:db8::7"}) {
+		t.Errorf("rangeAddrs = %v", got)
+	}
+	if got := rangeAddrs(netip.MustParsePrefix("192.0.2.0/30")); !slices.Equal(got, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("IPv4 rangeAddrs = %v", got)
+	}
+	if p, ok := parseIPTarget("::ffff:192.0.2.1"); !ok || p.String() != "192.0.2.1/32" {
+		t.Errorf("mapped address target: %v", p)
+	}
+	if !inTarget("2001:db8::6", "2001:db8::4/126") || inTarget("2001:db8::8", "2001:db8::4/126") {
+		t.Error("inTarget is wrong for an IPv6 range")
+	}
+	if rangeHost("[2001:db8::6]:443") != "2001:db8::6" || rangeHost("2001:db8::6") != "2001:db8::6" {
+		t.Error("rangeHost garbles IPv6 inputs")
+	}
+	if err := validateIPTarget("2001:db8::/120"); err != nil {
+		t.Errorf("a /120 of 256 addresses: %v", err)
+	}
+	if err := validateIPTarget("2001:db8::/64"); err == nil {
+		t.Error("accepted a /64")
+	}
+}
+
+// fixtureTransport answers every request with body
+type fixtureTransport string
+
+func (f fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(string(f))),
+		Request:    req,
+	}, nil
+}
+
+// TestAnnouncedPrefixesIPv6 keeps the IPv6 prefixes an ASN announces, or
+// only the -ip-version family's
+func TestAnnouncedPrefixesIPv6(t *testing.T) {
+	old := sourceHTTP
+	t.Cleanup(func() { sourceHTTP = old })
+	sourceHTTP = &http.Client{Transport: fixtureTransport(`{"data":{"prefixes":[
+		{"prefix":"192.0.2.0/24"},{"prefix":"2001:db8:100::/40"},{"prefix":"2001:DB8:200::1/48"},{"prefix":"bogus"}]}}`)}
+	for _, c := range []struct {
+		version string
+		want    []string
+	}{
+		{ipVersionBoth, []string{"192.0.2.0/24", "2001:db8:100::/40", "2001:db8:200::/48"}},
+		{ipVersion6, []string{"2001:db8:100::/40", "2001:db8:200::/48"}},
+		{ipVersion4, []string{"192.0.2.0/24"}},
+	} {
+		setIPVersion(t, c.version)
+		prefixes, err := announcedPrefixes(context.Background(), 64500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range prefixes {
+			got = append(got, p.String())
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("-ip-version %s: %v, want %v", c.version, got, c.want)
+		}
+	}
+}
+
+// TestCensysServicesFamily looks up the Censys services of an address of
+// the -ip-version family only
+func TestCensysServicesFamily(t *testing.T) {
+	services := []CensysService{{Port: 443}}
+	censysHostCache.Store("2001:db8::7", services)
+	t.Cleanup(func() { censysHostCache.Delete("2001:db8::7") })
+
+	setIPVersion(t, ipVersion6)
+	if got := censysServices(context.Background(), "2001:db8::7"); len(got) != 1 || got[0].Port != 443 {
+		t.Errorf("IPv6 address: %v", got)
+	}
+	setIPVersion(t, ipVersion4)
+	if got := censysServices(context.Background(), "2001:db8::7"); got != nil {
+		t.Errorf("IPv6 address looked up with -ip-version 4: %v", got)
+	}
+}
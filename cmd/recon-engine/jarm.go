@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jarmHandshakes is how many TLS handshakes one JARM fingerprint takes, each
+// counted against -rate-limit
+const jarmHandshakes = 10
+
+// jarmHandshakeTimeout is tlsx's timeout per handshake, in seconds; a host
+// that answers none of them still costs ten times this
+const jarmHandshakeTimeout = 3
+
+// jarmEmpty is the fingerprint of a host that answered no handshake
+var jarmEmpty = strings.Repeat("0", 62)
+
+// tlsxResult is the part of a tlsx -json line computeJarm reads
+type tlsxResult struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	JarmHash string `json:"jarm_hash"`
+}
+
+// tlsxArgs builds the tlsx command line fingerprinting hostport
+func tlsxArgs(hostport string) []string {
+	return []string{"-u", hostport, "-jarm", "-json", "-silent", "-timeout", strconv.Itoa(jarmHandshakeTimeout)}
+}
+
+// computeJarm sets res.Jarm to the JARM fingerprint of a live HTTPS host,
+// computed by tlsx. Hosts behind a CDN are skipped: their fingerprint is the
+// CDN's. The handshakes go out directly, -proxy only covers HTTP.
+func computeJarm(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || res.CDN != "" {
+		return
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	for i := 0; i < jarmHandshakes; i++ {
+		if nativeLimiter.Wait(ctx) != nil {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, (jarmHandshakes*jarmHandshakeTimeout+5)*time.Second)
+	defer cancel()
+	cmd := toolCommand(ctx, toolPath("tlsx"), tlsxArgs(net.JoinHostPort(u.Hostname(), port))...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := startTool(cmd); err != nil {
+		reportToolError("tlsx", "jarm", eventStartFailed, err)
+		return
+	}
+	if err := waitTool(cmd); err != nil && ctx.Err() == nil {
+		reportToolError("tlsx", "jarm", "", err)
+	}
+	lines := newLineReader(&out, "tlsx")
+	for lines.Next() {
+		var r tlsxResult
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			rejectedLine("tlsx", "jarm", eventParseFailed, err)
+			continue
+		}
+		if r.JarmHash != "" && !isHex(r.JarmHash, len(jarmEmpty)) {
+			rejectedLine("tlsx", "jarm", eventInvalid, fmt.Errorf("%s: malformed JARM hash %q", u.Host, r.JarmHash))
+			continue
+		}
+		if r.JarmHash != "" && r.JarmHash != jarmEmpty {
+			res.Jarm = r.JarmHash
+			jarmHosts.Add(r.JarmHash, u.Host)
+		}
+	}
+}
+
+// jarmIndex collects the hosts sharing each JARM fingerprint during the run
+type jarmIndex struct {
+	mu    sync.Mutex
+	hosts map[string]map[string]bool
+}
+
+var jarmHosts = &jarmIndex{hosts: make(map[string]map[string]bool)}
+
+// Add records that host answered with fingerprint jarm
+func (x *jarmIndex) Add(jarm, host string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	set, ok := x.hosts[jarm]
+	if !ok {
+		set = make(map[string]bool)
+		x.hosts[jarm] = set
+	}
+	set[host] = true
+}
+
+// JarmCluster is a JARM fingerprint shared by several hosts, as reported in
+// the run summary
+type JarmCluster struct {
+	Jarm  string   `json:"jarm"`
+	Count int      `json:"count"`
+	Hosts []string `json:"hosts"`
+}
+
+// Clusters returns every fingerprint seen on more than one host, most
+// common first
+func (x *jarmIndex) Clusters() []JarmCluster {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var out []JarmCluster
+	for jarm, set := range x.hosts {
+		if len(set) < 2 {
+			continue
+		}
+		hosts := make([]string, 0, len(set))
+		for h := range set {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+		out = append(out, JarmCluster{Jarm: jarm, Count: len(set), Hosts: hosts})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Jarm < out[j].Jarm
+	})
+	return out
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jiraBuffer bounds the issues waiting for the writer goroutine; issues
+	// beyond it are reported as failed rather than holding up output
+	jiraBuffer = 1000
+	// jiraAttempts is how often a Jira request is tried before the issue is
+	// given up on
+	jiraAttempts = 4
+)
+
+// jiraHTTP talks to -jira-url. Like the webhook client it bypasses the rate
+// limiter, proxy and custom headers meant for the target.
+var jiraHTTP = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	jiraQueue chan jiraIssue
+	jiraDone  sync.WaitGroup
+	jiraCache map[string]string // dedupe key -> issue key
+)
+
+// jiraIssue is one finding to file
+type jiraIssue struct {
+	key         string // dedupe key, also set as the recon-<key> label
+	summary     string
+	description string
+	labels      []string
+}
+
+// configureJira validates the -jira-* flags and loads the dedupe cache.
+// Called once after flag parsing; Jira itself is first contacted when an
+// issue is filed.
+func configureJira() error {
+	if jiraURL == "" && jiraProject == "" {
+		return nil
+	}
+	if jiraURL == "" || jiraProject == "" {
+		return fmt.Errorf("-jira-url and -jira-project must be set together")
+	}
+	u, err := url.Parse(jiraURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported Jira scheme %q (want http or https)", u.Scheme)
+	}
+	jiraURL = strings.TrimSuffix(jiraURL, "/")
+	if secret("JIRA_API_TOKEN") == "" {
+		return fmt.Errorf("JIRA_API_TOKEN is not set")
+	}
+	if _, ok := parseSeverity(jiraMinSeverity); !ok {
+		return fmt.Errorf("-jira-min-severity must be critical, high, medium, low or info, got %q", jiraMinSeverity)
+	}
+
+	if jiraCachePath == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		jiraCachePath = filepath.Join(dir, "recon-engine", "jira-cache.json")
+	}
+	jiraCache = make(map[string]string)
+	b, err := os.ReadFile(jiraCachePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &jiraCache); err != nil {
+			return fmt.Errorf("%s: %w", jiraCachePath, err)
+		}
+	}
+
+	jiraQueue = make(chan jiraIssue, jiraBuffer)
+	jiraDone.Add(1)
+	go jiraWriter()
+	return nil
+}
+
+// queueJira queues an issue for each finding of res at or above
+// -jira-min-severity, unless it is a known host that did not change. It
+// never waits for Jira; filing failures are reported
+// by jiraWriter.
+func queueJira(res Result) error {
+	if knownQuiet(res) || parkedQuiet(res) {
+		return nil
+	}
+	var err error
+	min, _ := parseSeverity(jiraMinSeverity)
+	for _, v := range res.Vulnerabilities {
+		sev := strings.ToLower(ddSeverity(v))
+		if v.Severity.rank() < min.rank() {
+			continue
+		}
+		issue := newJiraIssue(res, v, sev)
+		select {
+		case jiraQueue <- issue:
+		default:
+			stats.Add("jira.failed", 1)
+			err = fmt.Errorf("queue full, no issue filed for %s", issue.summary)
+		}
+	}
+	return err
+}
+
+// newJiraIssue describes one finding: where it is, what the host runs, the
+// evidence and the run that found it
+func newJiraIssue(res Result, v Finding, sev string) jiraIssue {
+	host := res.Subdomain
+	if host == "" {
+		host = res.IP
+	}
+	if res.Port != 0 && res.Port != 80 && res.Port != 443 {
+		host += ":" + strconv.Itoa(res.Port)
+	}
+	id, title := v.ID, v.Name()
+	key := ddUniqueID(id, res.RootDomain+"|"+host)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Subdomain: %s\n", host)
+	if res.URL != "" {
+		fmt.Fprintf(&b, "URL: %s\n", res.URL)
+	}
+	fmt.Fprintf(&b, "Severity: %s\n", sev)
+	if len(res.TechStack) > 0 {
+		fmt.Fprintf(&b, "Tech stack: %s\n", strings.Join(res.TechStack, ", "))
+	}
+	b.WriteString("\n" + ddDescription(res, v))
+
+	labels := []string{"recon-engine", "recon-" + key}
+	if res.RootDomain != "" {
+		labels = append(labels, res.RootDomain)
+	}
+	// Jira labels cannot hold spaces
+	if res.Owner != "" {
+		labels = append(labels, "owner-"+strings.Join(strings.Fields(res.Owner), "-"))
+	}
+	return jiraIssue{
+		key:         key,
+		summary:     fmt.Sprintf("%s on %s", title, host),
+		description: b.String(),
+		labels:      labels,
+	}
+}
+
+// jiraWriter files queued issues one at a time. An issue already in the
+// cache, or found in Jira by its recon-<key> label, is not filed again.
+func jiraWriter() {
+	defer jiraDone.Done()
+	for issue := range jiraQueue {
+		if _, ok := jiraCache[issue.key]; ok {
+			stats.Add("jira.duplicates", 1)
+			continue
+		}
+		existing, err := jiraRetry(func() (string, error) { return searchJira(issue.key) })
+		if err == nil && existing != "" {
+			stats.Add("jira.duplicates", 1)
+			jiraCache[issue.key] = existing
+			saveJiraCache()
+			continue
+		}
+		if err == nil {
+			existing, err = jiraRetry(func() (string, error) { return createJira(issue) })
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Jira error, no issue filed for %s: %v\n", issue.summary, err)
+			stats.Add("jira.failed", 1)
+			reportToolError("jira", "output", "", err)
+			continue
+		}
+		stats.Add("jira.created", 1)
+		jiraCache[issue.key] = existing
+		saveJiraCache()
+	}
+}
+
+// jiraStatusError is a Jira response other than success
+type jiraStatusError struct {
+	status int
+	body   string
+}
+
+func (e *jiraStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.status, e.body)
+}
+
+// jiraRetry runs fn up to jiraAttempts times, backing off between tries.
+// Client errors other than 429 are not retried.
+func jiraRetry(fn func() (string, error)) (string, error) {
+	backoff := time.Second
+	var out string
+	var err error
+	for attempt := 1; attempt <= jiraAttempts; attempt++ {
+		if out, err = fn(); err == nil {
+			return out, nil
+		}
+		var se *jiraStatusError
+		if errors.As(err, &se) && se.status < 500 && se.status != http.StatusTooManyRequests {
+			return "", err
+		}
+		if attempt < jiraAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return "", err
+}
+
+// searchJira returns the key of an issue in -jira-project labelled
+// recon-<key>, or "" when there is none. Jira Cloud has replaced the v2
+// search with /search/jql, which is tried when the old one is gone.
+func searchJira(key string) (string, error) {
+	jql := fmt.Sprintf("project = %q AND labels = %q", jiraProject, "recon-"+key)
+	q := url.Values{"jql": {jql}, "maxResults": {"1"}, "fields": {"key"}}.Encode()
+	var found struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	err := jiraDo(http.MethodGet, "/rest/api/2/search?"+q, nil, &found)
+	var se *jiraStatusError
+	if errors.As(err, &se) && (se.status == http.StatusGone || se.status == http.StatusNotFound) {
+		err = jiraDo(http.MethodGet, "/rest/api/3/search/jql?"+q, nil, &found)
+	}
+	if err != nil || len(found.Issues) == 0 {
+		return "", err
+	}
+	return found.Issues[0].Key, nil
+}
+
+// createJira files issue and returns its key
+func createJira(issue jiraIssue) (string, error) {
+	body := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": jiraProject},
+			"issuetype":   map[string]string{"name": jiraIssueType},
+			"summary":     truncate(issue.summary, 250),
+			"description": issue.description,
+			"labels":      issue.labels,
+		},
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := jiraDo(http.MethodPost, "/rest/api/2/issue", body, &created); err != nil {
+		return "", err
+	}
+	return created.Key, nil
+}
+
+// jiraDo sends a request to -jira-url and decodes the JSON answer into out.
+// With JIRA_EMAIL set the token is sent as Jira Cloud basic auth, otherwise
+// as a Server/Data Center personal access token.
+func jiraDo(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, jiraURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token := secret("JIRA_API_TOKEN")
+	if email := os.Getenv("JIRA_EMAIL"); email != "" {
+		req.SetBasicAuth(email, token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := jiraHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &jiraStatusError{status: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func saveJiraCache() {
+	if err := os.MkdirAll(filepath.Dir(jiraCachePath), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing Jira cache: %v\n", err)
+		return
+	}
+	b, err := json.Marshal(jiraCache)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(jiraCachePath, b, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing Jira cache: %v\n", err)
+	}
+}
+
+// closeJira waits for queued issues to be filed or given up on
+func closeJira() {
+	if jiraQueue == nil {
+		return
+	}
+	close(jiraQueue)
+	jiraDone.Wait()
+	jiraQueue = nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// junitTimestamp is the xs:dateTime form JUnit consumers expect, without a
+// zone
+const junitTimestamp = "2006-01-02T15:04:05"
+
+type junitSuites struct {
+	XMLName   xml.Name     `xml:"testsuites"`
+	Name      string       `xml:"name,attr"`
+	Tests     int          `xml:"tests,attr"`
+	Failures  int          `xml:"failures,attr"`
+	Errors    int          `xml:"errors,attr"`
+	Time      string       `xml:"time,attr"`
+	Timestamp string       `xml:"timestamp,attr"`
+	Suites    []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut *junitText    `xml:"system-out,omitempty"`
+}
+
+// junitText is element text kept readable as CDATA
+type junitText struct {
+	Text string `xml:",cdata"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// junitEncoder collects results and writes them as one JUnit document when
+// the run ends: a suite per root domain and a testcase per live host, or
+// per record with findings. Hosts with findings above info or a
+// -junit-fail-flags flag fail.
+type junitEncoder struct {
+	w       io.Writer
+	results []Result
+}
+
+// Encode buffers results; anything else, such as event records, is written
+// as JSON straight away, as the other formats do
+func (e *junitEncoder) Encode(v interface{}) error {
+	res, ok := v.(Result)
+	if !ok {
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	if res.StatusCode > 0 || len(res.Vulnerabilities) > 0 {
+		e.results = append(e.results, res)
+	}
+	return nil
+}
+
+// Flush writes the document
+func (e *junitEncoder) Flush() error {
+	elapsed := stats.Elapsed()
+	start := time.Now().Add(-elapsed).Format(junitTimestamp)
+	doc := junitSuites{Name: "recon-engine", Time: junitSeconds(elapsed.Seconds()), Timestamp: start}
+
+	byDomain := make(map[string][]Result)
+	var domains []string
+	for _, res := range e.results {
+		if _, ok := byDomain[res.RootDomain]; !ok {
+			domains = append(domains, res.RootDomain)
+		}
+		byDomain[res.RootDomain] = append(byDomain[res.RootDomain], res)
+	}
+	sort.Strings(domains)
+
+	failFlags := splitList(junitFailFlags)
+	for _, domain := range domains {
+		suite := junitSuite{
+			Name:      domain,
+			Time:      junitSeconds(elapsed.Seconds()),
+			Timestamp: start,
+			Properties: []junitProperty{
+				{Name: "run_id", Value: runID},
+				{Name: "engine_version", Value: version},
+			},
+		}
+		for _, res := range byDomain[domain] {
+			c := junitTestcase(res, failFlags)
+			suite.Tests++
+			if c.Failure != nil {
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Suites = append(doc.Suites, suite)
+	}
+
+	if _, err := io.WriteString(e.w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(e.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+// junitTestcase maps one result to a testcase
+func junitTestcase(res Result, failFlags []string) junitCase {
+	name := res.Subdomain
+	if name == "" {
+		name = res.IP
+	}
+	switch {
+	case res.Port != 0 && res.Port != 80 && res.Port != 443:
+		name += ":" + strconv.Itoa(res.Port)
+	case res.StatusCode == 0 && res.Source != "":
+		name += " (" + res.Source + ")"
+	}
+	secs := res.ResponseTimeMs / 1000
+	if t, ok := res.Timings[timingEnrich]; ok {
+		secs += t
+	}
+	c := junitCase{Name: name, Classname: res.RootDomain, Time: junitSeconds(secs)}
+
+	var out []string
+	if res.URL != "" {
+		out = append(out, "url: "+res.URL)
+	}
+	if res.StatusCode > 0 {
+		out = append(out, "status: "+strconv.Itoa(res.StatusCode))
+	}
+	if res.Title != "" {
+		out = append(out, "title: "+res.Title)
+	}
+	if len(res.TechStack) > 0 {
+		out = append(out, "tech: "+strings.Join(res.TechStack, ", "))
+	}
+	if res.IP != "" {
+		out = append(out, "ip: "+res.IP)
+	}
+	if len(out) > 0 {
+		c.SystemOut = &junitText{Text: strings.Join(out, "\n")}
+	}
+
+	var details []string
+	findings := 0
+	for _, v := range res.Vulnerabilities {
+		if v.Severity == severityInfo {
+			continue
+		}
+		findings++
+		line := fmt.Sprintf("[%s] %s", v.Severity, v.ID)
+		if v.Title != "" {
+			line += ": " + v.Title
+		}
+		details = append(details, line)
+	}
+	var flagged []string
+	for _, f := range res.Flags {
+		if contains(failFlags, f) {
+			flagged = append(flagged, f)
+		}
+	}
+	if findings == 0 && len(flagged) == 0 {
+		return c
+	}
+
+	var msg []string
+	if findings > 0 {
+		msg = append(msg, fmt.Sprintf("%d findings", findings))
+	}
+	if len(flagged) > 0 {
+		msg = append(msg, "flagged "+strings.Join(flagged, ", "))
+		details = append(details, "flags: "+strings.Join(flagged, ", "))
+	}
+	c.Failure = &junitFailure{Message: strings.Join(msg, "; "), Type: "recon-finding", Text: strings.Join(details, "\n")}
+	return c
+}
+
+func junitSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// TestJUnitDocument writes a run and reads it back as a CI system would
+func TestJUnitDocument(t *testing.T) {
+	oldFlags := junitFailFlags
+	t.Cleanup(func() { junitFailFlags = oldFlags })
+	junitFailFlags = "admin-panel,dir-listing"
+
+	var buf bytes.Buffer
+	enc := &junitEncoder{w: &buf}
+	for _, v := range []interface{}{
+		Result{Subdomain: "www.example.com", RootDomain: "example.com", URL: "https://www.example.com", StatusCode: 200, Title: "Home & <Away>"},
+		Result{Subdomain: "admin.example.com", RootDomain: "example.com", URL: "https://admin.example.com:8443", Port: 8443, StatusCode: 200, Flags: []string{"admin-panel", "login-page"}},
+		Result{Subdomain: "api.example.org", RootDomain: "example.org", StatusCode: 200, Vulnerabilities: []Finding{
+			newFinding("cors", "cors-reflected-origin", "high", confidenceConfirmed, "Reflected origin", nil),
+		}},
+		Result{Subdomain: "info.example.org", RootDomain: "example.org", StatusCode: 200, Vulnerabilities: []Finding{
+			newFinding("headers", "missing-hsts", "info", confidenceConfirmed, "No HSTS", nil),
+		}},
+		// Dead hosts are not testcases
+		Result{Subdomain: "old.example.com", RootDomain: "example.com"},
+	} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Error("no XML declaration")
+	}
+
+	var doc junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if doc.Tests != 4 || doc.Failures != 2 {
+		t.Errorf("%d tests, %d failures, want 4 and 2", doc.Tests, doc.Failures)
+	}
+	if len(doc.Suites) != 2 || doc.Suites[0].Name != "example.com" || doc.Suites[1].Name != "example.org" {
+		t.Fatalf("suites %+v", doc.Suites)
+	}
+
+	cases := make(map[string]junitCase)
+	for _, s := range doc.Suites {
+		for _, c := range s.Cases {
+			cases[c.Name] = c
+		}
+	}
+	if c := cases["www.example.com"]; c.Failure != nil || c.SystemOut == nil || !strings.Contains(c.SystemOut.Text, "title: Home & <Away>") {
+		t.Errorf("www: %+v", c)
+	}
+	if c, ok := cases["admin.example.com:8443"]; !ok || c.Failure == nil || c.Failure.Message != "flagged admin-panel" {
+		t.Errorf("admin: %+v", c)
+	}
+	if c := cases["api.example.org"]; c.Failure == nil || !strings.Contains(c.Failure.Text, "[high] cors-reflected-origin") {
+		t.Errorf("api: %+v", c)
+	}
+	if c := cases["info.example.org"]; c.Failure != nil {
+		t.Errorf("an info finding failed the testcase: %+v", c.Failure)
+	}
+}
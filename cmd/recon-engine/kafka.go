@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaBuffer bounds the messages handed to the producer but not yet
+// acknowledged; publishing blocks once it is full
+const kafkaBuffer = 1000
+
+var (
+	kafkaWriter   *kafka.Writer
+	kafkaInFlight chan struct{} // one slot per unacknowledged message
+)
+
+// kafkaSecurity builds the TLS and SASL settings from the environment:
+//
+//	KAFKA_TLS=1                  connect over TLS
+//	KAFKA_TLS_CA=file            PEM bundle to verify the brokers with
+//	KAFKA_TLS_INSECURE=1         skip certificate verification
+//	KAFKA_SASL_MECHANISM=m       plain, scram-sha-256 or scram-sha-512
+//	KAFKA_SASL_USERNAME, KAFKA_SASL_PASSWORD
+func kafkaSecurity() (*tls.Config, sasl.Mechanism, error) {
+	var tlsCfg *tls.Config
+	if envBool("KAFKA_TLS") || os.Getenv("KAFKA_TLS_CA") != "" {
+		tlsCfg = &tls.Config{InsecureSkipVerify: envBool("KAFKA_TLS_INSECURE")}
+		if ca := os.Getenv("KAFKA_TLS_CA"); ca != "" {
+			pem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, nil, err
+			}
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, nil, fmt.Errorf("%s: no certificates found", ca)
+			}
+		}
+	}
+
+	user, pass := os.Getenv("KAFKA_SASL_USERNAME"), secret("KAFKA_SASL_PASSWORD")
+	var mech sasl.Mechanism
+	switch m := strings.ToLower(os.Getenv("KAFKA_SASL_MECHANISM")); m {
+	case "":
+		if user != "" {
+			mech = plain.Mechanism{Username: user, Password: pass}
+		}
+	case "plain":
+		mech = plain.Mechanism{Username: user, Password: pass}
+	case "scram-sha-256", "scram-sha-512":
+		algo := scram.SHA256
+		if m == "scram-sha-512" {
+			algo = scram.SHA512
+		}
+		var err error
+		if mech, err = scram.Mechanism(algo, user, pass); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported KAFKA_SASL_MECHANISM %q (want plain, scram-sha-256 or scram-sha-512)", m)
+	}
+	return tlsCfg, mech, nil
+}
+
+func envBool(name string) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// configureKafka validates -kafka-brokers/-kafka-topic and checks that the
+// topic is reachable before the scan starts. Called once after flag parsing.
+func configureKafka() error {
+	if kafkaBrokers == "" && kafkaTopic == "" {
+		return nil
+	}
+	brokers := splitList(kafkaBrokers)
+	if len(brokers) == 0 || kafkaTopic == "" {
+		return fmt.Errorf("-kafka-brokers and -kafka-topic must be set together")
+	}
+	tlsCfg, mech, err := kafkaSecurity()
+	if err != nil {
+		return err
+	}
+
+	// Any one broker can answer the metadata request
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second, TLS: tlsCfg, SASLMechanism: mech}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var lastErr error
+	for _, b := range brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", b)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_, err = conn.ReadPartitions(kafkaTopic)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("topic %q: %w", kafkaTopic, err)
+		}
+		lastErr = nil
+		break
+	}
+	if lastErr != nil {
+		return fmt.Errorf("no broker reachable: %w", lastErr)
+	}
+
+	kafkaInFlight = make(chan struct{}, kafkaBuffer)
+	kafkaWriter = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        kafkaTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Async:        true,
+		BatchTimeout: 100 * time.Millisecond,
+		// Transient errors (leader elections, timeouts) are retried with
+		// backoff before a batch is given up on
+		MaxAttempts:     10,
+		WriteBackoffMin: 100 * time.Millisecond,
+		WriteBackoffMax: 5 * time.Second,
+		Transport:       &kafka.Transport{TLS: tlsCfg, SASL: mech},
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Kafka delivery error (%d records): %v\n", len(messages), err)
+				reportToolError("kafka", "output", "", err)
+			}
+			for range messages {
+				<-kafkaInFlight
+			}
+		},
+	}
+	return nil
+}
+
+// publishKafka queues res for -kafka-topic, keyed by subdomain so every
+// record for a host lands on the same partition
+func publishKafka(res Result) error {
+	b, err := json.Marshal(projectResult(res))
+	if err != nil {
+		return err
+	}
+	kafkaInFlight <- struct{}{}
+	// Not the scan context: records emitted while shutting down still go out
+	err = kafkaWriter.WriteMessages(context.Background(), kafka.Message{Key: []byte(res.Subdomain), Value: b})
+	if err != nil {
+		<-kafkaInFlight
+	}
+	return err
+}
+
+// closeKafka flushes queued records and waits for their delivery
+func closeKafka() {
+	if kafkaWriter == nil {
+		return
+	}
+	if err := kafkaWriter.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Kafka error: %v\n", err)
+	}
+	kafkaWriter = nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// knownNames are the -known hosts, lowercased
+var knownNames map[string]bool
+
+// configureKnown loads -known. Called once after flag parsing.
+func configureKnown() error {
+	if knownPath == "" {
+		if hideKnown {
+			return fmt.Errorf("-hide-known needs -known")
+		}
+		return nil
+	}
+	f, err := os.Open(knownPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	knownNames, err = readKnown(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", knownPath, err)
+	}
+	return nil
+}
+
+// readKnown reads a -known file: one host per line, with blank lines and
+// # comments ignored
+func readKnown(r io.Reader) (map[string]bool, error) {
+	names := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if name := knownName(line); name != "" {
+			names[name] = true
+		}
+	}
+	return names, sc.Err()
+}
+
+func knownName(s string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+}
+
+// markKnown sets res.Known when its host is in -known
+func markKnown(res *Result) {
+	if knownNames == nil || res.Subdomain == "" {
+		return
+	}
+	res.Known = knownNames[knownName(res.Subdomain)]
+	if res.Known {
+		stats.Add("results.known", 1)
+	}
+}
+
+// knownQuiet reports whether res is a known host with nothing to say:
+// notifications and the CI gates leave it out, and -hide-known the output.
+// A known host that changed or went away since the -diff or -state
+// baseline still speaks up.
+func knownQuiet(res Result) bool {
+	return res.Known && res.ChangeType != changeChanged && res.ChangeType != changeRemoved
+}
+
+// runKnownCommand implements the known subcommand
+func runKnownCommand(args []string) {
+	fs := flag.NewFlagSet("known", flag.ExitOnError)
+	file := fs.String("file", "known.txt", "The -known file to add to")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s known add [flags] <results-file>...\n\nAppends the live hosts of scan output (NDJSON or a JSON array, - for\nstdin) to a -known file, leaving out those it already lists.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "add" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil && !os.IsNotExist(err) {
+		fatalError("Failed to open "+*file, err)
+	}
+	known, err := readKnown(bytes.NewReader(data))
+	if err != nil {
+		fatalError("Failed to read "+*file, err)
+	}
+
+	var added []string
+	for _, path := range fs.Args() {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				fatalError("Failed to open results", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		results, err := readResults(r)
+		if err != nil {
+			fatalError("Failed to read "+path, err)
+		}
+		for _, res := range results {
+			name := knownName(res.Subdomain)
+			if name == "" || res.StatusCode == 0 || res.ChangeType == changeRemoved || known[name] {
+				continue
+			}
+			known[name] = true
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+
+	out, err := os.OpenFile(*file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		fatalError("Failed to open "+*file, err)
+	}
+	w := bufio.NewWriter(out)
+	// A hand-edited file may not end its last line
+	if len(added) > 0 && len(data) > 0 && data[len(data)-1] != '\n' {
+		w.WriteByte('\n')
+	}
+	for _, name := range added {
+		fmt.Fprintln(w, name)
+	}
+	if err := w.Flush(); err != nil {
+		fatalError("Failed to write "+*file, err)
+	}
+	if err := out.Close(); err != nil {
+		fatalError("Failed to write "+*file, err)
+	}
+	fmt.Fprintf(os.Stderr, "Added %d hosts to %s (%d known)\n", len(added), *file, len(known))
+}
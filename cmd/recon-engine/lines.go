@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// longLineWarn is the line length above which lineReader warns. bufio.Scanner
+// gave up on lines this long and silently dropped everything after them.
+const longLineWarn = 1024 * 1024
+
+// lineReader reads newline-terminated tool output without a line length
+// limit, replacing invalid UTF-8. It follows bufio.Scanner's
+// Next/Bytes/Err shape.
+type lineReader struct {
+	br   *bufio.Reader
+	tool string
+	line []byte
+	err  error
+	done bool
+}
+
+func newLineReader(r io.Reader, tool string) *lineReader {
+	return &lineReader{br: bufio.NewReaderSize(r, 64*1024), tool: tool}
+}
+
+// Next advances to the next line, returning false at EOF or on error
+func (l *lineReader) Next() bool {
+	if l.done {
+		return false
+	}
+	line, err := l.br.ReadBytes('\n')
+	if err != nil {
+		l.done = true
+		if err != io.EOF {
+			l.err = err
+		}
+		if len(line) == 0 {
+			return false
+		}
+	}
+	l.line = bytes.TrimRight(line, "\r\n")
+	var repaired bool
+	if l.line, repaired = validUTF8(l.line); repaired {
+		repairedLine(l.tool)
+	}
+	if len(l.line) > longLineWarn {
+		host := "unknown host"
+		if m := lineHost.FindSubmatch(l.line[:min(len(l.line), 64*1024)]); m != nil {
+			host = string(m[1])
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s output line of %d bytes for %s\n", l.tool, len(l.line), host)
+	}
+	return true
+}
+
+// Bytes returns the current line without its line ending. It is only valid
+// until the next call to Next.
+func (l *lineReader) Bytes() []byte { return l.line }
+
+// Text returns the current line as a string
+func (l *lineReader) Text() string { return string(l.line) }
+
+// Err returns the first read error other than io.EOF
+func (l *lineReader) Err() error { return l.err }
+
+// lineHost pulls the host out of a JSON line for the long line warning
+var lineHost = regexp.MustCompile(`"(?:input|host|name)"\s*:\s*"([^"]+)"`)
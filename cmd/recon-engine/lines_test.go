@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestLineReaderLongLine feeds a 2MB line between two short ones: the
+// bufio.Scanner it replaced stopped at the long line and dropped the rest
+func TestLineReaderLongLine(t *testing.T) {
+	long := `{"input":"big.example.com","title":"` + strings.Repeat("a", 2*1024*1024) + `"}`
+	in := "{\"input\":\"a.example.com\"}\n" + long + "\r\n{\"input\":\"b.example.com\"}"
+	lines := newLineReader(strings.NewReader(in), "httpx")
+	var got []string
+	for lines.Next() {
+		got = append(got, lines.Text())
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d lines, want 3", len(got))
+	}
+	if got[1] != long {
+		t.Errorf("long line came back as %d bytes, want %d", len(got[1]), len(long))
+	}
+	if got[2] != `{"input":"b.example.com"}` {
+		t.Errorf("line after the long one is %q", got[2])
+	}
+}
+
+// TestLineReaderRepairsUTF8 replaces invalid sequences instead of passing
+// them on
+func TestLineReaderRepairsUTF8(t *testing.T) {
+	lines := newLineReader(bytes.NewReader([]byte("ok\xff\xfe\n")), "whatweb")
+	if !lines.Next() {
+		t.Fatal("no line")
+	}
+	if got := lines.Text(); got != "ok\uFFFD" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockPollInterval is how often -wait-lock tries a held lock again
+const lockPollInterval = time.Second
+
+// runLockHolder is what a lock file records about the run holding it
+type runLockHolder struct {
+	PID       int    `json:"pid"`
+	Host      string `json:"host"`
+	RunID     string `json:"run_id,omitempty"`
+	Command   string `json:"command"`
+	StartedAt string `json:"started_at"` // when the lock was taken, RFC 3339
+}
+
+// runLock is an advisory lock on a file shared between runs, such as the
+// -state file or an explicit -workdir. It is an OS lock on path, a
+// ".lock" file beside or in it, so it goes away with the process however
+// that ends; the file itself stays and records the holder for the error
+// messages of runs that find it taken.
+type runLock struct {
+	what string // the flag and path it guards, for messages
+	path string
+	f    *os.File
+}
+
+var (
+	// runLocks are the locks this process holds
+	runLocksMu sync.Mutex
+	runLocks   []*runLock
+	// runLockCommand is the command line recorded in the locks
+	runLockCommand string
+)
+
+// errLockHeld is returned by tryLockFile when another process holds the
+// lock
+var errLockHeld = errors.New("lock held")
+
+// runLockPaths returns the locks a run takes: one beside -state and one in
+// an explicit -workdir. The default workdir is the run's own.
+func runLockPaths() []*runLock {
+	var locks []*runLock
+	if statePath != "" {
+		locks = append(locks, &runLock{what: "-state " + statePath, path: statePath + ".lock"})
+	}
+	if workdirFlag != "" {
+		locks = append(locks, &runLock{what: "-workdir " + workdir, path: filepath.Join(workdir, ".recon-engine.lock")})
+	}
+	return locks
+}
+
+// acquireRunLocks takes the run's locks, waiting up to -wait-lock for
+// held ones. On failure none are kept.
+func acquireRunLocks(cmd, target string) error {
+	runLockCommand = strings.TrimSpace(cmd + " " + target)
+	locks := runLockPaths()
+	if workdirFlag != "" {
+		if err := os.MkdirAll(workdir, 0o755); err != nil {
+			return err
+		}
+	}
+	for _, l := range locks {
+		if err := l.acquire(); err != nil {
+			releaseRunLocks()
+			return err
+		}
+		runLocksMu.Lock()
+		runLocks = append(runLocks, l)
+		runLocksMu.Unlock()
+	}
+	return nil
+}
+
+// releaseRunLocks lets go of every lock this process holds. exit calls it,
+// so every way out of a run releases them.
+func releaseRunLocks() {
+	runLocksMu.Lock()
+	locks := runLocks
+	runLocks = nil
+	runLocksMu.Unlock()
+	for i := len(locks) - 1; i >= 0; i-- {
+		locks[i].release()
+	}
+}
+
+// acquire takes the lock, waiting up to -wait-lock while another run holds
+// it and stealing it with -force-lock when its holder is gone
+func (l *runLock) acquire() error {
+	deadline := time.Now().Add(waitLock)
+	announced := false
+	for {
+		err := l.try()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errLockHeld) {
+			return fmt.Errorf("locking %s: %w", l.what, err)
+		}
+		holder, _ := readLockHolder(l.path)
+		if forceLock && holder != nil && holder.gone() {
+			fmt.Fprintf(os.Stderr, "Warning: -force-lock: stealing the lock on %s from %s\n", l.what, holder.describe())
+			if err := l.steal(); err != nil {
+				return fmt.Errorf("stealing the lock on %s: %w", l.what, err)
+			}
+			continue
+		}
+		if waitLock <= 0 || time.Now().After(deadline) {
+			return l.heldError(holder)
+		}
+		if !announced {
+			fmt.Fprintf(os.Stderr, "Waiting up to %s for %s, in use by %s\n", time.Until(deadline).Round(time.Second), l.what, describeHolder(holder))
+			announced = true
+		}
+		time.Sleep(min(lockPollInterval, time.Until(deadline)+time.Millisecond))
+	}
+}
+
+// try takes the lock if it is free and records this process in it
+func (l *runLock) try() error {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := tryLockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+	// A lock stolen between the open and the lock is on a file no longer
+	// at path; take the new one instead
+	if info, err := os.Stat(l.path); err != nil || !sameFile(f, info) {
+		unlockFile(f)
+		f.Close()
+		return errLockHeld
+	}
+	if prev, _ := readLockHolder(l.path); prev != nil && prev.PID != os.Getpid() {
+		fmt.Fprintf(os.Stderr, "Note: %s was left locked by %s, which ended without releasing it; taking it over\n", l.what, prev.describe())
+	}
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(runLockHolder{
+		PID:       os.Getpid(),
+		Host:      host,
+		RunID:     runID,
+		Command:   runLockCommand,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt(append(data, '\n'), 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+// steal replaces a lock file whose holder is gone with a new one. The old
+// file may still be locked by whatever inherited it; that lock no longer
+// guards anything.
+func (l *runLock) steal() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// release clears the holder and unlocks
+func (l *runLock) release() {
+	if l.f == nil {
+		return
+	}
+	l.f.Truncate(0)
+	unlockFile(l.f)
+	l.f.Close()
+	l.f = nil
+}
+
+// heldError explains who holds the lock and what can be done about it
+func (l *runLock) heldError(h *runLockHolder) error {
+	switch {
+	case h == nil:
+		return fmt.Errorf("%s is locked (%s) by a process that did not record itself; if no recon-engine is using it, delete %s", l.what, l.path, l.path)
+	case h.gone():
+		return fmt.Errorf("%s is locked, but %s is no longer running; a process it started may still hold %s. If no recon-engine is using %s, rerun with -force-lock", l.what, h.describe(), l.path, l.what)
+	case waitLock > 0:
+		return fmt.Errorf("%s is still in use by %s after waiting -wait-lock %s", l.what, h.describe(), waitLock)
+	default:
+		return fmt.Errorf("%s is in use by %s; wait for it with -wait-lock, e.g. -wait-lock 10m", l.what, h.describe())
+	}
+}
+
+// readLockHolder reads the holder recorded in the lock file at path, nil
+// when there is none
+func readLockHolder(path string) (*runLockHolder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return nil, err
+	}
+	var h runLockHolder
+	if err := json.Unmarshal(data, &h); err != nil || h.PID <= 0 {
+		return nil, fmt.Errorf("%s does not record a holder", path)
+	}
+	return &h, nil
+}
+
+// gone reports whether the holder is known not to be running: it ran on
+// this host and its PID is not alive. A holder on another host sharing the
+// file system is never taken for gone.
+func (h *runLockHolder) gone() bool {
+	host, _ := os.Hostname()
+	return h.Host == host && !pidAlive(h.PID)
+}
+
+// describe names the holder: PID, host, command and since when
+func (h *runLockHolder) describe() string {
+	s := fmt.Sprintf("PID %d", h.PID)
+	if host, _ := os.Hostname(); h.Host != "" && h.Host != host {
+		s += " on " + h.Host
+	}
+	if h.Command != "" {
+		s += " (recon-engine " + h.Command + ")"
+	}
+	if t, err := time.Parse(time.RFC3339, h.StartedAt); err == nil {
+		s += fmt.Sprintf(", locked since %s (%s ago)", t.Local().Format("2006-01-02 15:04:05 MST"), time.Since(t).Round(time.Second))
+	}
+	return s
+}
+
+func describeHolder(h *runLockHolder) string {
+	if h == nil {
+		return "a process that did not record itself"
+	}
+	return h.describe()
+}
+
+func sameFile(f *os.File, info os.FileInfo) bool {
+	fi, err := f.Stat()
+	return err == nil && os.SameFile(fi, info)
+}
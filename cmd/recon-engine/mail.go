@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimSelectors are the selectors common mail providers publish keys under.
+// DKIM keys cannot be enumerated, so a miss here proves nothing.
+var dkimSelectors = []string{
+	"default", "dkim", "mail", "smtp", "k1", "k2", "s1", "s2",
+	"selector1", "selector2", "google", "mandrill", "mxvault", "zoho",
+	"protonmail", "fm1", "fm2", "fm3",
+}
+
+// MailPosture is what -mail-check found in the root domain's DNS
+type MailPosture struct {
+	SPF           []string `json:"spf,omitempty"`
+	SPFAll        string   `json:"spf_all,omitempty"` // the all mechanism with its qualifier
+	DMARC         []string `json:"dmarc,omitempty"`
+	DMARCCNAME    string   `json:"dmarc_cname,omitempty"`
+	DMARCPolicy   string   `json:"dmarc_policy,omitempty"`
+	DKIMSelectors []string `json:"dkim_selectors,omitempty"`
+}
+
+// lookupTXT returns the TXT records of name through -resolvers. A name
+// without records is not an error; a failed lookup is.
+func lookupTXT(ctx context.Context, name string) ([]string, error) {
+	recs, err := dnsResolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	return recs, err
+}
+
+// withPrefix returns the records starting with prefix, ignoring case
+func withPrefix(recs []string, prefix string) []string {
+	var out []string
+	for _, r := range recs {
+		if len(r) >= len(prefix) && strings.EqualFold(r[:len(prefix)], prefix) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// checkMail gathers SPF, DMARC and DKIM records for domain and returns them
+// as a Result whose Vulnerabilities list the weaknesses. It returns false
+// when a lookup failed outright, since a record that could not be read must
+// not be reported as missing.
+func checkMail(ctx context.Context, domain string) (Result, bool) {
+	var mp MailPosture
+	var findings []Finding
+	add := func(id, severity, detail string) {
+		findings = append(findings, newFinding("mail-check", id, severity, confidenceConfirmed, detail, nil))
+	}
+
+	recs, err := lookupTXT(ctx, domain)
+	if err != nil {
+		reportToolError("dns", "mail", "", err)
+		return Result{}, false
+	}
+	// "v=spf1" alone is a valid (if useless) record
+	for _, r := range withPrefix(recs, "v=spf1") {
+		if len(r) == 6 || r[6] == ' ' {
+			mp.SPF = append(mp.SPF, r)
+		}
+	}
+	switch len(mp.SPF) {
+	case 0:
+		add("spf-missing", "medium", "no SPF record; anyone can send mail as "+domain)
+	case 1:
+		mp.SPFAll = spfAll(mp.SPF[0])
+		switch {
+		case mp.SPFAll == "+all" || mp.SPFAll == "all":
+			add("spf-pass-all", "high", "SPF ends in "+mp.SPFAll+", which authorises every sender")
+		case mp.SPFAll == "?all":
+			add("spf-neutral", "medium", "SPF ends in ?all, which asserts nothing about other senders")
+		case mp.SPFAll == "~all":
+			add("spf-softfail", "low", "SPF ends in ~all; receivers usually accept failing mail")
+		case mp.SPFAll == "" && !strings.Contains(strings.ToLower(mp.SPF[0]), "redirect="):
+			add("spf-no-all", "low", "SPF has no all mechanism, so other senders get a neutral result")
+		}
+	default:
+		// RFC 7208 4.5: more than one record is a permanent error and
+		// receivers treat the domain as having no SPF at all
+		add("spf-multiple", "medium", strconv.Itoa(len(mp.SPF))+" SPF records published; receivers treat this as a permanent error")
+	}
+
+	dmarcName := "_dmarc." + domain
+	recs, err = lookupTXT(ctx, dmarcName)
+	if err != nil {
+		reportToolError("dns", "mail", "", err)
+		return Result{}, false
+	}
+	if c, err := dnsResolver.LookupCNAME(ctx, dmarcName); err == nil && !strings.EqualFold(strings.TrimSuffix(c, "."), dmarcName) {
+		mp.DMARCCNAME = strings.TrimSuffix(c, ".")
+	}
+	mp.DMARC = withPrefix(recs, "v=DMARC1")
+	switch len(mp.DMARC) {
+	case 0:
+		add("dmarc-missing", "medium", "no DMARC record at "+dmarcName)
+	case 1:
+		tags := dmarcTags(mp.DMARC[0])
+		mp.DMARCPolicy = strings.ToLower(tags["p"])
+		switch mp.DMARCPolicy {
+		case "reject", "quarantine":
+			if pct, err := strconv.Atoi(tags["pct"]); err == nil && pct < 100 {
+				add("dmarc-partial", "low", "DMARC policy "+mp.DMARCPolicy+" only applies to "+tags["pct"]+"% of failing mail")
+			}
+		case "none":
+			add("dmarc-none", "low", "DMARC p=none only monitors; spoofed mail is delivered")
+		default:
+			add("dmarc-invalid", "medium", "DMARC record has no valid p= tag")
+		}
+	default:
+		add("dmarc-multiple", "medium", strconv.Itoa(len(mp.DMARC))+" DMARC records published; receivers ignore them all")
+	}
+
+	for _, sel := range dkimSelectors {
+		recs, err := lookupTXT(ctx, sel+"._domainkey."+domain)
+		if err != nil {
+			continue
+		}
+		for _, r := range recs {
+			if strings.Contains(r, "p=") {
+				mp.DKIMSelectors = append(mp.DKIMSelectors, sel)
+				break
+			}
+		}
+	}
+	if len(mp.DKIMSelectors) == 0 {
+		add("dkim-not-found", "info", "no DKIM key under common selectors")
+	}
+
+	if findings == nil {
+		findings = []Finding{}
+	}
+	return Result{
+		RunID:           runID,
+		RootDomain:      domain,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Subdomain:       domain,
+		TechStack:       []string{},
+		Vulnerabilities: findings,
+		Source:          "mail-posture",
+		MailPosture:     &mp,
+	}, true
+}
+
+// spfAll returns the all mechanism of an SPF record with its qualifier as
+// written, or "" when the record has none
+func spfAll(record string) string {
+	for _, term := range strings.Fields(record) {
+		t := strings.ToLower(term)
+		if strings.TrimLeft(t, "+-~?") == "all" {
+			return t
+		}
+	}
+	return ""
+}
+
+// dmarcTags splits a DMARC record into its tag=value pairs
+func dmarcTags(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if ok {
+			tags[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+	}
+	return tags
+}
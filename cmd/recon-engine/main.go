@@ -1,13 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -16,16 +19,186 @@ import (
 
 // Result represents the unified data schema for recon results
 type Result struct {
-	Timestamp       string                   `json:"timestamp"`
-	Subdomain       string                   `json:"subdomain"`
-	StatusCode      int                      `json:"status_code"`
-	Title           string                   `json:"title"`
-	TechStack       []string                 `json:"tech_stack"`
-	Vulnerabilities []map[string]interface{} `json:"vulnerabilities"`
-	Source          string                   `json:"source"`
-	Asn             string                   `json:"asn,omitempty"`
-	Org             string                   `json:"org,omitempty"`
-	Versions        map[string]string        `json:"versions,omitempty"`
+	RunID             string            `json:"run_id"`
+	RootDomain        string            `json:"root_domain"`
+	EngineVersion     string            `json:"engine_version"`
+	SchemaVersion     string            `json:"schema_version"`
+	Timestamp         string            `json:"timestamp"`
+	Subdomain         string            `json:"subdomain"`
+	URL               string            `json:"url,omitempty"`
+	Port              int               `json:"port,omitempty"`
+	StatusCode        int               `json:"status_code"`
+	Title             string            `json:"title"`
+	TechStack         []string          `json:"tech_stack"`
+	Vulnerabilities   []Finding         `json:"vulnerabilities"`
+	Source            string            `json:"source,omitempty"`
+	Sources           []string          `json:"sources,omitempty"`
+	SubfinderSources  []string          `json:"subfinder_sources,omitempty"`
+	IP                string            `json:"ip,omitempty"`
+	IPs               []string          `json:"ips,omitempty"`
+	CNAME             string            `json:"cname,omitempty"`
+	Ptr               string            `json:"ptr,omitempty"`
+	SharedHosting     bool              `json:"shared_hosting,omitempty"`
+	Asn               string            `json:"asn,omitempty"`
+	Org               string            `json:"org,omitempty"`
+	Country           string            `json:"country,omitempty"`
+	City              string            `json:"city,omitempty"`
+	Versions          map[string]string `json:"versions,omitempty"`
+	VersionConfidence map[string]int    `json:"version_confidence,omitempty"`
+	DetectionSource   map[string]string `json:"detection_source,omitempty"` // with -fingerprint: probe, whatweb or native, by technology
+	CensysServices    []CensysService   `json:"censys_services,omitempty"`
+	HistoricalIPs     []HistoricalIP    `json:"historical_ips,omitempty"`
+	CDN               string            `json:"cdn,omitempty"`
+	OpenPorts         []OpenPort        `json:"open_ports,omitempty"`
+	Paths             []PathHit         `json:"paths,omitempty"`
+	Parameters        []string          `json:"parameters,omitempty"`
+	Matches           []Match           `json:"matches,omitempty"`
+	RobotsDisallow    []string          `json:"robots_disallow,omitempty"`
+	SitemapURLs       []string          `json:"sitemap_urls,omitempty"`
+	SecurityTxt       *SecurityTxt      `json:"security_txt,omitempty"`
+	Flags             []string          `json:"flags,omitempty"`
+	InterestScore     int               `json:"interest_score,omitempty"`
+	Blocked           bool              `json:"blocked,omitempty"`      // the answer is a WAF block or challenge page
+	BlockVendor       string            `json:"block_vendor,omitempty"` // whose, from the block rules
+	Parked            bool              `json:"parked,omitempty"`       // the domain is parked or for sale
+	ParkedBy          string            `json:"parked_by,omitempty"`    // the parking provider, from the parked rules
+	MailPosture       *MailPosture      `json:"mail_posture,omitempty"`
+	Whois             *WhoisInfo        `json:"whois,omitempty"`
+	DNSAudit          *DNSAudit         `json:"dns_audit,omitempty"`
+	SecurityHeaders   map[string]string `json:"security_headers,omitempty"`
+	HeaderGrade       string            `json:"header_grade,omitempty"` // A to F, with -header-audit
+	Jarm              string            `json:"jarm,omitempty"`
+	Cookies           []CookieInfo      `json:"cookies,omitempty"`
+	Cert              *CertInfo         `json:"cert,omitempty"`
+	ClusterID         string            `json:"cluster_id,omitempty"`
+	ClusterMembers    []string          `json:"cluster_members,omitempty"` // with -collapse-clusters
+
+	FinalURL          string   `json:"final_url,omitempty"`
+	RedirectChain     []string `json:"redirect_chain,omitempty"`
+	RedirectsOffScope bool     `json:"redirects_off_scope,omitempty"`
+
+	ContentLength  int     `json:"content_length,omitempty"`
+	ResponseTimeMs float64 `json:"response_time_ms,omitempty"`
+	BodySHA256     string  `json:"body_sha256,omitempty"`
+	DuplicateOf    string  `json:"duplicate_of,omitempty"`
+	// Soft404 marks a root response indistinguishable from the host's
+	// answer to a random path, with -soft404
+	Soft404 bool `json:"soft_404,omitempty"`
+
+	// FingerprintInherited marks versions and technologies copied from the
+	// WhatWeb run of another host serving the same application
+	FingerprintInherited bool `json:"fingerprint_inherited,omitempty"`
+
+	// Authenticated marks a status, title, length and body hash taken from
+	// the page fetched with the host's -auth-file credentials
+	Authenticated bool `json:"authenticated,omitempty"`
+
+	// AbandonedCandidate marks a host whose expired certificate comes with
+	// other signs of neglect, with -cert-check
+	AbandonedCandidate bool `json:"abandoned_candidate,omitempty"`
+
+	// Known marks a host listed in -known
+	Known bool `json:"known,omitempty"`
+
+	// ProbeSkipped says why a host was not probed or enriched: the
+	// -exclude-ips address it resolved to, or the lookup that failed when
+	// its addresses had to be checked
+	ProbeSkipped string `json:"probe_skipped,omitempty"`
+
+	// CachedAt marks a result taken from the -state-backend instead of
+	// probed: when this or another run probed the host (RFC 3339)
+	CachedAt string `json:"cached_at,omitempty"`
+
+	// BodyArchive is the gzipped response body -archive-bodies kept of a
+	// flagged host, "bodies/<host>_<port>/<sha256>.gz" relative to the
+	// -workdir
+	BodyArchive string `json:"body_archive,omitempty"`
+
+	// RawRef points at the -keep-raw records the result was parsed from,
+	// space-separated "raw/<tool>.jsonl.gz:<record>" relative to the
+	// -workdir
+	RawRef string `json:"raw_ref,omitempty"`
+
+	// ProbeEngine is what probed the host: httpx, or the native engine,
+	// whose tech_stack only has what the response headers name
+	ProbeEngine string `json:"probe_engine,omitempty"`
+
+	// Environment is prod, staging, dev, test, uat or unknown, going by the
+	// environment rules; EnvironmentEvidence names the conditions that fired
+	Environment         string `json:"environment,omitempty"`
+	EnvironmentEvidence string `json:"environment_evidence,omitempty"`
+
+	// AccessControl is what keeps an anonymous visitor from the page, with
+	// -access-control: none, basic-auth, ntlm, client-cert, ip-restricted,
+	// waf-blocked, sso-redirect or unknown. AccessEvidence is what showed
+	// it and IdPHost the identity provider an SSO redirect leads to.
+	AccessControl  string `json:"access_control,omitempty"`
+	AccessEvidence string `json:"access_evidence,omitempty"`
+	IdPHost        string `json:"idp_host,omitempty"`
+
+	// ThirdParty marks a host served by a SaaS or CDN vendor's
+	// infrastructure, ThirdPartyVendor, with -third-party.
+	// ThirdPartyEvidence is the CNAME, CDN or ASN that showed it, and
+	// CNAMEChain the names the host's CNAME records lead through.
+	ThirdParty         bool     `json:"third_party,omitempty"`
+	ThirdPartyVendor   string   `json:"third_party_vendor,omitempty"`
+	ThirdPartyEvidence string   `json:"third_party_evidence,omitempty"`
+	CNAMEChain         []string `json:"cname_chain,omitempty"`
+
+	// Owner is the -owners team the host belongs to, "unassigned" when no
+	// pattern matches
+	Owner        string `json:"owner,omitempty"`
+	OwnerContact string `json:"owner_contact,omitempty"`
+
+	// Vhost is set on -vhost results: the Host header the address was
+	// requested with and what set the answer apart
+	Vhost *VhostProbe `json:"vhost,omitempty"`
+
+	// Timings are in seconds, with -timings
+	Timings map[string]float64 `json:"timings,omitempty"`
+
+	// Tags are the run's -tag and config file tags
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Screenshot is the file -screenshots saved the page to, and
+	// ScreenshotHash its 64-bit perceptual hash in hex
+	Screenshot     string `json:"screenshot,omitempty"`
+	ScreenshotHash string `json:"screenshot_hash,omitempty"`
+
+	ChangeType string   `json:"change_type,omitempty"`
+	Changes    []string `json:"changes,omitempty"`
+
+	// VisualChange pairs the previous and current screenshot of a host
+	// that looks different, with change_type visual-change when nothing
+	// else changed
+	VisualChange *VisualChange `json:"visual_change,omitempty"`
+
+	// FirstSeen and LastSeen come from the -state history: when the
+	// subdomain first turned up and when it last answered a probe (RFC 3339)
+	FirstSeen string `json:"first_seen,omitempty"`
+	LastSeen  string `json:"last_seen,omitempty"`
+
+	// headers holds httpx's response headers when a rule or -header-audit
+	// needs them
+	headers map[string]string
+	// body is the page block detection fetched, kept only while the block
+	// rules run
+	body []byte
+	// nameservers are the root domain's nameservers, kept only while the
+	// parked rules run
+	nameservers []string
+	// pageLinks are the links with a query on the host's page, found by
+	// -params
+	pageLinks []string
+	// claim is the -state-backend claim of the name whose probe gave the
+	// result
+	claim *probeClaim
+	// techConfidence is how sure the source in DetectionSource is of each
+	// technology, to settle version conflicts between fingerprinters
+	techConfidence map[string]int
+	// seq numbers the probed hosts in the order httpx answered, for
+	// -ordered
+	seq int
 }
 
 // HttpxResult matches the JSON output from httpx
@@ -36,6 +209,23 @@ type HttpxResult struct {
 	Title      string   `json:"title"`
 	Tech       []string `json:"tech"`
 	WebServer  string   `json:"webserver"`
+	Host       string   `json:"host"`
+	A          []string `json:"a"`
+	AAAA       []string `json:"aaaa"`
+	CDN        bool     `json:"cdn"`
+	CDNName    string   `json:"cdn_name"`
+
+	// Present with -content-length -response-time -hash sha256; older httpx
+	// releases omit some of them and they are left zero
+	ContentLength int    `json:"content_length"`
+	Time          string `json:"time"`
+	Hash          struct {
+		BodySHA256 string `json:"body_sha256"`
+	} `json:"hash"`
+
+	// Present with -irh, which is only passed when a rule matches headers
+	// or with -header-audit
+	Header map[string]interface{} `json:"header"`
 }
 
 // AmassResult matches partial JSON output from amass
@@ -50,261 +240,2417 @@ type AmassResult struct {
 
 // WhatWebResult matches partial JSON output from whatweb
 type WhatWebResult struct {
-	Target  string `json:"target"`
-	Plugins map[string]struct {
-		String  []string `json:"string,omitempty"`
-		Version []string `json:"version,omitempty"`
-	} `json:"plugins"`
+	Target     string                   `json:"target"`
+	HTTPStatus int                      `json:"http_status"`
+	Plugins    map[string]WhatWebPlugin `json:"plugins"`
+}
+
+// WhatWebPlugin is one plugin match within a WhatWebResult
+type WhatWebPlugin struct {
+	String    []string        `json:"string,omitempty"`
+	Version   []string        `json:"version,omitempty"`
+	Certainty json.RawMessage `json:"certainty,omitempty"`
 }
 
 var (
-	useDeep        bool
-	useFingerprint bool
+	useDeep           bool
+	useFingerprint    bool
+	fingerprintEngine string
+	sourcesFlag       string
+	censysEnrich      bool
+	censysMaxPages    int
+
+	securityTrailsMaxRequests int
+	vtRequestsPerMinute       int
+	dnsdbMaxRequests          int
+	circlMaxRequests          int
+	historicalIPsFlag         bool
+	scanHistoricalIPs         bool
+	maxPerSource              int
+	maxSubdomains             int
+	maxLiveHosts              int
+	timeBudget                time.Duration
+	timeBudgetSplit           string
+	breakerFailures           int
+	breakerCooldown           time.Duration
+	budgetReset               string
+	blockThreshold            float64
+	blockAction               string
+	blockThrottleRate         int
+	blockInclude              bool
+	includeParked             bool
+	recursive                 bool
+	recursionDepth            int
+	emitUnprobed              bool
+	legacySourceFlag          bool
+
+	bruteForce       bool
+	axfr             bool
+	wordlistPath     string
+	resolversPath    string
+	endpointFailures int
+	endpointCooldown time.Duration
+	dnsTimeout       time.Duration
+	dnsRetries       int
+	bruteConcurrency int
+	bruteChunkSize   int
+	showStats        bool
+	statsInterval    time.Duration
+
+	permute          bool
+	permutePatterns  string
+	maxPermutations  int
+	permuteRecursive bool
+
+	ptrSweep bool
+
+	asnExpand       string
+	asnExpandMaxIPs int
+	vhostProbe      bool
+	vhostMaxPairs   int
+	vhostCDN        bool
+	maxIPs          int
+
+	orgName string
+
+	// parallelDomains is how many -org domains are scanned at once
+	parallelDomains int
+	orgAuto         bool
+
+	rateLimit int
+	wwDelay   time.Duration
+
+	politeMode  bool
+	politeRate  int
+	politeDelay time.Duration
+
+	wwAggression     int
+	wwPlugins        string
+	wwExcludePlugins string
+	wwConfidence     bool
+	fingerprintAll   bool
+	wwTimeout        time.Duration
+	wwMaxOutput      int64
+
+	httpxThreads     int
+	httpxTimeout     int
+	httpxRetries     int
+	httpxExtraArgs   string
+	httpxPassthrough []string
+
+	probeEngine        string
+	probeBatch         int
+	nativeTimeout      time.Duration
+	nativeMaxRedirects int
+
+	amassActive  bool
+	amassConfig  string
+	amassTimeout int
+
+	subfinderConfig         string
+	subfinderSourceList     string
+	subfinderExcludeSources string
+	subfinderAll            bool
+	subfinderJSON           bool
+
+	strictVersions   bool
+	strictTools      bool
+	nmapOutput       string
+	workdirFlag      string
+	waitLock         time.Duration
+	forceLock        bool
+	keepArtifacts    bool
+	keepRawFlag      bool
+	keepRawMax       int64
+	archiveBodies    bool
+	archiveAll       bool
+	archiveMax       int64
+	archiveTypesFlag string
+	recordDir        string
+	replayDir        string
+	recordRedact     bool
+
+	portscanMode     string
+	portscanEngine   string
+	portscanTopPorts int
+	portscanRate     int
+	masscanConfirm   bool
+	masscanServices  bool
+
+	collectRobotsFlag    bool
+	securityTxtFlag      bool
+	corsCheck            bool
+	headerAudit          bool
+	jarmFlag             bool
+	screenshotsFlag      bool
+	screenshotDir        string
+	screenshotKeep       int
+	screenshotRate       int
+	visualIgnorePath     string
+	cookieAudit          bool
+	defaultCreds         bool
+	defaultCredsMax      int
+	defaultCredsDeny     string
+	rulesPath            string
+	techAliasesPath      string
+	techFingerprintsPath string
+	scoreWeightsPath     string
+	scoreKeywords        string
+	bucketCheck          bool
+	mailCheck            bool
+	whoisLookup          bool
+	whoisRecord          bool
+	dnsAudit             bool
+	dnsAuditRecord       bool
+	whoisExpiryWarn      time.Duration
+	dorksPath            string
+	graphPath            string
+	exportBurpPath       string
+	exportURLsPath       string
+	exportFlags          string
+
+	soft404Flag bool
+
+	thirdPartyCheck       bool
+	thirdPartyVendorsPath string
+	skipThirdPartyActive  bool
+
+	envBody              bool
+	accessCheck          bool
+	certCheck            bool
+	certExpiryWarnFlag   string
+	certExpiryWarn       time.Duration
+	certExpiredSeverity  string
+	certExpiringSeverity string
+
+	dirBrute            bool
+	dirbruteWordlist    string
+	dirbruteStatus      string
+	dirbruteMaxRequests int
+
+	paramsFlag     bool
+	paramsProbe    bool
+	paramsWordlist string
+	paramsProbeMax int
+	paramsMax      int
+	paramsBudget   int
+
+	redirectCheck       bool
+	redirectCheckMax    int
+	redirectCheckBudget int
+
+	matchRegexes regexFlags
+	matchFile    string
+
+	eventsOnStdout bool
+	eventsFile     string
+
+	dryRun         bool
+	outputFormat   string
+	plainOutput    bool
+	handshake      bool
+	emitUpdates    bool
+	plainStream    bool
+	noColor        bool
+	templateText   string
+	templateFile   string
+	junitFailFlags string
+
+	fieldsFlag        string
+	excludeFieldsFlag string
+
+	// defectDojoFlagged adds Info findings for flagged hosts to -format defectdojo
+	defectDojoFlagged bool
+	outputPath        string
+	compressOutput    bool
+	encryptOutputSpec string
+	flushEvery        int
+	syncInterval      time.Duration
+	matchCodes        string
+	filterCodes       string
+	filterFlag        string
+	includeDead       bool
+
+	dedupeBackend   string
+	queueMemory     int
+	scopePath       string
+	excludeIPsFlag  string
+	probeMixedIPs   bool
+	probeUnresolved bool
+	tuiFlag         bool
+
+	runIDFlag    string
+	pprofAddr    string
+	otelEndpoint string
+	otelSample   float64
+	keysFile     string
+	configPath   string
+	profileName  string
+	profileShow  bool
+
+	useDocker        bool
+	dockerImagesFlag string
+
+	proxyFlag          string
+	proxySkipDiscovery bool
+
+	sourceIPFlag  string
+	interfaceFlag string
+	ipVersion     string
+
+	extraHeaders headerFlags
+	runTags      tagFlags
+	authFile     string
+	userAgent    string
+	summaryFile  string
+	timings      bool
+	probePorts   string
+
+	followRedirectsFlag bool
+	dedupeIdentical     bool
+	clusterResults      bool
+	clusterKeysFlag     string
+	collapseClusters    bool
+
+	sharedHostingThreshold int
+	asnDBPath              string
+	workers                int
+	stageWorkers           string
+	ordered                bool
+	orderedWindow          int
+	geoIPPath              string
+	diffPath               string
+
+	monitor         bool
+	monitorInterval time.Duration
+	monitorJitter   float64
+	statePath       string
+	staleAfter      int
+	webhookURL      string
+	webhookFlags    string
+	webhookMinScore int
+	ownersPath      string
+	knownPath       string
+	hideKnown       bool
+
+	jiraURL         string
+	jiraProject     string
+	jiraMinSeverity string
+	jiraIssueType   string
+	jiraCachePath   string
+
+	emailTo          string
+	emailFrom        string
+	emailResultsFlag bool
+	emailMaxResults  int64
+	emailRequired    bool
+	smtpHost         string
+	smtpPort         int
+
+	failOnSeverity      string
+	failOnNewSubdomains int
+
+	cveLookup    bool
+	cveCachePath string
+	cacheDir     string
+	cacheTTL     time.Duration
+	noCache      bool
+
+	kafkaBrokers string
+	kafkaTopic   string
+
+	redisURL   string
+	redisKey   string
+	redisMode  string
+	redisTTL   time.Duration
+	redisSpool string
+
+	stateBackend     string
+	minProbeInterval time.Duration
+	forceProbe       bool
+
+	uploadURL             string
+	uploadPartialInterval time.Duration
 )
 
 func main() {
-	flag.BoolVar(&useDeep, "deep", false, "Enable deep discovery (Amass)")
-	flag.BoolVar(&useFingerprint, "fingerprint", false, "Enable aggressive fingerprinting (WhatWeb)")
-	flag.Parse()
+	// Panics elsewhere in the main goroutine still stop the child tools
+	defer func() {
+		if r := recover(); r != nil {
+			stopChildren()
+			flushStderr()
+			panic(r)
+		}
+	}()
 
-	args := flag.Args()
-	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-deep] [-fingerprint] <target-domain>\n", os.Args[0])
-		os.Exit(1)
+	// -record and -replay run the engine's own executable as a shim in
+	// place of the external tools
+	runTapeShim()
+	// bench runs the engine's own executable in place of the external tools
+	if tool, ok := replayTool(); ok {
+		runReplay(tool, os.Args[1:])
 	}
-	target := args[0]
 
-	// Check if required tools are installed
-	checkBinaries()
+	// A bare domain keeps working as an alias for scan
+	cmd, args := "scan", os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "scan", "resume", "doctor", "report", "diff", "serve", "schema", "bench", "install", "known", "reprocess", "host", "decrypt":
+			cmd, args = args[0], args[1:]
+		}
+	}
+	switch cmd {
+	case "serve":
+		runServe(args)
+	case "schema":
+		runSchema(args)
+	case "report":
+		runReport(args)
+	case "diff":
+		runDiffCommand(args)
+	case "bench":
+		runBench(args)
+	case "install":
+		runInstall(args)
+	case "known":
+		runKnownCommand(args)
+	case "reprocess":
+		runReprocess(args)
+	case "decrypt":
+		runDecrypt(args)
+	default:
+		runScan(cmd, args)
+	}
+}
 
-	// Setup signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// runScan implements scan, resume, doctor and host. resume takes a -state
+// file instead of a target and scans the target recorded in it; doctor checks
+// what a scan with the same flags needs, without scanning; host enriches one
+// URL without discovery.
+func runScan(cmd string, args []string) {
 
-	// Map to store ASN/Org info from Amass to enrich later
-	// key: subdomain, value: struct{asn, org}
-	type Infrastructure struct {
-		Asn int
-		Org string
+	flag.BoolVar(&useDeep, "deep", false, "Enable deep discovery (Amass, passive unless -amass-active)")
+	flag.BoolVar(&useFingerprint, "fingerprint", false, "Enable aggressive fingerprinting (WhatWeb, or the native matcher with -fingerprint-engine)")
+	flag.StringVar(&fingerprintEngine, "fingerprint-engine", fingerprintEngineWhatWeb, "What -fingerprint runs: whatweb, native (the embedded Wappalyzer fingerprints, no external tool) or both, see Technology names below")
+	flag.StringVar(&sourcesFlag, "sources", "", "Comma-separated discovery sources (default: subfinder, plus amass with -deep)")
+	flag.BoolVar(&censysEnrich, "censys-enrich", false, "Enrich live hosts with Censys service/port data")
+	flag.IntVar(&censysMaxPages, "censys-max-pages", 10, "Maximum Censys certificate search pages per domain")
+	flag.IntVar(&securityTrailsMaxRequests, "securitytrails-max-requests", 5, "Maximum SecurityTrails API requests per domain")
+	flag.IntVar(&vtRequestsPerMinute, "vt-rate", 4, "VirusTotal requests per minute (4 on the free tier)")
+	flag.IntVar(&dnsdbMaxRequests, "dnsdb-max-requests", 5, "Maximum DNSDB API requests per domain, discovery and -historical-ips together")
+	flag.IntVar(&circlMaxRequests, "circl-max-requests", 20, "Maximum CIRCL passive DNS queries per domain, discovery and -historical-ips together")
+	flag.BoolVar(&historicalIPsFlag, "historical-ips", false, "Attach the addresses passive DNS (dnsdb, circl) saw each host resolve to, with when, as historical_ips")
+	flag.BoolVar(&scanHistoricalIPs, "scan-historical-ips", false, "Probe the addresses the dnsdb and circl sources saw names resolve to; they may belong to someone else by now")
+	flag.IntVar(&maxPerSource, "max-subdomains-per-source", 0, "Stop a discovery source after it reports N names (0 = unlimited)")
+	flag.IntVar(&maxSubdomains, "max-subdomains", 0, "Probe at most N unique names across all discovery stages, then stop discovery (0 = unlimited)")
+	flag.IntVar(&maxLiveHosts, "max-live-hosts", 0, "Stop the run once N live hosts have been emitted (0 = unlimited)")
+	flag.DurationVar(&timeBudget, "time-budget", 0, "Finish the run within this time, ending each stage when its slice is spent, see Time budget below (0 = unlimited)")
+	flag.StringVar(&timeBudgetSplit, "time-budget-split", "", "Comma-separated stage=percent shares of -time-budget for discovery, probe and enrich (default 30 each)")
+	flag.IntVar(&breakerFailures, "breaker-failures", 3, "Skip a discovery source or enrichment API after this many failures in a row, see Circuit breaker below (0 = never)")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Minute, "With -monitor, how long a failing source is skipped before it is tried again")
+	flag.StringVar(&budgetReset, "budget-reset", budgetResetIteration, "With -monitor, when the -config budgets start afresh: iteration or day")
+	flag.Float64Var(&blockThreshold, "block-threshold", 0.3, "Warn when this share of a root domain's live hosts answer with WAF block pages, see WAF blocks below (0 = never)")
+	flag.StringVar(&blockAction, "block-action", blockActionWarn, "What crossing -block-threshold does besides warning: warn, throttle or pause")
+	flag.IntVar(&blockThrottleRate, "block-throttle-rate", 2, "Requests per second -block-action throttle slows active requests to the root domain to")
+	flag.BoolVar(&blockInclude, "block-include", false, "Cluster and score blocked results like any other")
+	flag.BoolVar(&includeParked, "include-parked", false, "Enrich, score and notify about parked domains like any other, see Parked domains below")
+	flag.BoolVar(&recursive, "recursive", false, "Run the discovery sources again on intermediate domains of discovered names (e.g. internal.example.com)")
+	flag.IntVar(&recursionDepth, "recursion-depth", 2, "How many levels -recursive goes below the first discovery wave")
+	flag.BoolVar(&emitUnprobed, "emit-unprobed", false, "Also emit a record, with no status code, for every discovered name httpx got no answer from")
+	flag.BoolVar(&legacySourceFlag, "legacy-source", false, "Also fill the deprecated single-valued source field of discovered hosts (removed in the next release; use sources)")
+	flag.BoolVar(&bruteForce, "brute", false, "Enable DNS brute-force discovery")
+	flag.BoolVar(&axfr, "axfr", false, "Attempt a zone transfer from each of the target's nameservers and add the names it yields")
+	flag.StringVar(&wordlistPath, "wordlist", "", "Wordlist for -brute (default: embedded list)")
+	flag.StringVar(&resolversPath, "resolvers", "", "DNS resolvers for native lookups and the tools that take one: comma-separated IPs or IP:ports, files of them one per line, or \"system\" for /etc/resolv.conf (default: embedded public resolvers)")
+	flag.IntVar(&endpointFailures, "endpoint-failures", 3, "Quarantine a -proxy or resolver after this many failures in a row (0 = never)")
+	flag.DurationVar(&endpointCooldown, "endpoint-cooldown", time.Minute, "How long a failing -proxy or resolver stays out of the rotation")
+	flag.DurationVar(&dnsTimeout, "dns-timeout", 2*time.Second, "Timeout of one native DNS query")
+	flag.IntVar(&dnsRetries, "dns-retries", 2, "Times a native DNS query that times out or fails is retried, each on the next resolver")
+	flag.IntVar(&bruteConcurrency, "brute-concurrency", 50, "Concurrent DNS lookups for -brute")
+	flag.IntVar(&bruteChunkSize, "brute-chunk-size", 10000, "Wordlist lines per -brute chunk, the unit -state checkpoints and resume skips")
+	flag.BoolVar(&showStats, "stats", false, "Print progress counters to stderr periodically")
+	flag.DurationVar(&statsInterval, "stats-interval", 5*time.Second, "Interval between -stats lines")
+	flag.BoolVar(&permute, "permute", false, "Resolve permutations of discovered names once discovery finishes")
+	flag.StringVar(&permutePatterns, "permute-patterns", "", "Word file for -permute (default: embedded list)")
+	flag.IntVar(&maxPermutations, "max-permutations", 50000, "Maximum permutation candidates to resolve")
+	flag.BoolVar(&permuteRecursive, "permute-recursive", false, "Permute names found by -permute again")
+	flag.StringVar(&asnExpand, "asn-expand", "", "Probe every address announced by these org-owned ASNs (e.g. AS64500,AS64501), see ASN sweep below")
+	flag.IntVar(&asnExpandMaxIPs, "asn-expand-max-ips", 4096, "Refuse -asn-expand when its ASNs announce more addresses than this")
+	flag.BoolVar(&vhostProbe, "vhost", false, "Request the live hosts' addresses with the Host headers of the other in-scope names, see Virtual hosts below")
+	flag.IntVar(&vhostMaxPairs, "vhost-max-pairs", 1000, "Request at most N (address, Host) pairs with -vhost")
+	flag.BoolVar(&vhostCDN, "vhost-cdn", false, "Include CDN addresses in -vhost")
+	flag.IntVar(&parallelDomains, "parallel-domains", 1, "Scan up to N -org root domains at once, sharing the worker budget, rate limits and output")
+	flag.StringVar(&orgName, "org", "", "Scan the root domains of this organization instead of a target, found through certificates, reverse WHOIS and ASNs (see Organizations below)")
+	flag.BoolVar(&orgAuto, "org-auto", false, "Scan the medium and high confidence -org domains without asking")
+	flag.IntVar(&maxIPs, "max-ips", 4096, "Refuse an IP or CIDR target holding more addresses than this")
+	flag.BoolVar(&ptrSweep, "ptr", false, "Reverse-resolve the IPs of discovered names, probe in-scope PTR names and record each host's PTR")
+	flag.IntVar(&rateLimit, "rate-limit", 0, "Maximum requests per second (0 = unlimited), see Traffic controls below")
+	flag.BoolVar(&politeMode, "polite", false, "Honour robots.txt and cap traffic to the target, see Traffic controls below")
+	flag.IntVar(&politeRate, "polite-rate", 2, "Requests per second to the target with -polite (lower -rate-limit values win)")
+	flag.DurationVar(&politeDelay, "polite-delay", 2*time.Second, "Pause between per-host active probes with -polite")
+	flag.DurationVar(&wwDelay, "delay", 0, "Pause between WhatWeb invocations, see Traffic controls below")
+	flag.StringVar(&sourceIPFlag, "source-ip", "", "Send recon traffic from this local address, for clients that allowlist the scanner")
+	flag.StringVar(&interfaceFlag, "interface", "", "Send recon traffic from this network interface's address (its IPv4 one when it has several, its IPv6 one with -ip-version 6)")
+	flag.StringVar(&ipVersion, "ip-version", ipVersionBoth, "Address families hosts are resolved, probed and port scanned on: 4, 6 or both, see IPv6 below")
+	flag.StringVar(&proxyFlag, "proxy", "", "Proxy URL for all HTTP traffic, e.g. http://127.0.0.1:8080 or socks5://127.0.0.1:1080; several comma-separated ones are rotated through, see Endpoint rotation below")
+	flag.BoolVar(&proxySkipDiscovery, "proxy-skip-discovery", false, "Send passive API discovery sources direct instead of through -proxy")
+	flag.Var(&runTags, "tag", "Tag every result and the run summary with key=value, e.g. env=prod (repeatable; merged over the -config file's tags:)")
+	flag.Var(&extraHeaders, "header", "Extra HTTP header \"Name: value\" sent by httpx, WhatWeb and native requests (repeatable)")
+	flag.StringVar(&authFile, "auth-file", "", "YAML file of per-host credentials: headers and cookies, from environment variables, sent only to the hosts they name (see Authenticated scanning below)")
+	flag.StringVar(&userAgent, "user-agent", "", "User-Agent for httpx, WhatWeb and native requests")
+	flag.StringVar(&summaryFile, "summary-file", "", "Also write the end-of-run summary to this file")
+	flag.BoolVar(&timings, "timings", false, "Record per-stage timings on each result and their percentiles in the run summary")
+	flag.StringVar(&probePorts, "probe-ports", "", "Comma-separated ports for httpx to probe on every name, e.g. 80,443,8080,8443")
+	flag.BoolVar(&followRedirectsFlag, "follow-redirects", true, "Record the redirect chain and final URL of hosts answering 3xx (up to 10 hops)")
+	flag.BoolVar(&dedupeIdentical, "dedupe-identical", false, "Tag results whose body hash matches an earlier result with duplicate_of")
+	flag.BoolVar(&clusterResults, "cluster", false, "Group live hosts serving the same application under a cluster_id and list the clusters in the summary")
+	flag.StringVar(&clusterKeysFlag, "cluster-keys", "title,body,tech", "What results of one -cluster share besides the status code: title, body, tech, length")
+	flag.BoolVar(&collapseClusters, "collapse-clusters", false, "Emit one representative per cluster with its cluster_members instead of every member (implies -cluster)")
+	flag.IntVar(&sharedHostingThreshold, "shared-hosting-threshold", 5, "Distinct subdomains on one IP before it is reported as shared hosting")
+	flag.StringVar(&asnDBPath, "asn-db", "", "ip2asn TSV dataset (optionally .gz) for ASN/Org enrichment; asnmap is used when omitted and installed")
+	flag.IntVar(&workers, "workers", 10, "Enrichment worker budget: hosts enriched at a time, with the stages sharing the slots by weight (see Worker budget below)")
+	flag.StringVar(&stageWorkers, "stage-workers", "", "Comma-separated stage=N caps on the calls of an enrichment stage running at once, within -workers (e.g. whatweb=3,dirbrute=2)")
+	flag.BoolVar(&ordered, "ordered", false, "Emit enriched results in the order httpx answered instead of as they finish, see Worker budget below")
+	flag.IntVar(&orderedWindow, "ordered-window", 1000, "Results -ordered holds behind one slow host before emitting them out of order")
+	flag.StringVar(&geoIPPath, "geoip", "", "GeoLite2-City.mmdb database for country/city enrichment")
+	flag.StringVar(&diffPath, "diff", "", "Previous run output (NDJSON or JSON array); only new, changed and removed hosts are emitted")
+	flag.BoolVar(&monitor, "monitor", false, "Keep running, rescanning every -interval and emitting only changes")
+	flag.DurationVar(&monitorInterval, "interval", 6*time.Hour, "Time between -monitor iterations")
+	flag.Float64Var(&monitorJitter, "jitter", 0.1, "Random fraction of -interval added or removed between iterations")
+	flag.StringVar(&statePath, "state", "", "State file holding the last run's results; later runs report only changes against it")
+	flag.StringVar(&stateBackend, "state-backend", "", "Probe state shared between runs, machines and teams (redis://host/0, file:///shared/dir or sqlite:///path/state.db): names another run probed within -min-probe-interval are not probed again, see Shared probe state below")
+	flag.DurationVar(&minProbeInterval, "min-probe-interval", 24*time.Hour, "Reuse the -state-backend results of names probed more recently than this instead of probing them")
+	flag.BoolVar(&forceProbe, "force", false, "Probe every name, whenever the -state-backend says it was last probed")
+	flag.IntVar(&staleAfter, "stale-after", 3, "Report a -state subdomain as stale once it has not answered for more than this many runs (0 = never)")
+	flag.StringVar(&webhookURL, "webhook-url", "", "POST each emitted result to this URL (Slack incoming webhooks get a text message)")
+	flag.StringVar(&webhookFlags, "webhook-flags", "", "Only notify -webhook-url about results carrying one of these comma-separated flags (e.g. admin-panel,login-page)")
+	flag.IntVar(&webhookMinScore, "webhook-min-score", 0, "Only notify -webhook-url about results with at least this interest score")
+	flag.StringVar(&knownPath, "known", "", "File of hosts already triaged, one per line: their results are marked known and left out of notifications and gates, see Known assets below")
+	flag.BoolVar(&hideKnown, "hide-known", false, "Leave unchanged -known hosts out of the output too")
+	flag.StringVar(&ownersPath, "owners", "", "YAML file mapping host patterns to owning teams, see Owners below")
+	flag.StringVar(&jiraURL, "jira-url", "", "Jira base URL to file an issue per finding at or above -jira-min-severity (token from JIRA_API_TOKEN)")
+	flag.StringVar(&jiraProject, "jira-project", "", "Jira project key for -jira-url issues")
+	flag.StringVar(&jiraMinSeverity, "jira-min-severity", "high", "Lowest finding severity filed in Jira (critical, high, medium, low or info)")
+	flag.StringVar(&jiraIssueType, "jira-issue-type", "Bug", "Issue type of -jira-url issues")
+	flag.StringVar(&jiraCachePath, "jira-cache", "", "File recording the findings already filed in Jira (default: user cache dir)")
+	flag.StringVar(&emailTo, "email-to", "", "Comma-separated addresses to email the run summary and HTML report to when the run finishes")
+	flag.StringVar(&emailFrom, "email-from", "", "Sender of -email-to messages (default: SMTP_USERNAME when it is an address)")
+	flag.BoolVar(&emailResultsFlag, "email-results", false, "Also attach the results as gzipped NDJSON to the -email-to message")
+	flag.Int64Var(&emailMaxResults, "email-max-results", 10<<20, "Leave out the -email-results attachment when it is larger than this many bytes")
+	flag.BoolVar(&emailRequired, "email-required", false, "Exit 5 when the -email-to message could not be sent")
+	flag.StringVar(&smtpHost, "smtp-host", "", "SMTP server for -email-to (credentials from SMTP_USERNAME and SMTP_PASSWORD)")
+	flag.IntVar(&smtpPort, "smtp-port", 587, "SMTP port for -smtp-host; 465 uses implicit TLS, others STARTTLS when offered")
+	flag.StringVar(&failOnSeverity, "fail-on-severity", "", "Exit 2 when a finding at or above this severity is reported (critical, high or medium)")
+	flag.IntVar(&failOnNewSubdomains, "fail-on-new-subdomains", 0, "Exit 3 when -diff reports at least N new subdomains (0 = off)")
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", "", "Comma-separated Kafka brokers to publish each emitted result to (TLS/SASL from KAFKA_* env vars)")
+	flag.StringVar(&kafkaTopic, "kafka-topic", "", "Kafka topic for -kafka-brokers")
+	flag.StringVar(&redisURL, "redis-url", "", "Redis URL (redis:// or rediss://) to push each emitted result to")
+	flag.StringVar(&redisKey, "redis-key", "recon:results", "Redis list or stream key for -redis-url")
+	flag.StringVar(&redisMode, "redis-mode", "list", "How results are pushed to Redis: list (LPUSH) or stream (XADD)")
+	flag.DurationVar(&redisTTL, "redis-ttl", 0, "Trim stream entries older than this on each XADD (stream mode, 0 = keep all)")
+	flag.StringVar(&redisSpool, "redis-spool", "redis-spool.ndjson", "File that results Redis could not accept are appended to")
+	flag.StringVar(&uploadURL, "upload", "", "Upload the output, summary and nmap report to s3://bucket/prefix/ or gs://bucket/prefix/ when the run ends")
+	flag.DurationVar(&uploadPartialInterval, "upload-partial-interval", 0, "Also upload the output written so far at this interval (0 = only at the end)")
+	flag.BoolVar(&cveLookup, "cve-lookup", false, "Look up CVEs for product versions found by -fingerprint (NVD, key from NVD_API_KEY)")
+	flag.StringVar(&cveCachePath, "cve-cache", "", "CVE lookup cache file (default: user cache dir)")
+	flag.StringVar(&cacheDir, "cache", "", "Directory caching WhatWeb results between runs (default ~/.recon-engine/cache)")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 24*time.Hour, "Reuse -cache entries younger than this")
+	flag.BoolVar(&noCache, "no-cache", false, "Neither read nor write the -cache")
+	flag.IntVar(&wwAggression, "ww-aggression", 3, "WhatWeb aggression level, 1 (stealthy) to 4 (heavy)")
+	flag.StringVar(&wwPlugins, "ww-plugins", "", "Comma-separated WhatWeb plugins to run (default: all)")
+	flag.StringVar(&wwExcludePlugins, "ww-exclude-plugins", "", "Comma-separated WhatWeb plugins to skip")
+	flag.DurationVar(&wwTimeout, "ww-timeout", 60*time.Second, "Kill a WhatWeb run that takes longer than this; the host keeps no versions")
+	flag.Int64Var(&wwMaxOutput, "ww-max-output", 8<<20, "Kill a WhatWeb run that writes more than this many bytes; the host keeps no versions")
+	flag.BoolVar(&fingerprintAll, "fingerprint-all", false, "Run WhatWeb on every live host instead of once per group of hosts with the same body hash, title and technologies")
+	flag.BoolVar(&wwConfidence, "ww-confidence", false, "Add version_confidence for versions WhatWeb is not certain of")
+	flag.IntVar(&httpxThreads, "httpx-threads", 0, "httpx -threads (0 = httpx default)")
+	flag.IntVar(&httpxTimeout, "httpx-timeout", 0, "httpx -timeout in seconds (0 = httpx default)")
+	flag.IntVar(&httpxRetries, "httpx-retries", 0, "httpx -retries (0 = httpx default)")
+	flag.StringVar(&httpxExtraArgs, "httpx-args", "", "Extra arguments appended to the httpx command line")
+	flag.StringVar(&probeEngine, "probe-engine", "", "Probe with httpx or native, see Probe engine below (default httpx, or native when httpx is missing)")
+	flag.IntVar(&probeBatch, "probe-batch", 0, "Send each httpx process at most N names, starting a fresh one for the next batch (0 = one process for the run)")
+	flag.DurationVar(&nativeTimeout, "native-timeout", 10*time.Second, "Timeout of each native probe request")
+	flag.IntVar(&nativeMaxRedirects, "native-max-redirects", 0, "Redirects the native probe engine follows (0 = report the redirect, as httpx does)")
+	flag.BoolVar(&amassActive, "amass-active", false, "Run amass in active mode instead of -passive")
+	flag.StringVar(&amassConfig, "amass-config", "", "amass config file (API keys), passed as -config")
+	flag.IntVar(&amassTimeout, "amass-timeout", 0, "amass -timeout in minutes (0 = no limit)")
+	flag.StringVar(&subfinderConfig, "subfinder-config", "", "subfinder provider config (API keys), passed as -provider-config")
+	flag.StringVar(&subfinderSourceList, "subfinder-sources", "", "Comma-separated subfinder sources to use (-s)")
+	flag.StringVar(&subfinderExcludeSources, "subfinder-exclude-sources", "", "Comma-separated subfinder sources to skip (-es)")
+	flag.BoolVar(&subfinderAll, "subfinder-all", false, "Use every subfinder source (-all), slower")
+	flag.BoolVar(&subfinderJSON, "subfinder-json", false, "Read subfinder JSON output and record which passive sources found each name")
+	flag.BoolVar(&strictVersions, "strict-versions", false, "Fail instead of warning when a tool is older than the supported minimum")
+	flag.BoolVar(&strictTools, "strict-tools", false, "Fail when httpx is missing instead of probing with the native engine")
+	flag.StringVar(&nmapOutput, "nmap-output", "", "File the background nmap scan writes its report to (default <workdir>/nmap-scan.txt)")
+	flag.StringVar(&workdirFlag, "workdir", "", "Directory for the run's artifacts (default ~/.recon-engine/runs/<run-id>)")
+	flag.DurationVar(&waitLock, "wait-lock", 0, "Wait this long for another run holding the -state or -workdir lock instead of failing at once")
+	flag.BoolVar(&forceLock, "force-lock", false, "Take over a -state or -workdir lock whose holder on this host is no longer running")
+	flag.StringVar(&recordDir, "record", "", "Capture every external tool's output and every native HTTP response into this directory, for -replay (see Record and replay below)")
+	flag.StringVar(&replayDir, "replay", "", "Run against a -record directory instead of running the tools or making HTTP requests")
+	flag.BoolVar(&recordRedact, "record-redact", false, "Replace the target domain and its hostnames in the -record captures with pseudonyms under "+redactedDomain)
+	flag.BoolVar(&keepArtifacts, "keep-artifacts", true, "Keep the -workdir once the run completes; false deletes it unless the run was interrupted or its upload failed")
+	flag.BoolVar(&keepRawFlag, "keep-raw", false, "Keep the raw httpx, amass and WhatWeb output in <workdir>/raw, for the reprocess command")
+	flag.Int64Var(&keepRawMax, "keep-raw-max", 256<<20, "Stop keeping raw output once -keep-raw holds this many bytes of it, before compression")
+	flag.BoolVar(&archiveBodies, "archive-bodies", false, "Keep the response body of flagged hosts and hosts with findings, gzipped, in <workdir>/bodies (body_archive)")
+	flag.BoolVar(&archiveAll, "archive-all", false, "Keep the response body of every live host, not only flagged ones; implies -archive-bodies")
+	flag.Int64Var(&archiveMax, "archive-max", 1<<20, "Bytes of each body -archive-bodies keeps, before compression")
+	flag.StringVar(&archiveTypesFlag, "archive-types", "", "Comma-separated media types -archive-bodies keeps besides text, e.g. application/pdf,image/*")
+	flag.StringVar(&portscanMode, "portscan", "root", "Port scan the root target (root) or the resolved IPs of live hosts after probing (hosts)")
+	flag.StringVar(&portscanEngine, "portscan-engine", "nmap", "Scanner for -portscan hosts: nmap, naabu or masscan")
+	flag.StringVar(&portscanEngine, "portscan-tool", "nmap", "Old name of -portscan-engine")
+	flag.IntVar(&portscanTopPorts, "portscan-top-ports", 100, "How many of the most common ports -portscan hosts checks")
+	flag.IntVar(&portscanRate, "portscan-rate", 0, "Packets per second for -portscan hosts (0 = scanner default; required for masscan)")
+	flag.BoolVar(&masscanConfirm, "masscan-confirm", false, fmt.Sprintf("Allow masscan to run above %d packets/s", masscanSafeRate))
+	flag.BoolVar(&masscanServices, "masscan-services", false, "Follow masscan with nmap service detection on the ports it found open")
+	flag.BoolVar(&collectRobotsFlag, "robots", false, "Collect robots.txt Disallow entries and same-host sitemap URLs from live hosts")
+	flag.BoolVar(&securityTxtFlag, "security-txt", false, "Fetch and parse security.txt (RFC 9116) from live hosts")
+	flag.BoolVar(&corsCheck, "cors-check", false, "Check live hosts' root path for CORS policies that trust foreign, subdomain or null origins with credentials")
+	flag.BoolVar(&headerAudit, "header-audit", false, "Grade live hosts' security response headers from A to F, on the page their redirects end on")
+	flag.BoolVar(&jarmFlag, "jarm", false, "Compute the JARM TLS fingerprint of live HTTPS hosts with tlsx and group hosts sharing one in the summary")
+	flag.BoolVar(&screenshotsFlag, "screenshots", false, "Screenshot live hosts with headless Chromium and report visual changes against -diff, -state or the previous -monitor iteration")
+	flag.StringVar(&screenshotDir, "screenshot-dir", "", "Directory the screenshots are kept in, one subdirectory per host and port (default ~/.recon-engine/screenshots)")
+	flag.IntVar(&screenshotKeep, "screenshot-keep", 5, "Screenshots kept per host and port; older ones are deleted")
+	flag.IntVar(&screenshotRate, "screenshot-rate", 2, "Screenshots started per second at most (0: no limit)")
+	flag.IntVar(&visualThreshold, "visual-threshold", defaultVisualThreshold, "Bits of the 64-bit perceptual hash two screenshots must differ in beyond this to count as a visual change")
+	flag.StringVar(&visualIgnorePath, "visual-ignore", "", "YAML file of screenshot regions to leave out of the hash, per host glob")
+	flag.BoolVar(&envBody, "env-body", false, "Fetch the page of live hosts the hostname, title and headers do not place in an environment, for the body markers of the environment rules")
+	flag.BoolVar(&thirdPartyCheck, "third-party", false, "Label live hosts served by SaaS or CDN vendors' infrastructure, going by their CNAME chain, CDN and ASN, see Third-party infrastructure below")
+	flag.StringVar(&thirdPartyVendorsPath, "third-party-vendors", "", "YAML list of extra -third-party vendors, tried before the embedded ones (implies -third-party)")
+	flag.BoolVar(&skipThirdPartyActive, "skip-third-party-active", false, "Keep dirbrute, default-creds and redirect-check from -third-party hosts, which are still probed and passively enriched (implies -third-party)")
+	flag.BoolVar(&accessCheck, "access-control", false, "Classify what keeps anonymous visitors from live hosts: basic-auth, ntlm, client-cert, ip-restricted, waf-blocked or sso-redirect, see Access control below")
+	flag.BoolVar(&certCheck, "cert-check", false, "Record live HTTPS hosts' TLS certificates and report expired or soon-to-expire ones")
+	flag.StringVar(&certExpiryWarnFlag, "cert-expiry-warn", "30d", "With -cert-check, report certificates expiring within this long, e.g. 14d")
+	flag.StringVar(&certExpiredSeverity, "cert-expired-severity", "medium", "Severity of the -cert-check finding for an expired certificate")
+	flag.StringVar(&certExpiringSeverity, "cert-expiring-severity", "low", "Severity of the -cert-check finding for a certificate expiring within -cert-expiry-warn")
+	flag.BoolVar(&cookieAudit, "cookie-audit", false, "Record the cookies live hosts' root path sets and report session cookies missing Secure, HttpOnly or SameSite")
+	flag.BoolVar(&defaultCreds, "default-creds", false, "Try default credentials against live hosts whose technologies match the embedded table of admin interfaces")
+	flag.IntVar(&defaultCredsMax, "default-creds-max", 3, "Most login requests -default-creds sends to one host")
+	flag.StringVar(&defaultCredsDeny, "default-creds-deny", "weblogic,glassfish,zabbix", "Comma-separated products -default-creds never tries, for interfaces that lock accounts out")
+	flag.BoolVar(&bucketCheck, "bucket-check", false, "Probe live hosts backed by S3, GCS or Azure blob storage for public listings and unclaimed buckets")
+	flag.BoolVar(&mailCheck, "mail-check", false, "Check the root domain's SPF, DMARC and common DKIM selectors and emit a mail-posture record")
+	flag.BoolVar(&whoisLookup, "whois", false, "Look up the root domain's registrar, dates and registrant org (RDAP, falling back to WHOIS) for the run summary")
+	flag.BoolVar(&whoisRecord, "whois-record", false, "Also emit the -whois data as a record with source whois")
+	flag.BoolVar(&dnsAudit, "dns-audit", false, "Check the root domain's DNSSEC chain, nameservers and SOA timers for the run summary, see DNS audit below")
+	flag.BoolVar(&dnsAuditRecord, "dns-audit-record", false, "Also emit the -dns-audit results as a record with source dns-audit, its findings under vulnerabilities")
+	flag.DurationVar(&whoisExpiryWarn, "whois-expiry-warn", 30*24*time.Hour, "Flag the root domain as expiring soon within this long of its expiry date")
+	flag.StringVar(&graphPath, "graph", "", "Write the graph of domains, subdomains, IPs, ASNs, certificates and technologies to this file: GraphML, or DOT for a .dot or .gv name")
+	flag.StringVar(&exportBurpPath, "export-burp", "", "Write the live hosts that pass the filters to this file as a Burp Suite target scope")
+	flag.StringVar(&exportURLsPath, "export-urls", "", "Write the URLs of the live hosts that pass the filters to this file, one per line")
+	flag.StringVar(&exportFlags, "export-flags", "", "Only export the hosts with one of these comma-separated flags, e.g. login-page,admin-panel")
+	flag.StringVar(&dorksPath, "dorks", "", "Write GitHub code search and Google dorks for the target, its notable hosts and technologies to this file (hit counts with GITHUB_TOKEN)")
+	flag.StringVar(&techFingerprintsPath, "tech-fingerprints", "", "Wappalyzer technology JSON file, or a directory of them, added to the embedded fingerprints of -fingerprint-engine native")
+	flag.StringVar(&techAliasesPath, "tech-aliases", "", "JSON object of extra technology aliases, name -> canonical name (\"\" drops the name), added to the embedded table")
+	flag.StringVar(&scoreWeightsPath, "score-weights", "", "YAML file of interest score weights replacing the embedded ones it sets, see Interest score below")
+	flag.StringVar(&scoreKeywords, "score-keywords", "", "Comma-separated extra hostname keywords that raise the interest score")
+	flag.StringVar(&rulesPath, "rules", "", "YAML file of extra triage rules that set a result's flags (added to the embedded rules)")
+	flag.BoolVar(&soft404Flag, "soft404", false, "Request a random path on each live host and mark results whose root page is the same answer with soft_404")
+	flag.BoolVar(&dirBrute, "dirbrute", false, "Brute-force paths on live hosts with ffuf")
+	flag.StringVar(&dirbruteWordlist, "dirbrute-wordlist", "", "Wordlist for -dirbrute (default: embedded list of common paths)")
+	flag.StringVar(&dirbruteStatus, "dirbrute-status", "200,204,301,302,401,403", "Status codes -dirbrute reports")
+	flag.IntVar(&dirbruteMaxRequests, "dirbrute-max-requests", 1000, "Most paths -dirbrute tries per host")
+	flag.BoolVar(&paramsFlag, "params", false, "Collect the query and form parameter names of live hosts, see Parameter discovery below")
+	flag.BoolVar(&paramsProbe, "params-probe", false, "With -params, also send candidate parameters and keep those reflected or changing the response")
+	flag.StringVar(&paramsWordlist, "params-wordlist", "", "Candidate parameters for -params-probe (default: embedded list)")
+	flag.IntVar(&paramsProbeMax, "params-probe-max", 30, "Most candidate parameters -params-probe tries per host")
+	flag.IntVar(&paramsMax, "params-max", 50, "Most parameter names recorded per host")
+	flag.IntVar(&paramsBudget, "params-budget", 0, "Most -params-probe requests across the run, half kept for login and admin pages (0 = unlimited)")
+	flag.Var(&matchRegexes, "match-regex", "Regex matched against live hosts' response headers and body, recorded under matches (repeatable), see Response matching below")
+	flag.StringVar(&matchFile, "match-file", "", "YAML list of {name, regex, part} patterns for live hosts' responses, part being body, header or all")
+	flag.BoolVar(&redirectCheck, "redirect-check", false, "Test redirect-like parameters of live hosts' known URLs for open redirects, see Open redirects below")
+	flag.IntVar(&redirectCheckMax, "redirect-check-max", 5, "Most -redirect-check requests per host")
+	flag.IntVar(&redirectCheckBudget, "redirect-check-budget", 200, "Most -redirect-check requests across the run (0 = unlimited)")
+	flag.BoolVar(&eventsOnStdout, "events", false, `Write {"type":"error"} records for tool failures to stdout, interleaved with results`)
+	flag.StringVar(&eventsFile, "events-file", "", "Append tool failure records to this file instead of stdout")
+	flag.BoolVar(&dryRun, "dry-run", false, "Validate flags, check tools and print the commands a run would execute, then exit")
+	flag.StringVar(&outputFormat, "format", "", "Output format: json (the default for results; -dry-run prints text unless set), junit or defectdojo")
+	flag.BoolVar(&defectDojoFlagged, "defectdojo-flagged", false, "With -format defectdojo, also report each flag on a host as an Info finding")
+	flag.StringVar(&junitFailFlags, "junit-fail-flags", "admin-panel,dir-listing,error-page-verbose", "Flags that make a host's -format junit testcase fail, besides findings above info")
+	flag.BoolVar(&handshake, "handshake", false, "Start the output with a handshake record: engine and schema version, run ID, targets and the stages the run will take")
+	flag.BoolVar(&emitUpdates, "emit-updates", false, "Write an update record for each result whose ASN and Org amass reported only after it was written")
+	flag.BoolVar(&plainOutput, "plain", false, "Print one aligned, human-readable line per result instead of JSON, highest interest score first")
+	flag.BoolVar(&plainStream, "plain-stream", false, "Print -plain lines as results arrive instead of sorted at the end of the run (always so with -monitor)")
+	flag.StringVar(&templateText, "template", "", `Render each result through this Go text/template instead of JSON, e.g. '{{.Subdomain}} {{.StatusCode}} {{join .TechStack ","}}'`)
+	flag.StringVar(&templateFile, "template-file", "", "Read the -template from this file")
+	flag.StringVar(&fieldsFlag, "fields", "", "Comma-separated JSON fields to write for each result, with dot paths for nested ones, e.g. subdomain,status_code,vulnerabilities.id")
+	flag.StringVar(&excludeFieldsFlag, "exclude-fields", "", "Comma-separated JSON fields to leave out of each result, with dot paths for nested ones")
+	flag.BoolVar(&noColor, "no-color", false, "Do not colorize -plain output (also off when stdout is not a terminal or NO_COLOR is set)")
+	flag.StringVar(&outputPath, "o", "", "Write results to this file instead of stdout")
+	flag.BoolVar(&compressOutput, "compress", false, "Gzip the -o file, adding a .gz suffix if missing")
+	flag.StringVar(&encryptOutputSpec, "encrypt-output", "", "Encrypt the -o file, -state file, -keep-raw records and reports at rest with the AES-256 key in an environment variable, given as env:NAME, see Encryption at rest below")
+	flag.IntVar(&flushEvery, "flush-every", 1, "Flush a -compress -o file every N records, so a killed run loses at most N (0 = only at checkpoints)")
+	flag.DurationVar(&syncInterval, "sync-interval", 30*time.Second, "Flush the -o file to disk at this interval, besides after each -monitor iteration and at exit (0 = only then)")
+	flag.StringVar(&matchCodes, "match-codes", "", "Only emit results with these status codes or classes, e.g. 200,401,403 or 2xx")
+	flag.StringVar(&filterCodes, "filter-codes", "", "Do not emit results with these status codes or classes, e.g. 404 or 5xx")
+	flag.StringVar(&filterFlag, "filter", "", `Only emit and notify about results this expression holds for, e.g. 'status_code == 200 && contains(tech_stack, "WordPress")', see Filter expressions below`)
+	flag.BoolVar(&includeDead, "include-dead", false, "Keep results without a status code when -match-codes or -filter-codes is set")
+	flag.BoolVar(&tuiFlag, "tui", false, "Show a live results table in the terminal while writing results to -o")
+	flag.StringVar(&scopePath, "scope", "", "Program scope file; names and addresses outside it are never probed or scanned")
+	flag.StringVar(&excludeIPsFlag, "exclude-ips", "", "Comma-separated IPv4/IPv6 addresses, CIDR ranges or files of them; hosts resolving into them are reported but never probed")
+	flag.BoolVar(&probeMixedIPs, "probe-mixed-ips", false, "Probe hosts that resolve to -exclude-ips addresses besides others, instead of excluding them")
+	flag.BoolVar(&probeUnresolved, "probe-unresolved", false, "Probe names whose lookup failed when -exclude-ips or -scope address rules need their addresses, instead of keeping them out")
+	flag.StringVar(&dedupeBackend, "dedupe-backend", "memory", "Where discovered names are deduplicated: memory, or disk for very large scopes")
+	flag.IntVar(&queueMemory, "queue-memory", 100000, "Names waiting for httpx kept in memory; more are spilled to a temporary file")
+	flag.StringVar(&runIDFlag, "run-id", "", "Correlation ID stamped on every result (default: a random UUID)")
+	flag.StringVar(&keysFile, "keys-file", "", "Read API keys and other credentials from this file of NAME=value lines; the environment wins")
+	flag.StringVar(&pprofAddr, "pprof", "", "Serve net/http/pprof profiles on this address, e.g. localhost:6060")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", "", "Export OpenTelemetry traces of the run to this OTLP collector: http(s)://host:4318 or grpc(s)://host:4317, see OpenTelemetry below")
+	flag.Float64Var(&otelSample, "otel-sample", 1, "Share of runs -otel-endpoint traces, from 0 to 1")
+	flag.BoolVar(&useDocker, "use-docker", false, "Run tools missing from PATH in their docker image instead of failing")
+	flag.StringVar(&dockerImagesFlag, "docker-images", "", "Comma-separated tool=image overrides for -use-docker, e.g. nmap=instrumentisto/nmap:7.95")
+	flag.StringVar(&configPath, "config", "", "YAML config file with profiles and tags (default: ~/.recon-engine/config.yaml when it exists)")
+	flag.StringVar(&profileName, "profile", "", "Preset flag set: quick, standard, thorough or a profile of -config; explicit flags win, see Profiles below")
+	flag.BoolVar(&profileShow, "profile-show", false, "Print the flags -profile sets and exit")
+	registerToolFlags()
+	flag.Usage = func() { usage(cmd) }
+	flag.CommandLine.Parse(args)
+	if err := applyProfile(); err != nil {
+		startupError("Invalid -profile", err)
+	}
+	configureTags()
+	// Before anything is printed that could carry a credential
+	if err := loadKeysFile(); err != nil {
+		startupError("Invalid -keys-file", err)
+	}
+	redactStderr()
+	if err := configureEncryption(encryptOutputSpec); err != nil {
+		startupError("Invalid -encrypt-output", err)
 	}
-	infraMap := make(map[string]Infrastructure)
-	var infraMutex sync.Mutex
-
-	// Channel to collect subdomains from all sources
-	subdomains := make(chan string, 1000)
-	var wgDiscovery sync.WaitGroup
 
-	// --- 1. Subfinder ---
-	wgDiscovery.Add(1)
-	go func() {
-		defer wgDiscovery.Done()
-		cmd := exec.Command("subfinder", "-d", target, "-silent")
-		stdout, err := cmd.StdoutPipe()
+	args = flag.Args()
+	if len(args) < 1 && cmd != "doctor" && orgName == "" {
+		usage(cmd)
+		exit(exitStartupFailed)
+	}
+	target := ""
+	if len(args) > 0 {
+		target = args[0]
+	}
+	if cmd == "resume" {
+		statePath = args[0]
+		st, err := readState(statePath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Subfinder pipe error: %v\n", err)
-			return
-		}
-		if err := cmd.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "Subfinder start error: %v\n", err)
-			return
+			startupError("Failed to load state", err)
 		}
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			subdomains <- scanner.Text()
+		target = st.Target
+	}
+	if cmd == "host" {
+		t, err := configureHostCommand(args)
+		if err != nil {
+			startupError("Invalid host options", err)
 		}
-		cmd.Wait()
-	}()
+		target = t
+	}
 
-	// --- 2. Amass (Conditional) ---
-	if useDeep {
-		wgDiscovery.Add(1)
-		go func() {
-			defer wgDiscovery.Done()
-			// amass enum -passive -d target -json -
-			// Note: Amass output format can be tricky. Using -passive for speed as requested in plan (though user said 'deep discovery' usually implies active, plan said 'amass enum -passive').
-			// User request: "amass enum -passive -d <target>"
-			// We stream output.
-			cmd := exec.Command("amass", "enum", "-passive", "-d", target, "-json", "/dev/stdout") // forcing stdout if needed, or just let it print
-			stdout, err := cmd.StdoutPipe()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Amass pipe error: %v\n", err)
-				return
-			}
-			if err := cmd.Start(); err != nil {
-				fmt.Fprintf(os.Stderr, "Amass start error: %v\n", err)
-				return
-			}
-			scanner := bufio.NewScanner(stdout)
-			// Amass JSON output line by line
-			for scanner.Scan() {
-				line := scanner.Bytes()
-				var ar AmassResult
-				if err := json.Unmarshal(line, &ar); err == nil && ar.Name != "" {
-					subdomains <- ar.Name
-					// Capture Infra info
-					if len(ar.Addresses) > 0 {
-						infraMutex.Lock()
-						infraMap[ar.Name] = Infrastructure{
-							Asn: ar.Addresses[0].Asn,
-							Org: ar.Addresses[0].Desc,
-						}
-						infraMutex.Unlock()
-					}
-				}
+	sources, err := selectedSources()
+	if err != nil {
+		startupError("Invalid -sources", err)
+	}
+	if err := configurePassiveDNS(sources); err != nil {
+		startupError("Invalid passive DNS options", err)
+	}
+	if err := validateOrg(cmd, args); err != nil {
+		startupError("Invalid -org", err)
+	}
+	if parallelDomains < 1 {
+		startupError("Invalid -parallel-domains", fmt.Errorf("must be at least 1, got %d", parallelDomains))
+	}
+
+	nativeLimiter = newTokenBucket(rateLimit)
+	if err := configurePolite(); err != nil {
+		startupError("Invalid -polite options", err)
+	}
+	if err := validatePorts(probePorts); err != nil {
+		startupError("Invalid -probe-ports", err)
+	}
+	if err := configureASN(); err != nil {
+		startupError("Invalid -asn-db", err)
+	}
+	if geoIPPath != "" {
+		if err := openGeoIP(geoIPPath); err != nil {
+			startupError("Invalid -geoip", err)
+		}
+	}
+	if err := configureEgress(); err != nil {
+		startupError("Invalid egress options", err)
+	}
+	if err := configureHTTP(); err != nil {
+		startupError("Invalid -proxy", err)
+	}
+	if err := validateEndpoints(); err != nil {
+		startupError("Invalid endpoint rotation options", err)
+	}
+	if err := configureAuth(); err != nil {
+		startupError("Invalid -auth-file", err)
+	}
+	if monitor && monitorInterval <= 0 {
+		startupError("Invalid -interval", fmt.Errorf("must be positive, got %s", monitorInterval))
+	}
+	if err := validateGates(); err != nil {
+		startupError("Invalid gate", err)
+	}
+	if httpxPassthrough, err = parseHttpxArgs(); err != nil {
+		startupError("Invalid -httpx-args", err)
+	}
+	checkSubfinderConfig()
+	if amassConfig != "" {
+		if _, err := os.Stat(amassConfig); err != nil {
+			startupError("Invalid -amass-config", err)
+		}
+	}
+	switch {
+	case outputFormat != "" && outputFormat != "json" && outputFormat != "junit" && outputFormat != "defectdojo":
+		startupError("Invalid -format", fmt.Errorf("unsupported format %q (want json, junit or defectdojo)", outputFormat))
+	case outputFormat != "" && outputFormat != "json" && monitor:
+		startupError("Invalid -format", fmt.Errorf("%s is one document per run and cannot be used with -monitor", outputFormat))
+	}
+	if err := validatePlain(); err != nil {
+		startupError("Invalid -plain", err)
+	}
+	if err := validateHandshake(); err != nil {
+		startupError("Invalid -handshake", err)
+	}
+	if err := configureTemplate(); err != nil {
+		startupError("Invalid -template", err)
+	}
+	if err := configureFields(); err != nil {
+		startupError("Invalid field selection", err)
+	}
+	if err := validateEmitUpdates(); err != nil {
+		startupError("Invalid -emit-updates", err)
+	}
+	if dedupeBackend != "memory" && dedupeBackend != "disk" {
+		startupError("Invalid -dedupe-backend", fmt.Errorf("want memory or disk, got %q", dedupeBackend))
+	}
+	if err := validatePortscan(); err != nil {
+		startupError("Invalid port scan options", err)
+	}
+	if queueMemory < 1 {
+		startupError("Invalid -queue-memory", fmt.Errorf("must be at least 1, got %d", queueMemory))
+	}
+	if maxSubdomains < 0 || maxLiveHosts < 0 || recursionDepth < 1 {
+		startupError("Invalid discovery limits", fmt.Errorf("-max-subdomains and -max-live-hosts must not be negative and -recursion-depth must be at least 1"))
+	}
+	if err := configureTimeBudget(); err != nil {
+		startupError("Invalid -time-budget", err)
+	}
+	if err := validateBreaker(); err != nil {
+		startupError("Invalid circuit breaker options", err)
+	}
+	if err := configureAPIBudgets(); err != nil {
+		startupError("Invalid API budgets", err)
+	}
+	if err := validateBlock(); err != nil {
+		startupError("Invalid block detection options", err)
+	}
+	if err := validateTUI(); err != nil {
+		startupError("Invalid -tui", err)
+	}
+	if err := configureScope(); err != nil {
+		startupError("Invalid -scope", err)
+	}
+	if err := configureExcludeIPs(); err != nil {
+		startupError("Invalid -exclude-ips", err)
+	}
+	if err := configureExports(); err != nil {
+		startupError("Invalid -export-flags", err)
+	}
+	if err := configureOrdered(); err != nil {
+		startupError("Invalid -ordered-window", err)
+	}
+	if err := validateIPTarget(target); err != nil {
+		startupError("Invalid target", err)
+	}
+	if err := validateURLTarget(target); err != nil {
+		startupError("Invalid target", err)
+	}
+	if err := configureIPVersion(target); err != nil {
+		startupError("Invalid -ip-version", err)
+	}
+	if withoutDiscovery(target) {
+		sources = nil
+	}
+	if err := configureStatusFilter(); err != nil {
+		startupError("Invalid status filters", err)
+	}
+	if err := configureFilter(); err != nil {
+		startupError("Invalid -filter", err)
+	}
+	if err := configureClusters(); err != nil {
+		startupError("Invalid -cluster-keys", err)
+	}
+	if err := validateWhois(); err != nil {
+		startupError("Invalid -whois options", err)
+	}
+	if err := validateASNExpand(); err != nil {
+		startupError("Invalid -asn-expand", err)
+	}
+	if err := validateVhost(target); err != nil {
+		startupError("Invalid -vhost options", err)
+	}
+	if err := configureDirbrute(); err != nil {
+		startupError("Invalid -dirbrute options", err)
+	}
+	if err := configureParams(); err != nil {
+		startupError("Invalid -params options", err)
+	}
+	if err := configureRedirectCheck(); err != nil {
+		startupError("Invalid -redirect-check options", err)
+	}
+	if err := configureCertCheck(); err != nil {
+		startupError("Invalid -cert-check options", err)
+	}
+	if err := configureMatch(); err != nil {
+		startupError("Invalid -match-regex / -match-file", err)
+	}
+	if err := configureEvents(); err != nil {
+		startupError("Invalid -events-file", err)
+	}
+	if err := validateWhatWeb(); err != nil {
+		startupError("Invalid WhatWeb options", err)
+	}
+	if err := configureCVE(); err != nil {
+		startupError("Invalid -cve-cache", err)
+	}
+	if err := configureCache(); err != nil {
+		startupError("Invalid -cache", err)
+	}
+	if err := configureDocker(); err != nil {
+		startupError("Invalid docker options", err)
+	}
+	if cveLookup && !useFingerprint {
+		fmt.Fprintln(os.Stderr, "Warning: -cve-lookup uses versions detected by -fingerprint, which is not enabled")
+	}
+	if err := configureKnown(); err != nil {
+		startupError("Invalid -known", err)
+	}
+	if err := configureOwners(); err != nil {
+		startupError("Invalid -owners", err)
+	}
+	if err := configureWebhook(); err != nil {
+		startupError("Invalid -webhook-url", err)
+	}
+	if err := configureJira(); err != nil {
+		startupError("Invalid Jira options", err)
+	}
+	if err := configureEmail(); err != nil {
+		startupError("Invalid email options", err)
+	}
+	if err := configureTechAliases(); err != nil {
+		startupError("Invalid -tech-aliases", err)
+	}
+	if err := configureFingerprints(); err != nil {
+		startupError("Invalid fingerprint options", err)
+	}
+	if err := configureDefaultCreds(); err != nil {
+		startupError("Invalid -default-creds options", err)
+	}
+	if err := configureRules(); err != nil {
+		startupError("Invalid -rules", err)
+	}
+	if err := configureThirdParty(); err != nil {
+		startupError("Invalid -third-party-vendors", err)
+	}
+	if err := configureScreenshots(); err != nil {
+		startupError("Invalid screenshot options", err)
+	}
+	if err := configureScore(); err != nil {
+		startupError("Invalid -score-weights", err)
+	}
+	if err := configureStages(); err != nil {
+		startupError("Invalid -stage-workers", err)
+	}
+	if whatwebFingerprinting() {
+		for _, u := range proxyURLs {
+			if u.Scheme != "http" && u.Scheme != "https" {
+				fmt.Fprintf(os.Stderr, "Warning: WhatWeb only supports HTTP proxies, fingerprinting goes direct when its turn comes to %s\n", u.Redacted())
 			}
-			cmd.Wait()
-		}()
+		}
 	}
 
-	// --- 3. Deduplication & Pipeline to Httpx ---
-	// We need a way to close the input to httpx once discovery is done.
-	// We'll use a pipe for httpx stdin.
-	
-	httpxCmd := exec.Command("httpx", "-silent", "-json", "-title", "-tech-detect", "-status-code")
-	httpxIn, err := httpxCmd.StdinPipe()
-	if err != nil {
-		fatalError("Failed to create httpx stdin pipe", err)
+	if dnsResolver, err = newDNSPool(resolversPath, dnsTimeout, dnsRetries); err != nil {
+		startupError("Invalid resolver options", err)
 	}
-	httpxOut, err := httpxCmd.StdoutPipe()
-	if err != nil {
-		fatalError("Failed to create httpx stdout pipe", err)
+
+	if cmd == "doctor" {
+		runDoctor(target, sources)
+	}
+	// The confirmed -org domains are scanned -parallel-domains at a time,
+	// one after the other by default
+	targets := []string{target}
+	if orgName != "" {
+		picked, err := orgTargets()
+		if err != nil {
+			startupError("No -org domains to scan", err)
+		}
+		targets = make([]string, len(picked))
+		for i, c := range picked {
+			targets[i] = c.Domain
+		}
+		target = targets[0]
+		summary.OrgDomains = picked
 	}
 
-	if err := httpxCmd.Start(); err != nil {
-		fatalError("Failed to start httpx", err)
+	runID = runIDFlag
+	if runID == "" {
+		if runID, err = newRunID(); err != nil {
+			startupError("Failed to generate run ID", err)
+		}
+	}
+	summary.RunID = runID
+	summary.EngineVersion = version
+	summary.Target = strings.Join(targets, ",")
+	summary.Sources = sources
+	summary.Headers = extraHeaders
+	summary.Tags = runTags
+	summary.UserAgent = userAgent
+	summary.APIKeys = apiKeysPresent()
+	if err := configureWorkdir(); err != nil {
+		startupError("Invalid -workdir", err)
+	}
+	if err := configureKeepRaw(); err != nil {
+		startupError("Invalid -keep-raw", err)
+	}
+	if err := configureArchive(); err != nil {
+		startupError("Invalid -archive-bodies", err)
+	}
+	if err := configureTape(target); err != nil {
+		startupError("Invalid -record or -replay", err)
 	}
 
-	// Nmap (Background)
-	nmapCmd := exec.Command("nmap", "-F", "--top-ports", "100", target, "-oN", "nmap-scan.txt")
-	if err := nmapCmd.Start(); err == nil {
-		go nmapCmd.Wait()
+	var baseline *diffBaseline
+	if diffPath != "" {
+		if baseline, err = loadBaseline(diffPath); err != nil {
+			startupError("Failed to load -diff baseline", err)
+		}
+	}
+	// Another run sharing -state or -workdir would overwrite what this one
+	// writes; -monitor takes the locks for each iteration instead
+	if !dryRun && !monitor {
+		if err := acquireRunLocks(cmd, strings.Join(targets, ",")); err != nil {
+			startupError("Failed to lock", err)
+		}
+	}
+	// A single run with -state diffs against and then replaces it; -monitor
+	// loads it itself
+	var history *seenHistory
+	if statePath != "" && !monitor {
+		var prev []Result
+		prev, history, err = loadState(statePath, target)
+		if err != nil {
+			startupError("Failed to load -state", err)
+		}
+		if prev != nil {
+			baseline = newBaseline(prev)
+		} else if baseline == nil {
+			baseline = newBaseline(nil)
+		}
+	}
+	if err := configureCheckpoints(cmd, target); err != nil {
+		startupError("Failed to load -brute checkpoints", err)
 	}
 
-	// Discovery coordination routine
-	go func() {
-		wgDiscovery.Wait()
-		close(subdomains)
-	}()
+	// Check if required tools are installed
+	if err := configureProbeEngine(); err != nil {
+		startupError("Invalid -probe-engine", err)
+	}
+	checkBinaries(sources)
 
-	// Feed unique subdomains to httpx
+	if dryRun {
+		runDryRun(target, sources, len(targets))
+	}
+	// After -dry-run, which must not need Kafka or Redis to be up
+	if err := createWorkdir(); err != nil {
+		startupError("Failed to create -workdir", err)
+	}
+	if err := openRawStore(); err != nil {
+		startupError("Failed to create the -keep-raw store", err)
+	}
+	if err := startPprof(); err != nil {
+		startupError("Invalid -pprof", err)
+	}
+	if err := configureOTel(cmd, summary.Target, sources); err != nil {
+		startupError("Invalid -otel-endpoint", err)
+	}
+	if err := configureKafka(); err != nil {
+		startupError("Kafka setup failed", err)
+	}
+	if err := configureRedis(); err != nil {
+		startupError("Redis setup failed", err)
+	}
+	if err := configureStateBackend(); err != nil {
+		startupError("Invalid -state-backend", err)
+	}
+	if err := configureOutput(cmd == "resume"); err != nil {
+		startupError("Invalid output options", err)
+	}
+	if err := configureUpload(); err != nil {
+		startupError("Invalid -upload", err)
+	}
+	if err := configureASNExpand(); err != nil {
+		startupError("ASN sweep setup failed", err)
+	}
+	checkAuthSessions()
+	if err := writeHandshake(targets, sources); err != nil {
+		fatalError("Failed to write the handshake", err)
+	}
+
+	// Setup signal handling. In monitor mode the first signal lets the
+	// in-flight iteration finish and a second one cancels it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	// os.Interrupt covers Ctrl+C on Windows too; SIGTERM is never delivered
+	// there but registering it is harmless
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		seen := make(map[string]bool)
-		for sub := range subdomains {
-			if !seen[sub] {
-				seen[sub] = true
-				fmt.Fprintln(httpxIn, sub)
-			}
+		<-sigChan
+		close(stop)
+		if monitor {
+			fmt.Fprintln(os.Stderr, "Stopping after the current iteration (signal again to abort)")
+			<-sigChan
 		}
-		httpxIn.Close() // Signal httpx we are done sending targets
+		cancel()
 	}()
 
-	// --- 4. Process Httpx Output & WhatWeb ---
-	scanner := bufio.NewScanner(httpxOut)
-	encoder := json.NewEncoder(os.Stdout)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		var hRes HttpxResult
-		if err := json.Unmarshal(line, &hRes); err != nil {
-			continue
-		}
+	statsDone := make(chan struct{})
+	if showStats {
+		go reportStats(os.Stderr, statsInterval, statsDone)
+	}
 
-		// Prepare Result
-		res := Result{
-			Timestamp:       time.Now().Format(time.RFC3339),
-			Subdomain:       hRes.Input,
-			StatusCode:      hRes.StatusCode,
-			Title:           hRes.Title,
-			TechStack:       extractTech(hRes),
-			Vulnerabilities: []map[string]interface{}{},
-			Source:          "recon_pipeline",
-		}
+	if monitor {
+		runMonitor(ctx, stop, cmd, target, sources, baseline)
+		closeStateBackend()
+		closeRawStore()
+		closeOutput()
+		finishUpload()
+		exit(exitInterrupted)
+	}
 
-		// Enrich with Amass Infra Data
-		infraMutex.Lock()
-		if inf, ok := infraMap[hRes.Input]; ok {
-			res.Asn = fmt.Sprintf("AS%d", inf.Asn)
-			res.Org = inf.Org
+	var ui *tuiView
+	if tuiFlag {
+		// q in the TUI takes the same path as a signal
+		ui = startTUI(target, func() {
+			select {
+			case sigChan <- os.Interrupt:
+			default:
+			}
+		})
+	}
+	gate := newCIGate()
+	sinks := newResultSinks(ctx, newResultEncoder(stdout), ui)
+	collapser := newClusterCollapser()
+	write := func(res Result) {
+		// Gates judge the whole run, not just what the filters let through
+		gate.Observe(res)
+		if !filterResult(res) || collapser.Hold(res) {
+			return
 		}
-		infraMutex.Unlock()
-
-		// --- 5. WhatWeb Fingerprinting (Conditional) ---
-		if useFingerprint && hRes.StatusCode > 0 { // Only fingerprint live hosts
-			// whatweb --aggression 3 --format=json <url>
-			wwCmd := exec.Command("whatweb", "--aggression", "3", "--format=json", hRes.Url) // Use hRes.Url which has protocol
-			// WhatWeb might take time, blocking here slows down the pipeline for this item.
-			// Ideally we have a worker pool, but for now strict pipeline is safer for implementation simplicity.
-			wwOut, err := wwCmd.Output()
-			if err == nil {
-				var wwResults []WhatWebResult
-				if json.Unmarshal(wwOut, &wwResults) == nil && len(wwResults) > 0 {
-					versions := make(map[string]string)
-					for plugin, info := range wwResults[0].Plugins {
-						if len(info.Version) > 0 {
-							versions[plugin] = strings.Join(info.Version, ", ")
-						}
-					}
-					res.Versions = versions
-					
-					// Also merge WhatWeb plugins into TechStack if not present?
-					// Optional, but good for completeness.
-					for plugin := range wwResults[0].Plugins {
-						found := false
-						for _, t := range res.TechStack {
-							if t == plugin {
-								found = true
-								break
-							}
-						}
-						if !found {
-							res.TechStack = append(res.TechStack, plugin)
-						}
-					}
-				}
+		sinks.Emit(res)
+	}
+	var current []Result
+	// Each pipeline emits from one goroutine; with -parallel-domains they
+	// take turns
+	var emitMu sync.Mutex
+	err = runTargets(ctx, targets, func(t string) error {
+		return runPipeline(ctx, t, sources, func(res Result) {
+			emitMu.Lock()
+			defer emitMu.Unlock()
+			observeDomain(res)
+			observeExport(res)
+			if statePath != "" {
+				history.Stamp(&res)
+				current = append(current, res)
 			}
+			if baseline != nil && !baseline.Classify(&res) {
+				return
+			}
+			write(res)
+		})
+	})
+	if err != nil {
+		if ui != nil {
+			ui.Close()
 		}
+		fatalError("Scan failed", err)
+	}
+	// Hosts from the previous run that did not show up this time
+	if baseline != nil && len(trippedCaps()) == 0 {
+		for _, res := range baseline.Removed() {
+			write(res)
+		}
+	}
+	// Only a complete run counts against hosts that did not answer
+	if history != nil && ctx.Err() == nil && len(trippedCaps()) == 0 {
+		for _, res := range history.Advance() {
+			write(res)
+		}
+	}
+	collapser.Drain(sinks.Emit)
+	sinks.Flush()
+	writeInfraUpdates(stdout)
+	if ui != nil {
+		ui.Finish()
+	}
 
-		if err := encoder.Encode(res); err != nil {
-			fmt.Fprintf(os.Stderr, "Error encoding result: %v\n", err)
+	if statePath != "" && ctx.Err() == nil {
+		if err := saveState(statePath, target, current, history); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -state: %v\n", err)
 		}
 	}
 
-	httpxCmd.Wait()
+	if showStats {
+		close(statsDone)
+	}
+	summary.SharedHosting = ipHosts.Shared(sharedHostingThreshold)
+	summary.JarmClusters = jarmHosts.Clusters()
+	summary.Clusters = resultClusters.Shared()
+	if dorksPath != "" {
+		writeDorks(ctx, target)
+	}
+	if graphPath != "" {
+		writeGraph()
+	}
+	if exportBurpPath != "" || exportURLsPath != "" {
+		writeExports()
+	}
+	summary.PTROutOfScope = ptrOutOfScopeNames()
+	gates, code := gate.Tripped()
+	summary.GatesTripped = gates
+	summary.CapsTripped = trippedCaps()
+	summary.ScopeDrops = scopeDrops()
+	summary.IPExclusions = ipExclusionCounts()
+	sinks.Close()
+	closeStateBackend()
+	closeRawStore()
+	summary.Sinks = sinks.Report()
+	summary.finish(os.Stderr)
+	closeOutput()
+	// Upload even when interrupted: a terminating instance gets SIGTERM
+	uploaded := finishUpload()
+	emailed := sendReport(summary.Target)
+	if ctx.Err() != nil {
+		exit(exitInterrupted)
+	}
+	if !uploaded && code == 0 {
+		code = exitUploadFailed
+	}
+	if uploaded {
+		removeWorkdir()
+	}
+	if !emailed && emailRequired && code == 0 {
+		code = exitEmailFailed
+	}
+	exit(code)
 }
 
-func checkBinaries() {
+func usage(cmd string) {
+	out := flag.CommandLine.Output()
+	switch cmd {
+	case "resume":
+		fmt.Fprintf(out, "Usage: %s resume [flags] <state-file>\n\nRescans the target recorded in a -state file, reports what changed since\nit was written and updates it. An -o file is appended to, after cutting\noff a record a killed run left half-written. Takes the same flags as scan.\n\nFlags:\n", os.Args[0])
+	case "host":
+		fmt.Fprintf(out, "Usage: %s host [flags] <url|hostname>\n\nProbes one URL, https:// for a bare hostname or address, without\ndiscovery and runs the whole enricher chain on it: the enrichers that need\nno external tool or API key are on unless a flag turns them off, the rest\nrun when their flags ask. Prints the result JSON, or with -plain a\ndetailed breakdown. Takes the same flags as scan but the discovery ones,\n-probe-ports, -monitor and -org.\n\nFlags:\n", os.Args[0])
+	case "doctor":
+		fmt.Fprintf(out, "Usage: %s doctor [flags] [target-domain]\n\nChecks the external tools and their versions, the Censys, SecurityTrails,\nChaos and VirusTotal API keys (with one cheap authenticated request each)\nand outbound DNS and HTTPS, through -proxy when set, then prints a\npass/warn/fail table, or JSON with -format json. Takes the same flags as\nscan: checks those flags need are marked required, and doctor exits 1 when\none of them fails. Credentials are never printed.\n\nFlags:\n", os.Args[0])
+	default:
+		fmt.Fprintf(out, "Usage: %s [scan] [flags] <target-domain|ip|cidr|url>\n\nCommands:\n"+
+			"  scan    discover, probe and enrich a domain or IP range (default)\n"+
+			"  resume  rescan from a -state file, reporting changes\n"+
+			"  doctor  check tools, API keys and connectivity\n"+
+			"  host    enrich one URL or hostname in depth, without discovery\n"+
+			"  report  render an HTML or Markdown report from results\n"+
+			"  diff    compare two result files\n"+
+			"  serve   HTTP API for submitting scans\n"+
+			"  schema  print the JSON Schema of result records\n"+
+			"  bench   time the pipeline against recorded tool output\n"+
+			"  install install the external tools, or with -check list missing ones\n"+
+			"  known   add the live hosts of results to a -known file\n"+
+			"  reprocess rebuild results from a -keep-raw run's raw output\n"+
+			"  decrypt decrypt an -encrypt-output file\n"+
+			"Run '%s <command> -h' for a command's flags.\n\nFlags:\n", os.Args[0], os.Args[0])
+	}
+	flag.PrintDefaults()
+	fmt.Fprint(out, `
+Profiles:
+  -profile sets a bundle of flags, as if they came first on the command
+  line; flags given explicitly win, with a note on stderr when they
+  disagree. quick is passive discovery and probing with the optional
+  enrichers off (-follow-redirects=false), standard is the defaults, and
+  thorough adds -deep, -fingerprint, -brute, -permute, -recursive,
+  -dirbrute, -params, -soft404, -robots, -security-txt, -header-audit,
+  -cookie-audit, -cors-check, -redirect-check, -cve-lookup, -jarm,
+  -dns-audit and -mail-check. -profile-show prints what a profile sets,
+  with any command line overrides, and exits. The -config file
+  (~/.recon-engine/config.yaml by default) defines more under profiles:,
+  as flag: value pairs with lists for comma-separated flags; one named
+  like a built-in profile replaces it:
+    profiles:
+      ci:
+        sources: [subfinder, crtsh]
+        fail-on-severity: high
+        fields: subdomain,url,status_code
+
+Tags:
+  -tag key=value (repeatable) adds tags: {key: value} to every result and to
+  the run summary, so they reach -o, the webhook, Kafka, Redis and the
+  report header, and -filter can test them as tags.key. The -config file's
+  tags: map applies too, -tag winning per key. Keys are a letter followed
+  by letters, digits and underscores, since downstream indexes use them as
+  field names:
+    tags:
+      env: prod
+      team: payments
+    recon-engine scan -tag env=staging -filter 'tags.env == "staging"' example.com
+
+Traffic controls:
+  -rate-limit N  httpx probing (passed as -rate-limit) and every HTTP request
+                 the engine makes itself: API discovery sources (Censys,
+                 SecurityTrails, VirusTotal, Chaos, crt.sh, DNSDB, CIRCL)
+                 and enrichers.
+                 Shared by all of them, so N is the ceiling for native calls.
+  -delay D       Pause between WhatWeb invocations (WhatWeb has no rate
+                 control of its own). Each invocation sends several requests
+                 to one host depending on the aggression level.
+  -vt-rate N     VirusTotal requests per minute, applied on top of -rate-limit.
+  -proxy URL     httpx (-http-proxy), ffuf (-x), WhatWeb (--proxy, HTTP
+                 proxies only) and native HTTP requests. -proxy-skip-discovery
+                 keeps the passive API sources direct since they are not
+                 in-scope traffic. Several proxies take turns, see Endpoint
+                 rotation.
+  -source-ip IP  Native HTTP, DNS and whois connections, nmap (-S, -e), naabu
+  -interface IF  (-source-ip, -interface) and masscan (--source-ip,
+                 --adapter) leave from this address, or the interface's,
+                 which must be assigned to a local interface. The other
+                 tools cannot be bound and take the default route, with a
+                 warning naming each; -probe-engine native keeps probing on
+                 the address. The summary (egress) and the -handshake
+                 record state the address used.
+  -polite        Caps traffic aimed at the target (httpx, ffuf and the
+                 enrichers, not the passive sources) at -polite-rate, runs
+                 httpx and ffuf with one thread and the active per-host
+                 enrichers one at a time, -polite-delay apart. -dirbrute
+                 skips, and -robots does not fetch, paths robots.txt
+                 disallows for any user agent. It wins over -rate-limit,
+                 -httpx-threads and -dirbrute concurrency, with a warning,
+                 and the summary records the limits under polite.
+DNS lookups (-brute, -permute) are governed by -brute-concurrency only.
+When a window of 200 -brute lookups sees more than 20% timeouts, SERVFAIL
+or REFUSED answers, as resolvers that rate limit give, the lookups in
+flight are halved, with a warning; clean windows add them back one by one.
+
+Large wordlists:
+  -brute streams -wordlist rather than loading it and works through it in
+  chunks of -brute-chunk-size lines (10000). With -state, the chunks
+  resolved so far and the names they found are written into the state file
+  every 10 seconds; resume skips those chunks of an interrupted run and
+  emits their names again. A changed wordlist or chunk size starts over,
+  and a completed run drops the checkpoints. With -stats, the lines carry
+  brute.attempts_per_sec and brute.eta while -brute is running.
+
+Worker budget:
+  -workers hosts are enriched at a time and their enrichment stages share
+  -workers slots. A call of a stage takes as many slots as it weighs:
+  whatweb and dirbrute 3, jarm, default_creds and params 2, every other
+  stage 1, at most the whole budget. Each stage waits in a queue of its
+  own and the queues take turns, so cheap stages keep running while an
+  expensive one waits for slots; a stage passed over 8 times is served
+  next. -stage-workers whatweb=2 also caps a stage's calls in flight. With
+  -stats, stage.<name>.in_flight and stage.<name>.queued show the current
+  calls and waiters.
+  Results go out as their enrichment finishes, so their order varies from
+  run to run. -ordered emits them in the order httpx answered, holding
+  those done early until the hosts before them are; the output of runs
+  over the same answers is then identical. At most -ordered-window results
+  (1000) are held behind one slow host: past that they go out with a
+  warning naming it, it follows out of order, and ordered.spills counts it.
+
+Time budget:
+  -time-budget 30m ends the run within 30 minutes of starting, however
+  large the target. Discovery, probing and enrichment get consecutive
+  slices of it, 30% each unless -time-budget-split discovery=20,probe=40
+  says otherwise; what is left, at least 5%, writes the output, summary and
+  report. When discovery's slice is spent the sources are stopped and names
+  they still report are not probed; when probing's is, httpx is sent no
+  more names and finishes those it has, and the optional enrichers (censys,
+  jarm, dirbrute, whatweb, default_creds, params, redirect_check, cve) are
+  skipped for the hosts still to come; when enrichment's is, httpx and the
+  enrichers in flight are stopped and remaining hosts go out as probed.
+  The summary's time_budget lists the truncated stages with the names or
+  hosts each left unprocessed and the hosts each enricher was skipped for.
+  A truncated run trips the time-budget cap, like -max-live-hosts.
+
+Circuit breaker:
+  A discovery source that fails -breaker-failures times in a row (3 by
+  default) is skipped for the rest of the run, so a broken provider or an
+  exhausted quota is not tried again for every -org domain and -recursive
+  level; the censys and nvd lookups of enrichment are judged the same way.
+  One message says when a breaker opens. With -monitor it closes again
+  after -breaker-cooldown: the next call goes through half-open, and the
+  source is back if it succeeds or skipped for another cool-down if not.
+  -stats lines list the breakers that are not closed, the counters have
+  breaker.<name>.skipped and .opened, and the summary's breakers has each
+  failing source's state, failures and skipped calls. -breaker-failures 0
+  turns the breaker off.
+
+API budgets:
+  Requests to the external APIs (censys, chaos, circl, crtsh, dnsdb,
+  github, nvd, rdap, ripestat, securitytrails, virustotal, whoisxml) are
+  counted, and the -config file caps them per run:
+    budgets:
+      securitytrails: 50
+      virustotal: 500
+  A provider whose budget is spent gets one warning and its circuit
+  breaker opens, even with -breaker-failures 0, so its sources and
+  enrichers are skipped for the rest of the run; the summary's
+  api_requests has each provider's requests, budget and skipped requests,
+  and the counters api.<name>.requests and .skipped. With -monitor the
+  budgets start afresh every iteration, or with -budget-reset day at the
+  first request of each day (local time, counted from the process start).
+  -dry-run estimates each provider's requests from the planned sources,
+  enrichers and their -*-max-* caps; those that scale with what discovery
+  finds say per what. Replayed runs send nothing and count nothing.
+
+Coverage:
+  The summary's coverage lists every capability, sources, discovery,
+  probing, port scanning, each enricher and the post-run checks, in run
+  order, with its stage, the items it processed and one status:
+    ran                     it ran in full
+    skipped-by-flag         no flag asked for it (reason names the flag)
+    skipped-missing-binary  its tool is not installed
+    skipped-missing-key     none of its API keys is set
+    truncated-by-budget     -time-budget, a -max-* cap, a spent API budget
+                            or an open circuit breaker cut it short
+  Every capability is listed whether or not it started, so CI can assert
+  on it, e.g.
+    jq -e '.coverage[]|select(.capability=="fingerprint").status=="ran"' summary.json
+  Capabilities asked for but not run in full are also named on stderr.
+  report -summary summary.json and the -email report add a coverage
+  section.
+
+WAF blocks:
+  Live hosts answering 403, 406, 429 or 503 are matched against the block
+  entries of the triage rules, which recognise the challenge and block
+  pages of Cloudflare, Akamai, Imperva, Sucuri, AWS, F5 and DDoS-Guard.
+  The page is fetched again when the rules need headers or body markers.
+  A match sets blocked and block_vendor; blocked results are left out of
+  -cluster and get no interest_score unless -block-include is set. -rules
+  adds vendors with entries like
+    - block: examplewaf
+      any: [{header: x-examplewaf, regex: "."}, {body_contains: blocked by}]
+  where body_contains is only allowed in block, parked and environment
+  entries. Once 20 live hosts
+  of a root domain are in and -block-threshold of them (0.3 by default)
+  are blocked, a warning says the scan is being filtered; -block-action
+  throttle then slows the native requests aimed at that domain to
+  -block-throttle-rate per second, and pause skips its remaining active
+  enrichers, block detection included. The summary's blocks has each root
+  domain with blocked hosts: results, blocked, vendors and the action
+  taken.
+
+Parked domains:
+  Live hosts are matched against the parked entries of the triage rules,
+  which recognise the landing pages and nameservers of Sedo, ParkingCrew,
+  Bodis, Above, ParkLogic, Afternic, GoDaddy, Namecheap, Dan and
+  HugeDomains, and generic "domain for sale" pages. The root domain's
+  nameservers are looked up once per run; the page is fetched for the
+  body markers only when it answers 2xx with no title or one naming the
+  domain. A match sets parked and parked_by, and the host is left out of
+  the remaining enrichers, the interest score and -webhook, -email and
+  -jira notifications unless -include-parked is set. -rules adds
+  providers with entries like
+    - parked: exampleparking
+      any: [{nameserver_contains: exampleparking.net}, {body_contains: parked by exampleparking}]
+  where nameserver_contains is only allowed in parked entries. The
+  summary's parked counts the parked hosts of each root domain.
+
+Probe engine:
+  Names are probed with httpx. When httpx is not found the native engine
+  takes over with a warning, unless -strict-tools makes that fatal; it is
+  chosen outright with -probe-engine native. It GETs each name over HTTPS,
+  then HTTP when HTTPS gets no answer, on every -probe-ports port, without
+  verifying certificates, and reports the status, title, Server header,
+  length and body hash httpx would. -native-timeout bounds each request and
+  -native-max-redirects sets how many redirects it follows (none by
+  default, like httpx). -httpx-threads, -rate-limit, -proxy, -header,
+  -auth-file and -polite apply to it. Its technologies are only those the
+  Server and X-Powered-By headers name and it detects no CDN, so results
+  carry probe_engine to tell the two apart.
+  -probe-batch 50000 sends each httpx (or native engine) at most 50000
+  names. Once a batch is full and another name is waiting, httpx's input is
+  closed; it answers every name it was sent and exits, -o is flushed, and a
+  fresh httpx takes the next batch. That caps httpx's memory on very large
+  scopes, and a run killed mid-way keeps every result written before the
+  last boundary. A batch's httpx that dies is restarted as usual. The
+  summary's probe_batches and -stats' probe.batches count the batches.
+
+Resolvers:
+  Every native DNS lookup (wildcard detection, -brute, -permute, -ptr,
+  -axfr, scope checks, enrichers) goes through one pool of resolvers: a set
+  of public ones (Cloudflare, Google, Quad9, OpenDNS) unless -resolvers
+  lists others, or is "system" for the servers in /etc/resolv.conf. Queries
+  go round-robin; one that times out after -dns-timeout or gets SERVFAIL or
+  REFUSED is retried up to -dns-retries times on the next server. Answers
+  and failures are counted per server as dns.ok.<server> and
+  dns.errors.<server> in -stats and the summary. subfinder, httpx and amass
+  get the same set with -r, less the quarantined servers.
+
+Endpoint rotation:
+  -proxy and -resolvers take several endpoints, e.g. -proxy
+  http://10.0.0.1:3128,http://10.0.0.2:3128 or -resolvers
+  1.1.1.1,9.9.9.9,resolvers.txt, used round-robin. Native requests each
+  take the next proxy, and every httpx, ffuf and WhatWeb invocation is
+  given the next one on its command line. An endpoint that fails
+  -endpoint-failures times in a row (3 by default) is quarantined for
+  -endpoint-cooldown (1m) and then gets one request to prove itself; for a
+  proxy only a failure to reach the proxy counts, for a resolver a timeout,
+  SERVFAIL or REFUSED. When every endpoint is quarantined the stages that
+  need one pause until the first comes back rather than failing each
+  record, with one message. The summary's proxies and resolvers list each
+  endpoint's successes, errors and quarantines; -stats has
+  proxy.ok.<proxy> and proxy.errors.<proxy>. -endpoint-failures 0 turns
+  quarantine off.
+
+Ports:
+  -probe-ports is passed to httpx as -ports and applies to every name. When a
+  port scanner stage also reports open ports, an explicit -probe-ports list
+  wins and discovered ports are not added to the probe.
+  -portscan root (the default) runs nmap against the target name in the
+  background and writes -nmap-output. -portscan hosts waits for probing to
+  finish, then scans each unique IP of the live hosts once, in batches of
+  256, skipping IPs httpx identifies as a CDN. Every host on a scanned IP
+  gets a result with source "portscan" and its open_ports.
+  -portscan-engine masscan scans all IPs in one run and needs -portscan-rate;
+  rates above `+strconv.Itoa(masscanSafeRate)+` packets/s also need -masscan-confirm.
+  -masscan-services runs nmap -sV on just the ports masscan found, adding
+  service, product and version to the same open_ports entries.
+
+IPv6:
+  Hosts are resolved to their A and AAAA records and every address is kept
+  in ips, IPv4 first; ip is the one the host was probed on. -ip-version 4
+  or 6 keeps to one family: other addresses are left out of ips, httpx
+  probes every address of a name (-pa) and only answers over the chosen
+  family are kept, and the native engine only connects over it. IP and URL
+  targets of the other family are refused, and so is a -source-ip that
+  cannot reach it.
+  -portscan hosts scans the address each host was probed on and, for a
+  dual-stack host, its first address of the other family. nmap scans IPv6
+  addresses in runs of their own with -6, naabu is given -iv, and masscan
+  takes both families as they are. -portscan root passes -6 to nmap with
+  -ip-version 6 or an IPv6 target.
+  IPv6 ranges work wherever ranges do: targets, -scope and -exclude-ips.
+  -asn-expand sweeps the IPv6 prefixes of its ASNs along with the IPv4
+  ones, but nearly all are far too large to sweep; those holding more than
+  -asn-expand-max-ips addresses are skipped with a warning instead of
+  refusing the run.
+
+Soft 404s:
+  -soft404 requests a random path on each live host and keeps its status,
+  length and body hash as the host's baseline. A root response with the
+  baseline's status, below 400, and the same hash or a length within 5% (at
+  least 64 bytes) of it is marked soft_404: the host answers everything
+  with that page, so -params does not take form fields and links from it.
+  -dirbrute filters on the same baseline, requested once per host, with or
+  without -soft404. With -polite, hosts httpx identifies as a CDN are not
+  sent the extra request.
+
+Directory brute-forcing:
+  -dirbrute runs ffuf on every live host from the enrichment workers, so at
+  most -workers hosts at a time, each at -rate-limit divided by -workers.
+  When the soft-404 baseline answers with a -dirbrute-status code,
+  responses whose length is within its jitter are filtered out. Hosts httpx
+  identifies as a CDN are skipped. Hits are listed under paths.
+
+Parameter discovery:
+  -params lists under parameters, up to -params-max per host, the query
+  parameter names of the host's URLs the run already found (redirects,
+  -robots sitemap and Disallow entries, -dirbrute hits) and the form fields
+  and same-host links of its page, fetched once. -params-probe then sends
+  up to -params-probe-max names from -params-wordlist, ten per request,
+  each with a random value, and adds those whose value is echoed or that
+  change the status, or the length of a page that is otherwise stable.
+  -params-budget caps the probe requests of the whole run; hosts not
+  flagged login-page or admin-panel stop at half of it. -scope, -polite
+  and -rate-limit apply to every request.
+
+Response matching:
+  -match-regex and the -match-file entries, e.g.
+    - name: express
+      regex: "(?i)x-powered-by: express"
+      part: header
+  are Go regexes run against the response httpx got from each live host,
+  fetched again without following redirects: its headers as "Name: value"
+  lines and the first 100KB of its body. Bodies that are not text (images,
+  archives, ...) by Content-Type and sniffing are skipped. Each pattern
+  matching is listed once under matches with the part it matched and 40
+  bytes of context either side. A pattern that does not compile stops the
+  run before it starts.
+
+Open redirects:
+  -redirect-check takes the redirect-like parameters (redirect, next, url,
+  return_to, ...) of the URLs the run knows for a live host, the same ones
+  -params reads plus the links of its page and the names it found when
+  -params is on, and sends each with https://`+redirectCanaryHost+`/ as
+  its value, once per path and parameter. Redirects are never followed: a
+  Location on the canary host is reported as an open-redirect finding with
+  the request URL as evidence, a page naming the canary next to a meta
+  refresh or a script redirect as open-redirect-possible. At most
+  -redirect-check-max requests go to one host and -redirect-check-budget
+  to the whole run.
+
+Authenticated scanning:
+  -auth-file FILE lists credentials for the hosts behind a login, e.g.
+    - hosts: ["*.portal.example.com", "app.example.com"]
+      headers:
+        Authorization: PORTAL_TOKEN
+      cookies:
+        session: PORTAL_SESSION
+      canary: https://app.example.com/account
+  Header and cookie values name the environment variables holding them;
+  inline values are refused. A host gets the credentials of the first entry
+  whose hosts match it, and no others: native requests are judged one hop
+  at a time, ffuf gets them as -H and WhatWeb as --header without
+  following redirects. httpx probes every host with the same headers, so it
+  never gets them; matching live hosts are fetched again with them and
+  take the status, title, length and body hash of that page, marked
+  authenticated. The values are redacted from stderr, -record captures
+  and -dry-run, which names only the variables. At startup the canary (by
+  default the root of the entry's first exact host) is requested with and
+  without the credentials; a 401 or 403, or the same page both ways, warns
+  that the session has likely expired. The summary lists each entry's
+  hosts, header and cookie names and session_valid under auth.
+
+Fingerprint groups:
+  Hosts whose httpx response had the same body hash, title and
+  technologies are one group, such as the members of a load-balanced
+  application. -fingerprint runs WhatWeb on the first host of a group
+  only; the others wait for it and get its versions and technologies,
+  marked fingerprint_inherited. Hosts without a body hash are never
+  grouped, and when the first host's run yields nothing the others are
+  fingerprinted themselves. The whatweb.inherited counter tells how many
+  runs were saved. -fingerprint-all runs WhatWeb on every host.
+
+Technology names:
+  tech_stack and versions use canonical names, so httpx's "Nginx:1.25.3"
+  and WhatWeb's nginx plugin are one entry, nginx:1.25.3. An embedded table
+  maps WhatWeb plugin and httpx (Wappalyzer) names to them; WhatWeb plugins
+  that are not technologies (Country, Title, UncommonHeaders, ...) are
+  dropped and HTTPServer, X-Powered-By and MetaGenerator contribute the
+  product they name. Unknown names are kept, lower-cased. -tech-aliases
+  adds entries from a JSON object such as {"Acme Portal": "acme-portal",
+  "Noise": ""}.
+  -fingerprint runs WhatWeb unless -fingerprint-engine says otherwise.
+  native needs no external tool: it GETs each live host, following
+  redirects, and matches the headers, cookies, meta tags, HTML and script
+  URLs against an embedded set of Wappalyzer fingerprints (the js, dom and
+  dns patterns need a browser and are ignored). -tech-fingerprints adds a
+  Wappalyzer technologies JSON file or directory, such as a checkout's
+  src/technologies, replacing embedded entries of the same name. both
+  runs WhatWeb, then the native matcher; when they report different
+  versions the more confident one wins, WhatWeb on a tie. With
+  -fingerprint, detection_source names where each technology came from:
+  probe, whatweb or native.
+
+Triage flags:
+  Every enriched result is matched against an embedded ruleset that sets
+  flags such as login-page, admin-panel, dir-listing, default-install and
+  error-page-verbose. -rules adds rules from a YAML list of entries like
+    - flag: grafana
+      all: [{title_contains: grafana}, {status: 200}]
+  matching on title_contains, tech_contains, status, body_hash, header
+  plus regex, header_grade_below, finding (a finding id), host_token,
+  robots_deny_all, environment_in (a list of environments) or
+  access_control_in (a list of -access-control classes), combined with all
+  and any.
+  -webhook-flags admin-panel only notifies about results carrying that flag.
+  Entries with block instead of flag recognise WAF block pages, see WAF
+  blocks; entries with environment classify results, see Environments.
+
+Environments:
+  Every result gets an environment, prod, staging, dev, test, uat or
+  unknown, from the first environment entry of the rules it matches, and
+  environment_evidence naming the conditions that fired, e.g.
+  hostname label "stg". The embedded entries try hostname labels first,
+  then title markers, then a 401 answer or a robots.txt disallowing
+  everything (with -robots) for staging, then body markers such as "do not
+  use in production", which only -env-body fetches the page for. -rules
+  entries come first and can add keywords:
+    - environment: staging
+      any: [{host_token: acc}, {title_contains: acceptance}]
+  The interest score adds the environment weights of rules/score.yaml, and
+  flag rules can key on it to drive notifications:
+    - flag: nonprod-admin
+      all: [{environment_in: [staging, dev, test, uat]}, {title_contains: admin}]
+  with -webhook-flags nonprod-admin, or -filter 'environment != "prod"'.
+
+Access control:
+  -access-control tells apart why a live host keeps visitors out and sets
+  access_control with access_evidence:
+    waf-blocked    the answer is a WAF block page (see WAF blocks)
+    sso-redirect   a redirect leads to an identity provider, by its host
+                   (Okta, Entra ID, Google, Auth0, ...) or a SAMLRequest,
+                   OpenID Connect request or ADFS/Keycloak path; idp_host
+                   names it
+    basic-auth     a 401 asking for Basic or Digest credentials
+    ntlm           a 401 asking for NTLM or Negotiate
+    client-cert    the TLS server requests a client certificate, or the
+                   page says one is required
+    ip-restricted  a 403 whose page names the visitor's address or network
+    unknown        another 400, 401, 403 or 407
+    none           anything else
+  Denied answers are fetched again for their headers and body, and an
+  HTTPS host is sent one more handshake, offering no certificate, to see
+  whether the server asks for one. Flag rules match the classes with
+  access_control_in; -filter 'access_control == "client-cert"' keeps those
+  hosts, and the report breaks the hosts down by class.
+
+Third-party infrastructure:
+  An in-scope name often points at a vendor's servers: a help desk CNAMEd
+  to Zendesk, a landing page on HubSpot, a site behind Fastly. -third-party
+  resolves each live host's CNAME chain (cname_chain) and labels the host
+  third_party with third_party_vendor when a name of the chain ends in a
+  vendor's domain, or else httpx's CDN or the ASN is the vendor's;
+  third_party_evidence says which. The vendors are in rules/third-party.yaml;
+  -third-party-vendors adds entries of the same form, tried first:
+    - vendor: acme-helpdesk
+      cname: [acmehelp.io]
+      asn: [64500]
+  The summary lists the vendors in use with their host counts under
+  third_party_vendors, a map of the estate's suppliers.
+  -skip-third-party-active keeps dirbrute, default-creds and redirect-check
+  from those hosts, which are still probed and passively enriched;
+  third_party.skipped_steps counts what it held back.
+
+Passive DNS:
+  The dnsdb (Farsight DNSDB, DNSDB_API_KEY) and circl (CIRCL passive DNS,
+  CIRCL_PDNS_USER and CIRCL_PDNS_PASSWORD) sources report every name under
+  the domain the services ever saw resolve, current or not. dnsdb pages
+  through a wildcard lookup; circl queries the domain and then the names
+  under it its answers mention. Each spends at most -dnsdb-max-requests or
+  -circl-max-requests calls per domain; a service answering that the quota
+  is used up (HTTP 429 or 402) is not called again for the rest of the run.
+  -historical-ips attaches the addresses each live host resolved to as
+  historical_ips, each with ip, first_seen, last_seen and source, most
+  recently seen first. Hosts the sources did not describe are looked up
+  with whichever services have credentials, within the same budgets.
+  The addresses are never probed or scanned, since they may belong to
+  someone else by now, unless -scan-historical-ips sends those the sources
+  saw to httpx like any discovered name; -scope exclusions still apply.
+
+Findings:
+  Every stage that reports weaknesses writes the same kind of entry under
+  vulnerabilities: id, title, severity (info, low, medium, high or
+  critical), confidence (confirmed, firm or tentative), evidence, reference,
+  stage and detected_at. The CI gate, Jira, JUnit, DefectDojo, the report
+  and the interest score all read these fields. Entries also repeat stage
+  as source, title as summary and each evidence entry at the top level, as
+  schema 1.1 had them; schema -changelog has the migration notes.
+
+Interest score:
+  Every result gets an interest_score: the weights of the keywords in its
+  hostname (admin, vpn, jenkins, staging, ...; cdn and static count
+  against), its status code, its technologies, flags and detected
+  versions, and each finding by severity, added up. The same result always
+  scores the same. -plain and report list the highest scores first and
+  -webhook-min-score notifies only above a threshold. -score-keywords adds
+  keywords; -score-weights takes a YAML file laid out like the embedded
+  weights, for example
+    keywords: {payroll: 30, www: 0}
+    status: {401: 20, 5xx: 0}
+    severity: {high: 50}
+  and replaces the weights it sets.
+
+Security headers:
+  -header-audit lists the HSTS, CSP, X-Frame-Options,
+  X-Content-Type-Options, Referrer-Policy and Permissions-Policy headers of
+  every live host under security_headers and grades them from A to F in
+  header_grade. Missing HSTS or plain HTTP and a missing or inline-script
+  CSP cost the most. Hosts answering 3xx are graded on the page their
+  redirects end on, when it is in scope. The embedded weak-security-headers
+  flag marks grades D and F; header_grade_below: B in a -rules entry
+  matches C and worse.
+
+Cookie audit:
+  -cookie-audit requests the root path of every live host once, without
+  following redirects, and lists the cookies it sets under cookies. Cookies
+  named like session identifiers (session, auth, token, ...sid) that lack
+  HttpOnly, SameSite or, over HTTPS, Secure are reported as
+  session-cookie-flags findings.
+
+Certificate expiry:
+  -cert-check records the certificate of every live HTTPS host under cert:
+  subject, issuer, names, validity and days_remaining. An expired one is a
+  tls-cert-expired finding (-cert-expired-severity, medium by default),
+  one expiring within -cert-expiry-warn (30d) a tls-cert-expiring finding
+  (-cert-expiring-severity, low); the evidence has the issuer and the days
+  remaining. The embedded cert-expired and cert-expiring flags mark them,
+  so -webhook-flags cert-expiring pings whoever renews certificates before
+  they lapse. cert.managed_by names the CDN or cloud that issued or serves
+  the certificate (httpx's CDN, or a Cloudflare or Amazon issuer), which is
+  who has to act. A host with an expired certificate and a title copyright
+  3 or more years old, or a version with known CVEs (-cve-lookup), is
+  marked abandoned_candidate.
+
+DNS audit:
+  -dns-audit checks the root domain (its registrable domain) and lists each
+  check under dns_audit in the summary as pass, fail or unknown, unknown
+  meaning its lookups failed:
+    dnssec                a DS at the parent matches a DNSKEY whose
+                          signature over the key set is valid and current
+    ns-resolves:NS        the nameserver's name resolves; if not, whether
+                          its domain exists at all
+    ns-authoritative:NS   it answers the zone's SOA with authority
+    open-recursion:NS     it refuses to resolve a name outside the zone
+    soa-*                 refresh, retry, expire and minimum are in the RFC
+                          1912 ranges and the nameservers agree on the serial
+  Lookups use -resolvers; only the questions for one nameserver go to it
+  directly. Each check gets 5s. -dns-audit-record also emits the results as
+  a record whose vulnerabilities hold the findings: a nameserver under an
+  unregistered domain (ns-unregistered-domain, high, a delegation takeover),
+  one that does not resolve, answer or serve the zone, an open resolver,
+  and missing or broken DNSSEC.
+
+Dorks:
+  -dorks FILE writes search queries worth running by hand once the run is
+  over: GitHub code search dorks pairing the root domain with secrets
+  (password, filename:.env, ...), its notable hosts and the organisation
+  with its technologies, and Google dorks for exposed files, login pages
+  and error pages on the domain and mentions on GitHub, Pastebin and
+  Trello. Notable hosts are flagged ones and those named like
+  non-production or internal systems (dev, uat, staging, internal, vpn).
+  With GITHUB_TOKEN set, the GitHub dorks are run against the code search
+  API at 10 a minute, its limit, and their hit counts follow each query
+  after a tab; the summary lists the dorks with the most hits.
+
+Asset graph:
+  -graph FILE writes the relationships between the live hosts once the run
+  is over, as GraphML for Gephi, yEd or Maltego, or as Graphviz DOT when
+  FILE ends in .dot or .gv. Nodes are typed domain, subdomain, ip, asn,
+  certificate and technology; edges are subdomain_of, resolves_to (name to
+  IP), announced_by (IP to ASN), covered_by (name to the crt.sh
+  certificates that list it, at most 5 each) and runs (name to
+  technology). Subdomain nodes carry url, status_code, title, cdn, cname,
+  flags, interest_score and technology versions; IP nodes ptr, country and
+  city; ASN nodes org. Certificates need the crtsh source. With -monitor
+  the file is rewritten after each iteration.
+
+Exports:
+  -export-burp FILE writes the live hosts as a Burp Suite target scope, to
+  load from Target > Scope settings > Options > Load: one advanced-mode
+  include rule per scheme, host and port, the host an anchored regex.
+  -export-urls FILE writes their URLs one per line, for other proxies and
+  tools. Both are written once the run is over, the highest interest score
+  first, from the hosts that pass -match-codes and -filter; with -diff they
+  hold every live host, not just the changed ones. -export-flags
+  login-page,admin-panel keeps only the hosts with one of those flags.
+  With -monitor the files are rewritten after each iteration.
+
+Default credentials:
+  -default-creds tries the default logins of the admin interfaces in an
+  embedded table (Jenkins, Grafana, Tomcat manager, RabbitMQ, ActiveMQ,
+  MinIO, SonarQube, Nexus) on live hosts whose tech_stack names the
+  product, and only on those. A login that lets random credentials in first
+  is not tried further. At most -default-creds-max login requests go to one
+  host; -scope and -polite apply to every one. WebLogic, GlassFish and
+  Zabbix lock accounts out and are in -default-creds-deny unless it is
+  overridden. A working pair is reported as a critical default-credentials
+  finding with the status and Location the login answered with. Add
+  -fingerprint for WhatWeb's technologies on top of httpx's.
+
+JARM fingerprints:
+  -jarm runs tlsx -jarm against every live HTTPS host httpx did not
+  identify as a CDN edge and records the fingerprint under jarm. Each host
+  costs ten TLS handshakes, taken from -rate-limit, with a 3s timeout per
+  handshake; they connect directly, as -proxy only covers HTTP.
+  Fingerprints shared by more than one host are listed under jarm_clusters
+  in the summary.
+
+Clustering:
+  -cluster gives every live host a cluster_id shared with the hosts that
+  answered with the same status code and the same -cluster-keys: the
+  normalised title (lower case, numbers blanked), body hash, technologies
+  without versions and, if listed, content length. The status code always
+  counts, so a CDN error page never merges with the application behind it;
+  drop body from -cluster-keys when pages embed per-host content. The
+  summary lists clusters of two or more with their representative, the
+  first member seen. -collapse-clusters emits only the representatives,
+  with cluster_members listing every member, after probing ends; -state
+  and the CI gates still see every result.
+
+Status filters:
+  -match-codes keeps only the listed codes and -filter-codes then removes
+  codes from what is left: -match-codes 4xx -filter-codes 404 emits every
+  4xx but 404. A code cannot be listed in both. Once either is set, results
+  without a status (dead hosts, -emit-unprobed names, port scan, mail and
+  zone transfer records) are dropped unless -include-dead is given.
+  Suppressed results still count towards results.total and
+  results.suppressed[.CODE] in the summary, and -state and the CI gates see
+  every result.
+
+Filter expressions:
+  -filter keeps the results an expression holds for, after the status
+  filters and before the output and every notification sink; -state and
+  the CI gates still see every result. Fields are the JSON names of a
+  result, with dot paths into nested ones; a path through a list, such as
+  vulnerabilities.severity, gives the list of its values, and a field a
+  record leaves out is empty, 0 or false. Operators are == != < <= > >=,
+  =~ and !~ with a quoted regular expression (on a list: any element),
+  in with a list such as [200, 301], && || ! and parentheses. Functions:
+  contains(list or string, value), which matches strings case-insensitively
+  as substrings, len, lower, startswith and endswith. Unknown fields, bad
+  syntax and type mismatches fail at startup. Dropped results count
+  towards results.suppressed.filter. Examples:
+    status_code == 200 && contains(tech_stack, "WordPress")
+    status_code in [401, 403] && !contains(flags, "login-page")
+    contains(vulnerabilities.severity, "critical")
+    len(vulnerabilities) > 0 || interest_score >= 50
+    subdomain =~ "^(dev|staging|test)[.-]"
+    title =~ "(?i)index of /" || contains(flags, "dir-listing")
+    tech_stack =~ "^(Jenkins|GitLab|Grafana)"
+    country != "" && !(country in ["US", "GB"])
+    change_type in ["new", "changed"]
+    !known && owner == "unassigned"
+    cdn == "" && len(open_ports) > 2
+    header_grade in ["D", "F"] && !blocked
+
+Scope:
+  -scope takes a program scope file, one rule per line:
+    *.example.com          every name below example.com
+    shop.example.com       that name only
+    !staging.example.com   exclusion; exclusions win over allow rules
+    !10.0.0.0/8            exclude names that resolve into the range
+  Passive discovery still sees every name, but nothing outside the scope is
+  probed, fingerprinted, port scanned or followed by -follow-redirects.
+  Without allow rules everything not excluded is in scope. The summary's
+  scope_drops counts what each rule kept out.
+  -exclude-ips 203.0.113.0/24,2001:db8::/32,more-ranges.txt keeps in-scope
+  names away from address ranges. Each discovered name is resolved (A and
+  AAAA) before probing; those with an address in a range are emitted once,
+  with probe_skipped saying which address and range, and never probed. A
+  name with addresses both inside and outside is excluded too, unless
+  -probe-mixed-ips. A name whose lookup fails (a timeout or SERVFAIL, not
+  NXDOMAIN) is emitted with probe_skipped too, since the prober might
+  resolve it into a range, unless -probe-unresolved; the counter
+  admit.unresolved counts them. The same lookup serves the scope's
+  address rules, 32 names at a time beside probing. The address a host
+  was probed on is checked again before enrichment, in case its
+  resolution changed: a host that moved into a range is emitted with
+  probe_skipped and not enriched. Addresses
+  in the ranges are never port scanned, swept or vhost probed. The
+  summary's ip_exclusions counts the names and addresses each range kept
+  out.
+
+ASN sweep:
+  -asn-expand scans IP space directly, so it only runs on AS numbers you
+  list and confirm the target owns; cloud, hosting and CDN ASNs are refused.
+  Their announced prefixes are looked up on RIPEstat before the run starts
+  and the run is refused if they hold more than -asn-expand-max-ips
+  addresses; an IPv6 prefix that large on its own is skipped with a
+  warning instead. Once the pipeline finishes, every address not already
+  seen behind a subdomain is probed with httpx and answers are emitted with
+  source "asn-sweep", an empty subdomain and the address under ip.
+
+Virtual hosts:
+  -vhost finds applications only reachable at an address with the right
+  Host header. Once the pipeline finishes, each address live hosts resolved
+  to is requested on the scheme and ports they answered on, first with the
+  address itself as the Host header, then with every in-scope name
+  discovery found that was not probed there by DNS, names that answered
+  nowhere first, up to -vhost-max-pairs pairs. Results carry source "vhost"
+  and a vhost object with the Host sent and one of:
+    default_vhost     the address's own page, unlike any of its names'
+    hidden_vhost      a name that answered nowhere by DNS
+    differs_from_dns  another page than the name's own probe, such as a
+                      staging vhost on a production address
+    origin_exposed    the page of a CDN-fronted name, served directly
+  Answers identical to the address's default page are dropped. CDN
+  addresses are skipped unless -vhost-cdn is set. Certificates are not
+  verified, since the address's rarely names the Host asked for.
+
+Organizations:
+  -org "Acme Corp" takes the place of a target. Root domains are gathered
+  from crt.sh certificates whose subject O is the organization and, when
+  WHOISXML_API_KEY is set, WhoisXML reverse WHOIS. Each is then checked
+  against its RDAP/WHOIS registrant org and, with -asn-db, whether its apex
+  is announced by an ASN whose description names the organization; those
+  ASNs are listed too. Every candidate is printed with the evidence linking
+  it and a low, medium or high confidence, and nothing is scanned until you
+  pick domains at the prompt (all, none, or numbers like 1,3-5).
+  -org-auto skips the prompt and scans the medium and high confidence
+  domains. Confirmed domains are scanned one after the other into the same
+  output, or -parallel-domains at a time: each runs its own discovery
+  sources, while the dedupe store, -workers budget, rate limits and output
+  are shared. A domain whose scan fails is recorded and the others go on.
+  The summary lists them with their evidence under org_domains and their
+  results, live hosts, findings, duration and any error under domains.
+  -org cannot be combined with -monitor, -state, -diff, -dorks, -record,
+  -replay or -dry-run.
+
+IP and URL targets:
+  An address or CIDR range (10.0.0.5, 10.0.0.0/24, 2001:db8::/120) is
+  scanned without discovery: its addresses go straight to httpx, leaving out
+  the network and broadcast addresses of IPv4 ranges and whatever -scope
+  excludes. Ranges holding more than
+  -max-ips addresses are refused. With -portscan hosts the addresses are port
+  scanned first and httpx probes ip:port for each open port; with the default
+  root mode nmap gets the same addresses. Results carry the address in ip and
+  its PTR name, when it has one, in subdomain.
+  An http or https URL (https://app.example.com:8443/login) is probed as
+  given, with its port and path, and only enriched; -probe-ports does not
+  apply. The result's url keeps the path and its subdomain is the URL's host.
+  The discovery flags (-sources, -deep, -brute, -axfr, -recursive, -permute,
+  -ptr), -mail-check, -whois and -dns-audit need a domain; for URL targets
+  -whois and -dns-audit look at the URL host's registrable domain.
+
+Artifacts:
+  Each run writes its nmap report, summary.json and events.ndjson to
+  -workdir, by default ~/.recon-engine/runs/<run-id>/, unless -nmap-output,
+  -summary-file or -events/-events-file send them elsewhere. The directory is
+  in the summary's workdir. -keep-artifacts=false deletes it when the run
+  completes; an interrupted run or a failed -upload keeps it.
+  -keep-raw also keeps what httpx, amass and WhatWeb wrote, line by line
+  (WhatWeb's output per URL), gzipped under raw/ with one file per tool.
+  Each result's raw_ref names the records it came from, as
+  raw/httpx.jsonl.gz:42 (the 42nd record). Once -keep-raw-max bytes are
+  kept the rest is dropped; the summary's raw has the records, bytes and
+  compressed bytes kept and how many were dropped.
+  'recon-engine reprocess <workdir>' rebuilds the results from those records with
+  the current engine, without touching the network.
+  -archive-bodies keeps the response body of each host that ends up with
+  flags, findings or -match-regex matches, since the page may have changed
+  by the time anyone looks: the page is fetched again once the flags are
+  set, without following redirects, and its first -archive-max bytes are
+  gzipped to bodies/<host>_<port>/<sha256>.gz, named in body_archive (the
+  gzip comment holds the URL). A body seen before is not stored twice.
+  Bodies of unflagged hosts are never kept unless -archive-all asks for
+  every live host's, for small scopes. Bodies that are not text are skipped
+  unless -archive-types names their media type (application/pdf, image/*).
+
+Record and replay:
+  -record DIR runs every external tool through the engine's own executable,
+  which passes its output on and keeps a copy, and saves every native HTTP
+  response (API sources and enrichers) along with it; DNS lookups are not
+  recorded. -replay DIR then runs the whole pipeline against those captures
+  without running a tool or sending a request, so the same flags give the
+  same results and processing flags can be changed to narrow a bug down. A
+  command is matched on its arguments, -workdir and temporary paths
+  aside; one that was not recorded fails, and so does a request, such as
+  the random probes of -soft404, -dirbrute or -params. Both turn the cache
+  off.
+  -record-redact replaces the target and its hostnames in the captures with
+  pseudonyms under `+redactedDomain+`, the target to replay the bundle with.
+
+Tool output checks:
+  Every line of tool output is checked before it is used. Invalid UTF-8 is
+  replaced, ANSI escapes and control characters are stripped from titles,
+  technologies and other text, overlong strings are cut (titles at 512
+  characters) and impossible numbers clamped or dropped, such as a
+  negative content length or a malformed address or body hash; such a line
+  counts as repaired. A line that does not parse or lacks what it is for,
+  such as an httpx line without input, a status code outside 0-599 or a
+  discovered name that is not a DNS name, is rejected: it yields no result
+  and is an error event of kind parse_failed or invalid. The summary's
+  tool_lines and -stats' tools.<tool>.rejected and tools.<tool>.repaired
+  count both per tool. The report subcommand cleans text the same way
+  and escapes it for HTML and Markdown.
+
+Cache:
+  WhatWeb output is cached per URL and WhatWeb options in -cache and reused
+  for -cache-ttl, so rescans within a day skip WhatWeb for known URLs.
+  Entries record the WhatWeb version and are ignored after an upgrade.
+  Entries are written to a temporary file and renamed, so concurrent runs
+  can share the directory. -no-cache always runs WhatWeb. CVE lookups keep
+  their own -cve-cache.
+
+Credentials:
+  API keys and other credentials (CENSYS_API_ID, CENSYS_API_SECRET,
+  SECURITYTRAILS_API_KEY, CHAOS_API_KEY, VT_API_KEY, DNSDB_API_KEY,
+  CIRCL_PDNS_USER, CIRCL_PDNS_PASSWORD, NVD_API_KEY, GITHUB_TOKEN,
+  JIRA_API_TOKEN, SMTP_PASSWORD, KAFKA_SASL_PASSWORD) are read
+  from the environment or from -keys-file, NAME=value per line; the
+  environment wins.
+  Their values, and the -proxy password, are replaced with REDACTED in
+  everything written to stderr, in the -dry-run plan, in error events and
+  in the run summary, whose api_keys only tells which services have a key.
+
+Docker:
+  -use-docker runs every required tool that is missing from PATH as
+  docker run --rm -i --init --network host IMAGE, with stdin and stdout
+  wired as for the native binary; tools found on PATH or set with
+  -<tool>-bin still run natively. Images are pinned per tool (subfinder,
+  httpx, naabu, amass, nmap, whatweb, tlsx); -docker-images tool=image,...
+  overrides them and supplies ones for ffuf and masscan. Only the
+  directories of files a tool must read or write, such as -nmap-output,
+  are mounted. The daemon is checked and missing images are pulled before
+  the run. Not available on Windows.
+
+Exit codes:
+  0    completed, no gate tripped
+  1    the run failed once started, e.g. a tool or the output broke
+  2    -fail-on-severity: a vulnerability at or above the severity was found
+  3    -fail-on-new-subdomains: -diff found at least N new subdomains
+  4    -upload: the output could not be uploaded (a tripped gate wins)
+  5    -email-required: the report could not be emailed (a tripped gate
+       or a failed upload wins)
+  6    the run could not start: an invalid flag or target, a missing or
+       too old tool, or a setup step (workdir, Kafka, Redis, ...) failed.
+       Missing and too old tools are reported as a JSON object on stderr.
+  130  interrupted by SIGINT/SIGTERM
+  When both gates trip the exit code is 2; the summary's gates_tripped
+  lists every gate that tripped.
+
+httpx:
+  The engine expects httpx v`+minHttpxVersion+` or newer. -httpx-args is split on
+  spaces (quotes group words) and appended after the engine's own options;
+  options the engine sets or parses itself, such as -json, are rejected.
+
+Outputs:
+  Every emitted record goes to each configured sink: stdout or -o, the TUI,
+  -webhook-url, -kafka-topic, -redis-url, -jira-url and -email-to. Each sink
+  has its own queue, so a slow one only holds up itself. Failures are logged
+  and counted per sink under sinks in the run summary.
+  Each record reaches the -o file in one write, and a -compress file is
+  flushed every -flush-every records, so a run that is killed leaves at
+  most a partial last record behind. The file is synced to disk every
+  -sync-interval, after each -monitor iteration and at exit. report, diff
+  and -diff skip a partial last record, and read a JSON array that was cut
+  off up to its last whole element, with a warning.
+  -fields subdomain,status_code,title,ip,tech_stack trims the JSON results
+  of stdout or -o, the webhook, Kafka and Redis to those fields, and
+  -exclude-fields drops the ones named. Nested fields take dot paths, such
+  as whois.registrar or vulnerabilities.id, which applies to every
+  finding. Unknown names fail at startup; the schema subcommand lists
+  them. The -state file always keeps the full records, so later diffs see
+  every field, but -diff against a trimmed -o file only compares what it
+  kept.
+  -handshake writes one {"type": "handshake", ...} record to stdout or -o
+  before anything else, with engine_version, schema_version, run_id,
+  targets and stages (as -dry-run names them), so an orchestrator can
+  check it understands the output before results flow. Startup errors
+  never reach stdout: they go to stderr and exit 6.
+  amass often reports a name's ASN and Org after httpx probed it. Results
+  still waiting for amass are checked again once discovery ends, and those
+  it described late are counted as infra.late. -emit-updates also writes,
+  after the results, an {"type": "update", ...} record for each to stdout
+  or -o, with run_id, root_domain, subdomain and url as the key and the
+  new asn and org under set. It needs JSON output. Only stdout or -o gets
+  update records: webhooks, Kafka, Redis, Jira, email and the TUI see
+  just the results, and -fields does not apply to them.
+
+Encryption at rest:
+  -encrypt-output env:NAME encrypts the -o file, the -state file, the
+  -keep-raw records and the -archive-bodies bodies as they are written, and report -encrypt-output the
+  report, with AES-256-GCM and the 32-byte key in $NAME, as 64 hex digits
+  or base64 (openssl rand -hex 32). Files are written in chunks of at most
+  64 KiB, flushed at the same points as -compress, so memory stays bounded
+  and a killed run's file decrypts up to its last whole chunk; -compress
+  compresses before encrypting. The files name the variable, never the
+  key. resume, report, diff, known, reprocess and -diff read encrypted
+  files when that variable holds the key, and decrypt writes one out in
+  the clear. Sinks that leave the host (webhook, Kafka, Redis, Jira,
+  email, -upload) and the other local files (-events-file, -graph,
+  -export-*, screenshots, nmap's report) are not encrypted.
+
+Monitoring:
+  -monitor reruns the scan every -interval (plus or minus -jitter) and only
+  prints new, changed and removed hosts, as -diff does. With -state the last
+  completed iteration is kept on disk and used as the baseline after a
+  restart. A failed iteration is logged and the next one runs on schedule.
+  The first SIGINT/SIGTERM finishes the running iteration, a second aborts.
+  -webhook-url, -kafka-topic and -redis-url receive every emitted record
+  (-webhook-flags narrows the webhook to flagged ones); -jira-url files
+  each finding once.
+
+Run locks:
+  Two runs sharing a -state file or a -workdir would overwrite each other's
+  work, so a run locks <state>.lock and <workdir>/.recon-engine.lock (an
+  advisory OS lock, gone with the process however it ends) and records its
+  PID, host, run ID, command and start time there. A second run fails at
+  once, naming the holder and since when it has held the lock; -wait-lock
+  10m queues it behind the first for up to that long instead. A lock whose
+  holder ran on this host and is no longer running, but which a process it
+  started still holds, is taken over with -force-lock; a holder on another
+  host is never assumed dead. -monitor holds the locks only while an
+  iteration runs, rereading -state each time, so other runs fit in between;
+  an iteration that cannot get them within -wait-lock is skipped. -dry-run
+  takes no locks.
+
+Screenshots:
+  -screenshots renders each live host with headless Chromium (-chromium-bin)
+  at 1280x800, at most -screenshot-rate a second, into
+  -screenshot-dir/<host>_<port>/, keeping the last -screenshot-keep per
+  host. Each screenshot gets a 64-bit perceptual hash (screenshot_hash).
+  Against -diff, -state or the previous -monitor iteration, a hash more
+  than -visual-threshold bits away is a visual change: the record gets
+  visual_change with the previous and current screenshot and the distance,
+  "visual-change N/64" in changes, and change_type visual-change when
+  nothing else changed. The report pairs the two screenshots side by side,
+  the emailed report attaches them and Slack messages name them.
+  Clocks and carousels flip a few bits; regions that change more, such as
+  a news ticker, are left out of the hash with -visual-ignore:
+    - host: "*.example.com"     # glob, every host when left out
+      regions:
+        - [0, 0, 1280, 90]      # x, y, width, height in pixels
+
+OpenTelemetry:
+  -otel-endpoint exports a trace of the run to an OTLP collector, over
+  OTLP/HTTP (http://collector:4318, posting to /v1/traces unless the URL
+  has a path) or OTLP/gRPC (grpc://collector:4317, or grpcs:// for TLS).
+  OTEL_EXPORTER_OTLP_HEADERS adds headers such as an API key. The run is
+  the root span, with a pipeline span per target and under it a span per
+  discovery source (with the names it found), one for probing (with the
+  probe's lines and restarts) and one per enricher for every 100 hosts.
+  Spans carry the target, counts and tool exit codes; tool errors and
+  timeouts are events on the root span. -otel-sample 0.1 traces one run in
+  ten; a TRACEPARENT in the environment makes the run part of the caller's
+  trace, whose sampling decision wins. Spans are sent in batches and the
+  last ones flushed on exit, signals included, waiting at most 5s. -stats
+  counts otel.spans, otel.dropped and otel.export_errors. Without
+  -otel-endpoint nothing is traced.
+
+Asset history:
+  -state also remembers every subdomain it has seen: first_seen, the last
+  run it answered (last_seen) with its status and technologies, and how
+  many runs it has not answered since. Results carry first_seen and
+  last_seen, and a subdomain silent for more than -stale-after runs is
+  reported once as a stale record. Only completed, uncapped runs count
+  towards that. A state file that fails to parse or has impossible dates is
+  refused rather than guessed at; one from before the history (version 1)
+  starts it with this run.
+
+Shared probe state:
+  -state-backend records when each name was last probed and what the probe
+  found, in a store runs on other machines and of other teams share:
+  redis://host:6379/0 (rediss:// for TLS), a directory, file:///srv/recon,
+  or a SQLite database, sqlite:///srv/recon-state.db, which needs no server
+  but a filesystem SQLite can lock, so a local disk rather than NFS.
+  A name probed less than -min-probe-interval ago (24h by default), by any
+  of them, is not probed again: its results are emitted as that run found
+  them, with cached_at set to when, and the summary counts the names under
+  probes_skipped. -force probes everything and refreshes the store. A name
+  is claimed when it is queued, so runs going at once probe it only once;
+  Redis changes it in one script, the directory under a lock file per name
+  and SQLite in a transaction. The claims of names an interrupted or
+  truncated run never got an answer for are given back. When the store
+  fails the name is probed, and state_backend.errors counts it.
+
+Upload:
+  -upload stores the -o file (or, without -o, everything written to stdout
+  as results.ndjson), summary.json, the -nmap-output report and the
+  -events-file under <prefix>/<YYYY-MM-DD>/<run-id>/, also after
+  SIGINT/SIGTERM. S3 uses the AWS credential chain (env, shared config,
+  instance role; the region comes from AWS_REGION or EC2 metadata), gs://
+  the Google application default credentials. Every upload is retried with
+  backoff.
+  -upload-partial-interval re-uploads the output while the run is going; a
+  -compress file is flushed first so the partial copy decompresses.
+
+Kafka:
+  -kafka-brokers/-kafka-topic publish each result as JSON keyed by subdomain.
+  The topic must be reachable at startup. Failed deliveries are retried and
+  then logged; the producer is flushed before the engine exits.
+  KAFKA_TLS=1, KAFKA_TLS_CA and KAFKA_TLS_INSECURE=1 configure TLS;
+  KAFKA_SASL_MECHANISM (plain, scram-sha-256, scram-sha-512) with
+  KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD configure SASL.
+
+Redis:
+  -redis-url pushes each result as JSON onto -redis-key, with LPUSH or, with
+  -redis-mode stream, XADD (fields subdomain and result). The server must
+  answer at startup. A record Redis keeps refusing is retried with backoff
+  and then appended to -redis-spool instead of being dropped.
+
+Jira:
+  -jira-url/-jira-project file one issue per finding at or above
+  -jira-min-severity, labelled recon-engine, the root domain and
+  recon-<key>, a hash of the finding id and host. Before filing, the key is
+  looked up in -jira-cache and then in Jira by its label, so re-runs and
+  other machines do not file duplicates. JIRA_API_TOKEN is sent as a
+  bearer token (Server/Data Center) or, with JIRA_EMAIL, as Jira Cloud basic
+  auth. Failed requests are retried with backoff; issues that still fail
+  are counted in the summary (jira.failed, tool_errors) and never hold up
+  results.
+
+Known assets:
+  -known known.txt lists hosts already triaged, one per line. Their results
+  carry known: true and stay out of -webhook-url, Jira, -email-to and the
+  -fail-on-* gates; -hide-known leaves them out of the output as well,
+  while results.known and results.suppressed.known still count them. With
+  -diff, -state or -monitor a known host that changed (a new status code,
+  technology or finding) or went away is reported as usual.
+  'recon-engine known add -file known.txt results.ndjson' appends the live
+  hosts of a run's output that the file does not list yet.
+
+Owners:
+  -owners owners.yaml tags every result with the team owning its host, in
+  owner and owner_contact:
+
+    owners:
+      - name: payments
+        contact: payments-sec@example.com
+        channel: "#payments-sec"
+        webhook: https://hooks.slack.com/services/...
+        patterns: ["checkout.example.com", "*.pay.example.com", "re:^api-pay[0-9]+\\."]
+    unassigned:
+      contact: secops@example.com
+
+  A pattern is a host name, a glob, or a regular expression after re:,
+  all case-insensitive. When several match, the most specific wins: a host
+  name over a glob over a regular expression, then the glob with more
+  characters that are not wildcards or the longer regular expression, then
+  the pattern listed first. Hosts no pattern matches belong to
+  "unassigned". A result goes to its owner's webhook instead of
+  -webhook-url, which still gets those of owners without one, and Jira
+  issues are labelled owner-<name>. The summary's owners counts each
+  owner's results and findings by severity.
+
+Email:
+  -email-to sends one message to each comma-separated address when the run
+  ends, also after SIGINT/SIGTERM: the run summary as text and the HTML
+  report (as the report command renders it) attached, plus the results as
+  gzipped NDJSON with -email-results unless they exceed -email-max-results.
+  -smtp-port 465 connects over TLS; other ports upgrade with STARTTLS when
+  the server offers it. SMTP_USERNAME and SMTP_PASSWORD authenticate, which
+  needs TLS unless the server is localhost. A failed delivery is logged and
+  only changes the exit code with -email-required.
+`)
+}
+
+// requiredBinaries lists the external tools a run with these sources and
+// flags starts
+func requiredBinaries(sources []string) []string {
 	// nmap is allowed to be missing in some envs if only running partial, but let's check all as per requirement
 	// Actually, if flags are off, we might not strictly need them, but for simplicity check all or just warn.
 	// Requirement: "Add amass and whatweb to the bins slice"
-	bins := []string{"subfinder", "httpx", "nmap"}
-	if useDeep {
-		bins = append(bins, "amass")
+	bins := portscanBinaries()
+	if probeEngine == probeEngineHttpx {
+		bins = append([]string{"httpx"}, bins...)
+	}
+	for _, name := range sources {
+		if bin, ok := sourceBinaries[name]; ok {
+			bins = append(bins, bin)
+		}
 	}
-	if useFingerprint {
+	if whatwebFingerprinting() {
 		bins = append(bins, "whatweb")
 	}
+	if dirBrute {
+		bins = append(bins, "ffuf")
+	}
+	if jarmFlag {
+		bins = append(bins, "tlsx")
+	}
+	if screenshotsFlag {
+		bins = append(bins, "chromium")
+	}
+	return bins
+}
 
+func checkBinaries(sources []string) {
+	bins := requiredBinaries(sources)
+	warnUnboundTools(bins)
+	// The recording answers for the tools
+	if replayDir != "" {
+		checkToolVersions(bins)
+		return
+	}
 	for _, bin := range bins {
-		if _, err := exec.LookPath(bin); err != nil {
+		path := toolPath(bin)
+		if _, err := exec.LookPath(path); err != nil {
+			// -<tool>-bin overrides are never replaced by a container
+			if useDocker && path == bin && containerize(bin) {
+				continue
+			}
 			errRes := map[string]string{
 				"error":   fmt.Sprintf("Missing binary: %s", bin),
 				"message": "Please install required tools in PATH",
 			}
-			json.NewEncoder(os.Stdout).Encode(errRes)
-			os.Exit(1)
+			if slices.ContainsFunc(goTools, func(t goTool) bool { return t.name == bin }) {
+				errRes["message"] = fmt.Sprintf("Run '%s install' or put %s in PATH", os.Args[0], bin)
+			}
+			if path != bin {
+				errRes["error"] = fmt.Sprintf("Binary for %s is not executable: %s", bin, path)
+				errRes["message"] = fmt.Sprintf("Check -%s-bin / %s", bin, toolEnv(bin))
+			}
+			if useDocker && path == bin {
+				errRes["message"] = fmt.Sprintf("No image for %s, set one with -docker-images %s=IMAGE", bin, bin)
+			}
+			startupFailure(errRes)
+		}
+	}
+	if len(containerized) > 0 {
+		if err := checkDocker(); err != nil {
+			startupFailure(map[string]string{
+				"error":   err.Error(),
+				"message": "Start the docker daemon or install the missing tools",
+			})
 		}
 	}
+	checkToolVersions(bins)
 }
 
 func extractTech(h HttpxResult) []string {
-	var techs []string
-	techs = append(techs, h.Tech...)
-	return techs
+	return newTechSet(h.Tech).Stack()
+}
+
+// urlPort returns the explicit or scheme-default port of a probed URL
+func urlPort(raw string) int {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0
+	}
+	if p := u.Port(); p != "" {
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	switch u.Scheme {
+	case "https":
+		return 443
+	case "http":
+		return 80
+	}
+	return 0
+}
+
+// responseTimeMs converts httpx's duration string (e.g. "152.3ms", "1.2s")
+// to milliseconds, returning 0 when absent or unparseable
+func responseTimeMs(s string) float64 {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0
+	}
+	return float64(d) / float64(time.Millisecond)
 }
 
+// validatePorts checks a comma-separated port list such as -probe-ports
+func validatePorts(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, p := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", p)
+		}
+	}
+	return nil
+}
+
+// fatalError reports a failure of a run that already started and exits 1
 func fatalError(msg string, err error) {
 	fmt.Fprintf(os.Stderr, "Error: %s: %v\n", msg, err)
-	os.Exit(1)
+	exit(1)
+}
+
+// startupError reports why a run cannot start, such as an invalid flag or
+// a setup step that failed, and exits with exitStartupFailed
+func startupError(msg string, err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s: %v\n", msg, err)
+	exit(exitStartupFailed)
+}
+
+// startupFailure writes a structured error object to stderr, where it
+// cannot be taken for a result, and exits with exitStartupFailed
+func startupFailure(errRes map[string]string) {
+	json.NewEncoder(os.Stderr).Encode(errRes)
+	exit(exitStartupFailed)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// mainArgsEnv makes the test binary run main with these arguments, one per
+// line, instead of the tests
+const mainArgsEnv = "RECON_TEST_MAIN_ARGS"
+
+// TestMain lets tests run the test binary as the engine: as a whole run
+// with runMain, and as the shims -record, -replay and bench start in place
+// of the external tools, which run the engine's own executable
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(mainArgsEnv); ok {
+		// Not for the processes the run starts, which may be this binary
+		os.Unsetenv(mainArgsEnv)
+		os.Args = append([]string{"recon-engine"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	if os.Getenv(tapeEnv) != "" || os.Getenv(replayEnv) != "" {
+		main() // exits
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the engine with args in dir, with env added to the
+// environment, and returns its exit code, stdout and stderr
+func runMain(t *testing.T, dir string, env []string, args ...string) (int, string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the runs use shell script tools")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), mainArgsEnv+"="+strings.Join(args, "\n")), env...)
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return code, stdout.String(), stderr.String()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// matchBodyMax is how much of the body the patterns see
+	matchBodyMax = 100 << 10
+	// matchContext is how many bytes of context evidence keeps on each side
+	// of a match
+	matchContext = 40
+)
+
+// Match is a -match-regex or -match-file pattern found in a host's response
+type Match struct {
+	Pattern  string `json:"pattern"`  // the entry's name, or the regex itself
+	Part     string `json:"part"`     // body or header
+	Evidence string `json:"evidence"` // the match with some context, on one line
+}
+
+// matchPattern is one compiled pattern. Part is body, header or all.
+type matchPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+	Part  string `yaml:"part"`
+
+	re *regexp.Regexp
+}
+
+// regexFlags collects repeated -match-regex flags
+type regexFlags []string
+
+func (r *regexFlags) String() string { return strings.Join(*r, ", ") }
+
+func (r *regexFlags) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// matchPatterns are the -match-regex patterns followed by the -match-file
+// entries
+var matchPatterns []matchPattern
+
+// matchHTTP refetches the response httpx probed. Like httpx it does not
+// follow redirects, so a Location header can match too.
+var matchHTTP = func() *http.Client {
+	c := newHTTPClient(15*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// configureMatch compiles -match-regex and -match-file. A pattern that does
+// not compile stops the run, naming it. Called once after flag parsing.
+func configureMatch() error {
+	for _, expr := range matchRegexes {
+		p := matchPattern{Name: expr, Regex: expr, Part: "all"}
+		if err := p.compile(); err != nil {
+			return err
+		}
+		matchPatterns = append(matchPatterns, p)
+	}
+	if matchFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(matchFile)
+	if err != nil {
+		return err
+	}
+	var entries []matchPattern
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %w", matchFile, err)
+	}
+	for i, p := range entries {
+		if p.Regex == "" {
+			return fmt.Errorf("%s: entry %d has no regex", matchFile, i+1)
+		}
+		if p.Name == "" {
+			p.Name = p.Regex
+		}
+		if err := p.compile(); err != nil {
+			return fmt.Errorf("%s: %w", matchFile, err)
+		}
+		matchPatterns = append(matchPatterns, p)
+	}
+	return nil
+}
+
+func (p *matchPattern) compile() error {
+	switch p.Part {
+	case "":
+		p.Part = "all"
+	case "all", "body", "header":
+	default:
+		return fmt.Errorf("pattern %q: part must be body, header or all, got %q", p.Name, p.Part)
+	}
+	re, err := regexp.Compile(p.Regex)
+	if err != nil {
+		return fmt.Errorf("pattern %q: %w", p.Name, err)
+	}
+	p.re = re
+	return nil
+}
+
+// matchResponse fetches a live host's page and records in res.Matches the
+// first place each pattern matches its headers (as "Name: value" lines) or
+// the first matchBodyMax bytes of its body. Bodies that are not text, going
+// by Content-Type and by sniffing, are left out.
+func matchResponse(ctx context.Context, res *Result) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, res.URL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := matchHTTP.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, matchBodyMax))
+	if !textual(resp.Header.Get("Content-Type"), body) {
+		body = nil
+	}
+	headers := headerLines(resp.Header)
+
+	for _, p := range matchPatterns {
+		if p.Part != "body" {
+			if m, ok := p.find(headers); ok {
+				res.Matches = append(res.Matches, Match{Pattern: p.Name, Part: "header", Evidence: m})
+				continue
+			}
+		}
+		if p.Part != "header" && body != nil {
+			if m, ok := p.find(body); ok {
+				res.Matches = append(res.Matches, Match{Pattern: p.Name, Part: "body", Evidence: m})
+			}
+		}
+	}
+}
+
+// find returns the first match of p in b with matchContext bytes on either
+// side, whitespace collapsed
+func (p *matchPattern) find(b []byte) (string, bool) {
+	loc := p.re.FindIndex(b)
+	if loc == nil {
+		return "", false
+	}
+	start, end := max(loc[0]-matchContext, 0), min(loc[1]+matchContext, len(b))
+	// Do not cut a multi-byte character in two
+	for start > 0 && !utf8.RuneStart(b[start]) {
+		start--
+	}
+	for end < len(b) && !utf8.RuneStart(b[end]) {
+		end++
+	}
+	return strings.Join(strings.Fields(strings.ToValidUTF8(string(b[start:end]), "")), " "), true
+}
+
+// headerLines renders h as sorted "Name: value" lines
+func headerLines(h http.Header) []byte {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		for _, v := range h[name] {
+			b.WriteString(name + ": " + v + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// textual reports whether a body is worth matching: its Content-Type, when
+// it has one, and its sniffed type both say text or a format that is text
+// underneath (JSON, XML, JavaScript)
+func textual(contentType string, body []byte) bool {
+	if contentType != "" && !textType(contentType) {
+		return false
+	}
+	return textType(http.DetectContentType(body))
+}
+
+func textType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "json") || strings.HasSuffix(mt, "xml") ||
+		strings.Contains(mt, "javascript")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// runMonitor reruns the pipeline every -interval until stop is closed,
+// emitting only what changed since the previous iteration. The baseline for
+// the first iteration comes from -state, falling back to -diff.
+func runMonitor(ctx context.Context, stop <-chan struct{}, cmd, target string, sources []string, baseline *diffBaseline) {
+	// The sinks outlive the iterations
+	sinks := newResultSinks(ctx, newResultEncoder(stdout), nil)
+	defer sinks.Close()
+	for iteration := 1; ; iteration++ {
+		// The -state and -workdir locks are held only while scanning, so
+		// other runs can use them between iterations. The state is read
+		// under the lock, as such a run may have replaced it.
+		var history *seenHistory
+		err := acquireRunLocks(cmd, target)
+		if err == nil && statePath != "" {
+			var prev []Result
+			prev, history, err = loadState(statePath, target)
+			if err != nil && iteration == 1 {
+				startupError("Failed to load -state", err)
+			}
+			if prev != nil {
+				baseline = newBaseline(prev)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Monitor iteration %d skipped: %v\n", iteration, err)
+		} else {
+			current, err := monitorIteration(ctx, target, sources, baseline, history, sinks)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Monitor iteration %d failed: %v\n", iteration, err)
+			} else {
+				// Only a completed iteration replaces the baseline, so hosts
+				// are not reported as removed because a run was cut short
+				baseline = newBaseline(current)
+				if statePath != "" {
+					if err := saveState(statePath, target, current, history); err != nil {
+						fmt.Fprintf(os.Stderr, "Error writing -state: %v\n", err)
+					}
+				}
+			}
+		}
+		releaseRunLocks()
+
+		wait := jitter(monitorInterval, monitorJitter)
+		fmt.Fprintf(os.Stderr, "Monitor iteration %d done, next run in %s\n", iteration, wait.Round(time.Second))
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// monitorIteration runs the pipeline once and returns every Result it
+// produced, stamped from history when there is one. A panic inside the
+// iteration is turned into an error so the monitor loop keeps going.
+func monitorIteration(ctx context.Context, target string, sources []string, baseline *diffBaseline, history *seenHistory, sinks *resultSinks) (current []Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	resetRunState()
+	rollAPIBudgets()
+	if history != nil {
+		history.startRun()
+	}
+	stats.Reset()
+	bruteStarted.Store(0)
+	resetToolErrors()
+	resetToolLines()
+	collapser := newClusterCollapser()
+	write := func(res Result) {
+		if !filterResult(res) || collapser.Hold(res) {
+			return
+		}
+		sinks.Emit(res)
+	}
+
+	err = runPipeline(ctx, target, sources, func(res Result) {
+		if history != nil {
+			history.Stamp(&res)
+		}
+		current = append(current, res)
+		observeExport(res)
+		if baseline == nil {
+			res.ChangeType = changeNew
+			write(res)
+			return
+		}
+		if baseline.Classify(&res) {
+			write(res)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if baseline != nil && len(trippedCaps()) == 0 {
+		for _, res := range baseline.Removed() {
+			write(res)
+		}
+	}
+	if history != nil && len(trippedCaps()) == 0 {
+		for _, res := range history.Advance() {
+			write(res)
+		}
+	}
+	collapser.Drain(sinks.Emit)
+
+	summary.SharedHosting = ipHosts.Shared(sharedHostingThreshold)
+	summary.JarmClusters = jarmHosts.Clusters()
+	summary.Clusters = resultClusters.Shared()
+	if dorksPath != "" {
+		writeDorks(ctx, target)
+	}
+	if graphPath != "" {
+		writeGraph()
+	}
+	if exportBurpPath != "" || exportURLsPath != "" {
+		writeExports()
+	}
+	summary.PTROutOfScope = ptrOutOfScopeNames()
+	summary.CapsTripped = trippedCaps()
+	summary.ScopeDrops = scopeDrops()
+	summary.IPExclusions = ipExclusionCounts()
+	sinks.Flush()
+	writeInfraUpdates(stdout)
+	if err := syncOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error syncing -o: %v\n", err)
+	}
+	summary.Sinks = sinks.Report()
+	summary.finish(os.Stderr)
+	return current, nil
+}
+
+// jitter returns d shifted by a random amount of up to frac*d either way
+func jitter(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 {
+		return d
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	return d + time.Duration((rand.Float64()*2-1)*frac*float64(d))
+}
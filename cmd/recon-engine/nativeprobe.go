@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe engines, as in -probe-engine and Result.ProbeEngine
+const (
+	probeEngineHttpx  = "httpx"
+	probeEngineNative = "native"
+)
+
+// probeEngineFallback is set when httpx was not found and the native engine
+// took over, for the coverage report
+var probeEngineFallback bool
+
+// nativeProbeThreads is how many names the native engine probes at once
+// without -httpx-threads, httpx's own default
+const nativeProbeThreads = 50
+
+// probeProcess is the prober the probe queue is written to: httpx or the
+// native engine. Wait returns once its output has ended.
+type probeProcess interface {
+	Wait() error
+}
+
+// httpxProcess is a running httpx
+type httpxProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p httpxProcess) Wait() error {
+	return waitTool(p.cmd)
+}
+
+// configureProbeEngine resolves -probe-engine. Without it httpx is used
+// when it can be found, and the native engine otherwise unless
+// -strict-tools makes the missing httpx fatal. Called once after flag
+// parsing, before the tools are checked.
+func configureProbeEngine() error {
+	if probeBatch < 0 {
+		return fmt.Errorf("-probe-batch must not be negative, got %d", probeBatch)
+	}
+	switch probeEngine {
+	case probeEngineHttpx, probeEngineNative:
+	case "":
+		probeEngine = probeEngineHttpx
+		if strictTools || useDocker || replayDir != "" {
+			break
+		}
+		if _, err := exec.LookPath(toolPath("httpx")); err != nil {
+			probeEngine, probeEngineFallback = probeEngineNative, true
+			fmt.Fprintln(os.Stderr, "Warning: httpx not found, probing with the native engine (-probe-engine native); technologies come from response headers only and CDNs are not detected")
+		}
+	default:
+		return fmt.Errorf("unknown -probe-engine %q (want httpx or native)", probeEngine)
+	}
+	if probeEngine == probeEngineNative {
+		if nativeTimeout <= 0 {
+			return fmt.Errorf("-native-timeout must be positive, got %s", nativeTimeout)
+		}
+		if nativeMaxRedirects < 0 {
+			return fmt.Errorf("-native-max-redirects must not be negative, got %d", nativeMaxRedirects)
+		}
+		if httpxExtraArgs != "" || httpxRetries > 0 || httpxTimeout > 0 {
+			fmt.Fprintln(os.Stderr, "Warning: -httpx-args, -httpx-retries and -httpx-timeout do not apply to the native probe engine")
+		}
+	}
+	return nil
+}
+
+// startProber starts the -probe-engine prober, returning its stdin and
+// stdout: names go in one per line and httpx JSON lines come out
+func startProber(ctx context.Context) (probeProcess, io.WriteCloser, io.Reader, error) {
+	if probeEngine == probeEngineNative {
+		p, in, out := startNativeProbe(ctx)
+		return p, in, out, nil
+	}
+	cmd, in, out, err := startHttpx(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return httpxProcess{cmd}, in, out, nil
+}
+
+// nativeProbeHTTP sends the native engine's requests. Like httpx it does not
+// verify certificates; redirects are followed up to -native-max-redirects.
+var nativeProbeHTTP = func() *http.Client {
+	c := &http.Client{Transport: limitedTransport{insecure: true}}
+	c.CheckRedirect = func(_ *http.Request, via []*http.Request) error {
+		if len(via) > nativeMaxRedirects {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	return c
+}()
+
+// nativeProbe is the native engine: it reads names like httpx's stdin and
+// writes the httpx JSON lines of the ones that answered
+type nativeProbe struct {
+	done chan struct{}
+}
+
+func (p *nativeProbe) Wait() error {
+	<-p.done
+	return nil
+}
+
+// startNativeProbe starts the native engine's workers: -httpx-threads of
+// them, httpx's default of 50 without, or one with -polite
+func startNativeProbe(ctx context.Context) (*nativeProbe, io.WriteCloser, io.Reader) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	p := &nativeProbe{done: make(chan struct{})}
+	threads := nativeProbeThreads
+	switch {
+	case politeMode:
+		threads = 1
+	case httpxThreads > 0:
+		threads = httpxThreads
+	}
+
+	names := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	enc := json.NewEncoder(outW)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				for _, h := range nativeProbeName(ctx, name) {
+					mu.Lock()
+					enc.Encode(h)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	// A cancelled run stops reading names; the feed's writes then fail as
+	// they would on an httpx that was killed
+	stop := context.AfterFunc(ctx, func() {
+		inR.CloseWithError(ctx.Err())
+	})
+	go func() {
+		defer close(p.done)
+		defer stop()
+		sc := bufio.NewScanner(inR)
+		for sc.Scan() {
+			if name := strings.TrimSpace(sc.Text()); name != "" {
+				names <- name
+			}
+		}
+		close(names)
+		wg.Wait()
+		inR.Close()
+		outW.Close()
+	}()
+	return p, inW, outR
+}
+
+// nativeProbeName probes name as httpx would: on each -probe-ports port, or
+// the default ones, HTTPS first and HTTP only when HTTPS gets no answer. A
+// name given as a URL is requested as it is.
+func nativeProbeName(ctx context.Context, name string) []HttpxResult {
+	if strings.Contains(name, "://") {
+		if h, ok := nativeFetch(ctx, name, name); ok {
+			return []HttpxResult{h}
+		}
+		return nil
+	}
+	host, ports := name, []string{""}
+	// ip:port inputs from -portscan of IP targets carry their port
+	if h, port, err := net.SplitHostPort(name); err == nil {
+		host, ports = h, []string{port}
+	} else if probePorts != "" {
+		ports = splitList(probePorts)
+	}
+	v4, v6, ok := nativeResolve(ctx, host)
+	if !ok {
+		return nil
+	}
+	var out []HttpxResult
+	for _, port := range ports {
+		addr := urlHost(host, port)
+		for _, scheme := range []string{"https", "http"} {
+			if h, ok := nativeFetch(ctx, name, scheme+"://"+addr); ok {
+				h.A, h.AAAA = v4, v6
+				out = append(out, h)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// nativeResolve looks up the A and AAAA records of host, as httpx -ip
+// reports them. ok is false when host has addresses but none of the
+// -ip-version family, which the probe could not connect to. Behind a proxy
+// or in -replay the proxy or the recording resolves names, and nothing is
+// looked up.
+func nativeResolve(ctx context.Context, host string) (v4, v6 []string, ok bool) {
+	if proxyURL != nil || replayDir != "" || net.ParseIP(host) != nil {
+		return nil, nil, true
+	}
+	addrs, err := dnsResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, nil, true
+	}
+	for _, a := range addrs {
+		if isIPv6(a) {
+			v6 = append(v6, a)
+		} else {
+			v4 = append(v4, a)
+		}
+	}
+	return v4, v6, len(hostIPs(addrs)) > 0
+}
+
+// urlHost joins host and port for a URL, bracketing IPv6 addresses and
+// escaping their zone; an empty port leaves the scheme's default
+func urlHost(host, port string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + strings.Replace(host, "%", "%25", 1) + "]"
+	}
+	if port == "" {
+		return host
+	}
+	return host + ":" + port
+}
+
+// nativeFetch GETs rawURL and describes the answer the way httpx's JSON
+// does, with the technologies the Server and X-Powered-By headers name
+func nativeFetch(ctx context.Context, input, rawURL string) (HttpxResult, bool) {
+	ctx, cancel := context.WithTimeout(ctx, nativeTimeout)
+	defer cancel()
+	var remote string
+	if proxyURL == nil {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+					remote = host
+				}
+			},
+		})
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return HttpxResult{}, false
+	}
+	start := time.Now()
+	resp, err := nativeProbeHTTP.Do(req)
+	if err != nil {
+		return HttpxResult{}, false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, authBodyMax))
+	if err != nil && len(body) == 0 {
+		return HttpxResult{}, false
+	}
+	elapsed := time.Since(start)
+	sum := sha256.Sum256(body)
+	h := HttpxResult{
+		Input:         input,
+		Url:           rawURL,
+		StatusCode:    resp.StatusCode,
+		Title:         pageTitle(body),
+		WebServer:     resp.Header.Get("Server"),
+		Tech:          headerTech(resp.Header),
+		Host:          remote,
+		ContentLength: len(body),
+		Time:          elapsed.String(),
+	}
+	h.Hash.BodySHA256 = hex.EncodeToString(sum[:])
+	if rulesNeedHeaders || headerAudit {
+		h.Header = make(map[string]interface{}, len(resp.Header))
+		for k, v := range resp.Header {
+			h.Header[headerKey(k)] = strings.Join(v, ", ")
+		}
+	}
+	stats.Add("probe.native_requests", 1)
+	return h, true
+}
+
+// headerTech returns the technologies response headers name, as httpx's
+// "name:version" entries: nginx/1.25.3 in Server is nginx:1.25.3
+func headerTech(h http.Header) []string {
+	var tech []string
+	for _, name := range []string{"Server", "X-Powered-By"} {
+		for _, v := range h.Values(name) {
+			for _, product := range strings.Fields(v) {
+				// Comments such as (Ubuntu) describe the previous product
+				if strings.HasPrefix(product, "(") {
+					continue
+				}
+				p, ver, _ := strings.Cut(product, "/")
+				if p == "" {
+					continue
+				}
+				if ver != "" && ver[0] >= '0' && ver[0] <= '9' {
+					p += ":" + ver
+				}
+				tech = append(tech, p)
+			}
+		}
+	}
+	return tech
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setNativeProbe selects the native engine with a short timeout and the
+// given redirect policy
+func setNativeProbe(t *testing.T, maxRedirects int) {
+	oldEngine, oldTimeout, oldRedirects, oldHeaders := probeEngine, nativeTimeout, nativeMaxRedirects, extraHeaders
+	t.Cleanup(func() {
+		probeEngine, nativeTimeout, nativeMaxRedirects, extraHeaders = oldEngine, oldTimeout, oldRedirects, oldHeaders
+	})
+	probeEngine, nativeTimeout, nativeMaxRedirects = probeEngineNative, 2*time.Second, maxRedirects
+}
+
+// appServer serves a page titled title, with the X-Test header it was sent
+// echoed back
+func appServer(title string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Server", "nginx/1.25.3 (Ubuntu)")
+			w.Header().Set("X-Powered-By", "PHP/8.2.1")
+			w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+			fmt.Fprintf(w, "<html><head><title> %s </title></head><body>hello</body></html>", title)
+		case "/r0":
+			http.Redirect(w, r, "/r1", http.StatusFound)
+		case "/r1":
+			http.Redirect(w, r, "/r2", http.StatusFound)
+		case "/r2":
+			fmt.Fprint(w, "<title>landed</title>")
+		}
+	})
+}
+
+// serverName is the ip:port name a test server is probed as
+func serverName(s *httptest.Server) string {
+	return strings.TrimPrefix(strings.TrimPrefix(s.URL, "https://"), "http://")
+}
+
+func TestNativeFetch(t *testing.T) {
+	setNativeProbe(t, 0)
+	extraHeaders = []string{"X-Test: from -header"}
+	s := httptest.NewServer(appServer("Portal"))
+	defer s.Close()
+	h, ok := nativeFetch(context.Background(), "portal.example.com", s.URL+"/")
+	if !ok {
+		t.Fatal("no answer")
+	}
+	if h.Input != "portal.example.com" || h.Url != s.URL+"/" || h.StatusCode != 200 || h.Title != "Portal" {
+		t.Errorf("answer %+v", h)
+	}
+	if h.WebServer != "nginx/1.25.3 (Ubuntu)" || !slices.Equal(h.Tech, []string{"nginx:1.25.3", "PHP:8.2.1"}) {
+		t.Errorf("server %q, tech %v", h.WebServer, h.Tech)
+	}
+	if h.ContentLength == 0 || len(h.Hash.BodySHA256) != 64 || h.Host != "127.0.0.1" || h.Time == "" {
+		t.Errorf("length %d, hash %q, host %q, time %q", h.ContentLength, h.Hash.BodySHA256, h.Host, h.Time)
+	}
+
+	oldRules := rulesNeedHeaders
+	t.Cleanup(func() { rulesNeedHeaders = oldRules })
+	rulesNeedHeaders = true
+	h, _ = nativeFetch(context.Background(), "portal.example.com", s.URL+"/")
+	if h.Header[headerKey("X-Echo")] != "from -header" {
+		t.Errorf("-header not sent: headers %v", h.Header)
+	}
+}
+
+// TestNativeRedirects reports the redirect itself by default and follows
+// up to -native-max-redirects
+func TestNativeRedirects(t *testing.T) {
+	s := httptest.NewServer(appServer("Portal"))
+	defer s.Close()
+	for _, c := range []struct {
+		max    int
+		status int
+		title  string
+	}{
+		{0, http.StatusFound, ""},
+		{1, http.StatusFound, ""},
+		{2, http.StatusOK, "landed"},
+	} {
+		setNativeProbe(t, c.max)
+		h, ok := nativeFetch(context.Background(), "r.example.com", s.URL+"/r0")
+		if !ok || h.StatusCode != c.status || h.Title != c.title {
+			t.Errorf("-native-max-redirects %d: status %d, title %q", c.max, h.StatusCode, h.Title)
+		}
+	}
+}
+
+// TestNativeProbeName tries HTTPS before HTTP and skips a port with
+// nothing listening
+func TestNativeProbeName(t *testing.T) {
+	setNativeProbe(t, 0)
+	tlsServer := httptest.NewTLSServer(appServer("Secure"))
+	defer tlsServer.Close()
+	plain := httptest.NewServer(appServer("Plain"))
+	defer plain.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	ctx := context.Background()
+	if got := nativeProbeName(ctx, serverName(tlsServer)); len(got) != 1 || !strings.HasPrefix(got[0].Url, "https://") || got[0].Title != "Secure" {
+		t.Errorf("TLS server: %+v", got)
+	}
+	if got := nativeProbeName(ctx, serverName(plain)); len(got) != 1 || !strings.HasPrefix(got[0].Url, "http://") || got[0].Title != "Plain" {
+		t.Errorf("plain server: %+v", got)
+	}
+	if got := nativeProbeName(ctx, closed); len(got) != 0 {
+		t.Errorf("closed port: %+v", got)
+	}
+	if got := nativeProbeName(ctx, plain.URL+"/"); len(got) != 1 || got[0].Url != plain.URL+"/" {
+		t.Errorf("URL input: %+v", got)
+	}
+}
+
+// TestNativeProbeEngine runs names through the native engine the way the
+// pipeline runs them through httpx, against a farm of servers
+func TestNativeProbeEngine(t *testing.T) {
+	setNativeProbe(t, 0)
+	oldThreads := httpxThreads
+	t.Cleanup(func() { httpxThreads = oldThreads })
+	httpxThreads = 4
+
+	var names, want []string
+	for i := 0; i < 10; i++ {
+		var s *httptest.Server
+		if i%2 == 0 {
+			s = httptest.NewTLSServer(appServer(fmt.Sprintf("App %d", i)))
+		} else {
+			s = httptest.NewServer(appServer(fmt.Sprintf("App %d", i)))
+		}
+		defer s.Close()
+		names = append(names, serverName(s))
+		want = append(want, fmt.Sprintf("App %d", i))
+	}
+
+	prober, in, out, err := startProber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for _, name := range names {
+			fmt.Fprintln(in, name)
+		}
+		in.Close()
+	}()
+	b, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prober.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var h HttpxResult
+		if err := json.Unmarshal([]byte(line), &h); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		titles = append(titles, h.Title)
+		if res := httpxResult(h, "example.com"); res.ProbeEngine != probeEngineNative {
+			t.Errorf("probe_engine %q", res.ProbeEngine)
+		}
+	}
+	sort.Strings(titles)
+	sort.Strings(want)
+	if !slices.Equal(titles, want) {
+		t.Errorf("titles %v, want %v", titles, want)
+	}
+}
+
+// TestNativeProbeCancel ends the engine's output when the run is cancelled
+func TestNativeProbeCancel(t *testing.T) {
+	setNativeProbe(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	prober, in, out, _ := startProber(ctx)
+	cancel()
+	io.Copy(io.Discard, out)
+	prober.Wait()
+	if _, err := fmt.Fprintln(in, "www.example.com"); err == nil {
+		t.Error("a cancelled engine accepted a name")
+	}
+}
+
+func TestHeaderTech(t *testing.T) {
+	h := http.Header{}
+	h.Add("Server", "Apache/2.4.57 (Debian) OpenSSL/3.0.11")
+	h.Add("Server", "cloudflare")
+	h.Add("X-Powered-By", "Express")
+	h.Add("X-Powered-By", "ASP.NET/beta")
+	want := []string{"Apache:2.4.57", "OpenSSL:3.0.11", "cloudflare", "Express", "ASP.NET"}
+	if got := headerTech(h); !slices.Equal(got, want) {
+		t.Errorf("headerTech = %v, want %v", got, want)
+	}
+}
+
+func TestConfigureProbeEngine(t *testing.T) {
+	setNativeProbe(t, 0)
+	oldFallback, oldStrict, oldDocker, oldReplay := probeEngineFallback, strictTools, useDocker, replayDir
+	t.Cleanup(func() {
+		probeEngineFallback, strictTools, useDocker, replayDir = oldFallback, oldStrict, oldDocker, oldReplay
+	})
+	useDocker, replayDir = false, ""
+	fakeTool(t, "httpx", "exit 0")
+	missing := "/nonexistent/httpx"
+
+	for _, c := range []struct {
+		engine   string
+		strict   bool
+		found    bool
+		want     string
+		fallback bool
+	}{
+		{"", false, true, probeEngineHttpx, false},
+		{"", false, false, probeEngineNative, true},
+		{"", true, false, probeEngineHttpx, false},
+		{probeEngineNative, false, true, probeEngineNative, false},
+		{probeEngineHttpx, false, false, probeEngineHttpx, false},
+	} {
+		path := *toolBins["httpx"]
+		if !c.found {
+			toolBins["httpx"] = &missing
+		}
+		probeEngine, probeEngineFallback, strictTools = c.engine, false, c.strict
+		if err := configureProbeEngine(); err != nil {
+			t.Fatal(err)
+		}
+		if probeEngine != c.want || probeEngineFallback != c.fallback {
+			t.Errorf("-probe-engine %q, strict %v, httpx found %v: engine %q, fallback %v", c.engine, c.strict, c.found, probeEngine, probeEngineFallback)
+		}
+		toolBins["httpx"] = &path
+	}
+
+	probeEngine = "curl"
+	if err := configureProbeEngine(); err == nil {
+		t.Error("accepted -probe-engine curl")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redirectCanaryHost is the external host -redirect-check asks to be sent
+// to. The .example TLD never resolves, so nothing is ever served from it.
+const redirectCanaryHost = "redirect-canary.example"
+
+// redirectParamNames are the parameter names -redirect-check tries,
+// lower-cased
+var redirectParamNames = []string{
+	"redirect", "redirect_uri", "redirect_url", "redirecturl", "redirect_to", "redir",
+	"url", "next", "return", "return_to", "returnto", "return_url", "returnurl",
+	"continue", "dest", "destination", "goto", "target", "forward", "out", "rurl",
+}
+
+// clientRedirectRe finds a meta refresh or a script assignment to location
+// that could carry the canary: these only redirect in a browser, so they are
+// reported as possible
+var clientRedirectRe = regexp.MustCompile(`(?i)http-equiv=["']?refresh|(?:window|document|top|self)\.location|location\.(?:href|replace|assign)`)
+
+// openRedirectHTTP never follows redirects: the Location is the evidence
+var openRedirectHTTP = func() *http.Client {
+	c := newHTTPClient(10*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+var (
+	redirectChecksMu      sync.Mutex
+	redirectChecksSent    int
+	redirectChecksTripped sync.Once
+)
+
+// redirectCandidate is one parameter of one endpoint to test
+type redirectCandidate struct {
+	endpoint *url.URL // without query
+	query    url.Values
+	param    string
+}
+
+// configureRedirectCheck validates the -redirect-check flags. Called once
+// after flag parsing.
+func configureRedirectCheck() error {
+	if !redirectCheck {
+		return nil
+	}
+	if redirectCheckMax < 1 {
+		return fmt.Errorf("-redirect-check-max must be at least 1")
+	}
+	if redirectCheckBudget < 0 {
+		return fmt.Errorf("-redirect-check-budget cannot be negative")
+	}
+	return nil
+}
+
+// checkOpenRedirects sets every redirect-like parameter of the host's known
+// URLs to the canary, one request each and at most -redirect-check-max per
+// host, and reports a Location pointing at the canary as an open redirect.
+// A page that only mentions the canary next to a meta refresh or a script
+// redirect is reported as a possible one. Each parameter of a path is tried
+// once, whatever the URLs it appears in.
+func checkOpenRedirects(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" || !scopeAllowsHost(u.Hostname()) {
+		return
+	}
+	sent := 0
+	for _, c := range redirectCandidates(res, u) {
+		target := redirectTarget(c)
+		if robotsDisallowed(ctx, target) {
+			continue
+		}
+		if sent == redirectCheckMax || !takeRedirectCheck() {
+			return
+		}
+		sent++
+		finding, err := tryOpenRedirect(ctx, target)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		if finding != nil {
+			finding.Evidence["parameter"] = c.param
+			res.Vulnerabilities = append(res.Vulnerabilities, *finding)
+		}
+	}
+}
+
+// redirectCandidates returns the redirect-like parameters of u's host in
+// its known URLs and, after -params, the names -params found, on u's own
+// path. One per path and parameter name, in a stable order.
+func redirectCandidates(res *Result, u *url.URL) []redirectCandidate {
+	seen := make(map[string]bool)
+	var out []redirectCandidate
+	add := func(t *url.URL, query url.Values, param string) {
+		key := t.EscapedPath() + "?" + strings.ToLower(param)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		endpoint := *t
+		endpoint.RawQuery, endpoint.Fragment = "", ""
+		out = append(out, redirectCandidate{endpoint: &endpoint, query: query, param: param})
+	}
+
+	for _, ref := range knownURLs(res) {
+		t, err := u.Parse(ref)
+		if err != nil || !strings.EqualFold(t.Host, u.Host) {
+			continue
+		}
+		query := t.Query()
+		names := make([]string, 0, len(query))
+		for name := range query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if contains(redirectParamNames, strings.ToLower(name)) {
+				add(t, query, name)
+			}
+		}
+	}
+	for _, name := range res.Parameters {
+		if contains(redirectParamNames, strings.ToLower(name)) {
+			add(u, u.Query(), name)
+		}
+	}
+	return out
+}
+
+// redirectTarget is c's endpoint with its query, c.param set to the canary
+func redirectTarget(c redirectCandidate) string {
+	q := url.Values{}
+	for k, v := range c.query {
+		q[k] = v
+	}
+	q.Set(c.param, "https://"+redirectCanaryHost+"/")
+	t := *c.endpoint
+	t.RawQuery = q.Encode()
+	return t.String()
+}
+
+// tryOpenRedirect requests target without following a redirect and returns
+// a finding when it sends the client to the canary, or nil
+func tryOpenRedirect(ctx context.Context, target string) (*Finding, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := openRedirectHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 256<<10))
+
+	evidence := map[string]interface{}{"request": target, "status": resp.StatusCode}
+	if loc := resp.Header.Get("Location"); loc != "" && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		evidence["location"] = loc
+		if next, err := req.URL.Parse(loc); err == nil && strings.EqualFold(next.Hostname(), redirectCanaryHost) {
+			return openRedirectFinding("open-redirect", "medium", confidenceConfirmed, target, evidence), nil
+		}
+		return nil, nil
+	}
+	if bytes.Contains(bytes.ToLower(body), []byte(redirectCanaryHost)) && clientRedirectRe.Match(body) {
+		return openRedirectFinding("open-redirect-possible", "low", confidenceTentative, target, evidence), nil
+	}
+	return nil, nil
+}
+
+func openRedirectFinding(id, severity, confidence, target string, evidence map[string]interface{}) *Finding {
+	evidence["url"] = target
+	f := newFinding("redirect-check", id, severity, confidence, "", evidence)
+	return &f
+}
+
+// takeRedirectCheck counts one request against -redirect-check-budget and
+// reports whether the budget allowed it
+func takeRedirectCheck() bool {
+	if redirectCheckBudget == 0 {
+		return true
+	}
+	redirectChecksMu.Lock()
+	defer redirectChecksMu.Unlock()
+	if redirectChecksSent >= redirectCheckBudget {
+		redirectChecksTripped.Do(func() {
+			fmt.Fprintf(os.Stderr, "Reached -redirect-check-budget (%d), open redirect checks stop\n", redirectCheckBudget)
+		})
+		return false
+	}
+	redirectChecksSent++
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// configureOrdered checks -ordered-window. Called once after flag parsing.
+func configureOrdered() error {
+	if ordered && orderedWindow < 1 {
+		return fmt.Errorf("must be at least 1, got %d", orderedWindow)
+	}
+	return nil
+}
+
+// resultOrder numbers the probed hosts in the order their httpx lines
+// arrived, so -ordered can emit them in that order once enriched
+type resultOrder struct {
+	mu   sync.Mutex
+	last int
+	// hosts are the URLs of the numbered Results not yet emitted, for the
+	// spill warning
+	hosts map[int]string
+}
+
+func newResultOrder() *resultOrder {
+	return &resultOrder{hosts: make(map[int]string)}
+}
+
+// assign gives res the next sequence number. Called from the goroutine
+// consuming httpx's output.
+func (o *resultOrder) assign(res *Result) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.last++
+	res.seq = o.last
+	o.hosts[res.seq] = res.URL
+}
+
+func (o *resultOrder) done(seq int) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	host := o.hosts[seq]
+	delete(o.hosts, seq)
+	return host
+}
+
+// release passes the enriched Results of in to the returned channel in
+// sequence order, holding those that finish early until their predecessors
+// are out. Once window Results are held behind one slow host, the hold is
+// spilled: they go out, the missing host follows out of order when it is
+// done, and a warning names it. Results dropped before enrichment, as
+// -max-live-hosts does, only hold the window until in is closed.
+func (o *resultOrder) release(in <-chan Result, window int) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		held := make(map[int]Result)
+		next := 1
+		send := func(res Result) {
+			o.done(res.seq)
+			out <- res
+		}
+		for res := range in {
+			if res.seq < next {
+				// Spilled past earlier, or not numbered at all
+				send(res)
+				continue
+			}
+			held[res.seq] = res
+			for {
+				r, ok := held[next]
+				if !ok {
+					break
+				}
+				delete(held, next)
+				send(r)
+				next++
+			}
+			if len(held) < window {
+				continue
+			}
+			stats.Add("ordered.spills", 1)
+			fmt.Fprintf(os.Stderr, "Warning: -ordered is holding %d results behind %s, still enriching; emitting them, it follows out of order\n", len(held), o.hostOf(next))
+			for len(held) > 0 {
+				if r, ok := held[next]; ok {
+					delete(held, next)
+					send(r)
+				}
+				next++
+			}
+		}
+		seqs := make([]int, 0, len(held))
+		for seq := range held {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			send(held[seq])
+		}
+	}()
+	return out
+}
+
+func (o *resultOrder) hostOf(seq int) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if host := o.hosts[seq]; host != "" {
+		return host
+	}
+	return fmt.Sprintf("result #%d", seq)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderedRun numbers n probed hosts as the pipeline does when httpx
+// answers, enriches them on a pool of workers with random delays from
+// seed, and returns what -ordered writes
+func orderedRun(t *testing.T, n, workers, window int, seed int64, delay func(seq int, r *rand.Rand) time.Duration) []byte {
+	t.Helper()
+	order := newResultOrder()
+	jobs := make(chan Result)
+	enriched := make(chan Result)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			res := Result{Subdomain: fmt.Sprintf("h%03d.example.com", i), StatusCode: 200}
+			res.URL = "https://" + res.Subdomain
+			order.assign(&res)
+			jobs <- res
+		}
+	}()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range jobs {
+				mu.Lock()
+				d := delay(res.seq, r)
+				mu.Unlock()
+				time.Sleep(d)
+				res.Title = "enriched " + res.Subdomain
+				enriched <- res
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(enriched)
+	}()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for res := range order.release(enriched, window) {
+		if err := enc.Encode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func randomDelay(_ int, r *rand.Rand) time.Duration {
+	return time.Duration(r.Intn(2000)) * time.Microsecond
+}
+
+// TestOrderedOutputIdentical writes byte-identical output over runs whose
+// enrichment finishes in a different order every time
+func TestOrderedOutputIdentical(t *testing.T) {
+	want := orderedRun(t, 200, 16, 1000, 1, randomDelay)
+	for seed := int64(2); seed < 6; seed++ {
+		if got := orderedRun(t, 200, 16, 1000, seed, randomDelay); !bytes.Equal(got, want) {
+			t.Fatalf("run %d differs from the first", seed)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(want))
+	for i := 0; dec.More(); i++ {
+		var res Result
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if name := fmt.Sprintf("h%03d.example.com", i); res.Subdomain != name || res.Title != "enriched "+name {
+			t.Fatalf("result %d is %s (%q)", i, res.Subdomain, res.Title)
+		}
+	}
+}
+
+// TestOrderedSpill emits what is held behind one slow host once the
+// window fills, and that host after
+func TestOrderedSpill(t *testing.T) {
+	before := stats.Get("ordered.spills")
+	slow := func(seq int, r *rand.Rand) time.Duration {
+		if seq == 1 {
+			return 200 * time.Millisecond
+		}
+		return randomDelay(seq, r)
+	}
+	out := orderedRun(t, 20, 4, 5, 1, slow)
+	var got []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var res Result
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, res.Subdomain)
+	}
+	if len(got) != 20 {
+		t.Fatalf("%d results, want 20", len(got))
+	}
+	if got[0] == "h000.example.com" {
+		t.Error("the slow host held every other result")
+	}
+	seen := make(map[string]bool)
+	for _, name := range got {
+		if seen[name] {
+			t.Errorf("%s emitted twice", name)
+		}
+		seen[name] = true
+	}
+	if stats.Get("ordered.spills") <= before {
+		t.Error("the spill was not counted")
+	}
+}
+
+// TestOrderedDropped does not lose the Results behind one that was dropped
+// before enrichment: they go out in order once the input ends
+func TestOrderedDropped(t *testing.T) {
+	order := newResultOrder()
+	in := make(chan Result, 4)
+	for i := 0; i < 4; i++ {
+		res := Result{Subdomain: fmt.Sprintf("h%d.example.com", i)}
+		order.assign(&res)
+		if i != 1 {
+			in <- res
+		}
+	}
+	close(in)
+	var got []int
+	for res := range order.release(in, 10) {
+		got = append(got, res.seq)
+	}
+	if fmt.Sprint(got) != "[1 3 4]" {
+		t.Errorf("released %v, want [1 3 4]", got)
+	}
+	if len(order.hosts) != 1 {
+		t.Errorf("hosts still tracked %v", order.hosts)
+	}
+}
+
+func TestConfigureOrdered(t *testing.T) {
+	oldOrdered, oldWindow := ordered, orderedWindow
+	t.Cleanup(func() { ordered, orderedWindow = oldOrdered, oldWindow })
+	ordered, orderedWindow = true, 0
+	if err := configureOrdered(); err == nil {
+		t.Error("accepted -ordered-window 0")
+	}
+	ordered = false
+	if err := configureOrdered(); err != nil {
+		t.Errorf("-ordered-window checked without -ordered: %v", err)
+	}
+}
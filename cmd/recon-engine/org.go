@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// reverseWhoisAPI is WhoisXML's reverse WHOIS endpoint, used by -org when
+// WHOISXML_API_KEY is set
+const reverseWhoisAPI = "https://reverse-whois.whoisxmlapi.com/api/v2"
+
+// orgDiscoveryTimeout bounds the whole of -org's candidate search
+const orgDiscoveryTimeout = 5 * time.Minute
+
+// orgCandidate is a root domain -org linked to the organization, with what
+// linked it
+type orgCandidate struct {
+	Domain     string   `json:"domain"`
+	Confidence string   `json:"confidence"` // low, medium or high
+	Evidence   []string `json:"evidence"`
+
+	points int
+}
+
+// orgLegalSuffixes are left out when matching organization names, so
+// "Acme Corp" matches an ASN described as "ACME Corporation"
+var orgLegalSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "corp": true, "corporation": true, "co": true, "company": true,
+	"ltd": true, "limited": true, "llc": true, "plc": true, "gmbh": true, "ag": true, "sa": true, "bv": true,
+}
+
+// validateOrg checks -org against the flags and arguments that cannot go
+// with it. Called once after flag parsing.
+func validateOrg(cmd string, args []string) error {
+	if orgName == "" {
+		if orgAuto {
+			return fmt.Errorf("-org-auto needs -org")
+		}
+		return nil
+	}
+	if orgWords(orgName) == nil {
+		return fmt.Errorf("%q has nothing to match on besides legal suffixes", orgName)
+	}
+	switch {
+	case cmd == "resume":
+		return fmt.Errorf("resume rescans the target of its -state file and cannot be used with -org")
+	case len(args) > 0:
+		return fmt.Errorf("-org takes no target; the root domains it finds are the targets")
+	case monitor, statePath != "", diffPath != "":
+		return fmt.Errorf("-monitor, -state and -diff follow one target and cannot be used with -org")
+	case recordDir != "", replayDir != "":
+		return fmt.Errorf("-record and -replay cannot be used with -org")
+	case dorksPath != "":
+		return fmt.Errorf("-dorks writes one target's dorks and cannot be used with -org")
+	case dryRun:
+		return fmt.Errorf("-org searches for domains over the network, which -dry-run does not do")
+	case !orgAuto && !term.IsTerminal(int(os.Stdin.Fd())):
+		return fmt.Errorf("confirming the domains -org finds needs a terminal; add -org-auto to accept them unattended")
+	}
+	return nil
+}
+
+// orgTargets finds the root domains of -org, prints them with their
+// evidence and returns the ones confirmed for scanning
+func orgTargets() ([]orgCandidate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), orgDiscoveryTimeout)
+	defer cancel()
+	fmt.Fprintf(os.Stderr, "Searching for root domains of %q\n", orgName)
+	candidates, asns := findOrgDomains(ctx, orgName)
+	if len(asns) > 0 {
+		fmt.Fprintln(os.Stderr, "ASNs registered to the organization (sweep them with -asn-expand once confirmed):")
+		for _, a := range asns {
+			fmt.Fprintf(os.Stderr, "  AS%d  %s\n", a.Asn, a.Org)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no root domains found for %q", orgName)
+	}
+	printOrgCandidates(os.Stderr, candidates)
+
+	var picked []orgCandidate
+	if orgAuto {
+		for _, c := range candidates {
+			if c.Confidence != "low" {
+				picked = append(picked, c)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "-org-auto: scanning the %d medium and high confidence domains\n", len(picked))
+	} else {
+		var err error
+		if picked, err = confirmOrgCandidates(os.Stdin, os.Stderr, candidates); err != nil {
+			return nil, err
+		}
+	}
+	if len(picked) == 0 {
+		return nil, fmt.Errorf("no root domains were confirmed for %q", orgName)
+	}
+	return picked, nil
+}
+
+// findOrgDomains searches certificate transparency for certificates whose
+// subject O is org and, with a WHOISXML_API_KEY, reverse WHOIS for domains
+// registered to it. Each candidate is then checked against its RDAP/WHOIS
+// registrant and, with -asn-db, whether its apex is announced by one of the
+// ASNs whose description names org, which are returned too.
+func findOrgDomains(ctx context.Context, org string) ([]orgCandidate, []asnInfo) {
+	byDomain := make(map[string]*orgCandidate)
+	add := func(domain string, points int, evidence string) {
+		domain = registrableDomain(domain)
+		if domain == "" || !scopeAllowsHost(domain) {
+			return
+		}
+		c := byDomain[domain]
+		if c == nil {
+			c = &orgCandidate{Domain: domain}
+			byDomain[domain] = c
+		}
+		c.points += points
+		c.Evidence = append(c.Evidence, evidence)
+	}
+
+	certs, err := crtshOrgNames(ctx, org)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Certificate transparency search failed: %v\n", err)
+		reportToolError("crtsh", "org", "", err)
+	}
+	for domain, ids := range certs {
+		points := 1
+		if len(ids) >= 3 {
+			points = 2
+		}
+		add(domain, points, fmt.Sprintf("%d certificate(s) with O=%s, e.g. crt.sh id %d", len(ids), org, ids[0]))
+	}
+
+	if secret("WHOISXML_API_KEY") != "" {
+		domains, err := reverseWhois(ctx, org)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Reverse WHOIS search failed: %v\n", err)
+			reportToolError("whoisxml", "org", "", err)
+		}
+		for _, domain := range domains {
+			add(domain, 2, "registrant matches in reverse WHOIS (WhoisXML)")
+		}
+	}
+
+	asns := orgASNs(org)
+	asnOrg := make(map[int]string, len(asns))
+	for _, a := range asns {
+		asnOrg[a.Asn] = a.Org
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	sem := make(chan struct{}, 8)
+	for _, c := range byDomain {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c *orgCandidate) {
+			defer func() { <-sem; wg.Done() }()
+			var evidence []string
+			points := 0
+			if info, ok := lookupWhois(ctx, c.Domain); ok && info.RegistrantOrg != "" && orgMatches(org, info.RegistrantOrg) {
+				points += 2
+				evidence = append(evidence, fmt.Sprintf("registrant org %q (%s)", info.RegistrantOrg, info.Via))
+			}
+			if len(asnOrg) > 0 {
+				addrs, _ := dnsResolver.LookupHost(ctx, c.Domain)
+				for _, addr := range addrs {
+					if info, ok := lookupASN(ctx, addr); ok && asnOrg[info.Asn] != "" {
+						points++
+						evidence = append(evidence, fmt.Sprintf("apex %s is in AS%d (%s)", addr, info.Asn, info.Org))
+						break
+					}
+				}
+			}
+			mu.Lock()
+			c.points += points
+			c.Evidence = append(c.Evidence, evidence...)
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	candidates := make([]orgCandidate, 0, len(byDomain))
+	for _, c := range byDomain {
+		switch {
+		case c.points >= 4:
+			c.Confidence = "high"
+		case c.points >= 2:
+			c.Confidence = "medium"
+		default:
+			c.Confidence = "low"
+		}
+		candidates = append(candidates, *c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].points != candidates[j].points {
+			return candidates[i].points > candidates[j].points
+		}
+		return candidates[i].Domain < candidates[j].Domain
+	})
+	return candidates, asns
+}
+
+// crtshOrgNames returns, per registrable domain, the crt.sh IDs of the
+// certificates whose subject O is org
+func crtshOrgNames(ctx context.Context, org string) (map[string][]int64, error) {
+	endpoint := "https://crt.sh/?output=json&O=" + url.QueryEscape(org)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := apiRequest("crtsh"); err != nil {
+		return nil, err
+	}
+	resp, err := streamHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("crtsh: unexpected status %s", resp.Status)
+	}
+
+	ids := make(map[string][]int64)
+	dec := json.NewDecoder(resp.Body)
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("crtsh: %w", err)
+	}
+	for dec.More() {
+		var e struct {
+			ID         int64  `json:"id"`
+			CommonName string `json:"common_name"`
+			NameValue  string `json:"name_value"`
+		}
+		if err := dec.Decode(&e); err != nil {
+			return ids, fmt.Errorf("crtsh: %w", err)
+		}
+		seen := make(map[string]bool)
+		for _, name := range append(strings.Split(e.NameValue, "\n"), e.CommonName) {
+			name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "*."))
+			// Subjects sometimes carry a person's or the org's name
+			if !strings.Contains(name, ".") || strings.ContainsAny(name, " @") {
+				continue
+			}
+			domain := registrableDomain(name)
+			if domain != "" && !seen[domain] {
+				seen[domain] = true
+				ids[domain] = append(ids[domain], e.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// reverseWhois lists the domains WhoisXML has registered to org
+func reverseWhois(ctx context.Context, org string) ([]string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"apiKey":           secret("WHOISXML_API_KEY"),
+		"searchType":       "current",
+		"mode":             "purchase",
+		"punycode":         true,
+		"basicSearchTerms": map[string][]string{"include": {org}},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reverseWhoisAPI, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := apiRequest("whoisxml"); err != nil {
+		return nil, err
+	}
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("whoisxml: unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var out struct {
+		DomainsList []string `json:"domainsList"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("whoisxml: %w", err)
+	}
+	return out.DomainsList, nil
+}
+
+// orgASNs returns the -asn-db ASNs whose description names org. Shared
+// cloud and CDN ASNs are left out: their description never means the
+// addresses belong to org.
+func orgASNs(org string) []asnInfo {
+	seen := make(map[int]bool)
+	var asns []asnInfo
+	for _, r := range asnRanges {
+		a := r.info
+		if seen[a.Asn] {
+			continue
+		}
+		seen[a.Asn] = true
+		if _, shared := sharedTenancyASNs[a.Asn]; !shared && orgMatches(org, a.Org) {
+			asns = append(asns, a)
+		}
+	}
+	sort.Slice(asns, func(i, j int) bool { return asns[i].Asn < asns[j].Asn })
+	return asns
+}
+
+// orgMatches reports whether every word of org, legal suffixes aside,
+// appears in name
+func orgMatches(org, name string) bool {
+	want := orgWords(org)
+	if want == nil {
+		return false
+	}
+	have := make(map[string]bool)
+	for _, w := range orgWords(name) {
+		have[w] = true
+	}
+	for _, w := range want {
+		if !have[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// orgWords splits an organization name into lowercase words without
+// punctuation or legal suffixes
+func orgWords(name string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	}) {
+		if !orgLegalSuffixes[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// printOrgCandidates lists the candidates numbered from 1 with their
+// evidence
+func printOrgCandidates(w io.Writer, candidates []orgCandidate) {
+	fmt.Fprintf(w, "Root domains linked to %q:\n", orgName)
+	for i, c := range candidates {
+		fmt.Fprintf(w, "%4d  %-30s %-6s  %s\n", i+1, c.Domain, c.Confidence, c.Evidence[0])
+		for _, e := range c.Evidence[1:] {
+			fmt.Fprintf(w, "      %-30s %-6s  %s\n", "", "", e)
+		}
+	}
+}
+
+// confirmOrgCandidates asks which candidates to scan: all, none, or
+// numbers and ranges such as 1,3-5. Nothing is scanned by default.
+func confirmOrgCandidates(in io.Reader, w io.Writer, candidates []orgCandidate) ([]orgCandidate, error) {
+	r := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(w, "Scan which domains? [all, none, or numbers like 1,3-5] (none): ")
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("no answer to the -org confirmation: %w", err)
+		}
+		picked, perr := pickOrgCandidates(strings.TrimSpace(line), candidates)
+		if perr == nil {
+			return picked, nil
+		}
+		fmt.Fprintln(w, perr)
+		if err != nil {
+			return nil, perr
+		}
+	}
+}
+
+func pickOrgCandidates(answer string, candidates []orgCandidate) ([]orgCandidate, error) {
+	switch strings.ToLower(answer) {
+	case "", "none", "n", "no":
+		return nil, nil
+	case "all", "a", "y", "yes":
+		return candidates, nil
+	}
+	chosen := make(map[int]bool)
+	for _, part := range splitList(answer) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		last, err2 := first, error(nil)
+		if isRange {
+			last, err2 = strconv.Atoi(strings.TrimSpace(hi))
+		}
+		if err1 != nil || err2 != nil || first < 1 || last < first || last > len(candidates) {
+			return nil, fmt.Errorf("%q: want numbers from 1 to %d", part, len(candidates))
+		}
+		for n := first; n <= last; n++ {
+			chosen[n-1] = true
+		}
+	}
+	var picked []orgCandidate
+	for i, c := range candidates {
+		if chosen[i] {
+			picked = append(picked, c)
+		}
+	}
+	return picked, nil
+}
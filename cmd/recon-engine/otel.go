@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// otelQueue is how many ended spans wait for the exporter before more
+	// are dropped
+	otelQueue = 4096
+	// otelBatchMax and otelFlushEvery bound how long a span waits to be sent
+	otelBatchMax   = 512
+	otelFlushEvery = 5 * time.Second
+	// otelExportTimeout caps one export request
+	otelExportTimeout = 10 * time.Second
+	// otelShutdownTimeout is how long exit waits for the last spans to go out
+	otelShutdownTimeout = 5 * time.Second
+	// otelMaxEvents caps the events of one span; the rest are counted
+	otelMaxEvents = 128
+	// otelHostBatch is how many hosts one enricher span covers
+	otelHostBatch = 100
+)
+
+// otelAttr is a span, event or resource attribute
+type otelAttr struct {
+	key  string
+	str  string
+	num  int64
+	kind byte // 's' or 'i'
+}
+
+func otelStr(key, v string) otelAttr { return otelAttr{key: key, str: v, kind: 's'} }
+
+func otelInt(key string, v int) otelAttr { return otelAttr{key: key, num: int64(v), kind: 'i'} }
+
+type otelEvent struct {
+	at    time.Time
+	name  string
+	attrs []otelAttr
+}
+
+// otelSpan is one span of the run's trace. Every method is a no-op on a
+// nil span, which is what otelStart returns without -otel-endpoint or when
+// the run was not sampled, so instrumented code never checks.
+type otelSpan struct {
+	name   string
+	id     [8]byte
+	parent [8]byte
+	start  time.Time
+
+	mu      sync.Mutex
+	end     time.Time
+	attrs   []otelAttr
+	events  []otelEvent
+	dropped int    // events over otelMaxEvents
+	failed  string // the status message of a span that ended in error
+	ended   bool
+}
+
+// otelTracer exports the spans of a sampled run
+type otelTracer struct {
+	endpoint string // for messages
+	traceID  [16]byte
+	root     *otelSpan
+	send     func(ctx context.Context, req []byte) error
+
+	mu     sync.RWMutex
+	closed bool
+	spans  chan *otelSpan
+	done   chan struct{}
+	warned bool // an export failed and was reported
+}
+
+// tracer is nil unless -otel-endpoint is set and the run was sampled
+var tracer *otelTracer
+
+type otelSpanKey struct{}
+
+// configureOTel sets up the -otel-endpoint exporter and starts the run's
+// root span. A TRACEPARENT in the environment makes the run a child of
+// the caller's trace and its sampling decision wins over -otel-sample.
+// Called once after the run ID and target are known.
+func configureOTel(cmd, target string, sources []string) error {
+	if otelEndpoint == "" {
+		return nil
+	}
+	if otelSample < 0 || otelSample > 1 {
+		return fmt.Errorf("-otel-sample must be between 0 and 1, got %g", otelSample)
+	}
+	send, err := otelSender(otelEndpoint)
+	if err != nil {
+		return err
+	}
+	t := &otelTracer{
+		endpoint: otelEndpoint,
+		send:     send,
+		spans:    make(chan *otelSpan, otelQueue),
+		done:     make(chan struct{}),
+	}
+	var parent [8]byte
+	sampled := rand.Float64() < otelSample
+	if tp := os.Getenv("TRACEPARENT"); tp != "" {
+		traceID, spanID, flags, ok := parseTraceparent(tp)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring malformed TRACEPARENT %q\n", tp)
+		} else {
+			t.traceID, parent, sampled = traceID, spanID, flags&1 == 1
+		}
+	}
+	if !sampled {
+		stats.Add("otel.unsampled", 1)
+		return nil
+	}
+	if t.traceID == ([16]byte{}) {
+		binary.BigEndian.PutUint64(t.traceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(t.traceID[8:], rand.Uint64())
+	}
+	t.root = newOTelSpan("recon-engine "+cmd, parent, time.Now())
+	t.root.SetAttr(
+		otelStr("recon.target", target),
+		otelStr("recon.run_id", runID),
+		otelStr("recon.sources", strings.Join(sources, ",")),
+	)
+	tracer = t
+	go t.export()
+	fmt.Fprintf(os.Stderr, "Tracing to %s (trace %s)\n", redactSecrets(otelEndpoint), hex.EncodeToString(t.traceID[:]))
+	return nil
+}
+
+// otelSender returns what posts an export request to endpoint:
+// http(s)://host:port[/path] for OTLP/HTTP, which posts to /v1/traces
+// unless a path is given, or grpc://host:port (plaintext) and
+// grpcs://host:port for OTLP/gRPC. OTEL_EXPORTER_OTLP_HEADERS adds
+// headers to either, e.g. for an API key. The collector is always reached
+// directly, never through -proxy.
+func otelSender(endpoint string) (func(context.Context, []byte) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host (want e.g. http://localhost:4318 or grpc://localhost:4317)", endpoint)
+	}
+	headers, err := otelHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/traces"
+		}
+		client := &http.Client{Transport: &http.Transport{}, Timeout: otelExportTimeout}
+		target := u.String()
+		return func(ctx context.Context, req []byte) error {
+			r, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(req))
+			if err != nil {
+				return err
+			}
+			for k, v := range headers {
+				r.Header.Set(k, v)
+			}
+			r.Header.Set("Content-Type", "application/x-protobuf")
+			resp, err := client.Do(r)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+			}
+			return nil
+		}, nil
+	case "grpc", "grpcs":
+		if u.Path != "" && u.Path != "/" {
+			return nil, fmt.Errorf("%q: an OTLP/gRPC endpoint takes no path", endpoint)
+		}
+		transport := &http2.Transport{}
+		scheme := "https"
+		if u.Scheme == "grpc" {
+			scheme = "http"
+			transport.AllowHTTP = true
+			transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}
+		}
+		client := &http.Client{Transport: transport, Timeout: otelExportTimeout}
+		target := scheme + "://" + u.Host + "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+		return func(ctx context.Context, req []byte) error {
+			// A gRPC message is framed with a compression flag and its length
+			frame := make([]byte, 5+len(req))
+			binary.BigEndian.PutUint32(frame[1:5], uint32(len(req)))
+			copy(frame[5:], req)
+			r, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(frame))
+			if err != nil {
+				return err
+			}
+			for k, v := range headers {
+				r.Header.Set(k, v)
+			}
+			r.Header.Set("Content-Type", "application/grpc")
+			r.Header.Set("TE", "trailers")
+			resp, err := client.Do(r)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%s", resp.Status)
+			}
+			// Trailers, or headers for a trailers-only answer
+			status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+			if status == "" {
+				status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+			}
+			if status != "0" {
+				msg, _ = url.PathUnescape(msg)
+				return fmt.Errorf("grpc status %s: %s", status, msg)
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme in %q (want http, https, grpc or grpcs)", endpoint)
+	}
+}
+
+// otelHeaders parses OTEL_EXPORTER_OTLP_HEADERS: comma-separated
+// key=value pairs with URL-encoded values
+func otelHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range splitList(s) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("malformed header %q (want key=value)", pair)
+		}
+		if dv, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = dv
+		}
+		headers[strings.TrimSpace(k)] = v
+	}
+	return headers, nil
+}
+
+// parseTraceparent reads a W3C traceparent: 00-<trace id>-<span id>-<flags>
+func parseTraceparent(s string) (traceID [16]byte, spanID [8]byte, flags byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == ([16]byte{}) {
+		return
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil || spanID == ([8]byte{}) {
+		return
+	}
+	f, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return
+	}
+	return traceID, spanID, byte(f), true
+}
+
+func newOTelSpan(name string, parent [8]byte, start time.Time) *otelSpan {
+	s := &otelSpan{name: name, parent: parent, start: start}
+	binary.BigEndian.PutUint64(s.id[:], rand.Uint64()|1)
+	return s
+}
+
+// otelStart starts a span under the one in ctx, or under the run's root
+// span, and returns a context carrying it
+func otelStart(ctx context.Context, name string, attrs ...otelAttr) (context.Context, *otelSpan) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := newOTelSpan(name, otelParent(ctx).id, time.Now())
+	s.attrs = append(s.attrs, attrs...)
+	return context.WithValue(ctx, otelSpanKey{}, s), s
+}
+
+// otelParent returns the span in ctx, or the run's root span
+func otelParent(ctx context.Context) *otelSpan {
+	if s, ok := ctx.Value(otelSpanKey{}).(*otelSpan); ok {
+		return s
+	}
+	return tracer.root
+}
+
+// SetAttr adds attributes, replacing those with the same key
+func (s *otelSpan) SetAttr(attrs ...otelAttr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+next:
+	for _, a := range attrs {
+		for i := range s.attrs {
+			if s.attrs[i].key == a.key {
+				s.attrs[i] = a
+				continue next
+			}
+		}
+		s.attrs = append(s.attrs, a)
+	}
+}
+
+// Event records that something happened during the span
+func (s *otelSpan) Event(name string, attrs ...otelAttr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if len(s.events) >= otelMaxEvents {
+		s.dropped++
+		return
+	}
+	s.events = append(s.events, otelEvent{at: time.Now(), name: name, attrs: attrs})
+}
+
+// Error marks the span failed with err, recording a timeout or exception
+// event with the exit code of a tool that exited non-zero
+func (s *otelSpan) Error(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	msg := redactSecrets(err.Error())
+	attrs := []otelAttr{otelStr("exception.message", msg)}
+	if code, ok := otelExitCode(err); ok {
+		attrs = append(attrs, otelInt("recon.exit_code", code))
+	}
+	name := "exception"
+	if errors.Is(err, context.DeadlineExceeded) {
+		name = "timeout"
+	}
+	s.Event(name, attrs...)
+	s.mu.Lock()
+	if !s.ended {
+		s.failed = msg
+	}
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export
+func (s *otelSpan) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	tracer.queue(s)
+}
+
+// otelExitCode returns the exit code of a tool that exited non-zero
+func otelExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// otelToolError records a tool failure as an event on the root span
+func otelToolError(rec eventRecord) {
+	if tracer == nil {
+		return
+	}
+	name := "tool_error"
+	if rec.Kind == eventTimeout {
+		name = "timeout"
+	}
+	attrs := []otelAttr{
+		otelStr("recon.tool", rec.Tool),
+		otelStr("recon.stage", rec.Stage),
+		otelStr("recon.kind", rec.Kind),
+		otelStr("exception.message", rec.Message),
+	}
+	if rec.ExitCode != nil {
+		attrs = append(attrs, otelInt("recon.exit_code", *rec.ExitCode))
+	}
+	tracer.root.Event(name, attrs...)
+}
+
+// otelBatch aggregates one enricher's steps into a span per otelHostBatch
+// hosts, as a span per host would bury the trace
+type otelBatch struct {
+	span  *otelSpan
+	hosts int
+	busy  time.Duration
+}
+
+type otelBatchKey struct {
+	parent *otelSpan
+	stage  string
+}
+
+var (
+	otelBatches   = make(map[otelBatchKey]*otelBatch)
+	otelBatchesMu sync.Mutex
+)
+
+// otelStep runs one enricher's step for a host, counting it towards the
+// enricher's current batch span
+func otelStep(ctx context.Context, stage string, fn func()) {
+	if tracer == nil {
+		fn()
+		return
+	}
+	start := time.Now()
+	fn()
+	busy := time.Since(start)
+
+	parent := otelParent(ctx)
+	key := otelBatchKey{parent, stage}
+	otelBatchesMu.Lock()
+	defer otelBatchesMu.Unlock()
+	b := otelBatches[key]
+	if b == nil {
+		b = &otelBatch{span: newOTelSpan("enrich "+stage, parent.id, start)}
+		b.span.attrs = []otelAttr{otelStr("recon.enricher", stage)}
+		otelBatches[key] = b
+	}
+	b.hosts++
+	b.busy += busy
+	if ctx.Err() != nil {
+		b.span.Event("cancelled", otelStr("exception.message", ctx.Err().Error()))
+	}
+	if b.hosts >= otelHostBatch {
+		b.end()
+		delete(otelBatches, key)
+	}
+}
+
+func (b *otelBatch) end() {
+	b.span.SetAttr(otelInt("recon.hosts", b.hosts), otelInt("recon.busy_ms", int(b.busy.Milliseconds())))
+	b.span.End()
+}
+
+// otelEndBatches ends the partial enricher batches under parent, or all of
+// them when parent is nil
+func otelEndBatches(parent *otelSpan) {
+	if tracer == nil {
+		return
+	}
+	otelBatchesMu.Lock()
+	defer otelBatchesMu.Unlock()
+	for key, b := range otelBatches {
+		if parent == nil || key.parent == parent {
+			b.end()
+			delete(otelBatches, key)
+		}
+	}
+}
+
+// queue hands an ended span to the exporter, dropping it when the queue
+// is full or the exporter has shut down
+func (t *otelTracer) queue(s *otelSpan) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		stats.Add("otel.dropped", 1)
+		return
+	}
+	select {
+	case t.spans <- s:
+	default:
+		stats.Add("otel.dropped", 1)
+	}
+}
+
+// export sends the queued spans in batches until the queue is closed
+func (t *otelTracer) export() {
+	defer close(t.done)
+	ticker := time.NewTicker(otelFlushEvery)
+	defer ticker.Stop()
+	var batch []*otelSpan
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), otelExportTimeout)
+		defer cancel()
+		if err := t.send(ctx, t.encode(batch)); err != nil {
+			stats.Add("otel.export_errors", 1)
+			stats.Add("otel.dropped", int64(len(batch)))
+			if !t.warned {
+				t.warned = true
+				fmt.Fprintf(os.Stderr, "Warning: OpenTelemetry export to %s failed: %v\n", redactSecrets(t.endpoint), redactSecrets(err.Error()))
+			}
+		} else {
+			stats.Add("otel.spans", int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s, ok := <-t.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= otelBatchMax {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// otelShutdown ends the open batch spans and the root span, recording the
+// exit code, and waits up to otelShutdownTimeout for the exporter to send
+// what is queued. exit calls it, so every way out of a scan, signals
+// included, flushes the trace.
+func otelShutdown(code int) {
+	t := tracer
+	if t == nil {
+		return
+	}
+	otelEndBatches(nil)
+	t.root.SetAttr(otelInt("recon.exit_code", code))
+	if code != 0 {
+		t.root.mu.Lock()
+		if t.root.failed == "" {
+			t.root.failed = fmt.Sprintf("exit code %d", code)
+		}
+		t.root.mu.Unlock()
+	}
+	t.root.End()
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.spans)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+	case <-time.After(otelShutdownTimeout):
+		fmt.Fprintln(os.Stderr, "Warning: gave up flushing OpenTelemetry spans")
+	}
+}
+
+// encode builds an OTLP ExportTraceServiceRequest in protobuf
+func (t *otelTracer) encode(spans []*otelSpan) []byte {
+	var resource, scope, scopeSpans pbuf
+	for _, a := range []otelAttr{
+		otelStr("service.name", "recon-engine"),
+		otelStr("service.version", version),
+		otelStr("service.instance.id", runID),
+	} {
+		resource = resource.message(1, a.encode())
+	}
+	scope = scope.str(1, "recon-engine").str(2, version)
+	scopeSpans = scopeSpans.message(1, scope)
+	for _, s := range spans {
+		scopeSpans = scopeSpans.message(2, t.encodeSpan(s))
+	}
+	var rs, req pbuf
+	rs = rs.message(1, resource).message(2, scopeSpans)
+	return req.message(1, rs)
+}
+
+func (t *otelTracer) encodeSpan(s *otelSpan) pbuf {
+	var b pbuf
+	b = b.bytes(1, t.traceID[:]).bytes(2, s.id[:])
+	if s.parent != ([8]byte{}) {
+		b = b.bytes(4, s.parent[:])
+	}
+	b = b.str(5, s.name).varintField(6, 1) // SPAN_KIND_INTERNAL
+	b = b.fixed64(7, uint64(s.start.UnixNano())).fixed64(8, uint64(s.end.UnixNano()))
+	for _, a := range s.attrs {
+		b = b.message(9, a.encode())
+	}
+	for _, e := range s.events {
+		var ev pbuf
+		ev = ev.fixed64(1, uint64(e.at.UnixNano())).str(2, e.name)
+		for _, a := range e.attrs {
+			ev = ev.message(3, a.encode())
+		}
+		b = b.message(11, ev)
+	}
+	if s.dropped > 0 {
+		b = b.varintField(12, uint64(s.dropped))
+	}
+	if s.failed != "" {
+		var st pbuf
+		st = st.str(2, s.failed).varintField(3, 2) // STATUS_CODE_ERROR
+		b = b.message(15, st)
+	}
+	return b
+}
+
+// encode builds a KeyValue
+func (a otelAttr) encode() pbuf {
+	var v pbuf
+	switch a.kind {
+	case 's':
+		v = v.str(1, a.str)
+	default:
+		v = v.varintField(3, uint64(a.num))
+	}
+	var kv pbuf
+	return kv.str(1, a.key).message(2, v)
+}
+
+// pbuf appends protobuf fields, just what the OTLP messages need
+type pbuf []byte
+
+func (b pbuf) tag(field, wire int) pbuf {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func (b pbuf) varintField(field int, v uint64) pbuf {
+	return binary.AppendUvarint(b.tag(field, 0), v)
+}
+
+func (b pbuf) fixed64(field int, v uint64) pbuf {
+	return binary.LittleEndian.AppendUint64(b.tag(field, 1), v)
+}
+
+func (b pbuf) bytes(field int, v []byte) pbuf {
+	b = binary.AppendUvarint(b.tag(field, 2), uint64(len(v)))
+	return append(b, v...)
+}
+
+func (b pbuf) str(field int, v string) pbuf {
+	b = binary.AppendUvarint(b.tag(field, 2), uint64(len(v)))
+	return append(b, v...)
+}
+
+func (b pbuf) message(field int, m pbuf) pbuf {
+	return b.bytes(field, m)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	outputFile *os.File
+	outputGzip *gzip.Writer   // set with -compress
+	outputEnc  *encryptWriter // set with -encrypt-output
+
+	// outputSyncStop ends the -sync-interval goroutine
+	outputSyncStop chan struct{}
+	outputSyncDone sync.WaitGroup
+)
+
+// outputWriter counts the records written to -o and ends the gzip block
+// and encrypted chunk every -flush-every records, so a killed run loses at
+// most that many. Every record arrives in one Write, under stdout's lock.
+type outputWriter struct {
+	w       io.Writer
+	records int
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	if err != nil || (outputGzip == nil && outputEnc == nil) || flushEvery <= 0 {
+		return n, err
+	}
+	if o.records++; o.records%flushEvery == 0 {
+		err = flushOutputLocked()
+	}
+	return n, err
+}
+
+// configureOutput points the shared stdout writer at -o, gzipped with
+// -compress. With appendTo, as resume does, an existing -o file is
+// repaired and added to instead of replaced. Called once after flag
+// parsing.
+func configureOutput(appendTo bool) error {
+	if outputPath == "" {
+		if compressOutput {
+			return fmt.Errorf("-compress needs -o: compressed stdout cannot be piped into jq")
+		}
+		return nil
+	}
+	if flushEvery < 0 {
+		return fmt.Errorf("-flush-every must not be negative")
+	}
+	if compressOutput && !strings.HasSuffix(outputPath, ".gz") {
+		outputPath += ".gz"
+	}
+	// junit and defectdojo are one document per run, not a record stream
+	if appendTo && (outputFormat == "" || outputFormat == "json") {
+		if err := repairOutput(outputPath, compressOutput, encryptKey != nil); err != nil {
+			return err
+		}
+	} else {
+		appendTo = false
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(outputPath, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	outputFile = f
+	var w io.Writer = f
+	if encryptKey != nil {
+		// Appending starts a new stream, which decryption reads as more
+		// of the same file
+		if outputEnc, err = newEncryptWriter(f); err != nil {
+			return err
+		}
+		w = outputEnc
+	}
+	if compressOutput {
+		// Appending starts a new gzip member, which readers take as more of
+		// the same stream
+		outputGzip = gzip.NewWriter(w)
+		w = outputGzip
+	}
+	stdout.mu.Lock()
+	stdout.w = &outputWriter{w: w}
+	stdout.mu.Unlock()
+
+	if syncInterval > 0 {
+		outputSyncStop = make(chan struct{})
+		outputSyncDone.Add(1)
+		go syncOutputPeriodically()
+	}
+	return nil
+}
+
+// repairOutput cuts a record a killed run left half-written off the end of
+// the -o file at path, so records appended after it stay parseable. A
+// gzipped or encrypted file is rewritten from what still decompresses and
+// decrypts. A missing file needs no repair.
+func repairOutput(path string, compressed, encrypted bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return repairEncryptedOutput(path, data, compressed)
+	}
+	if compressed {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			if len(data) > 0 {
+				fmt.Fprintf(os.Stderr, "Warning: %s is not readable gzip (%v), starting it over\n", path, err)
+			}
+			return os.WriteFile(path, nil, 0o644)
+		}
+		plain, rerr := io.ReadAll(zr)
+		good := completeRecords(plain)
+		if rerr == nil && len(good) == len(plain) {
+			return nil
+		}
+		if len(good) < len(plain) {
+			fmt.Fprintf(os.Stderr, "Warning: %s ends in a truncated record, dropping %d bytes of it\n", path, len(plain)-len(good))
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(good)
+		zw.Close()
+		return replaceFile(path, buf.Bytes())
+	}
+
+	good := completeRecords(data)
+	switch {
+	case len(good) == len(data):
+		return nil
+	case len(good) == len(data)+1:
+		// The last record is whole, only its newline is missing
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte("\n"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s ends in a truncated record, dropping %d bytes of it\n", path, len(data)-len(good))
+	return os.Truncate(path, int64(len(good)))
+}
+
+// repairEncryptedOutput is repairOutput for an -encrypt-output file: what
+// decrypts, and decompresses, is cut to whole records and encrypted again
+// when the file did not end cleanly
+func repairEncryptedOutput(path string, data []byte, compressed bool) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if !isEncrypted(data) {
+		return fmt.Errorf("%s is not encrypted; appending encrypted records would leave it unreadable", path)
+	}
+	d := &decryptReader{r: bufio.NewReader(bytes.NewReader(data))}
+	plain, err := io.ReadAll(d)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	clean := !d.Truncated
+	if compressed && len(plain) > 0 {
+		zr, err := gzip.NewReader(bytes.NewReader(plain))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		var rerr error
+		plain, rerr = io.ReadAll(zr)
+		clean = clean && rerr == nil
+	}
+	good := completeRecords(plain)
+	if clean && len(good) == len(plain) {
+		return nil
+	}
+	if len(good) < len(plain) {
+		fmt.Fprintf(os.Stderr, "Warning: %s ends in a truncated record, dropping %d bytes of it\n", path, len(plain)-len(good))
+	}
+	if compressed {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(good)
+		zw.Close()
+		good = buf.Bytes()
+	}
+	enc, err := encryptBytes(good)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, enc)
+}
+
+// completeRecords returns the part of NDJSON output made of whole lines. A
+// last line without its newline counts when it is valid JSON; the result
+// then has that newline added.
+func completeRecords(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	i := bytes.LastIndexByte(data, '\n') + 1
+	if json.Valid(data[i:]) {
+		return append(data[:len(data):len(data)], '\n')
+	}
+	return data[:i]
+}
+
+// replaceFile writes data to path through a temporary file
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// flushOutput makes everything written so far readable from the -o file.
+// With -compress it ends the current deflate block, so a copy taken now
+// decompresses up to the last record.
+func flushOutput() error {
+	stdout.mu.Lock()
+	defer stdout.mu.Unlock()
+	return flushOutputLocked()
+}
+
+// flushOutputLocked is flushOutput with stdout's lock held. The gzip
+// block goes out first so the chunk sealed after it holds all of it.
+func flushOutputLocked() error {
+	if outputGzip != nil {
+		if err := outputGzip.Flush(); err != nil {
+			return err
+		}
+	}
+	if outputEnc != nil {
+		return outputEnc.Flush()
+	}
+	return nil
+}
+
+// syncOutput flushes the -o file and asks the OS to put it on disk, so it
+// survives the machine going down, not just the process
+func syncOutput() error {
+	stdout.mu.Lock()
+	defer stdout.mu.Unlock()
+	if outputFile == nil {
+		return nil
+	}
+	if err := flushOutputLocked(); err != nil {
+		return err
+	}
+	return outputFile.Sync()
+}
+
+// syncOutputPeriodically runs syncOutput every -sync-interval
+func syncOutputPeriodically() {
+	defer outputSyncDone.Done()
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-outputSyncStop:
+			return
+		case <-ticker.C:
+		}
+		if err := syncOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error syncing -o: %v\n", err)
+		}
+	}
+}
+
+// closeOutput finishes the -o file. It runs on interrupted runs too so the
+// gzip trailer is always written.
+func closeOutput() {
+	if outputFile == nil {
+		return
+	}
+	if outputSyncStop != nil {
+		close(outputSyncStop)
+		outputSyncDone.Wait()
+		outputSyncStop = nil
+	}
+	stdout.mu.Lock()
+	defer stdout.mu.Unlock()
+	if outputGzip != nil {
+		if err := outputGzip.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -o: %v\n", err)
+		}
+	}
+	if outputEnc != nil {
+		if err := outputEnc.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -o: %v\n", err)
+		}
+		outputEnc = nil
+	}
+	if err := outputFile.Sync(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -o: %v\n", err)
+	}
+	if err := outputFile.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -o: %v\n", err)
+	}
+	stdout.w = io.Discard
+	outputFile = nil
+}
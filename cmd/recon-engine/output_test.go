@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompleteRecords(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"", ""},
+		{"{\"a\":1}\n", "{\"a\":1}\n"},
+		{"{\"a\":1}\n{\"b\":", "{\"a\":1}\n"},
+		// A whole last record only lost its newline
+		{"{\"a\":1}\n{\"b\":2}", "{\"a\":1}\n{\"b\":2}\n"},
+		{"{\"b\":", ""},
+	} {
+		if got := string(completeRecords([]byte(c.in))); got != c.want {
+			t.Errorf("completeRecords(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRepairOutput(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct{ in, want string }{
+		{"{\"a\":1}\n{\"b\":2}\n", "{\"a\":1}\n{\"b\":2}\n"},
+		{"{\"a\":1}\n{\"b\":2", "{\"a\":1}\n"},
+		{"{\"a\":1}\n{\"b\":2}", "{\"a\":1}\n{\"b\":2}\n"},
+	} {
+		path := filepath.Join(dir, "out.json")
+		if err := os.WriteFile(path, []byte(c.in), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := repairOutput(path, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(path); string(b) != c.want {
+			t.Errorf("repaired %q to %q, want %q", c.in, b, c.want)
+		}
+	}
+	if err := repairOutput(filepath.Join(dir, "missing.json"), false, false); err != nil {
+		t.Errorf("a missing file: %v", err)
+	}
+}
+
+// TestRepairCompressedOutput repairs a gzip file a killed run left without
+// its trailer, after a flush that ended mid-record
+func TestRepairCompressedOutput(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("{\"a\":1}\n{\"b\":2}\n{\"c\":"))
+	zw.Flush()
+	path := filepath.Join(t.TempDir(), "out.json.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repairOutput(path, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := gunzipFile(t, path); got != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Errorf("repaired to %q", got)
+	}
+}
+
+func gunzipFile(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file still being written has no trailer yet
+	b, err := io.ReadAll(zr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestOutputWriterFlushEvery makes every -flush-every records readable
+// from a gzipped -o while the run is still writing it
+func TestOutputWriterFlushEvery(t *testing.T) {
+	oldEvery, oldGzip, oldEnc := flushEvery, outputGzip, outputEnc
+	t.Cleanup(func() { flushEvery, outputGzip, outputEnc = oldEvery, oldGzip, oldEnc })
+	path := filepath.Join(t.TempDir(), "out.json.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	flushEvery, outputEnc = 2, nil
+	outputGzip = gzip.NewWriter(f)
+	w := &outputWriter{w: outputGzip}
+
+	w.Write([]byte("{\"a\":1}\n"))
+	if got := gunzipFile(t, path); got != "" {
+		t.Errorf("flushed after one record: %q", got)
+	}
+	w.Write([]byte("{\"b\":2}\n"))
+	w.Write([]byte("{\"c\":3}\n"))
+	if got := gunzipFile(t, path); got != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Errorf("after three records the file holds %q", got)
+	}
+}
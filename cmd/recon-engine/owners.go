@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ownerUnassigned is the owner of the hosts no -owners pattern matches
+const ownerUnassigned = "unassigned"
+
+// Owner pattern kinds, most specific first
+const (
+	ownerExact = iota
+	ownerGlob
+	ownerRegex
+)
+
+// owner is one team of -owners and the host patterns it owns
+type owner struct {
+	Name     string   `yaml:"name"`
+	Contact  string   `yaml:"contact"`
+	Channel  string   `yaml:"channel"` // e.g. a Slack channel, for the summary
+	Webhook  string   `yaml:"webhook"` // where -webhook-url notifications for its hosts go instead
+	Patterns []string `yaml:"patterns"`
+}
+
+// ownersFile is what -owners holds. unassigned takes the hosts no pattern
+// matches; its name is always "unassigned".
+type ownersFile struct {
+	Owners     []*owner `yaml:"owners"`
+	Unassigned *owner   `yaml:"unassigned"`
+}
+
+// ownerPattern is one compiled -owners pattern
+type ownerPattern struct {
+	owner   *owner
+	kind    int
+	exact   string
+	glob    string
+	re      *regexp.Regexp
+	literal int // characters of a glob that are not wildcards, or of a regexp
+	order   int // position in the file
+}
+
+// ownerSummary is one owner in the run summary
+type ownerSummary struct {
+	Contact    string           `json:"contact,omitempty"`
+	Channel    string           `json:"channel,omitempty"`
+	Results    int              `json:"results"`
+	Findings   int              `json:"findings"`
+	Severities map[Severity]int `json:"severities,omitempty"`
+}
+
+var (
+	ownerPatterns []ownerPattern
+	ownerDefault  *owner
+	ownerWebhooks bool // some owner has a webhook
+)
+
+// configureOwners loads -owners. Called once after flag parsing.
+func configureOwners() error {
+	if ownersPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(ownersPath)
+	if err != nil {
+		return err
+	}
+	var f ownersFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("%s: %w", ownersPath, err)
+	}
+	if len(f.Owners) == 0 {
+		return fmt.Errorf("%s lists no owners", ownersPath)
+	}
+	if f.Unassigned == nil {
+		f.Unassigned = &owner{}
+	}
+	f.Unassigned.Name = ownerUnassigned
+	names := make(map[string]bool)
+	for i, o := range append(f.Owners, f.Unassigned) {
+		where := fmt.Sprintf("%s: owner %d", ownersPath, i+1)
+		if o == f.Unassigned {
+			where = ownersPath + ": unassigned"
+		} else if o.Name == "" || o.Name == ownerUnassigned {
+			return fmt.Errorf("%s needs a name other than %q", where, ownerUnassigned)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("%s: owner %q is listed twice", ownersPath, o.Name)
+		}
+		names[o.Name] = true
+		if o.Webhook != "" {
+			u, err := url.Parse(o.Webhook)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s: webhook is not an http(s) URL", where)
+			}
+			// Slack webhook URLs are credentials
+			addRedaction(o.Webhook)
+			ownerWebhooks = true
+		}
+		if o == f.Unassigned {
+			if len(o.Patterns) > 0 {
+				return fmt.Errorf("%s takes no patterns", where)
+			}
+			continue
+		}
+		if len(o.Patterns) == 0 {
+			return fmt.Errorf("%s (%s) has no patterns", where, o.Name)
+		}
+		for _, raw := range o.Patterns {
+			p, err := parseOwnerPattern(raw)
+			if err != nil {
+				return fmt.Errorf("%s (%s): %w", where, o.Name, err)
+			}
+			p.owner, p.order = o, len(ownerPatterns)
+			ownerPatterns = append(ownerPatterns, p)
+		}
+	}
+	ownerDefault = f.Unassigned
+	return nil
+}
+
+// parseOwnerPattern reads a host name, a glob such as *.pay.example.com, or
+// a regular expression written re:expr
+func parseOwnerPattern(raw string) (ownerPattern, error) {
+	s := strings.TrimSpace(raw)
+	if expr, ok := strings.CutPrefix(s, "re:"); ok {
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return ownerPattern{}, fmt.Errorf("pattern %q: %w", raw, err)
+		}
+		return ownerPattern{kind: ownerRegex, re: re, literal: len(expr)}, nil
+	}
+	s = strings.TrimSuffix(strings.ToLower(s), ".")
+	if s == "" {
+		return ownerPattern{}, fmt.Errorf("empty pattern")
+	}
+	if !strings.ContainsAny(s, "*?[") {
+		return ownerPattern{kind: ownerExact, exact: s}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return ownerPattern{}, fmt.Errorf("pattern %q: %w", raw, err)
+	}
+	literal := 0
+	for _, c := range s {
+		if c != '*' && c != '?' {
+			literal++
+		}
+	}
+	return ownerPattern{kind: ownerGlob, glob: s, literal: literal}, nil
+}
+
+func (p ownerPattern) matches(host string) bool {
+	switch p.kind {
+	case ownerExact:
+		return host == p.exact
+	case ownerGlob:
+		ok, _ := path.Match(p.glob, host)
+		return ok
+	}
+	return p.re.MatchString(host)
+}
+
+// moreSpecific reports whether p wins over q when both match: a host name
+// over a glob over a regular expression, then the glob with more literal
+// characters or the longer regular expression, then whichever comes first
+// in the file
+func (p ownerPattern) moreSpecific(q ownerPattern) bool {
+	if p.kind != q.kind {
+		return p.kind < q.kind
+	}
+	if p.literal != q.literal {
+		return p.literal > q.literal
+	}
+	return p.order < q.order
+}
+
+// ownerOf returns the owner of host: that of the most specific matching
+// pattern, or the unassigned owner
+func ownerOf(host string) *owner {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	var best *ownerPattern
+	for i := range ownerPatterns {
+		p := &ownerPatterns[i]
+		if p.matches(host) && (best == nil || p.moreSpecific(*best)) {
+			best = p
+		}
+	}
+	if best == nil {
+		return ownerDefault
+	}
+	return best.owner
+}
+
+// resultOwner returns the owner of res's host, nil without -owners
+func resultOwner(res Result) *owner {
+	if ownerDefault == nil {
+		return nil
+	}
+	host := res.Subdomain
+	if host == "" {
+		host = rawURLHost(res.URL)
+	}
+	if host == "" {
+		host = res.IP
+	}
+	return ownerOf(host)
+}
+
+// applyOwner tags res with its owner and counts it in the run summary
+func applyOwner(res *Result) {
+	o := resultOwner(*res)
+	if o == nil {
+		return
+	}
+	res.Owner, res.OwnerContact = o.Name, o.Contact
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	if summary.Owners == nil {
+		summary.Owners = make(map[string]*ownerSummary)
+	}
+	s := summary.Owners[o.Name]
+	if s == nil {
+		s = &ownerSummary{Contact: o.Contact, Channel: o.Channel}
+		summary.Owners[o.Name] = s
+	}
+	s.Results++
+	s.Findings += len(res.Vulnerabilities)
+	for _, v := range res.Vulnerabilities {
+		if s.Severities == nil {
+			s.Severities = make(map[Severity]int)
+		}
+		s.Severities[v.Severity]++
+	}
+}
+
+// ownerWebhook returns the webhook res's notifications go to: its owner's,
+// or -webhook-url
+func ownerWebhook(res Result) string {
+	if o := resultOwner(res); o != nil && o.Webhook != "" {
+		return o.Webhook
+	}
+	return webhookURL
+}
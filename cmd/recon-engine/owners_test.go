@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadOwners loads data as -owners for the test
+func loadOwners(t *testing.T, data string) error {
+	t.Helper()
+	clearSecrets(t)
+	oldPath, oldPatterns, oldDefault, oldWebhooks, oldURL := ownersPath, ownerPatterns, ownerDefault, ownerWebhooks, webhookURL
+	summary.mu.Lock()
+	oldSummary := summary.Owners
+	summary.Owners = nil
+	summary.mu.Unlock()
+	t.Cleanup(func() {
+		ownersPath, ownerPatterns, ownerDefault, ownerWebhooks, webhookURL = oldPath, oldPatterns, oldDefault, oldWebhooks, oldURL
+		summary.mu.Lock()
+		summary.Owners = oldSummary
+		summary.mu.Unlock()
+	})
+	ownerPatterns, ownerDefault, ownerWebhooks = nil, nil, false
+	ownersPath = filepath.Join(t.TempDir(), "owners.yaml")
+	if err := os.WriteFile(ownersPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return configureOwners()
+}
+
+const testOwners = `owners:
+  - name: web
+    contact: web@example.com
+    patterns: ["re:^(www|shop)\\.", "*.example.com"]
+  - name: payments
+    contact: pay@example.com
+    webhook: https://hooks.example.com/services/T000/B000/payments
+    patterns: ["*.pay.example.com", "re:pay"]
+  - name: checkout
+    patterns: ["checkout.pay.example.com", "*-api.pay.example.com"]
+  - name: legacy
+    patterns: ["*.example.com"]
+unassigned:
+  contact: secops@example.com
+`
+
+// TestOwnerPrecedence picks the most specific matching pattern: a host
+// name over a glob over a regular expression, then the longer one, then
+// the first in the file
+func TestOwnerPrecedence(t *testing.T) {
+	if err := loadOwners(t, testOwners); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ host, want string }{
+		{"checkout.pay.example.com", "checkout"},
+		{"Checkout.Pay.Example.com.", "checkout"},
+		// *-api.pay.example.com has more literal characters than *.pay.example.com
+		{"cards-api.pay.example.com", "checkout"},
+		{"cards.pay.example.com", "payments"},
+		// A glob wins over a regular expression, whatever their order
+		{"www.example.com", "web"},
+		{"payroll.example.com", "web"},
+		{"paypal.example.org", "payments"},
+		{"www.example.org", "web"},
+		{"other.example.org", ownerUnassigned},
+	} {
+		// Deterministic: the same every time
+		for i := 0; i < 3; i++ {
+			if got := ownerOf(c.host).Name; got != c.want {
+				t.Errorf("ownerOf(%q) = %s, want %s", c.host, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestApplyOwner(t *testing.T) {
+	if err := loadOwners(t, testOwners); err != nil {
+		t.Fatal(err)
+	}
+	webhookURL = "https://hooks.example.com/services/T000/B000/default"
+	results := []Result{
+		{Subdomain: "cards.pay.example.com", Vulnerabilities: []Finding{
+			newFinding("nuclei", "exposed-panel", "high", confidenceConfirmed, "", nil),
+			newFinding("headers", "missing-hsts", "info", confidenceConfirmed, "", nil),
+		}},
+		{URL: "https://api.pay.example.com:8443/login"},
+		{IP: "192.0.2.1"},
+	}
+	for i := range results {
+		applyOwner(&results[i])
+	}
+	if r := results[0]; r.Owner != "payments" || r.OwnerContact != "pay@example.com" {
+		t.Errorf("owner %q, contact %q", r.Owner, r.OwnerContact)
+	}
+	if results[1].Owner != "payments" {
+		t.Errorf("a result with only a URL: owner %q", results[1].Owner)
+	}
+	if r := results[2]; r.Owner != ownerUnassigned || r.OwnerContact != "secops@example.com" {
+		t.Errorf("unmatched host: owner %q, contact %q", r.Owner, r.OwnerContact)
+	}
+
+	pay := summary.Owners["payments"]
+	if pay == nil || pay.Results != 2 || pay.Findings != 2 || pay.Severities[severityHigh] != 1 || pay.Severities[severityInfo] != 1 {
+		t.Errorf("payments summary %+v", pay)
+	}
+	if u := summary.Owners[ownerUnassigned]; u == nil || u.Results != 1 || u.Findings != 0 {
+		t.Errorf("unassigned summary %+v", u)
+	}
+
+	if got := ownerWebhook(results[0]); got != "https://hooks.example.com/services/T000/B000/payments" {
+		t.Errorf("payments notifications go to %s", got)
+	}
+	if got := ownerWebhook(results[2]); got != webhookURL {
+		t.Errorf("unassigned notifications go to %s", got)
+	}
+	if got := redactSecrets("POST https://hooks.example.com/services/T000/B000/payments"); got == "POST https://hooks.example.com/services/T000/B000/payments" {
+		t.Error("an owner's webhook is not redacted")
+	}
+}
+
+// TestNoOwners leaves results untagged without -owners
+func TestNoOwners(t *testing.T) {
+	oldDefault := ownerDefault
+	t.Cleanup(func() { ownerDefault = oldDefault })
+	ownerDefault = nil
+	res := Result{Subdomain: "www.example.com"}
+	applyOwner(&res)
+	if res.Owner != "" {
+		t.Errorf("owner %q without -owners", res.Owner)
+	}
+}
+
+func TestConfigureOwnersRejects(t *testing.T) {
+	for _, data := range []string{
+		"owners: []\n",
+		"owners:\n  - patterns: [a.example.com]\n",
+		"owners:\n  - name: unassigned\n    patterns: [a.example.com]\n",
+		"owners:\n  - name: web\n",
+		"owners:\n  - name: web\n    patterns: [a.example.com]\n  - name: web\n    patterns: [b.example.com]\n",
+		"owners:\n  - name: web\n    patterns: [\"re:(\"]\n",
+		"owners:\n  - name: web\n    patterns: [\"[a-\"]\n",
+		"owners:\n  - name: web\n    patterns: [\" \"]\n",
+		"owners:\n  - name: web\n    webhook: ftp://example.com\n    patterns: [a.example.com]\n",
+		"owners:\n  - name: web\n    patterns: [a.example.com]\nunassigned:\n  patterns: [b.example.com]\n",
+	} {
+		if err := loadOwners(t, data); err == nil {
+			t.Errorf("accepted %q", data)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+//go:embed wordlists/parameters.txt
+var defaultParamWordlist string
+
+const (
+	// paramsBodyMax caps how much of a response is searched for forms and
+	// reflected values
+	paramsBodyMax = 512 << 10
+	// paramsBatch is how many candidate parameters one probe request carries
+	paramsBatch = 10
+)
+
+// paramWords are the -params-wordlist candidates, cut to -params-probe-max
+var paramWords []string
+
+var paramsHTTP = newHTTPClient(10*time.Second, false)
+
+var (
+	paramsBudgetMu      sync.Mutex
+	paramsBudgetUsed    int
+	paramsBudgetTripped sync.Once
+)
+
+// paramsPriorityFlags are the triage flags whose hosts -params-budget keeps
+// half of the budget for
+var paramsPriorityFlags = []string{"login-page", "admin-panel"}
+
+// configureParams validates the -params flags and loads the candidate
+// wordlist. Called once after flag parsing.
+func configureParams() error {
+	if !paramsFlag {
+		if paramsProbe {
+			return fmt.Errorf("-params-probe needs -params")
+		}
+		return nil
+	}
+	switch {
+	case paramsMax < 1:
+		return fmt.Errorf("-params-max must be at least 1")
+	case paramsProbeMax < 1:
+		return fmt.Errorf("-params-probe-max must be at least 1")
+	case paramsBudget < 0:
+		return fmt.Errorf("-params-budget cannot be negative")
+	}
+	if !paramsProbe {
+		return nil
+	}
+
+	var words io.Reader = strings.NewReader(defaultParamWordlist)
+	if paramsWordlist != "" {
+		f, err := os.Open(paramsWordlist)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		words = f
+	}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(words)
+	for scanner.Scan() && len(paramWords) < paramsProbeMax {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") || seen[word] {
+			continue
+		}
+		seen[word] = true
+		paramWords = append(paramWords, word)
+	}
+	return scanner.Err()
+}
+
+// discoverParams fills res.Parameters with the query parameter names of
+// the host's URLs the run already knows (redirects, sitemap, robots.txt,
+// -dirbrute hits) and the form fields and same-host links of its page, then
+// with -params-probe sends the candidate wordlist and keeps the names that
+// are reflected or change the response. Hosts outside -scope, soft-404
+// pages, which say nothing about the host, and, with -polite, pages
+// robots.txt disallows get no request.
+func discoverParams(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] && len(res.Parameters) < paramsMax {
+			seen[name] = true
+			res.Parameters = append(res.Parameters, name)
+		}
+	}
+	addQuery := func(ref string) {
+		if t, err := u.Parse(ref); err == nil && strings.EqualFold(t.Host, u.Host) {
+			for _, name := range queryNames(t.RawQuery) {
+				add(name)
+			}
+		}
+	}
+
+	for _, ref := range knownURLs(res) {
+		addQuery(ref)
+	}
+
+	if res.Soft404 || !scopeAllowsHost(u.Hostname()) || robotsDisallowed(ctx, res.URL) {
+		return
+	}
+	status, body, err := paramsFetch(ctx, res.URL)
+	if err != nil {
+		return
+	}
+	var formFields []string
+	formFields, res.pageLinks = pageParams(body)
+	for _, name := range formFields {
+		add(name)
+	}
+	for _, ref := range res.pageLinks {
+		addQuery(ref)
+	}
+
+	if !paramsProbe || len(res.Parameters) >= paramsMax {
+		return
+	}
+	var candidates []string
+	for _, w := range paramWords {
+		if !seen[w] {
+			candidates = append(candidates, w)
+		}
+	}
+	priority := hasPriorityFlag(res)
+	for _, name := range probeParams(ctx, u, status, len(body), candidates, priority) {
+		add(name)
+	}
+}
+
+// knownURLs returns the URLs and paths of res the run collected so far:
+// its redirects, -robots entries, -dirbrute hits and, after -params, the
+// links of its page. References may be relative to res.URL.
+func knownURLs(res *Result) []string {
+	refs := append([]string{res.URL, res.FinalURL}, res.RedirectChain...)
+	refs = append(refs, res.SitemapURLs...)
+	refs = append(refs, res.RobotsDisallow...)
+	for _, p := range res.Paths {
+		refs = append(refs, p.Path)
+	}
+	return append(refs, res.pageLinks...)
+}
+
+// probeParams sends candidates to u in batches of paramsBatch, each with a
+// value of its own, and returns the names whose value comes back in the
+// body or that change the status (or, when the page's length is stable,
+// its length). A batch that changes the response is retried one name at a
+// time to find the name responsible. Every request is taken from
+// -params-budget.
+func probeParams(ctx context.Context, u *url.URL, baseStatus, baseLen int, candidates []string, priority bool) []string {
+	if len(candidates) == 0 || !takeParamsBudget(priority) {
+		return nil
+	}
+	// A second, unknown parameter shows whether the page answers the same
+	// way twice
+	canary := randomParamValue()
+	status, body, err := paramsFetch(ctx, withParams(u, map[string]string{randomParamValue(): canary}))
+	if err != nil {
+		return nil
+	}
+	if status != baseStatus {
+		// Any parameter changes the answer, so a change means nothing
+		return nil
+	}
+	// Pages that echo the whole URL reflect every name
+	echoesAll := bytes.Contains(body, []byte(canary))
+	stableLen := len(body) == baseLen && !echoesAll
+	differs := func(s int, b []byte) bool {
+		return s != baseStatus || (stableLen && len(b) != baseLen)
+	}
+
+	var found []string
+	for start := 0; start < len(candidates); start += paramsBatch {
+		batch := candidates[start:min(start+paramsBatch, len(candidates))]
+		values := make(map[string]string, len(batch))
+		for _, name := range batch {
+			values[name] = randomParamValue()
+		}
+		if !takeParamsBudget(priority) {
+			return found
+		}
+		s, b, err := paramsFetch(ctx, withParams(u, values))
+		if err != nil {
+			return found
+		}
+		var rest []string
+		for _, name := range batch {
+			if !echoesAll && bytes.Contains(b, []byte(values[name])) {
+				found = append(found, name)
+			} else {
+				rest = append(rest, name)
+			}
+		}
+		if !differs(s, b) || len(rest) == 0 {
+			continue
+		}
+		if len(batch) == 1 {
+			found = append(found, rest...)
+			continue
+		}
+		for _, name := range rest {
+			if !takeParamsBudget(priority) {
+				return found
+			}
+			s, b, err := paramsFetch(ctx, withParams(u, map[string]string{name: randomParamValue()}))
+			if err != nil {
+				return found
+			}
+			if differs(s, b) {
+				found = append(found, name)
+			}
+		}
+	}
+	return found
+}
+
+// takeParamsBudget takes one request from -params-budget and reports
+// whether there was one left. Hosts without a paramsPriorityFlags flag stop
+// at half of the budget.
+func takeParamsBudget(priority bool) bool {
+	if paramsBudget == 0 {
+		return true
+	}
+	paramsBudgetMu.Lock()
+	defer paramsBudgetMu.Unlock()
+	limit := paramsBudget
+	if !priority {
+		limit -= paramsBudget / 2
+	}
+	if paramsBudgetUsed >= limit {
+		if paramsBudgetUsed >= paramsBudget {
+			paramsBudgetTripped.Do(func() {
+				fmt.Fprintf(os.Stderr, "Reached -params-budget (%d), parameter probing stops\n", paramsBudget)
+			})
+		}
+		return false
+	}
+	paramsBudgetUsed++
+	return true
+}
+
+// hasPriorityFlag reports whether a paramsPriorityFlags rule matches res.
+// Flags are only set once every enricher ran, so the rules are evaluated
+// here.
+func hasPriorityFlag(res *Result) bool {
+	for i := range flagRules {
+		r := &flagRules[i]
+		if contains(paramsPriorityFlags, r.Flag) && r.match(res) {
+			return true
+		}
+	}
+	return false
+}
+
+// paramsFetch GETs rawURL and returns the status and at most paramsBodyMax
+// bytes of the body
+func paramsFetch(ctx context.Context, rawURL string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := paramsHTTP.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, paramsBodyMax))
+	return resp.StatusCode, body, err
+}
+
+// pageParams returns the names of the form fields in an HTML page and the
+// links, form actions and script sources it references
+func pageParams(body []byte) (fields, links []string) {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return fields, links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch {
+				case string(key) == "name" && isFormField(string(tag)):
+					fields = append(fields, string(val))
+				case string(key) == "href" || string(key) == "action" || string(key) == "src":
+					if bytes.ContainsRune(val, '?') {
+						links = append(links, string(val))
+					}
+				}
+			}
+		}
+	}
+}
+
+func isFormField(tag string) bool {
+	return tag == "input" || tag == "select" || tag == "textarea" || tag == "button"
+}
+
+// queryNames returns the parameter names of a raw query in order
+func queryNames(rawQuery string) []string {
+	var names []string
+	for _, pair := range strings.FieldsFunc(rawQuery, func(r rune) bool { return r == '&' || r == ';' }) {
+		name, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(name); err == nil && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// withParams returns u with values added to its query
+func withParams(u *url.URL, values map[string]string) string {
+	q := u.Query()
+	for k, v := range values {
+		q.Set(k, v)
+	}
+	t := *u
+	t.RawQuery = q.Encode()
+	return t.String()
+}
+
+// randomParamValue returns a value that cannot occur in a page by chance
+func randomParamValue() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return "rp" + hex.EncodeToString(b)
+}
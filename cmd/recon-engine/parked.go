@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	// parkedCounts counts the hosts classified as parked, by root domain
+	parkedCounts   = make(map[string]int)
+	parkedCountsMu sync.Mutex
+
+	// rootNameservers caches each root domain's nameservers, lower-case
+	rootNameservers sync.Map // root domain -> []string
+)
+
+// detectParked matches a live result against the parked rules and marks it
+// Parked by the first provider that matches. The page is fetched for the
+// body signatures only when its title is empty or names the domain, as
+// parking templates' titles do, so ordinary sites cost no extra request.
+func detectParked(ctx context.Context, res *Result) {
+	if len(parkedRules) == 0 || res.StatusCode == 0 {
+		return
+	}
+	if parkedRulesUse((*ruleMatcher).usesNameservers) {
+		res.nameservers = lookupRootNameservers(ctx, res.RootDomain)
+	}
+	provider := matchParked(res)
+	if provider == "" && res.URL != "" && parkedRulesUse((*ruleMatcher).usesBody) && parkedCandidate(*res) {
+		fetchBlockPage(ctx, res)
+		provider = matchParked(res)
+		res.body = nil
+	}
+	res.nameservers = nil
+	if provider == "" {
+		return
+	}
+	res.Parked, res.ParkedBy = true, provider
+	stats.Add("results.parked", 1)
+	parkedCountsMu.Lock()
+	parkedCounts[res.RootDomain]++
+	parkedCountsMu.Unlock()
+}
+
+func matchParked(res *Result) string {
+	for i := range parkedRules {
+		if parkedRules[i].match(res) {
+			return parkedRules[i].Parked
+		}
+	}
+	return ""
+}
+
+// parkedRulesUse reports whether a parked rule has a condition pred holds
+// for
+func parkedRulesUse(pred func(*ruleMatcher) bool) bool {
+	for i := range parkedRules {
+		if pred(&parkedRules[i].ruleMatcher) {
+			return true
+		}
+	}
+	return false
+}
+
+// parkedCandidate reports whether res's page is worth fetching for the
+// parked body signatures: a 2xx answer titled with nothing or the domain
+func parkedCandidate(res Result) bool {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return false
+	}
+	title := strings.ToLower(strings.TrimSpace(res.Title))
+	if title == "" {
+		return true
+	}
+	host := res.Subdomain
+	if u, err := url.Parse(res.URL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	for _, name := range []string{host, res.RootDomain} {
+		if name != "" && strings.Contains(title, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupRootNameservers returns root's nameservers, looked up once per
+// root domain. IP and range targets have none.
+func lookupRootNameservers(ctx context.Context, root string) []string {
+	if root == "" || strings.Contains(root, "/") {
+		return nil
+	}
+	if _, err := netip.ParseAddr(root); err == nil {
+		return nil
+	}
+	if v, ok := rootNameservers.Load(root); ok {
+		return v.([]string)
+	}
+	var names []string
+	nss, err := dnsResolver.LookupNS(ctx, registrableDomain(root))
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	for _, ns := range nss {
+		names = append(names, strings.ToLower(strings.TrimSuffix(ns.Host, ".")))
+	}
+	v, _ := rootNameservers.LoadOrStore(root, names)
+	return v.([]string)
+}
+
+// nameserverContains reports whether one of nameservers contains s
+func nameserverContains(nameservers []string, s string) bool {
+	for _, ns := range nameservers {
+		if strings.Contains(ns, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parkedQuiet reports whether res is a parked host that notifications
+// leave out, without -include-parked
+func parkedQuiet(res Result) bool {
+	return res.Parked && !includeParked
+}
+
+// parkedSnapshot returns how many hosts of each root domain were parked
+func parkedSnapshot() map[string]int {
+	parkedCountsMu.Lock()
+	defer parkedCountsMu.Unlock()
+	if len(parkedCounts) == 0 {
+		return nil
+	}
+	snap := make(map[string]int, len(parkedCounts))
+	for root, n := range parkedCounts {
+		snap[root] = n
+	}
+	return snap
+}
+
+// resetParked forgets the previous -monitor iteration's counts and
+// nameservers
+func resetParked() {
+	parkedCountsMu.Lock()
+	parkedCounts = make(map[string]int)
+	parkedCountsMu.Unlock()
+	rootNameservers = sync.Map{}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dnsdbAPIBase = "https://api.dnsdb.info/dnsdb/v2"
+	circlAPIBase = "https://www.circl.lu/pdns"
+
+	// dnsdbPageSize is how many records each DNSDB request asks for
+	dnsdbPageSize = 1000
+	// pdnsMaxLine bounds a line of a passive DNS answer
+	pdnsMaxLine = 1 << 20
+)
+
+// HistoricalIP is an address passive DNS saw a name resolve to, and when
+type HistoricalIP struct {
+	IP        string `json:"ip"`
+	FirstSeen string `json:"first_seen,omitempty"` // RFC 3339
+	LastSeen  string `json:"last_seen,omitempty"`
+	Source    string `json:"source"` // dnsdb or circl
+}
+
+// pdnsRecord is a passive DNS observation, in the common output format
+// both DNSDB and CIRCL use
+type pdnsRecord struct {
+	RRName    string          `json:"rrname"`
+	RRType    string          `json:"rrtype"`
+	RData     json.RawMessage `json:"rdata"` // a string, or a list with DNSDB
+	TimeFirst int64           `json:"time_first"`
+	TimeLast  int64           `json:"time_last"`
+	// Zone file observations carry their own times
+	ZoneTimeFirst int64 `json:"zone_time_first"`
+	ZoneTimeLast  int64 `json:"zone_time_last"`
+}
+
+// rdata returns the record's data, as a list either way
+func (r pdnsRecord) rdata() []string {
+	var list []string
+	if json.Unmarshal(r.RData, &list) == nil {
+		return list
+	}
+	var s string
+	if json.Unmarshal(r.RData, &s) == nil {
+		return []string{s}
+	}
+	return nil
+}
+
+// seen returns the first and last time the record was observed
+func (r pdnsRecord) seen() (time.Time, time.Time) {
+	first, last := r.TimeFirst, r.TimeLast
+	if first == 0 {
+		first, last = r.ZoneTimeFirst, r.ZoneTimeLast
+	}
+	var f, l time.Time
+	if first > 0 {
+		f = time.Unix(first, 0).UTC()
+	}
+	if last > 0 {
+		l = time.Unix(last, 0).UTC()
+	}
+	return f, l
+}
+
+// errPDNSQuota is returned once a passive DNS service reports the
+// account's quota is used up
+var errPDNSQuota = errors.New("passive DNS quota exhausted")
+
+var (
+	// pdnsDisabled holds the services whose quota ran out, so the rest of
+	// the run stops calling them
+	pdnsDisabled sync.Map // source -> true
+
+	// pdnsHistory holds the addresses each name resolved to, by source,
+	// for -historical-ips
+	pdnsHistory   = make(map[string]map[string]HistoricalIP) // name -> source+ip ->
+	pdnsHistoryMu sync.Mutex
+
+	// pdnsRequests counts each service's requests per root domain against
+	// -dnsdb-max-requests and -circl-max-requests
+	pdnsRequests   = make(map[string]int) // source+" "+domain -> count
+	pdnsRequestsMu sync.Mutex
+
+	// pdnsLooked holds the hosts each service described, in discovery or
+	// looked up by -historical-ips, so a host on several ports costs one
+	// lookup
+	pdnsLooked sync.Map // source+" "+host -> struct{}
+)
+
+// pdnsSources are the passive DNS services, in the order -historical-ips
+// asks them
+var pdnsSources = []string{"dnsdb", "circl"}
+
+// pdnsBudget takes one request of source's budget for domain, reporting
+// false once it is spent
+func pdnsBudget(source, domain string) bool {
+	limit := dnsdbMaxRequests
+	if source == "circl" {
+		limit = circlMaxRequests
+	}
+	pdnsRequestsMu.Lock()
+	defer pdnsRequestsMu.Unlock()
+	key := source + " " + domain
+	if pdnsRequests[key] >= limit {
+		if pdnsRequests[key] == limit {
+			fmt.Fprintf(os.Stderr, "%s request budget (%d) exhausted for %s\n", source, limit, domain)
+			pdnsRequests[key]++
+		}
+		stats.Add("pdns."+source+".budget_exhausted", 1)
+		return false
+	}
+	pdnsRequests[key]++
+	return true
+}
+
+// pdnsGet sends a passive DNS request of source and calls fn with each
+// record of its answer. A service whose quota ran out is disabled for the
+// rest of the run.
+func pdnsGet(ctx context.Context, source string, req *http.Request, fn func(pdnsRecord)) error {
+	if _, off := pdnsDisabled.Load(source); off {
+		return errPDNSQuota
+	}
+	if err := apiRequest(source); err != nil {
+		return err
+	}
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	stats.Add("pdns."+source+".requests", 1)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// DNSDB's answer when it has no records
+		return nil
+	case http.StatusTooManyRequests, http.StatusPaymentRequired:
+		if _, loaded := pdnsDisabled.LoadOrStore(source, true); !loaded {
+			fmt.Fprintf(os.Stderr, "%s quota exhausted (HTTP %d), disabling %s for the rest of the run\n", source, resp.StatusCode, source)
+		}
+		return errPDNSQuota
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%s rejected the credentials (HTTP %d), check %s", source, resp.StatusCode, strings.Join(apiKeyEnv[source], " and "))
+	default:
+		return fmt.Errorf("%s: unexpected status %s", source, resp.Status)
+	}
+
+	sc := bufio.NewScanner(io.LimitReader(resp.Body, 256<<20))
+	sc.Buffer(make([]byte, 64<<10), pdnsMaxLine)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		// DNSDB wraps records in {"obj": ...} between {"cond": ...} lines
+		var saf struct {
+			Cond string          `json:"cond"`
+			Msg  string          `json:"msg"`
+			Obj  json.RawMessage `json:"obj"`
+		}
+		if err := json.Unmarshal(line, &saf); err != nil {
+			rejectedLine(source, "discovery", eventParseFailed, err)
+			continue
+		}
+		if saf.Cond == "failed" {
+			return fmt.Errorf("%s: %s", source, saf.Msg)
+		}
+		if saf.Obj != nil {
+			line = saf.Obj
+		} else if saf.Cond != "" {
+			continue
+		}
+		var rec pdnsRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			rejectedLine(source, "discovery", eventParseFailed, err)
+			continue
+		}
+		fn(rec)
+	}
+	if err := sc.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return nil
+}
+
+// dnsdbRequest builds a DNSDB lookup of path
+func dnsdbRequest(ctx context.Context, path string, q url.Values) (*http.Request, error) {
+	key := secret("DNSDB_API_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("DNSDB_API_KEY is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dnsdbAPIBase+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-Key", key)
+	req.Header.Set("Accept", "application/x-ndjson")
+	return req, nil
+}
+
+// circlRequest builds a CIRCL passive DNS query of name
+func circlRequest(ctx context.Context, name string) (*http.Request, error) {
+	user, pass := secret("CIRCL_PDNS_USER"), secret("CIRCL_PDNS_PASSWORD")
+	if user == "" || pass == "" {
+		return nil, fmt.Errorf("CIRCL_PDNS_USER and CIRCL_PDNS_PASSWORD must be set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, circlAPIBase+"/query/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(user, pass)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// runDNSDB pages through DNSDB's records of the names under domain,
+// spending at most -dnsdb-max-requests calls. Every name ever seen is
+// reported, and the addresses they resolved to are kept for
+// -historical-ips.
+func runDNSDB(ctx context.Context, domain string, out chan<- string) error {
+	if !pdnsConfigured("dnsdb") {
+		return fmt.Errorf("DNSDB_API_KEY is not set")
+	}
+	run := newPDNSRun("dnsdb", domain, out)
+	defer run.flush(ctx)
+	for offset := 0; pdnsBudget("dnsdb", domain); offset += dnsdbPageSize {
+		q := url.Values{}
+		q.Set("limit", strconv.Itoa(dnsdbPageSize))
+		if offset > 0 {
+			q.Set("offset", strconv.Itoa(offset))
+		}
+		req, err := dnsdbRequest(ctx, "/lookup/rrset/name/*."+url.PathEscape(domain)+"/ANY", q)
+		if err != nil {
+			return err
+		}
+		n := 0
+		err = pdnsGet(ctx, "dnsdb", req, func(rec pdnsRecord) {
+			n++
+			// The wildcard lookup has every record of the name
+			historicalIPsLooked("dnsdb", strings.TrimSuffix(strings.ToLower(rec.RRName), "."))
+			run.handle(rec)
+		})
+		if errors.Is(err, errPDNSQuota) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n < dnsdbPageSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// runCIRCL queries CIRCL passive DNS for domain and then for each name
+// under it the answers mention, spending at most -circl-max-requests
+// queries. CIRCL has no wildcard queries, so the names are found by
+// following the records.
+func runCIRCL(ctx context.Context, domain string, out chan<- string) error {
+	if !pdnsConfigured("circl") {
+		return fmt.Errorf("CIRCL_PDNS_USER and CIRCL_PDNS_PASSWORD must be set")
+	}
+	run := newPDNSRun("circl", domain, out)
+	defer run.flush(ctx)
+	queue := []string{domain}
+	queued := map[string]bool{domain: true}
+	for len(queue) > 0 && pdnsBudget("circl", domain) {
+		name := queue[0]
+		queue = queue[1:]
+		historicalIPsLooked("circl", name)
+		req, err := circlRequest(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = pdnsGet(ctx, "circl", req, func(rec pdnsRecord) {
+			for _, found := range run.handle(rec) {
+				if !queued[found] {
+					queued[found] = true
+					queue = append(queue, found)
+				}
+			}
+		})
+		if errors.Is(err, errPDNSQuota) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pdnsRun is a passive DNS source's run for a root domain
+type pdnsRun struct {
+	source string
+	domain string
+	out    chan<- string
+	names  map[string]bool
+	addrs  []string // in the order they were first seen
+	seen   map[string]bool
+}
+
+func newPDNSRun(source, domain string, out chan<- string) *pdnsRun {
+	return &pdnsRun{source: source, domain: domain, out: out, names: make(map[string]bool), seen: make(map[string]bool)}
+}
+
+// handle sends the names under the domain a record mentions that were not
+// sent yet, returning them, and records the addresses of A and AAAA
+// records as the history of their name
+func (r *pdnsRun) handle(rec pdnsRecord) []string {
+	var found []string
+	add := func(name string) {
+		name, err := cleanName(name)
+		if err != nil || r.names[name] || !(name == r.domain || strings.HasSuffix(name, "."+r.domain)) {
+			return
+		}
+		r.names[name] = true
+		found = append(found, name)
+		r.out <- name
+	}
+	add(rec.RRName)
+	switch strings.ToUpper(rec.RRType) {
+	case "A", "AAAA":
+		for _, addr := range recordHistoricalIPs(r.source, rec) {
+			if !r.seen[addr] {
+				r.seen[addr] = true
+				r.addrs = append(r.addrs, addr)
+			}
+		}
+	case "CNAME", "NS", "MX", "PTR":
+		for _, target := range rec.rdata() {
+			// MX data leads with the preference
+			if fields := strings.Fields(target); len(fields) > 0 {
+				add(fields[len(fields)-1])
+			}
+		}
+	}
+	return found
+}
+
+// flush sends the addresses the run saw to be probed with
+// -scan-historical-ips. Without it they are never probed: they may belong
+// to someone else by now.
+func (r *pdnsRun) flush(ctx context.Context) {
+	if !scanHistoricalIPs {
+		return
+	}
+	for _, addr := range r.addrs {
+		if !scopeAllowsIP(addr) {
+			continue
+		}
+		select {
+		case r.out <- addr:
+			stats.Add("pdns.historical_ips_scanned", 1)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordHistoricalIPs records the addresses of an A or AAAA record as the
+// history of its name, returning them
+func recordHistoricalIPs(source string, rec pdnsRecord) []string {
+	var addrs []string
+	name := strings.TrimSuffix(strings.ToLower(rec.RRName), ".")
+	for _, ip := range rec.rdata() {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			recordHistoricalIP(name, source, addr.String(), rec)
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return addrs
+}
+
+// recordHistoricalIP adds addr to the history of name from source,
+// widening the period it was seen in
+func recordHistoricalIP(name, source, addr string, rec pdnsRecord) {
+	first, last := rec.seen()
+	pdnsHistoryMu.Lock()
+	defer pdnsHistoryMu.Unlock()
+	h, ok := pdnsHistory[name]
+	if !ok {
+		h = make(map[string]HistoricalIP)
+		pdnsHistory[name] = h
+	}
+	key := source + " " + addr
+	prev, ok := h[key]
+	entry := HistoricalIP{IP: addr, Source: source}
+	pf, pl := parseSeen(prev.FirstSeen), parseSeen(prev.LastSeen)
+	if ok && !pf.IsZero() && (first.IsZero() || pf.Before(first)) {
+		first = pf
+	}
+	if ok && pl.After(last) {
+		last = pl
+	}
+	if !first.IsZero() {
+		entry.FirstSeen = first.Format(time.RFC3339)
+	}
+	if !last.IsZero() {
+		entry.LastSeen = last.Format(time.RFC3339)
+	}
+	h[key] = entry
+}
+
+func parseSeen(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// historicalIPs returns the recorded history of name, most recently seen
+// first
+func historicalIPs(name string) []HistoricalIP {
+	pdnsHistoryMu.Lock()
+	defer pdnsHistoryMu.Unlock()
+	h := pdnsHistory[strings.ToLower(name)]
+	if len(h) == 0 {
+		return nil
+	}
+	out := make([]HistoricalIP, 0, len(h))
+	for _, e := range h {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].LastSeen != out[j].LastSeen {
+			return out[i].LastSeen > out[j].LastSeen
+		}
+		if out[i].IP != out[j].IP {
+			return out[i].IP < out[j].IP
+		}
+		return out[i].Source < out[j].Source
+	})
+	return out
+}
+
+// enrichHistoricalIPs sets res.HistoricalIPs to the addresses passive DNS
+// saw its host resolve to. Hosts the dnsdb and circl sources did not
+// describe are looked up with the services that have credentials, within
+// their request budgets.
+func enrichHistoricalIPs(ctx context.Context, res *Result) {
+	host := strings.ToLower(res.Subdomain)
+	for _, source := range pdnsSources {
+		if historicalIPsLooked(source, host) || !pdnsConfigured(source) {
+			continue
+		}
+		if _, off := pdnsDisabled.Load(source); off || !breakerAllow(source) || !pdnsBudget(source, res.RootDomain) {
+			continue
+		}
+		var req *http.Request
+		var err error
+		if source == "dnsdb" {
+			req, err = dnsdbRequest(ctx, "/lookup/rrset/name/"+url.PathEscape(host)+"/ANY", url.Values{"limit": {strconv.Itoa(dnsdbPageSize)}})
+		} else {
+			req, err = circlRequest(ctx, host)
+		}
+		if err == nil {
+			err = pdnsGet(ctx, source, req, func(rec pdnsRecord) {
+				if t := strings.ToUpper(rec.RRType); t == "A" || t == "AAAA" {
+					recordHistoricalIPs(source, rec)
+				}
+			})
+		}
+		if ctx.Err() == nil && !errors.Is(err, errPDNSQuota) {
+			breakerResult(source, err)
+		}
+		if err != nil && !errors.Is(err, errPDNSQuota) && !errors.Is(err, errBudgetExhausted) && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "%s lookup error for %s: %v\n", source, host, err)
+		}
+	}
+	res.HistoricalIPs = historicalIPs(host)
+	if len(res.HistoricalIPs) > 0 {
+		stats.Add("pdns.hosts_with_history", 1)
+	}
+}
+
+// historicalIPsLooked reports whether source already described host,
+// marking it described
+func historicalIPsLooked(source, host string) bool {
+	_, loaded := pdnsLooked.LoadOrStore(source+" "+host, struct{}{})
+	return loaded
+}
+
+// pdnsConfigured reports whether source has its credentials
+func pdnsConfigured(source string) bool {
+	for _, env := range apiKeyEnv[source] {
+		if secret(env) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// configurePassiveDNS checks the passive DNS flags against the selected
+// sources. Called once after flag parsing.
+func configurePassiveDNS(sources []string) error {
+	if dnsdbMaxRequests < 1 || circlMaxRequests < 1 {
+		return fmt.Errorf("-dnsdb-max-requests and -circl-max-requests must be at least 1")
+	}
+	if scanHistoricalIPs && !slices.Contains(sources, "dnsdb") && !slices.Contains(sources, "circl") {
+		return fmt.Errorf("-scan-historical-ips needs the dnsdb or circl source")
+	}
+	if historicalIPsFlag && !pdnsConfigured("dnsdb") && !pdnsConfigured("circl") {
+		return fmt.Errorf("-historical-ips needs DNSDB_API_KEY, or CIRCL_PDNS_USER and CIRCL_PDNS_PASSWORD")
+	}
+	return nil
+}
+
+// resetPassiveDNS clears the history and request counts of a monitor
+// iteration; a used-up quota stays used up
+func resetPassiveDNS() {
+	pdnsHistoryMu.Lock()
+	pdnsHistory = make(map[string]map[string]HistoricalIP)
+	pdnsHistoryMu.Unlock()
+	pdnsRequestsMu.Lock()
+	pdnsRequests = make(map[string]int)
+	pdnsRequestsMu.Unlock()
+	pdnsLooked = sync.Map{}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed wordlists/permutations.txt
+var defaultPermutationWords string
+
+// envWords are the environment tokens swapped for one another in a label
+var envWords = []string{"dev", "develop", "development", "staging", "stage", "stg", "prod", "production", "preprod", "test", "qa", "uat", "sandbox"}
+
+var trailingDigits = regexp.MustCompile(`\d+$`)
+
+// permutedNames records names that were found by the permutation stage
+var permutedNames sync.Map
+
+func loadPermutationWords() ([]string, error) {
+	data := defaultPermutationWords
+	if permutePatterns != "" {
+		b, err := os.ReadFile(permutePatterns)
+		if err != nil {
+			return nil, err
+		}
+		data = string(b)
+	}
+	var words []string
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		w := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if w != "" && !strings.HasPrefix(w, "#") {
+			words = append(words, w)
+		}
+	}
+	return words, nil
+}
+
+// permutations returns altdns-style variations of the left-most label of
+// name: prefixed/suffixed words, incremented numbers and swapped environment
+// tokens. Names equal to the input are never returned.
+func permutations(name, domain string, words []string) []string {
+	if !strings.HasSuffix(name, "."+domain) {
+		return nil
+	}
+	label, rest, _ := strings.Cut(name, ".")
+	seen := map[string]bool{label: true}
+	var out []string
+	add := func(l string) {
+		if l != "" && !seen[l] {
+			seen[l] = true
+			out = append(out, l+"."+rest)
+		}
+	}
+
+	for _, w := range words {
+		add(w + "-" + label)
+		add(label + "-" + w)
+		add(label + w)
+		// A new label in front of the whole name
+		seen[w+"."+label] = true
+		out = append(out, w+"."+name)
+	}
+
+	// Number increments: api-prod2 -> api-prod1, api-prod3; api -> api2
+	if digits := trailingDigits.FindString(label); digits != "" {
+		n, _ := strconv.Atoi(digits)
+		base := strings.TrimSuffix(label, digits)
+		if n > 0 {
+			add(base + strconv.Itoa(n-1))
+		}
+		add(base + strconv.Itoa(n+1))
+	} else {
+		add(label + "2")
+		add(label + "1")
+	}
+
+	// Environment swaps: api-prod -> api-staging, api-dev, ...
+	for _, tok := range strings.FieldsFunc(label, func(r rune) bool { return r == '-' || r == '_' }) {
+		for _, env := range envWords {
+			if tok != env && containsString(envWords, tok) {
+				add(strings.Replace(label, tok, env, 1))
+			}
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runPermutations resolves permutations of seeds and sends the names that
+// resolve (excluding wildcard answers) to out. With -permute-recursive the
+// hits are permuted again until nothing new resolves or -max-permutations
+// candidates have been tried.
+func runPermutations(ctx context.Context, domain string, seeds []string, out chan<- string) {
+	words, err := loadPermutationWords()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Permutation patterns error: %v\n", err)
+		return
+	}
+	wildcard := wildcardIPs(ctx, dnsResolver, domain)
+
+	tried := make(map[string]bool, len(seeds))
+	for _, s := range seeds {
+		tried[s] = true
+	}
+	budget := maxPermutations
+
+	for len(seeds) > 0 && budget > 0 && ctx.Err() == nil {
+		var candidates []string
+		for _, seed := range seeds {
+			for _, c := range permutations(seed, domain, words) {
+				if budget == 0 {
+					break
+				}
+				if !tried[c] {
+					tried[c] = true
+					candidates = append(candidates, c)
+					budget--
+				}
+			}
+		}
+		stats.Add("permute.generated", int64(len(candidates)))
+
+		hits := resolveCandidates(ctx, candidates, wildcard)
+		stats.Add("permute.resolved", int64(len(hits)))
+		for _, h := range hits {
+			permutedNames.Store(h, true)
+			out <- h
+		}
+
+		if !permuteRecursive {
+			break
+		}
+		seeds = hits
+	}
+	if budget == 0 {
+		fmt.Fprintf(os.Stderr, "Permutation stage reached -max-permutations (%d)\n", maxPermutations)
+	}
+}
+
+// resolveCandidates looks names up concurrently and returns those resolving
+// to at least one non-wildcard address
+func resolveCandidates(ctx context.Context, names []string, wildcard map[string]bool) []string {
+	var (
+		mu   sync.Mutex
+		hits []string
+		wg   sync.WaitGroup
+	)
+	work := make(chan string)
+	for i := 0; i < max(bruteConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				addrs, err := dnsResolver.LookupHost(ctx, name)
+				if err != nil || len(addrs) == 0 || isWildcardHit(addrs, wildcard) {
+					continue
+				}
+				mu.Lock()
+				hits = append(hits, name)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, n := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- n
+	}
+	close(work)
+	wg.Wait()
+	return hits
+}
@@ -0,0 +1,764 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resetRunState clears the per-run bookkeeping shared between stages so a
+// monitor iteration starts from scratch. Caches keyed by IP are kept.
+func resetRunState() {
+	infraMutex.Lock()
+	infraMap = make(map[string]Infrastructure)
+	infraMutex.Unlock()
+	ipHosts = &ipHostIndex{hosts: make(map[string]map[string]bool)}
+	jarmHosts = &jarmIndex{hosts: make(map[string]map[string]bool)}
+	resultClusters = &clusterIndex{clusters: make(map[string]*ResultCluster)}
+	dorkAssets = &dorkIndex{hosts: make(map[string]bool), tech: make(map[string]bool)}
+	assetGraphs = newAssetGraph()
+	vhostHosts = newVhostIndex()
+	permutedNames = sync.Map{}
+	ptrNames = sync.Map{}
+	soft404Baselines = sync.Map{}
+	wwGroups = sync.Map{}
+	amassRawRefs = sync.Map{}
+	ptrOutOfScopeMu.Lock()
+	ptrOutOfScope = make(map[string]bool)
+	ptrOutOfScopeMu.Unlock()
+	subfinderSourcesMu.Lock()
+	subfinderSources = make(map[string]map[string]bool)
+	subfinderSourcesMu.Unlock()
+	nameSourcesMu.Lock()
+	nameSources = make(map[string]map[string]bool)
+	nameSourcesMu.Unlock()
+	dnsRecordsMu.Lock()
+	dnsRecords = make(map[string]dnsRecord)
+	dnsRecordsMu.Unlock()
+	axfrOpenMu.Lock()
+	axfrOpen = nil
+	axfrOpenMu.Unlock()
+	discoveredAtMu.Lock()
+	discoveredAt = make(map[string]time.Duration)
+	discoveredAtMu.Unlock()
+	timingSamplesMu.Lock()
+	timingSamples = make(map[string][]float64)
+	timingSamplesMu.Unlock()
+	capsTrippedMu.Lock()
+	capsTripped = nil
+	capsTrippedMu.Unlock()
+	resetCoverage()
+	resetScopeDrops()
+	resetBlocks()
+	resetPassiveDNS()
+	resetIPExclusions()
+	resetParked()
+	infraUpdatesMu.Lock()
+	infraUpdates = nil
+	infraUpdatesMu.Unlock()
+}
+
+// nmapArgs builds the background nmap command line
+func nmapArgs(hosts ...string) []string {
+	args := []string{"-F", "--top-ports", "100"}
+	if ipVersion == ipVersion6 || hasIPv6(hosts) {
+		args = append(args, "-6")
+	}
+	args = append(append(args, egressArgs("nmap")...), hosts...)
+	return append(args, "-oN", filepath.Clean(nmapOutput))
+}
+
+// runPipeline runs discovery, probing and enrichment for target once,
+// calling emit for every enriched Result with its interest score. emit is
+// only ever called from a single goroutine.
+//
+// The budget caps wind the run down through the same cancellation as a
+// signal: -max-live-hosts cancels runCtx, which stops every stage and kills
+// the child processes, and -max-subdomains cancels only discovery.
+// -time-budget does both, as each stage's slice runs out.
+func runPipeline(ctx context.Context, target string, sources []string, emit func(Result)) error {
+	ctx, span := otelStart(ctx, "pipeline", otelStr("recon.target", target))
+	var results, live atomic.Int64
+	defer func() {
+		otelEndBatches(span)
+		span.SetAttr(otelInt("recon.results", int(results.Load())), otelInt("recon.live", int(live.Load())))
+		span.End()
+	}()
+	scored := emit
+	emit = func(res Result) {
+		if (!res.Blocked || blockInclude) && (!res.Parked || includeParked) {
+			res.InterestScore = interestScore(res)
+		}
+		applyOwner(&res)
+		markKnown(&res)
+		applyTags(&res)
+		recordProbe(res)
+		results.Add(1)
+		if res.StatusCode > 0 {
+			live.Add(1)
+		}
+		scored(res)
+	}
+
+	runCtx, stopRun := context.WithCancel(ctx)
+	defer stopRun()
+	discoveryCtx, stopDiscovery := context.WithCancel(runCtx)
+	defer stopDiscovery()
+
+	// Names already sent to httpx, by this pipeline or, with several
+	// domains, any of them
+	seen := sharedNames
+	if seen == nil {
+		set, err := newNameSet()
+		if err != nil {
+			err = fmt.Errorf("failed to create dedupe store: %w", err)
+			span.Error(err)
+			return err
+		}
+		seen = set
+	}
+
+	// Channel to collect subdomains from all sources. The feed below drains
+	// it into queue, which never blocks, so slow probing does not hold up
+	// discovery.
+	subdomains := make(chan string, 1000)
+	var wgDiscovery sync.WaitGroup
+
+	// --- 1. Discovery sources ---
+	// IP, CIDR and URL targets stand in for discovery with their own
+	// addresses or the URL itself. Each target of the run is classified on
+	// its own.
+	ipRange, targetU := targetRange(target), targetURL(target)
+	coverageTarget(target)
+	var rangeIPs []string
+	rangePorts := make(map[string][]OpenPort)
+	switch {
+	case ipRange.IsValid():
+		rangeIPs = rangeAddrs(ipRange)
+		wgDiscovery.Add(1)
+		go func() {
+			defer wgDiscovery.Done()
+			runIPRange(discoveryCtx, rangeIPs, rangePorts, subdomains)
+		}()
+		sources = nil
+	case targetU != nil:
+		recordDiscovery(targetU.String(), "url")
+		subdomains <- targetU.String()
+		sources = nil
+	}
+	for _, name := range sources {
+		startSource(discoveryCtx, &wgDiscovery, name, target, subdomains)
+	}
+
+	// --- 3. Deduplication & Pipeline to Httpx ---
+	// We need a way to close the input to httpx once discovery is done.
+	// We'll use a pipe for httpx stdin.
+
+	probeCtx, probeSpan := otelStart(runCtx, "probe", otelStr("recon.prober", probeEngine))
+	httpxCmd, httpxIn, httpxOut, err := startProber(probeCtx)
+	if err != nil {
+		probeSpan.Error(err)
+		probeSpan.End()
+		span.Error(err)
+		return err
+	}
+
+	// Nmap (Background); -portscan hosts scans after probing instead.
+	// IP targets pass the addresses -scope allows, URL targets their host.
+	nmapHosts := []string{targetHost(target)}
+	if ipRange.IsValid() {
+		nmapHosts = rangeIPs
+	}
+	switch {
+	case portscanMode != "root" || len(nmapHosts) == 0:
+	case !ipRange.IsValid() && !scopeAllowsHost(nmapHosts[0]):
+		fmt.Fprintf(os.Stderr, "Not port scanning %s: outside -scope\n", target)
+	default:
+		// Not tied to the run: it may finish after the last result, until
+		// the engine exits
+		nmapCmd := toolCommand(context.Background(), toolPath("nmap"), nmapArgs(nmapHosts...)...)
+		if err := startTool(nmapCmd); err == nil {
+			coverageAdd("portscan", len(nmapHosts))
+			go func() {
+				if err := waitTool(nmapCmd); err != nil {
+					reportToolError("nmap", "portscan", "", err)
+				}
+			}()
+		} else {
+			reportToolError("nmap", "portscan", eventStartFailed, err)
+		}
+	}
+
+	// Mail posture lookups run alongside discovery; the record is emitted
+	// once the probed hosts are out
+	var mail chan Result
+	if mailCheck {
+		mail = make(chan Result, 1)
+		go func() {
+			defer close(mail)
+			if res, ok := checkMail(ctx, target); ok {
+				mail <- res
+			}
+		}()
+	}
+
+	// So does the WHOIS lookup, which goes to the run summary
+	var whois chan *WhoisInfo
+	if whoisLookup {
+		whois = make(chan *WhoisInfo, 1)
+		go func() {
+			defer close(whois)
+			if info, ok := lookupWhois(ctx, registrableDomain(targetHost(target))); ok {
+				coverageAdd("whois", 1)
+				whois <- info
+			}
+		}()
+	}
+
+	// And the DNS audit
+	type dnsAuditOutcome struct {
+		audit    *DNSAudit
+		findings []Finding
+	}
+	var dnsAuditDone chan dnsAuditOutcome
+	if dnsAudit {
+		dnsAuditDone = make(chan dnsAuditOutcome, 1)
+		go func() {
+			audit, findings := auditDNS(ctx, registrableDomain(targetHost(target)))
+			coverageAdd("dns_audit", 1)
+			dnsAuditDone <- dnsAuditOutcome{audit, findings}
+		}()
+	}
+
+	// Names waiting for httpx. A single goroutine writes them to its stdin;
+	// when httpx exits early the rest goes to its replacement, or is drained
+	// and dropped.
+	queue := newProbeQueue()
+	feed := newProbeFeed(httpxIn)
+	feedWritten := make(chan struct{})
+	go func() {
+		defer close(feedWritten)
+		feed.run(queue)
+	}()
+
+	// Discovery coordination routine
+	go func() {
+		wgDiscovery.Wait()
+		close(subdomains)
+	}()
+
+	// Feed unique subdomains to httpx. fedNames, cachedResults and skipped
+	// are only read once feedDone is closed.
+	var fedNames []string
+	// Names -exclude-ips kept from probing
+	var excluded []excludedName
+	// Names claimed in the -state-backend for this run to probe, by name,
+	// and the results of those a run sharing it probed recently
+	var claims sync.Map
+	var cachedResults []Result
+	skipped := 0
+	feedDone := make(chan struct{})
+	go func() {
+		defer close(feedDone)
+		if seen != sharedNames {
+			defer seen.Close()
+		}
+		var seeds, firstWave []string
+		// admitMu guards fed, the names passed on to probing, and what
+		// admitted adds to, as it runs on the admitter's workers
+		var admitMu sync.Mutex
+		fed := 0
+		capReached := func() bool {
+			admitMu.Lock()
+			defer admitMu.Unlock()
+			return maxSubdomains > 0 && fed >= maxSubdomains
+		}
+		// admitted queues a name the scope and exclusions allow for probing
+		admitted := func(sub string) {
+			admitMu.Lock()
+			if maxSubdomains > 0 && fed >= maxSubdomains {
+				admitMu.Unlock()
+				if tripCap(capMaxSubdomains, maxSubdomains, "stopping discovery") {
+					stopDiscovery()
+				}
+				return
+			}
+			fed++
+			if permute || ptrSweep {
+				seeds = append(seeds, sub)
+			}
+			admitMu.Unlock()
+			if probeStates != nil {
+				cached, claim, recent := claimProbe(target, sub)
+				if recent {
+					admitMu.Lock()
+					skipped++
+					cachedResults = append(cachedResults, cached...)
+					admitMu.Unlock()
+					return
+				}
+				if claim != nil {
+					claims.Store(sub, claim)
+				}
+			}
+			if emitUnprobed {
+				admitMu.Lock()
+				fedNames = append(fedNames, sub)
+				admitMu.Unlock()
+			}
+			queue.Push(sub)
+		}
+		admit := newAdmitter(runCtx, admitted, func(e excludedName) {
+			admitMu.Lock()
+			excluded = append(excluded, e)
+			admitMu.Unlock()
+		})
+		feed := func(sub string) bool {
+			// Names found after discovery's slice of -time-budget are
+			// counted, not probed
+			if budgetExpired("discovery") {
+				budgetTruncated("discovery", 1)
+				return false
+			}
+			// -max-subdomains bounds every stage, recursion included
+			// Sources are cancelled, but what they already sent is drained
+			if capReached() {
+				if tripCap(capMaxSubdomains, maxSubdomains, "stopping discovery") {
+					stopDiscovery()
+				}
+				return false
+			}
+			fresh, err := seen.Add(sub)
+			if err != nil {
+				// Probing a name twice beats losing it
+				fmt.Fprintf(os.Stderr, "Dedupe store error for %s: %v\n", sub, err)
+				fresh = true
+			}
+			if !fresh {
+				return false
+			}
+			// Passive sources may see anything; only in-scope names are
+			// probed. IP and URL targets were judged before they were fed.
+			if ipRange.IsValid() || targetU != nil {
+				admitted(sub)
+				return true
+			}
+			if !scopeAllowsName(sub) {
+				return false
+			}
+			admit.Submit(sub)
+			return true
+		}
+		for sub := range subdomains {
+			if feed(sub) && recursive {
+				firstWave = append(firstWave, sub)
+			}
+		}
+		if recursive {
+			runRecursion(discoveryCtx, target, sources, firstWave, feed)
+		}
+		// Permutations are generated from everything discovery confirmed
+		if permute {
+			admit.Wait()
+			hits := make(chan string)
+			confirmed := append([]string(nil), seeds...)
+			go func() {
+				runPermutations(discoveryCtx, target, confirmed, hits)
+				close(hits)
+			}()
+			for sub := range hits {
+				recordDiscovery(sub, "permutation")
+				feed(sub)
+			}
+		}
+		// The PTR sweep covers discovery and permutation names; what it
+		// feeds is not swept again
+		if ptrSweep {
+			admit.Wait()
+			hits := make(chan string)
+			names := append([]string(nil), seeds...)
+			go func() {
+				runPTRSweep(discoveryCtx, target, names, hits)
+				close(hits)
+			}()
+			for sub := range hits {
+				recordDiscovery(sub, "ptr")
+				feed(sub)
+			}
+		}
+		admit.Wait()
+		queue.Close()
+	}()
+
+	// --- 4. Process Httpx Output & Enrich ---
+	scanner := newLineReader(httpxOut, "httpx")
+
+	// Enrichment runs on a pool of workers; a single goroutine calls emit
+	// so output lines never interleave
+	jobs := make(chan Result)
+	enriched := make(chan Result)
+	// Set once -max-live-hosts is reached; later results are dropped
+	var liveCapHit atomic.Bool
+	var wgWorkers sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wgWorkers.Add(1)
+		go func() {
+			defer wgWorkers.Done()
+			for res := range jobs {
+				if liveCapHit.Load() {
+					continue
+				}
+				// Past enrichment's slice of -time-budget hosts go out as
+				// probed
+				if budgetExpired("enrich") {
+					budgetTruncated("enrich", 1)
+					enriched <- res
+					continue
+				}
+				start := time.Now()
+				enrichResult(runCtx, &res, target)
+				if timings {
+					setTiming(&res, timingEnrich, time.Since(start))
+					observeTimings(res)
+				}
+				enriched <- res
+			}
+		}()
+	}
+	go func() {
+		wgWorkers.Wait()
+		close(enriched)
+	}()
+	// With -ordered the Results go out in the order httpx answered
+	var order *resultOrder
+	var ready <-chan Result = enriched
+	if ordered {
+		order = newResultOrder()
+		ready = order.release(enriched, orderedWindow)
+	}
+	// IP -> live hosts on it, filled by the emit goroutine for -portscan hosts
+	portTargets := make(map[string][]string)
+	pending := newInfraPending(target, sources)
+	encodeDone := make(chan struct{})
+	go func() {
+		defer close(encodeDone)
+		live := make(map[string]bool)
+		for res := range ready {
+			if liveCapHit.Load() {
+				continue
+			}
+			// IP targets were port scanned before probing
+			if portscanMode == "hosts" && !ipRange.IsValid() {
+				addPortTarget(portTargets, res)
+			}
+			// After enrichment, so WhatWeb's technologies count
+			if clusterResults && res.StatusCode > 0 && (!res.Blocked || blockInclude) {
+				res.ClusterID = resultClusters.Add(res)
+			}
+			if dorksPath != "" {
+				dorkAssets.Observe(res)
+			}
+			if graphPath != "" {
+				assetGraphs.Observe(res)
+			}
+			if vhostProbe {
+				vhostHosts.Observe(res)
+			}
+			emit(res)
+			pending.Observe(res)
+			if ipRange.IsValid() {
+				live[res.IP] = true
+			} else {
+				live[res.Subdomain] = true
+			}
+			if maxLiveHosts > 0 && len(live) >= maxLiveHosts {
+				liveCapHit.Store(true)
+				tripCap(capMaxLiveHosts, maxLiveHosts, "winding down")
+				stopRun()
+			}
+		}
+	}()
+
+	// Each -time-budget slice ends its stage through the same cancellation
+	// as the caps. Enrichment's also stops httpx, whose remaining results
+	// are emitted unenriched.
+	defer budgetTimer("discovery", feedDone, "stopping discovery", stopDiscovery)()
+	defer budgetTimer("probe", feedWritten, "probing no more names", feed.cutOff)()
+	defer budgetTimer("enrich", encodeDone, "winding down", stopRun)()
+
+	probed := make(map[string]bool)
+	// Names httpx answered for on any port
+	answered := make(map[string]bool)
+	bodyFirstSeen := make(map[string]string)
+	// Lines httpx answered with, and how often it was restarted
+	httpxLines, httpxRestarted := 0, 0
+	for {
+		if !scanner.Next() {
+			if scanner.Err() != nil {
+				break
+			}
+			var out io.Reader
+			httpxCmd, out = httpxEnded(runCtx, httpxCmd, feed, httpxLines, func(name string) bool { return answered[name] }, &httpxRestarted)
+			if httpxCmd == nil {
+				break
+			}
+			scanner = newLineReader(out, "httpx")
+			continue
+		}
+		httpxLines++
+		line := scanner.Bytes()
+		ref := keepRaw("httpx", target, "", line)
+		hRes, ok := parseHttpxLine(line)
+		if !ok {
+			continue
+		}
+		if !probeFamilyAllowed(hRes) {
+			stats.Add("probe.ip_version_skipped", 1)
+			continue
+		}
+
+		// Probing dedup is per subdomain+port; the same name can be live on
+		// several ports and each yields its own Result
+		probeKey := hRes.Input + ":" + strconv.Itoa(urlPort(hRes.Url))
+		if probed[probeKey] {
+			continue
+		}
+		probed[probeKey] = true
+		answered[hRes.Input] = true
+		claim, _ := claims.Load(hRes.Input)
+
+		res := httpxResult(hRes, target)
+		res.claim = claimOf(claim)
+		res.RawRef = joinRawRefs(ref, amassRawRef(hRes.Input))
+
+		// Shared hosting is judged on the subdomains seen so far; the run
+		// summary carries the complete per-IP picture once probing ends
+		if res.IP != "" && res.Subdomain != "" {
+			res.SharedHosting = ipHosts.Add(res.IP, res.Subdomain) >= sharedHostingThreshold
+		}
+
+		// Identical bodies point back at the first host that served them
+		if dedupeIdentical && res.BodySHA256 != "" {
+			if first, ok := bodyFirstSeen[res.BodySHA256]; ok {
+				res.DuplicateOf = first
+			} else {
+				bodyFirstSeen[res.BodySHA256] = res.URL
+			}
+		}
+
+		setProbeTimings(&res)
+		if order != nil {
+			order.assign(&res)
+		}
+		jobs <- res
+	}
+	close(jobs)
+	<-encodeDone
+	stats.Add("names.live", int64(len(answered)))
+	<-feedDone
+	pending.Reconcile()
+
+	// Names a run sharing the -state-backend probed recently go out as it
+	// found them. Claims of names left unprobed are given back.
+	if probeStates != nil {
+		<-feedDone
+		for _, res := range cachedResults {
+			if runCtx.Err() != nil {
+				break
+			}
+			emit(res)
+		}
+		_, dropped := feed.counts()
+		if runCtx.Err() != nil || dropped > 0 || feed.skippedNames() > 0 {
+			releaseProbes(&claims, answered)
+		}
+		summary.mu.Lock()
+		summary.ProbesSkipped += skipped
+		summary.mu.Unlock()
+	}
+
+	// Names in -exclude-ips ranges are reported, never probed
+	if len(ipExclusions) > 0 {
+		<-feedDone
+		for _, e := range excluded {
+			if runCtx.Err() != nil {
+				break
+			}
+			emit(excludedResult(target, e))
+		}
+		stats.Add("names.excluded", int64(len(excluded)))
+	}
+
+	// Discovered names that never answered, once the feed is complete
+	if emitUnprobed {
+		<-feedDone
+		unprobed := 0
+		for _, name := range fedNames {
+			if answered[name] || runCtx.Err() != nil {
+				continue
+			}
+			unprobed++
+			emit(unprobedResult(target, name))
+		}
+		stats.Add("names.discovered_only", int64(unprobed))
+	}
+
+	if res, ok := axfrResult(target); ok && ctx.Err() == nil {
+		emit(res)
+	}
+	if mail != nil {
+		if res, ok := <-mail; ok && ctx.Err() == nil {
+			emit(res)
+		}
+	}
+	if whois != nil {
+		if info, ok := <-whois; ok {
+			summary.mu.Lock()
+			summary.Whois = info
+			summary.mu.Unlock()
+			if whoisRecord && ctx.Err() == nil {
+				emit(whoisResult(target, info))
+			}
+		}
+	}
+	if dnsAuditDone != nil {
+		o := <-dnsAuditDone
+		summary.mu.Lock()
+		summary.DNSAudit = o.audit
+		summary.mu.Unlock()
+		if dnsAuditRecord && ctx.Err() == nil {
+			emit(dnsAuditResult(target, o.audit, o.findings))
+		}
+	}
+
+	switch {
+	case runCtx.Err() != nil || portscanMode != "hosts":
+	case ipRange.IsValid():
+		<-feedDone
+		emitRangePorts(runCtx, target, rangePorts, emit)
+	default:
+		runHostPortscan(runCtx, target, portTargets, emit)
+	}
+	if asnExpand != "" && runCtx.Err() == nil {
+		runASNSweep(runCtx, target, emit)
+	}
+	if vhostProbe && runCtx.Err() == nil {
+		runVhostProbe(runCtx, target, emit)
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading httpx output: %v\n", err)
+		feed.giveUp(nil)
+	}
+	if httpxCmd != nil {
+		if err := httpxCmd.Wait(); err != nil && runCtx.Err() == nil {
+			reportToolError("httpx", "probe", "", err)
+			probeSpan.Error(err)
+		}
+	}
+	probeSpan.SetAttr(otelInt("recon.probe.lines", httpxLines), otelInt("recon.probe.restarts", httpxRestarted), otelInt("recon.probe.batches", feed.batchCount()+1))
+	probeSpan.End()
+	<-feedWritten
+	if n := feed.skippedNames(); n > 0 {
+		budgetTruncated("probe", n)
+	}
+	if n := feed.batchCount(); n > 0 {
+		summary.mu.Lock()
+		summary.ProbeBatches += n + 1
+		summary.mu.Unlock()
+	}
+	if _, dropped := feed.counts(); summary.ProbeRestart != nil {
+		summary.mu.Lock()
+		summary.ProbeRestart.Unprobed = dropped
+		summary.mu.Unlock()
+	}
+	return nil
+
+}
+
+// parseHttpxLine decodes and sanitizes a line of httpx output, counting it
+// as rejected or repaired
+func parseHttpxLine(line []byte) (HttpxResult, bool) {
+	var hRes HttpxResult
+	if err := json.Unmarshal(line, &hRes); err != nil {
+		rejectedLine("httpx", "probe", eventParseFailed, err)
+		return hRes, false
+	}
+	if repaired, err := sanitizeHttpx(&hRes); err != nil {
+		rejectedLine("httpx", "probe", eventInvalid, err)
+		return hRes, false
+	} else if repaired {
+		repairedLine("httpx")
+	}
+	return hRes, true
+}
+
+// httpxResult builds the Result of an httpx answer for target, with what
+// discovery recorded about the name: its sources, zone transfer records and
+// amass infrastructure
+func httpxResult(hRes HttpxResult, target string) Result {
+	res := Result{
+		RunID:           runID,
+		RootDomain:      target,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Subdomain:       hRes.Input,
+		URL:             hRes.Url,
+		Port:            urlPort(hRes.Url),
+		StatusCode:      hRes.StatusCode,
+		Title:           hRes.Title,
+		TechStack:       extractTech(hRes),
+		Vulnerabilities: []Finding{},
+		Source:          legacySource(hRes.Input),
+		Sources:         discoverySources(hRes.Input),
+		ContentLength:   hRes.ContentLength,
+		ResponseTimeMs:  responseTimeMs(hRes.Time),
+		BodySHA256:      hRes.Hash.BodySHA256,
+		ProbeEngine:     probeEngine,
+		headers:         httpxHeaders(hRes.Header),
+	}
+
+	// Names from a zone transfer come with their records
+	res.IP = probeIP(hRes)
+	res.IPs = hostIPs([]string{res.IP}, hRes.A, hRes.AAAA)
+	if rec, ok := lookupDNSRecord(hRes.Input); ok {
+		res.IPs = hostIPs(res.IPs, rec.A, rec.AAAA)
+		res.CNAME = rec.CNAME
+	}
+	if res.IP == "" && len(res.IPs) > 0 {
+		res.IP = res.IPs[0]
+	}
+
+	// Addresses of IP targets carry no subdomain; the enrichers name
+	// them after their PTR record. URL targets are named by their host.
+	if targetRange(target).IsValid() {
+		res.IP, res.Subdomain = rangeHost(hRes.Input), ""
+		res.IPs = []string{res.IP}
+	} else if u := targetURL(target); u != nil {
+		res.Subdomain = u.Hostname()
+	}
+
+	res.SubfinderSources = subfinderSourcesFor(hRes.Input)
+
+	if hRes.CDN {
+		res.CDN = hRes.CDNName
+		if res.CDN == "" {
+			res.CDN = "unknown"
+		}
+	}
+
+	// Enrich with Amass Infra Data
+	if inf, ok := amassInfra(hRes.Input); ok {
+		res.Asn = fmt.Sprintf("AS%d", inf.Asn)
+		res.Org = inf.Org
+	}
+	return res
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Column widths of -plain lines
+const (
+	plainHostWidth  = 40
+	plainTitleWidth = 40
+)
+
+// resultEncoder writes one record per call: JSON by default, a -plain line
+// with -plain, the rendered -template, or with -format junit or defectdojo
+// a document written at the end of the run
+type resultEncoder interface {
+	Encode(v interface{}) error
+}
+
+// newResultEncoder returns the encoder for results written to w
+func newResultEncoder(w io.Writer) resultEncoder {
+	switch {
+	case resultTemplate != nil:
+		return &templateEncoder{w: w, t: resultTemplate}
+	case plainOutput && hostCommand:
+		return &hostEncoder{w: w, color: plainColor()}
+	case plainOutput:
+		return &plainEncoder{w: w, color: plainColor(), stream: plainStream || monitor}
+	case outputFormat == "junit":
+		return &junitEncoder{w: w}
+	case outputFormat == "defectdojo":
+		return &ddEncoder{w: w}
+	}
+	if includeFields != nil || excludeFields != nil {
+		return fieldsEncoder{json.NewEncoder(w)}
+	}
+	return json.NewEncoder(w)
+}
+
+// flushEncoder writes out what an encoder holds back until the run ends
+func flushEncoder(e resultEncoder) {
+	f, ok := e.(interface{ Flush() error })
+	if !ok {
+		return
+	}
+	if err := f.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+	}
+}
+
+// plainColor reports whether -plain lines are colorized: not with
+// -no-color or NO_COLOR, and only when they go to a terminal
+func plainColor() bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || outputPath != "" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// validatePlain checks -plain against the other output options
+func validatePlain() error {
+	if !plainOutput {
+		if plainStream {
+			return fmt.Errorf("-plain-stream needs -plain")
+		}
+		return nil
+	}
+	if outputFormat != "" {
+		return fmt.Errorf("-plain and -format are mutually exclusive")
+	}
+	if tuiFlag {
+		return fmt.Errorf("-plain and -tui are mutually exclusive")
+	}
+	return nil
+}
+
+type plainEncoder struct {
+	w      io.Writer
+	color  bool
+	stream bool
+	held   []Result
+}
+
+// Encode writes results as -plain lines, held back until Flush unless
+// -plain-stream is set; anything else, such as event records, stays JSON
+// and is written at once
+func (e *plainEncoder) Encode(v interface{}) error {
+	res, ok := v.(Result)
+	if !ok {
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	if !e.stream {
+		e.held = append(e.held, res)
+		return nil
+	}
+	_, err := fmt.Fprintln(e.w, plainLine(res, e.color))
+	return err
+}
+
+// Flush writes the held results, highest interest score first and
+// otherwise in the order they arrived
+func (e *plainEncoder) Flush() error {
+	sort.SliceStable(e.held, func(i, j int) bool { return e.held[i].InterestScore > e.held[j].InterestScore })
+	for _, res := range e.held {
+		if _, err := fmt.Fprintln(e.w, plainLine(res, e.color)); err != nil {
+			return err
+		}
+	}
+	e.held = nil
+	return nil
+}
+
+// plainLine renders res as one aligned line: status, host, title and tech,
+// then what the enrichers added as bracketed suffixes
+func plainLine(res Result, color bool) string {
+	status := "---"
+	if res.StatusCode > 0 {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	if color {
+		status = "\x1b[" + statusANSI(res.StatusCode) + "m" + status + "\x1b[0m"
+	}
+
+	host := res.Subdomain
+	if host == "" {
+		host = res.IP
+	}
+	if res.Port != 0 && res.Port != 80 && res.Port != 443 {
+		host += ":" + strconv.Itoa(res.Port)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %-*s  %-*s", status, plainHostWidth, host, plainTitleWidth, truncate(res.Title, plainTitleWidth))
+	if len(res.TechStack) > 0 {
+		fmt.Fprintf(&b, "  [%s]", strings.Join(res.TechStack, ", "))
+	}
+	for _, s := range plainSuffixes(res) {
+		b.WriteString(" [" + s + "]")
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// plainSuffixes lists the compact enrichment tags of res
+func plainSuffixes(res Result) []string {
+	var out []string
+	if res.StatusCode == 0 && res.Source != "" {
+		// Port scan, mail and zone transfer records say what they are
+		out = append(out, res.Source)
+	}
+	if res.ChangeType != "" {
+		out = append(out, res.ChangeType)
+	}
+	if res.InterestScore > 0 {
+		out = append(out, "score "+strconv.Itoa(res.InterestScore))
+	}
+	if res.CDN != "" {
+		out = append(out, "cdn "+res.CDN)
+	}
+	if len(res.OpenPorts) > 0 {
+		ports := make([]string, len(res.OpenPorts))
+		for i, p := range res.OpenPorts {
+			ports[i] = strconv.Itoa(p.Port)
+		}
+		out = append(out, "ports "+strings.Join(ports, ","))
+	}
+	if len(res.Flags) > 0 {
+		out = append(out, "flags "+strings.Join(res.Flags, ","))
+	}
+	if len(res.Vulnerabilities) > 0 {
+		out = append(out, "findings "+strconv.Itoa(len(res.Vulnerabilities)))
+	}
+	if res.FinalURL != "" {
+		out = append(out, "-> "+res.FinalURL)
+	}
+	return out
+}
+
+// statusANSI is the SGR color of a status class
+func statusANSI(code int) string {
+	switch code / 100 {
+	case 2:
+		return "32"
+	case 3:
+		return "36"
+	case 4:
+		return "33"
+	case 5:
+		return "31"
+	}
+	return "90"
+}
+
+// truncate shortens s to n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
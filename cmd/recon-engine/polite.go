@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// politeSummary records the limits -polite ran under in the run summary
+type politeSummary struct {
+	RateLimit       int    `json:"rate_limit"` // requests per second aimed at the target
+	Delay           string `json:"delay"`      // between per-host active probes
+	Concurrency     int    `json:"concurrency"`
+	DirbruteThreads int    `json:"dirbrute_threads,omitempty"`
+	RobotsTxt       bool   `json:"robots_txt"`
+}
+
+var (
+	// politeLimiter throttles the native requests aimed at the target with
+	// -polite; passive discovery traffic only waits on nativeLimiter
+	politeLimiter *tokenBucket
+
+	// politeMu runs the active per-host enrichers one at a time, at least
+	// -polite-delay apart
+	politeMu   sync.Mutex
+	politeLast time.Time
+
+	// robotsCache holds each base URL's Disallow rules for -polite
+	robotsCache sync.Map // base URL -> []string
+)
+
+// configurePolite checks the -polite flags and sets the limits it imposes,
+// warning about the flags it overrides. Called once after flag parsing.
+func configurePolite() error {
+	if !politeMode {
+		return nil
+	}
+	if politeRate < 1 {
+		return fmt.Errorf("-polite-rate must be at least 1")
+	}
+	if politeDelay < 0 {
+		return fmt.Errorf("-polite-delay must not be negative")
+	}
+	if rateLimit > politeRate {
+		fmt.Fprintf(os.Stderr, "Warning: -polite limits traffic to the target to %d requests/s, below -rate-limit %d\n", politeRate, rateLimit)
+	}
+	if dirBrute {
+		fmt.Fprintf(os.Stderr, "Warning: -polite runs -dirbrute one host at a time with a single ffuf thread, skipping paths robots.txt disallows\n")
+	}
+	if httpxThreads > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: -polite runs httpx with one thread instead of -httpx-threads %d\n", httpxThreads)
+	}
+	politeLimiter = newTokenBucket(activeRate())
+	summary.Polite = &politeSummary{
+		RateLimit:   activeRate(),
+		Delay:       politeDelay.String(),
+		Concurrency: 1,
+		RobotsTxt:   true,
+	}
+	if dirBrute {
+		summary.Polite.DirbruteThreads = 1
+	}
+	return nil
+}
+
+// activeRate is the request rate for traffic aimed at the target: -rate-limit,
+// lowered to -polite-rate with -polite. 0 means unlimited.
+func activeRate() int {
+	if !politeMode || (rateLimit > 0 && rateLimit < politeRate) {
+		return rateLimit
+	}
+	return politeRate
+}
+
+// politeStep runs fn, an enricher that sends requests to the host. With
+// -polite only one runs at a time, starting -polite-delay after the previous
+// one finished.
+func politeStep(ctx context.Context, fn func()) {
+	if !politeMode {
+		fn()
+		return
+	}
+	politeMu.Lock()
+	defer politeMu.Unlock()
+	if wait := politeDelay - time.Since(politeLast); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+	fn()
+	politeLast = time.Now()
+}
+
+// robotsDisallowed reports whether -polite must leave rawURL alone because
+// its host's robots.txt disallows the path. Rules are honoured whatever user
+// agent they are written for; a missing robots.txt allows everything.
+func robotsDisallowed(ctx context.Context, rawURL string) bool {
+	if !politeMode {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	base := u.Scheme + "://" + u.Host
+	rules, ok := robotsCache.Load(base)
+	if !ok {
+		var disallow []string
+		if body, err := fetchCapped(ctx, base+"/robots.txt", robotsMaxBytes); err == nil {
+			disallow, _ = parseRobots(body)
+		}
+		rules, _ = robotsCache.LoadOrStore(base, disallow)
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	for _, rule := range rules.([]string) {
+		if robotsMatch(rule, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// robotsMatch matches a Disallow rule against a path: a prefix match where *
+// stands for any characters and a trailing $ anchors the end (RFC 9309)
+func robotsMatch(rule, p string) bool {
+	anchored := strings.HasSuffix(rule, "$")
+	parts := strings.Split(strings.TrimSuffix(rule, "$"), "*")
+	if !strings.HasPrefix(p, parts[0]) {
+		return false
+	}
+	rest := p[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	if anchored && rest != "" {
+		// The last part may match later in the path
+		last := parts[len(parts)-1]
+		return len(parts) > 1 && strings.HasSuffix(p, last)
+	}
+	return true
+}
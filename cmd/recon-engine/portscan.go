@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// portscanBatch is how many IPs one nmap or naabu invocation scans;
+	// masscan gets every IP at once
+	portscanBatch = 256
+	// masscanSafeRate is the highest -portscan-rate masscan runs at without
+	// -masscan-confirm
+	masscanSafeRate = 10000
+)
+
+// OpenPort is a port -portscan hosts found open on a host's IP
+type OpenPort struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Service  string `json:"service,omitempty"`
+	Product  string `json:"product,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+// validatePortscan checks the -portscan* flags once after parsing
+func validatePortscan() error {
+	switch portscanMode {
+	case "root", "hosts":
+	default:
+		return fmt.Errorf("-portscan: want root or hosts, got %q", portscanMode)
+	}
+	switch portscanEngine {
+	case "nmap":
+	case "naabu":
+		if portscanMode != "hosts" {
+			return fmt.Errorf("-portscan-engine naabu needs -portscan hosts")
+		}
+		// naabu only knows its own fixed top-port lists
+		if portscanTopPorts != 100 && portscanTopPorts != 1000 {
+			return fmt.Errorf("naabu supports -portscan-top-ports 100 or 1000, got %d", portscanTopPorts)
+		}
+	case "masscan":
+		if portscanMode != "hosts" {
+			return fmt.Errorf("-portscan-engine masscan needs -portscan hosts")
+		}
+		// masscan is fast enough to flood a network by accident
+		if portscanRate == 0 {
+			return fmt.Errorf("-portscan-engine masscan needs an explicit -portscan-rate")
+		}
+		if portscanRate > masscanSafeRate && !masscanConfirm {
+			return fmt.Errorf("-portscan-rate %d is above %d packets/s, add -masscan-confirm to run masscan that fast", portscanRate, masscanSafeRate)
+		}
+	default:
+		return fmt.Errorf("-portscan-engine: want nmap, naabu or masscan, got %q", portscanEngine)
+	}
+	if masscanServices && portscanEngine != "masscan" {
+		return fmt.Errorf("-masscan-services needs -portscan-engine masscan")
+	}
+	if portscanTopPorts < 1 || portscanTopPorts > 65535 {
+		return fmt.Errorf("-portscan-top-ports must be between 1 and 65535")
+	}
+	if portscanRate < 0 {
+		return fmt.Errorf("-portscan-rate must not be negative")
+	}
+	return nil
+}
+
+// portscanBinaries are the tools the port scan stage runs
+func portscanBinaries() []string {
+	switch {
+	case portscanMode != "hosts":
+		return []string{"nmap"}
+	case masscanServices:
+		return []string{"masscan", "nmap"}
+	}
+	return []string{portscanEngine}
+}
+
+// hostNmapArgs builds the nmap command line for a batch of IPs of one
+// family. Without -sV the service names come from nmap's port table, which
+// keeps it fast.
+func hostNmapArgs(ips []string) []string {
+	args := []string{"-n", "-Pn", "--open", "--top-ports", strconv.Itoa(portscanTopPorts)}
+	if hasIPv6(ips) {
+		args = append(args, "-6")
+	}
+	if portscanRate > 0 {
+		args = append(args, "--max-rate", strconv.Itoa(portscanRate))
+	}
+	args = append(args, egressArgs("nmap")...)
+	args = append(args, "-oX", "-")
+	return append(args, ips...)
+}
+
+// nmapServiceArgs builds the nmap service detection command line run on
+// the ports masscan found open in a batch of IPs of one family
+func nmapServiceArgs(ports string, ips []string) []string {
+	args := []string{"-n", "-Pn", "-sV", "-p", ports}
+	if hasIPv6(ips) {
+		args = append(args, "-6")
+	}
+	if portscanRate > 0 {
+		args = append(args, "--max-rate", strconv.Itoa(portscanRate))
+	}
+	args = append(args, "-oX", "-")
+	return append(args, ips...)
+}
+
+// masscanArgs builds the masscan command line for a set of IPs
+func masscanArgs(ips []string) []string {
+	args := []string{"-oJ", "-", "--rate", strconv.Itoa(portscanRate), "--top-ports", strconv.Itoa(portscanTopPorts)}
+	args = append(args, egressArgs("masscan")...)
+	return append(args, ips...)
+}
+
+// naabuArgs builds the naabu command line; the IPs go to its stdin
+func naabuArgs(ips []string) []string {
+	args := []string{"-silent", "-json", "-top-ports", strconv.Itoa(portscanTopPorts)}
+	if portscanRate > 0 {
+		args = append(args, "-rate", strconv.Itoa(portscanRate))
+	}
+	args = append(args, naabuIPVersionArgs(ips)...)
+	return append(args, egressArgs("naabu")...)
+}
+
+// addPortTarget records the IP of a probed host for -portscan hosts, and
+// for a dual-stack host its first address of the other family, whose
+// firewall often differs. IPs httpx identified as a CDN edge are skipped:
+// their ports say nothing about the origin.
+func addPortTarget(targets map[string][]string, res Result) {
+	if res.IP == "" || res.CDN != "" {
+		return
+	}
+	ips := []string{res.IP}
+	for _, ip := range res.IPs {
+		if isIPv6(ip) != isIPv6(res.IP) {
+			ips = append(ips, ip)
+			break
+		}
+	}
+	for _, ip := range ips {
+		if !scopeAllowsIP(ip) || slices.Contains(targets[ip], res.Subdomain) {
+			continue
+		}
+		targets[ip] = append(targets[ip], res.Subdomain)
+	}
+}
+
+// runHostPortscan scans every IP in targets once, however many hosts share
+// it, and emits a Result per host with the open ports of its IP
+func runHostPortscan(ctx context.Context, target string, targets map[string][]string, emit func(Result)) {
+	ips := make([]string, 0, len(targets))
+	for ip := range targets {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	fmt.Fprintf(os.Stderr, "Port scanning %d IPs with %s\n", len(ips), portscanEngine)
+	coverageAdd("portscan", len(ips))
+
+	size := portscanBatchSize(len(ips))
+	for start := 0; start < len(ips) && ctx.Err() == nil; start += size {
+		batch := ips[start:min(start+size, len(ips))]
+		found := scanPortBatch(ctx, batch)
+		for _, ip := range batch {
+			ports := found[ip]
+			if len(ports) == 0 {
+				continue
+			}
+			for _, sub := range targets[ip] {
+				emit(portscanResult(target, sub, ip, ports))
+			}
+		}
+	}
+}
+
+// portscanBatchSize is how many of n IPs one -portscan-engine run scans
+func portscanBatchSize(n int) int {
+	if portscanEngine == "masscan" {
+		return max(n, 1)
+	}
+	return portscanBatch
+}
+
+// scanPortBatch scans a batch of IPs with -portscan-engine and returns the
+// open ports per IP; failures are reported and yield what was found
+func scanPortBatch(ctx context.Context, batch []string) map[string][]OpenPort {
+	var found map[string][]OpenPort
+	var err error
+	switch portscanEngine {
+	case "naabu":
+		found, err = scanNaabu(ctx, batch)
+	case "masscan":
+		found, err = scanMasscan(ctx, batch)
+		if err == nil && masscanServices {
+			found, err = detectServices(ctx, found)
+		}
+	default:
+		// nmap scans one address family per run
+		found = make(map[string][]OpenPort)
+		for _, group := range splitFamilies(batch) {
+			f, gErr := scanNmap(ctx, hostNmapArgs(group))
+			for ip, ports := range f {
+				found[ip] = ports
+			}
+			if err == nil {
+				err = gErr
+			}
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		reportToolError(portscanEngine, "portscan", "", err)
+	}
+	return found
+}
+
+// portscanResult is the Result carrying the open ports of ip for the host
+// sub on it
+func portscanResult(target, sub, ip string, ports []OpenPort) Result {
+	return Result{
+		RunID:           runID,
+		RootDomain:      target,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Subdomain:       sub,
+		TechStack:       []string{},
+		Vulnerabilities: []Finding{},
+		Source:          "portscan",
+		IP:              ip,
+		OpenPorts:       ports,
+	}
+}
+
+// nmapRun is the part of nmap's XML report the engine reads
+type nmapRun struct {
+	Hosts []struct {
+		Addresses []struct {
+			Addr string `xml:"addr,attr"`
+			Type string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+			Service struct {
+				Name    string `xml:"name,attr"`
+				Product string `xml:"product,attr"`
+				Version string `xml:"version,attr"`
+			} `xml:"service"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+// scanNmap runs nmap with args, which must include -oX -, and returns the
+// open ports per IP
+func scanNmap(ctx context.Context, args []string) (map[string][]OpenPort, error) {
+	var stderr bytes.Buffer
+	cmd := toolCommand(ctx, toolPath("nmap"), args...)
+	cmd.Stderr = &stderr
+	out, err := toolOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var run nmapRun
+	if err := xml.Unmarshal(out, &run); err != nil {
+		return nil, err
+	}
+	found := make(map[string][]OpenPort)
+	for _, h := range run.Hosts {
+		ip := ""
+		for _, a := range h.Addresses {
+			if a.Type == "ipv4" || a.Type == "ipv6" {
+				ip = a.Addr
+				break
+			}
+		}
+		for _, p := range h.Ports {
+			if ip == "" || p.State.State != "open" {
+				continue
+			}
+			found[ip] = append(found[ip], OpenPort{
+				Port:     p.PortID,
+				Protocol: p.Protocol,
+				Service:  p.Service.Name,
+				Product:  p.Service.Product,
+				Version:  p.Service.Version,
+			})
+		}
+	}
+	return found, nil
+}
+
+// naabuResult is one line of naabu -json output
+type naabuResult struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+func scanNaabu(ctx context.Context, ips []string) (map[string][]OpenPort, error) {
+	cmd := toolCommand(ctx, toolPath("naabu"), naabuArgs(ips)...)
+	cmd.Stdin = strings.NewReader(strings.Join(ips, "\n") + "\n")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := startTool(cmd); err != nil {
+		return nil, err
+	}
+	found := make(map[string][]OpenPort)
+	seen := make(map[string]bool)
+	lines := newLineReader(out, "naabu")
+	for lines.Next() {
+		var r naabuResult
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			rejectedLine("naabu", "portscan", eventParseFailed, err)
+			continue
+		}
+		if _, err := netip.ParseAddr(r.IP); err != nil || !validPort(r.Port) {
+			rejectedLine("naabu", "portscan", eventInvalid, fmt.Errorf("bad address %q or port %d", r.IP, r.Port))
+			continue
+		}
+		if r.Protocol == "" {
+			r.Protocol = "tcp"
+		}
+		key := r.IP + "/" + r.Protocol + "/" + strconv.Itoa(r.Port)
+		if r.IP == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		found[r.IP] = append(found[r.IP], OpenPort{Port: r.Port, Protocol: r.Protocol})
+	}
+	for _, ports := range found {
+		sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	}
+	if err := lines.Err(); err != nil {
+		waitTool(cmd)
+		return found, err
+	}
+	return found, waitTool(cmd)
+}
+
+// masscanRecord is one host entry of masscan -oJ output
+type masscanRecord struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port   int    `json:"port"`
+		Proto  string `json:"proto"`
+		Status string `json:"status"`
+	} `json:"ports"`
+}
+
+// scanMasscan runs masscan over ips. Its -oJ output is a JSON array written
+// one record per line, with the separating commas at either end of a line.
+func scanMasscan(ctx context.Context, ips []string) (map[string][]OpenPort, error) {
+	var stderr bytes.Buffer
+	cmd := toolCommand(ctx, toolPath("masscan"), masscanArgs(ips)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := startTool(cmd); err != nil {
+		return nil, err
+	}
+	found := make(map[string][]OpenPort)
+	seen := make(map[string]bool)
+	lines := newLineReader(out, "masscan")
+	for lines.Next() {
+		line := strings.Trim(strings.TrimSpace(lines.Text()), ",")
+		if line == "" || line == "[" || line == "]" {
+			continue
+		}
+		var r masscanRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			rejectedLine("masscan", "portscan", eventParseFailed, err)
+			continue
+		}
+		if _, err := netip.ParseAddr(r.IP); err != nil {
+			rejectedLine("masscan", "portscan", eventInvalid, fmt.Errorf("bad address %q", r.IP))
+			continue
+		}
+		for _, p := range r.Ports {
+			key := r.IP + "/" + p.Proto + "/" + strconv.Itoa(p.Port)
+			if p.Status != "open" || seen[key] {
+				continue
+			}
+			if !validPort(p.Port) {
+				rejectedLine("masscan", "portscan", eventInvalid, fmt.Errorf("%s: bad port %d", r.IP, p.Port))
+				continue
+			}
+			seen[key] = true
+			found[r.IP] = append(found[r.IP], OpenPort{Port: p.Port, Protocol: p.Proto})
+		}
+	}
+	for _, ports := range found {
+		sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	}
+	if err := lines.Err(); err != nil {
+		waitTool(cmd)
+		return found, err
+	}
+	if err := waitTool(cmd); err != nil {
+		return found, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return found, nil
+}
+
+// detectServices runs nmap service detection on the open ports masscan
+// reported, a batch of IPs at a time over the union of their ports. Only
+// ports masscan found are kept, so the result has the same shape as
+// masscan's. When nmap fails the masscan ports are returned unchanged.
+func detectServices(ctx context.Context, found map[string][]OpenPort) (map[string][]OpenPort, error) {
+	ips := make([]string, 0, len(found))
+	for ip := range found {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	var firstErr error
+	for start := 0; start < len(ips) && ctx.Err() == nil; start += portscanBatch {
+		batch := ips[start:min(start+portscanBatch, len(ips))]
+		union := make(map[int]bool)
+		for _, ip := range batch {
+			for _, p := range found[ip] {
+				union[p.Port] = true
+			}
+		}
+		ports := make([]int, 0, len(union))
+		for p := range union {
+			ports = append(ports, p)
+		}
+		sort.Ints(ports)
+		list := make([]string, len(ports))
+		for i, p := range ports {
+			list[i] = strconv.Itoa(p)
+		}
+
+		detected := make(map[string][]OpenPort)
+		for _, group := range splitFamilies(batch) {
+			d, err := scanNmap(ctx, nmapServiceArgs(strings.Join(list, ","), group))
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			for ip, ports := range d {
+				detected[ip] = ports
+			}
+		}
+		for _, ip := range batch {
+			for i, p := range found[ip] {
+				for _, d := range detected[ip] {
+					if d.Port == p.Port && d.Protocol == p.Protocol {
+						found[ip][i] = d
+						break
+					}
+				}
+			}
+		}
+	}
+	return found, firstErr
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+)
+
+// startPprof serves the runtime profiles on -pprof for the life of the
+// run. The handlers get their own mux so they are never exposed anywhere
+// else, such as serve's API.
+func startPprof() error {
+	if pprofAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", pprofAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go http.Serve(ln, mux)
+	fmt.Fprintf(os.Stderr, "Profiles at http://%s/debug/pprof/\n", ln.Addr())
+	return nil
+}
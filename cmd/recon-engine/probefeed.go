@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// httpxRestarts is how many times an httpx that dies mid-run is started
+// again with the names it never got to
+const httpxRestarts = 1
+
+// probeRestart is in the run summary when httpx died mid-run
+type probeRestart struct {
+	Error     string `json:"error"`
+	Fed       int    `json:"fed"`     // names written to httpx before it died
+	Results   int    `json:"results"` // lines it answered with until then
+	Resent    int    `json:"resent"`  // names written again to the new httpx
+	Restarted bool   `json:"restarted"`
+	Unprobed  int    `json:"unprobed,omitempty"` // names dropped after the last httpx died
+}
+
+// probeFeed writes the probe queue to httpx's stdin. A name whose write
+// fails once httpx is gone is held for its replacement, which is also sent
+// the names the dead one was sent but never answered. With -probe-batch
+// each httpx is sent at most that many names: the next name waits for a
+// fresh httpx, started once the last one answered its batch and exited.
+type probeFeed struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	in       io.WriteCloser // nil while httpx is being replaced
+	closed   bool           // in was closed after the last name, or of its batch
+	batchEnd bool           // in was closed at a -probe-batch boundary
+	done     bool           // httpx finished cleanly; nothing is left to write
+	gaveUp   bool
+	cut      bool     // -time-budget ended probing; nothing more is written
+	sent     []string // names written to the current httpx
+	batches  int      // httpx processes started for a new batch
+
+	resending sync.WaitGroup // resume writing to the new httpx
+
+	fed, dropped, skipped int
+}
+
+func newProbeFeed(in io.WriteCloser) *probeFeed {
+	f := &probeFeed{in: in}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// run writes queue's names until it is closed and httpx has either
+// finished or been given up on. Called on its own goroutine.
+func (f *probeFeed) run(queue *probeQueue) {
+	var failed io.WriteCloser // the stdin the held name could not be written to
+	name, ok := queue.Pop()
+	for {
+		f.mu.Lock()
+		for (f.in == nil || f.in == failed) && !f.gaveUp && !f.cut {
+			f.cond.Wait()
+		}
+		if !ok {
+			// Signal httpx we are done sending targets, then wait until it
+			// exits cleanly or a replacement has been sent the rest
+			for !f.done && !f.gaveUp {
+				if in := f.in; in != nil && !f.closed {
+					f.closed = true
+					in.Close()
+				}
+				f.cond.Wait()
+			}
+			f.mu.Unlock()
+			return
+		}
+		if f.cut {
+			f.skipped++
+			f.mu.Unlock()
+			name, ok = queue.Pop()
+			continue
+		}
+		if f.gaveUp {
+			f.dropped++
+			f.mu.Unlock()
+			name, ok = queue.Pop()
+			continue
+		}
+		in := f.in
+		// A full batch is closed only once another name is waiting, so
+		// the next httpx always has one to probe
+		if probeBatch > 0 && len(f.sent) >= probeBatch {
+			if !f.closed {
+				f.closed, f.batchEnd = true, true
+				in.Close()
+			}
+			failed = in
+			f.mu.Unlock()
+			continue
+		}
+		f.mu.Unlock()
+
+		if _, err := fmt.Fprintln(in, name); err != nil {
+			failed = in
+			continue
+		}
+		f.mu.Lock()
+		f.sent = append(f.sent, name)
+		f.fed++
+		f.mu.Unlock()
+		name, ok = queue.Pop()
+	}
+}
+
+// ended is called once httpx's output ended. It stops writing to httpx and
+// reports whether it was sent every name.
+func (f *probeFeed) ended() (complete bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.in = nil
+	return f.closed
+}
+
+// nextBatch reports whether httpx exited cleanly at a -probe-batch
+// boundary, with more names to probe. The writer starts over for the next
+// httpx.
+func (f *probeFeed) nextBatch() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.batchEnd || f.cut || f.gaveUp {
+		return false
+	}
+	f.sent, f.closed, f.batchEnd = nil, false, false
+	f.batches++
+	return true
+}
+
+// finish tells the writer httpx exited cleanly
+func (f *probeFeed) finish() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.done = true
+	f.cond.Broadcast()
+}
+
+// lost returns the names a dead httpx was sent but never answered. The
+// writer starts over for the next httpx.
+func (f *probeFeed) lost(answered func(string) bool) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, name := range f.sent {
+		if !answered(name) {
+			names = append(names, name)
+		}
+	}
+	f.sent, f.closed, f.batchEnd = nil, false, false
+	return names
+}
+
+// resume sends names to the new httpx behind in, then hands in to the
+// writer for the rest of the queue. Names that cannot be written are left
+// for the next httpxEnded to count.
+func (f *probeFeed) resume(in io.WriteCloser, names []string) {
+	f.resending.Add(1)
+	go func() {
+		defer f.resending.Done()
+		for _, name := range names {
+			if _, err := fmt.Fprintln(in, name); err != nil {
+				break
+			}
+		}
+		f.mu.Lock()
+		f.sent = append(f.sent, names...)
+		f.in = in
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	}()
+}
+
+// giveUp drops names, and with them every name still to come
+func (f *probeFeed) giveUp(names []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.in != nil {
+		f.in.Close()
+		f.in = nil
+	}
+	f.gaveUp = true
+	f.dropped += len(names)
+	f.cond.Broadcast()
+}
+
+// cutOff stops writing names and closes httpx's stdin, so it finishes
+// the names it was sent and exits. The rest of the queue is skipped.
+func (f *probeFeed) cutOff() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cut = true
+	if f.in != nil && !f.closed {
+		f.closed = true
+		f.in.Close()
+	}
+	f.cond.Broadcast()
+}
+
+// skippedNames returns how many names cutOff kept from httpx
+func (f *probeFeed) skippedNames() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.skipped
+}
+
+// batchCount returns how many httpx processes -probe-batch started after
+// the first
+func (f *probeFeed) batchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.batches
+}
+
+// counts returns the names written so far and those dropped
+func (f *probeFeed) counts() (fed, dropped int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fed, f.dropped
+}
+
+// startHttpx starts httpx with its stdin and stdout piped
+func startHttpx(ctx context.Context) (*exec.Cmd, io.WriteCloser, io.Reader, error) {
+	if err := waitProxy(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+	cmd := toolCommand(ctx, toolPath("httpx"), httpxArgs()...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create httpx stdin pipe: %w", err)
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create httpx stdout pipe: %w", err)
+	}
+	if err := startTool(cmd); err != nil {
+		reportToolError("httpx", "probe", eventStartFailed, err)
+		return nil, nil, nil, fmt.Errorf("failed to start httpx: %w", err)
+	}
+	return cmd, in, out, nil
+}
+
+// httpxEnded reaps httpx once its output ended. When it finished a
+// -probe-batch it flushes -o, so a killed run keeps what the batches
+// before it found, and starts the httpx of the next batch. When it died,
+// by exiting non-zero or before it was sent every name, it reports how far
+// it got and, while restarts are left, starts a new httpx for the names it
+// never answered, returning its output. It returns nil once probing is
+// over.
+func httpxEnded(ctx context.Context, cmd probeProcess, feed *probeFeed, results int, answered func(string) bool, restarts *int) (probeProcess, io.Reader) {
+	err := cmd.Wait()
+	feed.resending.Wait()
+	complete := feed.ended()
+	if ctx.Err() != nil {
+		feed.giveUp(nil)
+		return nil, nil
+	}
+	if err == nil && complete && feed.nextBatch() {
+		stats.Add("probe.batches", 1)
+		if ferr := flushOutput(); ferr != nil {
+			fmt.Fprintf(os.Stderr, "Error flushing output: %v\n", ferr)
+		}
+		next, in, out, serr := startProber(ctx)
+		if serr == nil {
+			feed.resume(in, nil)
+			return next, out
+		}
+		fmt.Fprintf(os.Stderr, "Giving up on probing the remaining names: cannot start the next batch: %v\n", serr)
+		feed.giveUp(nil)
+		return nil, nil
+	}
+	if err == nil && complete {
+		feed.finish()
+		return nil, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("exited before its input was complete")
+	}
+	reportToolError("httpx", "probe", "", err)
+	fed, _ := feed.counts()
+	lost := feed.lost(answered)
+	fmt.Fprintf(os.Stderr, "httpx died (%v) after %d names were fed and %d results came back\n", err, fed, results)
+	summary.mu.Lock()
+	if summary.ProbeRestart == nil {
+		summary.ProbeRestart = &probeRestart{}
+	}
+	summary.ProbeRestart.Error, summary.ProbeRestart.Fed, summary.ProbeRestart.Results = err.Error(), fed, results
+	summary.mu.Unlock()
+
+	if *restarts < httpxRestarts {
+		*restarts++
+		next, in, out, serr := startProber(ctx)
+		if serr == nil {
+			fmt.Fprintf(os.Stderr, "Restarting httpx with the %d names it did not answer and the rest of the queue\n", len(lost))
+			summary.mu.Lock()
+			summary.ProbeRestart.Restarted = true
+			summary.ProbeRestart.Resent = len(lost)
+			summary.mu.Unlock()
+			feed.resume(in, lost)
+			return next, out
+		}
+		err = serr
+	}
+	fmt.Fprintf(os.Stderr, "Giving up on probing the remaining names: %v\n", err)
+	feed.giveUp(lost)
+	return nil, nil
+}
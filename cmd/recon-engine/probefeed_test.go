@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// crashingHttpx is an httpx that, the first crashes times it runs, answers
+// two names and dies reading the fourth; after that it answers everything
+func crashingHttpx(t *testing.T, crashes int) {
+	dir := t.TempDir()
+	fakeTool(t, "httpx", fmt.Sprintf(`runs=$(cat %[1]s 2>/dev/null || echo 0)
+echo $((runs+1)) > %[1]s
+n=0
+while read name; do
+	n=$((n+1))
+	if [ $runs -lt %[2]d ]; then
+		[ $n -gt 3 ] && exit 1
+		[ $n -gt 2 ] && continue
+	fi
+	echo "{\"input\":\"$name\",\"url\":\"https://$name\",\"status_code\":200}"
+done`, filepath.Join(dir, "runs"), crashes))
+}
+
+// probeAll runs names through httpx the way the pipeline does, restarting
+// it when it dies, and counts the answers per name
+func probeAll(t *testing.T, names []string) (map[string]int, *probeFeed) {
+	t.Helper()
+	setQueueMemory(t, 1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	queue := newProbeQueue()
+	for _, name := range names {
+		queue.Push(name)
+	}
+	queue.Close()
+	return probeQueued(ctx, t, queue)
+}
+
+// probeQueued runs what is pushed to queue through httpx until it is
+// closed, and counts the answers per name
+func probeQueued(ctx context.Context, t *testing.T, queue *probeQueue) (map[string]int, *probeFeed) {
+	t.Helper()
+	oldEngine := probeEngine
+	summary.mu.Lock()
+	oldRestart := summary.ProbeRestart
+	summary.ProbeRestart = nil
+	summary.mu.Unlock()
+	t.Cleanup(func() {
+		probeEngine = oldEngine
+		summary.mu.Lock()
+		summary.ProbeRestart = oldRestart
+		summary.mu.Unlock()
+	})
+	probeEngine = probeEngineHttpx
+
+	prober, in, out, err := startProber(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := newProbeFeed(in)
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		feed.run(queue)
+	}()
+
+	answers := make(map[string]int)
+	lines, restarts := 0, 0
+	scanner := newLineReader(out, "httpx")
+	for {
+		if !scanner.Next() {
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+			prober, out = httpxEnded(ctx, prober, feed, lines, func(name string) bool { return answers[name] > 0 }, &restarts)
+			if prober == nil {
+				break
+			}
+			scanner = newLineReader(out, "httpx")
+			continue
+		}
+		lines++
+		var h HttpxResult
+		if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+		answers[h.Input]++
+	}
+	select {
+	case <-fed:
+	case <-ctx.Done():
+		t.Fatal("the feed never finished")
+	}
+	return answers, feed
+}
+
+func probeNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("h%d.example.com", i)
+	}
+	return names
+}
+
+// TestHttpxRestart restarts an httpx that dies mid-run with the names it
+// was sent but never answered and the rest of the queue: every name is
+// answered, and none twice
+func TestHttpxRestart(t *testing.T) {
+	crashingHttpx(t, 1)
+	names := probeNames(20)
+	answers, feed := probeAll(t, names)
+	for _, name := range names {
+		if answers[name] != 1 {
+			t.Errorf("%s answered %d times", name, answers[name])
+		}
+	}
+	if _, dropped := feed.counts(); dropped != 0 {
+		t.Errorf("%d names dropped", dropped)
+	}
+	r := summary.ProbeRestart
+	if r == nil || !r.Restarted || r.Results != 2 || r.Resent < 2 || r.Unprobed != 0 {
+		t.Errorf("probe_restart %+v", r)
+	}
+}
+
+// TestHttpxGivesUp stops after httpxRestarts when the new httpx dies too,
+// dropping the names it was never sent
+func TestHttpxGivesUp(t *testing.T) {
+	crashingHttpx(t, httpxRestarts+1)
+	names := probeNames(20)
+	answers, feed := probeAll(t, names)
+	total := 0
+	for _, n := range answers {
+		total += n
+	}
+	if total != 2*(httpxRestarts+1) {
+		t.Errorf("%d answers, want %d", total, 2*(httpxRestarts+1))
+	}
+	if _, dropped := feed.counts(); dropped == 0 {
+		t.Error("no names counted as dropped")
+	}
+}
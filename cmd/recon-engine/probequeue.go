@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// probeQueue sits between discovery and httpx so that neither waits on the
+// other: Push never blocks, however far httpx falls behind. Up to
+// -queue-memory names are held in memory; beyond that they are appended to
+// a temporary file and read back in order once memory has drained. The
+// depth is kept in the queue.depth counter.
+type probeQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	mem    []string // oldest names, popped first
+	closed bool
+
+	// Names pushed while mem was full, or while older ones were still on
+	// disk. The file is removed whenever it has been read back completely.
+	spill   *os.File // written through spillW
+	spillIn *os.File // read through spillR
+	spillW  *bufio.Writer
+	spillR  *bufio.Reader
+	spilled int  // names in the file not yet read back
+	noSpill bool // the file could not be written, keep everything in memory
+}
+
+func newProbeQueue() *probeQueue {
+	q := &probeQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues name for probing
+func (q *probeQueue) Push(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if (q.spilled > 0 || len(q.mem) >= queueMemory) && !q.noSpill {
+		err := q.spillName(name)
+		if err == nil {
+			stats.Add("queue.depth", 1)
+			stats.Add("queue.spilled", 1)
+			q.cond.Signal()
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Probe queue spill failed, keeping names in memory: %v\n", err)
+		q.noSpill = true
+	}
+	q.mem = append(q.mem, name)
+	stats.Add("queue.depth", 1)
+	q.cond.Signal()
+}
+
+// Close marks the end of the names; Pop returns what is left, then false
+func (q *probeQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// Pop returns the oldest queued name, waiting for one to be pushed. It
+// returns false once the queue is closed and empty, and removes the spill
+// file then.
+func (q *probeQueue) Pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.mem) == 0 && q.spilled == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.mem) == 0 && q.spilled > 0 {
+		if err := q.refill(); err != nil {
+			// The rest of the file is lost; the names were counted as fed
+			fmt.Fprintf(os.Stderr, "Probe queue read failed, %d names not probed: %v\n", q.spilled, err)
+			stats.Add("queue.depth", -int64(q.spilled))
+			q.spilled = 0
+			q.removeSpill()
+		}
+	}
+	if len(q.mem) == 0 {
+		q.removeSpill()
+		return "", false
+	}
+	name := q.mem[0]
+	q.mem[0] = ""
+	q.mem = q.mem[1:]
+	stats.Add("queue.depth", -1)
+	return name, true
+}
+
+func (q *probeQueue) spillName(name string) error {
+	if q.spill == nil {
+		f, err := os.CreateTemp("", "recon-queue-*")
+		if err != nil {
+			return err
+		}
+		r, err := os.Open(f.Name())
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		q.spill, q.spillIn = f, r
+		q.spillW, q.spillR = bufio.NewWriter(f), bufio.NewReader(r)
+	}
+	if _, err := q.spillW.WriteString(name + "\n"); err != nil {
+		return err
+	}
+	q.spilled++
+	return nil
+}
+
+// refill reads the next -queue-memory names back from the spill file,
+// removing it once nothing is left in it
+func (q *probeQueue) refill() error {
+	if err := q.spillW.Flush(); err != nil {
+		return err
+	}
+	n := min(q.spilled, queueMemory)
+	q.mem = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := q.spillR.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		q.mem = append(q.mem, strings.TrimSuffix(line, "\n"))
+	}
+	q.spilled -= n
+	if q.spilled == 0 {
+		q.removeSpill()
+	}
+	return nil
+}
+
+func (q *probeQueue) removeSpill() {
+	if q.spill == nil {
+		return
+	}
+	q.spill.Close()
+	q.spillIn.Close()
+	os.Remove(q.spill.Name())
+	q.spill, q.spillIn, q.spillW, q.spillR = nil, nil, nil, nil
+}
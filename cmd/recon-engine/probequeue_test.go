@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setQueueMemory(t *testing.T, n int) {
+	old := queueMemory
+	t.Cleanup(func() { queueMemory = old })
+	queueMemory = n
+}
+
+// TestProbeQueueNeverBlocks pushes far more names than -queue-memory with
+// nothing popping, the way discovery runs ahead of a stalled httpx, then
+// reads them back in order
+func TestProbeQueueNeverBlocks(t *testing.T) {
+	setQueueMemory(t, 10)
+	q := newProbeQueue()
+	const n = 1000
+	pushed := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			q.Push(fmt.Sprintf("h%d.example.com", i))
+		}
+		q.Close()
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Push blocked with nothing popping")
+	}
+	if q.spill == nil || q.spilled != n-10 {
+		t.Fatalf("%d names spilled, want %d", q.spilled, n-10)
+	}
+	spill := q.spill.Name()
+
+	for i := 0; i < n; i++ {
+		name, ok := q.Pop()
+		if want := fmt.Sprintf("h%d.example.com", i); !ok || name != want {
+			t.Fatalf("Pop %d = %q, %v, want %q", i, name, ok, want)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Error("Pop after the last name")
+	}
+	if _, err := os.Stat(spill); !os.IsNotExist(err) {
+		t.Errorf("spill file left behind: %v", err)
+	}
+}
+
+// TestProbeQueueInterleaved pops while pushing, so names spill while
+// older ones are still on disk and must still come out in order
+func TestProbeQueueInterleaved(t *testing.T) {
+	setQueueMemory(t, 4)
+	q := newProbeQueue()
+	const n = 500
+	go func() {
+		for i := 0; i < n; i++ {
+			q.Push(fmt.Sprintf("h%d.example.com", i))
+		}
+		q.Close()
+	}()
+	for i := 0; ; i++ {
+		name, ok := q.Pop()
+		if !ok {
+			if i != n {
+				t.Fatalf("queue ended after %d names, want %d", i, n)
+			}
+			break
+		}
+		if want := fmt.Sprintf("h%d.example.com", i); name != want {
+			t.Fatalf("Pop %d = %q, want %q", i, name, want)
+		}
+	}
+}
+
+// TestProbeQueueStress has discovery push half a million names while httpx
+// stalls before reading any: every push returns, most names go through the
+// spill file, and httpx answers each exactly once once it starts
+func TestProbeQueueStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("half a million names")
+	}
+	gate := filepath.Join(t.TempDir(), "go")
+	fakeTool(t, "httpx", fmt.Sprintf(`while [ ! -f %s ]; do sleep 0.05; done
+awk '{ printf "{\"input\":\"%%s\",\"url\":\"https://%%s\",\"status_code\":200}\n", $0, $0 }'`, gate))
+	setQueueMemory(t, 1000)
+	const n = 500000
+	spilled := stats.Get("queue.spilled")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	queue := newProbeQueue()
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for i := 0; i < n; i++ {
+			queue.Push(fmt.Sprintf("h%d.example.com", i))
+		}
+		queue.Close()
+	}()
+	go func() {
+		select {
+		case <-pushed:
+		case <-time.After(time.Minute):
+			t.Error("Push blocked while httpx stalled")
+		}
+		os.WriteFile(gate, nil, 0o644)
+	}()
+
+	answers, feed := probeQueued(ctx, t, queue)
+	if ctx.Err() != nil {
+		t.Fatal("httpx never finished")
+	}
+	if got := stats.Get("queue.spilled") - spilled; got < n/2 {
+		t.Errorf("%d names spilled, want most of %d", got, n)
+	}
+	if len(answers) != n {
+		t.Errorf("%d names answered, want %d", len(answers), n)
+	}
+	for i := 0; i < n; i++ {
+		if name := fmt.Sprintf("h%d.example.com", i); answers[name] != 1 {
+			t.Fatalf("%s answered %d times", name, answers[name])
+		}
+	}
+	if _, dropped := feed.counts(); dropped != 0 {
+		t.Errorf("%d names dropped", dropped)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// childGrace is how long stopChildren waits after asking the child tools to
+// terminate before killing them
+const childGrace = 5 * time.Second
+
+var (
+	childrenMu sync.Mutex
+	children   = make(map[int]*os.Process) // started and not yet waited for
+)
+
+// toolCommand is exec.CommandContext for an external tool. The tool runs in
+// its own process group, so a terminal's Ctrl+C reaches only the engine and
+// cancelling ctx kills everything the tool started, not just the tool.
+// Start it with startTool so every exit path can stop it. Tools that
+// -use-docker runs in a container get the docker command line instead, and
+// with -record or -replay the engine's tool shim runs in its place.
+func toolCommand(ctx context.Context, path string, args ...string) *exec.Cmd {
+	if image, ok := containerized[path]; ok && replayDir == "" {
+		path, args = "docker", dockerCommand(path, image, args)
+	}
+	var env []string
+	if exe, shimEnv, ok := tapeCommand(path, args); ok {
+		path, env = exe, append(os.Environ(), shimEnv...)
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = env
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killGroup(cmd.Process) }
+	return cmd
+}
+
+// startTool starts cmd and tracks it until waitTool
+func startTool(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	childrenMu.Lock()
+	children[cmd.Process.Pid] = cmd.Process
+	childrenMu.Unlock()
+	return nil
+}
+
+// waitTool waits for a cmd started with startTool
+func waitTool(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	childrenMu.Lock()
+	delete(children, cmd.Process.Pid)
+	childrenMu.Unlock()
+	return err
+}
+
+// runTool is cmd.Run for tracked tools
+func runTool(cmd *exec.Cmd) error {
+	if err := startTool(cmd); err != nil {
+		return err
+	}
+	return waitTool(cmd)
+}
+
+// toolOutput is cmd.Output for tracked tools
+func toolOutput(cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := runTool(cmd)
+	return out.Bytes(), err
+}
+
+// toolCombinedOutput is cmd.CombinedOutput for tracked tools
+func toolCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := runTool(cmd)
+	return out.Bytes(), err
+}
+
+// stopChildren terminates the process groups of every tool still running,
+// killing those that outlive childGrace
+func stopChildren() {
+	childrenMu.Lock()
+	procs := make([]*os.Process, 0, len(children))
+	for _, p := range children {
+		procs = append(procs, p)
+	}
+	childrenMu.Unlock()
+	if len(procs) == 0 {
+		return
+	}
+
+	for _, p := range procs {
+		terminateGroup(p)
+	}
+	deadline := time.Now().Add(childGrace)
+	for {
+		alive := procs[:0]
+		for _, p := range procs {
+			if groupAlive(p) {
+				alive = append(alive, p)
+			}
+		}
+		procs = alive
+		if len(procs) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, p := range procs {
+		killGroup(p)
+	}
+}
+
+// exit stops the child tools, flushes the redacted stderr, releases the run
+// locks and exits. Every exit of a scan goes through it rather than os.Exit,
+// so no tool keeps scanning after the engine is gone and no lock outlives
+// the run.
+func exit(code int) {
+	stopChildren()
+	otelShutdown(code)
+	flushStderr()
+	releaseRunLocks()
+	os.Exit(code)
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateGroup signals the tool's group, whose ID is the tool's PID
+func terminateGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGTERM)
+}
+
+func killGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// groupAlive reports whether any process of the group is left. A leader
+// that exited but was not waited for yet still counts.
+func groupAlive(p *os.Process) bool {
+	return syscall.Kill(-p.Pid, 0) == nil
+}
@@ -0,0 +1,131 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processGone reports whether pid has exited. An orphan nobody reaped yet
+// is a zombie and counts as gone.
+func processGone(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return true
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name
+	s := string(stat)
+	i := strings.LastIndexByte(s, ')')
+	return i >= 0 && i+2 < len(s) && s[i+2] == 'Z'
+}
+
+func waitGone(t *testing.T, pid int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("process %d outlived its tool", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// grandchildTool is a tool that starts a child of its own, writes the
+// child's PID to a file and waits for it
+func grandchildTool(t *testing.T) (script, pidFile string) {
+	pidFile = filepath.Join(t.TempDir(), "pid")
+	return "sleep 60 & echo $! > " + pidFile + "; wait", pidFile
+}
+
+func readPID(t *testing.T, pidFile string) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := os.ReadFile(pidFile)
+		if pid, perr := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && perr == nil {
+			return pid
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the tool never started its child")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestToolCommandOwnGroup runs the tool in a process group of its own, so
+// a terminal's Ctrl+C only reaches the engine
+func TestToolCommandOwnGroup(t *testing.T) {
+	fakeTool(t, "nmap", "sleep 60")
+	cmd := toolCommand(context.Background(), toolPath("nmap"))
+	if err := startTool(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		killGroup(cmd.Process)
+		waitTool(cmd)
+	}()
+	pgid, err := syscall.Getpgid(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pgid != cmd.Process.Pid || pgid == syscall.Getpgrp() {
+		t.Errorf("tool in group %d, engine in %d", pgid, syscall.Getpgrp())
+	}
+}
+
+// TestToolCommandCancelKillsGroup cancels a tool's context: the tool and
+// the child it started both go
+func TestToolCommandCancelKillsGroup(t *testing.T) {
+	script, pidFile := grandchildTool(t)
+	fakeTool(t, "nmap", script)
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := toolCommand(ctx, toolPath("nmap"))
+	done := make(chan error, 1)
+	go func() { done <- runTool(cmd) }()
+	child := readPID(t, pidFile)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("runTool did not return after cancel")
+	}
+	waitGone(t, child)
+}
+
+// TestStopChildren stops every tracked tool and its children, as every
+// exit path does
+func TestStopChildren(t *testing.T) {
+	script, pidFile := grandchildTool(t)
+	fakeTool(t, "nmap", script)
+	cmd := toolCommand(context.Background(), toolPath("nmap"))
+	if err := startTool(cmd); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- waitTool(cmd) }()
+	child := readPID(t, pidFile)
+
+	stopChildren()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the tool survived stopChildren")
+	}
+	waitGone(t, child)
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+	if _, ok := children[cmd.Process.Pid]; ok {
+		t.Error("a waited tool is still tracked")
+	}
+}
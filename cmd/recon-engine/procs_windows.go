@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// Windows has no SIGTERM to send, so tools are killed straight away
+
+func terminateGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func groupAlive(p *os.Process) bool {
+	return false
+}
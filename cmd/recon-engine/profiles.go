@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// builtinProfiles are the -profile presets: flag name -> value, applied as
+// if given on the command line before it. standard is the defaults.
+var builtinProfiles = map[string]map[string]string{
+	"quick": {
+		"deep":             "false",
+		"fingerprint":      "false",
+		"brute":            "false",
+		"follow-redirects": "false",
+	},
+	"standard": {},
+	"thorough": {
+		"deep":           "true",
+		"fingerprint":    "true",
+		"brute":          "true",
+		"permute":        "true",
+		"recursive":      "true",
+		"dirbrute":       "true",
+		"params":         "true",
+		"soft404":        "true",
+		"robots":         "true",
+		"security-txt":   "true",
+		"header-audit":   "true",
+		"cookie-audit":   "true",
+		"cors-check":     "true",
+		"redirect-check": "true",
+		"cve-lookup":     "true",
+		"jarm":           "true",
+		"dns-audit":      "true",
+		"mail-check":     "true",
+	},
+}
+
+// profileFlags are the flags a profile cannot set
+var profileFlags = map[string]bool{"profile": true, "profile-show": true, "config": true}
+
+// configFile is the -config file: profiles, the tags every result of the
+// run carries and the external API budgets
+type configFile struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+	Tags     map[string]string                 `yaml:"tags"`
+	Budgets  map[string]int                    `yaml:"budgets"`
+}
+
+// profileSetting is one flag a profile sets, for -profile-show
+type profileSetting struct {
+	name, value string
+	explicit    bool   // given on the command line, which wins
+	cmdline     string // its value there
+}
+
+// applyProfile sets the flags of -profile that the command line left
+// alone. A flag given explicitly wins, with a note when it disagrees with
+// the profile. Called right after flag parsing; with -profile-show it
+// prints the expansion and exits.
+func applyProfile() error {
+	profiles, origin, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if profileName == "" {
+		if profileShow {
+			return fmt.Errorf("-profile-show needs -profile")
+		}
+		return nil
+	}
+	profile, ok := profiles[profileName]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown -profile %q (want %s)", profileName, strings.Join(names, ", "))
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var settings []profileSetting
+	for _, name := range sortedKeys(profile) {
+		value := profile[name]
+		s := profileSetting{name: name, value: value}
+		if explicit[name] {
+			s.explicit, s.cmdline = true, flag.Lookup(name).Value.String()
+			if s.cmdline != value {
+				fmt.Fprintf(os.Stderr, "Info: -%s=%s from the command line overrides -profile %s's %s\n", name, redactSecrets(s.cmdline), profileName, redactSecrets(value))
+			}
+		} else if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("profile %s: -%s: %w", profileName, name, err)
+		}
+		settings = append(settings, s)
+	}
+	if profileShow {
+		writeProfile(os.Stdout, profileName, origin[profileName], settings)
+		exit(0)
+	}
+	return nil
+}
+
+// loadProfiles returns the built-in profiles with the -config file's on
+// top, and where each came from. A config profile named like a built-in
+// one replaces it. The file's tags are kept for configureTags and its
+// budgets for configureAPIBudgets.
+func loadProfiles() (map[string]map[string]string, map[string]string, error) {
+	profiles := make(map[string]map[string]string, len(builtinProfiles))
+	origin := make(map[string]string, len(builtinProfiles))
+	for name, p := range builtinProfiles {
+		profiles[name], origin[name] = p, "built in"
+	}
+	path, required := configPath, true
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return profiles, origin, nil
+		}
+		path, required = filepath.Join(home, ".recon-engine", "config.yaml"), false
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return profiles, origin, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var cfg configFile
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range cfg.Profiles {
+		settings, err := profileValues(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+		profiles[name], origin[name] = settings, path
+	}
+	for key := range cfg.Tags {
+		if err := validTagKey(key); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	configTags = cfg.Tags
+	configBudgets = cfg.Budgets
+	return profiles, origin, nil
+}
+
+// profileValues turns a config profile's YAML values into flag values.
+// Keys may carry the leading dash and lists become comma-separated.
+func profileValues(p map[string]interface{}) (map[string]string, error) {
+	out := make(map[string]string, len(p))
+	for key, v := range p {
+		name := strings.TrimLeft(key, "-")
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag -%s", name)
+		}
+		if profileFlags[name] {
+			return nil, fmt.Errorf("-%s cannot be set by a profile", name)
+		}
+		switch v := v.(type) {
+		case nil:
+			return nil, fmt.Errorf("-%s has no value", name)
+		case []interface{}:
+			parts := make([]string, len(v))
+			for i, e := range v {
+				parts[i] = fmt.Sprint(e)
+			}
+			out[name] = strings.Join(parts, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("-%s takes a single value or a list", name)
+		default:
+			out[name] = fmt.Sprint(v)
+		}
+	}
+	return out, nil
+}
+
+// writeProfile prints what -profile expands to
+func writeProfile(w io.Writer, name, origin string, settings []profileSetting) {
+	fmt.Fprintf(w, "Profile %s (%s):\n", name, origin)
+	if len(settings) == 0 {
+		fmt.Fprintln(w, "  (the defaults)")
+	}
+	for _, s := range settings {
+		if s.explicit && s.cmdline != s.value {
+			fmt.Fprintf(w, "  -%s=%s (command line; the profile has %s)\n", s.name, redactSecrets(s.cmdline), redactSecrets(s.value))
+			continue
+		}
+		fmt.Fprintf(w, "  -%s=%s\n", s.name, redactSecrets(s.value))
+	}
+}
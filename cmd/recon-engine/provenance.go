@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	// nameSources holds every discovery stage that reported each name, so
+	// a name three passive sources agree on can be told apart from a
+	// brute-force guess
+	// key: subdomain
+	nameSources   = make(map[string]map[string]bool)
+	nameSourcesMu sync.Mutex
+)
+
+// recordDiscovery notes that source reported name. Names are recorded
+// before deduplication so every source that found a name is kept.
+func recordDiscovery(name, source string) {
+	recordDiscoveredAt(name)
+	nameSourcesMu.Lock()
+	defer nameSourcesMu.Unlock()
+	set, ok := nameSources[name]
+	if !ok {
+		set = make(map[string]bool)
+		nameSources[name] = set
+	}
+	if !set[source] {
+		set[source] = true
+		stats.Add("sources."+source, 1)
+	}
+}
+
+// discoverySources returns the stages that reported name, sorted
+func discoverySources(name string) []string {
+	nameSourcesMu.Lock()
+	defer nameSourcesMu.Unlock()
+	set := nameSources[name]
+	if len(set) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(set))
+	for s := range set {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// legacySource is the single Result.Source value earlier releases emitted
+// for a discovered name; only set with -legacy-source
+func legacySource(name string) string {
+	if !legacySourceFlag {
+		return ""
+	}
+	if _, ok := ptrNames.Load(name); ok {
+		return "ptr"
+	}
+	if _, ok := permutedNames.Load(name); ok {
+		return "permutation"
+	}
+	return "recon_pipeline"
+}
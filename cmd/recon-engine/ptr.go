@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ptrNames records names that were found by the reverse DNS sweep
+var ptrNames sync.Map
+
+// ptrLookup is one cached reverse lookup; once makes concurrent callers for
+// the same IP share a single query
+type ptrLookup struct {
+	once sync.Once
+	name string
+}
+
+var (
+	// ptrCache is shared by the sweep and the enrichers so an IP is looked
+	// up once per process
+	// key: IP
+	ptrCache   = make(map[string]*ptrLookup)
+	ptrCacheMu sync.Mutex
+
+	// ptrOutOfScope holds PTR names outside the target, for the run summary
+	ptrOutOfScope   = make(map[string]bool)
+	ptrOutOfScopeMu sync.Mutex
+)
+
+// lookupPTR returns the first PTR name of ip, or "" when it has none
+func lookupPTR(ctx context.Context, ip string) string {
+	ptrCacheMu.Lock()
+	l, ok := ptrCache[ip]
+	if !ok {
+		l = &ptrLookup{}
+		ptrCache[ip] = l
+	}
+	ptrCacheMu.Unlock()
+	l.once.Do(func() {
+		names, err := dnsResolver.LookupAddr(ctx, ip)
+		if err == nil && len(names) > 0 {
+			l.name = strings.ToLower(strings.TrimSuffix(names[0], "."))
+		}
+	})
+	return l.name
+}
+
+// runPTRSweep resolves names, reverse-resolves their unique addresses and
+// sends PTR names under domain's registrable domain to out. It runs once over
+// what discovery found; the names it yields are not swept again, so a zone
+// whose PTRs point at each other cannot keep the stage going.
+func runPTRSweep(ctx context.Context, domain string, names []string, out chan<- string) {
+	ips := resolveIPs(ctx, names)
+	stats.Add("ptr.ips", int64(len(ips)))
+
+	var (
+		mu    sync.Mutex
+		found []string
+		wg    sync.WaitGroup
+	)
+	work := make(chan string)
+	for i := 0; i < max(bruteConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range work {
+				if name := lookupPTR(ctx, ip); name != "" {
+					mu.Lock()
+					found = append(found, name)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, ip := range ips {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- ip
+	}
+	close(work)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, name := range found {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if !sameRegistrableDomain(name, domain) {
+			ptrOutOfScopeMu.Lock()
+			ptrOutOfScope[name] = true
+			ptrOutOfScopeMu.Unlock()
+			continue
+		}
+		stats.Add("ptr.in_scope", 1)
+		ptrNames.Store(name, true)
+		out <- name
+	}
+}
+
+// resolveIPs returns the unique addresses names resolve to, of the
+// -ip-version families. Names a zone transfer already resolved are not
+// looked up again.
+func resolveIPs(ctx context.Context, names []string) []string {
+	var (
+		mu  sync.Mutex
+		ips = make(map[string]bool)
+		wg  sync.WaitGroup
+	)
+	add := func(addrs []string) {
+		mu.Lock()
+		for _, a := range addrs {
+			if ipAllowed(a) {
+				ips[a] = true
+			}
+		}
+		mu.Unlock()
+	}
+	work := make(chan string)
+	for i := 0; i < max(bruteConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				if rec, ok := lookupDNSRecord(name); ok && len(rec.addrs()) > 0 {
+					add(rec.addrs())
+					continue
+				}
+				if addrs, err := dnsResolver.LookupHost(ctx, name); err == nil {
+					add(addrs)
+				}
+			}
+		}()
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+
+	out := make([]string, 0, len(ips))
+	for ip := range ips {
+		out = append(out, ip)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ptrOutOfScopeNames lists the out-of-scope PTR names seen this run
+func ptrOutOfScopeNames() []string {
+	ptrOutOfScopeMu.Lock()
+	defer ptrOutOfScopeMu.Unlock()
+	names := make([]string, 0, len(ptrOutOfScope))
+	for name := range ptrOutOfScope {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
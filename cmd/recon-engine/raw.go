@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rawRecord is a line of raw/<tool>.jsonl.gz: a line or blob of the tool's
+// output as it wrote it, with the root domain it ran for and, for WhatWeb,
+// the URL it fingerprinted
+type rawRecord struct {
+	Target string `json:"target"`
+	URL    string `json:"url,omitempty"`
+	At     string `json:"at"`
+	Raw    string `json:"raw"`
+}
+
+// rawRun is raw/run.json, what reprocess needs of the run besides its
+// records
+type rawRun struct {
+	RunID         string `json:"run_id"`
+	EngineVersion string `json:"engine_version"`
+	StartedAt     string `json:"started_at"`
+	ProbeEngine   string `json:"probe_engine"`
+	// FingerprintAll is -fingerprint-all, without which hosts serving the
+	// same application share the WhatWeb output of the first
+	FingerprintAll bool `json:"fingerprint_all,omitempty"`
+}
+
+// rawSummary is what -keep-raw stored, for the run summary
+type rawSummary struct {
+	Dir     string         `json:"dir"`
+	Records map[string]int `json:"records"`
+	// Bytes is the tools' output as written, StoredBytes what it takes
+	// compressed on disk
+	Bytes       int64 `json:"bytes"`
+	StoredBytes int64 `json:"stored_bytes"`
+	// Dropped counts the records past -keep-raw-max, which were not kept
+	Dropped int `json:"dropped,omitempty"`
+}
+
+type rawFile struct {
+	f       *os.File
+	enc     *encryptWriter // with -encrypt-output
+	gz      *gzip.Writer
+	w       *json.Encoder
+	records int
+	err     error // the write error that stopped the file
+}
+
+// rawKeeper writes the -keep-raw records of the run, one gzip file per tool
+type rawKeeper struct {
+	mu      sync.Mutex
+	dir     string
+	files   map[string]*rawFile
+	bytes   int64
+	dropped int
+}
+
+// rawStore is the -keep-raw store, nil without the flag
+var rawStore *rawKeeper
+
+// amassRawRefs holds the record each name amass reported first came from
+var amassRawRefs sync.Map // name -> ref
+
+// configureKeepRaw checks the -keep-raw flags. Called once after flag
+// parsing.
+func configureKeepRaw() error {
+	if !keepRawFlag {
+		return nil
+	}
+	if keepRawMax <= 0 {
+		return fmt.Errorf("-keep-raw-max must be positive, got %d", keepRawMax)
+	}
+	if !keepArtifacts {
+		return fmt.Errorf("-keep-raw stores into the -workdir, which -keep-artifacts=false deletes")
+	}
+	return nil
+}
+
+// openRawStore creates <workdir>/raw with the run's run.json. A run
+// replaces the raw records an earlier run left in the same -workdir.
+func openRawStore() error {
+	if !keepRawFlag {
+		return nil
+	}
+	dir := filepath.Join(workdir, "raw")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rawRun{RunID: runID, EngineVersion: version, StartedAt: stats.start.Format(time.RFC3339), ProbeEngine: probeEngine, FingerprintAll: fingerprintAll})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if encryptKey != nil {
+		if b, err = encryptBytes(b); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "run.json"), b); err != nil {
+		return err
+	}
+	rawStore = &rawKeeper{dir: dir, files: make(map[string]*rawFile)}
+	return nil
+}
+
+// keepRaw stores a line or blob of tool's output for -keep-raw and returns
+// its reference, "" when nothing is kept
+func keepRaw(tool, target, url string, data []byte) string {
+	if rawStore == nil || len(data) == 0 {
+		return ""
+	}
+	return rawStore.keep(tool, rawRecord{Target: target, URL: url, At: time.Now().Format(time.RFC3339), Raw: string(data)})
+}
+
+func (k *rawKeeper) keep(tool string, rec rawRecord) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.dropped > 0 || k.bytes+int64(len(rec.Raw)) > keepRawMax {
+		if k.dropped == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: -keep-raw-max %d bytes reached, no longer keeping raw tool output\n", keepRawMax)
+		}
+		k.dropped++
+		stats.Add("raw.dropped", 1)
+		return ""
+	}
+	rf, ok := k.files[tool]
+	if !ok {
+		rf = &rawFile{}
+		k.files[tool] = rf
+		if rf.f, rf.err = os.Create(filepath.Join(k.dir, tool+".jsonl.gz")); rf.err == nil {
+			var w io.Writer = rf.f
+			if encryptKey != nil {
+				// Compressed before it is encrypted
+				if rf.enc, rf.err = newEncryptWriter(rf.f); rf.err != nil {
+					k.failed(tool, rf.err)
+					return ""
+				}
+				w = rf.enc
+			}
+			rf.gz = gzip.NewWriter(w)
+			rf.w = json.NewEncoder(rf.gz)
+			// HTML in titles and bodies stays readable in the records
+			rf.w.SetEscapeHTML(false)
+		} else {
+			k.failed(tool, rf.err)
+		}
+	}
+	if rf.err != nil {
+		return ""
+	}
+	if err := rf.w.Encode(rec); err != nil {
+		rf.err = err
+		k.failed(tool, err)
+		return ""
+	}
+	rf.records++
+	k.bytes += int64(len(rec.Raw))
+	stats.Add("raw.records", 1)
+	return rawRef(tool, rf.records)
+}
+
+// failed reports the write error that stops tool's raw file; later records
+// of the tool are not kept
+func (k *rawKeeper) failed(tool string, err error) {
+	fmt.Fprintf(os.Stderr, "Error keeping raw %s output, no longer keeping it: %v\n", tool, err)
+	stats.Add("raw.errors", 1)
+}
+
+// closeRawStore flushes the -keep-raw files and puts what they hold in the
+// run summary
+func closeRawStore() {
+	if rawStore == nil {
+		return
+	}
+	k := rawStore
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s := &rawSummary{Dir: k.dir, Records: make(map[string]int), Bytes: k.bytes, Dropped: k.dropped}
+	for tool, rf := range k.files {
+		s.Records[tool] = rf.records
+		if rf.f == nil {
+			continue
+		}
+		if err := rf.gz.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error keeping raw %s output: %v\n", tool, err)
+		}
+		if rf.enc != nil {
+			if err := rf.enc.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error keeping raw %s output: %v\n", tool, err)
+			}
+		}
+		if err := rf.f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error keeping raw %s output: %v\n", tool, err)
+		}
+		if fi, err := os.Stat(rf.f.Name()); err == nil {
+			s.StoredBytes += fi.Size()
+		}
+	}
+	rawStore = nil
+	summary.mu.Lock()
+	summary.Raw = s
+	summary.mu.Unlock()
+}
+
+// rawRef names record n (from 1) of tool's raw file, relative to the
+// -workdir
+func rawRef(tool string, n int) string {
+	return "raw/" + tool + ".jsonl.gz:" + strconv.Itoa(n)
+}
+
+// joinRawRefs joins the non-empty references, space-separated
+func joinRawRefs(refs ...string) string {
+	var parts []string
+	for _, r := range refs {
+		if r != "" {
+			parts = append(parts, r)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// noteAmassRawRef records that the amass record ref reported name, unless
+// an earlier one did
+func noteAmassRawRef(name, ref string) {
+	if name != "" && ref != "" {
+		amassRawRefs.LoadOrStore(name, ref)
+	}
+}
+
+// amassRawRef returns the amass record that first reported name, if kept
+func amassRawRef(name string) string {
+	if ref, ok := amassRawRefs.Load(name); ok {
+		return ref.(string)
+	}
+	return ""
+}
+
+// readRawRecords calls fn with each record of tool's raw file in dir and
+// its reference. A missing file holds no records; a file cut short by a
+// run that was killed yields the records before the cut. Files written with
+// -encrypt-output are decrypted.
+func readRawRecords(dir, tool string, fn func(rawRecord, string)) error {
+	f, err := os.Open(filepath.Join(dir, tool+".jsonl.gz"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(openDecrypted(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", tool, err)
+	}
+	r := bufio.NewReader(gz)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && err == nil {
+			var rec rawRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return fmt.Errorf("%s: %w", rawRef(tool, n), err)
+			}
+			fn(rec, rawRef(tool, n))
+		}
+		switch {
+		case err == io.EOF:
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			fmt.Fprintf(os.Stderr, "Warning: raw %s output ends early after %d records\n", tool, n-1)
+			return nil
+		case err != nil:
+			return fmt.Errorf("%s: %w", tool, err)
+		}
+	}
+}
+
+// runReprocess implements the reprocess subcommand
+func runReprocess(args []string) {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	fs.StringVar(&rulesPath, "rules", "", "YAML file of flag, block and environment rules, as for scan")
+	fs.StringVar(&scoreWeightsPath, "score-weights", "", "YAML file of interest score weights, as for scan")
+	fs.StringVar(&scoreKeywords, "score-keywords", "", "Comma-separated hostname keywords to weigh, as for scan")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s reprocess [flags] <artifact-dir>\n\nRebuilds the results of a -keep-raw run from the raw tool output kept in\nits -workdir and writes them as NDJSON to stdout, in the current result\nschema and without touching the network. The httpx and amass records are\nparsed again and WhatWeb's output merged in; then the environment and\nflag rules run and the interest score is computed. What the other\nenrichers found is not in the raw records and is left out, and so are\nthe discovery sources other than amass.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := configureRules(); err != nil {
+		startupError("Invalid -rules", err)
+	}
+	if err := configureScore(); err != nil {
+		startupError("Invalid -score-weights", err)
+	}
+	dir := filepath.Join(fs.Arg(0), "raw")
+	data, err := os.ReadFile(filepath.Join(dir, "run.json"))
+	if err != nil {
+		fatalError("Not a -keep-raw artifact directory", err)
+	}
+	if data, err = decryptBytes(data); err != nil {
+		fatalError("Failed to decrypt run.json", err)
+	}
+	var run rawRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		fatalError("Failed to read run.json", err)
+	}
+	runID, probeEngine, fingerprintAll = run.RunID, run.ProbeEngine, run.FingerprintAll
+
+	enc := json.NewEncoder(os.Stdout)
+	n, err := reprocessRaw(dir, func(res Result) {
+		if err := enc.Encode(res); err != nil {
+			fatalError("Failed to write results", err)
+		}
+	})
+	if err != nil {
+		fatalError("Failed to reprocess", err)
+	}
+	fmt.Fprintf(os.Stderr, "Reprocessed %d results of run %s (engine %s, now %s)\n", n, run.RunID, run.EngineVersion, version)
+}
+
+// reprocessRaw rebuilds the results of the raw records in dir, passing
+// each to emit, and returns how many there were
+func reprocessRaw(dir string, emit func(Result)) (int, error) {
+	// Amass, per root domain: names and their infrastructure
+	amassRefs := make(map[string]map[string]string)
+	graphs := make(map[string]*amassGraph)
+	out := make(chan string, 1)
+	err := readRawRecords(dir, "amass", func(rec rawRecord, ref string) {
+		refs, ok := amassRefs[rec.Target]
+		if !ok {
+			refs = make(map[string]string)
+			amassRefs[rec.Target] = refs
+		}
+		note := func(name string) {
+			recordDiscovery(name, "amass")
+			if _, ok := refs[name]; !ok {
+				refs[name] = ref
+			}
+		}
+		if strings.HasPrefix(strings.TrimSpace(rec.Raw), "{") {
+			if name := handleAmassJSON([]byte(rec.Raw), out); name != "" {
+				<-out
+				note(name)
+			}
+			return
+		}
+		g, ok := graphs[rec.Target]
+		if !ok {
+			g = newAmassGraph()
+			graphs[rec.Target] = g
+		}
+		g.handle(rec.Raw, func(name string) {
+			if name, ok := amassName(name, rec.Target); ok {
+				note(name)
+			}
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// WhatWeb, per URL; the first output of each is the one the run used
+	type wwRecord struct {
+		results []WhatWebResult
+		ref     string
+	}
+	whatweb := make(map[string]wwRecord)
+	err = readRawRecords(dir, "whatweb", func(rec rawRecord, ref string) {
+		key := rec.Target + " " + rec.URL
+		if _, ok := whatweb[key]; ok {
+			return
+		}
+		results, _ := parseWhatWeb(rec.URL, []byte(rec.Raw))
+		whatweb[key] = wwRecord{results, ref}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// httpx, with the dedup of the pipeline; hosts WhatWeb was not run on
+	// inherit the output of their group
+	probed := make(map[string]bool)
+	groups := make(map[string][]WhatWebResult)
+	n := 0
+	err = readRawRecords(dir, "httpx", func(rec rawRecord, ref string) {
+		hRes, ok := parseHttpxLine([]byte(rec.Raw))
+		if !ok {
+			return
+		}
+		probeKey := rec.Target + " " + hRes.Input + ":" + strconv.Itoa(urlPort(hRes.Url))
+		if probed[probeKey] {
+			return
+		}
+		probed[probeKey] = true
+
+		res := httpxResult(hRes, rec.Target)
+		res.Timestamp = rec.At
+		res.RawRef = joinRawRefs(ref, amassRefs[rec.Target][hRes.Input])
+		key := whatwebGroupKey(&res)
+		if ww, ok := whatweb[rec.Target+" "+res.URL]; ok {
+			if len(ww.results) > 0 {
+				mergeWhatWeb(&res, ww.results)
+				if _, ok := groups[key]; !ok && key != "" {
+					groups[key] = ww.results
+				}
+			}
+			res.RawRef = joinRawRefs(res.RawRef, ww.ref)
+		} else if results, ok := groups[key]; ok {
+			mergeWhatWeb(&res, results)
+			res.FingerprintInherited = true
+		}
+
+		classifyEnvironment(&res)
+		applyFlagRules(&res)
+		res.headers = nil
+		if !res.Blocked {
+			res.InterestScore = interestScore(res)
+		}
+		n++
+		emit(res)
+	})
+	return n, err
+}
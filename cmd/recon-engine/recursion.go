@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// recursionParents returns the intermediate domains between each name and
+// target, such as internal.example.com for dev.internal.example.com, that
+// have not been queried yet. Names directly under target have none.
+func recursionParents(names []string, target string, queried map[string]bool) []string {
+	var parents []string
+	for _, name := range names {
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok || parent == target || !strings.HasSuffix(parent, "."+target) || queried[parent] {
+			continue
+		}
+		queried[parent] = true
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+	return parents
+}
+
+// runRecursion runs the discovery sources again with intermediate domains
+// as the parent, for up to -recursion-depth levels. Each level only looks
+// at the names the previous one found for the first time, and each parent
+// is queried once, so the recursion ends even on wildcard-heavy zones.
+// feed reports whether a name was new.
+func runRecursion(ctx context.Context, target string, sources []string, found []string, feed func(string) bool) {
+	queried := map[string]bool{target: true}
+	for level := 1; level <= recursionDepth && ctx.Err() == nil; level++ {
+		parents := recursionParents(found, target, queried)
+		if len(parents) == 0 {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Recursion level %d: querying %d parent domains\n", level, len(parents))
+		found = nil
+		// Parents are queried one at a time so the API sources' rate
+		// limits see one domain at a time, as in the first wave
+		for _, parent := range parents {
+			if ctx.Err() != nil {
+				break
+			}
+			names := make(chan string, 1000)
+			var wg sync.WaitGroup
+			for _, name := range sources {
+				startSource(ctx, &wg, name, parent, names)
+			}
+			go func() {
+				wg.Wait()
+				close(names)
+			}()
+			for sub := range names {
+				if feed(sub) {
+					found = append(found, sub)
+				}
+			}
+		}
+		stats.Add(fmt.Sprintf("recursion.level%d", level), int64(len(found)))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxRedirectHops caps how many Location headers are followed per host
+const maxRedirectHops = 10
+
+// redirectHTTP never follows redirects itself so each hop can be recorded
+var redirectHTTP = func() *http.Client {
+	c := newHTTPClient(10*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// followRedirects walks the Location chain starting at start and returns the
+// final URL and every URL visited after start. Relative Locations are
+// resolved against the current URL, and the walk stops at a loop or after
+// maxRedirectHops.
+func followRedirects(ctx context.Context, start string) (string, []string) {
+	current, err := url.Parse(start)
+	if err != nil {
+		return "", nil
+	}
+	visited := map[string]bool{current.String(): true}
+	var chain []string
+
+	for hop := 0; hop < maxRedirectHops; hop++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, current.String(), nil)
+		if err != nil {
+			break
+		}
+		resp, err := redirectHTTP.Do(req)
+		if err != nil {
+			break
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+
+		loc := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || loc == "" {
+			break
+		}
+		next, err := current.Parse(loc)
+		if err != nil {
+			break
+		}
+		if visited[next.String()] {
+			// Redirect loop: record the hop that closes it and stop
+			chain = append(chain, next.String())
+			break
+		}
+		visited[next.String()] = true
+		chain = append(chain, next.String())
+		current = next
+		// The hop is recorded but never requested
+		if !scopeAllowsHost(next.Hostname()) {
+			break
+		}
+	}
+	return current.String(), chain
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestFollowRedirectsRelative(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Location", "/a/")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case "/a/":
+			// Relative to /a/, not to the root
+			w.Header().Set("Location", "b?x=1")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Write([]byte("done"))
+		}
+	}))
+	defer srv.Close()
+
+	final, chain := followRedirects(context.Background(), srv.URL+"/")
+	if want := srv.URL + "/a/b?x=1"; final != want {
+		t.Errorf("final URL %q, want %q", final, want)
+	}
+	want := []string{srv.URL + "/a/", srv.URL + "/a/b?x=1"}
+	if len(chain) != len(want) || chain[0] != want[0] || chain[1] != want[1] {
+		t.Errorf("chain %v, want %v", chain, want)
+	}
+}
+
+func TestFollowRedirectsLoop(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		next := "/b"
+		if r.URL.Path == "/b" {
+			next = "/a"
+		}
+		w.Header().Set("Location", next)
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+
+	final, chain := followRedirects(context.Background(), srv.URL+"/a")
+	if final != srv.URL+"/b" {
+		t.Errorf("final URL %q, want the last URL before the loop closed", final)
+	}
+	// The hop back to /a closes the loop and is recorded, not requested
+	if len(chain) != 2 || chain[1] != srv.URL+"/a" {
+		t.Errorf("chain %v", chain)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want 2", requests)
+	}
+}
+
+func TestFollowRedirectsHopCap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		w.Header().Set("Location", "/?n="+strconv.Itoa(n+1))
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, chain := followRedirects(context.Background(), srv.URL+"/?n=0")
+	if len(chain) != maxRedirectHops {
+		t.Errorf("%d hops recorded, want %d", len(chain), maxRedirectHops)
+	}
+}
+
+func TestInTargetOffScope(t *testing.T) {
+	for _, c := range []struct {
+		host, target string
+		want         bool
+	}{
+		{"www.example.com", "example.com", true},
+		{"example.com", "shop.example.com", true},
+		{"marketing.example.net", "example.com", false},
+		{"10.0.0.7", "10.0.0.0/24", true},
+		{"10.0.1.7", "10.0.0.0/24", false},
+		{"app.example.com", "https://app.example.com:8443/login", true},
+	} {
+		if got := inTarget(c.host, c.target); got != c.want {
+			t.Errorf("inTarget(%q, %q) = %v, want %v", c.host, c.target, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisBuffer bounds the records waiting for the writer goroutine;
+	// publishing blocks once it is full
+	redisBuffer = 1000
+	// redisAttempts is how often a record is tried before it is spooled
+	redisAttempts = 6
+)
+
+var (
+	redisClient *redis.Client
+	redisQueue  chan redisRecord
+	redisDone   sync.WaitGroup
+)
+
+type redisRecord struct {
+	subdomain string
+	body      []byte
+}
+
+// configureRedis validates the -redis-* flags and checks that the server
+// answers before the scan starts. Called once after flag parsing.
+func configureRedis() error {
+	if redisURL == "" {
+		return nil
+	}
+	switch redisMode {
+	case "list", "stream":
+	default:
+		return fmt.Errorf("-redis-mode: want list or stream, got %q", redisMode)
+	}
+	if redisKey == "" {
+		return fmt.Errorf("-redis-key is required with -redis-url")
+	}
+	if redisTTL < 0 || (redisTTL > 0 && redisMode != "stream") {
+		return fmt.Errorf("-redis-ttl needs -redis-mode stream and a positive duration")
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return err
+	}
+	// The client pools connections and redials broken ones; the retries
+	// below add backoff across longer outages
+	opts.MaxRetries = -1
+	redisClient = redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		redisClient.Close()
+		redisClient = nil
+		return err
+	}
+
+	redisQueue = make(chan redisRecord, redisBuffer)
+	redisDone.Add(1)
+	go redisWriter()
+	return nil
+}
+
+// publishRedis queues res for -redis-key. Delivery failures are reported
+// by redisWriter.
+func publishRedis(res Result) error {
+	b, err := json.Marshal(projectResult(res))
+	if err != nil {
+		return err
+	}
+	redisQueue <- redisRecord{subdomain: res.Subdomain, body: b}
+	return nil
+}
+
+// redisWriter delivers queued records in order. A record that still fails
+// after redisAttempts tries, backing off between them, goes to -redis-spool.
+func redisWriter() {
+	defer redisDone.Done()
+	for rec := range redisQueue {
+		var err error
+		backoff := 200 * time.Millisecond
+		for attempt := 1; attempt <= redisAttempts; attempt++ {
+			if err = pushRedis(rec); err == nil {
+				break
+			}
+			if attempt < redisAttempts {
+				time.Sleep(backoff)
+				backoff = min(backoff*2, 10*time.Second)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Redis delivery error for %s, spooling to %s: %v\n", rec.subdomain, redisSpool, err)
+			reportToolError("redis", "output", "", err)
+			spoolRedis(rec)
+		}
+	}
+}
+
+func pushRedis(rec redisRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if redisMode == "list" {
+		return redisClient.LPush(ctx, redisKey, rec.body).Err()
+	}
+	args := &redis.XAddArgs{
+		Stream: redisKey,
+		Values: map[string]interface{}{"subdomain": rec.subdomain, "result": rec.body},
+	}
+	if redisTTL > 0 {
+		// Stream IDs start with a millisecond timestamp, so trimming by
+		// MINID drops entries older than the TTL
+		args.MinID = strconv.FormatInt(time.Now().Add(-redisTTL).UnixMilli(), 10)
+		args.Approx = true
+	}
+	return redisClient.XAdd(ctx, args).Err()
+}
+
+// spoolRedis appends an undeliverable record to -redis-spool as NDJSON
+func spoolRedis(rec redisRecord) {
+	f, err := os.OpenFile(redisSpool, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -redis-spool, record for %s lost: %v\n", rec.subdomain, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(rec.body, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing -redis-spool, record for %s lost: %v\n", rec.subdomain, err)
+	}
+}
+
+// closeRedis waits for queued records to be delivered or spooled
+func closeRedis() {
+	if redisClient == nil {
+		return
+	}
+	close(redisQueue)
+	redisDone.Wait()
+	redisClient.Close()
+	redisClient = nil
+}
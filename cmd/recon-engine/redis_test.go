@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis speaks enough RESP for the -redis-url writer: PING, LPUSH and
+// XADD, recording the pushes. Anything else, HELLO included, is an unknown
+// command, which sends the client back to RESP2.
+type fakeRedis struct {
+	ln     net.Listener
+	fail   bool
+	mu     sync.Mutex
+	pushes [][]string
+}
+
+func newFakeRedis(t *testing.T, fail bool) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, fail: fail}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		reply := "-ERR unknown command\r\n"
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "LPUSH", "XADD":
+			if f.fail {
+				reply = "-ERR fake outage\r\n"
+				break
+			}
+			f.mu.Lock()
+			f.pushes = append(f.pushes, args)
+			f.mu.Unlock()
+			reply = ":1\r\n"
+			if args[0] == "XADD" {
+				reply = "$3\r\n1-0\r\n"
+			}
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRESP reads one command, an array of bulk strings
+func readRESP(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("not an array: %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func setRedisFlags(t *testing.T, url, mode string) {
+	oldURL, oldMode, oldKey, oldSpool := redisURL, redisMode, redisKey, redisSpool
+	t.Cleanup(func() { redisURL, redisMode, redisKey, redisSpool = oldURL, oldMode, oldKey, oldSpool })
+	redisURL, redisMode, redisKey = url, mode, "recon:results"
+	redisSpool = filepath.Join(t.TempDir(), "redis-spool.ndjson")
+}
+
+// TestRedisOutputOrder pushes results in the order they were published,
+// in both modes
+func TestRedisOutputOrder(t *testing.T) {
+	for _, mode := range []string{"list", "stream"} {
+		t.Run(mode, func(t *testing.T) {
+			srv := newFakeRedis(t, false)
+			setRedisFlags(t, "redis://"+srv.ln.Addr().String()+"/0", mode)
+			if err := configureRedis(); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{"a.example.com", "b.example.com", "c.example.com"}
+			for _, name := range names {
+				if err := publishRedis(Result{Subdomain: name, RootDomain: "example.com"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			closeRedis()
+
+			if len(srv.pushes) != len(names) {
+				t.Fatalf("%d pushes, want %d", len(srv.pushes), len(names))
+			}
+			for i, args := range srv.pushes {
+				if args[1] != redisKey {
+					t.Errorf("push %d went to %q", i, args[1])
+				}
+				body := args[len(args)-1]
+				if mode == "stream" {
+					// XADD key * field value ..., in map order
+					for j := 3; j+1 < len(args); j += 2 {
+						if args[j] == "result" {
+							body = args[j+1]
+						}
+					}
+				}
+				var res Result
+				if err := json.Unmarshal([]byte(body), &res); err != nil {
+					t.Fatalf("push %d: %v", i, err)
+				}
+				if res.Subdomain != names[i] {
+					t.Errorf("push %d is %s, want %s", i, res.Subdomain, names[i])
+				}
+				if mode == "stream" && !strings.Contains(strings.Join(args, " "), "subdomain "+names[i]) {
+					t.Errorf("stream entry %d has no subdomain field: %v", i, args)
+				}
+			}
+		})
+	}
+}
+
+// TestRedisOutputSpools writes records the server keeps refusing to
+// -redis-spool instead of dropping them. The backoff between attempts
+// makes it slow.
+func TestRedisOutputSpools(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out the delivery backoff")
+	}
+	srv := newFakeRedis(t, true)
+	setRedisFlags(t, "redis://"+srv.ln.Addr().String()+"/0", "list")
+	if err := configureRedis(); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishRedis(Result{Subdomain: "a.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	closeRedis()
+
+	b, err := os.ReadFile(redisSpool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Result
+	if err := json.Unmarshal(b, &res); err != nil || res.Subdomain != "a.example.com" {
+		t.Errorf("spool holds %q (%v)", b, err)
+	}
+}
+
+func TestConfigureRedisFlags(t *testing.T) {
+	for _, c := range []struct {
+		mode, key string
+	}{
+		{"pubsub", "recon:results"},
+		{"list", ""},
+	} {
+		setRedisFlags(t, "redis://127.0.0.1:1/0", c.mode)
+		redisKey = c.key
+		if configureRedis() == nil {
+			t.Errorf("-redis-mode %q -redis-key %q accepted", c.mode, c.key)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment of the tools bench replays. The engine under test only sees
+// them as -<tool>-bin; the replay settings reach them through the
+// environment it passes on.
+const (
+	replayEnv               = "RECON_BENCH_REPLAY" // set to 1 in replayed tools
+	replayScaleEnv          = "RECON_BENCH_SCALE"
+	replaySubfinderRateEnv  = "RECON_BENCH_SUBFINDER_RATE"
+	replayHttpxLatencyEnv   = "RECON_BENCH_HTTPX_LATENCY"
+	replayHttpxThreadsEnv   = "RECON_BENCH_HTTPX_THREADS"
+	replayWhatWebLatencyEnv = "RECON_BENCH_WHATWEB_LATENCY"
+)
+
+// fixtureDomain is the domain the fixtures were recorded against
+const fixtureDomain = "example.com"
+
+// replayedTools are the tools bench stands in for, each reading its
+// fixture from testdata/bench
+var replayedTools = []string{"subfinder", "httpx", "whatweb", "nmap"}
+
+// replayTool reports which tool this process stands in for: bench links the
+// engine's executable under each tool's name
+func replayTool() (string, bool) {
+	if os.Getenv(replayEnv) == "" {
+		return "", false
+	}
+	tool := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	for _, t := range replayedTools {
+		if t == tool {
+			return tool, true
+		}
+	}
+	return "", false
+}
+
+// replayVersions are the banners the replayed tools print for their
+// version command, recent enough for every minimum in toolVersions
+var replayVersions = map[string]string{
+	"subfinder": "[INF] Current Version: v2.6.6",
+	"httpx":     "[INF] Current Version: v1.6.0",
+	"whatweb":   "WhatWeb version 0.5.5",
+	"nmap":      "Nmap version 7.94 ( https://nmap.org )",
+}
+
+// runReplay plays tool's fixture back the way the real tool would answer
+// args, then exits
+func runReplay(tool string, args []string) {
+	if spec := toolVersions[tool]; len(args) > 0 && args[0] == spec.args[0] {
+		fmt.Fprintln(os.Stderr, replayVersions[tool])
+		os.Exit(0)
+	}
+	var err error
+	switch tool {
+	case "subfinder":
+		err = replaySubfinder(args)
+	case "httpx":
+		err = replayHttpx()
+	case "whatweb":
+		err = replayWhatWeb(args)
+	case "nmap":
+		err = replayNmap(args)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s replay: %v\n", tool, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+func envDuration(name string) time.Duration {
+	d, _ := time.ParseDuration(os.Getenv(name))
+	return d
+}
+
+// replayNames returns the fixture's names under domain, repeated
+// RECON_BENCH_SCALE times. Copies after the first get an r<N>. label in
+// front, which fixtureName strips again.
+func replayNames(domain string) ([]string, error) {
+	b, err := benchFixtures.ReadFile("testdata/bench/subfinder.txt")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Fields(string(b)) {
+		names = append(names, strings.TrimSuffix(line, fixtureDomain)+domain)
+	}
+	scale := envInt(replayScaleEnv, 1)
+	out := make([]string, 0, len(names)*scale)
+	for i := 0; i < scale; i++ {
+		for _, n := range names {
+			if i > 0 {
+				n = "r" + strconv.Itoa(i) + "." + n
+			}
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+var scaledLabel = regexp.MustCompile(`^r\d+\.`)
+
+// fixtureName maps a replayed name back to the recorded one
+func fixtureName(name, domain string) string {
+	return strings.TrimSuffix(scaledLabel.ReplaceAllString(name, ""), domain) + fixtureDomain
+}
+
+// replaySubfinder prints the names for -d at RECON_BENCH_SUBFINDER_RATE
+// names per second, or all at once without a rate
+func replaySubfinder(args []string) error {
+	domain := fixtureDomain
+	for i, a := range args {
+		if a == "-d" && i+1 < len(args) {
+			domain = args[i+1]
+		}
+	}
+	names, err := replayNames(domain)
+	if err != nil {
+		return err
+	}
+	var tick *time.Ticker
+	if rate := envInt(replaySubfinderRateEnv, 0); rate > 0 {
+		tick = time.NewTicker(time.Second / time.Duration(rate))
+		defer tick.Stop()
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, n := range names {
+		if tick != nil {
+			w.Flush()
+			<-tick.C
+		}
+		fmt.Fprintln(w, n)
+	}
+	return nil
+}
+
+// replayHttpx answers each name on stdin with its recorded httpx record,
+// or nothing for names that were not live. Every probe takes
+// RECON_BENCH_HTTPX_LATENCY, RECON_BENCH_HTTPX_THREADS (default 50) at a
+// time, as httpx's own threads would.
+func replayHttpx() error {
+	b, err := benchFixtures.ReadFile("testdata/bench/httpx.jsonl")
+	if err != nil {
+		return err
+	}
+	records := make(map[string]map[string]interface{})
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var rec map[string]interface{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		input, _ := rec["input"].(string)
+		records[input] = rec
+	}
+
+	latency := envDuration(replayHttpxLatencyEnv)
+	sem := make(chan struct{}, envInt(replayHttpxThreadsEnv, 50))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	enc := json.NewEncoder(os.Stdout)
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		name := strings.TrimSpace(sc.Text())
+		if name == "" {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			time.Sleep(latency)
+			rec, ok := records[fixtureName(name, rootOf(name))]
+			if !ok {
+				return
+			}
+			out := make(map[string]interface{}, len(rec))
+			for k, v := range rec {
+				out[k] = v
+			}
+			out["input"], out["url"] = name, "https://"+name
+			mu.Lock()
+			enc.Encode(out)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return sc.Err()
+}
+
+// rootOf guesses the scanned domain from a replayed name: the last two
+// labels, which is what the fixtures use
+func rootOf(name string) string {
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return name
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// replayWhatWeb prints the recorded WhatWeb output for the URL, the last
+// argument, after RECON_BENCH_WHATWEB_LATENCY
+func replayWhatWeb(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no target")
+	}
+	b, err := benchFixtures.ReadFile("testdata/bench/whatweb.json")
+	if err != nil {
+		return err
+	}
+	time.Sleep(envDuration(replayWhatWebLatencyEnv))
+	_, err = os.Stdout.Write(bytes.ReplaceAll(b, []byte("https://TARGET"), []byte(args[len(args)-1])))
+	return err
+}
+
+// replayNmap writes the recorded report to the -oN file
+func replayNmap(args []string) error {
+	b, err := benchFixtures.ReadFile("testdata/bench/nmap.txt")
+	if err != nil {
+		return err
+	}
+	for i, a := range args {
+		if a == "-oN" && i+1 < len(args) {
+			return os.WriteFile(args[i+1], b, 0o644)
+		}
+	}
+	_, err = io.Copy(os.Stdout, bytes.NewReader(b))
+	return err
+}
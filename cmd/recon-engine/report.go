@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// reportData is what the report templates render. With a previous run to
+// compare against, Delta lists what changed, Results holds the hosts that
+// changed or went missing and Unchanged the rest.
+type reportData struct {
+	Source       string
+	Tags         []string // key=value, of any of the results
+	Total        int
+	Live         int
+	WithFindings int
+	Results      []Result
+	Unchanged    []Result
+	Delta        *reportDelta
+	// Access lists the hosts of each -access-control class but none
+	Access []reportSection
+	// Visual lists the hosts whose screenshot changed, with both
+	// screenshots
+	Visual []reportChange
+	// Coverage is the run summary's coverage, when it is known
+	Coverage []coverageEntry
+}
+
+// reportDelta is the "changes since" part of a report, one section per
+// kind of change
+type reportDelta struct {
+	Previous string
+	Sections []reportSection
+}
+
+type reportSection struct {
+	Title   string
+	Entries []reportChange
+}
+
+// reportChange is one entry of a delta section, linking to the host's row
+// in the inventory
+type reportChange struct {
+	Anchor string
+	Host   string
+	Detail string
+	// Before and After are the screenshots of a visual change
+	Before, After string
+}
+
+func newReportData(source string, results []Result) reportData {
+	sortReportResults(results)
+	d := reportData{Source: source, Total: len(results), Results: results}
+	tags := make(map[string]string)
+	for _, r := range results {
+		for key, value := range r.Tags {
+			tags[key] = value
+		}
+		if r.StatusCode > 0 {
+			d.Live++
+		}
+		if len(r.Vulnerabilities) > 0 {
+			d.WithFindings++
+		}
+	}
+	d.Tags = tagPairs(tags)
+	d.Access = accessSections(results)
+	for _, r := range results {
+		if v := r.VisualChange; v != nil {
+			d.Visual = append(d.Visual, reportChange{Anchor: reportAnchor(r), Host: reportHost(r), Detail: fmt.Sprintf("%d of 64 hash bits differ", v.Distance), Before: v.Previous, After: v.Current})
+		}
+	}
+	return d
+}
+
+// accessSections groups the hosts -access-control classified by class, in
+// the order access_control lists them, with the evidence as the detail
+func accessSections(results []Result) []reportSection {
+	byClass := make(map[string][]reportChange)
+	for _, r := range results {
+		if r.AccessControl == "" || r.AccessControl == accessNone {
+			continue
+		}
+		byClass[r.AccessControl] = append(byClass[r.AccessControl], reportChange{Anchor: reportAnchor(r), Host: reportHost(r), Detail: r.AccessEvidence})
+	}
+	var sections []reportSection
+	for _, class := range accessClasses {
+		if entries := byClass[class]; len(entries) > 0 {
+			sections = append(sections, reportSection{Title: class, Entries: entries})
+		}
+	}
+	return sections
+}
+
+// sortReportResults puts the most interesting hosts first
+func sortReportResults(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].InterestScore != results[j].InterestScore {
+			return results[i].InterestScore > results[j].InterestScore
+		}
+		if results[i].Subdomain != results[j].Subdomain {
+			return results[i].Subdomain < results[j].Subdomain
+		}
+		return results[i].Port < results[j].Port
+	})
+}
+
+// newDeltaReportData compares results against a previous run's the way
+// -diff does and splits them into the delta sections, the changed hosts
+// and the unchanged ones
+func newDeltaReportData(source, previous string, prev, results []Result) reportData {
+	baseline := newBaseline(prev)
+	var current, changed, unchanged []Result
+	for _, res := range results {
+		if res.ChangeType == changeRemoved {
+			continue
+		}
+		res.ChangeType, res.Changes, res.VisualChange = "", nil, nil
+		if baseline.Classify(&res) {
+			changed = append(changed, res)
+		} else {
+			unchanged = append(unchanged, res)
+		}
+		current = append(current, res)
+	}
+	removed := baseline.Removed()
+
+	d := newReportData(source, current)
+	sortReportResults(changed)
+	sortReportResults(unchanged)
+	d.Results, d.Unchanged = append(changed, removed...), unchanged
+
+	var added, live, moved, findings, missing []reportChange
+	for _, res := range changed {
+		entry := reportChange{Anchor: reportAnchor(res), Host: reportHost(res)}
+		if res.ChangeType == changeNew {
+			entry.Detail = "not live"
+			if res.StatusCode > 0 {
+				entry.Detail = fmt.Sprintf("status %d", res.StatusCode)
+				if res.Title != "" {
+					entry.Detail += ", " + res.Title
+				}
+			}
+			added = append(added, entry)
+			for _, v := range res.Vulnerabilities {
+				findings = append(findings, reportFinding(entry, v))
+			}
+			continue
+		}
+		newlyLive := contains(res.Changes, "newly_live")
+		var other []string
+		for _, c := range res.Changes {
+			switch {
+			case c == "newly_live":
+			case strings.HasPrefix(c, "status_code "):
+				if newlyLive {
+					e := entry
+					e.Detail = "status " + strings.TrimPrefix(c, "status_code ")
+					live = append(live, e)
+				} else {
+					other = append(other, c)
+				}
+			case strings.HasPrefix(c, "visual-change "):
+				// Listed with the screenshots
+			case strings.HasPrefix(c, "new_finding "):
+				id := strings.TrimPrefix(c, "new_finding ")
+				for _, v := range res.Vulnerabilities {
+					if v.ID == id {
+						findings = append(findings, reportFinding(entry, v))
+						break
+					}
+				}
+			default:
+				other = append(other, c)
+			}
+		}
+		if len(other) > 0 {
+			entry.Detail = strings.Join(other, ", ")
+			moved = append(moved, entry)
+		}
+	}
+	for _, res := range removed {
+		entry := reportChange{Anchor: reportAnchor(res), Host: reportHost(res), Detail: "no longer found"}
+		if p, ok := baseline.prev[diffKey(res)]; ok && p.StatusCode > 0 {
+			entry.Detail = fmt.Sprintf("last answered %d", p.StatusCode)
+		}
+		missing = append(missing, entry)
+	}
+	d.Delta = &reportDelta{
+		Previous: previous,
+		Sections: []reportSection{
+			{"New subdomains", added},
+			{"Newly live hosts", live},
+			{"Status and technology changes", moved},
+			{"New findings", findings},
+			{"Gone missing", missing},
+		},
+	}
+	return d
+}
+
+func reportFinding(host reportChange, v Finding) reportChange {
+	host.Detail = fmt.Sprintf("%s (%s)", v.ID, v.Severity)
+	if v.Title != "" {
+		host.Detail += " " + v.Title
+	}
+	return host
+}
+
+// reportHost names a result in the delta sections: its subdomain, with the
+// port when it is not the scheme's default
+func reportHost(r Result) string {
+	if r.Port == 0 || r.Port == 80 || r.Port == 443 {
+		return r.Subdomain
+	}
+	return r.Subdomain + ":" + strconv.Itoa(r.Port)
+}
+
+// reportAnchor is the id of a result's inventory row
+func reportAnchor(r Result) string {
+	id := "host-" + strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' {
+			return c
+		}
+		if c >= 'A' && c <= 'Z' {
+			return c + 'a' - 'A'
+		}
+		return '_'
+	}, r.Subdomain)
+	if r.Port != 0 {
+		id += "-" + strconv.Itoa(r.Port)
+	}
+	return id
+}
+
+var reportFuncs = map[string]interface{}{
+	"join": strings.Join,
+	"vulnID": func(v Finding) string {
+		if v.ID != "" {
+			return v.ID
+		}
+		return "finding"
+	},
+	"severity": func(v Finding) string { return string(v.Severity) },
+	"anchor":   reportAnchor,
+	// md escapes the characters that would break a Markdown table cell or
+	// a link, and HTML, which most Markdown renderers pass through
+	"md": func(s string) string {
+		return mdEscaper.Replace(s)
+	},
+}
+
+var mdEscaper = strings.NewReplacer("|", `\|`, "[", `\[`, "]", `\]`, "`", "\\`", "<", "&lt;", ">", "&gt;", "&", "&amp;", "\n", " ", "\r", "")
+
+// cleanReportResults strips the ANSI escapes and control characters a
+// results file from an older engine, or edited by hand, may carry in the
+// text the report shows. html/template and md escape what is left.
+func cleanReportResults(results []Result) {
+	for i := range results {
+		r := &results[i]
+		for _, p := range []*string{&r.Subdomain, &r.Title, &r.Asn, &r.Org, &r.IP, &r.ChangeType, &r.AccessControl, &r.AccessEvidence} {
+			*p, _ = cleanText(*p, titleMax)
+		}
+		for j := range r.TechStack {
+			r.TechStack[j], _ = cleanText(r.TechStack[j], textMax)
+		}
+		for key, value := range r.Tags {
+			if validTagKey(key) != nil {
+				delete(r.Tags, key)
+				continue
+			}
+			r.Tags[key], _ = cleanText(value, textMax)
+		}
+	}
+}
+
+var htmlReport = template.Must(template.New("html").Funcs(template.FuncMap(reportFuncs)).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Recon report: {{.Source}}</title>
+<style>
+body{font-family:sans-serif;margin:2em}table{border-collapse:collapse;width:100%}
+th,td{border:1px solid #ccc;padding:4px 8px;text-align:left;vertical-align:top}
+th{background:#f3f3f3}.dead{color:#999}summary{font-weight:bold;margin:1em 0 .5em}
+.shots img{width:48%;border:1px solid #ccc;margin-right:1%}
+</style></head><body>
+<h1>Recon report</h1>
+<p>{{.Source}}: {{.Total}} hosts, {{.Live}} live, {{.WithFindings}} with findings.</p>
+{{if .Tags}}<p>Tags: {{join .Tags ", "}}</p>
+{{end}}{{if .Access}}<h2>Access control</h2>
+{{range .Access}}<details><summary>{{.Title}} ({{len .Entries}})</summary>
+<ul>
+{{range .Entries}}<li><a href="#{{.Anchor}}">{{.Host}}</a>{{if .Detail}}: {{.Detail}}{{end}}</li>
+{{end}}</ul>
+</details>
+{{end}}{{end}}{{if .Visual}}<h2>Visual changes</h2>
+{{range .Visual}}<h3><a href="#{{.Anchor}}">{{.Host}}</a>: {{.Detail}}</h3>
+<p class="shots"><img src="{{.Before}}" alt="previous screenshot"><img src="{{.After}}" alt="current screenshot"></p>
+{{end}}{{end}}{{if .Coverage}}<details><summary>Coverage</summary>
+<table>
+<tr><th>Capability</th><th>Stage</th><th>Status</th><th>Items</th><th>Reason</th></tr>
+{{range .Coverage}}<tr{{if ne .Status "ran"}} class="dead"{{end}}><td>{{.Capability}}</td><td>{{.Stage}}</td><td>{{.Status}}</td><td>{{.Items}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}{{with .Delta}}<h2>Changes since {{.Previous}}</h2>
+{{range .Sections}}<details{{if .Entries}} open{{end}}><summary>{{.Title}} ({{len .Entries}})</summary>
+{{if .Entries}}<ul>
+{{range .Entries}}<li><a href="#{{.Anchor}}">{{.Host}}</a>: {{.Detail}}</li>
+{{end}}</ul>{{else}}<p>None.</p>{{end}}
+</details>
+{{end}}<h2>Changed hosts</h2>
+{{end}}{{template "table" .Results}}
+{{if .Unchanged}}<details><summary>Unchanged hosts ({{len .Unchanged}})</summary>
+{{template "table" .Unchanged}}
+</details>
+{{end}}</body></html>
+{{define "table"}}<table>
+<tr><th>Subdomain</th><th>Score</th><th>Status</th><th>Title</th><th>Tech</th><th>IP</th><th>ASN / Org</th><th>Findings</th></tr>
+{{range .}}<tr id="{{anchor .}}"{{if eq .StatusCode 0}} class="dead"{{end}}>
+<td>{{if .URL}}<a href="{{.URL}}">{{.Subdomain}}</a>{{else}}{{.Subdomain}}{{end}}{{if .ChangeType}} ({{.ChangeType}}){{end}}</td>
+<td>{{.InterestScore}}</td><td>{{.StatusCode}}</td><td>{{.Title}}</td><td>{{join .TechStack ", "}}</td><td>{{.IP}}</td>
+<td>{{.Asn}} {{.Org}}</td>
+<td>{{range .Vulnerabilities}}{{vulnID .}} ({{severity .}})<br>{{end}}</td>
+</tr>
+{{end}}</table>{{end}}`))
+
+var markdownReport = texttemplate.Must(texttemplate.New("markdown").Funcs(texttemplate.FuncMap(reportFuncs)).Parse(`# Recon report
+
+{{md .Source}}: {{.Total}} hosts, {{.Live}} live, {{.WithFindings}} with findings.
+{{if .Tags}}
+Tags: {{md (join .Tags ", ")}}
+{{end}}{{if .Access}}
+## Access control
+{{range .Access}}
+### {{.Title}} ({{len .Entries}})
+
+{{range .Entries}}- [{{md .Host}}](#{{.Anchor}}){{if .Detail}}: {{md .Detail}}{{end}}
+{{end}}{{end}}{{end}}{{if .Visual}}
+## Visual changes
+{{range .Visual}}
+### [{{md .Host}}](#{{.Anchor}}): {{.Detail}}
+
+![previous screenshot](<{{.Before}}>) ![current screenshot](<{{.After}}>)
+{{end}}{{end}}{{if .Coverage}}
+## Coverage
+
+| Capability | Stage | Status | Items | Reason |
+|---|---|---|---|---|
+{{range .Coverage}}| {{.Capability}} | {{.Stage}} | {{.Status}} | {{.Items}} | {{md .Reason}} |
+{{end}}{{end}}{{with .Delta}}
+## Changes since {{md .Previous}}
+{{range .Sections}}
+### {{.Title}} ({{len .Entries}})
+
+{{range .Entries}}- [{{md .Host}}](#{{.Anchor}}): {{md .Detail}}
+{{else}}None.
+{{end}}{{end}}
+## Changed hosts
+{{end}}
+{{template "table" .Results}}{{if .Unchanged}}
+## Unchanged hosts
+
+{{template "table" .Unchanged}}{{end}}{{define "table"}}| Subdomain | Score | Status | Title | Tech | IP | ASN / Org | Findings |
+|---|---|---|---|---|---|---|---|
+{{range .}}| <a id="{{anchor .}}"></a>{{md .Subdomain}}{{if .ChangeType}} ({{md .ChangeType}}){{end}} | {{.InterestScore}} | {{.StatusCode}} | {{md .Title}} | {{md (join .TechStack ", ")}} | {{md .IP}} | {{md .Asn}} {{md .Org}} | {{range $i, $v := .Vulnerabilities}}{{if $i}}, {{end}}{{md (vulnID $v)}} ({{severity $v}}){{end}} |
+{{end}}{{end}}`))
+
+// runReport implements the report subcommand
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "markdown", "Report format: markdown or html")
+	outPath := fs.String("o", "", "Write the report to this file instead of stdout")
+	previous := fs.String("previous", "", "Previous run's results to report the changes since, as -diff compares them")
+	state := fs.String("state", "", "-state file whose results to report the changes since, instead of -previous")
+	summaryPath := fs.String("summary", "", "Run summary (summary.json) whose coverage to report: what ran and what was skipped or cut short")
+	encryptSpec := fs.String("encrypt-output", "", "Encrypt the -o report with the AES-256 key in an environment variable, given as env:NAME, as scan does")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [flags] <results-file>\n\nRenders scan output (NDJSON or a JSON array) as a report. With -previous\nor -state, sections listing the new subdomains, newly live hosts, status\nand technology changes, new findings and missing hosts come before the\ninventory, and unchanged hosts are collapsed in HTML. With -summary, a coverage section\nlists every capability and whether it ran.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := configureEncryption(*encryptSpec); err != nil {
+		startupError("Invalid -encrypt-output", err)
+	}
+	if encryptKey != nil && *outPath == "" {
+		startupError("Invalid -encrypt-output", fmt.Errorf("needs -o: the report is encrypted at rest, not on stdout"))
+	}
+
+	// html/template escapes for HTML, which would garble Markdown
+	var tmpl interface {
+		Execute(io.Writer, interface{}) error
+	}
+	switch *format {
+	case "markdown", "md":
+		tmpl = markdownReport
+	case "html":
+		tmpl = htmlReport
+	default:
+		startupError("Invalid -format", fmt.Errorf("want markdown or html, got %q", *format))
+	}
+	if *previous != "" && *state != "" {
+		startupError("Invalid report options", fmt.Errorf("-previous and -state are mutually exclusive"))
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatalError("Failed to open results", err)
+	}
+	results, err := readResults(f)
+	f.Close()
+	if err != nil {
+		fatalError("Failed to read results", err)
+	}
+	cleanReportResults(results)
+
+	data := newReportData(fs.Arg(0), results)
+	switch {
+	case *previous != "":
+		f, err := os.Open(*previous)
+		if err != nil {
+			fatalError("Failed to open previous results", err)
+		}
+		prev, err := readResults(f)
+		f.Close()
+		if err != nil {
+			fatalError("Failed to read previous results", err)
+		}
+		cleanReportResults(prev)
+		data = newDeltaReportData(fs.Arg(0), *previous, prev, results)
+	case *state != "":
+		st, err := readState(*state)
+		if err != nil {
+			fatalError("Failed to read state", err)
+		}
+		cleanReportResults(st.Results)
+		data = newDeltaReportData(fs.Arg(0), fmt.Sprintf("%s (%s)", *state, st.UpdatedAt), st.Results, results)
+	}
+	if *summaryPath != "" {
+		var sum runSummary
+		b, err := os.ReadFile(*summaryPath)
+		if err == nil {
+			err = json.Unmarshal(b, &sum)
+		}
+		if err != nil {
+			fatalError("Failed to read summary", err)
+		}
+		data.Coverage = sum.Coverage
+	}
+
+	var w io.Writer = os.Stdout
+	if *outPath != "" {
+		out, err := os.Create(*outPath)
+		if err != nil {
+			fatalError("Failed to create report", err)
+		}
+		defer out.Close()
+		w = out
+	}
+	var enc *encryptWriter
+	if encryptKey != nil {
+		if enc, err = newEncryptWriter(w); err != nil {
+			fatalError("Failed to create report", err)
+		}
+		w = enc
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		fatalError("Failed to render report", err)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			fatalError("Failed to write report", err)
+		}
+	}
+}
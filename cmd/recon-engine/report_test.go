@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// hostileResults are results whose text a target controls
+func hostileResults() []Result {
+	return []Result{{
+		Subdomain:  "xss.example.com",
+		RootDomain: "example.com",
+		StatusCode: 200,
+		Title:      "\x1b[31m<script>alert(1)</script>\x1b[0m | [link](javascript:x)\nnext",
+		TechStack:  []string{"<img src=x onerror=alert(2)>"},
+		Org:        "Evil & Co",
+	}}
+}
+
+// TestReportEscapesText sanitizes titles and other target-controlled text
+// when the report is rendered, for results files that were not cleaned
+// when they were written
+func TestReportEscapesText(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		render   func(*bytes.Buffer, reportData) error
+		want     []string
+		unwanted []string
+	}{
+		{
+			"html",
+			func(b *bytes.Buffer, d reportData) error { return htmlReport.Execute(b, d) },
+			[]string{"&lt;script&gt;alert(1)&lt;/script&gt;", "&lt;img src=x onerror=alert(2)&gt;", "Evil &amp; Co"},
+			[]string{"<script>alert", "<img src=x", "\x1b"},
+		},
+		{
+			"markdown",
+			func(b *bytes.Buffer, d reportData) error { return markdownReport.Execute(b, d) },
+			[]string{"&lt;script&gt;alert(1)&lt;/script&gt; \\| \\[link\\](javascript:x) next", "Evil &amp; Co"},
+			[]string{"<script>alert", "<img src=x", "\x1b", "| [link]"},
+		},
+	} {
+		results := hostileResults()
+		cleanReportResults(results)
+		var b bytes.Buffer
+		if err := c.render(&b, newReportData("results.json", results)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		out := b.String()
+		for _, s := range c.want {
+			if !strings.Contains(out, s) {
+				t.Errorf("%s report lacks %q", c.name, s)
+			}
+		}
+		for _, s := range c.unwanted {
+			if strings.Contains(out, s) {
+				t.Errorf("%s report contains %q", c.name, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	robotsMaxBytes  = 512 << 10
+	sitemapMaxBytes = 10 << 20 // after decompression
+	// robotsMaxEntries and sitemapMaxURLs cap the Result fields
+	robotsMaxEntries = 200
+	sitemapMaxURLs   = 200
+	// sitemapMaxChildren caps the child sitemaps read from a sitemap index
+	sitemapMaxChildren = 10
+)
+
+var robotsHTTP = newHTTPClient(10*time.Second, false)
+
+// collectRobots fetches /robots.txt and the host's sitemaps and fills
+// res.RobotsDisallow and res.SitemapURLs. Failures leave them empty.
+func collectRobots(ctx context.Context, res *Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	base := &url.URL{Scheme: u.Scheme, Host: u.Host}
+
+	sitemaps := []string{base.String() + "/sitemap.xml"}
+	if body, err := fetchCapped(ctx, base.String()+"/robots.txt", robotsMaxBytes); err == nil {
+		var more []string
+		res.RobotsDisallow, more = parseRobots(body)
+		if len(res.RobotsDisallow) > robotsMaxEntries {
+			res.RobotsDisallow = res.RobotsDisallow[:robotsMaxEntries]
+		}
+		for _, s := range more {
+			if sameHost(base, s) && !contains(sitemaps, s) {
+				sitemaps = append(sitemaps, s)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	add := func(loc string) {
+		if len(res.SitemapURLs) < sitemapMaxURLs && sameHost(base, loc) && !seen[loc] {
+			seen[loc] = true
+			res.SitemapURLs = append(res.SitemapURLs, loc)
+		}
+	}
+	for _, s := range sitemaps {
+		if robotsDisallowed(ctx, s) {
+			continue
+		}
+		locs, children := readSitemap(ctx, s)
+		for _, loc := range locs {
+			add(loc)
+		}
+		// Index files are followed one level deep
+		fetched := 0
+		for _, c := range children {
+			if fetched == sitemapMaxChildren || len(res.SitemapURLs) >= sitemapMaxURLs {
+				break
+			}
+			if !sameHost(base, c) || robotsDisallowed(ctx, c) {
+				continue
+			}
+			fetched++
+			locs, _ := readSitemap(ctx, c)
+			for _, loc := range locs {
+				add(loc)
+			}
+		}
+	}
+}
+
+// fetchCapped GETs rawURL and returns at most limit bytes of a 200 response,
+// decompressing gzip bodies
+func fetchCapped(ctx context.Context, rawURL string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := robotsHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	br := bufio.NewReader(resp.Body)
+	var r io.Reader = br
+	// Sitemaps are often served as .xml.gz without a Content-Encoding
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return io.ReadAll(io.LimitReader(r, limit))
+}
+
+// parseRobots returns the Disallow paths and Sitemap URLs of a robots.txt,
+// whatever user agent they apply to
+func parseRobots(body []byte) (disallow, sitemaps []string) {
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "disallow":
+			if value != "" && !seen[value] {
+				seen[value] = true
+				disallow = append(disallow, value)
+			}
+		case "sitemap":
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		}
+	}
+	return disallow, sitemaps
+}
+
+// sitemapDoc covers both a urlset and a sitemapindex
+type sitemapDoc struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// readSitemap returns the page URLs of a sitemap and, for an index, the
+// child sitemap URLs
+func readSitemap(ctx context.Context, rawURL string) (locs, children []string) {
+	body, err := fetchCapped(ctx, rawURL, sitemapMaxBytes)
+	if err != nil {
+		return nil, nil
+	}
+	var doc sitemapDoc
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, nil
+	}
+	for i := range doc.URLs {
+		doc.URLs[i] = strings.TrimSpace(doc.URLs[i])
+	}
+	for i := range doc.Sitemaps {
+		doc.Sitemaps[i] = strings.TrimSpace(doc.Sitemaps[i])
+	}
+	return doc.URLs, doc.Sitemaps
+}
+
+// sameHost reports whether rawURL points at base's host
+func sameHost(base *url.URL, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.EqualFold(u.Host, base.Host)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
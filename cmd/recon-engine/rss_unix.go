@@ -0,0 +1,18 @@
+//go:build unix && !darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// peakRSS returns the largest resident set size of the exited process, or
+// of a process it waited for if that was larger. Linux and the BSDs report
+// kilobytes.
+func peakRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss) * 1024
+	}
+	return 0
+}
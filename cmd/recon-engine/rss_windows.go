@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// peakRSS is not measured on Windows, whose rusage has no memory figures
+func peakRSS(ps *os.ProcessState) int64 {
+	return 0
+}
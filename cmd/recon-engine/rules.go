@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/flags.yaml
+var defaultFlagRules []byte
+
+// flagRule sets Flag on every result its conditions match. A rule with
+// Block instead marks the result as a block page of that WAF or CDN vendor,
+// one with Environment classifies it as that environment and one with
+// Parked as a domain parked with that registrar or parking service.
+type flagRule struct {
+	Flag        string `yaml:"flag"`
+	Block       string `yaml:"block"`
+	Environment string `yaml:"environment"`
+	Parked      string `yaml:"parked"`
+	ruleMatcher `yaml:",inline"`
+}
+
+// ruleMatcher is one rule condition. The fields set on it must all match;
+// All and Any nest further conditions.
+type ruleMatcher struct {
+	TitleContains string        `yaml:"title_contains"`
+	TechContains  string        `yaml:"tech_contains"`
+	Status        int           `yaml:"status"`
+	BodyHash      string        `yaml:"body_hash"`
+	BodyContains  string        `yaml:"body_contains"` // block, parked and environment rules only
+	HostToken     string        `yaml:"host_token"`    // a label of the hostname, as the score splits them
+	RobotsDenyAll bool          `yaml:"robots_deny_all"`
+	EnvIn         []string      `yaml:"environment_in"`
+	AccessIn      []string      `yaml:"access_control_in"` // -access-control classes
+	Header        string        `yaml:"header"`
+	Regex         string        `yaml:"regex"`               // applied to Header's value
+	GradeBelow    string        `yaml:"header_grade_below"`  // -header-audit grade worse than this
+	Finding       string        `yaml:"finding"`             // id of a finding the result has
+	Nameserver    string        `yaml:"nameserver_contains"` // parked rules only: the root domain's nameservers
+	All           []ruleMatcher `yaml:"all"`
+	Any           []ruleMatcher `yaml:"any"`
+
+	re *regexp.Regexp
+}
+
+// flagRules are the embedded rules followed by -rules; blockRules and
+// parkedRules are those with a block vendor and a parking provider.
+// envRules are the environment rules of -rules followed by the embedded
+// ones, so -rules can override them.
+var flagRules, blockRules, parkedRules, envRules []flagRule
+
+// rulesNeedHeaders is set when a rule matches on a response header, which
+// httpx only reports when asked to
+var rulesNeedHeaders bool
+
+// configureRules loads the embedded rules and -rules. Called once after
+// flag parsing.
+func configureRules() error {
+	rules, err := parseRules(defaultFlagRules)
+	if err != nil {
+		return fmt.Errorf("embedded rules: %w", err)
+	}
+	var more []flagRule
+	if rulesPath != "" {
+		data, err := os.ReadFile(rulesPath)
+		if err != nil {
+			return err
+		}
+		if more, err = parseRules(data); err != nil {
+			return fmt.Errorf("%s: %w", rulesPath, err)
+		}
+	}
+	for _, r := range append(more, rules...) {
+		if r.Environment != "" {
+			if r.usesNameservers() {
+				return fmt.Errorf("rule %q: nameserver_contains is only for parked rules", r.Environment)
+			}
+			if r.usesHeaders() {
+				rulesNeedHeaders = true
+			}
+			envRules = append(envRules, r)
+		}
+	}
+	for _, r := range append(rules, more...) {
+		if r.Environment != "" {
+			continue
+		}
+		if r.Parked != "" {
+			parkedRules = append(parkedRules, r)
+			continue
+		}
+		if r.usesNameservers() {
+			return fmt.Errorf("rule %q: nameserver_contains is only for parked rules", r.Flag+r.Block)
+		}
+		if r.Block != "" {
+			blockRules = append(blockRules, r)
+			continue
+		}
+		if r.usesBody() {
+			return fmt.Errorf("rule %q: body_contains is only for block, parked and environment rules", r.Flag)
+		}
+		// Block rules fetch the headers themselves, for block pages only
+		if r.usesHeaders() {
+			rulesNeedHeaders = true
+		}
+		flagRules = append(flagRules, r)
+	}
+	return nil
+}
+
+func parseRules(data []byte) ([]flagRule, error) {
+	var rules []flagRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		r := &rules[i]
+		kinds := 0
+		for _, k := range []string{r.Flag, r.Block, r.Environment, r.Parked} {
+			if k != "" {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("rule %d needs one of a flag, a block vendor, an environment or a parking provider", i+1)
+		}
+		r.Environment = strings.ToLower(r.Environment)
+		if r.Environment != "" && !validEnvironment(r.Environment) {
+			return nil, fmt.Errorf("rule %d: unknown environment %q (want %s)", i+1, r.Environment, strings.Join(environments, ", "))
+		}
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Flag+r.Block+r.Environment+r.Parked, err)
+		}
+	}
+	return rules, nil
+}
+
+// compile checks m and its nested conditions and compiles header regexes.
+// A condition with nothing to match is rejected since it would match every
+// result.
+func (m *ruleMatcher) compile() error {
+	if m.TitleContains == "" && m.TechContains == "" && m.Status == 0 && m.BodyHash == "" && m.BodyContains == "" && m.Header == "" && m.GradeBelow == "" && m.Finding == "" && m.Nameserver == "" && m.HostToken == "" && !m.RobotsDenyAll && len(m.EnvIn) == 0 && len(m.AccessIn) == 0 && len(m.All) == 0 && len(m.Any) == 0 {
+		return fmt.Errorf("empty condition")
+	}
+	if (m.Header == "") != (m.Regex == "") {
+		return fmt.Errorf("header and regex must be set together")
+	}
+	m.HostToken = strings.ToLower(m.HostToken)
+	m.Nameserver = strings.ToLower(m.Nameserver)
+	for i, env := range m.EnvIn {
+		m.EnvIn[i] = strings.ToLower(env)
+		if !validEnvironment(m.EnvIn[i]) && m.EnvIn[i] != envUnknown {
+			return fmt.Errorf("environment_in: unknown environment %q", env)
+		}
+	}
+	for i, class := range m.AccessIn {
+		m.AccessIn[i] = strings.ToLower(class)
+		if !contains(accessClasses, m.AccessIn[i]) {
+			return fmt.Errorf("access_control_in: unknown class %q", class)
+		}
+	}
+	if m.GradeBelow != "" && gradeRank(m.GradeBelow) < 0 {
+		return fmt.Errorf("header_grade_below must be one of A, B, C, D or F, got %q", m.GradeBelow)
+	}
+	if m.Header != "" {
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return err
+		}
+		m.re = re
+	}
+	for i := range m.All {
+		if err := m.All[i].compile(); err != nil {
+			return err
+		}
+	}
+	for i := range m.Any {
+		if err := m.Any[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usesHeaders reports whether m or a nested condition matches on a response
+// header
+func (m *ruleMatcher) usesHeaders() bool {
+	return m.anyCondition(func(c *ruleMatcher) bool { return c.Header != "" })
+}
+
+// usesBody reports whether m or a nested condition matches on the body
+func (m *ruleMatcher) usesBody() bool {
+	return m.anyCondition(func(c *ruleMatcher) bool { return c.BodyContains != "" })
+}
+
+// usesNameservers reports whether m or a nested condition matches on the
+// root domain's nameservers
+func (m *ruleMatcher) usesNameservers() bool {
+	return m.anyCondition(func(c *ruleMatcher) bool { return c.Nameserver != "" })
+}
+
+func (m *ruleMatcher) anyCondition(pred func(*ruleMatcher) bool) bool {
+	if pred(m) {
+		return true
+	}
+	for i := range m.All {
+		if m.All[i].anyCondition(pred) {
+			return true
+		}
+	}
+	for i := range m.Any {
+		if m.Any[i].anyCondition(pred) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ruleMatcher) match(res *Result) bool {
+	if m.TitleContains != "" && !strings.Contains(strings.ToLower(res.Title), strings.ToLower(m.TitleContains)) {
+		return false
+	}
+	if m.TechContains != "" && !techContains(res.TechStack, m.TechContains) {
+		return false
+	}
+	if m.Status != 0 && res.StatusCode != m.Status {
+		return false
+	}
+	if m.BodyHash != "" && !strings.EqualFold(res.BodySHA256, m.BodyHash) {
+		return false
+	}
+	if m.BodyContains != "" && !bytes.Contains(bytes.ToLower(res.body), []byte(strings.ToLower(m.BodyContains))) {
+		return false
+	}
+	if m.re != nil && !m.re.MatchString(res.headers[headerKey(m.Header)]) {
+		return false
+	}
+	// Results without a grade were not audited and match no grade
+	if m.GradeBelow != "" && (res.HeaderGrade == "" || gradeRank(res.HeaderGrade) <= gradeRank(m.GradeBelow)) {
+		return false
+	}
+	if m.Finding != "" && !hasFinding(res, m.Finding) {
+		return false
+	}
+	if m.Nameserver != "" && !nameserverContains(res.nameservers, m.Nameserver) {
+		return false
+	}
+	if m.HostToken != "" && !hostHasToken(res.Subdomain, res.RootDomain, m.HostToken) {
+		return false
+	}
+	if m.RobotsDenyAll && !contains(res.RobotsDisallow, "/") {
+		return false
+	}
+	if len(m.EnvIn) > 0 && !contains(m.EnvIn, res.Environment) {
+		return false
+	}
+	if len(m.AccessIn) > 0 && !contains(m.AccessIn, res.AccessControl) {
+		return false
+	}
+	for i := range m.All {
+		if !m.All[i].match(res) {
+			return false
+		}
+	}
+	if len(m.Any) == 0 {
+		return true
+	}
+	for i := range m.Any {
+		if m.Any[i].match(res) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFinding reports whether res has a finding with id, in any case
+func hasFinding(res *Result, id string) bool {
+	for _, f := range res.Vulnerabilities {
+		if strings.EqualFold(f.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func techContains(tech []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, t := range tech {
+		if strings.Contains(strings.ToLower(t), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerKey normalises a header name the way httpx -irh reports it
+func headerKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "-", "_")
+}
+
+// httpxHeaders flattens httpx's header map, which holds a string per
+// header or a list when the header repeated
+func httpxHeaders(h map[string]interface{}) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		switch v := v.(type) {
+		case string:
+			out[headerKey(k)] = v
+		case []interface{}:
+			vals := make([]string, 0, len(v))
+			for _, s := range v {
+				vals = append(vals, fmt.Sprint(s))
+			}
+			out[headerKey(k)] = strings.Join(vals, ", ")
+		}
+	}
+	return out
+}
+
+// applyFlagRules sets res.Flags from the loaded rules
+func applyFlagRules(res *Result) {
+	for i := range flagRules {
+		r := &flagRules[i]
+		if r.match(res) && !contains(res.Flags, r.Flag) {
+			res.Flags = append(res.Flags, r.Flag)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// loadRules runs configureRules with -rules set to path, restoring the
+// loaded rules afterwards
+func loadRules(t *testing.T, path string) {
+	t.Helper()
+	oldPath, oldFlag, oldBlock, oldParked, oldEnv, oldHeaders := rulesPath, flagRules, blockRules, parkedRules, envRules, rulesNeedHeaders
+	t.Cleanup(func() {
+		rulesPath, flagRules, blockRules, parkedRules, envRules, rulesNeedHeaders = oldPath, oldFlag, oldBlock, oldParked, oldEnv, oldHeaders
+	})
+	rulesPath, flagRules, blockRules, parkedRules, envRules, rulesNeedHeaders = path, nil, nil, nil, nil, false
+	if err := configureRules(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flagsOf applies the loaded flag rules to an httpx record
+func flagsOf(t *testing.T, line string) []string {
+	t.Helper()
+	var h HttpxResult
+	if err := json.Unmarshal([]byte(line), &h); err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	res := httpxResult(h, "example.com")
+	applyFlagRules(&res)
+	return res.Flags
+}
+
+// TestEmbeddedFlagRules runs the embedded ruleset over httpx records of
+// the pages it is meant to flag, and of ordinary ones it must leave alone
+func TestEmbeddedFlagRules(t *testing.T) {
+	loadRules(t, "")
+	for _, c := range []struct {
+		line string
+		want []string
+	}{
+		{`{"input":"sso.example.com","url":"https://sso.example.com","status_code":200,"title":"Sign In - Example Corp"}`, []string{"login-page"}},
+		{`{"input":"pma.example.com","url":"https://pma.example.com","status_code":200,"title":"phpMyAdmin","tech":["phpMyAdmin","PHP:8.1"]}`, []string{"admin-panel"}},
+		{`{"input":"ci.example.com","url":"https://ci.example.com","status_code":403,"title":"Sign in [Jenkins]","tech":["Jenkins:2.426"]}`, []string{"login-page", "admin-panel"}},
+		{`{"input":"files.example.com","url":"http://files.example.com","status_code":200,"title":"Index of /backups"}`, []string{"dir-listing"}},
+		{`{"input":"new.example.com","url":"http://new.example.com","status_code":200,"title":"Welcome to nginx!","tech":["Nginx:1.24.0"]}`, []string{"default-install"}},
+		{`{"input":"tc.example.com","url":"http://tc.example.com:8080","status_code":200,"title":"Apache Tomcat/9.0.82"}`, []string{"default-install"}},
+		// The Tomcat default page only counts when it is served
+		{`{"input":"tc2.example.com","url":"http://tc2.example.com:8080","status_code":404,"title":"Apache Tomcat/9.0.82 - Error report"}`, nil},
+		{`{"input":"api.example.com","url":"https://api.example.com","status_code":500,"title":"Whitelabel Error Page"}`, []string{"error-page-verbose"}},
+		{`{"input":"app.example.com","url":"https://app.example.com","status_code":500,"title":"Server Error in '/' Application."}`, []string{"error-page-verbose"}},
+		{`{"input":"app2.example.com","url":"https://app2.example.com","status_code":200,"title":"Server Error in '/' Application."}`, nil},
+		{`{"input":"www.example.com","url":"https://www.example.com","status_code":200,"title":"Example Corp - Home","tech":["Cloudflare","React"]}`, nil},
+	} {
+		if got := flagsOf(t, c.line); !slices.Equal(got, c.want) {
+			t.Errorf("%s: flags %v, want %v", c.line, got, c.want)
+		}
+	}
+}
+
+// TestCustomFlagRules adds a -rules file composing header, status and
+// title matchers with all and any
+func TestCustomFlagRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := `
+- flag: exposed-grafana
+  all:
+    - status: 200
+    - any:
+        - title_contains: grafana
+        - header: x-grafana-org-id
+          regex: "^[0-9]+$"
+`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadRules(t, path)
+	if !rulesNeedHeaders {
+		t.Error("a header rule did not ask httpx for headers")
+	}
+	for _, c := range []struct {
+		line string
+		want []string
+	}{
+		{`{"input":"g.example.com","url":"https://g.example.com","status_code":200,"title":"Grafana"}`, []string{"admin-panel", "exposed-grafana"}},
+		{`{"input":"g2.example.com","url":"https://g2.example.com","status_code":200,"title":"Metrics","header":{"X-Grafana-Org-Id":"1"}}`, []string{"exposed-grafana"}},
+		{`{"input":"g3.example.com","url":"https://g3.example.com","status_code":200,"title":"Metrics","header":{"X-Grafana-Org-Id":"main"}}`, nil},
+		{`{"input":"g4.example.com","url":"https://g4.example.com","status_code":302,"title":"Metrics","header":{"X-Grafana-Org-Id":"1"}}`, nil},
+	} {
+		if got := flagsOf(t, c.line); !slices.Equal(got, c.want) {
+			t.Errorf("%s: flags %v, want %v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestParseRulesRejects(t *testing.T) {
+	for _, rules := range []string{
+		"- title_contains: admin\n",
+		"- flag: a\n  block: b\n  title_contains: admin\n",
+		"- flag: a\n",
+		"- flag: a\n  header: server\n",
+		"- flag: a\n  header: server\n  regex: \"(\"\n",
+		"- flag: a\n  header_grade_below: E\n",
+	} {
+		if _, err := parseRules([]byte(rules)); err == nil {
+			t.Errorf("accepted %q", rules)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on tool output. Longer strings are cut, longer lists truncated and
+// numbers outside the range clamped, each counting the line as repaired.
+const (
+	titleMax      = 512 // runes
+	textMax       = 256 // runes, for the other short strings
+	techMax       = 100
+	contentLenMax = 1<<31 - 1
+	nameMax       = 253 // a DNS name
+)
+
+// ansiEscape matches the CSI and OSC escape sequences tools leak into
+// titles and banners when they colour their output
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-_]`)
+
+// toolLineStats counts the output lines of one tool the engine rejected or
+// repaired, for the run summary
+type toolLineStats struct {
+	Rejected int `json:"rejected,omitempty"`
+	Repaired int `json:"repaired,omitempty"`
+}
+
+var (
+	toolLinesMu sync.Mutex
+	toolLines   = make(map[string]*toolLineStats)
+)
+
+func toolLineCounts(tool string) *toolLineStats {
+	c := toolLines[tool]
+	if c == nil {
+		c = &toolLineStats{}
+		toolLines[tool] = c
+	}
+	return c
+}
+
+// repairedLine counts a line of tool output that was fixed up to be used
+func repairedLine(tool string) {
+	toolLinesMu.Lock()
+	toolLineCounts(tool).Repaired++
+	toolLinesMu.Unlock()
+	stats.Add("tools."+tool+".repaired", 1)
+}
+
+// rejectedLine counts a line of tool output that could not be used and
+// reports it as a tool error of the given kind
+func rejectedLine(tool, stage, kind string, err error) {
+	toolLinesMu.Lock()
+	toolLineCounts(tool).Rejected++
+	toolLinesMu.Unlock()
+	stats.Add("tools."+tool+".rejected", 1)
+	reportToolError(tool, stage, kind, err)
+}
+
+// toolLineSnapshot copies the counts for the run summary
+func toolLineSnapshot() map[string]toolLineStats {
+	toolLinesMu.Lock()
+	defer toolLinesMu.Unlock()
+	if len(toolLines) == 0 {
+		return nil
+	}
+	out := make(map[string]toolLineStats, len(toolLines))
+	for tool, c := range toolLines {
+		out[tool] = *c
+	}
+	return out
+}
+
+// resetToolLines clears the counts between -monitor iterations
+func resetToolLines() {
+	toolLinesMu.Lock()
+	defer toolLinesMu.Unlock()
+	toolLines = make(map[string]*toolLineStats)
+}
+
+// validUTF8 returns b with invalid UTF-8 sequences replaced by U+FFFD, and
+// whether there were any
+func validUTF8(b []byte) ([]byte, bool) {
+	if utf8.Valid(b) {
+		return b, false
+	}
+	return bytes.ToValidUTF8(b, []byte("�")), true
+}
+
+// cleanText strips ANSI escapes and control characters from s, turning
+// line breaks and tabs into spaces, and cuts it to max runes. It reports
+// whether anything changed.
+func cleanText(s string, max int) (string, bool) {
+	orig := s
+	if strings.IndexByte(s, 0x1b) >= 0 {
+		s = ansiEscape.ReplaceAllString(s, "")
+	}
+	s = strings.ToValidUTF8(s, "�")
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+	if utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max])
+	}
+	s = strings.TrimSpace(s)
+	return s, s != strings.TrimSpace(orig)
+}
+
+// cleanName lowercases a discovered DNS name and checks it is one: at most
+// 253 characters of letters, digits, hyphens, underscores, dots and a
+// leading wildcard
+func cleanName(s string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+	if name == "" {
+		return "", fmt.Errorf("empty name")
+	}
+	if len(name) > nameMax {
+		return "", fmt.Errorf("name of %d characters", len(name))
+	}
+	for i, c := range name {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '*' && i == 0 {
+			continue
+		}
+		return "", fmt.Errorf("invalid character %q in name %q", c, strings.ToValidUTF8(name, "�"))
+	}
+	return name, nil
+}
+
+// sanitizeHttpx checks a line of httpx (or native engine) output: it needs
+// an input, a status code HTTP allows and an http(s) URL. Text fields are
+// cleaned and absurd values clamped; repaired reports whether any were.
+func sanitizeHttpx(h *HttpxResult) (repaired bool, err error) {
+	h.Input = strings.TrimSpace(h.Input)
+	if h.Input == "" {
+		return false, fmt.Errorf("line without input")
+	}
+	if h.StatusCode < 0 || h.StatusCode > 599 {
+		return false, fmt.Errorf("%s: status code %d outside 0-599", h.Input, h.StatusCode)
+	}
+	if h.Url != "" {
+		u, err := url.Parse(h.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return false, fmt.Errorf("%s: malformed url %q", h.Input, h.Url)
+		}
+	}
+	var changed bool
+	fix := func(p *string, max int) {
+		if *p, changed = cleanText(*p, max); changed {
+			repaired = true
+		}
+	}
+	fix(&h.Title, titleMax)
+	fix(&h.WebServer, textMax)
+	fix(&h.CDNName, textMax)
+	tech := h.Tech[:0]
+	for _, t := range h.Tech {
+		if t, changed = cleanText(t, textMax); changed {
+			repaired = true
+		}
+		if t != "" && len(tech) < techMax {
+			tech = append(tech, t)
+		} else {
+			repaired = true
+		}
+	}
+	h.Tech = tech
+	if h.ContentLength < 0 || h.ContentLength > contentLenMax {
+		h.ContentLength, repaired = min(max(h.ContentLength, 0), contentLenMax), true
+	}
+	addrs := h.A[:0]
+	for _, a := range h.A {
+		if _, err := netip.ParseAddr(a); err == nil {
+			addrs = append(addrs, a)
+		} else {
+			repaired = true
+		}
+	}
+	h.A = addrs
+	addrs = h.AAAA[:0]
+	for _, a := range h.AAAA {
+		if _, err := netip.ParseAddr(a); err == nil {
+			addrs = append(addrs, a)
+		} else {
+			repaired = true
+		}
+	}
+	h.AAAA = addrs
+	if sum := h.Hash.BodySHA256; sum != "" && !isHex(sum, 64) {
+		h.Hash.BodySHA256, repaired = "", true
+	}
+	return repaired, nil
+}
+
+// sanitizeAmass checks a line of amass JSON output: it needs a valid name
+func sanitizeAmass(ar *AmassResult) (repaired bool, err error) {
+	if ar.Name, err = cleanName(ar.Name); err != nil {
+		return false, err
+	}
+	for i := range ar.Addresses {
+		a := &ar.Addresses[i]
+		if a.Asn < 0 || int64(a.Asn) > 1<<32-1 {
+			a.Asn, repaired = 0, true
+		}
+		var changed bool
+		if a.Desc, changed = cleanText(a.Desc, textMax); changed {
+			repaired = true
+		}
+	}
+	return repaired, nil
+}
+
+// sanitizeWhatWeb cleans WhatWeb's plugin names, strings and versions and
+// drops entries without a target or with an impossible status
+func sanitizeWhatWeb(results []WhatWebResult) (out []WhatWebResult, repaired, rejected int) {
+	for _, r := range results {
+		if strings.TrimSpace(r.Target) == "" || r.HTTPStatus < 0 || r.HTTPStatus > 599 {
+			rejected++
+			continue
+		}
+		changed := false
+		plugins := make(map[string]WhatWebPlugin, len(r.Plugins))
+		for name, p := range r.Plugins {
+			clean, c := cleanText(name, textMax)
+			changed = changed || c
+			if clean == "" {
+				continue
+			}
+			for _, list := range [][]string{p.String, p.Version} {
+				for i := range list {
+					list[i], c = cleanText(list[i], textMax)
+					changed = changed || c
+				}
+			}
+			plugins[clean] = p
+		}
+		r.Plugins = plugins
+		if changed {
+			repaired++
+		}
+		out = append(out, r)
+	}
+	return out, repaired, rejected
+}
+
+// validPort reports whether p is a TCP or UDP port number
+func validPort(p int) bool {
+	return p >= 1 && p <= 65535
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// readFixture returns the lines of testdata/malformed/name
+func readFixture(t *testing.T, name string) [][]byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "malformed", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+}
+
+func clearToolLines(t *testing.T) {
+	resetToolLines()
+	t.Cleanup(resetToolLines)
+}
+
+// TestParseHttpxFixtures feeds real-world malformed httpx lines through
+// the parser: each is rejected, repaired or passed as it is, and counted
+func TestParseHttpxFixtures(t *testing.T) {
+	clearToolLines(t)
+	var kept []HttpxResult
+	for _, line := range readFixture(t, "httpx.jsonl") {
+		if h, ok := parseHttpxLine(line); ok {
+			kept = append(kept, h)
+		}
+	}
+	var inputs []string
+	for _, h := range kept {
+		inputs = append(inputs, h.Input)
+	}
+	if want := []string{"admin.example.com", "e.example.com", "g.example.com", "h.example.com"}; !slices.Equal(inputs, want) {
+		t.Fatalf("kept %v, want %v", inputs, want)
+	}
+
+	if h := kept[0]; h.Title != "Admin Panel" || !slices.Equal(h.Tech, []string{"Nginx"}) {
+		t.Errorf("ANSI escapes: title %q, tech %v", h.Title, h.Tech)
+	}
+	if h := kept[1]; h.ContentLength != 0 || !slices.Equal(h.A, []string{"192.0.2.5"}) || !slices.Equal(h.AAAA, []string{"2001:db8::5"}) ||
+		h.Hash.BodySHA256 != "" || !slices.Equal(h.Tech, []string{"IIS:10.0"}) {
+		t.Errorf("clamped values: %+v", h)
+	}
+	if h := kept[2]; h.Title != "Sign  in �� here" {
+		t.Errorf("control characters: title %q", h.Title)
+	}
+	if h := kept[3]; h.Title != "Grafana" || len(h.Hash.BodySHA256) != 64 || !slices.Equal(h.A, []string{"192.0.2.8"}) {
+		t.Errorf("clean line changed: %+v", h)
+	}
+
+	if got := toolLineSnapshot()["httpx"]; got != (toolLineStats{Rejected: 4, Repaired: 3}) {
+		t.Errorf("httpx counts %+v, want 4 rejected, 3 repaired", got)
+	}
+}
+
+func TestHandleAmassFixtures(t *testing.T) {
+	clearToolLines(t)
+	infraMutex.Lock()
+	oldInfra := infraMap
+	infraMap = make(map[string]Infrastructure)
+	infraMutex.Unlock()
+	t.Cleanup(func() {
+		infraMutex.Lock()
+		infraMap = oldInfra
+		infraMutex.Unlock()
+	})
+
+	var names []string
+	for _, line := range readFixture(t, "amass.jsonl") {
+		out := make(chan string, 1)
+		if name := handleAmassJSON(line, out); name != "" {
+			names = append(names, <-out)
+		}
+	}
+	if want := []string{"www.example.com", "api.example.com", "vpn.example.com"}; !slices.Equal(names, want) {
+		t.Fatalf("names %v, want %v", names, want)
+	}
+	if in := infraMap["www.example.com"]; in.Asn != 64500 || in.Org != "EXAMPLE-NET - Example" {
+		t.Errorf("www infrastructure %+v", in)
+	}
+	if in := infraMap["api.example.com"]; in.Asn != 0 {
+		t.Errorf("an ASN of -1 kept: %+v", in)
+	}
+	if got := toolLineSnapshot()["amass"]; got != (toolLineStats{Rejected: 3, Repaired: 2}) {
+		t.Errorf("amass counts %+v, want 3 rejected, 2 repaired", got)
+	}
+}
+
+// TestParseWhatWebFixture replaces WhatWeb's invalid UTF-8 and drops the
+// entries with no target or an impossible status
+func TestParseWhatWebFixture(t *testing.T) {
+	clearToolLines(t)
+	raw, err := os.ReadFile(filepath.Join("testdata", "malformed", "whatweb.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, ok := parseWhatWeb("https://shop.example.com", raw)
+	if !ok || len(results) != 1 {
+		t.Fatalf("ok %v, %d entries kept", ok, len(results))
+	}
+	if s := results[0].Plugins["Title"].String; !slices.Equal(s, []string{"Caf� Shop"}) {
+		t.Errorf("title plugin %q", s)
+	}
+	if got := toolLineSnapshot()["whatweb"]; got != (toolLineStats{Rejected: 2, Repaired: 1}) {
+		t.Errorf("whatweb counts %+v, want 2 rejected, 1 repaired", got)
+	}
+
+	if _, ok := parseWhatWeb("https://shop.example.com", []byte("[{\"target\":")); ok {
+		t.Error("accepted truncated WhatWeb output")
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	for _, c := range []struct {
+		in, want string
+		max      int
+		changed  bool
+	}{
+		{"Login", "Login", 10, false},
+		{"  Login  ", "Login", 10, false},
+		{"\x1b[32mOK\x1b[0m", "OK", 10, true},
+		{"\x1b]0;window title\x07Page", "Page", 10, true},
+		{"a\tb\nc", "a b c", 10, true},
+		{"bell\x07", "bell", 10, true},
+		{"abcdefghij", "abcde", 5, true},
+	} {
+		got, changed := cleanText(c.in, c.max)
+		if got != c.want || changed != c.changed {
+			t.Errorf("cleanText(%q) = %q, %v; want %q, %v", c.in, got, changed, c.want, c.changed)
+		}
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	for in, want := range map[string]string{
+		"WWW.Example.COM.":   "www.example.com",
+		"*.example.com":      "*.example.com",
+		"_dmarc.example.com": "_dmarc.example.com",
+	} {
+		if got, err := cleanName(in); err != nil || got != want {
+			t.Errorf("cleanName(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", " . ", "a.*.example.com", "a b.example.com", "<x>.example.com", string(bytes.Repeat([]byte("a"), 254))} {
+		if _, err := cleanName(in); err == nil {
+			t.Errorf("cleanName(%q) accepted", in)
+		}
+	}
+}
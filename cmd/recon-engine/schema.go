@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// schemaVersion is stamped on every Result. Bump the major part when a
+// field is removed, renamed or changes type; the minor part when fields are
+// added.
+const schemaVersion = "2.42"
+
+// schemaEnums constrains fields that only take a fixed set of values
+var schemaEnums = map[string][]string{
+	"change_type":    {changeNew, changeChanged, changeVisual, changeRemoved, changeStale},
+	"severity":       {string(severityInfo), string(severityLow), string(severityMedium), string(severityHigh), string(severityCritical)},
+	"confidence":     {confidenceConfirmed, confidenceFirm, confidenceTentative},
+	"environment":    append(append([]string{}, environments...), envUnknown),
+	"access_control": accessClasses,
+}
+
+// schemaChangelog tells readers of older records what changed, newest first
+var schemaChangelog = []struct {
+	Version string `json:"version"`
+	Changes string `json:"changes"`
+}{
+	{"2.42", "vhost is set on -vhost results: the Host header the address was requested with (host), what set the answer apart (finding) and the name's own URL (name_url)."},
+	{"2.41", "authenticated marks a status, title, length and body hash taken from the page fetched with the host's -auth-file credentials."},
+	{"2.40", "fingerprint_inherited marks versions and technologies copied from the WhatWeb run of another host serving the same application."},
+	{"2.39", "soft_404 marks a root response indistinguishable from the host's answer to a random path, with -soft404."},
+	{"2.38", "interest_score ranks a host for triage by the weights of its hostname keywords, status, technologies, flags, versions and findings."},
+	{"2.37", "matches lists the -match-regex and -match-file patterns the response matched, with the part matched and the evidence."},
+	{"2.36", "dns_audit describes the root domain's DNSSEC, nameservers and SOA, with -dns-audit."},
+	{"2.35", "parameters lists the parameters -params discovered on the host."},
+	{"2.34", "first_seen and last_seen come from the -state history: when the subdomain first turned up and when it last answered a probe (RFC 3339)."},
+	{"2.33", "cluster_id groups hosts serving the same application, and cluster_members lists the hosts a result stands for with -collapse-clusters."},
+	{"2.32", "cookies lists the cookies the host set with their Secure, HttpOnly and SameSite attributes, with -cookie-audit."},
+	{"2.31", "jarm is the TLS fingerprint of an HTTPS host, with -jarm."},
+	{"2.30", "security_headers holds the host's security response headers and header_grade grades them from A to F, with -header-audit."},
+	{"2.29", "whois is the root domain's registration from RDAP or WHOIS, with -whois."},
+	{"2.28", "timings are the per-stage durations of a host in seconds, with -timings."},
+	{"2.27", "ptr is the reverse DNS name of the host's address, with -ptr."},
+	{"2.26", "cname is the host's CNAME target, from the probe or an -axfr zone transfer."},
+	{"2.25", "mail_posture is the root domain's SPF, DMARC and DKIM posture, with -mail-check."},
+	{"2.24", "flags names the triage rules a host matched, e.g. admin-panel or login-page."},
+	{"2.23", "security_txt is the host's parsed security.txt, with -security-txt."},
+	{"2.22", "robots_disallow lists the Disallow entries of the host's robots.txt and sitemap_urls the sitemaps it names, with -robots."},
+	{"2.21", "paths lists the paths -dirbrute found, each with its status and length."},
+	{"2.20", "open_ports lists the ports -portscan found open on the host's address, with the service, product and version nmap named; cdn names the CDN in front of the host. " +
+		"2.20 to 2.42 document fields written since before 2.1 that the changelog missed."},
+	{"2.19", "ips lists every address the host resolved to; ip remains the one it was probed on."},
+	{"2.18", "body_archive is the gzipped response body -archive-bodies kept of a flagged host, relative to the -workdir."},
+	{"2.17", "detection_source names, by technology, where -fingerprint found it: probe, whatweb or native."},
+	{"2.16", "parked marks a parked or for-sale domain and parked_by names the parking provider."},
+	{"2.15", "probe_skipped says why a host was not probed or enriched: the -exclude-ips address it resolved to, or the lookup that failed."},
+	{"2.14", "screenshot is the file -screenshots saved the page to and screenshot_hash its perceptual hash; visual_change pairs the previous and current screenshot, with change_type visual-change when nothing else changed."},
+	{"2.13", "historical_ips lists the addresses passive DNS saw the host on, with -historical-ips."},
+	{"2.12", "raw_ref points at the -keep-raw records a result was parsed from."},
+	{"2.11", "third_party marks a host served by a SaaS or CDN vendor, third_party_vendor, with -third-party; third_party_evidence is what showed it and cname_chain the names its CNAME records lead through."},
+	{"2.10", "access_control is what keeps an anonymous visitor from the page, with -access-control; access_evidence is what showed it and idp_host the identity provider an SSO redirect leads to."},
+	{"2.9", "cached_at marks a result taken from the -state-backend instead of probed: when the host was probed (RFC 3339)."},
+	{"2.8", "environment is prod, staging, dev, test, uat or unknown, going by the environment rules, and environment_evidence names the conditions that fired."},
+	{"2.7", "cert describes the host's certificate with -cert-check, and abandoned_candidate marks an expired one that comes with other signs of neglect."},
+	{"2.6", "tags carries the run's -tag and config file tags as an object of key and value."},
+	{"2.5", "blocked marks an answer that is a WAF block or challenge page, and block_vendor names whose."},
+	{"2.4", "known marks a host listed in -known."},
+	{"2.3", "probe_engine names what probed the host: httpx, or the native engine, whose tech_stack only has what the response headers name."},
+	{"2.2", "owner names the -owners team a host belongs to, \"unassigned\" when no pattern matches, and owner_contact how to reach it."},
+	{"2.1", "vulnerabilities entries are findings with id, title, severity (info, low, medium, high or critical), confidence (confirmed, firm or tentative), evidence, reference, stage and detected_at. " +
+		"Migration: read stage for source and title for summary, and stage-specific details such as cvss, product, url or nameserver from evidence. " +
+		"source, summary and a top-level copy of each evidence entry are still written and will be dropped in 3.0."},
+	{"2.0", "sources lists every discovery source of a name, e.g. [\"subfinder\", \"crtsh\"]. " +
+		"Breaking: source is no longer written for discovered hosts unless the scan runs with -legacy-source; read sources instead."},
+	{"1.0", "Result records carry schema_version."},
+}
+
+// resultSchema builds the JSON Schema of Result from its struct definition
+func resultSchema() map[string]interface{} {
+	s := typeSchema(reflect.TypeOf(Result{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = "https://github.com/NicholasGodwin34/macsecurity/schema/result-" + schemaVersion + ".json"
+	s["title"] = "recon-engine Result"
+	props := s["properties"].(map[string]interface{})
+	props["schema_version"] = map[string]interface{}{"type": "string", "const": schemaVersion}
+	return s
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		return typeSchema(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fs := typeSchema(f.Type)
+			if enum, ok := schemaEnums[name]; ok {
+				fs["enum"] = enum
+			}
+			omitempty := strings.Contains(opts, "omitempty")
+			if k := f.Type.Kind(); !omitempty && (k == reflect.Slice || k == reflect.Map) {
+				// nil slices and maps encode as null
+				fs["type"] = []string{fs["type"].(string), "null"}
+			}
+			props[name] = fs
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]interface{}{}
+}
+
+// runSchema implements the schema subcommand
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	changelog := fs.Bool("changelog", false, "Print what changed in each schema version, with migration notes, instead")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s schema [-changelog]\n\nPrints the JSON Schema of result records (schema_version %s).\n", os.Args[0], schemaVersion)
+	}
+	fs.Parse(args)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	var doc interface{} = resultSchema()
+	if *changelog {
+		doc = schemaChangelog
+	}
+	if err := enc.Encode(doc); err != nil {
+		fatalError("Failed to write schema", err)
+	}
+}
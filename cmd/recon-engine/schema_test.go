@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// validateSchema checks v, decoded JSON, against the subset of JSON Schema
+// resultSchema emits. The record itself may only carry declared
+// properties, so a Result field the schema does not know about fails;
+// nested objects may carry more, as JSON Schema allows, which findings do
+// for readers of older schemas.
+func validateSchema(path string, schema map[string]interface{}, v interface{}) error {
+	if c, ok := schema["const"]; ok && v != c {
+		return fmt.Errorf("%s: %v, want %v", path, v, c)
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		if s, _ := v.(string); !slices.Contains(enum, s) {
+			return fmt.Errorf("%s: %v not in %v", path, v, enum)
+		}
+	}
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []string:
+		types = t
+	case nil:
+		return nil
+	}
+	var got string
+	switch v.(type) {
+	case nil:
+		got = "null"
+	case bool:
+		got = "boolean"
+	case string:
+		got = "string"
+	case float64:
+		got = "number"
+		if n := v.(float64); n == float64(int64(n)) && slices.Contains(types, "integer") {
+			got = "integer"
+		}
+	case []interface{}:
+		got = "array"
+	case map[string]interface{}:
+		got = "object"
+	}
+	if !slices.Contains(types, got) {
+		return fmt.Errorf("%s: %s, want %v", path, got, types)
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		for i, e := range v {
+			if err := validateSchema(fmt.Sprintf("%s[%d]", path, i), items, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if extra, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			for k, e := range v {
+				if err := validateSchema(path+"."+k, extra, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for k, e := range v {
+			ps, ok := props[k].(map[string]interface{})
+			if !ok {
+				if path == "result" {
+					return fmt.Errorf("%s.%s: not in the schema", path, k)
+				}
+				continue
+			}
+			if err := validateSchema(path+"."+k, ps, e); err != nil {
+				return err
+			}
+		}
+		required, _ := schema["required"].([]string)
+		for _, k := range required {
+			if _, ok := v[k]; !ok {
+				return fmt.Errorf("%s.%s: required", path, k)
+			}
+		}
+	}
+	return nil
+}
+
+// TestResultSchema validates sample records against the emitted schema so
+// the two cannot drift apart
+func TestResultSchema(t *testing.T) {
+	schema := resultSchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	live := Result{
+		SchemaVersion:   schemaVersion,
+		RunID:           "run-1",
+		Subdomain:       "app.example.com",
+		RootDomain:      "example.com",
+		Sources:         []string{"subfinder", "crtsh"},
+		URL:             "https://app.example.com",
+		StatusCode:      200,
+		Title:           "App",
+		TechStack:       []string{"nginx"},
+		Versions:        map[string]string{"nginx": "1.25.3"},
+		IP:              "192.0.2.10",
+		IPs:             []string{"192.0.2.10", "2001:db8::10"},
+		Flags:           []string{"login-page"},
+		Tags:            map[string]string{"team": "web"},
+		ChangeType:      changeNew,
+		InterestScore:   42,
+		Timestamp:       "2024-01-02T03:04:05Z",
+		RedirectChain:   []string{"https://app.example.com/login"},
+		Vulnerabilities: []Finding{newFinding("cors", "cors-reflected-origin", "high", confidenceConfirmed, "Reflected origin", map[string]interface{}{"origin": "https://evil.example"})},
+	}
+	dead := Result{SchemaVersion: schemaVersion, Subdomain: "old.example.com", RootDomain: "example.com"}
+	for _, res := range []Result{live, dead} {
+		b, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateSchema("result", schema, v); err != nil {
+			t.Errorf("%s: %v\n%s", res.Subdomain, err, b)
+		}
+	}
+
+	bad := map[string]interface{}{"schema_version": "0.1"}
+	if validateSchema("result", schema, bad) == nil {
+		t.Error("a record of another schema_version validated")
+	}
+}
+
+func TestSchemaChangelog(t *testing.T) {
+	if schemaChangelog[0].Version != schemaVersion {
+		t.Errorf("newest changelog entry is %s, schema_version %s", schemaChangelog[0].Version, schemaVersion)
+	}
+	for i := 1; i < len(schemaChangelog); i++ {
+		if compareVersions(schemaChangelog[i-1].Version, schemaChangelog[i].Version) <= 0 {
+			t.Errorf("changelog %s is listed before %s", schemaChangelog[i-1].Version, schemaChangelog[i].Version)
+		}
+	}
+}
+
+// TestSchemaChangelogFields names every field added since 1.0 in the
+// changelog entry that added it
+func TestSchemaChangelogFields(t *testing.T) {
+	since := make(map[string]bool)
+	for _, name := range strings.Fields("run_id root_domain engine_version schema_version timestamp subdomain url port status_code title tech_stack vulnerabilities source subfinder_sources ip shared_hosting asn org country city versions version_confidence censys_services final_url redirect_chain redirects_off_scope content_length response_time_ms body_sha256 duplicate_of change_type changes") {
+		since[name] = true
+	}
+	named := regexp.MustCompile(`\b[a-z][a-z0-9]*(_[a-z0-9]+)*\b`)
+	for _, e := range schemaChangelog {
+		for _, name := range named.FindAllString(e.Changes, -1) {
+			since[name] = true
+		}
+	}
+	for name := range resultSchema()["properties"].(map[string]interface{}) {
+		if !since[name] {
+			t.Errorf("%s is in no changelog entry", name)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// scopeNoAllowRule is the scope_drops key for names no allow rule covers
+const scopeNoAllowRule = "no allow rule"
+
+// scopeRule is one line of a -scope file
+type scopeRule struct {
+	label    string // line number and text, as reported in scope_drops
+	deny     bool
+	wildcard bool // *.example.com: every name below example.com
+	host     string
+	prefix   netip.Prefix // address exclusions; host is empty
+}
+
+func (r scopeRule) matchName(name string) bool {
+	if r.host == "" {
+		return false
+	}
+	if r.wildcard {
+		return strings.HasSuffix(name, "."+r.host)
+	}
+	return name == r.host
+}
+
+// scopeMatcher decides what active stages may touch. Exclusions win over
+// allow rules; with no allow rules at all everything not excluded is
+// allowed.
+type scopeMatcher struct {
+	rules    []scopeRule
+	allowAny bool // no allow rules
+	ipRules  bool // names must be resolved to be judged
+	drops    map[string]int
+	dropsMu  sync.Mutex
+}
+
+// scope is set by -scope; nil means everything is in scope
+var scope *scopeMatcher
+
+// parseScope reads a scope file: one rule per line, # comments, ! for
+// exclusions, *.example.com for every name below example.com and CIDR
+// ranges or addresses to exclude by resolved IP
+func parseScope(r io.Reader, name string) (*scopeMatcher, error) {
+	m := &scopeMatcher{allowAny: true, drops: make(map[string]int)}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		rule, err := parseScopeRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		rule.label = "line " + strconv.Itoa(n) + ": " + line
+		if !rule.deny {
+			m.allowAny = false
+		}
+		if rule.prefix.IsValid() {
+			m.ipRules = true
+		}
+		m.rules = append(m.rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(m.rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules", name)
+	}
+	return m, nil
+}
+
+func parseScopeRule(line string) (scopeRule, error) {
+	var rule scopeRule
+	body, deny := strings.CutPrefix(line, "!")
+	rule.deny = deny
+	body = strings.ToLower(strings.TrimSpace(body))
+
+	if strings.Contains(body, "/") {
+		p, err := netip.ParsePrefix(body)
+		if err != nil {
+			return rule, fmt.Errorf("%q is not a CIDR range", body)
+		}
+		rule.prefix = p.Masked()
+	} else if a, err := netip.ParseAddr(body); err == nil {
+		rule.prefix = netip.PrefixFrom(a, a.BitLen())
+	}
+	if rule.prefix.IsValid() {
+		if !deny {
+			return rule, fmt.Errorf("address ranges can only be excluded (!%s)", body)
+		}
+		return rule, nil
+	}
+
+	host, wildcard := strings.CutPrefix(body, "*.")
+	host = strings.TrimSuffix(host, ".")
+	if !validScopeHost(host) {
+		return rule, fmt.Errorf("%q is not a host name, *.domain wildcard or CIDR range", body)
+	}
+	rule.host, rule.wildcard = host, wildcard
+	return rule, nil
+}
+
+func validScopeHost(host string) bool {
+	if host == "" || !strings.Contains(host, ".") {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// configureScope loads -scope. Called once after flag parsing, before
+// anything touches the network.
+func configureScope() error {
+	if scopePath == "" {
+		return nil
+	}
+	f, err := os.Open(scopePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scope, err = parseScope(f, scopePath)
+	return err
+}
+
+// nameRule returns the rule that excludes name, or "" when it is allowed
+func (m *scopeMatcher) nameRule(name string) string {
+	allowed := m.allowAny
+	for _, r := range m.rules {
+		if !r.matchName(name) {
+			continue
+		}
+		if r.deny {
+			return r.label
+		}
+		allowed = true
+	}
+	if !allowed {
+		return scopeNoAllowRule
+	}
+	return ""
+}
+
+// ipRule returns the rule that excludes ip, or "" when it is allowed
+func (m *scopeMatcher) ipRule(ip string) string {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	for _, r := range m.rules {
+		if r.prefix.IsValid() && r.prefix.Contains(a.Unmap()) {
+			return r.label
+		}
+	}
+	return ""
+}
+
+func (m *scopeMatcher) drop(rule string) bool {
+	m.dropsMu.Lock()
+	m.drops[rule]++
+	m.dropsMu.Unlock()
+	return false
+}
+
+// scopeAllowsName reports whether a discovered name may be probed by the
+// name rules. The address rules are applied by the admitter, which
+// resolves the name first.
+func scopeAllowsName(name string) bool {
+	if scope == nil {
+		return true
+	}
+	if rule := scope.nameRule(strings.ToLower(strings.TrimSuffix(name, "."))); rule != "" {
+		return scope.drop(rule)
+	}
+	return true
+}
+
+// scopeAllowsAddrs reports whether a name resolving to addrs may be probed
+// by the address rules
+func scopeAllowsAddrs(addrs []string) bool {
+	if scope == nil {
+		return true
+	}
+	for _, ip := range addrs {
+		if rule := scope.ipRule(ip); rule != "" {
+			return scope.drop(rule)
+		}
+	}
+	return true
+}
+
+// scopeAllowsIP reports whether an address may be probed or scanned, by
+// -scope and -exclude-ips
+func scopeAllowsIP(ip string) bool {
+	if p, ok := excludedPrefix(ip); ok {
+		countExclusion(p, ip)
+		return false
+	}
+	if scope == nil {
+		return true
+	}
+	if rule := scope.ipRule(ip); rule != "" {
+		return scope.drop(rule)
+	}
+	return true
+}
+
+// scopeAllowsHost judges a URL host without resolving it: addresses by the
+// exclusions, names by the name rules
+func scopeAllowsHost(host string) bool {
+	if scope == nil {
+		return true
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return scopeAllowsIP(host)
+	}
+	if rule := scope.nameRule(strings.ToLower(strings.TrimSuffix(host, "."))); rule != "" {
+		return scope.drop(rule)
+	}
+	return true
+}
+
+// scopeDrops returns how many names and addresses each rule kept away from
+// active stages this run
+func scopeDrops() map[string]int {
+	if scope == nil {
+		return nil
+	}
+	scope.dropsMu.Lock()
+	defer scope.dropsMu.Unlock()
+	if len(scope.drops) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(scope.drops))
+	for k, v := range scope.drops {
+		out[k] = v
+	}
+	return out
+}
+
+// resetScopeDrops clears the counts for a new monitor iteration
+func resetScopeDrops() {
+	if scope == nil {
+		return
+	}
+	scope.dropsMu.Lock()
+	scope.drops = make(map[string]int)
+	scope.dropsMu.Unlock()
+}
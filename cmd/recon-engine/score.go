@@ -0,0 +1,166 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/score.yaml
+var defaultScoreWeights []byte
+
+// scoreWeights are the interest score weights of rules/score.yaml
+type scoreWeights struct {
+	Keywords map[string]int `yaml:"keywords"`
+	Keyword  *int           `yaml:"keyword"`
+	Status   map[string]int `yaml:"status"`
+	Tech     *int           `yaml:"tech"`
+	Flag     *int           `yaml:"flag"`
+	Versions *int           `yaml:"versions"`
+	Severity map[string]int `yaml:"severity"`
+	// Environment weighs the environment a result is classified as
+	Environment map[string]int `yaml:"environment"`
+}
+
+// weights are the embedded weights with -score-weights and -score-keywords
+// applied
+var weights scoreWeights
+
+// configureScore loads the embedded weights, -score-weights and
+// -score-keywords. Called once after flag parsing.
+func configureScore() error {
+	w, err := parseScoreWeights(defaultScoreWeights)
+	if err != nil {
+		return fmt.Errorf("embedded weights: %w", err)
+	}
+	if scoreWeightsPath != "" {
+		data, err := os.ReadFile(scoreWeightsPath)
+		if err != nil {
+			return err
+		}
+		more, err := parseScoreWeights(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", scoreWeightsPath, err)
+		}
+		w.merge(more)
+	}
+	for _, k := range splitList(scoreKeywords) {
+		w.Keywords[strings.ToLower(k)] = intOr(w.Keyword)
+	}
+	weights = w
+	return nil
+}
+
+// parseScoreWeights reads a weights file, lower-casing its keys and
+// checking the status keys
+func parseScoreWeights(data []byte) (scoreWeights, error) {
+	var w scoreWeights
+	if err := yaml.Unmarshal(data, &w); err != nil {
+		return w, err
+	}
+	for _, m := range []*map[string]int{&w.Keywords, &w.Status, &w.Severity, &w.Environment} {
+		lower := make(map[string]int, len(*m))
+		for k, v := range *m {
+			lower[strings.ToLower(strings.TrimSpace(k))] = v
+		}
+		*m = lower
+	}
+	for k := range w.Status {
+		if !statusKey(k) {
+			return w, fmt.Errorf("status %q: want a code such as 403 or a class such as 4xx", k)
+		}
+	}
+	return w, nil
+}
+
+// statusKey validates a status weight key, a code or a class
+func statusKey(k string) bool {
+	if len(k) == 3 && k[0] >= '1' && k[0] <= '5' && k[1:] == "xx" {
+		return true
+	}
+	code, err := strconv.Atoi(k)
+	return err == nil && code >= 100 && code <= 599
+}
+
+// merge sets the weights more has on w
+func (w *scoreWeights) merge(more scoreWeights) {
+	for k, v := range more.Keywords {
+		w.Keywords[k] = v
+	}
+	for k, v := range more.Status {
+		w.Status[k] = v
+	}
+	for k, v := range more.Severity {
+		w.Severity[k] = v
+	}
+	for k, v := range more.Environment {
+		w.Environment[k] = v
+	}
+	for _, p := range []struct{ dst, src **int }{
+		{&w.Keyword, &more.Keyword}, {&w.Tech, &more.Tech}, {&w.Flag, &more.Flag}, {&w.Versions, &more.Versions},
+	} {
+		if *p.src != nil {
+			*p.dst = *p.src
+		}
+	}
+}
+
+// interestScore ranks res for triage: the weights of its hostname
+// keywords, status, technologies, flags, versions and findings added up,
+// never below 0. It only depends on res and the weights.
+func interestScore(res Result) int {
+	score := 0
+	for _, k := range hostKeywords(res.Subdomain, res.RootDomain) {
+		score += weights.Keywords[k]
+	}
+	if res.StatusCode > 0 {
+		if v, ok := weights.Status[strconv.Itoa(res.StatusCode)]; ok {
+			score += v
+		} else {
+			score += weights.Status[strconv.Itoa(res.StatusCode/100)+"xx"]
+		}
+	}
+	score += len(res.TechStack)*intOr(weights.Tech) + len(res.Flags)*intOr(weights.Flag)
+	if len(res.Versions) > 0 {
+		score += intOr(weights.Versions)
+	}
+	for _, v := range res.Vulnerabilities {
+		score += weights.Severity[string(v.Severity)]
+	}
+	score += weights.Environment[res.Environment]
+	return max(score, 0)
+}
+
+// hostKeywords returns the distinct labels of host left of root, split on
+// dots, dashes and underscores with trailing digits dropped, that are
+// weighted keywords
+func hostKeywords(host, root string) []string {
+	host, root = strings.ToLower(host), strings.ToLower(root)
+	if root != "" {
+		if host == root {
+			return nil
+		}
+		host = strings.TrimSuffix(host, "."+root)
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, part := range strings.FieldsFunc(host, func(r rune) bool { return r == '.' || r == '-' || r == '_' }) {
+		part = strings.TrimRight(part, "0123456789")
+		if _, ok := weights.Keywords[part]; ok && !seen[part] {
+			seen[part] = true
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func intOr(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
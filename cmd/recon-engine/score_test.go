@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func loadScoreWeights(t *testing.T, path, keywords string) {
+	t.Helper()
+	oldPath, oldKeywords, oldWeights := scoreWeightsPath, scoreKeywords, weights
+	t.Cleanup(func() { scoreWeightsPath, scoreKeywords, weights = oldPath, oldKeywords, oldWeights })
+	scoreWeightsPath, scoreKeywords = path, keywords
+	if err := configureScore(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestInterestScore scores a fixture set against the embedded weights
+func TestInterestScore(t *testing.T) {
+	loadScoreWeights(t, "", "")
+	for _, c := range []struct {
+		res  Result
+		want int
+	}{
+		// vpn 20 + admin 25 + old 10, 403 10
+		{Result{Subdomain: "vpn-admin-old.example.com", RootDomain: "example.com", StatusCode: 403}, 65},
+		// cdn -10 + assets -10, 200 10: never below 0
+		{Result{Subdomain: "cdn-assets-37.example.com", RootDomain: "example.com", StatusCode: 200}, 0},
+		// jenkins 25 counted once, 401 15, 2 technologies 4, 1 flag 15, versions 5
+		{Result{Subdomain: "jenkins2.jenkins.example.com", RootDomain: "example.com", StatusCode: 401, TechStack: []string{"jenkins", "java"}, Flags: []string{"login-page"}, Versions: map[string]string{"jenkins": "2.426"}}, 64},
+		// staging 15 + staging environment 10, 302 2
+		{Result{Subdomain: "staging.example.com", RootDomain: "example.com", StatusCode: 302, Environment: "staging"}, 27},
+		// The root's own labels are not keywords of its hosts
+		{Result{Subdomain: "www.admin.com", RootDomain: "admin.com", StatusCode: 418}, 0},
+		{Result{Subdomain: "admin.com", RootDomain: "admin.com"}, 0},
+		// db 20, 500 8, critical 60 + info 2
+		{Result{Subdomain: "db.example.com", RootDomain: "example.com", StatusCode: 500, Vulnerabilities: []Finding{
+			newFinding("nuclei", "cve-2021-44228", "critical", confidenceConfirmed, "", nil),
+			newFinding("headers", "missing-hsts", "info", confidenceConfirmed, "", nil),
+		}}, 90},
+		// Dead hosts score their name only
+		{Result{Subdomain: "backup.example.com", RootDomain: "example.com"}, 25},
+	} {
+		got := interestScore(c.res)
+		if got != c.want {
+			t.Errorf("%s: score %d, want %d", c.res.Subdomain, got, c.want)
+		}
+		// Deterministic: the same result scores the same every time
+		if again := interestScore(c.res); again != got {
+			t.Errorf("%s: score changed between calls", c.res.Subdomain)
+		}
+	}
+}
+
+func TestHostKeywords(t *testing.T) {
+	loadScoreWeights(t, "", "")
+	got := hostKeywords("VPN-admin_old2.dev.Example.com", "example.com")
+	if want := []string{"vpn", "admin", "old", "dev"}; !slices.Equal(got, want) {
+		t.Errorf("keywords %v, want %v", got, want)
+	}
+}
+
+// TestScoreWeightsFile overrides weights with -score-weights and adds
+// keywords with -score-keywords
+func TestScoreWeightsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "weights.yaml")
+	weightsFile := "keywords:\n  Payroll: 40\n  cdn: 0\nstatus:\n  403: 1\nkeyword: 30\n"
+	if err := os.WriteFile(path, []byte(weightsFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadScoreWeights(t, path, "acme,Billing")
+	for _, c := range []struct {
+		host   string
+		status int
+		want   int
+	}{
+		{"payroll.example.com", 0, 40},
+		{"cdn.example.com", 0, 0},
+		{"acme-billing.example.com", 0, 60},
+		// 403 overridden, 2xx kept
+		{"x.example.com", 403, 1},
+		{"x.example.com", 200, 10},
+	} {
+		if got := interestScore(Result{Subdomain: c.host, RootDomain: "example.com", StatusCode: c.status}); got != c.want {
+			t.Errorf("%s %d: score %d, want %d", c.host, c.status, got, c.want)
+		}
+	}
+}
+
+func TestParseScoreWeightsRejects(t *testing.T) {
+	for _, data := range []string{
+		"status:\n  600: 5\n",
+		"status:\n  4x: 5\n",
+		"keywords: [admin]\n",
+	} {
+		if _, err := parseScoreWeights([]byte(data)); err == nil {
+			t.Errorf("accepted %q", data)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math/bits"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Screenshots are taken by headless Chromium at this viewport; -visual-ignore
+// regions are in its pixels
+const (
+	screenshotWidth  = 1280
+	screenshotHeight = 800
+)
+
+// defaultVisualThreshold is how many of the 64 hash bits may differ before
+// a screenshot counts as changed. Clocks, carousels and rotating banners
+// flip a few bits at most.
+const defaultVisualThreshold = 10
+
+// VisualChange is set on a -diff or -monitor record whose screenshot looks
+// different from the previous run's: both screenshots and how many bits of
+// their perceptual hashes differ
+type VisualChange struct {
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+	Distance int    `json:"distance"`
+}
+
+// visualIgnore is an entry of the -visual-ignore file: regions blanked
+// before hashing the screenshots of hosts matching Host, or of every host
+// when it is empty
+type visualIgnore struct {
+	Host    string   `yaml:"host"`
+	Regions [][4]int `yaml:"regions"` // x, y, width, height
+}
+
+var (
+	visualThreshold   = defaultVisualThreshold
+	visualIgnores     []visualIgnore
+	screenshotLimiter *tokenBucket
+)
+
+// configureScreenshots checks the screenshot options and loads
+// -visual-ignore. Called once after flag parsing.
+func configureScreenshots() error {
+	if !screenshotsFlag {
+		if visualIgnorePath != "" {
+			return fmt.Errorf("-visual-ignore needs -screenshots")
+		}
+		return nil
+	}
+	if screenshotKeep < 2 {
+		return fmt.Errorf("-screenshot-keep must be at least 2 to keep the previous screenshot, got %d", screenshotKeep)
+	}
+	if visualThreshold < 0 || visualThreshold > 64 {
+		return fmt.Errorf("-visual-threshold must be between 0 and 64, got %d", visualThreshold)
+	}
+	if screenshotRate < 0 {
+		return fmt.Errorf("-screenshot-rate must not be negative, got %d", screenshotRate)
+	}
+	if screenshotDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("no home directory for the default, set -screenshot-dir: %w", err)
+		}
+		screenshotDir = filepath.Join(home, ".recon-engine", "screenshots")
+	}
+	if visualIgnorePath != "" {
+		data, err := os.ReadFile(visualIgnorePath)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &visualIgnores); err != nil {
+			return fmt.Errorf("%s: %w", visualIgnorePath, err)
+		}
+		for i, ig := range visualIgnores {
+			if _, err := path.Match(ig.Host, ""); err != nil {
+				return fmt.Errorf("%s: entry %d: host %q: %w", visualIgnorePath, i+1, ig.Host, err)
+			}
+			for _, r := range ig.Regions {
+				if r[2] <= 0 || r[3] <= 0 {
+					return fmt.Errorf("%s: entry %d: region %v needs a positive width and height", visualIgnorePath, i+1, r)
+				}
+			}
+		}
+	}
+	screenshotLimiter = newTokenBucket(screenshotRate)
+	return nil
+}
+
+// takeScreenshot saves a screenshot of res's page under -screenshot-dir and
+// sets res.Screenshot and res.ScreenshotHash, keeping the host's last
+// -screenshot-keep screenshots. Replayed runs have no pages to render.
+func takeScreenshot(ctx context.Context, res *Result) {
+	if replayDir != "" {
+		return
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	if screenshotLimiter.Wait(ctx) != nil || waitProxy(ctx) != nil {
+		return
+	}
+	dir := filepath.Join(screenshotDir, screenshotHostDir(u))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		reportToolError("chromium", "enrich", "", err)
+		return
+	}
+	file := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000000000Z")+".png")
+
+	args := []string{
+		"--headless=new", "--disable-gpu", "--no-sandbox", "--hide-scrollbars", "--mute-audio",
+		"--ignore-certificate-errors", "--no-first-run", "--disable-extensions",
+		fmt.Sprintf("--window-size=%d,%d", screenshotWidth, screenshotHeight),
+		"--screenshot=" + file,
+	}
+	if p := nextProxy(); p != nil && p.User == nil {
+		args = append(args, "--proxy-server="+p.Scheme+"://"+p.Host)
+	}
+	if userAgent != "" {
+		args = append(args, "--user-agent="+userAgent)
+	}
+	shotCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := toolCommand(shotCtx, toolPath("chromium"), append(args, res.URL)...)
+	cmd.WaitDelay = 5 * time.Second
+	err = runTool(cmd)
+	switch {
+	case ctx.Err() != nil:
+		os.Remove(file)
+		return
+	case shotCtx.Err() == context.DeadlineExceeded:
+		os.Remove(file)
+		reportToolError("chromium", "enrich", eventTimeout, fmt.Errorf("%s: no screenshot within 30s", res.URL))
+		return
+	case err != nil:
+		os.Remove(file)
+		reportToolError("chromium", "enrich", "", fmt.Errorf("%s: %w", res.URL, err))
+		return
+	}
+
+	hash, err := screenshotHash(file, visualIgnoreRegions(u.Hostname()))
+	if err != nil {
+		os.Remove(file)
+		reportToolError("chromium", "enrich", eventParseFailed, fmt.Errorf("%s: %w", res.URL, err))
+		return
+	}
+	res.Screenshot = file
+	res.ScreenshotHash = fmt.Sprintf("%016x", hash)
+	stats.Add("screenshots.taken", 1)
+	pruneScreenshots(dir, screenshotKeep)
+}
+
+// screenshotHostDir names the directory of a host's screenshots after its
+// host and port
+func screenshotHostDir(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' {
+			return c
+		}
+		return '_'
+	}, strings.ToLower(u.Hostname())) + "_" + port
+}
+
+// pruneScreenshots deletes all but the newest keep screenshots in dir. The
+// file names sort by the time they were taken.
+func pruneScreenshots(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var shots []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".png") {
+			shots = append(shots, e.Name())
+		}
+	}
+	sort.Strings(shots)
+	for len(shots) > keep {
+		os.Remove(filepath.Join(dir, shots[0]))
+		stats.Add("screenshots.pruned", 1)
+		shots = shots[1:]
+	}
+}
+
+// visualIgnoreRegions returns the -visual-ignore regions for host
+func visualIgnoreRegions(host string) []image.Rectangle {
+	var rects []image.Rectangle
+	for _, ig := range visualIgnores {
+		if ig.Host != "" {
+			if ok, _ := path.Match(ig.Host, host); !ok {
+				continue
+			}
+		}
+		for _, r := range ig.Regions {
+			rects = append(rects, image.Rect(r[0], r[1], r[0]+r[2], r[1]+r[3]))
+		}
+	}
+	return rects
+}
+
+// screenshotHash computes the difference hash of the PNG at file: the image
+// is shrunk to 9x8 cells of average brightness, leaving out the pixels of
+// the ignore regions, and each bit tells whether a cell is darker than its
+// right neighbour. Similar pages get hashes a few bits apart.
+func screenshotHash(file string, ignore []image.Rectangle) (uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+	return dHash(img, ignore), nil
+}
+
+func dHash(img image.Image, ignore []image.Rectangle) uint64 {
+	const cols, rows = 9, 8
+	b := img.Bounds()
+	var sum [rows][cols]float64
+	var n [rows][cols]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) * rows / b.Dy()
+	pixels:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := image.Pt(x-b.Min.X, y-b.Min.Y)
+			for _, r := range ignore {
+				if p.In(r) {
+					continue pixels
+				}
+			}
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			col := (x - b.Min.X) * cols / b.Dx()
+			sum[row][col] += 0.299*float64(cr) + 0.587*float64(cg) + 0.114*float64(cb)
+			n[row][col]++
+		}
+	}
+	var hash uint64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols-1; col++ {
+			hash <<= 1
+			if cellMean(sum[row][col], n[row][col]) < cellMean(sum[row][col+1], n[row][col+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func cellMean(sum float64, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// visualDistance returns how many bits the screenshot hashes of prev and
+// cur differ in, and whether that exceeds -visual-threshold. Records
+// without a hash, from runs without -screenshots, never differ.
+func visualDistance(prev, cur Result) (int, bool) {
+	if prev.ScreenshotHash == "" || cur.ScreenshotHash == "" {
+		return 0, false
+	}
+	a, err1 := strconv.ParseUint(prev.ScreenshotHash, 16, 64)
+	b, err2 := strconv.ParseUint(cur.ScreenshotHash, 16, 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	d := bits.OnesCount64(a ^ b)
+	return d, d > visualThreshold
+}
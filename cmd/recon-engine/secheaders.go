@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// securityHeaderNames are the response headers -header-audit reports
+var securityHeaderNames = []string{
+	"strict-transport-security",
+	"content-security-policy",
+	"x-frame-options",
+	"x-content-type-options",
+	"referrer-policy",
+	"permissions-policy",
+}
+
+// hstsMinMaxAge is the shortest HSTS max-age that is not penalised: six
+// months, as the Mozilla Observatory has it
+const hstsMinMaxAge = 15552000
+
+// headerGrades are the grades from best to worst with the lowest score
+// each needs
+var headerGrades = []struct {
+	grade string
+	min   int
+}{
+	{"A", 90}, {"B", 70}, {"C", 55}, {"D", 40}, {"F", 0},
+}
+
+// headerAuditHTTP does not follow redirects: the final URL is already known
+var headerAuditHTTP = func() *http.Client {
+	c := newHTTPClient(10*time.Second, false)
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// auditHeaders sets res.SecurityHeaders and res.HeaderGrade. The headers
+// httpx reported are graded unless the host redirected, in which case the
+// page the redirects end on is fetched and graded instead: a host that only
+// redirects to HTTPS serves no content its own headers protect. Nothing is
+// graded when that page is off scope or does not answer.
+func auditHeaders(ctx context.Context, res *Result) {
+	rawURL, h := res.URL, res.headers
+	if res.StatusCode >= 300 && res.StatusCode < 400 {
+		final := res.FinalURL
+		if final == "" {
+			final, _ = followRedirects(ctx, res.URL)
+		}
+		u, err := url.Parse(final)
+		if err != nil || u.Hostname() == "" || !scopeAllowsHost(u.Hostname()) {
+			return
+		}
+		rawURL, h = final, nil
+	}
+	if h == nil {
+		var status int
+		var err error
+		if status, h, err = fetchHeaders(ctx, rawURL); err != nil || (status >= 300 && status < 400) {
+			return
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	found := make(map[string]string)
+	for _, name := range securityHeaderNames {
+		if v := h[headerKey(name)]; v != "" {
+			found[name] = v
+		}
+	}
+	if len(found) > 0 {
+		res.SecurityHeaders = found
+	}
+	res.HeaderGrade = headerGrade(headerScore(u.Scheme == "https", found))
+}
+
+// fetchHeaders GETs rawURL and returns its status and its headers keyed
+// the way httpxHeaders keys them
+func fetchHeaders(ctx context.Context, rawURL string) (int, map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := headerAuditHTTP.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	h := make(map[string]string, len(resp.Header))
+	for k, v := range resp.Header {
+		h[headerKey(k)] = strings.Join(v, ", ")
+	}
+	return resp.StatusCode, h, nil
+}
+
+// headerScore scores a response's security headers out of 100, keyed by
+// the names in securityHeaderNames. The penalties loosely follow the
+// Mozilla Observatory: missing HSTS (or no HTTPS at all) and missing CSP
+// weigh most, a CSP that allows inline or eval'd script counts for little
+// more than none, and framing must be restricted by X-Frame-Options or
+// frame-ancestors.
+func headerScore(https bool, h map[string]string) int {
+	score := 100
+
+	if !https {
+		score -= 20
+	} else if maxAge, ok := hstsMaxAge(h["strict-transport-security"]); !ok {
+		score -= 20
+	} else if maxAge < hstsMinMaxAge {
+		score -= 10
+	}
+
+	csp := parseCSP(h["content-security-policy"])
+	if csp == nil {
+		score -= 25
+	} else {
+		script, ok := csp["script-src"]
+		if !ok {
+			script, ok = csp["default-src"]
+		}
+		switch {
+		case !ok:
+			score -= 20
+		case cspUnsafeInline(script):
+			score -= 20
+		case cspHas(script, "'unsafe-eval'"):
+			score -= 10
+		}
+	}
+
+	xfo := strings.ToLower(strings.TrimSpace(h["x-frame-options"]))
+	if _, framed := csp["frame-ancestors"]; !framed && xfo != "deny" && xfo != "sameorigin" {
+		score -= 20
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(h["x-content-type-options"]), "nosniff") {
+		score -= 5
+	}
+
+	// The last policy listed is the one browsers apply
+	policies := strings.Split(h["referrer-policy"], ",")
+	switch strings.ToLower(strings.TrimSpace(policies[len(policies)-1])) {
+	case "unsafe-url", "no-referrer-when-downgrade":
+		score -= 5
+	}
+
+	return max(score, 0)
+}
+
+// headerGrade turns a headerScore into a grade from A to F
+func headerGrade(score int) string {
+	for _, g := range headerGrades {
+		if score >= g.min {
+			return g.grade
+		}
+	}
+	return "F"
+}
+
+// gradeRank orders grades from 0 for A; it is -1 for anything else
+func gradeRank(grade string) int {
+	for i, g := range headerGrades {
+		if strings.EqualFold(g.grade, grade) {
+			return i
+		}
+	}
+	return -1
+}
+
+// hstsMaxAge returns the max-age of a Strict-Transport-Security value
+func hstsMaxAge(v string) (int, bool) {
+	for _, d := range strings.Split(v, ";") {
+		k, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if strings.EqualFold(strings.TrimSpace(k), "max-age") {
+			n, err := strconv.Atoi(strings.Trim(strings.TrimSpace(val), `"`))
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// parseCSP returns the directives of a Content-Security-Policy with their
+// sources, or nil when there is none. Only the first policy of a repeated
+// header is read.
+func parseCSP(v string) map[string][]string {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	csp := make(map[string][]string)
+	for _, d := range strings.Split(v, ";") {
+		fields := strings.Fields(strings.ToLower(d))
+		if len(fields) == 0 {
+			continue
+		}
+		// The first occurrence of a directive wins
+		if _, seen := csp[fields[0]]; !seen {
+			csp[fields[0]] = fields[1:]
+		}
+	}
+	return csp
+}
+
+// cspUnsafeInline reports whether script sources allow inline script.
+// Browsers ignore 'unsafe-inline' once a nonce or hash is listed.
+func cspUnsafeInline(sources []string) bool {
+	if !cspHas(sources, "'unsafe-inline'") {
+		return false
+	}
+	for _, s := range sources {
+		if strings.HasPrefix(s, "'nonce-") || strings.HasPrefix(s, "'sha256-") || strings.HasPrefix(s, "'sha384-") || strings.HasPrefix(s, "'sha512-") {
+			return false
+		}
+	}
+	return true
+}
+
+func cspHas(sources []string, s string) bool {
+	for _, src := range sources {
+		if src == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// goodHeaders score 100 over HTTPS, with the changes in set applied
+func goodHeaders(set map[string]string) map[string]string {
+	h := map[string]string{
+		"strict-transport-security": "max-age=31536000; includeSubDomains",
+		"content-security-policy":   "default-src 'self'; frame-ancestors 'none'",
+		"x-content-type-options":    "nosniff",
+		"referrer-policy":           "strict-origin-when-cross-origin",
+	}
+	for k, v := range set {
+		if v == "" {
+			delete(h, k)
+		} else {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+func TestHeaderScore(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		https bool
+		set   map[string]string
+		want  int
+	}{
+		{"all good", true, nil, 100},
+		{"over HTTP", false, nil, 80},
+		{"over HTTP, HSTS ignored", false, map[string]string{"strict-transport-security": ""}, 80},
+		{"HSTS missing", true, map[string]string{"strict-transport-security": ""}, 80},
+		{"HSTS max-age=0", true, map[string]string{"strict-transport-security": "max-age=0"}, 90},
+		{"HSTS a day", true, map[string]string{"strict-transport-security": "max-age=86400"}, 90},
+		{"HSTS six months", true, map[string]string{"strict-transport-security": `max-age="15552000"`}, 100},
+		{"HSTS malformed", true, map[string]string{"strict-transport-security": "max-age=forever"}, 80},
+		{"HSTS without max-age", true, map[string]string{"strict-transport-security": "includeSubDomains"}, 80},
+		{"CSP missing, X-Frame-Options", true, map[string]string{"content-security-policy": "", "x-frame-options": "DENY"}, 75},
+		{"CSP and X-Frame-Options missing", true, map[string]string{"content-security-policy": ""}, 55},
+		{"CSP 'unsafe-inline'", true, map[string]string{"content-security-policy": "script-src 'self' 'unsafe-inline'; frame-ancestors 'none'"}, 80},
+		{"CSP 'unsafe-inline' in default-src", true, map[string]string{"content-security-policy": "default-src * 'unsafe-inline'; frame-ancestors 'self'"}, 80},
+		{"CSP 'unsafe-inline' with a nonce", true, map[string]string{"content-security-policy": "script-src 'nonce-abc' 'unsafe-inline'; frame-ancestors 'none'"}, 100},
+		{"CSP 'unsafe-eval'", true, map[string]string{"content-security-policy": "script-src 'self' 'unsafe-eval'; frame-ancestors 'none'"}, 90},
+		{"CSP duplicate, strict first", true, map[string]string{"content-security-policy": "script-src 'self'; script-src 'unsafe-inline'; frame-ancestors 'none'"}, 100},
+		{"CSP duplicate, unsafe first", true, map[string]string{"content-security-policy": "script-src 'unsafe-inline'; script-src 'self'; frame-ancestors 'none'"}, 80},
+		{"CSP without script sources", true, map[string]string{"content-security-policy": "frame-ancestors 'none'"}, 80},
+		{"framing allowed", true, map[string]string{"content-security-policy": "default-src 'self'", "x-frame-options": "ALLOW-FROM https://x.example"}, 80},
+		{"framing by X-Frame-Options", true, map[string]string{"content-security-policy": "default-src 'self'", "x-frame-options": " sameorigin "}, 100},
+		{"nosniff missing", true, map[string]string{"x-content-type-options": ""}, 95},
+		{"referrer leaked last", true, map[string]string{"referrer-policy": "no-referrer, unsafe-url"}, 95},
+		{"referrer leaked first", true, map[string]string{"referrer-policy": "unsafe-url, no-referrer"}, 100},
+		{"nothing over HTTP", false, map[string]string{"strict-transport-security": "", "content-security-policy": "", "x-content-type-options": "", "referrer-policy": ""}, 30},
+	} {
+		if got := headerScore(c.https, goodHeaders(c.set)); got != c.want {
+			t.Errorf("%s: score %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHeaderGrade(t *testing.T) {
+	for score, want := range map[int]string{
+		100: "A", 90: "A",
+		89: "B", 70: "B",
+		69: "C", 55: "C",
+		54: "D", 40: "D",
+		39: "F", 0: "F",
+	} {
+		if got := headerGrade(score); got != want {
+			t.Errorf("headerGrade(%d) = %s, want %s", score, got, want)
+		}
+	}
+	for grade, want := range map[string]int{"A": 0, "b": 1, "F": 4, "E": -1} {
+		if got := gradeRank(grade); got != want {
+			t.Errorf("gradeRank(%q) = %d, want %d", grade, got, want)
+		}
+	}
+}
+
+func TestHSTSMaxAge(t *testing.T) {
+	for _, c := range []struct {
+		v    string
+		want int
+		ok   bool
+	}{
+		{"max-age=31536000", 31536000, true},
+		{"includeSubDomains; MAX-AGE = 0 ; preload", 0, true},
+		{`max-age="300"`, 300, true},
+		{"max-age=", 0, false},
+		{"max-age=1e6", 0, false},
+		{"", 0, false},
+	} {
+		if got, ok := hstsMaxAge(c.v); got != c.want || ok != c.ok {
+			t.Errorf("hstsMaxAge(%q) = %d %v, want %d %v", c.v, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestParseCSP(t *testing.T) {
+	if csp := parseCSP("  "); csp != nil {
+		t.Errorf("empty policy: %v", csp)
+	}
+	got := parseCSP("Script-Src 'self' https://cdn.example; ; script-src *; img-src; default-src 'none'")
+	want := map[string][]string{
+		"script-src":  {"'self'", "https://cdn.example"},
+		"img-src":     {},
+		"default-src": {"'none'"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCSP = %v, want %v", got, want)
+	}
+}
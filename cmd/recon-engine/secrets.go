@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretEnv lists every credential the engine reads. Their values come from
+// the environment or -keys-file and never appear in its output.
+var secretEnv = []string{
+	"CENSYS_API_ID", "CENSYS_API_SECRET", "SECURITYTRAILS_API_KEY", "CHAOS_API_KEY", "VT_API_KEY",
+	"DNSDB_API_KEY", "CIRCL_PDNS_USER", "CIRCL_PDNS_PASSWORD",
+	"NVD_API_KEY", "GITHUB_TOKEN", "WHOISXML_API_KEY", "JIRA_API_TOKEN", "SMTP_PASSWORD", "KAFKA_SASL_PASSWORD",
+}
+
+// apiKeyEnv maps each API-backed service to the variables its key needs
+var apiKeyEnv = map[string][]string{
+	"censys":         {"CENSYS_API_ID", "CENSYS_API_SECRET"},
+	"securitytrails": {"SECURITYTRAILS_API_KEY"},
+	"chaos":          {"CHAOS_API_KEY"},
+	"virustotal":     {"VT_API_KEY"},
+	"dnsdb":          {"DNSDB_API_KEY"},
+	"circl":          {"CIRCL_PDNS_USER", "CIRCL_PDNS_PASSWORD"},
+	"nvd":            {"NVD_API_KEY"},
+	"github":         {"GITHUB_TOKEN"},
+	"whoisxml":       {"WHOISXML_API_KEY"},
+}
+
+var (
+	keysFromFile map[string]string // -keys-file entries
+
+	// redactValues are credentials read from elsewhere, such as
+	// -auth-file, that redactSecrets hides too
+	redactMu     sync.RWMutex
+	redactValues []string
+
+	// realStderr is the terminal or file behind the redacting pipe that
+	// os.Stderr points to once redactStderr has run
+	realStderr *os.File
+	stderrPipe *os.File
+	stderrDone chan struct{}
+)
+
+// secret returns the credential name from the environment or, when it is
+// not set there, from -keys-file
+func secret(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return keysFromFile[name]
+}
+
+// loadKeysFile reads -keys-file: NAME=value lines for the variables in
+// secretEnv, with # comments. Called once after flag parsing.
+func loadKeysFile() error {
+	if keysFile == "" {
+		return nil
+	}
+	f, err := os.Open(keysFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keysFromFile = make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(strings.TrimPrefix(name, "export "))
+		if !ok {
+			return fmt.Errorf("%s:%d: want NAME=value", keysFile, n)
+		}
+		if !isSecretEnv(name) {
+			return fmt.Errorf("%s:%d: unknown key %s (want one of %s)", keysFile, n, name, strings.Join(secretEnv, ", "))
+		}
+		keysFromFile[name] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return sc.Err()
+}
+
+func isSecretEnv(name string) bool {
+	for _, env := range secretEnv {
+		if env == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeysPresent reports, per service, whether its whole key is configured
+func apiKeysPresent() map[string]bool {
+	present := make(map[string]bool, len(apiKeyEnv))
+	for service, envs := range apiKeyEnv {
+		present[service] = true
+		for _, env := range envs {
+			if secret(env) == "" {
+				present[service] = false
+			}
+		}
+	}
+	return present
+}
+
+// missingKeys returns the variables of service's key that are not set
+func missingKeys(service string) []string {
+	var missing []string
+	for _, env := range apiKeyEnv[service] {
+		if secret(env) == "" {
+			missing = append(missing, env)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// addRedaction makes redactSecrets hide v
+func addRedaction(v string) {
+	if len(v) < 4 {
+		return
+	}
+	redactMu.Lock()
+	redactValues = append(redactValues, v)
+	redactMu.Unlock()
+}
+
+// redactSecrets replaces every credential value in s, the -auth-file
+// values and the -proxy password with REDACTED. Values under four
+// characters are left alone, they would match all over the place.
+func redactSecrets(s string) string {
+	for _, env := range secretEnv {
+		if v := secret(env); len(v) >= 4 {
+			s = strings.ReplaceAll(s, v, "REDACTED")
+		}
+	}
+	redactMu.RLock()
+	for _, v := range redactValues {
+		s = strings.ReplaceAll(s, v, "REDACTED")
+	}
+	redactMu.RUnlock()
+	// From the flag rather than proxyURL, which is set later and would
+	// race with the stderr pipe
+	for _, raw := range splitList(proxyFlag) {
+		if u, err := url.Parse(raw); err == nil && u.User != nil {
+			if p, ok := u.User.Password(); ok && len(p) >= 4 {
+				s = strings.ReplaceAll(s, p, "REDACTED")
+			}
+		}
+	}
+	return s
+}
+
+// redactStderr sends everything written to os.Stderr through
+// redactSecrets, including the stderr of tools that share it. A secret is
+// only missed when it is split across two writes. flushStderr undoes it.
+func redactStderr() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	realStderr, stderrPipe, stderrDone = os.Stderr, w, make(chan struct{})
+	os.Stderr = w
+	go func() {
+		defer close(stderrDone)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				realStderr.WriteString(redactSecrets(string(buf[:n])))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// flushStderr writes out what is left in the redacting pipe and points
+// os.Stderr back at the real stderr. Called by exit, after the tools that
+// might hold the pipe open are stopped; it waits a second at most in case
+// one escaped.
+func flushStderr() {
+	if stderrPipe == nil {
+		return
+	}
+	os.Stderr = realStderr
+	stderrPipe.Close()
+	select {
+	case <-stderrDone:
+	case <-time.After(time.Second):
+	}
+	stderrPipe = nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const securityTrailsAPIBase = "https://api.securitytrails.com/v1"
+
+type securityTrailsSubdomains struct {
+	Subdomains []string `json:"subdomains"`
+	Meta       struct {
+		LimitReached bool `json:"limit_reached"`
+	} `json:"meta"`
+}
+
+type securityTrailsScroll struct {
+	Records []struct {
+		Hostname string `json:"hostname"`
+	} `json:"records"`
+	Meta struct {
+		ScrollID string `json:"scroll_id"`
+	} `json:"meta"`
+}
+
+// runSecurityTrails lists subdomains from SecurityTrails. The subdomains
+// endpoint is tried first; when it reports the result was truncated the
+// scroll API is used to page through the rest, spending at most
+// -securitytrails-max-requests calls in total.
+func runSecurityTrails(ctx context.Context, domain string, out chan<- string) error {
+	key := secret("SECURITYTRAILS_API_KEY")
+	if key == "" {
+		return fmt.Errorf("SECURITYTRAILS_API_KEY is not set")
+	}
+	budget := securityTrailsMaxRequests
+
+	var subs securityTrailsSubdomains
+	endpoint := fmt.Sprintf("%s/domain/%s/subdomains?children_only=false&include_inactive=true", securityTrailsAPIBase, url.PathEscape(domain))
+	if err := securityTrailsDo(ctx, key, http.MethodGet, endpoint, nil, &subs); err != nil {
+		return err
+	}
+	budget--
+	for _, label := range subs.Subdomains {
+		out <- strings.ToLower(label) + "." + domain
+	}
+	if !subs.Meta.LimitReached {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"filter": map[string]string{"apex_domain": domain},
+	})
+	endpoint = securityTrailsAPIBase + "/domains/list?include_ipv4s=false&scroll=true"
+	method := http.MethodPost
+	for ; budget > 0; budget-- {
+		var page securityTrailsScroll
+		if err := securityTrailsDo(ctx, key, method, endpoint, body, &page); err != nil {
+			return err
+		}
+		if len(page.Records) == 0 || page.Meta.ScrollID == "" {
+			return nil
+		}
+		for _, rec := range page.Records {
+			out <- strings.ToLower(rec.Hostname)
+		}
+		endpoint = securityTrailsAPIBase + "/scroll/" + url.PathEscape(page.Meta.ScrollID)
+		method, body = http.MethodGet, nil
+	}
+	fmt.Fprintf(os.Stderr, "SecurityTrails request budget (%d) exhausted for %s\n", securityTrailsMaxRequests, domain)
+	return nil
+}
+
+func securityTrailsDo(ctx context.Context, key, method, endpoint string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("APIKEY", key)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := apiRequest("securitytrails"); err != nil {
+		return err
+	}
+
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("SecurityTrails rejected the API key (HTTP %d), check SECURITYTRAILS_API_KEY", resp.StatusCode)
+	default:
+		return fmt.Errorf("securitytrails: unexpected status %s", resp.Status)
+	}
+}
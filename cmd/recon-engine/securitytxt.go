@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const securityTxtMaxBytes = 64 << 10
+
+// SecurityTxt holds the RFC 9116 fields of a host's security.txt
+type SecurityTxt struct {
+	URL     string   `json:"url"`
+	Contact []string `json:"contact"`
+	Expires string   `json:"expires,omitempty"`
+	Policy  []string `json:"policy,omitempty"`
+	Expired bool     `json:"expired,omitempty"`
+}
+
+// fetchSecurityTxt looks for security.txt at the RFC 9116 location, then at
+// the legacy top-level path. A file without a Contact field is treated as
+// absent: hosts that answer every path with a page would match otherwise.
+func fetchSecurityTxt(ctx context.Context, res *Result) *SecurityTxt {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	base := u.Scheme + "://" + u.Host
+	for _, p := range []string{"/.well-known/security.txt", "/security.txt"} {
+		body, err := fetchCapped(ctx, base+p, securityTxtMaxBytes)
+		if err != nil {
+			continue
+		}
+		if st := parseSecurityTxt(body); st != nil {
+			st.URL = base + p
+			return st
+		}
+	}
+	return nil
+}
+
+// parseSecurityTxt reads the fields of a security.txt, which may be
+// wrapped in an OpenPGP cleartext signature
+func parseSecurityTxt(body []byte) *SecurityTxt {
+	var st SecurityTxt
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "-----BEGIN PGP SIGNATURE") {
+			break
+		}
+		// Cleartext signatures dash-escape lines starting with a dash
+		line = strings.TrimPrefix(line, "- ")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "contact":
+			st.Contact = append(st.Contact, value)
+		case "policy":
+			st.Policy = append(st.Policy, value)
+		case "expires":
+			// Only one Expires field is allowed; the first one counts
+			if st.Expires == "" {
+				st.Expires = value
+			}
+		}
+	}
+	if len(st.Contact) == 0 {
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339, st.Expires); err == nil {
+		st.Expired = time.Now().After(t)
+	}
+	return &st
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+// TestValidateServeTarget takes what the scan command takes as a target
+func TestValidateServeTarget(t *testing.T) {
+	for target, ok := range map[string]bool{
+		"example.com":                  true,
+		"Sub_1.Example.COM.":           true,
+		"10.0.0.5":                     true,
+		"10.0.0.0/24":                  true,
+		"2001:db8::/120":               true,
+		"https://app.example.com/api/": true,
+		"http://10.0.0.5:8080":         true,
+		"":                             false,
+		"-deep":                        false,
+		"*.example.com":                false,
+		".example.com":                 false,
+		"example.com/path":             false,
+		"example .com":                 false,
+		"ftp://example.com":            false,
+		"10.0.0.0/33":                  false,
+	} {
+		if err := validateServeTarget(target); (err == nil) != ok {
+			t.Errorf("validateServeTarget(%q) = %v", target, err)
+		}
+	}
+}
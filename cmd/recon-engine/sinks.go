@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// sinkStats is what one output sink did during the run, as reported in the
+// run summary
+type sinkStats struct {
+	Written   int    `json:"written"`
+	Failed    int    `json:"failed,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// resultSink is one destination for results. Each has its own queue and
+// goroutine, so a slow sink only ever holds up itself; write sees the
+// results in emission order, one at a time.
+type resultSink struct {
+	name  string
+	write func(Result) error
+	close func() // run once the queue is drained, may be nil
+
+	mu    sync.Mutex
+	cond  *sync.Cond // signalled when the queue or busy changes
+	queue []Result
+	busy  bool // a result is being written
+	shut  bool
+	done  chan struct{}
+	stats sinkStats
+}
+
+// resultSinks fans every emitted Result out to the registered sinks
+type resultSinks struct {
+	sinks []*resultSink
+}
+
+// newResultSinks registers a sink for the encoder writing to stdout or -o
+// and one for every other output configured. ui may be nil.
+func newResultSinks(ctx context.Context, encoder resultEncoder, ui *tuiView) *resultSinks {
+	s := &resultSinks{}
+	s.register("output", func(res Result) error { return encoder.Encode(res) }, func() { flushEncoder(encoder) })
+	if ui != nil {
+		s.register("tui", func(res Result) error { ui.Add(res); return nil }, nil)
+	}
+	if webhookURL != "" || ownerWebhooks {
+		s.register("webhook", func(res Result) error { return notifyWebhook(ctx, res) }, nil)
+	}
+	if kafkaWriter != nil {
+		s.register("kafka", publishKafka, closeKafka)
+	}
+	if redisClient != nil {
+		s.register("redis", publishRedis, closeRedis)
+	}
+	if jiraQueue != nil {
+		s.register("jira", queueJira, closeJira)
+	}
+	if emailRecipients != nil {
+		s.register("email", func(res Result) error { collectEmail(res); return nil }, nil)
+	}
+	return s
+}
+
+// register adds a sink and starts its goroutine
+func (s *resultSinks) register(name string, write func(Result) error, close func()) {
+	k := &resultSink{name: name, write: write, close: close, done: make(chan struct{})}
+	k.cond = sync.NewCond(&k.mu)
+	s.sinks = append(s.sinks, k)
+	go k.run()
+}
+
+// Emit queues res for every sink. It never waits for one.
+func (s *resultSinks) Emit(res Result) {
+	for _, k := range s.sinks {
+		k.mu.Lock()
+		k.queue = append(k.queue, res)
+		k.cond.Broadcast()
+		k.mu.Unlock()
+	}
+}
+
+// Flush waits until every sink has written what was emitted so far
+func (s *resultSinks) Flush() {
+	for _, k := range s.sinks {
+		k.mu.Lock()
+		for len(k.queue) > 0 || k.busy {
+			k.cond.Wait()
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Close drains every sink, then closes them in registration order
+func (s *resultSinks) Close() {
+	for _, k := range s.sinks {
+		k.mu.Lock()
+		k.shut = true
+		k.cond.Broadcast()
+		k.mu.Unlock()
+	}
+	for _, k := range s.sinks {
+		<-k.done
+		if k.close != nil {
+			k.close()
+		}
+	}
+}
+
+// Report returns what each sink did so far and prints a line for every
+// sink that failed to write some results
+func (s *resultSinks) Report() map[string]sinkStats {
+	out := make(map[string]sinkStats, len(s.sinks))
+	for _, k := range s.sinks {
+		k.mu.Lock()
+		st := k.stats
+		k.mu.Unlock()
+		out[k.name] = st
+		if st.Failed > 0 {
+			fmt.Fprintf(os.Stderr, "Output to %s: %d of %d results failed, last error: %s\n", k.name, st.Failed, st.Written+st.Failed, st.LastError)
+		}
+	}
+	return out
+}
+
+func (k *resultSink) run() {
+	defer close(k.done)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for {
+		for len(k.queue) == 0 && !k.shut {
+			k.cond.Wait()
+		}
+		if len(k.queue) == 0 {
+			return
+		}
+		res := k.queue[0]
+		k.queue[0] = Result{}
+		k.queue = k.queue[1:]
+		k.busy = true
+		k.mu.Unlock()
+		err := k.write(res)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing result to %s: %v\n", k.name, err)
+			reportToolError(k.name, "output", "", err)
+		}
+		k.mu.Lock()
+		k.busy = false
+		if err != nil {
+			k.stats.Failed++
+			k.stats.LastError = err.Error()
+		} else {
+			k.stats.Written++
+		}
+		k.cond.Broadcast()
+	}
+}
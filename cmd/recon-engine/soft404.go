@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// soft404JitterBytes and soft404JitterRatio bound how far a response's
+	// length may stray from the baseline and still count as the same page:
+	// timestamps, CSRF tokens and the echoed random path change it slightly
+	// from one request to the next
+	soft404JitterBytes = 64
+	soft404JitterRatio = 0.05
+)
+
+// soft404Baseline is how a host answers a path that does not exist
+type soft404Baseline struct {
+	Status int
+	Length int
+	SHA256 string
+}
+
+// soft404HTTP requests the baselines. It goes through the native limiter
+// like every other request the engine makes itself.
+var soft404HTTP = newHTTPClient(15*time.Second, false)
+
+// soft404Baselines holds each base URL's baseline, requested once and
+// shared by -soft404, -dirbrute and -params
+var soft404Baselines sync.Map // base URL -> *soft404Entry
+
+type soft404Entry struct {
+	mu       sync.Mutex
+	done     bool
+	baseline *soft404Baseline
+	err      error
+}
+
+// hostBaseline returns how base, a URL without path, answers a random path.
+// A request that failed because ctx ended is not kept: the next caller
+// on the host, with its own context, tries again.
+func hostBaseline(ctx context.Context, base string) (*soft404Baseline, error) {
+	v, _ := soft404Baselines.LoadOrStore(base, &soft404Entry{})
+	e := v.(*soft404Entry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.done {
+		b, err := fetchBaseline(ctx, base)
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+		e.baseline, e.err, e.done = b, err, true
+	}
+	return e.baseline, e.err
+}
+
+func fetchBaseline(ctx context.Context, base string) (*soft404Baseline, error) {
+	b := make([]byte, 12)
+	rand.Read(b)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/"+hex.EncodeToString(b), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := soft404HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	n, _ := io.Copy(h, io.LimitReader(resp.Body, 10<<20))
+	return &soft404Baseline{Status: resp.StatusCode, Length: int(n), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Matches reports whether a response with this status, body length and
+// body hash is indistinguishable from the baseline. An empty hash is
+// compared on length alone, which needs both bodies to be non-empty: an
+// empty baseline would be within the jitter of any short page.
+func (b *soft404Baseline) Matches(status, length int, hash string) bool {
+	if b == nil || status != b.Status {
+		return false
+	}
+	if hash != "" && hash == b.SHA256 {
+		return true
+	}
+	if length == 0 || b.Length == 0 {
+		return false
+	}
+	return lengthWithinJitter(length, b.Length)
+}
+
+// Soft reports whether the host answers missing paths as if they existed,
+// rather than with a 4xx or 5xx status
+func (b *soft404Baseline) Soft() bool {
+	return b != nil && b.Status < 400
+}
+
+// soft404Jitter is how many bytes a response of about length bytes may
+// differ from the baseline by
+func soft404Jitter(length int) int {
+	return max(soft404JitterBytes, int(float64(length)*soft404JitterRatio))
+}
+
+// lengthWithinJitter reports whether two body lengths are close enough to
+// be the same dynamic page
+func lengthWithinJitter(a, b int) bool {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	return d <= soft404Jitter(max(a, b))
+}
+
+// checkSoft404 sets res.Soft404 when the host's root response is the page
+// it serves for a random path. With -polite, hosts behind a CDN are not
+// sent the extra request.
+func checkSoft404(ctx context.Context, res *Result) {
+	if politeMode && res.CDN != "" {
+		return
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	b, err := hostBaseline(ctx, u.Scheme+"://"+u.Host)
+	if err != nil {
+		return
+	}
+	if b.Soft() && b.Matches(res.StatusCode, res.ContentLength, res.BodySHA256) {
+		res.Soft404 = true
+		stats.Add("soft404.hosts", 1)
+	}
+}
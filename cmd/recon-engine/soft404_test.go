@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestLengthWithinJitter allows 64 bytes below 1280 bytes and 5% of the
+// longer body above
+func TestLengthWithinJitter(t *testing.T) {
+	for _, c := range []struct {
+		a, b int
+		want bool
+	}{
+		// 64 bytes
+		{1064, 1000, true},
+		{1065, 1000, false},
+		{936, 1000, true},
+		{935, 1000, false},
+		{64, 0, true},
+		{65, 0, false},
+		// Where 5% of the longer body reaches 64 bytes
+		{1280, 1216, true},
+		{1281, 1216, false},
+		// 5% of the longer body
+		{1900, 2000, true},
+		{1899, 2000, false},
+		{2000, 1900, true},
+		{2001, 1900, false},
+		{105000, 100000, true},
+		{105264, 100000, false},
+	} {
+		if got := lengthWithinJitter(c.a, c.b); got != c.want {
+			t.Errorf("lengthWithinJitter(%d, %d) = %v, want %v", c.a, c.b, got, c.want)
+		}
+		if got := lengthWithinJitter(c.b, c.a); got != c.want {
+			t.Errorf("lengthWithinJitter(%d, %d) = %v, want %v", c.b, c.a, got, c.want)
+		}
+	}
+}
+
+func TestSoft404Matches(t *testing.T) {
+	b := &soft404Baseline{Status: 200, Length: 2000, SHA256: "aaaa"}
+	for _, c := range []struct {
+		status, length int
+		hash           string
+		want           bool
+	}{
+		{200, 2000, "aaaa", true},
+		{200, 1, "aaaa", true},
+		{200, 2050, "", true},
+		{200, 2050, "bbbb", true},
+		{200, 2200, "bbbb", false},
+		{404, 2000, "aaaa", false},
+	} {
+		if got := b.Matches(c.status, c.length, c.hash); got != c.want {
+			t.Errorf("Matches(%d, %d, %q) = %v", c.status, c.length, c.hash, got)
+		}
+	}
+
+	// An empty baseline matches an empty body by its hash, never a short
+	// page by its length
+	empty := &soft404Baseline{Status: 200, SHA256: "e3b0"}
+	if !empty.Matches(200, 0, "e3b0") {
+		t.Error("empty body with the baseline's hash")
+	}
+	for _, length := range []int{0, 10, 64} {
+		if empty.Matches(200, length, "") || empty.Matches(200, length, "cccc") {
+			t.Errorf("empty baseline matched a %d-byte page", length)
+		}
+	}
+	if b.Matches(200, 0, "") {
+		t.Error("empty page matched a baseline by its length")
+	}
+	var none *soft404Baseline
+	if none.Matches(200, 2000, "aaaa") || none.Soft() {
+		t.Error("no baseline matched")
+	}
+}
+
+// TestHostBaselineCancelled does not keep the error of a request its
+// caller's context ended, and keeps the baseline once it is fetched
+func TestHostBaselineCancelled(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(strings.Repeat("x", 500)))
+	}))
+	defer s.Close()
+	t.Cleanup(func() { soft404Baselines.Delete(s.URL) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hostBaseline(ctx, s.URL); err == nil {
+		t.Fatal("no error with a cancelled context")
+	}
+	b, err := hostBaseline(context.Background(), s.URL)
+	if err != nil {
+		t.Fatalf("the cancelled request's error was kept: %v", err)
+	}
+	if b.Status != 200 || b.Length != 500 || !b.Soft() {
+		t.Errorf("baseline %+v", b)
+	}
+	if _, err := hostBaseline(context.Background(), s.URL); err != nil || requests.Load() != 1 {
+		t.Errorf("%d requests, %v", requests.Load(), err)
+	}
+}
+
+// TestCheckSoft404 flags a root page that is the host's answer to a
+// random path
+func TestCheckSoft404(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>" + strings.Repeat("x", 1000) + r.URL.Path + "</html>"))
+	}))
+	defer s.Close()
+	t.Cleanup(func() { soft404Baselines.Delete(s.URL) })
+
+	res := &Result{URL: s.URL + "/", StatusCode: 200, ContentLength: 1014}
+	checkSoft404(context.Background(), res)
+	if !res.Soft404 {
+		t.Error("soft 404 not flagged")
+	}
+	res = &Result{URL: s.URL + "/", StatusCode: 200, ContentLength: 5000}
+	checkSoft404(context.Background(), res)
+	if res.Soft404 {
+		t.Error("a different page flagged")
+	}
+}
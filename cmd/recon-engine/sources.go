@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceFunc streams names discovered for domain into out until the source
+// is exhausted or ctx is cancelled
+type sourceFunc func(ctx context.Context, domain string, out chan<- string) error
+
+// sourceRegistry maps the names accepted by -sources to their implementation
+var sourceRegistry = map[string]sourceFunc{
+	"subfinder":      runSubfinder,
+	"amass":          runAmass,
+	"censys":         runCensysSource,
+	"securitytrails": runSecurityTrails,
+	"virustotal":     runVirusTotal,
+	"chaos":          runChaos,
+	"crtsh":          runCrtsh,
+	"brute":          runBrute,
+	"axfr":           runAXFR,
+	"dnsdb":          runDNSDB,
+	"circl":          runCIRCL,
+}
+
+// sourceBinaries lists the external tools a source needs on PATH
+var sourceBinaries = map[string]string{
+	"subfinder": "subfinder",
+	"amass":     "amass",
+}
+
+var (
+	// sourceHTTP is shared by the API-backed discovery sources and enrichers
+	sourceHTTP = newHTTPClient(30*time.Second, true)
+	// streamHTTP is used for large dataset downloads that can outlast
+	// sourceHTTP's timeout; they are bounded by their context instead
+	streamHTTP = newHTTPClient(0, true)
+)
+
+// Infrastructure holds ASN/Org info reported by a discovery source
+type Infrastructure struct {
+	Asn int
+	Org string
+}
+
+// infraMap stores ASN/Org info from Amass to enrich later
+// key: subdomain
+var (
+	infraMap   = make(map[string]Infrastructure)
+	infraMutex sync.Mutex
+)
+
+// selectedSources resolves the -sources flag into registry names. An empty
+// flag keeps the historical behaviour: subfinder, plus amass with -deep.
+// -brute adds the brute-force source to whichever set is selected.
+func selectedSources() ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	if sourcesFlag == "" {
+		add("subfinder")
+		if useDeep {
+			add("amass")
+		}
+	}
+	for _, name := range strings.Split(sourcesFlag, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := sourceRegistry[name]; !ok {
+			return nil, fmt.Errorf("unknown source %q (available: %s)", name, strings.Join(availableSources(), ", "))
+		}
+		add(name)
+	}
+	if bruteForce {
+		add("brute")
+	}
+	if axfr {
+		add("axfr")
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no sources selected")
+	}
+	return names, nil
+}
+
+func availableSources() []string {
+	names := make([]string, 0, len(sourceRegistry))
+	for name := range sourceRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func runSubfinder(ctx context.Context, domain string, out chan<- string) error {
+	cmd := toolCommand(ctx, toolPath("subfinder"), subfinderArgs(domain)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("pipe error: %w", err)
+	}
+	if err := startTool(cmd); err != nil {
+		return fmt.Errorf("start error: %w", err)
+	}
+	scanner := newLineReader(stdout, "subfinder")
+	for scanner.Next() {
+		if !subfinderJSON {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			host, err := cleanName(scanner.Text())
+			if err != nil {
+				rejectedLine("subfinder", "discovery", eventInvalid, err)
+				continue
+			}
+			out <- host
+			continue
+		}
+		var line subfinderLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			rejectedLine("subfinder", "discovery", eventParseFailed, err)
+			continue
+		}
+		host, err := cleanName(line.Host)
+		if err != nil {
+			rejectedLine("subfinder", "discovery", eventInvalid, err)
+			continue
+		}
+		if line.Source != "" {
+			recordSubfinderSource(host, line.Source)
+		}
+		for _, src := range line.Sources {
+			recordSubfinderSource(host, src)
+		}
+		out <- host
+	}
+	return waitTool(cmd)
+}
+
+// startSource runs a registered source in the background, forwarding its
+// names into out. Once -max-subdomains-per-source names have been forwarded
+// the source's context is cancelled and anything else it sends is discarded.
+// A source whose circuit breaker is open is not started.
+func startSource(ctx context.Context, wg *sync.WaitGroup, name, domain string, out chan<- string) {
+	if !breakerAllow(name) {
+		return
+	}
+	run := sourceRegistry[name]
+	ctx, span := otelStart(ctx, "discovery "+name, otelStr("recon.source", name), otelStr("recon.target", domain))
+	srcCtx, srcCancel := context.WithCancel(ctx)
+	names := make(chan string)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer srcCancel()
+		count := 0
+		defer func() {
+			span.SetAttr(otelInt("recon.names", count))
+			span.End()
+		}()
+		for n := range names {
+			if maxPerSource > 0 && count >= maxPerSource {
+				continue
+			}
+			recordDiscovery(n, name)
+			out <- n
+			count++
+			if maxPerSource > 0 && count == maxPerSource {
+				fmt.Fprintf(os.Stderr, "%s reached -max-subdomains-per-source (%d), stopping\n", name, maxPerSource)
+				coverageCap(name)
+				srcCancel()
+			}
+		}
+	}()
+
+	go func() {
+		defer close(names)
+		err := run(srcCtx, domain, names)
+		// A spent request budget was warned about once already
+		if err != nil && srcCtx.Err() == nil && !errors.Is(err, errBudgetExhausted) {
+			fmt.Fprintf(os.Stderr, "%s error: %v\n", name, err)
+			reportToolError(name, "discovery", "", err)
+			span.Error(err)
+		}
+		// A source stopped by the run or by -max-subdomains-per-source is
+		// not judged
+		if srcCtx.Err() == nil {
+			breakerResult(name, err)
+		}
+	}()
+}
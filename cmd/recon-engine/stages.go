@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stageWeights are how many -workers slots one in-flight call of each
+// enrichment stage takes. Stages that run a child process or send many
+// requests per host weigh more, so a few of them cannot crowd out the rest.
+// Every enrichment stage is listed; -stage-workers only accepts these names.
+var stageWeights = map[string]int{
+	"auth":           1,
+	"parked":         1,
+	"redirects":      1,
+	"access":         1,
+	"soft404":        1,
+	"asn":            1,
+	"third_party":    1,
+	"ptr":            1,
+	"geo":            1,
+	"censys":         1,
+	"historical_ips": 1,
+	"robots":         1,
+	"security_txt":   1,
+	"cors":           1,
+	"headers":        1,
+	"cookies":        1,
+	"cert":           1,
+	"environment":    1,
+	"jarm":           2,
+	"bucket":         1,
+	"dirbrute":       3,
+	"whatweb":        3,
+	"fingerprint":    1,
+	"default_creds":  2,
+	"match":          1,
+	"params":         2,
+	"redirect_check": 1,
+	"cve":            1,
+	"screenshot":     3,
+	"archive":        1,
+}
+
+// stageStarvation is how many times the head of a stage's queue may be
+// passed over for other stages before it is served first
+const stageStarvation = 8
+
+// stageSched hands out the -workers budget to the enrichment stages. Each
+// stage has its own queue, so a cheap stage is not held up behind an
+// expensive one waiting for enough slots to free up; a waiter that keeps
+// being passed over is served before anyone else.
+type stageSched struct {
+	mu       sync.Mutex
+	capacity int
+	free     int
+	limits   map[string]int // -stage-workers
+	inFlight map[string]int
+	queues   map[string][]*stageWaiter
+	order    []string // stages with waiters, served round-robin
+	next     int
+}
+
+type stageWaiter struct {
+	ready  chan struct{}
+	passed int
+}
+
+// stages is the enrichment budget, set by configureStages
+var stages *stageSched
+
+// configureStages validates -stage-workers and sets up the -workers budget.
+// Called once after flag parsing.
+func configureStages() error {
+	limits, err := parseStageWorkers(stageWorkers)
+	if err != nil {
+		return err
+	}
+	stages = newStageSched(max(workers, 1), limits)
+	return nil
+}
+
+// parseStageWorkers reads a comma-separated list of stage=limit pairs
+func parseStageWorkers(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, pair := range splitList(s) {
+		name, v, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("%q: want stage=limit", pair)
+		}
+		if _, known := stageWeights[name]; !known {
+			return nil, fmt.Errorf("unknown stage %q (want one of %s)", name, strings.Join(stageNames(), ", "))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s: limit must be a number of at least 1, got %q", name, v)
+		}
+		limits[name] = n
+	}
+	return limits, nil
+}
+
+func newStageSched(capacity int, limits map[string]int) *stageSched {
+	return &stageSched{
+		capacity: capacity,
+		free:     capacity,
+		limits:   limits,
+		inFlight: make(map[string]int),
+		queues:   make(map[string][]*stageWaiter),
+	}
+}
+
+// weight is what one call of stage takes from the budget. A stage heavier
+// than the whole budget takes all of it.
+func (s *stageSched) weight(stage string) int {
+	return min(max(stageWeights[stage], 1), s.capacity)
+}
+
+// fits reports whether one more call of stage is allowed right now
+func (s *stageSched) fits(stage string) bool {
+	if limit, ok := s.limits[stage]; ok && s.inFlight[stage] >= limit {
+		return false
+	}
+	return s.weight(stage) <= s.free
+}
+
+// Acquire waits until stage may run one more call and takes its slots.
+// It returns false, holding nothing, when ctx ends first.
+func (s *stageSched) Acquire(ctx context.Context, stage string) bool {
+	s.mu.Lock()
+	if len(s.queues[stage]) == 0 && s.starved() == "" && s.fits(stage) {
+		s.take(stage)
+		s.passOthers(stage)
+		s.mu.Unlock()
+		return true
+	}
+	w := &stageWaiter{ready: make(chan struct{})}
+	if len(s.queues[stage]) == 0 {
+		s.order = append(s.order, stage)
+	}
+	s.queues[stage] = append(s.queues[stage], w)
+	stats.Add("stage."+stage+".queued", 1)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Granted while ctx ended: hand the slots back
+		s.release(stage)
+	default:
+		s.remove(stage, w)
+	}
+	return false
+}
+
+// Release returns the slots a call of stage took
+func (s *stageSched) Release(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(stage)
+}
+
+func (s *stageSched) take(stage string) {
+	s.free -= s.weight(stage)
+	s.inFlight[stage]++
+	stats.Add("stage."+stage+".in_flight", 1)
+}
+
+func (s *stageSched) release(stage string) {
+	s.free += s.weight(stage)
+	s.inFlight[stage]--
+	stats.Add("stage."+stage+".in_flight", -1)
+	s.grant()
+}
+
+// grant serves the queues round-robin while their heads fit. A head that
+// was passed over stageStarvation times blocks every other queue until it
+// fits.
+func (s *stageSched) grant() {
+	for len(s.order) > 0 {
+		if first := s.starved(); first != "" {
+			if !s.fits(first) {
+				return
+			}
+			s.serve(first)
+			continue
+		}
+		served := false
+		for i := 0; i < len(s.order); i++ {
+			stage := s.order[(s.next+i)%len(s.order)]
+			if s.fits(stage) {
+				s.serve(stage)
+				served = true
+				break
+			}
+		}
+		if !served {
+			return
+		}
+	}
+}
+
+// starved returns the stage whose head was passed over the longest, when it
+// reached stageStarvation
+func (s *stageSched) starved() string {
+	best, passed := "", stageStarvation-1
+	for _, stage := range s.order {
+		if p := s.queues[stage][0].passed; p > passed {
+			best, passed = stage, p
+		}
+	}
+	return best
+}
+
+// serve grants the head of stage's queue and counts a pass against the
+// other heads
+func (s *stageSched) serve(stage string) {
+	q := s.queues[stage]
+	w := q[0]
+	s.queues[stage] = q[1:]
+	s.take(stage)
+	stats.Add("stage."+stage+".queued", -1)
+	close(w.ready)
+
+	i := slices.Index(s.order, stage)
+	if len(s.queues[stage]) == 0 {
+		delete(s.queues, stage)
+		s.order = append(s.order[:i], s.order[i+1:]...)
+		s.next = i
+	} else {
+		s.next = i + 1
+	}
+	if len(s.order) > 0 {
+		s.next %= len(s.order)
+	}
+	s.passOthers(stage)
+}
+
+// passOthers counts a pass against the head of every queue but stage's
+func (s *stageSched) passOthers(stage string) {
+	for _, other := range s.order {
+		if other != stage {
+			s.queues[other][0].passed++
+		}
+	}
+}
+
+// remove drops a waiter whose context ended
+func (s *stageSched) remove(stage string, w *stageWaiter) {
+	q := s.queues[stage]
+	for i, x := range q {
+		if x == w {
+			s.queues[stage] = append(q[:i], q[i+1:]...)
+			stats.Add("stage."+stage+".queued", -1)
+			break
+		}
+	}
+	if len(s.queues[stage]) == 0 {
+		delete(s.queues, stage)
+		if i := slices.Index(s.order, stage); i >= 0 {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			if s.next > i {
+				s.next--
+			}
+			if len(s.order) > 0 {
+				s.next %= len(s.order)
+			} else {
+				s.next = 0
+			}
+		}
+	}
+	// The head may have changed to one that fits
+	s.grant()
+}
+
+// stageStep runs fn as a call of stage once the budget allows it, and
+// skips it when ctx ends while it waits or -time-budget is running out
+func stageStep(ctx context.Context, stage string, fn func()) {
+	if timeBudget > 0 && budgetSkips(stage) {
+		return
+	}
+	if !stages.Acquire(ctx, stage) {
+		return
+	}
+	defer stages.Release(stage)
+	coverageAdd(stage, 1)
+	fn()
+}
+
+// stageNames lists the enrichment stages in name order
+func stageNames() []string {
+	names := make([]string, 0, len(stageWeights))
+	for name := range stageWeights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkIdle fails unless every slot of s was handed back
+func checkIdle(t *testing.T, s *stageSched) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.free != s.capacity {
+		t.Errorf("%d of %d slots free", s.free, s.capacity)
+	}
+	for stage, n := range s.inFlight {
+		if n != 0 {
+			t.Errorf("%s: %d in flight", stage, n)
+		}
+	}
+	if len(s.queues) != 0 || len(s.order) != 0 {
+		t.Errorf("queues left: %v", s.order)
+	}
+}
+
+// acquireAsync starts an Acquire and returns its result channel
+func acquireAsync(ctx context.Context, s *stageSched, stage string) chan bool {
+	got := make(chan bool, 1)
+	go func() { got <- s.Acquire(ctx, stage) }()
+	return got
+}
+
+func granted(got chan bool) bool {
+	select {
+	case ok := <-got:
+		if !ok {
+			panic("Acquire failed")
+		}
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+// TestStageSchedAccounting runs many calls of stages of every weight at
+// once, checking the budget and -stage-workers limits are never exceeded
+// and every slot comes back. Run it with -race.
+func TestStageSchedAccounting(t *testing.T) {
+	const capacity = 6
+	limits := map[string]int{"whatweb": 1, "cors": 2}
+	s := newStageSched(capacity, limits)
+	stageList := []string{"whatweb", "screenshot", "jarm", "params", "cors", "headers", "robots"}
+
+	var used atomic.Int64
+	inFlight := make(map[string]*atomic.Int64)
+	for _, stage := range stageList {
+		inFlight[stage] = new(atomic.Int64)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 50; i++ {
+				stage := stageList[r.Intn(len(stageList))]
+				if !s.Acquire(context.Background(), stage) {
+					t.Error("Acquire failed without a deadline")
+					return
+				}
+				w := int64(min(stageWeights[stage], capacity))
+				if n := used.Add(w); n > capacity {
+					t.Errorf("%d slots in use, budget %d", n, capacity)
+				}
+				if limit, ok := limits[stage]; ok {
+					if n := inFlight[stage].Add(1); n > int64(limit) {
+						t.Errorf("%s: %d in flight, limit %d", stage, n, limit)
+					}
+				}
+				time.Sleep(time.Duration(r.Intn(200)) * time.Microsecond)
+				if _, ok := limits[stage]; ok {
+					inFlight[stage].Add(-1)
+				}
+				used.Add(-w)
+				s.Release(stage)
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	checkIdle(t, s)
+}
+
+// TestStageSchedNoStarvation keeps cheap calls coming while an expensive
+// one waits for the whole budget: it is served once it has been passed
+// over stageStarvation times
+func TestStageSchedNoStarvation(t *testing.T) {
+	s := newStageSched(3, nil)
+	ctx := context.Background()
+	held := 3
+	for i := 0; i < held; i++ {
+		s.Acquire(ctx, "cors")
+	}
+	ww := acquireAsync(ctx, s, "whatweb")
+	time.Sleep(20 * time.Millisecond)
+
+	var waiting []chan bool
+	for i := 0; !granted(ww); i++ {
+		if i > 4*stageStarvation {
+			t.Fatal("whatweb starved behind cors")
+		}
+		if held > 0 {
+			s.Release("cors")
+			held--
+		}
+		// Cheap calls keep arriving; once whatweb is starved they queue
+		// behind it
+		got := acquireAsync(ctx, s, "cors")
+		if granted(got) {
+			held++
+		} else {
+			waiting = append(waiting, got)
+		}
+	}
+	if len(waiting) == 0 {
+		t.Error("cheap calls were never held back")
+	}
+	s.Release("whatweb")
+	for _, got := range waiting {
+		if !granted(got) {
+			t.Fatal("a queued cheap call was not served after whatweb")
+		}
+		s.Release("cors")
+	}
+	for ; held > 0; held-- {
+		s.Release("cors")
+	}
+	checkIdle(t, s)
+}
+
+// TestStageSchedPerStageQueues lets a cheap stage run while an expensive
+// one waits for slots, instead of queueing them in one line
+func TestStageSchedPerStageQueues(t *testing.T) {
+	s := newStageSched(4, nil)
+	ctx := context.Background()
+	s.Acquire(ctx, "whatweb") // 3 of 4
+	ww := acquireAsync(ctx, s, "whatweb")
+	if granted(ww) {
+		t.Fatal("second whatweb granted over budget")
+	}
+	if !granted(acquireAsync(ctx, s, "headers")) {
+		t.Fatal("headers waited behind whatweb")
+	}
+	s.Release("headers")
+	s.Release("whatweb")
+	if !granted(ww) {
+		t.Fatal("queued whatweb not served")
+	}
+	s.Release("whatweb")
+	checkIdle(t, s)
+}
+
+func TestStageSchedCancel(t *testing.T) {
+	s := newStageSched(2, nil)
+	s.Acquire(context.Background(), "jarm")
+	ctx, cancel := context.WithCancel(context.Background())
+	got := acquireAsync(ctx, s, "cors")
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if ok := <-got; ok {
+		t.Error("Acquire succeeded after its context ended")
+	}
+	s.Release("jarm")
+	checkIdle(t, s)
+}
+
+func TestParseStageWorkers(t *testing.T) {
+	limits, err := parseStageWorkers("whatweb=3, screenshot = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["whatweb"] != 3 || limits["screenshot"] != 2 || len(limits) != 2 {
+		t.Errorf("limits %v", limits)
+	}
+	for _, s := range []string{"whatweb", "nosuch=2", "whatweb=0", "whatweb=x"} {
+		if _, err := parseStageWorkers(s); err == nil {
+			t.Errorf("accepted %q", s)
+		}
+	}
+}
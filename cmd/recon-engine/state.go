@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateVersion 2 added Assets. Version 1 files still load, without history.
+const stateVersion = 2
+
+// changeStale marks the record of an asset that has not answered for more
+// than -stale-after runs
+const changeStale = "stale"
+
+// scanState is the -state file: the full Result set of the last completed
+// iteration, used as the diff baseline for the next one, and the history of
+// every subdomain seen since the file was started
+type scanState struct {
+	Version   int                      `json:"version"`
+	Target    string                   `json:"target"`
+	UpdatedAt string                   `json:"updated_at"`
+	Results   []Result                 `json:"results"`
+	Assets    map[string]*assetHistory `json:"assets,omitempty"`
+	// Brute holds the -brute checkpoints of an interrupted run, by domain
+	Brute map[string]*bruteCheckpoint `json:"brute,omitempty"`
+}
+
+// assetHistory is what the -state file remembers about one subdomain
+type assetHistory struct {
+	FirstSeen  string   `json:"first_seen"`          // RFC 3339
+	LastSeen   string   `json:"last_seen,omitempty"` // last run it answered a probe
+	LastStatus int      `json:"last_status,omitempty"`
+	LastTech   []string `json:"last_tech,omitempty"`
+	MissedRuns int      `json:"missed_runs,omitempty"` // completed runs since it last answered
+}
+
+// readState reads and checks the state file at path, decrypting an
+// -encrypt-output one
+func readState(path string) (*scanState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = decryptBytes(b); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var st scanState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch {
+	case st.Version == 1:
+		fmt.Fprintf(os.Stderr, "Warning: %s has no first/last seen history (state version 1); it starts with this run\n", path)
+	case st.Version != stateVersion:
+		return nil, fmt.Errorf("%s: unsupported state version %d (want %d)", path, st.Version, stateVersion)
+	}
+	if err := checkAssets(st.Assets); err != nil {
+		return nil, fmt.Errorf("%s: corrupted state: %w", path, err)
+	}
+	return &st, nil
+}
+
+// checkAssets rejects history that would stamp results with wrong dates
+func checkAssets(assets map[string]*assetHistory) error {
+	for name, a := range assets {
+		if name == "" || a == nil {
+			return fmt.Errorf("empty asset entry")
+		}
+		first, err := time.Parse(time.RFC3339, a.FirstSeen)
+		if err != nil {
+			return fmt.Errorf("asset %s: first_seen: %w", name, err)
+		}
+		if a.LastSeen != "" {
+			last, err := time.Parse(time.RFC3339, a.LastSeen)
+			if err != nil {
+				return fmt.Errorf("asset %s: last_seen: %w", name, err)
+			}
+			if last.Before(first) {
+				return fmt.Errorf("asset %s: last_seen %s is before first_seen %s", name, a.LastSeen, a.FirstSeen)
+			}
+		}
+		if a.MissedRuns < 0 {
+			return fmt.Errorf("asset %s: negative missed_runs", name)
+		}
+	}
+	return nil
+}
+
+// loadState reads the state file at path. A missing file is not an error
+// and yields nil results and an empty history.
+func loadState(path, target string) ([]Result, *seenHistory, error) {
+	st, err := readState(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, newSeenHistory(nil), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.Target != target {
+		return nil, nil, fmt.Errorf("%s: state is for %q, not %q", path, st.Target, target)
+	}
+	return st.Results, newSeenHistory(st.Assets), nil
+}
+
+// saveState replaces the state file at path, writing to a temporary file
+// first so an interrupted write never leaves a truncated state behind. It
+// is encrypted with -encrypt-output.
+func saveState(path, target string, results []Result, history *seenHistory) error {
+	st := scanState{
+		Version:   stateVersion,
+		Target:    target,
+		UpdatedAt: time.Now().Format(time.RFC3339),
+		Results:   results,
+		Assets:    history.assets,
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if encryptKey != nil {
+		if b, err = encryptBytes(b); err != nil {
+			return err
+		}
+	}
+	return replaceFile(path, b)
+}
+
+// seenHistory tracks first and last seen dates per subdomain across the
+// runs sharing a -state file
+type seenHistory struct {
+	mu     sync.Mutex
+	assets map[string]*assetHistory
+	now    string          // the current run's start
+	alive  map[string]bool // subdomains that answered in the current run
+}
+
+func newSeenHistory(assets map[string]*assetHistory) *seenHistory {
+	if assets == nil {
+		assets = make(map[string]*assetHistory)
+	}
+	h := &seenHistory{assets: assets}
+	h.startRun()
+	return h
+}
+
+// startRun begins a run; the dates Stamp records are its start
+func (h *seenHistory) startRun() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.now = time.Now().Format(time.RFC3339)
+	h.alive = make(map[string]bool)
+}
+
+// Stamp records res in the history and sets its FirstSeen and LastSeen
+func (h *seenHistory) Stamp(res *Result) {
+	if res.Subdomain == "" || res.ChangeType == changeRemoved {
+		return
+	}
+	name := strings.ToLower(res.Subdomain)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a, ok := h.assets[name]
+	if !ok {
+		a = &assetHistory{FirstSeen: h.now}
+		h.assets[name] = a
+	}
+	if res.StatusCode > 0 {
+		a.LastSeen, a.LastStatus, a.LastTech = h.now, res.StatusCode, res.TechStack
+		h.alive[name] = true
+	}
+	res.FirstSeen, res.LastSeen = a.FirstSeen, a.LastSeen
+}
+
+// Advance ends a completed run. Assets that did not answer miss a run, and
+// those that just passed -stale-after missed runs are returned as stale
+// records, each reported once.
+func (h *seenHistory) Advance() []Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var names []string
+	for name, a := range h.assets {
+		if h.alive[name] {
+			a.MissedRuns = 0
+			continue
+		}
+		a.MissedRuns++
+		if staleAfter > 0 && a.MissedRuns == staleAfter+1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	now := time.Now().Format(time.RFC3339)
+	out := make([]Result, 0, len(names))
+	for _, name := range names {
+		a := h.assets[name]
+		out = append(out, Result{
+			Subdomain:       name,
+			TechStack:       append([]string{}, a.LastTech...),
+			Vulnerabilities: []Finding{},
+			Timestamp:       now,
+			RunID:           runID,
+			EngineVersion:   version,
+			SchemaVersion:   schemaVersion,
+			FirstSeen:       a.FirstSeen,
+			LastSeen:        a.LastSeen,
+			ChangeType:      changeStale,
+			Changes:         []string{fmt.Sprintf("missed_runs %d", a.MissedRuns), fmt.Sprintf("last_status %d", a.LastStatus)},
+		})
+	}
+	return out
+}
+
+// bruteCheckpoint is how far an interrupted -brute run over one domain got:
+// the chunks of its wordlist it resolved and the names they turned up
+type bruteCheckpoint struct {
+	Wordlist  string   `json:"wordlist"` // path, size and modification time
+	ChunkSize int      `json:"chunk_size"`
+	Done      []int    `json:"done"`
+	Found     []string `json:"found,omitempty"`
+}
+
+// bruteCheckpointEvery is how often finished chunks are written to the
+// -state file, at most
+const bruteCheckpointEvery = 10 * time.Second
+
+// checkpoints keeps the -brute checkpoints of a run with -state, nil
+// without one
+var checkpoints *checkpointStore
+
+// checkpointStore writes -brute progress into the -state file as the run
+// goes, leaving its results and history alone. The completed run's
+// saveState drops it; an interrupted run leaves it for resume.
+type checkpointStore struct {
+	mu      sync.Mutex
+	path    string
+	target  string
+	resumed map[string]*bruteCheckpoint // what resume starts from
+	brute   map[string]*bruteCheckpoint
+	dirty   bool
+	saved   time.Time
+	failed  bool
+}
+
+// configureCheckpoints sets up checkpointing into the -state file of a
+// single run. resume picks up the checkpoints the file holds; scan starts
+// over. Called once after flag parsing.
+func configureCheckpoints(cmd, target string) error {
+	if bruteChunkSize < 1 {
+		return fmt.Errorf("-brute-chunk-size must be at least 1")
+	}
+	if statePath == "" || monitor {
+		return nil
+	}
+	checkpoints = &checkpointStore{path: statePath, target: target, brute: make(map[string]*bruteCheckpoint)}
+	if cmd == "resume" {
+		st, err := readState(statePath)
+		if err != nil {
+			return err
+		}
+		checkpoints.resumed = st.Brute
+	}
+	return nil
+}
+
+// start returns the chunks of domain's wordlist already resolved and the
+// names they found. A checkpoint of another wordlist or chunk size is
+// dropped.
+func (c *checkpointStore) start(domain, wordlist string) (map[int]bool, []string) {
+	if c == nil {
+		return nil, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := &bruteCheckpoint{Wordlist: wordlist, ChunkSize: bruteChunkSize}
+	if prev := c.resumed[domain]; prev != nil {
+		if prev.Wordlist == wordlist && prev.ChunkSize == bruteChunkSize {
+			cp.Done, cp.Found = prev.Done, prev.Found
+		} else {
+			fmt.Fprintf(os.Stderr, "Info: the -brute checkpoint of %s is for another wordlist or -brute-chunk-size, starting over\n", domain)
+		}
+	}
+	c.brute[domain] = cp
+	done := make(map[int]bool, len(cp.Done))
+	for _, i := range cp.Done {
+		done[i] = true
+	}
+	return done, append([]string(nil), cp.Found...)
+}
+
+// done records a resolved chunk of domain's wordlist, writing the
+// checkpoints out when the last write is old enough
+func (c *checkpointStore) done(domain string, chunk int, found []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := c.brute[domain]
+	cp.Done = append(cp.Done, chunk)
+	cp.Found = append(cp.Found, found...)
+	c.dirty = true
+	if time.Since(c.saved) >= bruteCheckpointEvery {
+		c.save()
+	}
+}
+
+// flush writes the checkpoints out if they changed since the last write
+func (c *checkpointStore) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.save()
+}
+
+// save rewrites the -state file with the checkpoints. A failure is reported
+// once and does not stop the run. Called with c.mu held.
+func (c *checkpointStore) save() {
+	if !c.dirty || c.failed {
+		return
+	}
+	st, err := readState(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		st, err = &scanState{Version: stateVersion, Target: c.target}, nil
+	}
+	if err == nil {
+		for _, cp := range c.brute {
+			sort.Ints(cp.Done)
+		}
+		st.UpdatedAt = time.Now().Format(time.RFC3339)
+		st.Brute = c.brute
+		var b []byte
+		if b, err = json.Marshal(st); err == nil {
+			err = replaceFile(c.path, b)
+		}
+	}
+	if err != nil {
+		c.failed = true
+		fmt.Fprintf(os.Stderr, "Warning: cannot checkpoint -brute into -state: %v\n", err)
+		return
+	}
+	c.dirty, c.saved = false, time.Now()
+}
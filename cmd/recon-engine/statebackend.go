@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// stateBackendTimeout bounds each call to the -state-backend
+	stateBackendTimeout = 10 * time.Second
+	// stateKeyPrefix namespaces the Redis keys of the -state-backend
+	stateKeyPrefix = "recon-engine:probe:"
+	// stateLockWait is how long a file backend entry's lock is waited for,
+	// and stateLockStale the age past which a lock is taken to be left by a
+	// run that died holding it
+	stateLockWait  = 10 * time.Second
+	stateLockStale = 30 * time.Second
+)
+
+// probeStore records when each name was last probed, by any run sharing
+// it, and the results that probe gave. Every method is safe against other
+// processes using the same store.
+type probeStore interface {
+	// Claim returns the results and time of the name's last probe when it
+	// is younger than interval. Otherwise it records now as the name's
+	// probe, dropping the old results, and returns false.
+	Claim(name string, now time.Time, interval time.Duration) ([]Result, time.Time, bool, error)
+	// Record adds a result of the probe claimed at probedAt
+	Record(name string, probedAt time.Time, res Result) error
+	// Release forgets the probe claimed at probedAt, unless another run
+	// has claimed the name since
+	Release(name string, probedAt time.Time) error
+	Close() error
+}
+
+// probeStates is the -state-backend, nil without one
+var probeStates probeStore
+
+// stateBackendWarned is set once a -state-backend error was reported; the
+// rest are only counted
+var stateBackendWarned atomic.Bool
+
+// configureStateBackend opens -state-backend and checks -min-probe-interval.
+// Called once after flag parsing.
+func configureStateBackend() error {
+	if minProbeInterval < 0 {
+		return fmt.Errorf("-min-probe-interval must not be negative, got %s", minProbeInterval)
+	}
+	if stateBackend == "" {
+		return nil
+	}
+	u, err := url.Parse(stateBackend)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		probeStates, err = newRedisProbeStore(stateBackend)
+	case "file":
+		dir := u.Path
+		if u.Opaque != "" {
+			dir = u.Opaque
+		}
+		if dir == "" {
+			return fmt.Errorf("file:// needs a directory, e.g. file:///srv/recon-state")
+		}
+		probeStates, err = newFileProbeStore(dir)
+	case "sqlite":
+		path := u.Path
+		if u.Opaque != "" {
+			path = u.Opaque
+		}
+		if path == "" {
+			return fmt.Errorf("sqlite:// needs a database file, e.g. sqlite:///srv/recon-state.db")
+		}
+		probeStates, err = newSQLiteProbeStore(path)
+	default:
+		return fmt.Errorf("want redis://, rediss://, file:// or sqlite://, got %q", stateBackend)
+	}
+	return err
+}
+
+// closeStateBackend closes the -state-backend
+func closeStateBackend() {
+	if probeStates != nil {
+		probeStates.Close()
+	}
+}
+
+// stateBackendError counts a failed -state-backend call, reporting the
+// first. The name is probed (or its result goes unrecorded) as if there
+// were no backend.
+func stateBackendError(name string, err error) {
+	stats.Add("state_backend.errors", 1)
+	if stateBackendWarned.CompareAndSwap(false, true) {
+		fmt.Fprintf(os.Stderr, "Warning: -state-backend failed for %s, probing without it where it fails: %v\n", name, err)
+	}
+}
+
+// probeClaim is a name this run claimed in the -state-backend
+type probeClaim struct {
+	name string
+	at   time.Time
+}
+
+// claimProbe checks name against the -state-backend. It returns the cached
+// results, ready to emit for target, when another probe of name is younger
+// than -min-probe-interval and -force is not set; otherwise the claim the
+// results of this run's probe are recorded under.
+func claimProbe(target, name string) ([]Result, *probeClaim, bool) {
+	interval := minProbeInterval
+	if forceProbe {
+		interval = 0
+	}
+	now := time.Now()
+	cached, probedAt, recent, err := probeStates.Claim(name, now, interval)
+	if err != nil {
+		stateBackendError(name, err)
+		return nil, nil, false
+	}
+	if !recent {
+		return nil, &probeClaim{name, now}, false
+	}
+	stats.Add("probe.shared_skipped", 1)
+	for i := range cached {
+		res := &cached[i]
+		res.RunID, res.RootDomain = runID, target
+		res.Timestamp, res.CachedAt = now.Format(time.RFC3339), probedAt.UTC().Format(time.RFC3339)
+		// What the emitting run adds is this run's to add
+		res.Tags, res.ChangeType, res.Changes, res.FirstSeen, res.LastSeen = nil, "", nil, "", ""
+		// Raw records live in the probing run's -workdir
+		res.RawRef = ""
+	}
+	return cached, nil, true
+}
+
+// recordProbe stores res under the claim this run made for the name whose
+// probe gave it
+func recordProbe(res Result) {
+	c := res.claim
+	if c == nil {
+		return
+	}
+	if err := probeStates.Record(c.name, c.at, res); err != nil {
+		stateBackendError(c.name, err)
+	}
+}
+
+// releaseProbes gives back the claims of names that were never answered
+// when probing did not run to completion, so other runs probe them
+func releaseProbes(claims *sync.Map, answered map[string]bool) {
+	claims.Range(func(_, v interface{}) bool {
+		if c := v.(*probeClaim); !answered[c.name] {
+			if err := probeStates.Release(c.name, c.at); err != nil {
+				stateBackendError(c.name, err)
+			}
+		}
+		return true
+	})
+}
+
+// claimOf returns the claim a sync.Map of claims held, nil for none
+func claimOf(v interface{}) *probeClaim {
+	c, _ := v.(*probeClaim)
+	return c
+}
+
+// redisProbeStore keeps a hash with the probe time (Unix milliseconds) and
+// a list of results per name, changed only by scripts, which Redis runs
+// atomically
+type redisProbeStore struct {
+	client *redis.Client
+}
+
+// redisClaimProbe returns {1} after claiming KEYS[1] at ARGV[1], or {0, probed
+// at, results} when it was probed less than ARGV[2] milliseconds before
+var redisClaimProbe = redis.NewScript(`
+local t = tonumber(redis.call('HGET', KEYS[1], 'probed_at') or '0')
+if t > 0 and tonumber(ARGV[1]) - t < tonumber(ARGV[2]) then
+	return {0, t, redis.call('LRANGE', KEYS[2], 0, -1)}
+end
+redis.call('HSET', KEYS[1], 'probed_at', ARGV[1])
+redis.call('DEL', KEYS[2])
+return {1}
+`)
+
+// redisRecordProbe appends ARGV[2] to the results while the claim ARGV[1] holds
+var redisRecordProbe = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'probed_at') == ARGV[1] then
+	redis.call('RPUSH', KEYS[2], ARGV[2])
+end
+return 0
+`)
+
+// redisReleaseProbe drops the name while the claim ARGV[1] holds
+var redisReleaseProbe = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'probed_at') == ARGV[1] then
+	redis.call('DEL', KEYS[1], KEYS[2])
+end
+return 0
+`)
+
+func newRedisProbeStore(rawURL string) (*redisProbeStore, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), stateBackendTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisProbeStore{client: client}, nil
+}
+
+func redisProbeKeys(name string) []string {
+	return []string{stateKeyPrefix + name, stateKeyPrefix + name + ":results"}
+}
+
+func (s *redisProbeStore) Claim(name string, now time.Time, interval time.Duration) ([]Result, time.Time, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateBackendTimeout)
+	defer cancel()
+	reply, err := redisClaimProbe.Run(ctx, s.client, redisProbeKeys(name), now.UnixMilli(), interval.Milliseconds()).Slice()
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if len(reply) != 3 {
+		return nil, time.Time{}, false, nil
+	}
+	ms, _ := reply[1].(int64)
+	items, _ := reply[2].([]interface{})
+	var cached []Result
+	for _, item := range items {
+		s, _ := item.(string)
+		var res Result
+		if err := json.Unmarshal([]byte(s), &res); err != nil {
+			return nil, time.Time{}, false, fmt.Errorf("cached result of %s: %w", name, err)
+		}
+		cached = append(cached, res)
+	}
+	return cached, time.UnixMilli(ms), true, nil
+}
+
+func (s *redisProbeStore) Record(name string, probedAt time.Time, res Result) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stateBackendTimeout)
+	defer cancel()
+	return redisRecordProbe.Run(ctx, s.client, redisProbeKeys(name), strconv.FormatInt(probedAt.UnixMilli(), 10), b).Err()
+}
+
+func (s *redisProbeStore) Release(name string, probedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stateBackendTimeout)
+	defer cancel()
+	return redisReleaseProbe.Run(ctx, s.client, redisProbeKeys(name), strconv.FormatInt(probedAt.UnixMilli(), 10)).Err()
+}
+
+func (s *redisProbeStore) Close() error { return s.client.Close() }
+
+// fileProbeStore keeps a JSON file per name in a directory runs share,
+// <sha256 of name>.json, changed under a <sha256 of name>.lock file that
+// only one process can create at a time
+type fileProbeStore struct {
+	dir string
+}
+
+type fileProbeEntry struct {
+	Name     string   `json:"name"`
+	ProbedAt int64    `json:"probed_at"` // Unix milliseconds
+	Results  []Result `json:"results,omitempty"`
+}
+
+func newFileProbeStore(dir string) (*fileProbeStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileProbeStore{dir: dir}, nil
+}
+
+func (s *fileProbeStore) path(name, ext string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+// update runs fn on the entry of name, holding its lock. fn reports
+// whether the entry changed; a nil entry removes it.
+func (s *fileProbeStore) update(name string, fn func(e *fileProbeEntry) (*fileProbeEntry, bool)) error {
+	unlock, err := s.lock(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var e *fileProbeEntry
+	b, err := os.ReadFile(s.path(name, ".json"))
+	switch {
+	case err == nil:
+		e = &fileProbeEntry{}
+		// A damaged entry reads as a name never probed
+		if json.Unmarshal(b, e) != nil || e.Name != name {
+			e = nil
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	next, changed := fn(e)
+	switch {
+	case !changed:
+		return nil
+	case next == nil:
+		if err := os.Remove(s.path(name, ".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	b, err = json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(name, ".json"), b)
+}
+
+// lock creates the lock file of name, waiting for another holder, and
+// returns what removes it
+func (s *fileProbeStore) lock(name string) (func(), error) {
+	p := s.path(name, ".lock")
+	deadline := time.Now().Add(stateLockWait)
+	for {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(p) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(p); err == nil && time.Since(fi.ModTime()) > stateLockStale {
+			os.Remove(p)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", p)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (s *fileProbeStore) Claim(name string, now time.Time, interval time.Duration) ([]Result, time.Time, bool, error) {
+	var cached []Result
+	var probedAt time.Time
+	recent := false
+	err := s.update(name, func(e *fileProbeEntry) (*fileProbeEntry, bool) {
+		if e != nil && e.ProbedAt > 0 && now.UnixMilli()-e.ProbedAt < interval.Milliseconds() {
+			cached, probedAt, recent = e.Results, time.UnixMilli(e.ProbedAt), true
+			return e, false
+		}
+		return &fileProbeEntry{Name: name, ProbedAt: now.UnixMilli()}, true
+	})
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return cached, probedAt, recent, nil
+}
+
+func (s *fileProbeStore) Record(name string, probedAt time.Time, res Result) error {
+	return s.update(name, func(e *fileProbeEntry) (*fileProbeEntry, bool) {
+		if e == nil || e.ProbedAt != probedAt.UnixMilli() {
+			return e, false
+		}
+		e.Results = append(e.Results, res)
+		return e, true
+	})
+}
+
+func (s *fileProbeStore) Release(name string, probedAt time.Time) error {
+	return s.update(name, func(e *fileProbeEntry) (*fileProbeEntry, bool) {
+		return nil, e != nil && e.ProbedAt == probedAt.UnixMilli()
+	})
+}
+
+func (s *fileProbeStore) Close() error { return nil }
+
+// sqliteProbeStore keeps the probe time (Unix milliseconds) of each name
+// and the results of that probe in a SQLite database runs on one machine,
+// or on a shared filesystem SQLite can lock, share. Claims run in
+// transactions that take the write lock up front.
+type sqliteProbeStore struct {
+	db *sql.DB
+}
+
+// sqliteProbeSchema creates the tables of a new database
+const sqliteProbeSchema = `
+CREATE TABLE IF NOT EXISTS probes (
+	name TEXT PRIMARY KEY,
+	probed_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS probe_results (
+	name TEXT NOT NULL,
+	probed_at INTEGER NOT NULL,
+	result TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS probe_results_name ON probe_results (name, probed_at);
+`
+
+func newSQLiteProbeStore(path string) (*sqliteProbeStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// Other processes hold the lock for a statement or two; wait for them
+	// rather than failing
+	dsn := "file:" + path + "?_txlock=immediate&_pragma=busy_timeout(" + strconv.Itoa(int(stateLockWait.Milliseconds())) + ")&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection: this process's claims queue for it instead of for
+	// the database lock
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), stateBackendTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, sqliteProbeSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteProbeStore{db: db}, nil
+}
+
+// tx runs fn in a transaction, committing when it returns nil
+func (s *sqliteProbeStore) tx(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stateBackendTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteProbeStore) Claim(name string, now time.Time, interval time.Duration) ([]Result, time.Time, bool, error) {
+	var cached []Result
+	var probedAt time.Time
+	recent := false
+	err := s.tx(func(ctx context.Context, tx *sql.Tx) error {
+		var ms int64
+		err := tx.QueryRowContext(ctx, `SELECT probed_at FROM probes WHERE name = ?`, name).Scan(&ms)
+		switch {
+		case err == nil && ms > 0 && now.UnixMilli()-ms < interval.Milliseconds():
+			rows, err := tx.QueryContext(ctx, `SELECT result FROM probe_results WHERE name = ? AND probed_at = ? ORDER BY rowid`, name, ms)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var b []byte
+				if err := rows.Scan(&b); err != nil {
+					return err
+				}
+				var res Result
+				if err := json.Unmarshal(b, &res); err != nil {
+					return fmt.Errorf("cached result of %s: %w", name, err)
+				}
+				cached = append(cached, res)
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			probedAt, recent = time.UnixMilli(ms), true
+			return nil
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO probes (name, probed_at) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET probed_at = excluded.probed_at`, name, now.UnixMilli()); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM probe_results WHERE name = ?`, name)
+		return err
+	})
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return cached, probedAt, recent, nil
+}
+
+func (s *sqliteProbeStore) Record(name string, probedAt time.Time, res Result) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return s.tx(func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO probe_results (name, probed_at, result) SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM probes WHERE name = ?1 AND probed_at = ?2)`, name, probedAt.UnixMilli(), string(b))
+		return err
+	})
+}
+
+func (s *sqliteProbeStore) Release(name string, probedAt time.Time) error {
+	return s.tx(func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM probes WHERE name = ? AND probed_at = ?`, name, probedAt.UnixMilli())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM probe_results WHERE name = ?`, name)
+		return err
+	})
+}
+
+func (s *sqliteProbeStore) Close() error { return s.db.Close() }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// runStats holds named counters describing the progress of a run. Stages
+// bump counters with stats.Add and the -stats reporter prints a snapshot
+// periodically and once more when the run finishes.
+type runStats struct {
+	mu       sync.Mutex
+	start    time.Time
+	counters map[string]int64
+}
+
+var stats = &runStats{start: time.Now(), counters: make(map[string]int64)}
+
+// Add increments the named counter by n
+func (s *runStats) Add(name string, n int64) {
+	s.mu.Lock()
+	s.counters[name] += n
+	s.mu.Unlock()
+}
+
+// Reset clears every counter and restarts the elapsed clock
+func (s *runStats) Reset() {
+	s.mu.Lock()
+	s.start = time.Now()
+	s.counters = make(map[string]int64)
+	s.mu.Unlock()
+}
+
+// Elapsed returns the time since the run started
+func (s *runStats) Elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.start)
+}
+
+// Get returns the current value of the named counter
+func (s *runStats) Get(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[name]
+}
+
+// Snapshot returns a copy of all counters
+func (s *runStats) Snapshot() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := make(map[string]int64, len(s.counters))
+	for k, v := range s.counters {
+		snap[k] = v
+	}
+	return snap
+}
+
+// statsLine is the JSON record written by the -stats reporter
+type statsLine struct {
+	Type     string            `json:"type"`
+	Elapsed  string            `json:"elapsed"`
+	Counters map[string]int64  `json:"counters"`
+	Breakers map[string]string `json:"breakers,omitempty"` // the circuit breakers not closed
+	Brute    *bruteRate        `json:"brute,omitempty"`
+}
+
+// WriteTo writes a single stats line to w
+func (s *runStats) WriteTo(w io.Writer) (int64, error) {
+	counters := s.Snapshot()
+	line := statsLine{
+		Type:     "stats",
+		Elapsed:  time.Since(s.start).Round(time.Second).String(),
+		Counters: counters,
+		Breakers: breakerStates(),
+		Brute:    currentBruteRate(counters),
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fmt.Fprintln(w, string(b))
+	return int64(n), err
+}
+
+// reportStats writes a stats line to w every interval until done is closed
+func reportStats(w io.Writer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			stats.WriteTo(w)
+		}
+	}
+}
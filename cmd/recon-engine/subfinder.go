@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// subfinderLine is one line of subfinder -json output. With -cs every
+// source that reported the host is listed in Sources.
+type subfinderLine struct {
+	Host    string   `json:"host"`
+	Source  string   `json:"source"`
+	Sources []string `json:"sources"`
+}
+
+var (
+	subfinderSourcesMu sync.Mutex
+	subfinderSources   = make(map[string]map[string]bool) // host -> passive sources
+)
+
+// subfinderArgs builds the subfinder command line from the -subfinder-* flags
+func subfinderArgs(domain string) []string {
+	args := []string{"-d", domain, "-silent"}
+	if subfinderConfig != "" {
+		args = append(args, "-provider-config", subfinderConfig)
+	}
+	if subfinderSourceList != "" {
+		args = append(args, "-s", subfinderSourceList)
+	}
+	if subfinderExcludeSources != "" {
+		args = append(args, "-es", subfinderExcludeSources)
+	}
+	if subfinderAll {
+		args = append(args, "-all")
+	}
+	if subfinderJSON {
+		args = append(args, "-json", "-cs")
+	}
+	if r := dnsResolver.toolArg(); r != "" {
+		args = append(args, "-r", r)
+	}
+	return args
+}
+
+// checkSubfinderConfig warns when -subfinder-config points at a missing file;
+// subfinder then runs with only its keyless sources
+func checkSubfinderConfig() {
+	if subfinderConfig == "" {
+		return
+	}
+	if _, err := os.Stat(subfinderConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: -subfinder-config: %v\n", err)
+	}
+}
+
+func recordSubfinderSource(host, source string) {
+	subfinderSourcesMu.Lock()
+	defer subfinderSourcesMu.Unlock()
+	set, ok := subfinderSources[host]
+	if !ok {
+		set = make(map[string]bool)
+		subfinderSources[host] = set
+	}
+	set[strings.ToLower(source)] = true
+}
+
+// subfinderSourcesFor returns the passive sources subfinder credited with
+// host, sorted
+func subfinderSourcesFor(host string) []string {
+	subfinderSourcesMu.Lock()
+	defer subfinderSourcesMu.Unlock()
+	set := subfinderSources[host]
+	if len(set) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(set))
+	for s := range set {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
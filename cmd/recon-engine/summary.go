@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// runSummary is written to stderr and -summary-file (by default
+// summary.json in the -workdir) when a run finishes
+type runSummary struct {
+	mu sync.Mutex
+
+	Type          string           `json:"type"`
+	RunID         string           `json:"run_id"`
+	EngineVersion string           `json:"engine_version"`
+	Target        string           `json:"target"`
+	StartedAt     string           `json:"started_at"`
+	FinishedAt    string           `json:"finished_at"`
+	Duration      string           `json:"duration"`
+	Sources       []string         `json:"sources"`
+	Headers       []string         `json:"headers,omitempty"`
+	UserAgent     string           `json:"user_agent,omitempty"`
+	Tags          tagFlags         `json:"tags,omitempty"`
+	Counters      map[string]int64 `json:"counters"`
+
+	// OrgDomains are the -org root domains that were scanned, with the
+	// evidence that linked each to the organization
+	OrgDomains []orgCandidate `json:"org_domains,omitempty"`
+
+	// Owners breaks the results and findings down per -owners team
+	Owners map[string]*ownerSummary `json:"owners,omitempty"`
+
+	// Domains breaks the counts of a multi-domain run down per root domain
+	Domains map[string]*domainSummary `json:"domains,omitempty"`
+
+	SharedHosting []SharedIP `json:"shared_hosting,omitempty"`
+
+	// ThirdPartyVendors counts the -third-party hosts per vendor
+	ThirdPartyVendors map[string]int `json:"third_party_vendors,omitempty"`
+
+	// JarmClusters lists the JARM fingerprints several hosts share, with -jarm
+	JarmClusters []JarmCluster `json:"jarm_clusters,omitempty"`
+
+	GatesTripped []string `json:"gates_tripped,omitempty"`
+	CapsTripped  []string `json:"caps_tripped,omitempty"`
+
+	// TimeBudget is how -time-budget was split and what it cut short
+	TimeBudget *timeBudgetSummary `json:"time_budget,omitempty"`
+
+	// ScopeDrops counts the names and addresses each -scope rule kept from
+	// the active stages
+	ScopeDrops map[string]int `json:"scope_drops,omitempty"`
+
+	// IPExclusions counts the names and addresses each -exclude-ips range
+	// kept from the active stages
+	IPExclusions map[string]int `json:"ip_exclusions,omitempty"`
+
+	// PTROutOfScope lists -ptr names outside the target, which are never probed
+	PTROutOfScope []string `json:"ptr_out_of_scope,omitempty"`
+
+	// Timings has per-stage percentiles of the results' -timings
+	Timings map[string]timingStats `json:"timings,omitempty"`
+
+	// APIKeys tells which services have a key configured, never the key
+	APIKeys map[string]bool `json:"api_keys,omitempty"`
+
+	// Whois is the root domain's registration data, with -whois
+	Whois *WhoisInfo `json:"whois,omitempty"`
+
+	// DNSAudit is the root domain's DNS checks, with -dns-audit
+	DNSAudit *DNSAudit `json:"dns_audit,omitempty"`
+
+	// Clusters lists the -cluster groups with more than one member
+	Clusters []ResultCluster `json:"clusters,omitempty"`
+
+	// Dorks summarises the -dorks file
+	Dorks *dorksSummary `json:"dorks,omitempty"`
+
+	// Graph counts what the -graph export holds
+	Graph *graphSummary `json:"graph,omitempty"`
+
+	// Export counts what -export-burp and -export-urls hold
+	Export *exportSummary `json:"export,omitempty"`
+
+	// Auth lists the -auth-file contexts and whether their sessions looked
+	// valid, without their credentials
+	Auth []*authSummary `json:"auth,omitempty"`
+
+	// Polite holds the limits -polite ran under
+	Polite *politeSummary `json:"polite,omitempty"`
+
+	// Sinks counts what each output sink wrote and failed to write
+	Sinks map[string]sinkStats `json:"sinks,omitempty"`
+
+	// Workdir is the directory holding the run's artifacts
+	Workdir string `json:"workdir,omitempty"`
+
+	// ProbeRestart is set when httpx exited before it was sent every name
+	ProbeRestart *probeRestart `json:"probe_restart,omitempty"`
+	// ProbeBatches is how many httpx processes -probe-batch split probing
+	// into, when it took more than one
+	ProbeBatches int `json:"probe_batches,omitempty"`
+	// ProbesSkipped counts the names not probed because a run sharing the
+	// -state-backend probed them within -min-probe-interval
+	ProbesSkipped int `json:"probes_skipped,omitempty"`
+
+	// Raw has what -keep-raw stored
+	Raw *rawSummary `json:"raw,omitempty"`
+
+	// Breakers has the circuit breaker of each source or API that failed
+	Breakers map[string]breaker `json:"breakers,omitempty"`
+	// APIRequests has the requests sent to each external API against its
+	// -config budget
+	APIRequests map[string]apiUsage `json:"api_requests,omitempty"`
+
+	// Egress is the -source-ip or -interface address and the tools that
+	// could not be bound to it
+	Egress *egressSummary `json:"egress,omitempty"`
+
+	// Proxies and Resolvers have what went through each -proxy and
+	// resolver
+	Proxies   []endpointStats `json:"proxies,omitempty"`
+	Resolvers []endpointStats `json:"resolvers,omitempty"`
+
+	// Blocks has the WAF block rate of each root domain with blocked hosts
+	Blocks map[string]blockSummary `json:"blocks,omitempty"`
+	// Parked counts the parked hosts of each root domain
+	Parked map[string]int `json:"parked,omitempty"`
+
+	// Coverage says, for every capability in run order, whether it ran or
+	// why not, and how many items it processed
+	Coverage []coverageEntry `json:"coverage,omitempty"`
+
+	ToolVersions map[string]string `json:"tool_versions,omitempty"`
+	ToolErrors   map[string]int    `json:"tool_errors,omitempty"`
+	// ToolLines counts each tool's output lines that were rejected as
+	// unusable or repaired before use
+	ToolLines map[string]toolLineStats `json:"tool_lines,omitempty"`
+
+	written []byte // the summary as last written by finish
+}
+
+var summary = &runSummary{Type: "summary"}
+
+// finish fills in the end-of-run fields and writes the summary
+func (s *runSummary) finish(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.StartedAt = stats.start.Format(time.RFC3339)
+	s.FinishedAt = now.Format(time.RFC3339)
+	s.Duration = now.Sub(stats.start).Round(time.Second).String()
+	s.Counters = stats.Snapshot()
+	s.ToolErrors = toolErrorCounts()
+	s.ToolLines = toolLineSnapshot()
+	s.Breakers = breakerSnapshot()
+	s.APIRequests = apiUsageSnapshot()
+	s.Blocks = blockSnapshot()
+	s.Parked = parkedSnapshot()
+	s.Proxies = proxies.snapshot()
+	s.Resolvers = dnsResolver.pool.snapshot()
+	s.Timings = timingSummary()
+	s.Coverage = coverageReport(s)
+	warnCoverage(s.Coverage)
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding run summary: %v\n", err)
+		return
+	}
+	// -header values can carry credentials
+	b = []byte(redactSecrets(string(b)))
+	s.written = b
+	fmt.Fprintln(w, string(b))
+	if summaryFile != "" {
+		if err := os.WriteFile(summaryFile, append(b, '\n'), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing run summary: %v\n", err)
+		}
+	}
+}
+
+// coverage returns the coverage finish last filled in
+func (s *runSummary) coverage() []coverageEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Coverage
+}
+
+// encoded returns the summary as finish last wrote it, or nil before then
+func (s *runSummary) encoded() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
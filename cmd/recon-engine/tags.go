@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tagKeyMax bounds a -tag key, which becomes a field name in Elasticsearch
+// mappings and database columns downstream
+const tagKeyMax = 64
+
+// tagFlags collects repeated -tag key=value flags. A key given twice keeps
+// its last value.
+type tagFlags map[string]string
+
+func (t *tagFlags) String() string { return strings.Join(tagPairs(*t), ",") }
+
+func (t *tagFlags) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	key = strings.TrimSpace(key)
+	if !ok {
+		return fmt.Errorf("tag must look like key=value, got %q", v)
+	}
+	if err := validTagKey(key); err != nil {
+		return err
+	}
+	if *t == nil {
+		*t = make(tagFlags)
+	}
+	(*t)[key] = strings.TrimSpace(value)
+	return nil
+}
+
+// validTagKey checks key is a letter followed by letters, digits and
+// underscores: safe as an index field name and usable in -filter as
+// tags.<key>
+func validTagKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty tag key")
+	}
+	if len(key) > tagKeyMax {
+		return fmt.Errorf("tag key %q is longer than %d characters", key, tagKeyMax)
+	}
+	for i, c := range key {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && (c >= '0' && c <= '9' || c == '_') {
+			continue
+		}
+		return fmt.Errorf("tag key %q: want a letter followed by letters, digits and underscores", key)
+	}
+	return nil
+}
+
+// configTags are the tags: of the -config file, read and checked with its
+// profiles
+var configTags map[string]string
+
+// configureTags merges the -config file's tags under the -tag flags, the
+// command line winning per key. Called once after flag parsing.
+func configureTags() {
+	for key, value := range configTags {
+		if _, ok := runTags[key]; ok {
+			continue
+		}
+		if runTags == nil {
+			runTags = make(tagFlags)
+		}
+		runTags[key] = value
+	}
+}
+
+// applyTags copies the run's tags onto res
+func applyTags(res *Result) {
+	if len(runTags) == 0 {
+		return
+	}
+	if res.Tags == nil {
+		res.Tags = make(map[string]string, len(runTags))
+	}
+	for key, value := range runTags {
+		res.Tags[key] = value
+	}
+}
+
+// tagPairs returns tags as sorted key=value strings
+func tagPairs(tags map[string]string) []string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment of a tool shim: with -record or -replay every external tool
+// runs as the engine's own executable, which records the real tool's output
+// or plays a capture back
+const (
+	tapeEnv       = "RECON_TAPE"        // record:<prefix> or replay:<prefix>
+	tapeToolEnv   = "RECON_TAPE_TOOL"   // what the shim stands in for
+	tapeRedactEnv = "RECON_TAPE_REDACT" // -record-redact's domain
+)
+
+// redactedDomain replaces the target's domain in a -record-redact bundle.
+// Replay it with this as the target.
+const redactedDomain = "example.com"
+
+// tapeRun is run.json in a recording
+type tapeRun struct {
+	EngineVersion string   `json:"engine_version"`
+	Target        string   `json:"target"`
+	Args          []string `json:"args"`
+	Recorded      string   `json:"recorded"`
+	Redacted      bool     `json:"redacted,omitempty"`
+}
+
+// tapeCall is tools/NNNN.json: one external command. Its output is in
+// NNNN.out and NNNN.err, its exit code in NNNN.exit.
+type tapeCall struct {
+	Tool string   `json:"tool"`
+	Args []string `json:"args"`
+
+	prefix string
+	used   bool
+}
+
+// tapeExchange is http/NNNN.json: one native HTTP request and the response
+// headers. The body is in NNNN.body.
+type tapeExchange struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	BodySHA256 string      `json:"body_sha256,omitempty"` // of the request
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header"`
+
+	prefix string
+}
+
+var tape struct {
+	mu      sync.Mutex
+	exe     string
+	redact  string // the target's domain with -record-redact
+	toolSeq int
+	httpSeq int
+
+	calls     []*tapeCall
+	exchanges map[string][]*tapeExchange // by tapeKey
+	served    map[string]int
+}
+
+// configureTape validates -record and -replay, creates the -record bundle
+// and loads a -replay one.
+// Called once after flag parsing, once the -workdir is known. Both turn the
+// -cache off: a cached answer would not be recorded, or would be used
+// instead of the recording.
+func configureTape(target string) error {
+	if recordDir == "" && replayDir == "" {
+		if recordRedact {
+			return fmt.Errorf("-record-redact needs -record")
+		}
+		return nil
+	}
+	switch {
+	case recordDir != "" && replayDir != "":
+		return fmt.Errorf("-record and -replay are mutually exclusive")
+	case replayDir != "" && recordRedact:
+		return fmt.Errorf("-record-redact needs -record")
+	case monitor:
+		return fmt.Errorf("-record and -replay cannot be combined with -monitor")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find the engine's executable for the tool shim: %w", err)
+	}
+	tape.exe = exe
+	noCache = true
+	if replayDir != "" {
+		return loadTape(target)
+	}
+	if recordRedact {
+		tape.redact = strings.ToLower(strings.TrimSuffix(target, "."))
+	}
+	return startRecording(target)
+}
+
+// startRecording creates the -record bundle, before the tools' version
+// checks run
+func startRecording(target string) error {
+	for _, sub := range []string{"tools", "http"} {
+		if err := os.MkdirAll(filepath.Join(recordDir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	args := make([]string, len(os.Args)-1)
+	for i, a := range os.Args[1:] {
+		args[i] = redactHosts(a, tape.redact)
+	}
+	run := tapeRun{
+		EngineVersion: version,
+		Target:        redactHosts(target, tape.redact),
+		Args:          args,
+		Recorded:      time.Now().UTC().Format(time.RFC3339),
+		Redacted:      tape.redact != "",
+	}
+	return writeJSONFile(filepath.Join(recordDir, "run.json"), run)
+}
+
+// loadTape reads the -replay bundle's commands and HTTP exchanges
+func loadTape(target string) error {
+	var run tapeRun
+	data, err := os.ReadFile(filepath.Join(replayDir, "run.json"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return fmt.Errorf("run.json: %w", err)
+	}
+	if !strings.EqualFold(run.Target, target) {
+		fmt.Fprintf(os.Stderr, "Warning: replaying a recording of %s against %s; commands and requests naming the target will not match\n", run.Target, target)
+	}
+
+	metas, err := filepath.Glob(filepath.Join(replayDir, "tools", "*.json"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(metas)
+	for _, m := range metas {
+		var c tapeCall
+		if err := readJSONFile(m, &c); err != nil {
+			return err
+		}
+		c.prefix = strings.TrimSuffix(m, ".json")
+		tape.calls = append(tape.calls, &c)
+	}
+
+	metas, err = filepath.Glob(filepath.Join(replayDir, "http", "*.json"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(metas)
+	tape.exchanges = make(map[string][]*tapeExchange)
+	tape.served = make(map[string]int)
+	for _, m := range metas {
+		var x tapeExchange
+		if err := readJSONFile(m, &x); err != nil {
+			return err
+		}
+		x.prefix = strings.TrimSuffix(m, ".json")
+		key := tapeKey(x.Method, x.URL, x.BodySHA256)
+		tape.exchanges[key] = append(tape.exchanges[key], &x)
+	}
+	fmt.Fprintf(os.Stderr, "Replaying %s: %d commands and %d HTTP responses recorded by engine %s\n", replayDir, len(tape.calls), len(metas), run.EngineVersion)
+	return nil
+}
+
+// tapeCommand returns the shim to run in place of path with args, which it
+// is passed as they are, and the environment telling it what to do. ok is
+// false when neither -record nor -replay is on.
+func tapeCommand(path string, args []string) (exe string, env []string, ok bool) {
+	tool := toolName(path)
+	switch {
+	case replayDir != "":
+		prefix := findTapeCall(tool, tapeArgs(args))
+		return tape.exe, []string{tapeEnv + "=replay:" + prefix, tapeToolEnv + "=" + tool}, true
+	case recordDir != "":
+		tape.mu.Lock()
+		tape.toolSeq++
+		prefix := filepath.Join(recordDir, "tools", fmt.Sprintf("%04d", tape.toolSeq))
+		tape.mu.Unlock()
+		if err := writeJSONFile(prefix+".json", tapeCall{Tool: tool, Args: tapeArgs(args)}); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot record %s: %v\n", tool, err)
+			return "", nil, false
+		}
+		return tape.exe, []string{tapeEnv + "=record:" + prefix, tapeToolEnv + "=" + path, tapeRedactEnv + "=" + tape.redact}, true
+	}
+	return "", nil, false
+}
+
+// findTapeCall picks the capture to replay for tool run with args: the
+// first unused one with the same arguments, then a used one with the same
+// arguments, then the first unused one with the same last argument, which
+// is the target or URL for most tools
+func findTapeCall(tool string, args []string) string {
+	tape.mu.Lock()
+	defer tape.mu.Unlock()
+	var reuse, similar *tapeCall
+	for _, c := range tape.calls {
+		if c.Tool != tool {
+			continue
+		}
+		switch {
+		case slices.Equal(c.Args, args) && !c.used:
+			c.used = true
+			return c.prefix
+		case slices.Equal(c.Args, args):
+			reuse = c
+		case similar == nil && !c.used && len(c.Args) > 0 && len(args) > 0 && c.Args[len(c.Args)-1] == args[len(args)-1]:
+			similar = c
+		}
+	}
+	if reuse != nil {
+		return reuse.prefix
+	}
+	if similar != nil {
+		similar.used = true
+		return similar.prefix
+	}
+	return ""
+}
+
+// tapeArgs are args as they are recorded: the -workdir and temporary files
+// replaced by placeholders, so the same command matches in another run, and
+// hostnames redacted with -record-redact
+func tapeArgs(args []string) []string {
+	out := make([]string, len(args))
+	tmp := filepath.Clean(os.TempDir()) + string(filepath.Separator)
+	for i, a := range args {
+		switch {
+		case workdir != "" && strings.HasPrefix(a, workdir):
+			a = "{workdir}" + strings.TrimPrefix(a, workdir)
+		case strings.HasPrefix(a, tmp):
+			a = "{tmp}"
+		}
+		out[i] = redactSecrets(redactHosts(a, tape.redact))
+	}
+	return out
+}
+
+// toolName is the tool a command path runs: the tool whose -<tool>-bin it
+// is, or its file name
+func toolName(path string) string {
+	for tool, p := range toolBins {
+		if *p != "" && *p == path {
+			return tool
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(path), ".exe")
+}
+
+// runTapeShim is the whole life of a tool shim process; it returns only
+// when this process is not one
+func runTapeShim() {
+	mode, prefix, _ := strings.Cut(os.Getenv(tapeEnv), ":")
+	switch mode {
+	case "record":
+		os.Exit(recordTool(prefix, os.Getenv(tapeToolEnv), os.Args[1:], os.Getenv(tapeRedactEnv)))
+	case "replay":
+		os.Exit(replayTapeCall(prefix, os.Getenv(tapeToolEnv)))
+	}
+}
+
+// recordTool runs the real tool with the shim's stdin, passes its output
+// through and copies it, redacted, into the capture files
+func recordTool(prefix, path string, args []string, redact string) int {
+	out, err := os.Create(prefix + ".out")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: %v\n", err)
+		return 1
+	}
+	defer out.Close()
+	errOut, err := os.Create(prefix + ".err")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: %v\n", err)
+		return 1
+	}
+	defer errOut.Close()
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+	code := 0
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(errOut, err)
+		code = 127
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); teeLines(stdout, os.Stdout, out, redact) }()
+		go func() { defer wg.Done(); teeLines(stderr, os.Stderr, errOut, redact) }()
+		wg.Wait()
+		var exitErr *exec.ExitError
+		if err := cmd.Wait(); errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			code = 1
+		}
+	}
+	os.WriteFile(prefix+".exit", []byte(strconv.Itoa(code)), 0o644)
+	return code
+}
+
+// teeLines copies r to live as it comes and, a line at a time and
+// redacted, to capture
+func teeLines(r io.Reader, live, capture io.Writer, redact string) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			live.Write(line)
+			capture.Write([]byte(redactHosts(string(line), redact)))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// replayTapeCall reads stdin to its end, so the output comes after the
+// input as it would from the tool, writes a capture's output back and exits
+// with the recorded code. A command that was not recorded fails.
+func replayTapeCall(prefix, tool string) int {
+	io.Copy(io.Discard, os.Stdin)
+	if prefix == "" {
+		fmt.Fprintf(os.Stderr, "replay: %s was not run like this in the recording\n", tool)
+		return 1
+	}
+	for _, f := range []struct {
+		ext string
+		w   io.Writer
+	}{{".out", os.Stdout}, {".err", os.Stderr}} {
+		if data, err := os.ReadFile(prefix + f.ext); err == nil {
+			f.w.Write(data)
+		}
+	}
+	data, err := os.ReadFile(prefix + ".exit")
+	if err != nil {
+		// Stopped before it exited
+		return 1
+	}
+	code, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return code
+}
+
+// tapeRoundTrip records the response to req with -record or answers it from
+// the recording with -replay. send makes the real request.
+func tapeRoundTrip(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	bodySum := requestBodySHA256(req)
+	if replayDir != "" {
+		return replayExchange(req, bodySum)
+	}
+	resp, err := send(req)
+	if err != nil || recordDir == "" {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	tape.mu.Lock()
+	tape.httpSeq++
+	prefix := filepath.Join(recordDir, "http", fmt.Sprintf("%04d", tape.httpSeq))
+	tape.mu.Unlock()
+	header := resp.Header.Clone()
+	for _, vs := range header {
+		for i, v := range vs {
+			vs[i] = redactSecrets(redactHosts(v, tape.redact))
+		}
+	}
+	x := tapeExchange{
+		Method:     req.Method,
+		URL:        redactHosts(req.URL.String(), tape.redact),
+		BodySHA256: bodySum,
+		Status:     resp.StatusCode,
+		Header:     header,
+	}
+	err = os.WriteFile(prefix+".body", []byte(redactHosts(string(body), tape.redact)), 0o644)
+	if err == nil {
+		err = writeJSONFile(prefix+".json", x)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot record %s %s: %v\n", req.Method, x.URL, err)
+	}
+	return resp, nil
+}
+
+// replayExchange answers req with the next recorded response to the same
+// request, or the last one once they are used up
+func replayExchange(req *http.Request, bodySum string) (*http.Response, error) {
+	key := tapeKey(req.Method, req.URL.String(), bodySum)
+	tape.mu.Lock()
+	list := tape.exchanges[key]
+	i := tape.served[key]
+	if i < len(list) {
+		tape.served[key]++
+	}
+	tape.mu.Unlock()
+	if len(list) == 0 {
+		return nil, fmt.Errorf("replay: no recorded response to %s %s", req.Method, req.URL)
+	}
+	x := list[min(i, len(list)-1)]
+	body, err := os.ReadFile(x.prefix + ".body")
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(x.Status) + " " + http.StatusText(x.Status),
+		StatusCode:    x.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        x.Header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func tapeKey(method, rawURL, bodySum string) string {
+	return method + " " + rawURL + " " + bodySum
+}
+
+// requestBodySHA256 hashes a request body without consuming it; "" when
+// there is none
+func requestBodySHA256(req *http.Request) string {
+	if req.GetBody == nil || req.ContentLength == 0 {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	h := sha256.New()
+	io.Copy(h, body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var redactRes sync.Map // domain -> *regexp.Regexp
+
+// redactHosts replaces domain and the hostnames under it in s: the domain
+// becomes redactedDomain and each host a stable pseudonym under it, so the
+// same host reads the same in every capture. An empty domain leaves s as
+// it is.
+func redactHosts(s, domain string) string {
+	if domain == "" {
+		return s
+	}
+	re, ok := redactRes.Load(domain)
+	if !ok {
+		re, _ = redactRes.LoadOrStore(domain, regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)*`+regexp.QuoteMeta(domain)+`\b`))
+	}
+	return re.(*regexp.Regexp).ReplaceAllStringFunc(s, func(host string) string {
+		host = strings.ToLower(host)
+		if host == domain {
+			return redactedDomain
+		}
+		sum := sha256.Sum256([]byte(host))
+		return "h" + hex.EncodeToString(sum[:4]) + "." + redactedDomain
+	})
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestReplayRecording replays testdata/tape, a -record of a scan of
+// example.com, with none of the tools on the PATH: the results are the
+// recorded run's
+func TestReplayRecording(t *testing.T) {
+	dir := t.TempDir()
+	tape, err := filepath.Abs(filepath.Join("testdata", "tape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "results.json")
+	code, _, stderr := runMain(t, dir, []string{"HOME=" + dir, "PATH=" + dir},
+		"-workdir", filepath.Join(dir, "work"), "-o", out, "-sources", "subfinder", "-resolvers", "127.0.0.1:1",
+		"-replay", tape, "example.com")
+	if code != 0 {
+		t.Fatalf("exit %d:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "6 commands and 0 HTTP responses") || strings.Contains(stderr, "was not run like this") ||
+		!strings.Contains(stderr, `"tool_versions":{"httpx":"1.6.0","nmap":"7.94","subfinder":"2.6.6"}`) {
+		t.Errorf("the recording was not replayed as it was made:\n%s", stderr)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var r Result
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		got = append(got, r.URL+" "+r.Title+" "+strings.Join(r.TechStack, ",")+" "+r.IP)
+	}
+	sort.Strings(got)
+	want := []string{
+		"https://api.example.com Title of api.example.com nginx:1.25 192.0.2.10",
+		"https://www.example.com Title of www.example.com nginx:1.25 192.0.2.10",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("results\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
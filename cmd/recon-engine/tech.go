@@ -0,0 +1,147 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//go:embed rules/tech-aliases.json
+var defaultTechAliases []byte
+
+// techAliases maps lower-cased WhatWeb plugin and httpx (Wappalyzer) names
+// to canonical technology names. An empty name marks a WhatWeb plugin that
+// is not a technology, such as Country or Title.
+var techAliases map[string]string
+
+// whatwebStringPlugins are WhatWeb plugins whose string names the
+// technology: HTTPServer "nginx/1.18.0 (Ubuntu)", MetaGenerator
+// "WordPress 6.4"
+var whatwebStringPlugins = map[string]bool{
+	"httpserver":    true,
+	"x-powered-by":  true,
+	"metagenerator": true,
+	"poweredby":     true,
+}
+
+// configureTechAliases loads the embedded alias table and -tech-aliases on
+// top of it. Called once after flag parsing.
+func configureTechAliases() error {
+	aliases, err := parseTechAliases(defaultTechAliases)
+	if err != nil {
+		return fmt.Errorf("embedded aliases: %w", err)
+	}
+	if techAliasesPath != "" {
+		data, err := os.ReadFile(techAliasesPath)
+		if err != nil {
+			return err
+		}
+		more, err := parseTechAliases(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", techAliasesPath, err)
+		}
+		for k, v := range more {
+			aliases[k] = v
+		}
+	}
+	techAliases = aliases
+	return nil
+}
+
+// parseTechAliases reads a JSON object of name -> canonical name
+func parseTechAliases(data []byte) (map[string]string, error) {
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string, len(raw))
+	for k, v := range raw {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" {
+			return nil, fmt.Errorf("empty alias for %q", v)
+		}
+		aliases[k] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return aliases, nil
+}
+
+// canonicalTech returns the canonical name of a technology, or "" when it
+// is not one. Names missing from the table are kept, lower-cased.
+func canonicalTech(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if c, ok := techAliases[name]; ok {
+		return c
+	}
+	return name
+}
+
+// techEntry canonicalizes a TechStack entry, which httpx writes as
+// "Name:version" when it knows the version
+func techEntry(entry string) string {
+	name, version, _ := strings.Cut(entry, ":")
+	name = canonicalTech(name)
+	if version = strings.TrimSpace(version); name != "" && version != "" {
+		return name + ":" + version
+	}
+	return name
+}
+
+// techSet is a TechStack keyed on canonical names
+type techSet struct {
+	stack []string
+	index map[string]int // canonical name -> position in stack
+}
+
+func newTechSet(stack []string) *techSet {
+	s := &techSet{index: make(map[string]int)}
+	for _, t := range stack {
+		s.Add(t)
+	}
+	return s
+}
+
+// Add adds a technology, given as a name or "name:version". An entry with a
+// version replaces one without.
+func (s *techSet) Add(entry string) {
+	entry = techEntry(entry)
+	if entry == "" {
+		return
+	}
+	name, _, versioned := strings.Cut(entry, ":")
+	if i, ok := s.index[name]; ok {
+		if versioned && !strings.Contains(s.stack[i], ":") {
+			s.stack[i] = entry
+		}
+		return
+	}
+	s.index[name] = len(s.stack)
+	s.stack = append(s.stack, entry)
+}
+
+// Stack returns the technologies in the order they were first added
+func (s *techSet) Stack() []string {
+	if s.stack == nil {
+		return []string{}
+	}
+	return s.stack
+}
+
+// whatwebTech returns the technology a WhatWeb plugin match stands for, or
+// "" when it is not one
+func whatwebTech(plugin string, info WhatWebPlugin) string {
+	key := strings.ToLower(plugin)
+	if !whatwebStringPlugins[key] {
+		return canonicalTech(plugin)
+	}
+	if len(info.String) == 0 {
+		return ""
+	}
+	// "nginx/1.18.0 (Ubuntu)", "PHP/8.1.2", "WordPress 6.4"
+	name := strings.FieldsFunc(info.String[0], func(r rune) bool { return r == '/' || r == ' ' })
+	if len(name) == 0 {
+		return ""
+	}
+	return canonicalTech(name[0])
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func loadTechAliases(t *testing.T, path string) {
+	t.Helper()
+	oldPath, oldAliases := techAliasesPath, techAliases
+	t.Cleanup(func() { techAliasesPath, techAliases = oldPath, oldAliases })
+	techAliasesPath = path
+	if err := configureTechAliases(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCanonicalTech maps the names httpx (Wappalyzer) and WhatWeb report
+// most often, spelled as each of them spells them
+func TestCanonicalTech(t *testing.T) {
+	loadTechAliases(t, "")
+	for name, want := range map[string]string{
+		// Servers
+		"Nginx":                  "nginx",
+		"nginx":                  "nginx",
+		"Apache":                 "apache",
+		"Apache HTTP Server":     "apache",
+		"Microsoft-IIS":          "microsoft-iis",
+		"IIS":                    "microsoft-iis",
+		"Microsoft-HTTPAPI":      "microsoft-httpapi",
+		"Apache Tomcat":          "tomcat",
+		"Apache-Coyote":          "tomcat",
+		"OpenResty":              "openresty",
+		"LiteSpeed":              "litespeed",
+		"Caddy":                  "caddy",
+		"Envoy":                  "envoy",
+		"Jetty":                  "jetty",
+		"Oracle WebLogic Server": "weblogic",
+		"Varnish":                "varnish",
+		"Gunicorn":               "gunicorn",
+		// CDNs and WAFs
+		"Cloudflare":        "cloudflare",
+		"Amazon CloudFront": "amazon-cloudfront",
+		"CloudFront":        "amazon-cloudfront",
+		"Amazon S3":         "amazon-s3",
+		"AmazonS3":          "amazon-s3",
+		"Amazon ELB":        "amazon-elb",
+		"AkamaiGHost":       "akamai",
+		"Akamai":            "akamai",
+		"Fastly":            "fastly",
+		"Incapsula":         "imperva",
+		"F5 BIG-IP":         "f5-big-ip",
+		"BigIP":             "f5-big-ip",
+		// Languages and frameworks
+		"PHP":               "php",
+		"ASP_NET":           "asp.net",
+		"Microsoft ASP.NET": "asp.net",
+		"Node.js":           "node.js",
+		"Express":           "express",
+		"Django":            "django",
+		"Laravel":           "laravel",
+		"Ruby on Rails":     "ruby-on-rails",
+		"Spring Boot":       "spring",
+		"OpenSSL":           "openssl",
+		// Applications
+		"WordPress":            "wordpress",
+		"Drupal":               "drupal",
+		"Joomla!":              "joomla",
+		"Magento":              "magento",
+		"Microsoft SharePoint": "sharepoint",
+		"Outlook Web App":      "outlook-web-app",
+		"Atlassian Jira":       "jira",
+		"Atlassian Confluence": "confluence",
+		"Jenkins":              "jenkins",
+		"GitLab":               "gitlab",
+		"Grafana":              "grafana",
+		"phpMyAdmin":           "phpmyadmin",
+		"RabbitMQ Management":  "rabbitmq",
+		"Sonatype Nexus":       "nexus",
+		// Front end
+		"jQuery":             "jquery",
+		"jQuery UI":          "jquery-ui",
+		"Bootstrap":          "bootstrap",
+		"React":              "react",
+		"Vue.js":             "vue.js",
+		"Next.js":            "next.js",
+		"Font Awesome":       "font-awesome",
+		"Google Analytics":   "google-analytics",
+		"Google Tag Manager": "google-tag-manager",
+		"Google Font API":    "google-fonts",
+		"reCAPTCHA":          "recaptcha",
+		"HSTS":               "hsts",
+		// WhatWeb plugins that are not technologies
+		"Country":                   "",
+		"IP":                        "",
+		"Title":                     "",
+		"HTML5":                     "",
+		"Cookies":                   "",
+		"HttpOnly":                  "",
+		"UncommonHeaders":           "",
+		"RedirectLocation":          "",
+		"PasswordField":             "",
+		"X-Frame-Options":           "",
+		"Strict-Transport-Security": "",
+		// Unknown names pass through, lower-cased
+		"Acme Portal": "acme portal",
+	} {
+		if got := canonicalTech(name); got != want {
+			t.Errorf("canonicalTech(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWhatWebTech(t *testing.T) {
+	loadTechAliases(t, "")
+	for _, c := range []struct {
+		plugin string
+		info   WhatWebPlugin
+		want   string
+	}{
+		{"HTTPServer", WhatWebPlugin{String: []string{"nginx/1.18.0 (Ubuntu)"}}, "nginx"},
+		{"HTTPServer", WhatWebPlugin{String: []string{"Microsoft-IIS/10.0"}}, "microsoft-iis"},
+		{"X-Powered-By", WhatWebPlugin{String: []string{"PHP/8.1.2"}}, "php"},
+		{"MetaGenerator", WhatWebPlugin{String: []string{"WordPress 6.4"}}, "wordpress"},
+		{"HTTPServer", WhatWebPlugin{}, ""},
+		{"WordPress", WhatWebPlugin{Version: []string{"6.4"}}, "wordpress"},
+		{"Country", WhatWebPlugin{String: []string{"UNITED STATES"}}, ""},
+	} {
+		if got := whatwebTech(c.plugin, c.info); got != c.want {
+			t.Errorf("whatwebTech(%s, %v) = %q, want %q", c.plugin, c.info.String, got, c.want)
+		}
+	}
+}
+
+// TestMergeWhatWebDedupes merges WhatWeb's names into an httpx stack that
+// spells the same technologies differently: each ends up once
+func TestMergeWhatWebDedupes(t *testing.T) {
+	loadTechAliases(t, "")
+	res := Result{TechStack: []string{"Nginx", "PHP:8.1", "jQuery"}}
+	mergeWhatWeb(&res, []WhatWebResult{{
+		Target:     "https://app.example.com",
+		HTTPStatus: 200,
+		Plugins: map[string]WhatWebPlugin{
+			"HTTPServer": {String: []string{"nginx/1.25.3"}},
+			"nginx":      {Version: []string{"1.25.3"}},
+			"PHP":        {Version: []string{"8.1.2"}},
+			"JQuery":     {Version: []string{"3.6.0"}},
+			"Country":    {String: []string{"UNITED STATES"}},
+			"Title":      {String: []string{"App"}},
+		},
+	}})
+	got := slices.Clone(res.TechStack)
+	sort.Strings(got)
+	if want := []string{"jquery", "nginx", "php:8.1"}; !slices.Equal(got, want) {
+		t.Errorf("TechStack %v, want %v", got, want)
+	}
+	keys := sortedKeys(res.Versions)
+	if want := []string{"jquery", "nginx", "php"}; !slices.Equal(keys, want) {
+		t.Errorf("Versions keys %v, want %v", keys, want)
+	}
+}
+
+func TestTechAliasesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	if err := os.WriteFile(path, []byte(`{"Acme Portal": "acme-portal", "Nginx": "web-server"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadTechAliases(t, path)
+	if got := canonicalTech("ACME portal"); got != "acme-portal" {
+		t.Errorf("added alias: %q", got)
+	}
+	if got := canonicalTech("nginx"); got != "web-server" {
+		t.Errorf("overridden alias: %q", got)
+	}
+	if got := canonicalTech("WordPress"); got != "wordpress" {
+		t.Errorf("embedded alias lost: %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// resultTemplate is the parsed -template or -template-file, nil for JSON
+var resultTemplate *template.Template
+
+// templateFuncs is the FuncMap available to -template
+var templateFuncs = template.FuncMap{
+	"join":    strings.Join,
+	"lower":   strings.ToLower,
+	"default": templateDefault,
+}
+
+// templateDefault returns def when v is empty, as in
+// {{default "-" .Title}}
+func templateDefault(def, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		if rv.Len() == 0 {
+			return def
+		}
+	default:
+		if rv.IsZero() {
+			return def
+		}
+	}
+	return v
+}
+
+// configureTemplate parses -template or -template-file. Called once after
+// flag parsing so a broken template fails before the scan starts.
+func configureTemplate() error {
+	text := templateText
+	switch {
+	case templateText != "" && templateFile != "":
+		return fmt.Errorf("-template and -template-file are mutually exclusive")
+	case templateFile != "":
+		b, err := os.ReadFile(templateFile)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	case templateText == "":
+		return nil
+	}
+	if plainOutput || outputFormat != "" {
+		return fmt.Errorf("templates replace JSON output and cannot be combined with -plain or -format")
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	t, err := template.New("result").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	resultTemplate = t
+	return nil
+}
+
+type templateEncoder struct {
+	w        io.Writer
+	t        *template.Template
+	warnOnce sync.Once
+}
+
+// Encode renders results through the template. A record the template fails
+// on is skipped, and only the first failure is logged. Anything else, such
+// as event records, stays JSON.
+func (e *templateEncoder) Encode(v interface{}) error {
+	res, ok := v.(Result)
+	if !ok {
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	var buf bytes.Buffer
+	if err := e.t.Execute(&buf, res); err != nil {
+		stats.Add("template.errors", 1)
+		e.warnOnce.Do(func() {
+			fmt.Fprintf(os.Stderr, "Template error, skipping records it fails on: %v\n", err)
+		})
+		return nil
+	}
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed rules/third-party.yaml
+var defaultThirdPartyVendors []byte
+
+// thirdPartyActiveStages are the enrichers -skip-third-party-active keeps
+// from third-party hosts: those that brute-force, log in or send crafted
+// requests, which the vendor never agreed to
+var thirdPartyActiveStages = map[string]bool{
+	"dirbrute":       true,
+	"default_creds":  true,
+	"redirect_check": true,
+}
+
+// thirdPartyVendor is an entry of rules/third-party.yaml or
+// -third-party-vendors
+type thirdPartyVendor struct {
+	Vendor string   `yaml:"vendor"`
+	CNAME  []string `yaml:"cname"` // suffixes of a name in the CNAME chain
+	CDN    []string `yaml:"cdn"`   // CDN names as httpx reports them
+	ASN    []int    `yaml:"asn"`
+}
+
+// thirdPartyVendors are the entries of -third-party-vendors followed by the
+// embedded ones
+var thirdPartyVendors []thirdPartyVendor
+
+// configureThirdParty loads the embedded vendors and -third-party-vendors.
+// Called once after flag parsing.
+func configureThirdParty() error {
+	if skipThirdPartyActive || thirdPartyVendorsPath != "" {
+		thirdPartyCheck = true
+	}
+	if !thirdPartyCheck {
+		return nil
+	}
+	vendors, err := parseThirdPartyVendors(defaultThirdPartyVendors)
+	if err != nil {
+		return fmt.Errorf("embedded vendors: %w", err)
+	}
+	if thirdPartyVendorsPath != "" {
+		data, err := os.ReadFile(thirdPartyVendorsPath)
+		if err != nil {
+			return err
+		}
+		more, err := parseThirdPartyVendors(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", thirdPartyVendorsPath, err)
+		}
+		vendors = append(more, vendors...)
+	}
+	thirdPartyVendors = vendors
+	return nil
+}
+
+// parseThirdPartyVendors reads a YAML list of vendors, lower-casing the
+// suffixes and CDN names
+func parseThirdPartyVendors(data []byte) ([]thirdPartyVendor, error) {
+	var vendors []thirdPartyVendor
+	if err := yaml.Unmarshal(data, &vendors); err != nil {
+		return nil, err
+	}
+	for i := range vendors {
+		v := &vendors[i]
+		if v.Vendor == "" {
+			return nil, fmt.Errorf("entry %d: vendor is required", i+1)
+		}
+		if len(v.CNAME) == 0 && len(v.CDN) == 0 && len(v.ASN) == 0 {
+			return nil, fmt.Errorf("vendor %q: needs a cname, cdn or asn to match", v.Vendor)
+		}
+		for j, s := range v.CNAME {
+			v.CNAME[j] = strings.Trim(strings.ToLower(strings.TrimSpace(s)), ".")
+			if v.CNAME[j] == "" {
+				return nil, fmt.Errorf("vendor %q: empty cname suffix", v.Vendor)
+			}
+		}
+		for j, s := range v.CDN {
+			v.CDN[j] = strings.ToLower(strings.TrimSpace(s))
+		}
+		for _, asn := range v.ASN {
+			if asn <= 0 || int64(asn) > 1<<32-1 {
+				return nil, fmt.Errorf("vendor %q: invalid asn %d", v.Vendor, asn)
+			}
+		}
+	}
+	return vendors, nil
+}
+
+// classifyThirdParty resolves the CNAME chain of res's host and labels res
+// with the vendor whose infrastructure serves it, if any, counting the
+// vendor for the run summary
+func classifyThirdParty(ctx context.Context, res *Result) {
+	if res.Subdomain != "" && len(res.CNAMEChain) == 0 {
+		// A failed lookup leaves the CDN and ASN to go by
+		res.CNAMEChain, _ = dnsResolver.CNAMEChain(ctx, res.Subdomain)
+	}
+	if len(res.CNAMEChain) == 0 && res.CNAME != "" {
+		res.CNAMEChain = []string{res.CNAME}
+	}
+	vendor, evidence := matchThirdParty(res.CNAMEChain, res.CDN, res.Asn)
+	if vendor == "" {
+		return
+	}
+	res.ThirdParty, res.ThirdPartyVendor, res.ThirdPartyEvidence = true, vendor, evidence
+	stats.Add("third_party.hosts", 1)
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	if summary.ThirdPartyVendors == nil {
+		summary.ThirdPartyVendors = make(map[string]int)
+	}
+	summary.ThirdPartyVendors[vendor]++
+}
+
+// matchThirdParty returns the vendor a CNAME chain, CDN name and ASN
+// ("AS13335") point at, and which of them did
+func matchThirdParty(chain []string, cdn, asn string) (string, string) {
+	for _, name := range chain {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		for _, v := range thirdPartyVendors {
+			for _, suffix := range v.CNAME {
+				if name == suffix || strings.HasSuffix(name, "."+suffix) {
+					return v.Vendor, "cname " + name
+				}
+			}
+		}
+	}
+	if cdn = strings.ToLower(cdn); cdn != "" {
+		for _, v := range thirdPartyVendors {
+			if contains(v.CDN, cdn) {
+				return v.Vendor, "cdn " + cdn
+			}
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(asn), "AS")); err == nil {
+		for _, v := range thirdPartyVendors {
+			for _, a := range v.ASN {
+				if a == n {
+					return v.Vendor, "asn " + asn
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// thirdPartySkipped reports whether -skip-third-party-active keeps the
+// enricher name from res
+func thirdPartySkipped(res *Result, name string) bool {
+	if !skipThirdPartyActive || !res.ThirdParty || !thirdPartyActiveStages[name] {
+		return false
+	}
+	stats.Add("third_party.skipped_steps", 1)
+	return true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadThirdParty loads the embedded vendors, and those of path if any
+func loadThirdParty(t *testing.T, path string) {
+	t.Helper()
+	oldCheck, oldPath, oldSkip, oldVendors := thirdPartyCheck, thirdPartyVendorsPath, skipThirdPartyActive, thirdPartyVendors
+	t.Cleanup(func() {
+		thirdPartyCheck, thirdPartyVendorsPath, skipThirdPartyActive, thirdPartyVendors = oldCheck, oldPath, oldSkip, oldVendors
+	})
+	thirdPartyCheck, thirdPartyVendorsPath, skipThirdPartyActive = true, path, false
+	if err := configureThirdParty(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestThirdPartyCNAMEFixtures matches the embedded vendors against the
+// real-world CNAME chains in testdata/thirdparty/cnames.txt
+func TestThirdPartyCNAMEFixtures(t *testing.T) {
+	loadThirdParty(t, "")
+	f, err := os.Open(filepath.Join("testdata", "thirdparty", "cnames.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	cases := 0
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		chain, want := fields[:len(fields)-1], fields[len(fields)-1]
+		if want == "-" {
+			want = ""
+		}
+		vendor, evidence := matchThirdParty(chain, "", "")
+		if vendor != want {
+			t.Errorf("%v: vendor %q, want %q", chain, vendor, want)
+		}
+		if vendor != "" && !strings.HasPrefix(evidence, "cname ") {
+			t.Errorf("%v: evidence %q", chain, evidence)
+		}
+		cases++
+	}
+	if err := sc.Err(); err != nil || cases == 0 {
+		t.Fatalf("%d cases read: %v", cases, err)
+	}
+}
+
+// TestThirdPartyPrecedence prefers the CNAME chain over the CDN over the
+// ASN, and -third-party-vendors over the embedded vendors
+func TestThirdPartyPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vendors.yaml")
+	data := "- vendor: acme-hosting\n  cname: [Hosting.Acme.example.]\n  asn: [64500]\n- vendor: acme-zendesk\n  cname: [help.zendesk.com]\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadThirdParty(t, path)
+	for _, c := range []struct {
+		chain         []string
+		cdn, asn      string
+		want, because string
+	}{
+		{[]string{"x.fastly.net"}, "cloudflare", "AS13335", "fastly", "cname x.fastly.net"},
+		{nil, "Cloudflare", "AS54113", "cloudflare", "cdn cloudflare"},
+		{nil, "", "as54113", "fastly", "asn as54113"},
+		{nil, "", "AS64500", "acme-hosting", "asn AS64500"},
+		{[]string{"WWW.HOSTING.ACME.EXAMPLE."}, "", "", "acme-hosting", "cname www.hosting.acme.example"},
+		{[]string{"example.help.zendesk.com"}, "", "", "acme-zendesk", "cname example.help.zendesk.com"},
+		{[]string{"example.zendesk.com"}, "", "", "zendesk", "cname example.zendesk.com"},
+		{nil, "", "AS15169", "", ""},
+	} {
+		vendor, evidence := matchThirdParty(c.chain, c.cdn, c.asn)
+		if vendor != c.want || evidence != c.because {
+			t.Errorf("%v %q %q: %q (%s), want %q (%s)", c.chain, c.cdn, c.asn, vendor, evidence, c.want, c.because)
+		}
+	}
+}
+
+func TestClassifyThirdParty(t *testing.T) {
+	loadThirdParty(t, "")
+	summary.mu.Lock()
+	oldVendors := summary.ThirdPartyVendors
+	summary.ThirdPartyVendors = nil
+	summary.mu.Unlock()
+	t.Cleanup(func() {
+		summary.mu.Lock()
+		summary.ThirdPartyVendors = oldVendors
+		summary.mu.Unlock()
+	})
+
+	results := []Result{
+		{Subdomain: "help.example.com", CNAMEChain: []string{"example.zendesk.com"}},
+		{CNAME: "example.zendesk.com"},
+		{CDN: "fastly"},
+		{Asn: "AS64496"},
+	}
+	for i := range results {
+		classifyThirdParty(context.Background(), &results[i])
+	}
+	if r := results[0]; !r.ThirdParty || r.ThirdPartyVendor != "zendesk" || r.ThirdPartyEvidence != "cname example.zendesk.com" {
+		t.Errorf("CNAME chain: %v %q %q", r.ThirdParty, r.ThirdPartyVendor, r.ThirdPartyEvidence)
+	}
+	if r := results[1]; r.ThirdPartyVendor != "zendesk" || len(r.CNAMEChain) != 1 {
+		t.Errorf("CNAME only: %q, chain %v", r.ThirdPartyVendor, r.CNAMEChain)
+	}
+	if r := results[3]; r.ThirdParty || r.ThirdPartyVendor != "" {
+		t.Errorf("own ASN labelled %q", r.ThirdPartyVendor)
+	}
+	if v := summary.ThirdPartyVendors; len(v) != 2 || v["zendesk"] != 2 || v["fastly"] != 1 {
+		t.Errorf("summary vendors %v", v)
+	}
+}
+
+// TestThirdPartySkipped keeps only the aggressive enrichers from
+// third-party hosts, and only with -skip-third-party-active
+func TestThirdPartySkipped(t *testing.T) {
+	loadThirdParty(t, "")
+	vendor := &Result{ThirdParty: true, ThirdPartyVendor: "zendesk"}
+	own := &Result{}
+	if thirdPartySkipped(vendor, "dirbrute") {
+		t.Error("skipped without -skip-third-party-active")
+	}
+	skipThirdPartyActive = true
+	for stage := range thirdPartyActiveStages {
+		if !thirdPartySkipped(vendor, stage) {
+			t.Errorf("%s not skipped on a third-party host", stage)
+		}
+		if thirdPartySkipped(own, stage) {
+			t.Errorf("%s skipped on an own host", stage)
+		}
+	}
+	if thirdPartySkipped(vendor, "headers") {
+		t.Error("a passive enricher was skipped")
+	}
+}
+
+func TestParseThirdPartyVendorsRejects(t *testing.T) {
+	for _, data := range []string{
+		"- cname: [example.com]\n",
+		"- vendor: acme\n",
+		"- vendor: acme\n  cname: [\" . \"]\n",
+		"- vendor: acme\n  asn: [0]\n",
+		"- vendor: acme\n  asn: [4294967296]\n",
+		"vendor: acme\n",
+	} {
+		if _, err := parseThirdPartyVendors([]byte(data)); err == nil {
+			t.Errorf("accepted %q", data)
+		}
+	}
+	if _, err := parseThirdPartyVendors(defaultThirdPartyVendors); err != nil {
+		t.Errorf("embedded vendors: %v", err)
+	}
+}
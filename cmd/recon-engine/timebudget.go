@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// budgetStages are the stages -time-budget divides its time between, in
+// the order their slices follow one another
+var budgetStages = []string{"discovery", "probe", "enrich"}
+
+// budgetMinReserve is the percentage of -time-budget always left after
+// enrichment's slice for writing the output, the summary and the report
+const budgetMinReserve = 5
+
+// budgetOptional are the enrichment stages skipped once probing's slice is
+// spent. Each adds detail to a host rather than hosts, and runs a child
+// process or many requests per host.
+var budgetOptional = map[string]bool{
+	"censys":         true,
+	"jarm":           true,
+	"dirbrute":       true,
+	"whatweb":        true,
+	"default_creds":  true,
+	"params":         true,
+	"redirect_check": true,
+	"cve":            true,
+}
+
+// timeBudgetSummary is in the run summary with -time-budget
+type timeBudgetSummary struct {
+	Budget string         `json:"budget"`
+	Split  map[string]int `json:"split"` // stage -> percent of the budget
+
+	// Truncated lists the stages cut off when their slice was spent, with
+	// how many names or hosts each left unprocessed
+	Truncated map[string]int `json:"truncated,omitempty"`
+
+	// Skipped counts the hosts each optional enricher was skipped for
+	Skipped map[string]int `json:"skipped_enrichers,omitempty"`
+}
+
+// budgetDeadlines is when each stage's slice ends, set by
+// configureTimeBudget
+var budgetDeadlines map[string]time.Time
+
+// configureTimeBudget validates -time-budget and -time-budget-split and sets
+// the stage deadlines. The budget counts from the start of the process, so
+// -org discovery and the startup checks spend it too. Called once after flag
+// parsing.
+func configureTimeBudget() error {
+	if timeBudget == 0 {
+		return nil
+	}
+	if timeBudget < 0 {
+		return fmt.Errorf("-time-budget must not be negative, got %s", timeBudget)
+	}
+	if monitor {
+		return fmt.Errorf("-time-budget bounds a single run and cannot be used with -monitor")
+	}
+	split, err := parseBudgetSplit(timeBudgetSplit)
+	if err != nil {
+		return err
+	}
+	budgetDeadlines = make(map[string]time.Time, len(budgetStages))
+	elapsed := 0
+	for _, stage := range budgetStages {
+		elapsed += split[stage]
+		budgetDeadlines[stage] = stats.start.Add(timeBudget * time.Duration(elapsed) / 100)
+	}
+	summary.TimeBudget = &timeBudgetSummary{Budget: timeBudget.String(), Split: split}
+	return nil
+}
+
+// parseBudgetSplit reads a comma-separated list of stage=percent pairs.
+// Stages left out keep their default share.
+func parseBudgetSplit(s string) (map[string]int, error) {
+	split := map[string]int{"discovery": 30, "probe": 30, "enrich": 30}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(pair, "=")
+		if _, known := split[name]; !known || !ok {
+			return nil, fmt.Errorf("-time-budget-split: %q is not stage=percent with stage one of %s", pair, strings.Join(budgetStages, ", "))
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("-time-budget-split: %s needs a percentage of at least 1, got %q", name, v)
+		}
+		split[name] = n
+	}
+	total := 0
+	for _, n := range split {
+		total += n
+	}
+	if total > 100-budgetMinReserve {
+		return nil, fmt.Errorf("-time-budget-split: the stages add up to %d%%; at most %d%% leaves time to write the output", total, 100-budgetMinReserve)
+	}
+	return split, nil
+}
+
+// budgetExpired reports whether stage's slice of -time-budget is spent
+func budgetExpired(stage string) bool {
+	return timeBudget > 0 && !time.Now().Before(budgetDeadlines[stage])
+}
+
+// budgetTruncated records that stage was cut off with n more items left
+// unprocessed. It trips the time-budget cap, so the run is not compared
+// against earlier ones as if it were complete.
+func budgetTruncated(stage string, n int) {
+	summary.mu.Lock()
+	tb := summary.TimeBudget
+	if tb.Truncated == nil {
+		tb.Truncated = make(map[string]int)
+	}
+	tb.Truncated[stage] += n
+	summary.mu.Unlock()
+	recordCap(capTimeBudget)
+}
+
+// budgetSkips reports whether the optional enrichment stage is skipped
+// because probing's slice of -time-budget is spent, and counts it
+func budgetSkips(stage string) bool {
+	if !budgetOptional[stage] || !budgetExpired("probe") {
+		return false
+	}
+	summary.mu.Lock()
+	tb := summary.TimeBudget
+	if tb.Skipped == nil {
+		tb.Skipped = make(map[string]int)
+	}
+	tb.Skipped[stage]++
+	summary.mu.Unlock()
+	return true
+}
+
+// budgetTimer calls cut once stage's slice of -time-budget is spent, unless
+// done is closed by then because the stage already finished. what says how
+// the run winds down. The returned func stops the timer.
+func budgetTimer(stage string, done <-chan struct{}, what string, cut func()) (stop func() bool) {
+	if timeBudget <= 0 {
+		return func() bool { return false }
+	}
+	t := time.AfterFunc(time.Until(budgetDeadlines[stage]), func() {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		budgetTruncated(stage, 0)
+		fmt.Fprintf(os.Stderr, "Spent the %s slice of -time-budget (%s), %s\n", stage, timeBudget, what)
+		cut()
+	})
+	return t.Stop
+}
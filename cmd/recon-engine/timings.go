@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timing keys besides the per-enricher ones, which are enrich.<name>
+const (
+	timingDiscoveredAt = "discovered_at" // offset from the start of the run
+	timingProbe        = "probe"
+	timingEnrich       = "enrich" // all enrichers together
+)
+
+var (
+	// discoveredAt is when each name was first reported, with -timings
+	// key: subdomain
+	discoveredAt   = make(map[string]time.Duration)
+	discoveredAtMu sync.Mutex
+
+	// timingSamples collects every Result's timings for the run summary
+	// key: timing key
+	timingSamples   = make(map[string][]float64)
+	timingSamplesMu sync.Mutex
+)
+
+// recordDiscoveredAt notes when name was first reported
+func recordDiscoveredAt(name string) {
+	if !timings {
+		return
+	}
+	discoveredAtMu.Lock()
+	defer discoveredAtMu.Unlock()
+	if _, ok := discoveredAt[name]; !ok {
+		discoveredAt[name] = stats.Elapsed()
+	}
+}
+
+// discoveredAtOf returns when name was first reported
+func discoveredAtOf(name string) (time.Duration, bool) {
+	discoveredAtMu.Lock()
+	defer discoveredAtMu.Unlock()
+	d, ok := discoveredAt[name]
+	return d, ok
+}
+
+// setTiming records d under key on res
+func setTiming(res *Result, key string, d time.Duration) {
+	if res.Timings == nil {
+		res.Timings = make(map[string]float64)
+	}
+	res.Timings[key] = d.Seconds()
+}
+
+// setProbeTimings records when res's name was discovered and how long it
+// took to probe: httpx's response time, or the wall clock since discovery
+// when httpx did not report one
+func setProbeTimings(res *Result) {
+	if !timings {
+		return
+	}
+	found, ok := discoveredAtOf(res.Subdomain)
+	if ok {
+		setTiming(res, timingDiscoveredAt, found)
+	}
+	switch {
+	case res.ResponseTimeMs > 0:
+		setTiming(res, timingProbe, time.Duration(res.ResponseTimeMs*float64(time.Millisecond)))
+	case ok:
+		setTiming(res, timingProbe, stats.Elapsed()-found)
+	}
+}
+
+// timeStep runs one enricher, recording its duration as enrich.<name>
+func timeStep(res *Result, name string, fn func()) {
+	if !timings {
+		fn()
+		return
+	}
+	start := time.Now()
+	fn()
+	setTiming(res, timingEnrich+"."+name, time.Since(start))
+}
+
+// observeTimings adds res's timings to the run summary's samples
+func observeTimings(res Result) {
+	if len(res.Timings) == 0 {
+		return
+	}
+	timingSamplesMu.Lock()
+	defer timingSamplesMu.Unlock()
+	for k, v := range res.Timings {
+		timingSamples[k] = append(timingSamples[k], v)
+	}
+}
+
+// timingStats summarises one timing key across a run, in seconds
+type timingStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// timingSummary returns percentiles for every timing key seen this run
+func timingSummary() map[string]timingStats {
+	timingSamplesMu.Lock()
+	defer timingSamplesMu.Unlock()
+	if len(timingSamples) == 0 {
+		return nil
+	}
+	out := make(map[string]timingStats, len(timingSamples))
+	for k, samples := range timingSamples {
+		sorted := append([]float64(nil), samples...)
+		sort.Float64s(sorted)
+		out[k] = timingStats{
+			Count: len(sorted),
+			P50:   percentile(sorted, 50),
+			P90:   percentile(sorted, 90),
+			P99:   percentile(sorted, 99),
+			Max:   sorted[len(sorted)-1],
+		}
+	}
+	return out
+}
+
+// percentile returns the nearest-rank percentile p of sorted
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+// externalTools lists the tools whose location can be overridden with
+// -<tool>-bin or RECON_<TOOL>_BIN
+var externalTools = []string{"subfinder", "httpx", "amass", "whatweb", "nmap", "naabu", "masscan", "ffuf", "tlsx", "chromium"}
+
+// toolBins holds the -<tool>-bin values, keyed by tool name
+var toolBins = make(map[string]*string)
+
+// registerToolFlags defines the -<tool>-bin flags. The environment variable
+// provides the default, so an explicit flag wins over it.
+func registerToolFlags() {
+	for _, tool := range externalTools {
+		env := toolEnv(tool)
+		toolBins[tool] = flag.String(tool+"-bin", os.Getenv(env), "Path to the "+tool+" binary or a wrapper script (env "+env+")")
+	}
+}
+
+func toolEnv(tool string) string {
+	return "RECON_" + strings.ToUpper(tool) + "_BIN"
+}
+
+// toolPath returns the command to run for tool: the configured override, or
+// the bare name to be found on PATH
+func toolPath(tool string) string {
+	if p, ok := toolBins[tool]; ok && *p != "" {
+		return *p
+	}
+	return tool
+}
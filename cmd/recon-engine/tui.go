@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"golang.org/x/term"
+)
+
+// tuiLogLines is how much stderr the log pane keeps, and replays once the
+// TUI exits
+const tuiLogLines = 200
+
+// validateTUI checks that -tui can take over the terminal
+func validateTUI() error {
+	if !tuiFlag {
+		return nil
+	}
+	if monitor {
+		return fmt.Errorf("-tui is for single runs, not -monitor")
+	}
+	if outputPath == "" {
+		return fmt.Errorf("-tui takes over the terminal, so results need -o")
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("-tui needs an interactive terminal; stdin or stdout is not a TTY")
+	}
+	return nil
+}
+
+// tuiRow is the part of a Result the table shows
+type tuiRow struct {
+	subdomain string
+	status    int
+	title     string
+	tech      string
+}
+
+// tuiView is the live results table shown with -tui. Results are written to
+// -o as usual; the view only mirrors them.
+type tuiView struct {
+	app    *tview.Application
+	header *tview.TextView
+	table  *tview.Table
+	logs   *tview.TextView
+
+	mu       sync.Mutex
+	rows     []tuiRow
+	class    int // status class shown, 0 for all
+	stopping bool
+	finished bool
+
+	target  string
+	quit    func()
+	done    chan struct{}
+	stderr  *os.File // the real stderr, restored by Close
+	logPipe *os.File
+	logDone chan struct{}
+	logTail []string
+}
+
+// startTUI takes over the terminal and starts redirecting stderr to the log
+// pane. quit is called when the user asks to stop.
+func startTUI(target string, quit func()) *tuiView {
+	v := &tuiView{
+		app:     tview.NewApplication(),
+		header:  tview.NewTextView(),
+		table:   tview.NewTable().SetFixed(1, 0),
+		logs:    tview.NewTextView().SetMaxLines(tuiLogLines),
+		target:  target,
+		quit:    quit,
+		done:    make(chan struct{}),
+		stderr:  os.Stderr,
+		logDone: make(chan struct{}),
+	}
+	v.logs.SetBorder(true).SetTitle(" log ")
+	v.logs.SetChangedFunc(func() { v.logs.ScrollToEnd() })
+	v.renderTable()
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(v.header, 3, 0, false).
+		AddItem(v.table, 0, 1, true).
+		AddItem(v.logs, 8, 0, false)
+	v.app.SetRoot(layout, true).SetInputCapture(v.key)
+
+	// Anything written to stderr while the TUI owns the terminal would tear
+	// the screen, so it goes to the log pane instead
+	r, w, err := os.Pipe()
+	if err == nil {
+		v.logPipe = w
+		os.Stderr = w
+		go v.readLogs(r)
+	} else {
+		close(v.logDone)
+	}
+
+	go func() {
+		defer close(v.done)
+		if err := v.app.Run(); err != nil {
+			fmt.Fprintf(v.stderr, "TUI error: %v\n", err)
+		}
+	}()
+	go v.tick()
+	return v
+}
+
+func (v *tuiView) key(ev *tcell.EventKey) *tcell.EventKey {
+	switch {
+	case ev.Key() == tcell.KeyCtrlC, ev.Rune() == 'q':
+		v.mu.Lock()
+		finished := v.finished
+		v.stopping = true
+		v.mu.Unlock()
+		if finished {
+			v.app.Stop()
+			return nil
+		}
+		v.quit()
+	case ev.Rune() >= '1' && ev.Rune() <= '5':
+		v.setClass(int(ev.Rune() - '0'))
+	case ev.Rune() == 'a', ev.Rune() == '0':
+		v.setClass(0)
+	default:
+		return ev
+	}
+	v.renderHeader()
+	return nil
+}
+
+// Add shows res in the table
+func (v *tuiView) Add(res Result) {
+	row := tuiRow{subdomain: res.Subdomain, status: res.StatusCode, title: res.Title, tech: strings.Join(res.TechStack, ", ")}
+	if row.subdomain == "" {
+		row.subdomain = res.IP
+	}
+	if res.Port != 0 && res.Port != 80 && res.Port != 443 {
+		row.subdomain += ":" + strconv.Itoa(res.Port)
+	}
+	v.mu.Lock()
+	v.rows = append(v.rows, row)
+	show := v.class == 0 || row.status/100 == v.class
+	v.mu.Unlock()
+	if show {
+		v.queue(func() { v.addRow(row) })
+	}
+}
+
+func (v *tuiView) setClass(class int) {
+	v.mu.Lock()
+	v.class = class
+	v.mu.Unlock()
+	v.renderTable()
+}
+
+// renderTable rebuilds the table for the current filter. Called from the
+// application goroutine or before it starts.
+func (v *tuiView) renderTable() {
+	v.table.Clear()
+	for i, h := range []string{"SUBDOMAIN", "STATUS", "TITLE", "TECH"} {
+		v.table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+	v.mu.Lock()
+	rows := append([]tuiRow(nil), v.rows...)
+	class := v.class
+	v.mu.Unlock()
+	for _, row := range rows {
+		if class == 0 || row.status/100 == class {
+			v.addRow(row)
+		}
+	}
+}
+
+func (v *tuiView) addRow(row tuiRow) {
+	n := v.table.GetRowCount()
+	status := "-"
+	if row.status > 0 {
+		status = strconv.Itoa(row.status)
+	}
+	v.table.SetCell(n, 0, tview.NewTableCell(row.subdomain))
+	v.table.SetCell(n, 1, tview.NewTableCell(status).SetTextColor(statusColor(row.status)))
+	v.table.SetCell(n, 2, tview.NewTableCell(row.title).SetMaxWidth(50))
+	v.table.SetCell(n, 3, tview.NewTableCell(row.tech).SetExpansion(1))
+}
+
+func statusColor(code int) tcell.Color {
+	switch code / 100 {
+	case 2:
+		return tcell.ColorGreen
+	case 3:
+		return tcell.ColorTeal
+	case 4:
+		return tcell.ColorOrange
+	case 5:
+		return tcell.ColorRed
+	}
+	return tcell.ColorGray
+}
+
+// renderHeader shows progress, per-source discovery counts and the keys
+func (v *tuiView) renderHeader() {
+	v.mu.Lock()
+	total, class, stopping, finished := len(v.rows), v.class, v.stopping, v.finished
+	v.mu.Unlock()
+
+	filter := "all"
+	if class != 0 {
+		filter = strconv.Itoa(class) + "xx"
+	}
+	state := ""
+	switch {
+	case finished:
+		state = "  [finished, q to exit]"
+	case stopping:
+		state = "  [stopping]"
+	}
+	var sources []string
+	for name, n := range stats.Snapshot() {
+		if src, ok := strings.CutPrefix(name, "sources."); ok {
+			sources = append(sources, fmt.Sprintf("%s %d", src, n))
+		}
+	}
+	sort.Strings(sources)
+	if len(sources) == 0 {
+		sources = []string{"none yet"}
+	}
+	v.header.SetText(fmt.Sprintf("%s  elapsed %s  results %d  showing %s%s\ndiscovered: %s\nkeys: 1-5 status class, a all, q quit",
+		v.target, stats.Elapsed().Round(time.Second), total, filter, state, strings.Join(sources, "  ")))
+}
+
+// queue runs f on the application goroutine, unless the TUI has exited
+func (v *tuiView) queue(f func()) {
+	select {
+	case <-v.done:
+	default:
+		v.app.QueueUpdateDraw(f)
+	}
+}
+
+// tick refreshes the header once a second
+func (v *tuiView) tick() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		v.queue(v.renderHeader)
+		select {
+		case <-v.done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (v *tuiView) readLogs(r *os.File) {
+	defer close(v.logDone)
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		line := lines.Text()
+		v.mu.Lock()
+		v.logTail = append(v.logTail, line)
+		if len(v.logTail) > tuiLogLines {
+			v.logTail = v.logTail[1:]
+		}
+		v.mu.Unlock()
+		v.queue(func() { fmt.Fprintln(v.logs, line) })
+	}
+}
+
+// Finish shows that the run is over and waits for the user to leave the
+// TUI, unless they already asked to stop, then closes it
+func (v *tuiView) Finish() {
+	v.mu.Lock()
+	v.finished = true
+	stopping := v.stopping
+	v.mu.Unlock()
+	if stopping {
+		v.Close()
+		return
+	}
+	v.queue(v.renderHeader)
+	<-v.done
+	v.Close()
+}
+
+// Close gives the terminal back, restores stderr and replays the end of the
+// log there so errors are not lost with the screen
+func (v *tuiView) Close() {
+	v.app.Stop()
+	<-v.done
+	if v.logPipe == nil {
+		return
+	}
+	os.Stderr = v.stderr
+	v.logPipe.Close()
+	<-v.logDone
+	for _, line := range v.logTail {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
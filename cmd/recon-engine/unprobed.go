@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// unprobedResult is the discovery-only record of a name httpx gave no
+// answer for. It carries no status code, and only the resolution data a
+// source already held.
+func unprobedResult(target, name string) Result {
+	res := Result{
+		RunID:            runID,
+		RootDomain:       target,
+		EngineVersion:    version,
+		SchemaVersion:    schemaVersion,
+		Timestamp:        time.Now().Format(time.RFC3339),
+		Subdomain:        name,
+		TechStack:        []string{},
+		Vulnerabilities:  []Finding{},
+		Source:           legacySource(name),
+		Sources:          discoverySources(name),
+		SubfinderSources: subfinderSourcesFor(name),
+	}
+	if rec, ok := lookupDNSRecord(name); ok {
+		if res.IPs = hostIPs(rec.A, rec.AAAA); len(res.IPs) > 0 {
+			res.IP = res.IPs[0]
+		}
+		res.CNAME = rec.CNAME
+	}
+	if found, ok := discoveredAtOf(name); ok {
+		setTiming(&res, timingDiscoveredAt, found)
+		observeTimings(res)
+	}
+	return res
+}
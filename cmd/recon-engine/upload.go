@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/oauth2/google"
+)
+
+// exitUploadFailed is returned when -upload could not store the run output
+// and no CI gate tripped
+const exitUploadFailed = 4
+
+const (
+	uploadAttempts = 5
+	// uploadTimeout bounds the final upload, which also runs after an
+	// interrupt when the scan context is already cancelled
+	uploadTimeout = 5 * time.Minute
+)
+
+// objectStore puts one object into the -upload bucket
+type objectStore interface {
+	put(ctx context.Context, key, contentType string, body io.ReadSeeker, size int64) error
+}
+
+var (
+	uploader     objectStore
+	uploadPrefix string   // key prefix including date and run ID
+	uploadOut    *os.File // copy of stdout when there is no -o file
+
+	// the output file and the object it is stored as
+	resultsPath, resultsName, resultsType string
+
+	partialStop chan struct{}
+	partialDone sync.WaitGroup
+)
+
+// configureUpload parses -upload, sets up the bucket client from the
+// standard AWS or Google credential chain and starts copying stdout to a
+// local file. Called once after flag parsing.
+func configureUpload() error {
+	if uploadURL == "" {
+		if uploadPartialInterval > 0 {
+			return fmt.Errorf("-upload-partial-interval needs -upload")
+		}
+		return nil
+	}
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s: no bucket", uploadURL)
+	}
+	switch u.Scheme {
+	case "s3":
+		// On EC2 the region comes from instance metadata unless configured
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithEC2IMDSRegion())
+		if err != nil {
+			return err
+		}
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		uploader = &s3Store{client: s3.NewFromConfig(cfg), bucket: u.Host}
+	case "gs":
+		client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/devstorage.read_write")
+		if err != nil {
+			return err
+		}
+		uploader = &gcsStore{client: client, bucket: u.Host}
+	default:
+		return fmt.Errorf("unsupported scheme %q (want s3 or gs)", u.Scheme)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	uploadPrefix = prefix + time.Now().UTC().Format("2006-01-02") + "/" + runID + "/"
+
+	resultsPath, resultsName, resultsType = outputPath, filepath.Base(outputPath), "application/x-ndjson"
+	if compressOutput {
+		resultsType = "application/gzip"
+	}
+	if outputPath == "" {
+		if uploadOut, err = os.CreateTemp("", "recon-results-*.ndjson"); err != nil {
+			return err
+		}
+		stdout.mu.Lock()
+		stdout.w = io.MultiWriter(os.Stdout, uploadOut)
+		stdout.mu.Unlock()
+		resultsPath, resultsName = uploadOut.Name(), "results.ndjson"
+	}
+
+	if uploadPartialInterval > 0 {
+		partialStop = make(chan struct{})
+		partialDone.Add(1)
+		go uploadPartial()
+	}
+	return nil
+}
+
+// uploadPartial re-uploads the output written so far every
+// -upload-partial-interval, so an instance lost mid-run still leaves most of
+// its results behind
+func uploadPartial() {
+	defer partialDone.Done()
+	ticker := time.NewTicker(uploadPartialInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-partialStop:
+			return
+		case <-ticker.C:
+		}
+		err := flushOutput()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), uploadPartialInterval)
+			err = uploadFile(ctx, resultsName, resultsType, resultsPath)
+			cancel()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Partial upload failed: %v\n", err)
+			reportToolError("upload", "partial", "", err)
+		}
+	}
+}
+
+// finishUpload uploads the output, the run summary and the nmap report.
+// The -o file must be closed first. It returns false when any of them could
+// not be stored.
+func finishUpload() bool {
+	if uploader == nil {
+		return true
+	}
+	if partialStop != nil {
+		close(partialStop)
+		partialDone.Wait()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	ok := true
+	fail := func(name string, err error) {
+		fmt.Fprintf(os.Stderr, "Upload of %s failed: %v\n", name, err)
+		reportToolError("upload", name, "", err)
+		ok = false
+	}
+	if err := uploadFile(ctx, resultsName, resultsType, resultsPath); err != nil {
+		fail("results", err)
+	}
+	if uploadOut != nil {
+		uploadOut.Close()
+		os.Remove(uploadOut.Name())
+	}
+
+	if b := summary.encoded(); b != nil {
+		if err := uploadBytes(ctx, "summary.json", "application/json", b); err != nil {
+			fail("summary", err)
+		}
+	}
+	if nmapOutput != "" {
+		if _, err := os.Stat(nmapOutput); err == nil {
+			if err := uploadFile(ctx, filepath.Base(nmapOutput), "text/plain", nmapOutput); err != nil {
+				fail("nmap report", err)
+			}
+		}
+	}
+	if eventsFile != "" {
+		if err := uploadFile(ctx, filepath.Base(eventsFile), "application/x-ndjson", eventsFile); err != nil {
+			fail("events", err)
+		}
+	}
+	if ok {
+		fmt.Fprintf(os.Stderr, "Uploaded run output to %s\n", strings.TrimSuffix(uploadURL, "/"))
+	}
+	return ok
+}
+
+// uploadFile uploads what the file at p holds right now; data appended
+// while the upload runs is left for the next one
+func uploadFile(ctx context.Context, name, contentType, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return uploadWithRetry(ctx, name, contentType, io.NewSectionReader(f, 0, fi.Size()), fi.Size())
+}
+
+func uploadBytes(ctx context.Context, name, contentType string, b []byte) error {
+	return uploadWithRetry(ctx, name, contentType, bytes.NewReader(b), int64(len(b)))
+}
+
+// uploadWithRetry puts body under the run's prefix, backing off between
+// attempts
+func uploadWithRetry(ctx context.Context, name, contentType string, body io.ReadSeeker, size int64) error {
+	key := uploadPrefix + name
+	backoff := time.Second
+	var err error
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err = uploader.put(ctx, key, contentType, body, size); err == nil {
+			return nil
+		}
+		if attempt == uploadAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func (s *s3Store) put(ctx context.Context, key, contentType string, body io.ReadSeeker, size int64) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	})
+	return err
+}
+
+// gcsStore uses the JSON API's simple media upload, authenticated by the
+// application default credentials
+type gcsStore struct {
+	client *http.Client
+	bucket string
+}
+
+func (g *gcsStore) put(ctx context.Context, key, contentType string, body io.ReadSeeker, size int64) error {
+	q := url.Values{}
+	q.Set("uploadType", "media")
+	q.Set("name", key)
+	endpoint := "https://storage.googleapis.com/upload/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("gcs: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parseURLTarget reports whether target is an http or https URL rather than
+// a domain, and returns it normalized: lower-case scheme and host, no
+// default port, no fragment and / for an empty path
+func parseURLTarget(target string) (*url.URL, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		return nil, false
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, false
+	}
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	u.Host = host
+	if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	}
+	u.User, u.Fragment, u.RawFragment = nil, "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, true
+}
+
+// targetURL is the normalized URL of a URL target, probed as given without
+// discovery; nil for domain and IP targets
+func targetURL(target string) *url.URL {
+	u, _ := parseURLTarget(target)
+	return u
+}
+
+// validateURLTarget checks a URL target. httpx probes it at its own port and
+// path, so -probe-ports is refused along with the discovery flags. Domain
+// and IP targets pass.
+func validateURLTarget(target string) error {
+	u, ok := parseURLTarget(target)
+	if !ok {
+		return nil
+	}
+	if f := discoveryFlag(); f != "" {
+		return fmt.Errorf("%s needs a domain target, %s is probed without discovery", f, target)
+	}
+	if probePorts != "" {
+		return fmt.Errorf("-probe-ports does not apply to %s, which is probed at its own port", target)
+	}
+	if !scopeAllowsHost(u.Hostname()) {
+		return fmt.Errorf("%s is outside -scope", target)
+	}
+	return nil
+}
+
+// targetHost is the name the target stands for: its host for a URL target,
+// the target itself otherwise
+func targetHost(target string) string {
+	if u := targetURL(target); u != nil {
+		return u.Hostname()
+	}
+	return target
+}
+
+// withoutDiscovery reports whether target is an IP, CIDR or URL target,
+// which stands in for discovery with its own addresses or the URL itself
+func withoutDiscovery(target string) bool {
+	return targetRange(target).IsValid() || targetURL(target) != nil
+}
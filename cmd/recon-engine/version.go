@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// version is the engine version, set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// runID identifies this run in every Result and the summary. It is taken
+// from -run-id or generated at startup.
+var runID string
+
+// newRunID returns a random RFC 4122 version 4 UUID
+func newRunID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// toolVersionSpec describes how to ask a tool for its version and the
+// oldest release the engine works with
+type toolVersionSpec struct {
+	args []string
+	re   *regexp.Regexp // first submatch is the version
+	min  string
+}
+
+// toolVersions holds the per-tool version checks. Banners differ between
+// tools, e.g.:
+//
+//	subfinder -version  [INF] Current Version: v2.6.6
+//	httpx -version      [INF] Current Version: v1.6.0
+//	amass -version      v4.2.0
+//	nmap --version      Nmap version 7.94 ( https://nmap.org )
+//	whatweb --version   WhatWeb version 0.5.5 ( https://morningstarsecurity.com/research/whatweb/ )
+//	naabu -version      [INF] Current Version: 2.3.0
+//	ffuf -V             ffuf version: 2.1.0-dev
+//	tlsx -version       [INF] Current Version: v1.1.6
+//	masscan --version   Masscan version 1.3.2 ( https://github.com/robertdavidgraham/masscan )
+//	dnsx -version       [INF] Current Version: 1.2.1
+//	nuclei -version     [INF] Nuclei Engine Version: v3.3.2
+//	katana -version     [INF] Current version: v1.1.0
+//	chromium --version  Chromium 120.0.6099.224 built on Debian 12.4
+var toolVersions = map[string]toolVersionSpec{
+	"subfinder": {[]string{"-version"}, regexp.MustCompile(`Current Version: v?(\d+\.\d+(?:\.\d+)?)`), "2.5.0"},
+	"httpx":     {[]string{"-version"}, regexp.MustCompile(`Current Version: v?(\d+\.\d+(?:\.\d+)?)`), minHttpxVersion},
+	"amass":     {[]string{"-version"}, regexp.MustCompile(`(?m)^v?(\d+\.\d+(?:\.\d+)?)`), "3.19.0"},
+	"nmap":      {[]string{"--version"}, regexp.MustCompile(`Nmap version (\d+\.\d+(?:\.\d+)?)`), "7.0"},
+	"whatweb":   {[]string{"--version"}, regexp.MustCompile(`WhatWeb version (\d+\.\d+(?:\.\d+)?)`), "0.5.0"},
+	"ffuf":      {[]string{"-V"}, regexp.MustCompile(`ffuf version: v?(\d+\.\d+(?:\.\d+)?)`), "2.0.0"},
+	"masscan":   {[]string{"--version"}, regexp.MustCompile(`Masscan version (\d+\.\d+(?:\.\d+)?)`), "1.0.5"},
+	"naabu":     {[]string{"-version"}, regexp.MustCompile(`Current Version: v?(\d+\.\d+(?:\.\d+)?)`), "2.1.0"},
+	"tlsx":      {[]string{"-version"}, regexp.MustCompile(`Current Version: v?(\d+\.\d+(?:\.\d+)?)`), "1.1.0"},
+	"dnsx":      {[]string{"-version"}, regexp.MustCompile(`Current Version: v?(\d+\.\d+(?:\.\d+)?)`), "1.1.0"},
+	"nuclei":    {[]string{"-version"}, regexp.MustCompile(`Engine Version: v?(\d+\.\d+(?:\.\d+)?)`), "3.0.0"},
+	"katana":    {[]string{"-version"}, regexp.MustCompile(`(?i)Current Version: v?(\d+\.\d+(?:\.\d+)?)`), "1.0.0"},
+	"chromium":  {[]string{"--version"}, regexp.MustCompile(`(?:Chromium|Chrome) (\d+\.\d+(?:\.\d+)?)`), "112.0"},
+}
+
+var (
+	detectedMu       sync.Mutex
+	detectedVersions = make(map[string]string) // tool -> version, "" when unknown
+)
+
+// toolVersion runs tool's version command once and returns the parsed
+// version, or "" when the banner was not recognised
+func toolVersion(tool string) string {
+	detectedMu.Lock()
+	defer detectedMu.Unlock()
+	if v, ok := detectedVersions[tool]; ok {
+		return v
+	}
+	spec, ok := toolVersions[tool]
+	if !ok {
+		return ""
+	}
+	v := readVersion(toolPath(tool), spec)
+	detectedVersions[tool] = v
+	return v
+}
+
+// readVersion runs the version command of the binary at path, uncached
+func readVersion(path string, spec toolVersionSpec) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Most of these tools print the banner on stderr
+	out, _ := toolCombinedOutput(toolCommand(ctx, path, spec.args...))
+	if m := spec.re.FindSubmatch(out); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1.
+// Missing components count as zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// toolVersionWarning is written to stderr for a tool older than required
+// or whose version could not be read
+type toolVersionWarning struct {
+	Type     string `json:"type"`
+	Warning  string `json:"warning"`
+	Tool     string `json:"tool"`
+	Found    string `json:"found"`
+	Required string `json:"required"`
+}
+
+// checkToolVersions compares every tool in bins against its minimum. Old
+// tools produce a warning, or a fatal structured error with -strict-versions.
+// Detected versions are recorded in the run summary.
+func checkToolVersions(bins []string) {
+	found := make(map[string]string, len(bins))
+	for _, tool := range bins {
+		spec, ok := toolVersions[tool]
+		if !ok {
+			continue
+		}
+		v := toolVersion(tool)
+		if v == "" {
+			found[tool] = "unknown"
+		} else {
+			found[tool] = v
+			if compareVersions(v, spec.min) >= 0 {
+				continue
+			}
+		}
+
+		w := toolVersionWarning{Type: "warning", Warning: "tool_version", Tool: tool, Found: found[tool], Required: spec.min}
+		if strictVersions {
+			errRes := map[string]string{
+				"error":   fmt.Sprintf("%s %s is older than the required %s", tool, w.Found, spec.min),
+				"message": "Upgrade the tool or run without -strict-versions",
+			}
+			if v == "" {
+				errRes["error"] = fmt.Sprintf("Could not determine the %s version (need %s)", tool, spec.min)
+			}
+			startupFailure(errRes)
+		}
+		b, _ := json.Marshal(w)
+		fmt.Fprintln(os.Stderr, string(b))
+	}
+	summary.ToolVersions = found
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+// TestToolVersionBanners parses banners as the tools print them
+func TestToolVersionBanners(t *testing.T) {
+	for _, c := range []struct {
+		tool, banner, want string
+	}{
+		{"subfinder", "\n               __    _____           __         \n[INF] Current Version: v2.6.6\n", "2.6.6"},
+		{"httpx", "[INF] Current Version: v1.6.0\n", "1.6.0"},
+		{"amass", "v4.2.0\n", "4.2.0"},
+		{"amass", "OWASP Amass Project\n3.19.2\n", "3.19.2"},
+		{"nmap", "Nmap version 7.94 ( https://nmap.org )\nPlatform: x86_64-pc-linux-gnu\nCompiled with: liblua-5.4.4 openssl-3.0.11\n", "7.94"},
+		{"whatweb", "WhatWeb version 0.5.5 ( https://morningstarsecurity.com/research/whatweb/ )\n", "0.5.5"},
+		{"naabu", "[INF] Current Version: 2.3.0\n", "2.3.0"},
+		{"ffuf", "ffuf version: 2.1.0-dev\n", "2.1.0"},
+		{"tlsx", "[INF] Current Version: v1.1.6\n", "1.1.6"},
+		{"masscan", "\nMasscan version 1.3.2 ( https://github.com/robertdavidgraham/masscan )\nCompiled on: Jan  1 2023 00:00:00\n", "1.3.2"},
+		{"dnsx", "[INF] Current Version: 1.2.1\n", "1.2.1"},
+		{"nuclei", "[INF] Nuclei Engine Version: v3.3.2\n[INF] Nuclei Config Directory: /root/.config/nuclei\n", "3.3.2"},
+		{"katana", "[INF] Current version: v1.1.0\n", "1.1.0"},
+		{"chromium", "Chromium 120.0.6099.224 built on Debian 12.4, running on Debian 12.4\n", "120.0.6099"},
+		{"chromium", "Google Chrome 121.0.6167.85 \n", "121.0.6167"},
+		{"httpx", "flag provided but not defined: -version\n", ""},
+	} {
+		got := ""
+		if m := toolVersions[c.tool].re.FindStringSubmatch(c.banner); m != nil {
+			got = m[1]
+		}
+		if got != c.want {
+			t.Errorf("%s banner %q: got %q, want %q", c.tool, c.banner, got, c.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.6.0", "1.6.0", 0},
+		{"1.6", "1.6.0", 0},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0", "10.0", -1},
+		{"3.19.2", "3.19.0", 1},
+		{"0.5.5", "0.5.0", 1},
+	} {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+// TestToolVersionMinimums keeps every minimum parseable by its own banner
+// regexp's format
+func TestToolVersionMinimums(t *testing.T) {
+	for tool, spec := range toolVersions {
+		if compareVersions(spec.min, "0") <= 0 {
+			t.Errorf("%s has no minimum version", tool)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vhost probe findings, as in Result.Vhost.Finding
+const (
+	vhostDefault       = "default_vhost"    // what the address serves for an unknown Host
+	vhostHidden        = "hidden_vhost"     // a name that answered by name nowhere
+	vhostOriginExposed = "origin_exposed"   // a CDN-fronted name served directly by its origin
+	vhostDiffers       = "differs_from_dns" // a different page than the name's own probe
+)
+
+// VhostProbe records how a -vhost Result was requested and why it was kept
+type VhostProbe struct {
+	Host    string `json:"host"` // the Host header sent; the address itself for the default vhost
+	Finding string `json:"finding"`
+	NameURL string `json:"name_url,omitempty"` // the probe by name it was compared with
+}
+
+// vhostHTTP sends the -vhost requests. The address's certificate is for
+// some name, rarely the one asked for, so it is not verified.
+var vhostHTTP = func() *http.Client {
+	c := &http.Client{Timeout: 15 * time.Second, Transport: limitedTransport{insecure: true}}
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}()
+
+// vhostName is a name's own probe, as httpx saw it
+type vhostName struct {
+	url  string
+	cdn  string
+	page pageResponse
+}
+
+// vhostAddr is an address live hosts resolved to
+type vhostAddr struct {
+	cdn       string
+	endpoints map[string]bool // scheme://ip:port
+	names     map[string]bool // names probed on it by DNS
+}
+
+// vhostIndex collects the live hosts' addresses and pages for -vhost
+type vhostIndex struct {
+	mu    sync.Mutex
+	addrs map[string]*vhostAddr
+	names map[string]vhostName
+}
+
+var vhostHosts = newVhostIndex()
+
+func newVhostIndex() *vhostIndex {
+	return &vhostIndex{addrs: make(map[string]*vhostAddr), names: make(map[string]vhostName)}
+}
+
+// Observe records where a probed host lives and the page it served
+func (x *vhostIndex) Observe(res Result) {
+	if res.Source != "" || res.Subdomain == "" || res.IP == "" || res.StatusCode == 0 {
+		return
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	a := x.addrs[res.IP]
+	if a == nil {
+		a = &vhostAddr{endpoints: make(map[string]bool), names: make(map[string]bool)}
+		x.addrs[res.IP] = a
+	}
+	if res.CDN != "" {
+		a.cdn = res.CDN
+	}
+	a.endpoints[u.Scheme+"://"+vhostHostPort(res.IP, res.Port, u.Scheme)] = true
+	name := res.Subdomain
+	a.names[name] = true
+	if _, ok := x.names[name]; !ok {
+		x.names[name] = vhostName{url: res.URL, cdn: res.CDN, page: pageResponse{
+			status: res.StatusCode, title: res.Title, length: res.ContentLength, sha256: res.BodySHA256,
+		}}
+	}
+}
+
+// vhostHostPort joins ip and port, leaving out the scheme's default port
+func vhostHostPort(ip string, port int, scheme string) string {
+	if port == 0 || (scheme == "http" && port == 80) || (scheme == "https" && port == 443) {
+		if strings.Contains(ip, ":") {
+			return "[" + ip + "]"
+		}
+		return ip
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// vhostPair is one address endpoint to request with one Host header
+type vhostPair struct {
+	ip, endpoint, host string
+}
+
+// vhostPairs lists the (address, Host) pairs to probe: every in-scope name
+// discovery found, on every endpoint of an address it was not probed on by
+// DNS. Names no probe by name answered for come first, since hidden vhosts
+// are what -vhost is after; each name is tried on every address before the
+// next. CDN addresses are left out unless -vhost-cdn is set.
+func (x *vhostIndex) vhostPairs(target string, known []string) (pairs []vhostPair, total int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var ips []string
+	for ip, a := range x.addrs {
+		if (a.cdn != "" && !vhostCDN) || !scopeAllowsIP(ip) {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	seen := make(map[string]bool)
+	var hidden, live []string
+	for _, name := range known {
+		if seen[name] || !inTarget(name, target) || !scopeAllowsHost(name) {
+			continue
+		}
+		seen[name] = true
+		if _, ok := x.names[name]; ok {
+			live = append(live, name)
+		} else {
+			hidden = append(hidden, name)
+		}
+	}
+	sort.Strings(hidden)
+	sort.Strings(live)
+
+	// Every endpoint is requested; answers are deduplicated on (IP, Host)
+	// as they come back
+	for _, name := range append(hidden, live...) {
+		for _, ip := range ips {
+			a := x.addrs[ip]
+			if a.names[name] {
+				continue
+			}
+			endpoints := make([]string, 0, len(a.endpoints))
+			for e := range a.endpoints {
+				endpoints = append(endpoints, e)
+			}
+			sort.Strings(endpoints)
+			for _, e := range endpoints {
+				total++
+				if len(pairs) < vhostMaxPairs {
+					pairs = append(pairs, vhostPair{ip: ip, endpoint: e, host: name})
+				}
+			}
+		}
+	}
+	return pairs, total
+}
+
+// fetchVhost GETs endpoint's root with host as the Host header
+func fetchVhost(ctx context.Context, endpoint, host string) (pageResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/", nil)
+	if err != nil {
+		return pageResponse{}, err
+	}
+	req.Host = host
+	resp, err := vhostHTTP.Do(req)
+	if err != nil {
+		return pageResponse{}, err
+	}
+	return readPageResponse(resp)
+}
+
+// runVhostProbe requests the addresses of the live hosts with the Host
+// headers of the other in-scope names, once probing is over. A pair whose
+// page is the address's default vhost, what it serves with its own address
+// as the Host, is not a vhost; nor is one serving the same page its name
+// does by DNS, unless that name sits behind a CDN and the address is its
+// exposed origin. Default vhosts no name probe saw are emitted too.
+func runVhostProbe(ctx context.Context, target string, emit func(Result)) {
+	known := vhostHosts.knownNames()
+	pairs, total := vhostHosts.vhostPairs(target, known)
+	if len(pairs) == 0 {
+		return
+	}
+	if total > len(pairs) {
+		fmt.Fprintf(os.Stderr, "Vhost probe: %d of %d pairs, capped at -vhost-max-pairs\n", len(pairs), total)
+		stats.Add("vhost.pairs_skipped", int64(total-len(pairs)))
+	} else {
+		fmt.Fprintf(os.Stderr, "Vhost probe: %d pairs\n", len(pairs))
+	}
+
+	// Each endpoint's default vhost, requested once
+	defaults := make(map[string]pageResponse)
+	for _, p := range pairs {
+		if _, ok := defaults[p.endpoint]; ok || ctx.Err() != nil {
+			continue
+		}
+		page, err := fetchVhost(ctx, p.endpoint, vhostHostPort(p.ip, 0, ""))
+		if err != nil {
+			page = pageResponse{}
+		}
+		defaults[p.endpoint] = page
+		if page.status > 0 && !vhostHosts.servesKnownPage(p.ip, page) {
+			emit(vhostResult(target, p.ip, p.endpoint, page, &VhostProbe{Host: p.ip, Finding: vhostDefault}))
+		}
+	}
+
+	type answer struct {
+		pair vhostPair
+		page pageResponse
+	}
+	work := make(chan vhostPair)
+	answers := make(chan answer)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				page, err := fetchVhost(ctx, p.endpoint, p.host)
+				if err != nil || page.status == 0 {
+					continue
+				}
+				answers <- answer{p, page}
+			}
+		}()
+	}
+	go func() {
+		defer close(work)
+		for _, p := range pairs {
+			select {
+			case work <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(answers)
+	}()
+
+	// Dedup is on (IP, Host)
+	done := make(map[[2]string]bool)
+	for a := range answers {
+		stats.Add("vhost.pairs", 1)
+		key := [2]string{a.pair.ip, a.pair.host}
+		if done[key] {
+			continue
+		}
+		if def := defaults[a.pair.endpoint]; def.status > 0 && samePage(def, a.page) {
+			continue
+		}
+		probe := &VhostProbe{Host: a.pair.host}
+		name, live := vhostHosts.name(a.pair.host)
+		switch {
+		case !live:
+			probe.Finding = vhostHidden
+		case !samePage(name.page, a.page):
+			probe.Finding, probe.NameURL = vhostDiffers, name.url
+		case name.cdn != "":
+			probe.Finding, probe.NameURL = vhostOriginExposed, name.url
+		default:
+			continue
+		}
+		done[key] = true
+		stats.Add("vhost.findings", 1)
+		emit(vhostResult(target, a.pair.ip, a.pair.endpoint, a.page, probe))
+	}
+}
+
+// vhostResult builds the Result of a -vhost answer
+func vhostResult(target, ip, endpoint string, page pageResponse, probe *VhostProbe) Result {
+	res := Result{
+		RunID:           runID,
+		RootDomain:      target,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		URL:             endpoint + "/",
+		Port:            urlPort(endpoint),
+		StatusCode:      page.status,
+		Title:           page.title,
+		TechStack:       []string{},
+		Vulnerabilities: []Finding{},
+		Source:          "vhost",
+		IP:              ip,
+		ContentLength:   page.length,
+		BodySHA256:      page.sha256,
+		Vhost:           probe,
+	}
+	if probe.Finding != vhostDefault {
+		res.Subdomain = probe.Host
+	}
+	applyFlagRules(&res)
+	return res
+}
+
+// knownNames returns every name discovery reported and every live host
+func (x *vhostIndex) knownNames() []string {
+	nameSourcesMu.Lock()
+	names := make([]string, 0, len(nameSources))
+	for name := range nameSources {
+		names = append(names, name)
+	}
+	nameSourcesMu.Unlock()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for name := range x.names {
+		names = append(names, name)
+	}
+	return names
+}
+
+// name returns the probe by name of host, if it answered
+func (x *vhostIndex) name(host string) (vhostName, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	n, ok := x.names[host]
+	return n, ok
+}
+
+// servesKnownPage reports whether page is what one of the names on ip
+// served when probed by name
+func (x *vhostIndex) servesKnownPage(ip string, page pageResponse) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	a := x.addrs[ip]
+	if a == nil {
+		return false
+	}
+	for name := range a.names {
+		if samePage(x.names[name].page, page) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVhost checks the -vhost options against target. Only domain
+// targets have names to send.
+func validateVhost(target string) error {
+	if !vhostProbe {
+		return nil
+	}
+	if vhostMaxPairs < 1 {
+		return fmt.Errorf("-vhost-max-pairs must be at least 1, got %d", vhostMaxPairs)
+	}
+	if withoutDiscovery(target) {
+		return fmt.Errorf("-vhost needs a domain target")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const virusTotalAPIBase = "https://www.virustotal.com/api/v3"
+
+type virusTotalRelations struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+	Meta struct {
+		Cursor string `json:"cursor"`
+	} `json:"meta"`
+}
+
+// runVirusTotal walks the domain's subdomains relationship cursor. Requests
+// are spaced client-side to stay inside -vt-rate (the free tier allows 4 per
+// minute).
+func runVirusTotal(ctx context.Context, domain string, out chan<- string) error {
+	key := secret("VT_API_KEY")
+	if key == "" {
+		return fmt.Errorf("VT_API_KEY is not set")
+	}
+	interval := time.Minute / time.Duration(max(vtRequestsPerMinute, 1))
+
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+		}
+
+		q := url.Values{}
+		q.Set("limit", "40")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		endpoint := fmt.Sprintf("%s/domains/%s/subdomains?%s", virusTotalAPIBase, url.PathEscape(domain), q.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("x-apikey", key)
+		req.Header.Set("Accept", "application/json")
+		if err := apiRequest("virustotal"); err != nil {
+			return err
+		}
+
+		resp, err := sourceHTTP.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var rel virusTotalRelations
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&rel)
+		case http.StatusTooManyRequests:
+			fmt.Fprintf(os.Stderr, "Warning: VirusTotal quota exceeded for %s, stopping source\n", domain)
+			resp.Body.Close()
+			return nil
+		case http.StatusUnauthorized, http.StatusForbidden:
+			err = fmt.Errorf("VirusTotal rejected the API key (HTTP %d), check VT_API_KEY", resp.StatusCode)
+		default:
+			err = fmt.Errorf("virustotal: unexpected status %s", resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, d := range rel.Data {
+			out <- strings.ToLower(d.ID)
+		}
+		cursor = rel.Meta.Cursor
+		if cursor == "" || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
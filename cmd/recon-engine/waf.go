@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Block actions, as in -block-action
+const (
+	blockActionWarn     = "warn"
+	blockActionThrottle = "throttle"
+	blockActionPause    = "pause"
+)
+
+// blockBodyMax caps how much of a page block detection reads
+const blockBodyMax = 256 << 10
+
+// blockMinResults is how many results of a root domain are seen before its
+// block rate is trusted
+const blockMinResults = 20
+
+// blockStatuses are the answers WAFs serve their block and challenge pages
+// with; only these are checked against the block rules
+var blockStatuses = map[int]bool{403: true, 406: true, 429: true, 503: true}
+
+// blockHTTP fetches a page for the block rules. Block pages are the answer
+// itself, so redirects are not followed.
+var blockHTTP = &http.Client{
+	Transport: limitedTransport{insecure: true},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// blockSummary is one root domain's block rate in the run summary
+type blockSummary struct {
+	Results int            `json:"results"`
+	Blocked int            `json:"blocked"`
+	Vendors map[string]int `json:"vendors,omitempty"`
+	Action  string         `json:"action,omitempty"` // what crossing -block-threshold did
+}
+
+var (
+	blocks   = make(map[string]*blockSummary) // by root domain
+	blocksMu sync.Mutex
+
+	// blockLimiters throttle the active requests to the root domains
+	// -block-action throttle slowed down
+	blockLimiters = make(map[string]*tokenBucket)
+	// blockPausedRoots are the root domains -block-action pause stopped
+	// active enrichment of
+	blockPausedRoots sync.Map // root domain -> bool
+)
+
+// validateBlock checks the block detection flags once after parsing
+func validateBlock() error {
+	if blockThreshold < 0 || blockThreshold > 1 {
+		return fmt.Errorf("-block-threshold must be between 0 and 1, got %g", blockThreshold)
+	}
+	switch blockAction {
+	case blockActionWarn, blockActionPause:
+	case blockActionThrottle:
+		if blockThrottleRate < 1 {
+			return fmt.Errorf("-block-throttle-rate must be at least 1, got %d", blockThrottleRate)
+		}
+	default:
+		return fmt.Errorf("unknown -block-action %q (want warn, throttle or pause)", blockAction)
+	}
+	return nil
+}
+
+// resetBlocks forgets the block rates of the previous -monitor iteration
+func resetBlocks() {
+	blocksMu.Lock()
+	defer blocksMu.Unlock()
+	blocks = make(map[string]*blockSummary)
+	blockLimiters = make(map[string]*tokenBucket)
+	blockPausedRoots = sync.Map{}
+}
+
+// detectBlock matches a live result against the block rules, fetching the
+// page again when a rule needs headers or a body httpx did not report, and
+// counts it towards its root domain's block rate
+func detectBlock(ctx context.Context, res *Result) {
+	if len(blockRules) > 0 && blockStatuses[res.StatusCode] {
+		if res.URL != "" && (res.headers == nil || blockRulesUseBody()) {
+			fetchBlockPage(ctx, res)
+		}
+		for i := range blockRules {
+			if blockRules[i].match(res) {
+				res.Blocked, res.BlockVendor = true, blockRules[i].Block
+				stats.Add("results.blocked", 1)
+				break
+			}
+		}
+		res.body = nil
+	}
+	observeBlock(*res)
+}
+
+// blockRulesUseBody reports whether a block rule looks at the page body
+func blockRulesUseBody() bool {
+	for i := range blockRules {
+		if blockRules[i].usesBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchBlockPage GETs res.URL, filling in the headers when httpx did not
+// report them and the first blockBodyMax bytes of the body
+func fetchBlockPage(ctx context.Context, res *Result) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, res.URL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := blockHTTP.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	res.body, _ = io.ReadAll(io.LimitReader(resp.Body, blockBodyMax))
+	if res.headers == nil {
+		res.headers = make(map[string]string, len(resp.Header))
+		for k, v := range resp.Header {
+			res.headers[headerKey(k)] = strings.Join(v, ", ")
+		}
+	}
+}
+
+// observeBlock counts res towards its root domain's block rate, warning
+// once, and throttling or pausing with -block-action, when the rate reaches
+// -block-threshold
+func observeBlock(res Result) {
+	if res.StatusCode == 0 {
+		return
+	}
+	root := res.RootDomain
+	blocksMu.Lock()
+	defer blocksMu.Unlock()
+	b := blocks[root]
+	if b == nil {
+		b = &blockSummary{}
+		blocks[root] = b
+	}
+	b.Results++
+	if res.Blocked {
+		b.Blocked++
+		if b.Vendors == nil {
+			b.Vendors = make(map[string]int)
+		}
+		b.Vendors[res.BlockVendor]++
+	}
+	if blockThreshold == 0 || b.Action != "" || b.Results < blockMinResults {
+		return
+	}
+	rate := float64(b.Blocked) / float64(b.Results)
+	if rate < blockThreshold {
+		return
+	}
+	b.Action = blockAction
+	stats.Add("blocks.threshold_crossed", 1)
+	msg := fmt.Sprintf("Warning: %d of %d live hosts of %s (%.0f%%) answer with WAF block pages", b.Blocked, b.Results, root, rate*100)
+	switch blockAction {
+	case blockActionThrottle:
+		blockLimiters[root] = newTokenBucket(blockThrottleRate)
+		msg += fmt.Sprintf("; slowing active requests to it to %d/s", blockThrottleRate)
+	case blockActionPause:
+		blockPausedRoots.Store(root, true)
+		msg += "; pausing its active enrichment"
+	}
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// blockPaused reports whether -block-action pause stopped active
+// enrichment of root
+func blockPaused(root string) bool {
+	_, ok := blockPausedRoots.Load(root)
+	return ok
+}
+
+// blockLimiter returns the -block-action throttle limiter of the root
+// domain host falls under, nil when it is not throttled
+func blockLimiter(host string) *tokenBucket {
+	blocksMu.Lock()
+	defer blocksMu.Unlock()
+	for root, b := range blockLimiters {
+		if inTarget(host, root) {
+			return b
+		}
+	}
+	return nil
+}
+
+// blockSnapshot copies the block rates of the root domains with blocked
+// results, for the run summary
+func blockSnapshot() map[string]blockSummary {
+	blocksMu.Lock()
+	defer blocksMu.Unlock()
+	var snap map[string]blockSummary
+	for root, b := range blocks {
+		if b.Blocked == 0 {
+			continue
+		}
+		if snap == nil {
+			snap = make(map[string]blockSummary)
+		}
+		snap[root] = *b
+	}
+	return snap
+}
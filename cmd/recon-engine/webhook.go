@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhookHTTP posts notifications. It bypasses the rate limiter, proxy and
+// custom headers, which exist for traffic towards the target.
+var webhookHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// configureWebhook validates -webhook-url. Called once after flag parsing,
+// after configureOwners.
+func configureWebhook() error {
+	if webhookURL == "" {
+		if ownerWebhooks {
+			return nil
+		}
+		if webhookFlags != "" {
+			return fmt.Errorf("-webhook-flags needs -webhook-url")
+		}
+		if webhookMinScore != 0 {
+			return fmt.Errorf("-webhook-min-score needs -webhook-url")
+		}
+		return nil
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported webhook scheme %q (want http or https)", u.Scheme)
+	}
+	return nil
+}
+
+// slackWebhook reports whether rawURL is a Slack incoming webhook, which
+// only accepts a {"text": ...} payload
+func slackWebhook(rawURL string) bool {
+	return rawURLHost(rawURL) == "hooks.slack.com"
+}
+
+// notifyWebhook posts res to its -owners webhook or to -webhook-url.
+// Failures are returned to the webhook sink, which reports them; they never
+// interrupt the scan.
+func notifyWebhook(ctx context.Context, res Result) error {
+	if webhookFlags != "" && !hasAnyFlag(res, splitList(webhookFlags)) {
+		return nil
+	}
+	if res.InterestScore < webhookMinScore || knownQuiet(res) || parkedQuiet(res) {
+		return nil
+	}
+	target := ownerWebhook(res)
+	if target == "" {
+		return nil
+	}
+	payload := projectResult(res)
+	if slackWebhook(target) {
+		payload = map[string]string{"text": slackText(res)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// slackText renders res as a one-line Slack message
+func slackText(res Result) string {
+	change := res.ChangeType
+	if change == "" {
+		change = "found"
+	}
+	target := res.URL
+	if target == "" {
+		target = res.Subdomain
+	}
+	msg := fmt.Sprintf("[%s] %s (status %d)", change, target, res.StatusCode)
+	if res.Title != "" {
+		msg += " " + res.Title
+	}
+	if len(res.Flags) > 0 {
+		msg += " [" + strings.Join(res.Flags, ", ") + "]"
+	}
+	if len(res.Changes) > 0 {
+		msg += ": " + strings.Join(res.Changes, ", ")
+	}
+	if v := res.VisualChange; v != nil {
+		msg += " (screenshots " + v.Previous + " and " + v.Current + ")"
+	}
+	return msg
+}
+
+// hasAnyFlag reports whether res carries one of flags
+func hasAnyFlag(res Result, flags []string) bool {
+	for _, f := range flags {
+		if contains(res.Flags, f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// wwGate spaces WhatWeb invocations by -delay across all workers
+	wwGate    sync.Mutex
+	wwLastRun time.Time
+)
+
+// waitWhatWebDelay blocks until -delay has passed since the previous
+// WhatWeb invocation started
+func waitWhatWebDelay() {
+	if wwDelay <= 0 {
+		return
+	}
+	wwGate.Lock()
+	defer wwGate.Unlock()
+	if !wwLastRun.IsZero() {
+		if wait := wwDelay - time.Since(wwLastRun); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	wwLastRun = time.Now()
+}
+
+// validateWhatWeb checks the -ww-* flags once after parsing
+func validateWhatWeb() error {
+	if wwAggression < 1 || wwAggression > 4 {
+		return fmt.Errorf("-ww-aggression must be between 1 and 4, got %d", wwAggression)
+	}
+	if wwTimeout <= 0 {
+		return fmt.Errorf("-ww-timeout must be positive, got %s", wwTimeout)
+	}
+	if wwMaxOutput <= 0 {
+		return fmt.Errorf("-ww-max-output must be positive, got %d", wwMaxOutput)
+	}
+	for _, list := range []string{wwPlugins, wwExcludePlugins} {
+		for _, p := range splitList(list) {
+			if p[0] == '+' || p[0] == '-' {
+				return fmt.Errorf("plugin %q: give plain names, exclusions go in -ww-exclude-plugins", p)
+			}
+			if strings.ContainsAny(p, " \t") {
+				return fmt.Errorf("invalid plugin name %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// whatwebPluginArgs maps -ww-plugins and -ww-exclude-plugins onto WhatWeb's
+// --plugins list, where excluded plugins carry a "-" prefix
+func whatwebPluginArgs() []string {
+	list := splitList(wwPlugins)
+	for _, p := range splitList(wwExcludePlugins) {
+		list = append(list, "-"+p)
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return []string{"--plugins", strings.Join(list, ",")}
+}
+
+// certainty reads a plugin's certainty, which WhatWeb reports as a number
+// or a list of numbers. Plugins without one are certain (100).
+func (p WhatWebPlugin) certainty() int {
+	var n int
+	if json.Unmarshal(p.Certainty, &n) == nil {
+		return n
+	}
+	var ns []int
+	if json.Unmarshal(p.Certainty, &ns) == nil && len(ns) > 0 {
+		n = ns[0]
+		for _, c := range ns[1:] {
+			n = max(n, c)
+		}
+		return n
+	}
+	return 100
+}
+
+// errWhatWebOutput is returned once WhatWeb has written more than
+// -ww-max-output bytes
+var errWhatWebOutput = errors.New("output exceeds -ww-max-output")
+
+// cappedBuffer keeps up to limit bytes and calls cancel, which kills the
+// writer, instead of growing past it. The buffer is a field rather than
+// embedded so io.Copy cannot bypass Write through its ReadFrom.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	cancel   func()
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buf.Len()+len(p)) > b.limit {
+		b.overflow = true
+		b.cancel()
+		return 0, errWhatWebOutput
+	}
+	return b.buf.Write(p)
+}
+
+// fingerprintWhatWeb runs WhatWeb against res.URL, or takes its output from
+// the -cache, and merges the detected plugin versions and names into res.
+// The output is returned for the other members of res's group, nil when
+// there is none. WhatWeb's own output is kept for -keep-raw; a cached one
+// was kept by the run that cached it.
+func fingerprintWhatWeb(ctx context.Context, res *Result) []WhatWebResult {
+	// whatweb --aggression N --format=json [--plugins LIST] <url>
+	wwArgs := append([]string{"--aggression", strconv.Itoa(wwAggression), "--format=json"}, whatwebPluginArgs()...)
+	// The options that change what WhatWeb reports; the proxy and headers
+	// stay out of the key, which is stored in the entry
+	key := res.URL + " " + strings.Join(wwArgs, " ")
+	if authFor(rawURLHost(res.URL)) != nil {
+		key += " (authenticated)"
+	}
+	version := toolVersion("whatweb")
+	var wwResults []WhatWebResult
+	if !cacheGet("whatweb", key, version, &wwResults) {
+		var raw []byte
+		var ok bool
+		wwResults, raw, ok = runWhatWeb(ctx, res.URL, wwArgs)
+		if len(raw) > 0 {
+			res.RawRef = joinRawRefs(res.RawRef, keepRaw("whatweb", res.RootDomain, res.URL, raw))
+		}
+		if !ok {
+			return nil
+		}
+		cachePut("whatweb", key, version, wwResults)
+	}
+	if len(wwResults) == 0 {
+		return nil
+	}
+	mergeWhatWeb(res, wwResults)
+	return wwResults
+}
+
+// wwGroup is the WhatWeb output of the first host of a group of hosts
+// serving the same application, which the others inherit
+type wwGroup struct {
+	done    chan struct{} // closed once results is set
+	results []WhatWebResult
+}
+
+// wwGroups holds the groups of the run by whatwebGroupKey
+var wwGroups sync.Map // group key -> *wwGroup
+
+// whatwebGroupKey groups hosts whose httpx response had the same body
+// hash, title and technologies. It is "" for hosts that are not grouped:
+// with -fingerprint-all and when httpx reported no body hash.
+func whatwebGroupKey(res *Result) string {
+	if fingerprintAll || res.BodySHA256 == "" {
+		return ""
+	}
+	tech := make([]string, 0, len(res.TechStack))
+	for _, t := range res.TechStack {
+		tech = append(tech, techEntry(t))
+	}
+	sort.Strings(tech)
+	return res.BodySHA256 + "\x00" + res.Title + "\x00" + strings.Join(tech, ",")
+}
+
+// fingerprintGrouped fingerprints res with run, unless another host of its
+// group got there first: then that host's WhatWeb output is merged into res,
+// which is marked fingerprint_inherited. When the first host got no output,
+// the others are fingerprinted themselves.
+func fingerprintGrouped(ctx context.Context, res *Result, run func() []WhatWebResult) {
+	key := whatwebGroupKey(res)
+	if key == "" {
+		run()
+		return
+	}
+	g := &wwGroup{done: make(chan struct{})}
+	if v, loaded := wwGroups.LoadOrStore(key, g); loaded {
+		g = v.(*wwGroup)
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.done:
+		}
+		if len(g.results) == 0 {
+			run()
+			return
+		}
+		mergeWhatWeb(res, g.results)
+		res.FingerprintInherited = true
+		stats.Add("whatweb.inherited", 1)
+		return
+	}
+	defer close(g.done)
+	g.results = run()
+}
+
+// runWhatWeb runs WhatWeb with args against url. An invocation that runs
+// longer than -ww-timeout or writes more than -ww-max-output is killed and
+// reported; ok is false then and on any other failure. raw is the output
+// of a run that completed, parsed or not.
+func runWhatWeb(ctx context.Context, url string, wwArgs []string) (wwResults []WhatWebResult, raw []byte, ok bool) {
+	// WhatWeb has no rate control of its own
+	waitWhatWebDelay()
+	if err := waitProxy(ctx); err != nil {
+		return nil, nil, false
+	}
+
+	wwArgs = append(wwArgs, whatwebProxyArgs()...)
+	wwArgs = append(wwArgs, whatwebHeaderArgs()...)
+	wwArgs = append(wwArgs, whatwebAuthArgs(url)...)
+	wwCtx, cancel := context.WithTimeout(ctx, wwTimeout)
+	defer cancel()
+	wwCmd := toolCommand(wwCtx, toolPath("whatweb"), append(wwArgs, url)...) // Use the URL which has protocol
+	wwOut := &cappedBuffer{limit: wwMaxOutput, cancel: cancel}
+	wwCmd.Stdout = wwOut
+	// Children WhatWeb leaves behind must not keep Wait on the pipe
+	wwCmd.WaitDelay = 5 * time.Second
+	err := runTool(wwCmd)
+	switch {
+	case ctx.Err() != nil:
+		return nil, nil, false
+	case wwOut.overflow:
+		fmt.Fprintf(os.Stderr, "WhatWeb output for %s exceeded %d bytes, skipping fingerprint\n", url, wwMaxOutput)
+		reportToolError("whatweb", "enrich", eventOutputLimit, fmt.Errorf("%s: %w", url, errWhatWebOutput))
+		return nil, nil, false
+	case wwCtx.Err() == context.DeadlineExceeded:
+		fmt.Fprintf(os.Stderr, "WhatWeb timed out on %s after %s, skipping fingerprint\n", url, wwTimeout)
+		reportToolError("whatweb", "enrich", eventTimeout, fmt.Errorf("%s: no answer within -ww-timeout %s", url, wwTimeout))
+		return nil, nil, false
+	case err != nil:
+		reportToolError("whatweb", "enrich", "", err)
+		return nil, nil, false
+	}
+	raw = wwOut.buf.Bytes()
+	wwResults, ok = parseWhatWeb(url, raw)
+	return wwResults, raw, ok
+}
+
+// parseWhatWeb decodes and sanitizes WhatWeb's JSON output for url,
+// counting it as rejected or repaired
+func parseWhatWeb(url string, raw []byte) ([]WhatWebResult, bool) {
+	var wwResults []WhatWebResult
+	data, fixed := validUTF8(raw)
+	if err := json.Unmarshal(data, &wwResults); err != nil {
+		rejectedLine("whatweb", "enrich", eventParseFailed, err)
+		return nil, false
+	}
+	wwResults, repaired, rejected := sanitizeWhatWeb(wwResults)
+	if fixed && repaired == 0 {
+		repaired = 1
+	}
+	for i := 0; i < repaired; i++ {
+		repairedLine("whatweb")
+	}
+	for i := 0; i < rejected; i++ {
+		rejectedLine("whatweb", "enrich", eventInvalid, fmt.Errorf("%s: entry without a target or with a status outside 0-599", url))
+	}
+	return wwResults, true
+}
+
+// mergeWhatWeb merges the versions and technologies of WhatWeb's output
+// into res, both under canonical names
+func mergeWhatWeb(res *Result, wwResults []WhatWebResult) {
+	// WhatWeb writes one entry per target it visited when it follows
+	// redirects. The redirect hops say little about the application, so
+	// only the non-redirect entries are used when there are any.
+	var targets []WhatWebResult
+	for _, r := range wwResults {
+		if r.HTTPStatus < 300 || r.HTTPStatus >= 400 {
+			targets = append(targets, r)
+		}
+	}
+	if len(targets) == 0 {
+		targets = wwResults
+	}
+
+	versions := make(map[string]string)
+	confidence := make(map[string]int)
+	// detected is each plugin's highest certainty, for detection_source
+	detected := make(map[string]int)
+	tech := newTechSet(res.TechStack)
+	for _, r := range targets {
+		for name, info := range r.Plugins {
+			plugin := whatwebTech(name, info)
+			if plugin == "" {
+				continue
+			}
+			tech.Add(plugin)
+			detected[plugin] = max(detected[plugin], info.certainty())
+			if len(info.Version) == 0 {
+				continue
+			}
+			if prev, ok := versions[plugin]; !ok {
+				versions[plugin] = strings.Join(info.Version, ", ")
+			} else {
+				for _, v := range info.Version {
+					if !strings.Contains(", "+prev+", ", ", "+v+", ") {
+						prev += ", " + v
+					}
+				}
+				versions[plugin] = prev
+			}
+			if c := info.certainty(); c < 100 {
+				if prev, ok := confidence[plugin]; !ok || c > prev {
+					confidence[plugin] = c
+				}
+			}
+		}
+	}
+	res.Versions = versions
+	if wwConfidence && len(confidence) > 0 {
+		res.VersionConfidence = confidence
+	}
+	for _, plugin := range sortedKeys(versions) {
+		recordDetection(res, plugin, fingerprintEngineWhatWeb, detected[plugin], true)
+	}
+	for plugin, c := range detected {
+		recordDetection(res, plugin, fingerprintEngineWhatWeb, c, false)
+	}
+	res.TechStack = tech.Stack()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTool points -<tool>-bin at a shell script for the test
+func fakeTool(t *testing.T, tool, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	path := filepath.Join(t.TempDir(), tool)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old, had := toolBins[tool]
+	t.Cleanup(func() {
+		if had {
+			toolBins[tool] = old
+		} else {
+			delete(toolBins, tool)
+		}
+	})
+	toolBins[tool] = &path
+}
+
+func setWhatWebLimits(t *testing.T, timeout time.Duration, maxOutput int64) {
+	oldTimeout, oldMax := wwTimeout, wwMaxOutput
+	t.Cleanup(func() { wwTimeout, wwMaxOutput = oldTimeout, oldMax })
+	wwTimeout, wwMaxOutput = timeout, maxOutput
+}
+
+func TestRunWhatWeb(t *testing.T) {
+	fakeTool(t, "whatweb", `echo '[{"target":"https://app.example.com","http_status":200,"plugins":{"nginx":{"version":["1.25.3"]}}}]'`)
+	setWhatWebLimits(t, 10*time.Second, 1<<20)
+	results, raw, ok := runWhatWeb(context.Background(), "https://app.example.com", nil)
+	if !ok || len(results) != 1 || len(raw) == 0 {
+		t.Fatalf("ok %v, %d results, %d bytes", ok, len(results), len(raw))
+	}
+}
+
+// TestRunWhatWebTimeout kills a WhatWeb that hangs, and the children it
+// started, once -ww-timeout is up
+func TestRunWhatWebTimeout(t *testing.T) {
+	fakeTool(t, "whatweb", "sleep 60 & sleep 60")
+	setWhatWebLimits(t, 300*time.Millisecond, 1<<20)
+	start := time.Now()
+	_, raw, ok := runWhatWeb(context.Background(), "https://slow.example.com", nil)
+	if ok || raw != nil {
+		t.Errorf("a timed out run returned ok %v with %d bytes", ok, len(raw))
+	}
+	if took := time.Since(start); took > 10*time.Second {
+		t.Errorf("returned after %s", took)
+	}
+}
+
+// TestRunWhatWebOutputCap kills a WhatWeb that writes without end
+func TestRunWhatWebOutputCap(t *testing.T) {
+	fakeTool(t, "whatweb", "yes '[{\"target\":\"x\"}]'")
+	setWhatWebLimits(t, 30*time.Second, 64<<10)
+	start := time.Now()
+	_, raw, ok := runWhatWeb(context.Background(), "https://loud.example.com", nil)
+	if ok || raw != nil {
+		t.Errorf("an oversized run returned ok %v with %d bytes", ok, len(raw))
+	}
+	if took := time.Since(start); took > 10*time.Second {
+		t.Errorf("returned after %s", took)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	cancelled := false
+	b := &cappedBuffer{limit: 8, cancel: func() { cancelled = true }}
+	if _, err := b.Write([]byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Write([]byte("9")); err != errWhatWebOutput {
+		t.Errorf("write past the limit: %v", err)
+	}
+	if !b.overflow || !cancelled || b.buf.Len() != 8 {
+		t.Errorf("overflow %v, cancelled %v, %d bytes kept", b.overflow, cancelled, b.buf.Len())
+	}
+}
+
+// resetWhatWebGroups starts the test with no groups and the given
+// -fingerprint-all
+func resetWhatWebGroups(t *testing.T, all bool) {
+	old := fingerprintAll
+	t.Cleanup(func() {
+		fingerprintAll = old
+		wwGroups = sync.Map{}
+	})
+	fingerprintAll = all
+	wwGroups = sync.Map{}
+}
+
+func groupedHost(name string) *Result {
+	return &Result{Subdomain: name, URL: "https://" + name, BodySHA256: "abc123", Title: "Portal", TechStack: []string{"nginx", "php"}}
+}
+
+var portalWhatWeb = []WhatWebResult{{
+	Target:     "https://a.example.com",
+	HTTPStatus: 200,
+	Plugins:    map[string]WhatWebPlugin{"WordPress": {Version: []string{"6.4.2"}}},
+}}
+
+func TestWhatWebGroupKey(t *testing.T) {
+	resetWhatWebGroups(t, false)
+	a := groupedHost("a.example.com")
+	b := groupedHost("b.example.com")
+	b.TechStack = []string{"php", "nginx"}
+	if whatwebGroupKey(a) == "" || whatwebGroupKey(a) != whatwebGroupKey(b) {
+		t.Error("the same body, title and technologies are not grouped")
+	}
+	// Any of the three keys differing keeps hosts apart
+	for _, change := range []func(*Result){
+		func(r *Result) { r.BodySHA256 = "def456" },
+		func(r *Result) { r.Title = "Portal " },
+		func(r *Result) { r.TechStack = []string{"nginx"} },
+		func(r *Result) { r.TechStack = []string{"nginx:1.25", "php"} },
+	} {
+		c := groupedHost("c.example.com")
+		change(c)
+		if whatwebGroupKey(c) == whatwebGroupKey(a) {
+			t.Errorf("grouped with %+v", c)
+		}
+	}
+	if whatwebGroupKey(&Result{Title: "Portal"}) != "" {
+		t.Error("grouped a host with no body hash")
+	}
+	fingerprintAll = true
+	if whatwebGroupKey(a) != "" {
+		t.Error("grouped with -fingerprint-all")
+	}
+}
+
+// TestFingerprintGroupedInherits runs WhatWeb once for a group of hosts
+// fingerprinted at once and copies its versions to the rest, marked
+func TestFingerprintGroupedInherits(t *testing.T) {
+	resetWhatWebGroups(t, false)
+	var runs atomic.Int64
+	hosts := make([]*Result, 20)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		hosts[i] = groupedHost(fmt.Sprintf("h%d.example.com", i))
+		wg.Add(1)
+		go func(res *Result) {
+			defer wg.Done()
+			fingerprintGrouped(context.Background(), res, func() []WhatWebResult {
+				runs.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				mergeWhatWeb(res, portalWhatWeb)
+				return portalWhatWeb
+			})
+		}(hosts[i])
+	}
+	wg.Wait()
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("WhatWeb ran %d times for one group", n)
+	}
+	inherited := 0
+	for _, res := range hosts {
+		if res.Versions["wordpress"] != "6.4.2" {
+			t.Errorf("%s: versions %v", res.Subdomain, res.Versions)
+		}
+		if res.FingerprintInherited {
+			inherited++
+		}
+	}
+	if inherited != len(hosts)-1 {
+		t.Errorf("%d hosts marked inherited, want %d", inherited, len(hosts)-1)
+	}
+}
+
+// TestFingerprintGroupedNoOutput fingerprints every host itself when the
+// group's first host got nothing from WhatWeb
+func TestFingerprintGroupedNoOutput(t *testing.T) {
+	resetWhatWebGroups(t, false)
+	runs := 0
+	fingerprintGrouped(context.Background(), groupedHost("a.example.com"), func() []WhatWebResult {
+		runs++
+		return nil
+	})
+	b := groupedHost("b.example.com")
+	fingerprintGrouped(context.Background(), b, func() []WhatWebResult {
+		runs++
+		mergeWhatWeb(b, portalWhatWeb)
+		return portalWhatWeb
+	})
+	if runs != 2 || b.FingerprintInherited || b.Versions["wordpress"] != "6.4.2" {
+		t.Errorf("%d runs, inherited %v, versions %v", runs, b.FingerprintInherited, b.Versions)
+	}
+}
+
+func TestFingerprintAll(t *testing.T) {
+	resetWhatWebGroups(t, true)
+	runs := 0
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		res := groupedHost(name)
+		fingerprintGrouped(context.Background(), res, func() []WhatWebResult {
+			runs++
+			return portalWhatWeb
+		})
+		if res.FingerprintInherited {
+			t.Errorf("%s marked inherited with -fingerprint-all", name)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("WhatWeb ran %d times with -fingerprint-all, want 2", runs)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rdapBootstrap redirects each domain to its registry's RDAP server
+const rdapBootstrap = "https://rdap.org/domain/"
+
+// whoisIANA answers which WHOIS server holds a TLD, as its refer: line
+const whoisIANA = "whois.iana.org"
+
+// WhoisInfo is the registration data -whois found for the root domain
+type WhoisInfo struct {
+	Domain        string `json:"domain"`
+	Registrar     string `json:"registrar,omitempty"`
+	Created       string `json:"created,omitempty"` // RFC 3339
+	Expires       string `json:"expires,omitempty"` // RFC 3339
+	RegistrantOrg string `json:"registrant_org,omitempty"`
+	Via           string `json:"via"` // rdap or whois
+	ExpiringSoon  bool   `json:"expiring_soon,omitempty"`
+}
+
+// validateWhois checks the -whois flags once after parsing
+func validateWhois() error {
+	if whoisExpiryWarn < 0 {
+		return fmt.Errorf("-whois-expiry-warn must not be negative")
+	}
+	if whoisRecord && !whoisLookup {
+		return fmt.Errorf("-whois-record needs -whois")
+	}
+	return nil
+}
+
+// lookupWhois returns the registration data of domain from RDAP, falling
+// back to WHOIS for registries without RDAP. It returns false when neither
+// answered; the failure is reported.
+func lookupWhois(ctx context.Context, domain string) (*WhoisInfo, bool) {
+	info, err := lookupRDAP(ctx, domain)
+	if err != nil {
+		var werr error
+		if info, werr = lookupPort43(ctx, domain); werr != nil {
+			reportToolError("whois", "whois", "", fmt.Errorf("%s: rdap: %v; whois: %v", domain, err, werr))
+			return nil, false
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, info.Expires); err == nil {
+		info.ExpiringSoon = time.Until(t) < whoisExpiryWarn
+	}
+	return info, true
+}
+
+// rdapEntity is the part of an RDAP entity lookupRDAP reads
+type rdapEntity struct {
+	Roles      []string          `json:"roles"`
+	VcardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity      `json:"entities"`
+}
+
+// vcard returns the first value of the vCard property name, e.g. fn or org
+func (e rdapEntity) vcard(name string) string {
+	if len(e.VcardArray) < 2 {
+		return ""
+	}
+	var props [][]json.RawMessage
+	if json.Unmarshal(e.VcardArray[1], &props) != nil {
+		return ""
+	}
+	for _, p := range props {
+		var key string
+		if len(p) < 4 || json.Unmarshal(p[0], &key) != nil || key != name {
+			continue
+		}
+		// org may be structured: a list of units
+		var s string
+		if json.Unmarshal(p[3], &s) == nil {
+			return strings.TrimSpace(s)
+		}
+		var parts []string
+		if json.Unmarshal(p[3], &parts) == nil && len(parts) > 0 {
+			return strings.TrimSpace(parts[0])
+		}
+	}
+	return ""
+}
+
+// withRole returns the first entity, nested ones included, that has role
+func withRole(entities []rdapEntity, role string) (rdapEntity, bool) {
+	for _, e := range entities {
+		for _, r := range e.Roles {
+			if r == role {
+				return e, true
+			}
+		}
+		if found, ok := withRole(e.Entities, role); ok {
+			return found, true
+		}
+	}
+	return rdapEntity{}, false
+}
+
+func lookupRDAP(ctx context.Context, domain string) (*WhoisInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rdapBootstrap+domain, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	if err := apiRequest("rdap"); err != nil {
+		return nil, err
+	}
+	resp, err := sourceHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var body struct {
+		Events []struct {
+			Action string `json:"eventAction"`
+			Date   string `json:"eventDate"`
+		} `json:"events"`
+		Entities []rdapEntity `json:"entities"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&body); err != nil {
+		return nil, err
+	}
+	info := &WhoisInfo{Domain: domain, Via: "rdap"}
+	for _, ev := range body.Events {
+		switch ev.Action {
+		case "registration":
+			info.Created = whoisDate(ev.Date)
+		case "expiration":
+			info.Expires = whoisDate(ev.Date)
+		}
+	}
+	if e, ok := withRole(body.Entities, "registrar"); ok {
+		info.Registrar = e.vcard("fn")
+	}
+	if e, ok := withRole(body.Entities, "registrant"); ok {
+		if info.RegistrantOrg = e.vcard("org"); info.RegistrantOrg == "" {
+			info.RegistrantOrg = e.vcard("fn")
+		}
+	}
+	return info, nil
+}
+
+// whoisFields are the keys registries and registrars use for each field,
+// most specific first
+var whoisFields = map[string][]string{
+	"registrar": {"registrar", "sponsoring registrar", "registrar name", "registrar organization"},
+	"created":   {"creation date", "created", "created on", "registered on", "registration time", "registered", "domain registration date"},
+	"expires":   {"registry expiry date", "registrar registration expiration date", "expiration date", "expiry date", "expires", "expires on", "paid-till", "expiration time", "renewal date"},
+	"org":       {"registrant organization", "registrant organisation", "registrant", "org", "organization"},
+}
+
+// lookupPort43 asks IANA which server holds the TLD, queries it and follows
+// one referral to the registrar's server, which thin registries such as
+// .com leave the details to. Fields from the registrar win.
+func lookupPort43(ctx context.Context, domain string) (*WhoisInfo, error) {
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+	iana, err := queryWhois(ctx, whoisIANA, tld)
+	if err != nil {
+		return nil, err
+	}
+	server := whoisValue(parseWhoisText(iana), "refer", "whois")
+	if server == "" {
+		return nil, fmt.Errorf("no WHOIS server for .%s", tld)
+	}
+	text, err := queryWhois(ctx, server, domain)
+	if err != nil {
+		return nil, err
+	}
+	fields := parseWhoisText(text)
+	if ref := whoisValue(fields, "registrar whois server"); ref != "" && !strings.EqualFold(ref, server) {
+		ref = strings.TrimPrefix(strings.TrimPrefix(ref, "whois://"), "rwhois://")
+		if more, err := queryWhois(ctx, ref, domain); err == nil {
+			for k, v := range parseWhoisText(more) {
+				fields[k] = v
+			}
+		}
+	}
+	info := &WhoisInfo{
+		Domain:        domain,
+		Via:           "whois",
+		Registrar:     whoisValue(fields, whoisFields["registrar"]...),
+		Created:       whoisDate(whoisValue(fields, whoisFields["created"]...)),
+		Expires:       whoisDate(whoisValue(fields, whoisFields["expires"]...)),
+		RegistrantOrg: whoisValue(fields, whoisFields["org"]...),
+	}
+	if info.Registrar == "" && info.Created == "" && info.Expires == "" {
+		return nil, fmt.Errorf("%s: no registration data in the answer", server)
+	}
+	return info, nil
+}
+
+// queryWhois sends query to server on port 43 and returns the answer. The
+// connection is direct: -proxy only covers HTTP.
+func queryWhois(ctx context.Context, server, query string) (string, error) {
+	if err := nativeLimiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	conn, err := egressDialer("tcp", 10*time.Second).DialContext(ctx, "tcp", net.JoinHostPort(server, "43"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+	if _, err := fmt.Fprintf(conn, "%s\r\n", query); err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(io.LimitReader(conn, 1<<20))
+	return string(b), err
+}
+
+// parseWhoisText reads "Key: value" lines into lower-cased keys, keeping
+// the first value of each. Comments and notices are skipped.
+func parseWhoisText(text string) map[string]string {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '%' || line[0] == '#' || strings.HasPrefix(line, ">>>") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		// Some registries pad keys with dots: "Registrar.......: X"
+		k, v = strings.ToLower(strings.TrimRight(k, ". ")), strings.TrimSpace(v)
+		if !ok || v == "" {
+			continue
+		}
+		if _, seen := fields[k]; !seen {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// whoisValue returns the value of the first of keys that is present
+func whoisValue(fields map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v := fields[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// whoisDateLayouts are the date formats seen in WHOIS answers
+var whoisDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02",
+	"2006.01.02",
+	"2006/01/02",
+	"02-Jan-2006",
+	"02.01.2006",
+	"January 2 2006",
+	"Mon Jan 2 15:04:05 MST 2006",
+}
+
+// whoisDate normalizes a registration date to RFC 3339 in UTC, or returns
+// "" when it is in none of the known formats
+func whoisDate(s string) string {
+	// "2030-01-01 (YYYY-MM-DD)" and similar annotations
+	if i := strings.Index(s, " ("); i > 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	for _, layout := range whoisDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// whoisResult is the synthetic record -whois-record emits for the root
+// domain, with a finding when it expires within -whois-expiry-warn
+func whoisResult(target string, info *WhoisInfo) Result {
+	findings := []Finding{}
+	if t, err := time.Parse(time.RFC3339, info.Expires); err == nil && info.ExpiringSoon {
+		id, severity, summary := "domain-expiring", "medium", fmt.Sprintf("%s expires on %s", info.Domain, t.Format("2006-01-02"))
+		if time.Now().After(t) {
+			id, severity, summary = "domain-expired", "high", fmt.Sprintf("%s expired on %s", info.Domain, t.Format("2006-01-02"))
+		}
+		findings = append(findings, newFinding("whois", id, severity, confidenceConfirmed, summary, nil))
+	}
+	return Result{
+		RunID:           runID,
+		RootDomain:      target,
+		EngineVersion:   version,
+		SchemaVersion:   schemaVersion,
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Subdomain:       info.Domain,
+		TechStack:       []string{},
+		Vulnerabilities: findings,
+		Source:          "whois",
+		Whois:           info,
+	}
+}
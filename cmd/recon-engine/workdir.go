@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// workdir holds the run's artifacts that no flag puts elsewhere: the nmap
+// report, summary.json and events.ndjson. Each run gets its own, named after
+// the run ID, so concurrent runs never overwrite each other's files.
+var workdir string
+
+// configureWorkdir picks the artifact directory, -workdir or
+// ~/.recon-engine/runs/<run-id>. Called once the run ID is known; the
+// directory is only created by createWorkdir, after -dry-run.
+func configureWorkdir() error {
+	workdir = workdirFlag
+	if workdir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("no home directory for the default, set -workdir: %w", err)
+		}
+		workdir = filepath.Join(home, ".recon-engine", "runs", runID)
+	}
+	if nmapOutput == "" {
+		nmapOutput = filepath.Join(workdir, "nmap-scan.txt")
+	}
+	if summaryFile == "" {
+		summaryFile = filepath.Join(workdir, "summary.json")
+	}
+	summary.Workdir = workdir
+	return nil
+}
+
+// createWorkdir creates the directory and opens events.ndjson in it unless
+// -events or -events-file chose where tool failures go
+func createWorkdir() error {
+	if err := os.MkdirAll(workdir, 0o755); err != nil {
+		return err
+	}
+	if eventOut == nil {
+		eventsFile = filepath.Join(workdir, "events.ndjson")
+		return configureEvents()
+	}
+	return nil
+}
+
+// removeWorkdir deletes the artifacts of a run that completed, with
+// -keep-artifacts=false. Tools still writing into it are stopped first.
+func removeWorkdir() {
+	if keepArtifacts || workdir == "" {
+		return
+	}
+	stopChildren()
+	if err := os.RemoveAll(workdir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing -workdir: %v\n", err)
+	}
+}